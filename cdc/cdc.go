@@ -0,0 +1,213 @@
+// Package cdc streams row changes out of PostgreSQL with logical
+// replication: the server decodes its write-ahead log with the built-in
+// pgoutput plugin, and Stream turns what it sends into Change values.
+//
+// It speaks the streaming replication protocol directly on a pgconn
+// connection opened with replication=database, covering what a change
+// feed needs: creating and dropping slots, START_REPLICATION, the
+// pgoutput messages of protocol version 1, and standby status updates.
+// The server must run with wal_level=logical, the role needs the
+// REPLICATION attribute, and a publication must name the tables to stream.
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Op is the kind of a Change.
+type Op string
+
+// The operations a Change reports.
+const (
+	Insert   Op = "INSERT"
+	Update   Op = "UPDATE"
+	Delete   Op = "DELETE"
+	Truncate Op = "TRUNCATE"
+)
+
+// Change is one row change, or a TRUNCATE of a whole table.
+type Change struct {
+	Op     Op
+	Schema string
+	Table  string
+	// LSN is where the change's transaction commits; every change of a
+	// transaction shares it, as they do CommitTime and XID.
+	LSN        LSN
+	CommitTime time.Time
+	XID        uint32
+	// New is the row after an INSERT or UPDATE. Old is the replica
+	// identity of the row before an UPDATE that changed it, and before
+	// every DELETE: the key columns, or the whole row with REPLICA
+	// IDENTITY FULL. Either is nil when not sent.
+	New, Old *Row
+}
+
+// StandbyInterval is how often Stream confirms its position to the server
+// while no keepalive asks for it sooner.
+const StandbyInterval = 10 * time.Second
+
+// Stream starts replication from slot on conn, a connection opened with
+// the replication=database parameter, and calls fn with each change of
+// the tables in publication, in commit order, until ctx is done or fn
+// returns an error, which Stream then returns.
+//
+// The position confirmed to the server only moves past a transaction once
+// fn has returned for all of its changes, so the slot keeps everything not
+// yet handled. Delivery is at least once: after a restart, the changes of
+// a transaction that was interrupted part way are sent again from its
+// start.
+func Stream(ctx context.Context, conn *pgconn.PgConn, slot, publication string, fn func(Change) error) error {
+	if err := startReplication(ctx, conn, slot, publication); err != nil {
+		return fmt.Errorf("starting replication: %w", err)
+	}
+	s := &stream{conn: conn, relations: map[uint32]relationMsg{}, types: pgtype.NewMap()}
+	err := s.run(ctx, fn)
+	// Confirm what was handled, so a restart does not repeat it
+	if s.confirmed > 0 && !conn.IsClosed() {
+		sendStandbyStatus(conn, s.confirmed)
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// stream is the state of one Stream call.
+type stream struct {
+	conn      *pgconn.PgConn
+	relations map[uint32]relationMsg
+	types     *pgtype.Map
+	begin     beginMsg
+	inTx      bool
+	confirmed LSN
+}
+
+func (s *stream) run(ctx context.Context, fn func(Change) error) error {
+	nextStatus := time.Now().Add(StandbyInterval)
+	for {
+		if time.Now().After(nextStatus) {
+			if err := sendStandbyStatus(s.conn, s.confirmed); err != nil {
+				return err
+			}
+			nextStatus = time.Now().Add(StandbyInterval)
+		}
+		recvCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		msg, err := s.conn.ReceiveMessage(recvCtx)
+		cancel()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			return ctx.Err()
+		case pgconn.Timeout(err):
+			continue // time for a status update
+		case err != nil:
+			return err
+		}
+
+		var data []byte
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			data = msg.Data
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		default:
+			continue
+		}
+		m, err := parseCopyData(data)
+		if err != nil {
+			return err
+		}
+		switch m := m.(type) {
+		case keepalive:
+			// Outside a transaction nothing is pending, so the WAL up to the
+			// server's end (other tables' changes, say) can be let go
+			if !s.inTx && m.walEnd > s.confirmed {
+				s.confirmed = m.walEnd
+			}
+			if m.replyRequired {
+				nextStatus = time.Now()
+			}
+		case xLogData:
+			if err := s.handle(m.data, fn); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handle acts on one pgoutput message.
+func (s *stream) handle(data []byte, fn func(Change) error) error {
+	msg, err := parseMessage(data)
+	if err != nil {
+		return err
+	}
+	switch msg := msg.(type) {
+	case beginMsg:
+		s.begin, s.inTx = msg, true
+	case commitMsg:
+		s.inTx = false
+		s.confirmed = msg.endLSN
+	case relationMsg:
+		// Sent before the first change to a table, and again after its
+		// columns change
+		s.relations[msg.id] = msg
+	case changeMsg:
+		rel, ok := s.relations[msg.relation]
+		if !ok {
+			return fmt.Errorf("change to unknown relation %d", msg.relation)
+		}
+		c := s.change(msg.op, rel)
+		c.New = s.row(rel, msg.new)
+		c.Old = s.row(rel, msg.old)
+		return fn(c)
+	case truncateMsg:
+		for _, id := range msg.relations {
+			rel, ok := s.relations[id]
+			if !ok {
+				return fmt.Errorf("truncate of unknown relation %d", id)
+			}
+			if err := fn(s.change(Truncate, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *stream) change(op Op, rel relationMsg) Change {
+	return Change{
+		Op:         op,
+		Schema:     rel.schema,
+		Table:      rel.table,
+		LSN:        s.begin.finalLSN,
+		CommitTime: s.begin.commitTime,
+		XID:        s.begin.xid,
+	}
+}
+
+// row pairs the values of a tuple with the columns of rel; nil when the
+// message carried no such tuple.
+func (s *stream) row(rel relationMsg, tuple []tupleValue) *Row {
+	if tuple == nil {
+		return nil
+	}
+	if len(tuple) > len(rel.columns) {
+		tuple = tuple[:len(rel.columns)]
+	}
+	values := make([]Value, len(tuple))
+	for i, t := range tuple {
+		values[i] = Value{
+			Name:      rel.columns[i].name,
+			OID:       rel.columns[i].oid,
+			Text:      t.data,
+			Null:      t.kind == 'n',
+			Unchanged: t.kind == 'u',
+		}
+	}
+	return &Row{Values: values, types: s.types}
+}
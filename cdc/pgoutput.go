@@ -0,0 +1,174 @@
+package cdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// The pgoutput messages of protocol version 1 that Stream acts on. See
+// "Logical Replication Message Formats" in the PostgreSQL documentation.
+type (
+	beginMsg struct {
+		finalLSN   LSN
+		commitTime time.Time
+		xid        uint32
+	}
+	commitMsg struct {
+		endLSN LSN
+	}
+	relationMsg struct {
+		id      uint32
+		schema  string
+		table   string
+		columns []column
+	}
+	// changeMsg is an insert, update or delete. old is set for an update
+	// that changed the key (or any update with REPLICA IDENTITY FULL) and
+	// for every delete.
+	changeMsg struct {
+		op       Op
+		relation uint32
+		old, new []tupleValue
+	}
+	truncateMsg struct {
+		relations []uint32
+	}
+)
+
+// column is a column of a relation message.
+type column struct {
+	name string
+	oid  uint32
+}
+
+// tupleValue is one column of a tuple: its text form, or null, or TOAST
+// data the change did not touch, which pgoutput does not resend.
+type tupleValue struct {
+	kind byte // 'n' null, 'u' unchanged TOAST, 't' text
+	data []byte
+}
+
+// decoder reads the fields of one pgoutput message in order. The first
+// read past the end sets err, and later reads return zero values, so a
+// message is checked once at the end.
+type decoder struct {
+	buf  []byte
+	err  error
+	zero [8]byte
+}
+
+// take returns the next n bytes. Past the end it returns zeros, which
+// the fixed-size reads need, or nil for n above 8.
+func (d *decoder) take(n int) []byte {
+	if d.err != nil || len(d.buf) < n {
+		d.err = fmt.Errorf("truncated pgoutput message")
+		if n > len(d.zero) {
+			return nil
+		}
+		return d.zero[:n]
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte       { return d.take(1)[0] }
+func (d *decoder) uint16() uint16   { return binary.BigEndian.Uint16(d.take(2)) }
+func (d *decoder) uint32() uint32   { return binary.BigEndian.Uint32(d.take(4)) }
+func (d *decoder) uint64() uint64   { return binary.BigEndian.Uint64(d.take(8)) }
+func (d *decoder) time() time.Time  { return pgTime(int64(d.uint64())) }
+func (d *decoder) lsn() LSN         { return LSN(d.uint64()) }
+func (d *decoder) relation() uint32 { return d.uint32() }
+
+func (d *decoder) string() string {
+	if d.err != nil {
+		return ""
+	}
+	i := bytes.IndexByte(d.buf, 0)
+	if i < 0 {
+		d.err = fmt.Errorf("unterminated string in pgoutput message")
+		return ""
+	}
+	s := string(d.buf[:i])
+	d.buf = d.buf[i+1:]
+	return s
+}
+
+func (d *decoder) tuple() []tupleValue {
+	values := make([]tupleValue, d.uint16())
+	for i := range values {
+		values[i].kind = d.byte()
+		if values[i].kind == 't' {
+			// Copied: the message buffer is reused for the next one
+			values[i].data = bytes.Clone(d.take(int(d.uint32())))
+		}
+	}
+	return values
+}
+
+// parseMessage decodes a pgoutput message. Message types Stream has no use
+// for (origin, type, logical decoding messages) decode to nil.
+func parseMessage(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty pgoutput message")
+	}
+	d := &decoder{buf: data[1:]}
+	var msg any
+	switch data[0] {
+	case 'B':
+		msg = beginMsg{finalLSN: d.lsn(), commitTime: d.time(), xid: d.uint32()}
+	case 'C':
+		d.byte() // flags, unused
+		d.lsn()  // commit LSN; the end LSN is what to confirm
+		msg = commitMsg{endLSN: d.lsn()}
+	case 'R':
+		r := relationMsg{id: d.relation(), schema: d.string(), table: d.string()}
+		d.byte() // replica identity setting
+		r.columns = make([]column, d.uint16())
+		for i := range r.columns {
+			d.byte() // flags: part of the key
+			r.columns[i] = column{name: d.string(), oid: d.uint32()}
+			d.uint32() // type modifier
+		}
+		msg = r
+	case 'I':
+		c := changeMsg{op: Insert, relation: d.relation()}
+		d.byte() // 'N'
+		c.new = d.tuple()
+		msg = c
+	case 'U':
+		c := changeMsg{op: Update, relation: d.relation()}
+		if kind := d.byte(); kind == 'K' || kind == 'O' {
+			c.old = d.tuple()
+			d.byte() // 'N'
+		}
+		c.new = d.tuple()
+		msg = c
+	case 'D':
+		c := changeMsg{op: Delete, relation: d.relation()}
+		d.byte() // 'K' or 'O'
+		c.old = d.tuple()
+		msg = c
+	case 'T':
+		n := d.uint32()
+		d.byte() // options: CASCADE, RESTART IDENTITY
+		if uint64(n)*4 > uint64(len(d.buf)) {
+			return nil, fmt.Errorf("truncated pgoutput message 'T'")
+		}
+		t := truncateMsg{relations: make([]uint32, n)}
+		for i := range t.relations {
+			t.relations[i] = d.relation()
+		}
+		msg = t
+	case 'O', 'Y', 'M':
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown pgoutput message %q", data[0])
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("pgoutput message %q: %w", data[0], d.err)
+	}
+	return msg, nil
+}
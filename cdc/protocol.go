@@ -0,0 +1,166 @@
+package cdc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// LSN is a position in the write-ahead log.
+type LSN uint64
+
+// ParseLSN parses the X/Y form PostgreSQL prints, e.g. "0/16B3748".
+func ParseLSN(s string) (LSN, error) {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(s, "%X/%X", &hi, &lo); err != nil {
+		return 0, fmt.Errorf("invalid LSN %q", s)
+	}
+	return LSN(uint64(hi)<<32 | uint64(lo)), nil
+}
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+// MarshalText lets LSNs appear in JSON as PostgreSQL prints them.
+func (l LSN) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// namePattern is what slot and publication names are limited to here. It
+// is PostgreSQL's rule for slot names, and it lets both be spliced into
+// replication commands, which take no parameters, without quoting.
+var namePattern = regexp.MustCompile(`^[a-z0-9_]{1,63}$`)
+
+// CheckName reports whether name may be used as a slot or publication name.
+func CheckName(kind, name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("%s name %q must be 1 to 63 lower-case letters, digits and underscores", kind, name)
+	}
+	return nil
+}
+
+// CreateSlot creates the logical replication slot name with the pgoutput
+// plugin on conn, a replication connection. A temporary slot is dropped
+// when conn closes; a permanent one stays, and keeps the WAL it has not
+// been confirmed past, until DropSlot.
+func CreateSlot(ctx context.Context, conn *pgconn.PgConn, name string, temporary bool) (LSN, error) {
+	if err := CheckName("slot", name); err != nil {
+		return 0, err
+	}
+	sql := "CREATE_REPLICATION_SLOT " + name
+	if temporary {
+		sql += " TEMPORARY"
+	}
+	results, err := conn.Exec(ctx, sql+" LOGICAL pgoutput NOEXPORT_SNAPSHOT").ReadAll()
+	if err != nil {
+		return 0, err
+	}
+	// slot_name, consistent_point, snapshot_name, output_plugin
+	if len(results) != 1 || len(results[0].Rows) != 1 || len(results[0].Rows[0]) < 2 {
+		return 0, fmt.Errorf("unexpected reply to CREATE_REPLICATION_SLOT")
+	}
+	return ParseLSN(string(results[0].Rows[0][1]))
+}
+
+// DropSlot drops the replication slot name, releasing the WAL it retains.
+func DropSlot(ctx context.Context, conn *pgconn.PgConn, name string) error {
+	if err := CheckName("slot", name); err != nil {
+		return err
+	}
+	_, err := conn.Exec(ctx, "DROP_REPLICATION_SLOT "+name).ReadAll()
+	return err
+}
+
+// startReplication switches conn into streaming: the server then sends the
+// changes in publication from the slot's confirmed position onwards,
+// decoded by pgoutput, until the connection closes.
+func startReplication(ctx context.Context, conn *pgconn.PgConn, slot, publication string) error {
+	if err := CheckName("slot", slot); err != nil {
+		return err
+	}
+	if err := CheckName("publication", publication); err != nil {
+		return err
+	}
+	// 0/0 starts where the slot's consumer last confirmed
+	sql := fmt.Sprintf("START_REPLICATION SLOT %s LOGICAL 0/0 (proto_version '1', publication_names '%s')", slot, publication)
+	conn.Frontend().Send(&pgproto3.Query{String: sql})
+	if err := conn.Frontend().Flush(); err != nil {
+		return err
+	}
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.NoticeResponse, *pgproto3.ParameterStatus:
+		default:
+			return fmt.Errorf("unexpected %T in reply to START_REPLICATION", msg)
+		}
+	}
+}
+
+// pgEpoch is where the timestamps of the replication protocol count from.
+var pgEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func pgTime(micros int64) time.Time {
+	return pgEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+// sendStandbyStatus tells the server that everything up to lsn has been
+// processed, so the slot may release the WAL before it.
+func sendStandbyStatus(conn *pgconn.PgConn, lsn LSN) error {
+	buf := make([]byte, 0, 34)
+	buf = append(buf, 'r')
+	buf = binary.BigEndian.AppendUint64(buf, uint64(lsn)) // written
+	buf = binary.BigEndian.AppendUint64(buf, uint64(lsn)) // flushed
+	buf = binary.BigEndian.AppendUint64(buf, uint64(lsn)) // applied
+	buf = binary.BigEndian.AppendUint64(buf, uint64(time.Since(pgEpoch).Microseconds()))
+	buf = append(buf, 0) // no reply requested
+	conn.Frontend().Send(&pgproto3.CopyData{Data: buf})
+	return conn.Frontend().Flush()
+}
+
+// xLogData is a 'w' message: a piece of WAL, here one pgoutput message.
+type xLogData struct {
+	start LSN
+	data  []byte
+}
+
+// keepalive is a 'k' message: the server's WAL end, and whether it wants
+// a standby status update right away.
+type keepalive struct {
+	walEnd        LSN
+	replyRequired bool
+}
+
+// parseCopyData splits a CopyData message of the replication stream into
+// an xLogData or a keepalive.
+func parseCopyData(data []byte) (any, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty replication message")
+	}
+	switch data[0] {
+	case 'w':
+		if len(data) < 25 {
+			return nil, fmt.Errorf("short XLogData message")
+		}
+		return xLogData{start: LSN(binary.BigEndian.Uint64(data[1:])), data: data[25:]}, nil
+	case 'k':
+		if len(data) < 18 {
+			return nil, fmt.Errorf("short keepalive message")
+		}
+		return keepalive{walEnd: LSN(binary.BigEndian.Uint64(data[1:])), replyRequired: data[17] == 1}, nil
+	}
+	return nil, fmt.Errorf("unknown replication message %q", data[0])
+}
@@ -0,0 +1,85 @@
+package cdc
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Value is one column of a changed row, in the text format pgoutput sends.
+type Value struct {
+	Name string
+	OID  uint32 // type of the column
+	Text []byte
+	Null bool
+	// Unchanged marks a TOASTed value that an update did not touch:
+	// pgoutput does not resend it, so it is unknown here.
+	Unchanged bool
+}
+
+// Row is a changed row, or the part of one that pgoutput sent: an old row
+// holds only the replica identity columns (the primary key, by default).
+type Row struct {
+	Values []Value
+	types  *pgtype.Map
+}
+
+// Map decodes the row into Go values by column type, e.g. int32 for an
+// integer column and time.Time for a timestamp. Unchanged TOAST values are
+// left out; values of types pgx does not know stay strings.
+func (r Row) Map() (map[string]any, error) {
+	m := make(map[string]any, len(r.Values))
+	for _, v := range r.Values {
+		switch {
+		case v.Unchanged:
+			continue
+		case v.Null:
+			m[v.Name] = nil
+			continue
+		}
+		t, ok := r.types.TypeForOID(v.OID)
+		if !ok {
+			m[v.Name] = string(v.Text)
+			continue
+		}
+		value, err := t.Codec.DecodeValue(r.types, v.OID, pgtype.TextFormatCode, v.Text)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", v.Name, err)
+		}
+		m[v.Name] = value
+	}
+	return m, nil
+}
+
+// Scan decodes the row into the struct dst points to, matching columns to
+// fields by their db tag as pgx.RowToStructByName does. Columns without a
+// field, and fields without a column in the row, are skipped, so an old
+// row that holds only the key fills in just the key.
+func (r Row) Scan(dst any) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cdc: Scan needs a pointer to a struct, not %T", dst)
+	}
+	v = v.Elem()
+	fields := map[string]int{}
+	for i := range v.NumField() {
+		if tag := v.Type().Field(i).Tag.Get("db"); tag != "" && tag != "-" {
+			fields[tag] = i
+		}
+	}
+	for _, col := range r.Values {
+		i, ok := fields[col.Name]
+		if !ok || col.Unchanged {
+			continue
+		}
+		var src []byte
+		if !col.Null {
+			src = col.Text
+		}
+		if err := r.types.Scan(col.OID, pgtype.TextFormatCode, src, v.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("column %s: %w", col.Name, err)
+		}
+	}
+	return nil
+}
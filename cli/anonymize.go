@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// newAnonymizeCmd builds the anonymize command.
+func newAnonymizeCmd(a *app) *cobra.Command {
+	var yes bool
+	var seed uint64
+	cmd := &cobra.Command{
+		Use:   "anonymize",
+		Short: "Replace every username and email with a fake one, e.g. in a copy of production",
+		Long: `Rewrite the username and email of every user with made-up ones such as
+grace.okafor.42 and grace.okafor.42@example.org, so that a restored
+production snapshot can be used for development without its personal data.
+
+The fake values are derived from each user's id and --seed: running the
+command twice, or on two copies of the same data, gives the same values.
+They are unique because the id is part of the username, and the ids, and
+with them the posts and every other reference to a user, stay as they
+are. The copies of usernames and emails in users_audit and users_outbox
+are rewritten to the same values, those of purged users included. Profiles,
+posts, events and password hashes are left alone.
+
+The users table's triggers are disabled while it is rewritten, so the
+rewrite is neither audited nor notified nor queued, which needs the role
+that owns the table. Everything happens in one transaction. Every
+username and email is lost, so --yes is required, and the command refuses
+to run when APP_ENV is prod or production. Needs PostgreSQL or
+CockroachDB; CockroachDB has neither the triggers nor users_audit and
+users_outbox.`,
+		Example: `  go run ./cmd/go-postgres backup restore --in prod.zip && go run ./cmd/go-postgres anonymize --yes`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runAnonymize(cmd.Context(), yes, seed)
+		},
+	}
+	cmd.Flags().BoolVar(&yes, "yes", false, "confirm that every username and email may be replaced")
+	cmd.Flags().Uint64Var(&seed, "seed", 0, "seed of the fake values; another seed gives other names")
+	return cmd
+}
+
+// anonymizedUser returns the fake username and email of the user with the
+// given id. The faker is seeded from seed and the id, so the values depend
+// on nothing else; the id, without the dashes of a UUID, keeps the
+// username unique.
+func anonymizedUser(seed uint64, id string) (username, email string) {
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	f := newFaker(seed ^ h.Sum64())
+	username = f.FirstName() + "." + f.LastName() + "." + strings.ReplaceAll(id, "-", "")
+	return username, username + "@" + f.Domain()
+}
+
+// runAnonymize implements the anonymize command.
+func (a *app) runAnonymize(ctx context.Context, yes bool, seed uint64) error {
+	if env := a.cfg().App.Env; protectedEnvs[strings.ToLower(env)] {
+		return fmt.Errorf("anonymize refuses to run with APP_ENV=%s", env)
+	}
+	if !yes {
+		return fmt.Errorf("anonymize replaces every username and email in schema %q; run it again with --yes", a.dbSchema())
+	}
+	if a.usesSQLDB() {
+		return fmt.Errorf("anonymize needs PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	if t := a.cfg().Database.RowTenant; t != "" {
+		return fmt.Errorf("anonymize would only see the users of RLS_TENANT=%s and leave the others as they are; unset it to anonymize", t)
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	audit := pgx.Identifier{a.dbSchema(), "users_audit"}.Sanitize()
+	outbox := pgx.Identifier{a.dbSchema(), "users_outbox"}.Sanitize()
+	// The audit trail and the outbox hold the ids of users purged since,
+	// whose usernames and emails are just as personal
+	idsSQL := "SELECT id::text FROM " + a.usersTable()
+	if !a.isCockroach() {
+		idsSQL += " UNION SELECT user_id FROM " + audit + " UNION SELECT payload->>'id' FROM " + outbox + " WHERE payload ? 'id'"
+	}
+
+	return pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, idsSQL)
+		if err != nil {
+			return err
+		}
+		ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, `CREATE TEMPORARY TABLE anonymized (id TEXT PRIMARY KEY, username TEXT NOT NULL, email TEXT NOT NULL) ON COMMIT DROP`); err != nil {
+			return err
+		}
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"anonymized"}, []string{"id", "username", "email"},
+			pgx.CopyFromSlice(len(ids), func(i int) ([]any, error) {
+				username, email := anonymizedUser(seed, ids[i])
+				return []any{ids[i], username, email}, nil
+			}))
+		if err != nil {
+			return err
+		}
+
+		if !a.isCockroach() {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+a.usersTable()+" DISABLE TRIGGER USER"); err != nil {
+				return fmt.Errorf("disabling the triggers of users: %w", err)
+			}
+		}
+		tag, err := tx.Exec(ctx, `UPDATE `+a.usersTable()+` u SET username = a.username, email = a.email
+			FROM anonymized a WHERE u.id::text = a.id`)
+		if err != nil {
+			return fmt.Errorf("rewriting users: %w", err)
+		}
+		attrs := []any{"users", tag.RowsAffected(), "seed", seed}
+		if !a.isCockroach() {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+a.usersTable()+" ENABLE TRIGGER USER"); err != nil {
+				return fmt.Errorf("enabling the triggers of users: %w", err)
+			}
+			tag, err := tx.Exec(ctx, `UPDATE `+audit+` x SET
+				old_row = x.old_row || jsonb_build_object('username', a.username, 'email', a.email),
+				new_row = x.new_row || jsonb_build_object('username', a.username, 'email', a.email)
+				FROM anonymized a WHERE x.user_id = a.id`)
+			if err != nil {
+				return fmt.Errorf("rewriting users_audit: %w", err)
+			}
+			attrs = append(attrs, "audit_entries", tag.RowsAffected())
+			tag, err = tx.Exec(ctx, `UPDATE `+outbox+` o SET
+				payload = o.payload || jsonb_build_object('username', a.username, 'email', a.email)
+				FROM anonymized a WHERE o.payload->>'id' = a.id`)
+			if err != nil {
+				return fmt.Errorf("rewriting users_outbox: %w", err)
+			}
+			attrs = append(attrs, "outbox_events", tag.RowsAffected())
+		}
+		slog.Info("users anonymized", attrs...)
+		return nil
+	})
+}
@@ -0,0 +1,286 @@
+// Package cli is the go-postgres command-line tool, a PostgreSQL database
+// connection example: the cobra command tree and everything behind it.
+// cmd/go-postgres runs it with Run.
+//
+// This application demonstrates:
+// - Connecting to a PostgreSQL database using pgx
+// - Loading configuration from environment variables using Viper
+// - Creating tables with schema constraints through embedded, versioned migrations
+// - Inserting data with duplicate-key conflict handling
+// - Diagnosing connection problems with the doctor command
+// - A small command-line tool built with cobra (ping, migrate, seed, insert, list, ...)
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/time/rate"
+	//use godotenv to load .env file
+	// "github.com/joho/godotenv"
+	// "os"
+)
+
+// app is what the commands share. run builds one from the configuration
+// loaded at startup and hands it to each command constructor, so a test
+// can build an app over a configuration and pools of its own. What stays
+// process-wide is what the process has one of: the log level (logLevel),
+// the Prometheus registry (metricsRegistry) and the tracer provider.
+type app struct {
+	// settings holds the settings (see the config package); read them
+	// with cfg. A reload of serve or daemon stores new ones while requests
+	// and jobs are reading them, so they are never changed in place.
+	settings atomic.Pointer[config.Config]
+	// openPool opens a connection pool on the configured database; the
+	// caller closes it. It is dialPool unless a test sets another.
+	openPool func(ctx context.Context) (*pgxpool.Pool, error)
+	// dbBreaker, cacheClient and writeLimiter are shared by every
+	// repository the process creates, so one outage is detected once,
+	// cached users are seen by every command and concurrent workers are
+	// held to MAX_WRITES_PER_SEC together rather than each. Each is made
+	// on first use; the breaker and cache client are nil when their
+	// setting is off.
+	dbBreaker    func() *circuitBreaker
+	cacheClient  func() *redis.Client
+	writeLimiter func() *rate.Limiter
+	// creds holds the credentials read from the secrets manager, shared by
+	// every connection the app opens, including the ones its pools open
+	// long after startup.
+	creds *secretCredentials
+	// hooks are called after the writes of the pgx users repositories;
+	// see newUserHooks.
+	hooks *users.Hooks
+}
+
+// newApp returns the app of cfg, opening pools with dialPool.
+func newApp(cfg *config.Config) *app {
+	a := &app{creds: &secretCredentials{}}
+	a.settings.Store(cfg)
+	a.openPool = a.dialPool
+	a.dbBreaker = sync.OnceValue(a.newDBBreaker)
+	a.cacheClient = sync.OnceValue(a.newCacheClient)
+	a.writeLimiter = sync.OnceValue(a.newWriteLimiter)
+	a.hooks = a.newUserHooks()
+	return a
+}
+
+// cfg returns the current settings. The caller must not change them; see
+// setCfg.
+func (a *app) cfg() *config.Config {
+	return a.settings.Load()
+}
+
+// setCfg makes the settings changed by update current, on a copy of the
+// ones in place.
+func (a *app) setCfg(update func(cfg *config.Config)) {
+	next := *a.cfg()
+	update(&next)
+	a.settings.Store(&next)
+}
+
+// Run wires the program together and runs the command args select: it
+// loads the configuration, builds the logger from it and hands both to the
+// command tree, returning the exit status. Without a command it runs the
+// quickstart (which accepts --generate N); otherwise the first argument
+// selects a subcommand (see newRootCmd and --help for the full list).
+func Run(args []string) int {
+
+	// err := godotenv.Load(".env")
+	// if err != nil {
+	// 	log.Fatal("Error loading .env file")
+	// }
+
+	// connStr := os.Getenv("CONN_STR")
+
+	// SIGINT and SIGTERM cancel ctx, which every command passes to its
+	// queries, so they are cancelled and the pool is closed on the way out.
+	// Once ctx is done the handler is removed, so a second signal kills the
+	// process immediately. This is the only root context: everything else
+	// derives from ctx.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+
+	config.ParseFlags(args)
+	cfg, configErr := config.Load(ctx)
+	a := newApp(cfg)
+	// Also routes the standard log package (used by dependencies) through slog
+	logger := newLogger(os.Stderr, cfg.App)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := setupTracing(ctx, cfg.App)
+	if err != nil {
+		slog.Error("tracing disabled", "err", err)
+		shutdownTracing = func(context.Context) error { return nil }
+	}
+	// One span covers the whole command; the root command renames it once
+	// cobra has picked the subcommand
+	ctx, span := tracer.Start(ctx, "go-postgres")
+
+	// Commands return their errors instead of exiting, so deferred cleanup
+	// runs and this is the only place the process exits with a failure
+	root := newRootCmd(a, configErr)
+	root.SetArgs(args)
+	err = root.ExecuteContext(ctx)
+	interrupted := ctx.Err() != nil // checked before stop, which also cancels ctx
+	stop()
+	endSpan(span, err)
+	flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Warn("flushing traces failed", "err", err)
+	}
+	cancel()
+	if err != nil {
+		if interrupted {
+			logger.Warn("interrupted", "err", err)
+			return 130
+		}
+		logger.Error("command failed", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// quickstartOptions holds the flags accepted by the quickstart (and seed).
+type quickstartOptions struct {
+	Generate int    // insert this many generated users instead of the sample data
+	Source   string // provenance label overriding the default
+}
+
+// runQuickstart performs the following steps:
+// 1. Connects to PostgreSQL database
+// 2. Creates a users table if it doesn't exist
+// 3. Inserts sample (or --generate'd fake) user records with conflict handling
+// 4. Displays results and configuration values
+func (a *app) runQuickstart(ctx context.Context, opts quickstartOptions) error {
+	if a.usesSQLDB() {
+		return a.runSQLQuickstart(ctx, opts)
+	}
+
+	// Retrieve the connection string from configuration
+	// and open a pgxpool connection pool to PostgreSQL
+	// ctx is cancelled on SIGINT/SIGTERM, which aborts any query in flight
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	// Ensure the pool is properly closed when the function returns
+	defer pool.Close()
+
+	// Query the current database time to verify connection
+	var now time.Time
+	err = pool.QueryRow(ctx, "SELECT NOW()").Scan(&now)
+	if err != nil {
+		return fmt.Errorf("QueryRow failed: %w", err)
+	}
+
+	records, batchSource := a.seedInput(opts)
+	if a.cfg().App.DryRun {
+		// On CockroachDB the migrations would commit before the seed
+		// transaction begins rather than inside it
+		fmt.Println("BEGIN;")
+		if err := a.printPendingMigrationSQL(ctx, os.Stdout, pool); err != nil {
+			return err
+		}
+		if err := a.printSeedSQL(os.Stdout, records, batchSource); err != nil {
+			return err
+		}
+		fmt.Println("COMMIT;")
+		return nil
+	}
+
+	// Create or upgrade the table and seed it in one transaction, so a
+	// failure part way leaves the database as it was rather than half set up.
+	// The transaction holds the setup lock, so instances started together
+	// take turns.
+	// CockroachDB does not allow schema changes after writes in the same
+	// transaction, so there the migrations commit on their own first.
+	if a.isCockroach() {
+		if err := a.migrateUp(ctx, pool); err != nil {
+			return fmt.Errorf("migration failed: %w", err)
+		}
+	}
+	var result seedResult
+	step := bootstrapBegin
+	err = a.withTx(ctx, pool, func(tx pgx.Tx) error {
+		if !a.isCockroach() {
+			step = bootstrapLock
+			if err := a.lockSetupTx(ctx, tx); err != nil {
+				return err
+			}
+			step = bootstrapMigrate
+			if err := a.applyMigrations(ctx, tx); err != nil {
+				return err
+			}
+		}
+		slog.Info("users table ready", "schema", a.dbSchema(), "version", schemaVersion)
+
+		step = bootstrapSeed
+		var err error
+		if result, err = a.seedUsers(ctx, a.newUserRepository(tx), records, batchSource); err != nil {
+			return err
+		}
+		if result.Failed > 0 {
+			return fmt.Errorf("%d users failed", result.Failed)
+		}
+		step = bootstrapCommit
+		return nil
+	})
+	if err != nil {
+		a.printSeedIssues(os.Stdout, result.Issues)
+		return bootstrapError(step, err)
+	}
+
+	// Report the current database time and configuration
+	slog.Info("quickstart complete", result.attrs(slog.Time("db_time", a.displayTime(now)), slog.String("developer", a.cfg().App.Developer))...)
+	a.printSeedResult(os.Stdout, result)
+	return nil
+}
+
+// Steps of the quickstart's bootstrap transaction, as bootstrapError names
+// them.
+const (
+	bootstrapBegin   = "starting the transaction"
+	bootstrapLock    = "taking the setup lock"
+	bootstrapMigrate = "creating the schema"
+	bootstrapSeed    = "seeding users"
+	bootstrapCommit  = "committing"
+)
+
+// bootstrapError reports the step at which the bootstrap transaction
+// failed. Everything it did was rolled back.
+func bootstrapError(step string, err error) error {
+	return fmt.Errorf("setup failed while %s; nothing was committed: %w", step, err)
+}
+
+// seedInput returns the users to insert and the provenance label of the batch.
+func (a *app) seedInput(opts quickstartOptions) ([]users.User, string) {
+	// Sample user data to insert
+	// Note: The third user has the same username as the first, which will test conflict handling
+	records := []users.User{
+		{Username: "alice", Email: "alice@example.com"},
+		{Username: "bob", Email: "bob@example.com"},
+		{Username: "alice", Email: "alice@example.com"}, // duplicate username
+	}
+	batchSource := "sample"
+	if opts.Generate > 0 {
+		seed := a.fakeSeed()
+		records = generateUsers(newFaker(seed), opts.Generate)
+		batchSource = fmt.Sprintf("generate:seed=%d", seed)
+	}
+	if opts.Source != "" {
+		batchSource = opts.Source
+	}
+	return records, batchSource
+}
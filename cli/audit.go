@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newAuditCmd builds the audit command group, which reads the change
+// history that migration 0010 records in users_audit.
+func newAuditCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect the history of changes to users",
+	}
+
+	var filter users.AuditFilter
+	var id string
+	var since time.Duration
+	var asJSON bool
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Print recorded inserts, updates and deletes of users, newest first",
+		Example: `  audit list --limit 20
+  audit list --username alice
+  audit list --since 24h --json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if a.isCockroach() {
+				return fmt.Errorf("the audit trail is recorded by a PostgreSQL trigger; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			if id != "" {
+				var err error
+				if filter.UserID, err = users.ParseID(id); err != nil {
+					return err
+				}
+			}
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				entries, err := repo.History(ctx, filter)
+				if err != nil {
+					return fmt.Errorf("reading audit trail: %w", err)
+				}
+				if asJSON {
+					enc := json.NewEncoder(os.Stdout)
+					enc.SetIndent("", "  ")
+					return enc.Encode(entries)
+				}
+				a.printAuditEntries(os.Stdout, entries)
+				return nil
+			})
+		},
+	}
+	list.Flags().StringVar(&id, "id", "", "only changes to the user with this id")
+	list.Flags().StringVar(&filter.Username, "username", "", "only changes to the user with this username, before or after the change")
+	list.Flags().DurationVar(&since, "since", 0, "only changes made within this window (e.g. 24h)")
+	list.Flags().IntVar(&filter.Limit, "limit", 50, "print at most this many entries (0 for all)")
+	list.Flags().BoolVar(&asJSON, "json", false, "print the entries as JSON, with the full rows before and after")
+	list.MarkFlagsMutuallyExclusive("id", "username")
+
+	cmd.AddCommand(list)
+	return cmd
+}
+
+// printAuditEntries writes entries as an aligned table with one line per
+// change.
+func (a *app) printAuditEntries(w io.Writer, entries []users.AuditEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No changes recorded")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CHANGED AT\tOPERATION\tUSER ID\tUSERNAME\tBY\tCHANGES")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.displayTime(e.ChangedAt).Format(displayTimeLayout), e.Operation, e.UserID, e.Username(), e.ChangedBy, auditChanges(e))
+	}
+	tw.Flush()
+}
+
+// auditChanges summarises an entry in one line: the email of an inserted
+// user, or each changed column of an update as old -> new. updated_at
+// changes with every update and is left out, and a password change is
+// named without its hash.
+func auditChanges(e users.AuditEntry) string {
+	switch e.Operation {
+	case "INSERT":
+		return "email=" + auditValue(e.New["email"])
+	case "UPDATE":
+		var parts []string
+		for _, col := range e.Changed {
+			switch col {
+			case "updated_at":
+			case "password_hash":
+				parts = append(parts, "password changed")
+			default:
+				parts = append(parts, fmt.Sprintf("%s: %s -> %s", col, auditValue(e.Old[col]), auditValue(e.New[col])))
+			}
+		}
+		if len(parts) == 0 && slices.Contains(e.Changed, "updated_at") {
+			return "updated_at only"
+		}
+		return strings.Join(parts, "; ")
+	}
+	return ""
+}
+
+// auditValue renders a column value decoded from JSON: strings as they
+// are, anything else (numbers, objects such as the profile, null) as JSON.
+func auditValue(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// backfillOptions describes a batched column backfill.
+type backfillOptions struct {
+	Table      string        // quoted, schema-qualified table with an id key
+	Column     string        // column to populate (unquoted)
+	Expr       string        // SQL expression computing the new value, e.g. lower(email)
+	OnlyNull   bool          // only touch rows where Column IS NULL
+	BatchSize  int           // ids per batch
+	Delay      time.Duration // pause between batches to let the server breathe
+	StartAfter users.ID      // resume point: only ids greater than this are processed; empty to start at the beginning
+}
+
+// backfillProgress reports how far a backfill has got.
+type backfillProgress struct {
+	Batches int
+	Rows    int64
+	LastID  users.ID
+}
+
+// backfill updates Column in batches keyed on id so that no single statement
+// locks or rewrites the whole table. Each batch covers the next BatchSize ids
+// after the previous batch and runs in its own implicit transaction, so an
+// interrupted backfill can resume from the last reported LastID. UUID ids
+// work too: they are not in insertion order, but they are ordered, which is
+// all the batching needs.
+// onBatch, if non-nil, is called after every batch.
+func backfill(ctx context.Context, pool *pgxpool.Pool, opts backfillOptions, onBatch func(backfillProgress)) (backfillProgress, error) {
+	if opts.BatchSize <= 0 {
+		return backfillProgress{}, fmt.Errorf("batch size must be positive, got %d", opts.BatchSize)
+	}
+	column := pgx.Identifier{opts.Column}.Sanitize()
+	// The first batch has no lower bound: there is no smallest UUID to
+	// start after, as 0 is for integer ids
+	after := func(lastID users.ID) string {
+		if lastID == "" {
+			return "TRUE"
+		}
+		return "id > @after"
+	}
+	nextSQL := `SELECT id FROM (SELECT id FROM %s WHERE %s ORDER BY id LIMIT @limit) batch ORDER BY id DESC LIMIT 1`
+	updateSQL := `UPDATE %s SET %s = %s WHERE %s AND id <= @upper`
+	if opts.OnlyNull {
+		updateSQL += fmt.Sprintf(" AND %s IS NULL", column)
+	}
+
+	progress := backfillProgress{LastID: opts.StartAfter}
+	for {
+		args := pgx.NamedArgs{"after": string(progress.LastID), "limit": opts.BatchSize}
+		var upper users.ID
+		err := pool.QueryRow(ctx, fmt.Sprintf(nextSQL, opts.Table, after(progress.LastID)), args).Scan(&upper)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return progress, nil // no rows left
+		}
+		if err != nil {
+			return progress, fmt.Errorf("finding next batch after id %q: %w", progress.LastID, err)
+		}
+		args["upper"] = string(upper)
+		tag, err := pool.Exec(ctx, fmt.Sprintf(updateSQL, opts.Table, column, opts.Expr, after(progress.LastID)), args)
+		if err != nil {
+			return progress, fmt.Errorf("updating ids after %q up to %s: %w", progress.LastID, upper, err)
+		}
+		progress.Batches++
+		progress.Rows += tag.RowsAffected()
+		progress.LastID = upper
+		if onBatch != nil {
+			onBatch(progress)
+		}
+
+		select {
+		case <-ctx.Done():
+			return progress, ctx.Err()
+		case <-time.After(opts.Delay):
+		}
+	}
+}
+
+// newBackfillCmd builds the backfill command for the users table.
+func newBackfillCmd(a *app) *cobra.Command {
+	opts := backfillOptions{Table: a.usersTable()}
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Populate a column of the users table in batches",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runBackfill(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Column, "column", "", "column to populate")
+	cmd.Flags().StringVar(&opts.Expr, "expr", "", "SQL expression for the new value, e.g. lower(email)")
+	cmd.Flags().BoolVar(&opts.OnlyNull, "only-null", true, "only update rows where the column is NULL")
+	cmd.Flags().IntVar(&opts.BatchSize, "batch-size", a.cfg().App.BackfillBatchSize, "rows per batch")
+	cmd.Flags().DurationVar(&opts.Delay, "delay", a.cfg().App.BackfillDelay, "pause between batches")
+	cmd.Flags().StringVar((*string)(&opts.StartAfter), "start-after", "", "resume after this id (the last_id from a previous run)")
+	cmd.MarkFlagRequired("column")
+	cmd.MarkFlagRequired("expr")
+	return cmd
+}
+
+// runBackfill implements the backfill command.
+func (a *app) runBackfill(ctx context.Context, opts backfillOptions) error {
+	if opts.StartAfter != "" {
+		id, err := users.ParseID(string(opts.StartAfter))
+		if err != nil {
+			return fmt.Errorf("invalid --start-after: %w", err)
+		}
+		opts.StartAfter = id
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	progress, err := backfill(ctx, pool, opts, func(p backfillProgress) {
+		slog.Info("backfill batch", "column", opts.Column, "batch", p.Batches, "rows_affected", p.Rows, "last_id", p.LastID)
+	})
+	if err != nil && progress.LastID != "" {
+		return fmt.Errorf("backfill stopped: %w (resume with --start-after %s)", err, progress.LastID)
+	}
+	if err != nil {
+		return fmt.Errorf("backfill stopped: %w", err)
+	}
+	slog.Info("backfill complete", "column", opts.Column, "rows_affected", progress.Rows, "batches", progress.Batches)
+	return nil
+}
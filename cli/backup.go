@@ -0,0 +1,333 @@
+package cli
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// backupFormat identifies archives written by the backup command.
+const backupFormat = "go-sql-quickstart/backup"
+
+// backupManifestName is the archive entry that describes the others.
+const backupManifestName = "manifest.json"
+
+// backupTables lists the tables a backup covers, in the order a restore
+// loads them, so posts follows the users it refers to. Tables missing from
+// the database, such as posts, users_audit and users_outbox on CockroachDB,
+// are left out of the archive. schema_migrations is not
+// archived: the manifest records the schema version instead, and restore
+// migrates the target to it.
+var backupTables = []string{"users", "posts", "users_audit", "users_outbox"}
+
+// backupManifest describes a backup archive. Each table is stored in the
+// entry <name>.copy, in COPY's text format with the listed columns.
+type backupManifest struct {
+	Format        string        `json:"format"`
+	SchemaVersion int           `json:"schema_version"`
+	TakenAt       time.Time     `json:"taken_at"`
+	Tables        []backupTable `json:"tables"`
+}
+
+// backupTable is one table of a backup archive.
+type backupTable struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+	Rows    int64    `json:"rows"`
+}
+
+// entry returns the name of the archive entry holding the table's rows.
+func (t backupTable) entry() string {
+	return t.Name + ".copy"
+}
+
+// copyColumns returns the table in schema and its columns as a COPY
+// target, quoted.
+func (t backupTable) copyColumns(schema string) string {
+	quoted := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return fmt.Sprintf("%s (%s)", pgx.Identifier{schema, t.Name}.Sanitize(), strings.Join(quoted, ", "))
+}
+
+// tableColumns returns the columns of a table in DB_SCHEMA that COPY can
+// load, in table order: generated columns are left out. A table that does
+// not exist has none.
+func (a *app) tableColumns(ctx context.Context, tx pgx.Tx, table string) ([]string, error) {
+	return db.SelectColumn[string](ctx, tx, `SELECT column_name FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2 AND is_generated = 'NEVER'
+		ORDER BY ordinal_position`, a.dbSchema(), table)
+}
+
+// takeBackup streams every table in backupTables into a zip archive written
+// to zw, one COPY TO per table, and returns the manifest it stored last.
+// The COPYs share one read-only REPEATABLE READ transaction, so the tables
+// are archived as of the same moment.
+func (a *app) takeBackup(ctx context.Context, pool *pgxpool.Pool, zw *zip.Writer) (*backupManifest, error) {
+	manifest := &backupManifest{
+		Format:        backupFormat,
+		SchemaVersion: schemaVersion,
+		TakenAt:       time.Now().UTC(),
+	}
+	tx, err := pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// COPY's text format escapes newlines, so each row is one line
+	prog := a.startProgress(ctx, "backup", "rows", 0)
+	defer prog.Finish()
+	for _, name := range backupTables {
+		columns, err := a.tableColumns(ctx, tx, name)
+		if err != nil {
+			return nil, fmt.Errorf("listing columns of %s: %w", name, err)
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		table := backupTable{Name: name, Columns: columns}
+		w, err := zw.Create(table.entry())
+		if err != nil {
+			return nil, err
+		}
+		tag, err := tx.Conn().PgConn().CopyTo(ctx, &progressWriter{w: w, p: prog}, "COPY "+table.copyColumns(a.dbSchema())+" TO STDOUT")
+		if err != nil {
+			return nil, fmt.Errorf("copying %s: %w", name, err)
+		}
+		table.Rows = tag.RowsAffected()
+		manifest.Tables = append(manifest.Tables, table)
+	}
+
+	w, err := zw.Create(backupManifestName)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.NewEncoder(w).Encode(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, zw.Close()
+}
+
+// readBackupManifest reads and checks the manifest of an opened archive.
+func readBackupManifest(zr *zip.Reader) (*backupManifest, error) {
+	f, err := zr.Open(backupManifestName)
+	if err != nil {
+		return nil, fmt.Errorf("not a backup archive: %w", err)
+	}
+	defer f.Close()
+	var manifest backupManifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", backupManifestName, err)
+	}
+	if manifest.Format != backupFormat {
+		return nil, fmt.Errorf("not a backup archive (format %q)", manifest.Format)
+	}
+	if manifest.SchemaVersion != schemaVersion {
+		return nil, fmt.Errorf("backup has schema version %d but this program uses version %d", manifest.SchemaVersion, schemaVersion)
+	}
+	return &manifest, nil
+}
+
+// backupRestoreOptions are the flags of backup restore.
+type backupRestoreOptions struct {
+	In       string
+	Truncate bool // empty the archived tables before loading them
+	Cascade  bool // truncate tables that reference them by foreign key too
+}
+
+// restoreBackup replays an archive with COPY FROM, one table after another,
+// inside one transaction, so a failed restore leaves the database as it
+// was. The schema is created or upgraded first. Without opts.Truncate the
+// rows are added to those already there, and a clashing row aborts the
+// restore.
+//
+// User triggers on users are disabled while the rows load, so restored
+// users are neither audited again nor announced as new; this needs the
+// role to own the table. Afterwards id sequences are moved past the
+// restored ids.
+func (a *app) restoreBackup(ctx context.Context, pool *pgxpool.Pool, zr *zip.Reader, manifest *backupManifest, opts backupRestoreOptions) error {
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return err
+	}
+
+	return a.withTx(ctx, pool, func(tx pgx.Tx) error {
+		for _, t := range manifest.Tables {
+			columns, err := a.tableColumns(ctx, tx, t.Name)
+			if err != nil {
+				return fmt.Errorf("listing columns of %s: %w", t.Name, err)
+			}
+			if len(columns) == 0 {
+				return fmt.Errorf("the backup holds table %s, which does not exist in schema %q", t.Name, a.dbSchema())
+			}
+		}
+		if opts.Truncate {
+			tables := make([]string, len(manifest.Tables))
+			for i, t := range manifest.Tables {
+				tables[i] = pgx.Identifier{a.dbSchema(), t.Name}.Sanitize()
+			}
+			stmt := "TRUNCATE " + strings.Join(tables, ", ")
+			if opts.Cascade {
+				stmt += " CASCADE"
+			}
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("truncating tables: %w", err)
+			}
+		}
+		// CockroachDB has no triggers to disable
+		if !a.isCockroach() {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+a.usersTable()+" DISABLE TRIGGER USER"); err != nil {
+				return fmt.Errorf("disabling users triggers: %w", err)
+			}
+		}
+
+		for _, t := range manifest.Tables {
+			if err := a.restoreBackupTable(ctx, pool, tx, zr, t); err != nil {
+				return fmt.Errorf("restoring %s: %w", t.Name, err)
+			}
+			if err := a.resetIDSequence(ctx, tx, pgx.Identifier{a.dbSchema(), t.Name}.Sanitize()); err != nil {
+				return err
+			}
+		}
+
+		if !a.isCockroach() {
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+a.usersTable()+" ENABLE TRIGGER USER"); err != nil {
+				return fmt.Errorf("enabling users triggers: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// restoreBackupTable loads one table's entry with COPY FROM STDIN, reporting
+// progress from the server while it runs.
+func (a *app) restoreBackupTable(ctx context.Context, pool *pgxpool.Pool, tx pgx.Tx, zr *zip.Reader, t backupTable) error {
+	f, err := zr.Open(t.entry())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	watchCtx, stopWatching := context.WithCancel(ctx)
+	defer stopWatching()
+	go a.watchServerProgress(watchCtx, pool, tx.Conn().PgConn().PID(), t.Rows)
+	tag, err := tx.Conn().PgConn().CopyFrom(ctx, f, "COPY "+t.copyColumns(a.dbSchema())+" FROM STDIN")
+	if err != nil {
+		return err
+	}
+	if n := tag.RowsAffected(); n != t.Rows {
+		return fmt.Errorf("loaded %d rows but the manifest lists %d", n, t.Rows)
+	}
+	return nil
+}
+
+// newBackupCmd builds the backup command and its restore subcommand.
+func newBackupCmd(a *app) *cobra.Command {
+	var out string
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Archive every table of the program to a compressed file",
+		Long: `Stream the users, posts, users_audit and users_outbox tables with COPY TO into a zip archive,
+with a manifest recording the schema version and the row counts. The tables
+are read in one transaction, so they are archived as of the same moment.
+No pg_dump is required. The archive includes password hashes: keep it as
+private as the database.
+
+Unlike snapshot, which covers the users table only and writes JSON, backup
+keeps COPY's own format and covers every table.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runBackup(cmd.Context(), out)
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "backup.zip", "archive to write")
+
+	var opts backupRestoreOptions
+	restore := &cobra.Command{
+		Use:   "restore",
+		Short: "Load a backup archive back into the database",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runBackupRestore(cmd.Context(), opts)
+		},
+	}
+	restore.Flags().StringVar(&opts.In, "in", "backup.zip", "archive to restore")
+	restore.Flags().BoolVar(&opts.Truncate, "truncate", false, "empty the archived tables before restoring")
+	restore.Flags().BoolVar(&opts.Cascade, "cascade", false, "with --truncate, also empty tables that reference them by foreign key")
+	cmd.AddCommand(migratesSchema(restore))
+	return cmd
+}
+
+// runBackup implements the backup command. An archive that was only partly
+// written is removed.
+func (a *app) runBackup(ctx context.Context, out string) (err error) {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(out)
+		}
+	}()
+	manifest, err := a.takeBackup(ctx, pool, zip.NewWriter(f))
+	if err != nil {
+		return fmt.Errorf("backup failed: %w", err)
+	}
+	for _, t := range manifest.Tables {
+		slog.Info("table backed up", "table", t.Name, "rows", t.Rows)
+	}
+	slog.Info("backup saved", "tables", len(manifest.Tables), "schema_version", manifest.SchemaVersion, "path", out)
+	return nil
+}
+
+// runBackupRestore implements backup restore.
+func (a *app) runBackupRestore(ctx context.Context, opts backupRestoreOptions) error {
+	if opts.Cascade && !opts.Truncate {
+		return fmt.Errorf("--cascade needs --truncate")
+	}
+	zr, err := zip.OpenReader(opts.In)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	manifest, err := readBackupManifest(&zr.Reader)
+	if err != nil {
+		return err
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if err := a.restoreBackup(ctx, pool, &zr.Reader, manifest, opts); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+	for _, t := range manifest.Tables {
+		slog.Info("table restored", "table", t.Name, "rows", t.Rows)
+	}
+	slog.Info("backup restored", "tables", len(manifest.Tables), "taken_at", manifest.TakenAt, "path", opts.In)
+	return nil
+}
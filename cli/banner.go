@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// version is the application version, and buildDate the time of the
+// build. Release builds set them with
+//
+//	go build -ldflags "-X github.com/hozana-dusabimana/cli.version=v1.2.3 -X github.com/hozana-dusabimana/cli.buildDate=2026-01-02T15:04:05Z"
+//
+// Without buildDate, the commit time the Go toolchain embeds stands in.
+var (
+	version   = "dev"
+	buildDate = ""
+)
+
+// featureFlags returns the boolean settings reported in the startup banner.
+// Add new toggles here so operators can see at a glance which are active.
+func featureFlags(c *config.Config) map[string]bool {
+	return map[string]bool{
+		"dedup_input":         c.App.DedupInput,
+		"credential_refresh":  c.Database.CredentialRefresh,
+		"record_provenance":   c.App.RecordProvenance,
+		"precheck_duplicates": c.App.PrecheckDuplicates,
+		"pgbouncer":           c.Database.PgBouncer,
+		"read_only":           c.Database.ReadOnly,
+		"gorm":                c.Database.ORM == "gorm",
+	}
+}
+
+// buildSetting returns the build setting key the Go toolchain embedded,
+// such as vcs.revision, or "" when there is none.
+func buildSetting(key string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, s := range info.Settings {
+		if s.Key == key {
+			return s.Value
+		}
+	}
+	return ""
+}
+
+// buildCommit returns the VCS revision embedded by the Go toolchain,
+// shortened to 12 characters, or "unknown" when not built from a checkout.
+func buildCommit() string {
+	revision := buildSetting("vcs.revision")
+	switch {
+	case revision == "":
+		return "unknown"
+	case len(revision) > 12:
+		return revision[:12]
+	}
+	return revision
+}
+
+// startupBanner returns the attributes of the "startup" log event, which
+// summarizes what this process is about to do. The database is described by
+// user, host, port and name only, so the password never appears in logs.
+func (a *app) startupBanner(cfg *pgxpool.Config, schemaPending bool) []slog.Attr {
+	env := a.cfg().App.Env
+	if env == "" {
+		env = "development"
+	}
+
+	var enabled []string
+	for flag, on := range featureFlags(a.cfg()) {
+		if on {
+			enabled = append(enabled, flag)
+		}
+	}
+	sort.Strings(enabled)
+	features := "none"
+	if len(enabled) > 0 {
+		features = strings.Join(enabled, ",")
+	}
+
+	conn := cfg.ConnConfig
+	return []slog.Attr{
+		slog.String("version", version),
+		slog.String("commit", buildCommit()),
+		slog.String("env", env),
+		slog.String("db", fmt.Sprintf("%s@%s/%s", conn.User, serverAddress(&conn.Config), conn.Database)),
+		slog.Int("pool_size", int(cfg.MaxConns)),
+		slog.String("features", features),
+		slog.Bool("schema_pending", schemaPending),
+	}
+}
+
+// startupKey is the context key of the command's *startup.
+type startupKey struct{}
+
+// startup is what a command does once, when it opens its first pool:
+// checking that the schema suits this build and logging the startup
+// banner. The root command puts one in the context of every command it
+// runs; openPool does nothing more without one, as for doctor and version.
+type startup struct {
+	once sync.Once
+	// schema is the command's schema annotation; see schemaNeeds.
+	schema string
+	err    error
+}
+
+// withStartup returns ctx with a fresh startup for cmd.
+func withStartup(ctx context.Context, cmd *cobra.Command) context.Context {
+	return context.WithValue(ctx, startupKey{}, &startup{schema: schemaNeeds(cmd)})
+}
+
+// runStartup checks the version of DB_SCHEMA with checkSchemaVersion and
+// logs the startup banner of pool with STARTUP_BANNER, the first time it
+// is called with the startup of ctx. Later calls, such as serve's
+// reconnects, return the first one's error.
+func (a *app) runStartup(ctx context.Context, pool *pgxpool.Pool) error {
+	s, ok := ctx.Value(startupKey{}).(*startup)
+	if !ok {
+		return nil
+	}
+	s.once.Do(func() {
+		if s.schema == schemaAny && !a.cfg().App.StartupBanner {
+			return
+		}
+		current, err := migrations.Current(ctx, pool, a.dbSchema())
+		if err != nil {
+			s.err = fmt.Errorf("reading schema version: %w", err)
+			return
+		}
+		latest := int64(schemaVersion)
+		warning, err := checkSchemaVersion(a.dbSchema(), current, latest, s.schema, a.cfg().App.SchemaBehind)
+		if err != nil {
+			s.err = err
+			return
+		}
+		if warning != "" {
+			slog.WarnContext(ctx, warning)
+		}
+		if a.cfg().App.StartupBanner {
+			slog.LogAttrs(ctx, slog.LevelInfo, "startup", a.startupBanner(pool.Config(), current < latest)...)
+		}
+	})
+	return s.err
+}
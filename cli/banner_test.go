@@ -0,0 +1,51 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestStartupBannerRedactsSecrets(t *testing.T) {
+	const password = "s3cret-pw"
+	poolCfg, err := pgxpool.ParseConfig("postgres://app:" + password + "@db.example.com:5433/users?pool_max_conns=7&sslpassword=" + password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newApp(&config.Config{App: config.App{Env: "production", RecordProvenance: true, DedupInput: true}})
+
+	attrs := a.startupBanner(poolCfg, true)
+	got := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		got[attr.Key] = attr.Value.String()
+	}
+	want := map[string]string{
+		"version":        version,
+		"commit":         buildCommit(),
+		"env":            "production",
+		"db":             "app@db.example.com:5433/users",
+		"pool_size":      "7",
+		"features":       "dedup_input,record_provenance",
+		"schema_pending": "true",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("banner %s = %q, want %q", key, got[key], value)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("banner fields = %v, want only %v", got, want)
+	}
+
+	// Nor does the log line the banner is written as hold the password
+	var buf bytes.Buffer
+	slog.New(slog.NewJSONHandler(&buf, nil)).LogAttrs(context.Background(), slog.LevelInfo, "startup", attrs...)
+	if strings.Contains(buf.String(), password) {
+		t.Errorf("startup banner logs the password: %s", buf.String())
+	}
+}
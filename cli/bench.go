@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// benchStrategy is one way of inserting users that bench measures.
+type benchStrategy struct {
+	Name string
+	// insert writes us with the strategy.
+	insert func(ctx context.Context, repo *users.PostgresRepository, pool *pgxpool.Pool, us []users.User) error
+}
+
+// insertAll writes us with s in batches of batchSize.
+func (s benchStrategy) insertAll(ctx context.Context, repo *users.PostgresRepository, pool *pgxpool.Pool, us []users.User, batchSize int) error {
+	for chunk := range slices.Chunk(us, batchSize) {
+		if err := s.insert(ctx, repo, pool, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchUsers returns n users for a benchmark, named prefix followed by
+// their index.
+func benchUsers(prefix string, n int) []users.User {
+	us := make([]users.User, n)
+	for i := range us {
+		username := fmt.Sprintf("%s%d", prefix, i)
+		us[i] = users.User{Username: username, Email: username + "@bench.example"}
+	}
+	return us
+}
+
+// benchStrategies returns the insert paths of the program, usually slowest
+// first.
+func (a *app) benchStrategies() []benchStrategy {
+	return []benchStrategy{
+		{"exec", func(ctx context.Context, repo *users.PostgresRepository, _ *pgxpool.Pool, us []users.User) error {
+			for i := range us {
+				if err := repo.Create(ctx, &us[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		{"batch", func(ctx context.Context, repo *users.PostgresRepository, _ *pgxpool.Pool, us []users.User) error {
+			results, err := repo.CreateMany(ctx, us)
+			if err != nil {
+				return err
+			}
+			return errors.Join(results...)
+		}},
+		{"unnest", func(ctx context.Context, repo *users.PostgresRepository, _ *pgxpool.Pool, us []users.User) error {
+			_, _, err := repo.UpsertMany(ctx, us)
+			return err
+		}},
+		{"bulk", func(ctx context.Context, repo *users.PostgresRepository, _ *pgxpool.Pool, us []users.User) error {
+			_, _, err := repo.BulkCreate(ctx, us)
+			return err
+		}},
+		{"copy", func(ctx context.Context, _ *users.PostgresRepository, pool *pgxpool.Pool, us []users.User) error {
+			_, err := pool.CopyFrom(ctx, pgx.Identifier{a.dbSchema(), "users"}, []string{"username", "email"},
+				pgx.CopyFromSlice(len(us), func(i int) ([]any, error) {
+					return []any{us[i].Username, us[i].Email}, nil
+				}))
+			return err
+		}},
+	}
+}
+
+// benchResult is what bench reports of a strategy.
+type benchResult struct {
+	Strategy   string        `json:"strategy"`
+	Rows       int           `json:"rows"`
+	Elapsed    time.Duration `json:"elapsed_ns"`
+	RowsPerSec float64       `json:"rows_per_sec"`
+}
+
+// newBenchCmd builds the bench command.
+func newBenchCmd(a *app) *cobra.Command {
+	var names []string
+	var batchSize int
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure the rows per second of each way of inserting users",
+		Long: `Measure each insert path of the program against the configured database
+and print the rows per second each one reached. Like a Go benchmark, each
+strategy inserts n generated users, growing n until a run takes about a
+second, in batches of --batch-size for the strategies that batch:
+
+  exec    one INSERT per user (Create), one round trip each
+  batch   the same INSERTs sent together in a pgx.Batch (CreateMany)
+  unnest  one INSERT ... SELECT FROM unnest(...) ON CONFLICT per batch (UpsertMany)
+  bulk    COPY into a staging table, then INSERT ... SELECT (BulkCreate)
+  copy    COPY straight into users, without conflict handling
+
+The users are named bench_<run>_... and deleted after each strategy, so
+the table is the same size for all of them. The numbers depend on the
+server, the network and the indexes and triggers of users; compare them
+on one setup rather than across setups. ON_CONFLICT, ON_EMAIL_CONFLICT and
+PRECHECK_DUPLICATES apply as they do to seed, and the last two turn batch
+into one INSERT per user. Needs PostgreSQL or CockroachDB.
+
+The same strategies run as BenchmarkInsert with go test -tags integration
+-bench Insert, on a test database.`,
+		Example: `  go run ./cmd/go-postgres bench
+  go run ./cmd/go-postgres bench --strategy batch,copy --batch-size 5000 --output json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			strategies, err := a.selectBenchStrategies(names)
+			if err != nil {
+				return err
+			}
+			if batchSize < 1 {
+				return errors.New("--batch-size must be at least 1")
+			}
+			return a.runBench(cmd.Context(), strategies, batchSize)
+		},
+	}
+	cmd.Flags().StringSliceVar(&names, "strategy", nil, "strategies to run, comma-separated (default all: exec, batch, unnest, bulk, copy)")
+	cmd.Flags().IntVar(&batchSize, "batch-size", 1000, "users per batch for batch, unnest, bulk and copy")
+	return cmd
+}
+
+// selectBenchStrategies returns the strategies named, in their usual
+// order, or all of them when none is.
+func (a *app) selectBenchStrategies(names []string) ([]benchStrategy, error) {
+	if len(names) == 0 {
+		return a.benchStrategies(), nil
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(a.benchStrategies(), func(s benchStrategy) bool { return s.Name == name }) {
+			return nil, fmt.Errorf("unknown strategy %q; use exec, batch, unnest, bulk or copy", name)
+		}
+	}
+	return slices.DeleteFunc(a.benchStrategies(), func(s benchStrategy) bool {
+		return !slices.Contains(names, s.Name)
+	}), nil
+}
+
+// runBench implements the bench command.
+func (a *app) runBench(ctx context.Context, strategies []benchStrategy, batchSize int) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	repo := users.NewRepository(pool, a.userRepositoryOptions())
+	prefix := fmt.Sprintf("bench_%x_", time.Now().UnixNano()&0xffffffff)
+
+	var results []benchResult
+	for _, s := range strategies {
+		result, err := benchInsert(ctx, s, repo, pool, prefix+s.Name+"_", batchSize)
+		if _, cleanupErr := a.deleteUsersNamed(context.WithoutCancel(ctx), pool, prefix); cleanupErr != nil {
+			return errors.Join(err, fmt.Errorf("deleting the users of the benchmark, named %s*: %w", prefix, cleanupErr))
+		}
+		if err != nil {
+			return fmt.Errorf("strategy %s: %w", s.Name, err)
+		}
+		results = append(results, result)
+	}
+	return a.printBenchResults(results)
+}
+
+// benchTime is how long the last run of each strategy should take, as
+// with the default -benchtime of go test.
+const benchTime = time.Second
+
+// benchInsert measures s as go test -bench would, without linking the
+// testing package into the program: it inserts n users, growing n until a
+// run takes benchTime, and reports the last run. The users of every run
+// get names of their own under prefix, since the runs before the last are
+// not cleaned up.
+func benchInsert(ctx context.Context, s benchStrategy, repo *users.PostgresRepository, pool *pgxpool.Pool, prefix string, batchSize int) (benchResult, error) {
+	n := 1
+	for run := 1; ; run++ {
+		us := benchUsers(fmt.Sprintf("%s%d_", prefix, run), n)
+		start := time.Now()
+		if err := s.insertAll(ctx, repo, pool, us, batchSize); err != nil {
+			return benchResult{}, err
+		}
+		elapsed := time.Since(start)
+		if elapsed >= benchTime || n >= 1e9 {
+			return benchResult{Strategy: s.Name, Rows: n, Elapsed: elapsed, RowsPerSec: float64(n) / elapsed.Seconds()}, nil
+		}
+		n = nextBenchN(n, elapsed)
+	}
+}
+
+// nextBenchN predicts how many users make a run take benchTime after n
+// took elapsed, the way the testing package grows b.N: with a fifth
+// more for good measure, at least one more and at most a hundred times
+// as many.
+func nextBenchN(n int, elapsed time.Duration) int {
+	next := n * 100
+	if elapsed > 0 {
+		next = int(min(float64(n)*float64(benchTime)/float64(elapsed)*1.2, float64(next)))
+	}
+	return min(max(next, n+1), 1e9)
+}
+
+// printBenchResults writes results as a table, or as a document with
+// --output json or yaml.
+func (a *app) printBenchResults(results []benchResult) error {
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, map[string]any{"results": results})
+	}
+	fastest := slices.MaxFunc(results, func(a, b benchResult) int {
+		return cmp.Compare(a.RowsPerSec, b.RowsPerSec)
+	})
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "STRATEGY\tROWS\tELAPSED\tROWS/S\tVS FASTEST\t")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.0f\t%.2fx\t\n", r.Strategy, r.Rows, r.Elapsed.Round(time.Millisecond), r.RowsPerSec, r.RowsPerSec/fastest.RowsPerSec)
+	}
+	return tw.Flush()
+}
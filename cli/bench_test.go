@@ -0,0 +1,37 @@
+//go:build integration
+
+package cli
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// BenchmarkInsert runs the strategies of the bench command as Go
+// benchmarks, each on the same test database:
+//
+//	go test -tags integration -run '^$' -bench Insert ./cli
+func BenchmarkInsert(b *testing.B) {
+	a, pool := newTestApp(b)
+	repo := users.NewRepository(pool, a.userRepositoryOptions())
+	for _, s := range a.benchStrategies() {
+		runs := 0
+		b.Run(s.Name, func(b *testing.B) {
+			runs++
+			prefix := fmt.Sprintf("bench_%s_%d_", s.Name, runs)
+			us := benchUsers(prefix, b.N)
+			b.ResetTimer()
+			if err := s.insertAll(b.Context(), repo, pool, us, 1000); err != nil {
+				b.Fatal(err)
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "rows/s")
+			b.StopTimer()
+			// The table is the same size for every run and strategy
+			if _, err := a.deleteUsersNamed(b.Context(), pool, prefix); err != nil {
+				b.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hozana-dusabimana/server"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// breakerState is the state of a circuitBreaker.
+type breakerState int
+
+const (
+	breakerClosed   breakerState = iota // calls go through
+	breakerHalfOpen                     // one probe call goes through
+	breakerOpen                         // calls fail fast
+)
+
+func (s breakerState) String() string {
+	return [...]string{"closed", "half-open", "open"}[s]
+}
+
+var (
+	breakerStateGauge = promauto.With(metricsRegistry).NewGauge(prometheus.GaugeOpts{
+		Name: "db_circuit_breaker_state",
+		Help: "State of the database circuit breaker: 0 closed, 1 half-open, 2 open.",
+	})
+	breakerRejections = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "db_circuit_breaker_rejections_total",
+		Help: "Repository calls failed fast because the circuit breaker was open.",
+	})
+)
+
+// circuitOpenError is returned instead of calling the database while the
+// breaker is open.
+type circuitOpenError struct {
+	retryAfter time.Duration // until the next probe is allowed
+}
+
+var _ server.Unavailable = (*circuitOpenError)(nil)
+
+// RetryAfter returns how long until the breaker lets a probe through.
+func (e *circuitOpenError) RetryAfter() time.Duration { return e.retryAfter }
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("database unavailable: circuit breaker open, retrying in %s", max(time.Second, e.retryAfter.Round(time.Second)))
+}
+
+// circuitBreaker stops calling a database that keeps failing. After
+// threshold consecutive failures it opens, and calls fail at once with a
+// *circuitOpenError instead of each waiting for a connection timeout.
+// After cooldown it half-opens and lets one call through as a probe: if the
+// probe succeeds it closes again, otherwise it reopens for another
+// cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    breakerState
+	failures int
+	openedAt time.Time
+}
+
+// newDBBreaker returns the breaker of BREAKER_THRESHOLD, or nil when it
+// is 0; see app.dbBreaker.
+func (a *app) newDBBreaker() *circuitBreaker {
+	if a.cfg().App.BreakerThreshold == 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: a.cfg().App.BreakerThreshold, cooldown: a.cfg().App.BreakerCooldown}
+}
+
+// allow reports whether a call may proceed, turning an open breaker whose
+// cooldown has passed into a half-open one with this call as its probe.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if wait := b.cooldown - time.Since(b.openedAt); wait > 0 {
+			breakerRejections.Inc()
+			return &circuitOpenError{retryAfter: wait}
+		}
+		b.setState(breakerHalfOpen)
+		slog.Info("circuit breaker half-open, probing the database")
+	case breakerHalfOpen:
+		// The probe is still running
+		breakerRejections.Inc()
+		return &circuitOpenError{retryAfter: time.Second}
+	}
+	return nil
+}
+
+// record feeds the outcome of an allowed call back into the breaker.
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case b.state == breakerOpen:
+		// A call admitted before the breaker opened; its outcome is stale
+	case errors.Is(err, context.Canceled):
+		// The caller gave up; that says nothing about the database. A
+		// cancelled probe leaves the next call to probe instead
+		if b.state == breakerHalfOpen {
+			b.setState(breakerOpen)
+			b.openedAt = time.Now().Add(-b.cooldown)
+		}
+	case !isOutage(err):
+		if b.state == breakerHalfOpen {
+			slog.Info("circuit breaker closed, the database is back")
+		}
+		b.failures = 0
+		b.setState(breakerClosed)
+	case b.state == breakerHalfOpen:
+		b.open(err)
+	default:
+		b.failures++
+		if b.failures >= b.threshold {
+			b.open(err)
+		}
+	}
+}
+
+// open opens the breaker for a cooldown; mu must be held.
+func (b *circuitBreaker) open(err error) {
+	slog.Warn("circuit breaker open, failing database calls fast", "failures", b.failures, "cooldown", b.cooldown, "err", err)
+	b.openedAt = time.Now()
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(s breakerState) {
+	b.state = s
+	breakerStateGauge.Set(float64(s))
+}
+
+// State returns the current state; "" for a nil (disabled) breaker.
+func (b *circuitBreaker) State() string {
+	if b == nil {
+		return ""
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
+
+// isOutage reports whether err means the database could not serve the
+// call, as opposed to answering it: the repository's own errors and SQL
+// errors such as a constraint violation prove the server is up. Server
+// errors in the connection (08), resources (53), operator intervention
+// (57) and system (58) classes do count, as do errors with no SQLSTATE at
+// all, such as refused connections and timeouts.
+func isOutage(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, known := range []error{users.ErrNotFound, users.ErrDuplicate, users.ErrForeignKeyViolation, users.ErrInvalid, users.ErrStaleRecord, users.ErrUpdated} {
+		if errors.Is(err, known) {
+			return false
+		}
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		for _, class := range []string{"08", "53", "57", "58"} {
+			if strings.HasPrefix(pgErr.Code, class) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// guardWithBreaker wraps repo in dbBreaker, and returns repo unchanged when
+// the breaker is disabled.
+func (a *app) guardWithBreaker(repo users.Repository) users.Repository {
+	b := a.dbBreaker()
+	if b == nil {
+		return repo
+	}
+	return breakerRepository{Repository: repo, breaker: b}
+}
+
+// breakerRepository routes every repository call through a circuitBreaker.
+type breakerRepository struct {
+	users.Repository
+	breaker *circuitBreaker
+}
+
+// call runs fn if the breaker allows it and records the outcome.
+func (r breakerRepository) call(fn func() error) error {
+	if err := r.breaker.allow(); err != nil {
+		return err
+	}
+	err := fn()
+	r.breaker.record(err)
+	return err
+}
+
+func (r breakerRepository) Create(ctx context.Context, u *users.User) error {
+	return r.call(func() error { return r.Repository.Create(ctx, u) })
+}
+
+// CreateMany judges the batch by its batch-level error only: a per-user
+// failure means the database answered.
+func (r breakerRepository) CreateMany(ctx context.Context, us []users.User) ([]error, error) {
+	var results []error
+	err := r.call(func() (err error) {
+		results, err = r.Repository.CreateMany(ctx, us)
+		return err
+	})
+	if results == nil {
+		results = make([]error, len(us))
+		for i := range results {
+			results[i] = err
+		}
+	}
+	return results, err
+}
+
+func (r breakerRepository) BulkCreate(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	err = r.call(func() (err error) {
+		inserted, updated, err = r.Repository.BulkCreate(ctx, us)
+		return err
+	})
+	return inserted, updated, err
+}
+
+func (r breakerRepository) UpsertMany(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	err = r.call(func() (err error) {
+		inserted, updated, err = r.Repository.UpsertMany(ctx, us)
+		return err
+	})
+	return inserted, updated, err
+}
+
+func (r breakerRepository) GetByID(ctx context.Context, id users.ID) (u *users.User, err error) {
+	err = r.call(func() (err error) {
+		u, err = r.Repository.GetByID(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+func (r breakerRepository) GetByIDs(ctx context.Context, ids []users.ID) (us []users.User, err error) {
+	err = r.call(func() (err error) {
+		us, err = r.Repository.GetByIDs(ctx, ids)
+		return err
+	})
+	return us, err
+}
+
+func (r breakerRepository) GetByUsername(ctx context.Context, username string) (u *users.User, err error) {
+	err = r.call(func() (err error) {
+		u, err = r.Repository.GetByUsername(ctx, username)
+		return err
+	})
+	return u, err
+}
+
+func (r breakerRepository) List(ctx context.Context, page users.Page) (records []users.User, next string, err error) {
+	err = r.call(func() (err error) {
+		records, next, err = r.Repository.List(ctx, page)
+		return err
+	})
+	return records, next, err
+}
+
+// ForEachUser judges the scan by the database's errors only: an error
+// from fn stops the scan but says nothing about the database's health.
+func (r breakerRepository) ForEachUser(ctx context.Context, fn func(users.User) error) error {
+	var fnErr error
+	err := r.call(func() error {
+		err := r.Repository.ForEachUser(ctx, func(u users.User) error {
+			fnErr = fn(u)
+			return fnErr
+		})
+		if fnErr != nil {
+			return nil
+		}
+		return err
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+func (r breakerRepository) Count(ctx context.Context, f users.Filter) (n int64, err error) {
+	err = r.call(func() (err error) {
+		n, err = r.Repository.Count(ctx, f)
+		return err
+	})
+	return n, err
+}
+
+func (r breakerRepository) Update(ctx context.Context, u *users.User) error {
+	return r.call(func() error { return r.Repository.Update(ctx, u) })
+}
+
+func (r breakerRepository) Delete(ctx context.Context, username string) error {
+	return r.call(func() error { return r.Repository.Delete(ctx, username) })
+}
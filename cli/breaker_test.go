@@ -0,0 +1,98 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsOutage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"not found", users.ErrNotFound, false},
+		{"duplicate", fmt.Errorf("creating: %w", users.ErrDuplicateUsername), false},
+		{"stale", users.ErrStaleRecord, false},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false},
+		{"syntax error", &pgconn.PgError{Code: "42601"}, false},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true},
+		{"too many connections", &pgconn.PgError{Code: "53300"}, true},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true},
+		{"io error", &pgconn.PgError{Code: "58030"}, true},
+		{"refused", errors.New("dial tcp: connection refused"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isOutage(tt.err); got != tt.want {
+				t.Errorf("isOutage(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	down := errors.New("connection refused")
+	b := &circuitBreaker{threshold: 2, cooldown: time.Hour}
+
+	b.record(down)
+	if err := b.allow(); err != nil || b.State() != "closed" {
+		t.Fatalf("after one failure: %v, %s", err, b.State())
+	}
+	b.record(users.ErrNotFound) // the database answered: the count restarts
+	b.record(down)
+	if b.State() != "closed" {
+		t.Fatalf("failures apart opened the breaker")
+	}
+	b.record(down)
+	if b.State() != "open" {
+		t.Fatalf("state after %d failures in a row = %s, want open", b.threshold, b.State())
+	}
+	var open *circuitOpenError
+	if err := b.allow(); !errors.As(err, &open) || open.RetryAfter() <= 0 {
+		t.Fatalf("allow while open = %v, want a *circuitOpenError", err)
+	}
+
+	// Cooldown over: one probe goes through, the others wait for it
+	b.openedAt = time.Now().Add(-b.cooldown)
+	if err := b.allow(); err != nil || b.State() != "half-open" {
+		t.Fatalf("allow after the cooldown = %v, %s", err, b.State())
+	}
+	if err := b.allow(); !errors.As(err, &open) {
+		t.Fatalf("second call while probing = %v, want a *circuitOpenError", err)
+	}
+	b.record(down)
+	if b.State() != "open" {
+		t.Fatalf("failed probe left the breaker %s", b.State())
+	}
+
+	b.openedAt = time.Now().Add(-b.cooldown)
+	b.allow()
+	b.record(context.Canceled) // the caller gave up: probe again at once
+	if err := b.allow(); err != nil {
+		t.Fatalf("allow after a cancelled probe = %v", err)
+	}
+	b.record(nil)
+	if b.State() != "closed" {
+		t.Errorf("successful probe left the breaker %s", b.State())
+	}
+}
+
+func TestDisabledBreaker(t *testing.T) {
+	var b *circuitBreaker
+	if b.State() != "" {
+		t.Errorf("State of a nil breaker = %q", b.State())
+	}
+	var repo users.Repository = users.NewSQLiteRepository(nil, users.Options{})
+	if got := newApp(&config.Config{}).guardWithBreaker(repo); got != repo {
+		t.Errorf("BREAKER_THRESHOLD=0 wrapped the repository in %T", got)
+	}
+}
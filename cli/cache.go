@@ -0,0 +1,253 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var cacheLookups = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "users_cache_lookups_total",
+	Help: "User lookups through the CACHE_URL cache, by result: hit, miss or error (Redis failed and the database answered).",
+}, []string{"result"})
+
+// newCacheClient returns the Redis client of CACHE_URL, or nil when it is
+// unset; see app.cacheClient. The URL was checked by the config package,
+// so a failure here is an option go-redis rejects.
+func (a *app) newCacheClient() *redis.Client {
+	if a.cfg().App.CacheURL == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(a.cfg().App.CacheURL)
+	if err != nil {
+		slog.Error("cache disabled: invalid CACHE_URL", "err", err)
+		return nil
+	}
+	return redis.NewClient(opts)
+}
+
+// cacheReads wraps repo in a read-through cache, and returns repo unchanged
+// when CACHE_URL is unset.
+func (a *app) cacheReads(repo users.Repository) users.Repository {
+	client := a.cacheClient()
+	if client == nil {
+		return repo
+	}
+	prefix := "users:" + a.dbSchema() + ":"
+	if t := a.cfg().Database.RowTenant; t != "" {
+		// Processes of other row tenants may share the cache but not see
+		// the same users
+		prefix += "rls:" + t + ":"
+	}
+	return cachedRepository{Repository: repo, client: client, ttl: a.cfg().App.CacheTTL, prefix: prefix}
+}
+
+// cachedRepository answers GetByID and GetByUsername from Redis when it
+// can, and stores what the database returns for CACHE_TTL. A user is
+// stored under both keys at once, so either lookup finds it.
+//
+// Updates and deletes made through the repository, and inserts that
+// overwrote an existing user, remove the user's entries. Changes made any
+// other way (another program, or an instance without the cache) show up
+// once the entries expire, so CACHE_TTL bounds how stale a lookup can be.
+// When Redis fails, lookups fall through to the database and a warning is
+// logged: the cache never makes a lookup fail.
+type cachedRepository struct {
+	users.Repository
+	client *redis.Client
+	ttl    time.Duration
+	prefix string // namespaces the keys by schema, so tenants never share entries
+}
+
+func (r cachedRepository) idKey(id users.ID) string {
+	return r.prefix + "id:" + id.String()
+}
+
+func (r cachedRepository) usernameKey(username string) string {
+	return r.prefix + "username:" + username
+}
+
+// lookup returns the user cached under key, or nil when there is none or
+// Redis failed.
+func (r cachedRepository) lookup(ctx context.Context, key string) *users.User {
+	b, err := r.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		cacheLookups.WithLabelValues("miss").Inc()
+		return nil
+	}
+	var u users.User
+	if err == nil {
+		err = json.Unmarshal(b, &u)
+	}
+	if err != nil {
+		cacheLookups.WithLabelValues("error").Inc()
+		slog.Warn("cache lookup failed; reading the database", "key", key, "err", err)
+		return nil
+	}
+	cacheLookups.WithLabelValues("hit").Inc()
+	return &u
+}
+
+// store caches u under its id and its username.
+func (r cachedRepository) store(ctx context.Context, u *users.User) {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return
+	}
+	_, err = r.client.Pipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, r.idKey(u.ID), b, r.ttl)
+		p.Set(ctx, r.usernameKey(u.Username), b, r.ttl)
+		return nil
+	})
+	if err != nil {
+		slog.Warn("caching user failed", "username", u.Username, "err", err)
+	}
+}
+
+// invalidateChunk bounds the keys invalidateKeys sends to Redis in one command.
+const invalidateChunk = 1000
+
+// invalidate removes the entries of the named users. The id entries are
+// found through the username entries, so an id entry whose username entry
+// is gone already stays until it expires.
+func (r cachedRepository) invalidate(ctx context.Context, usernames ...string) {
+	keys := make([]string, len(usernames))
+	for i, name := range usernames {
+		keys[i] = r.usernameKey(name)
+	}
+	r.invalidateKeys(ctx, keys)
+}
+
+// invalidateUpdated removes the entries of users a create overwrote, by id
+// as well as by username: ON_CONFLICT_EMAIL=upsert renames the user, whose
+// entry under the old username is only found through its id entry.
+func (r cachedRepository) invalidateUpdated(ctx context.Context, us ...users.User) {
+	keys := make([]string, 0, 2*len(us))
+	for _, u := range us {
+		keys = append(keys, r.usernameKey(u.Username), r.idKey(u.ID))
+	}
+	r.invalidateKeys(ctx, keys)
+}
+
+// invalidateKeys removes keys, and both entries of each user cached under
+// one of them.
+func (r cachedRepository) invalidateKeys(ctx context.Context, keys []string) {
+	// The write has happened; the entries must go even if the caller has
+	// given up waiting
+	ctx = context.WithoutCancel(ctx)
+	for batch := range slices.Chunk(keys, invalidateChunk) {
+		if err := r.invalidateBatch(ctx, batch); err != nil {
+			slog.Warn("cache invalidation failed; entries expire within CACHE_TTL", "err", err)
+			return
+		}
+	}
+}
+
+func (r cachedRepository) invalidateBatch(ctx context.Context, keys []string) error {
+	cached, err := r.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return err
+	}
+	for _, v := range cached {
+		var u users.User
+		if s, ok := v.(string); ok && json.Unmarshal([]byte(s), &u) == nil && u.ID != "" {
+			keys = append(keys, r.idKey(u.ID), r.usernameKey(u.Username))
+		}
+	}
+	return r.client.Del(ctx, keys...).Err()
+}
+
+func (r cachedRepository) GetByID(ctx context.Context, id users.ID) (*users.User, error) {
+	if u := r.lookup(ctx, r.idKey(id)); u != nil {
+		return u, nil
+	}
+	u, err := r.Repository.GetByID(ctx, id)
+	if err == nil {
+		r.store(ctx, u)
+	}
+	return u, err
+}
+
+func (r cachedRepository) GetByUsername(ctx context.Context, username string) (*users.User, error) {
+	if u := r.lookup(ctx, r.usernameKey(username)); u != nil {
+		return u, nil
+	}
+	u, err := r.Repository.GetByUsername(ctx, username)
+	if err == nil {
+		r.store(ctx, u)
+	}
+	return u, err
+}
+
+// Create invalidates the user that ON_CONFLICT=upsert overwrote.
+func (r cachedRepository) Create(ctx context.Context, u *users.User) error {
+	err := r.Repository.Create(ctx, u)
+	if errors.Is(err, users.ErrUpdated) {
+		r.invalidateUpdated(ctx, *u)
+	}
+	return err
+}
+
+func (r cachedRepository) CreateMany(ctx context.Context, us []users.User) ([]error, error) {
+	results, err := r.Repository.CreateMany(ctx, us)
+	var overwritten []users.User
+	for i, rowErr := range results {
+		if errors.Is(rowErr, users.ErrUpdated) {
+			overwritten = append(overwritten, us[i])
+		}
+	}
+	r.invalidateUpdated(ctx, overwritten...)
+	return results, err
+}
+
+// BulkCreate does not report which users it overwrote, so when it
+// overwrote any, every username of the batch is invalidated.
+func (r cachedRepository) BulkCreate(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	inserted, updated, err = r.Repository.BulkCreate(ctx, us)
+	r.invalidateOverwritten(ctx, us, updated)
+	return inserted, updated, err
+}
+
+// UpsertMany invalidates as BulkCreate does.
+func (r cachedRepository) UpsertMany(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	inserted, updated, err = r.Repository.UpsertMany(ctx, us)
+	r.invalidateOverwritten(ctx, us, updated)
+	return inserted, updated, err
+}
+
+// invalidateOverwritten invalidates every username of us when a batch write
+// overwrote any of them.
+func (r cachedRepository) invalidateOverwritten(ctx context.Context, us []users.User, updated int64) {
+	if updated == 0 {
+		return
+	}
+	usernames := make([]string, len(us))
+	for i, u := range us {
+		usernames[i] = u.Username
+	}
+	r.invalidate(ctx, usernames...)
+}
+
+func (r cachedRepository) Update(ctx context.Context, u *users.User) error {
+	err := r.Repository.Update(ctx, u)
+	if err == nil {
+		r.invalidate(ctx, u.Username)
+	}
+	return err
+}
+
+func (r cachedRepository) Delete(ctx context.Context, username string) error {
+	err := r.Repository.Delete(ctx, username)
+	if err == nil {
+		r.invalidate(ctx, username)
+	}
+	return err
+}
@@ -0,0 +1,288 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/hozana-dusabimana/cdc"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/cobra"
+)
+
+// cdcOptions holds the flags of the cdc command.
+type cdcOptions struct {
+	Slot        string
+	Publication string
+	Temporary   bool
+	JSON        bool
+	Forward     string
+}
+
+// cdcEvent is how a change to the users table is printed with --json and
+// forwarded. Old is the row before an update or delete as far as the
+// replica identity reaches: with the default, only its id is set.
+type cdcEvent struct {
+	Op         cdc.Op      `json:"op"`
+	Table      string      `json:"table"`
+	LSN        cdc.LSN     `json:"lsn"`
+	XID        uint32      `json:"xid"`
+	CommitTime time.Time   `json:"commit_time"`
+	New        *users.User `json:"new,omitempty"`
+	Old        *users.User `json:"old,omitempty"`
+}
+
+// newCDCCmd builds the cdc command and its drop subcommand.
+func newCDCCmd(a *app) *cobra.Command {
+	var opts cdcOptions
+	cmd := &cobra.Command{
+		Use:   "cdc",
+		Short: "Stream inserts, updates and deletes of users from the WAL via logical replication",
+		Example: `  cdc
+  cdc --json
+  cdc --forward http://localhost:9000/changes
+  cdc drop`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runCDC(cmd.Context(), opts)
+		},
+	}
+	cmd.PersistentFlags().StringVar(&opts.Slot, "slot", "users_cdc", "logical replication slot to create or resume from")
+	cmd.PersistentFlags().StringVar(&opts.Publication, "publication", "users_cdc", "publication of the users table to create or use")
+	cmd.Flags().BoolVar(&opts.Temporary, "temporary", false, "use a temporary slot, dropped on exit; changes made while not running are missed")
+	cmd.Flags().BoolVar(&opts.JSON, "json", false, "print each change as a line of JSON")
+	cmd.Flags().StringVar(&opts.Forward, "forward", "", "POST each change as JSON to this http(s) URL instead of printing it")
+	cmd.MarkFlagsMutuallyExclusive("json", "forward")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "drop",
+		Short: "Drop the replication slot and the publication, releasing the WAL the slot retains",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.dropCDC(cmd.Context(), opts)
+		},
+	})
+	return cmd
+}
+
+// runCDC makes sure the publication exists, then streams the changes it
+// covers from the slot, creating the slot on first use. A permanent slot
+// keeps every change not yet confirmed, so when the connection is lost the
+// stream resumes where it stopped; a change whose delivery fails is sent
+// again.
+func (a *app) runCDC(ctx context.Context, opts cdcOptions) error {
+	if err := a.checkCDC(opts); err != nil {
+		return err
+	}
+	deliver := a.printChange(opts.JSON)
+	if opts.Forward != "" {
+		u, err := url.Parse(opts.Forward)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--forward must be an http or https URL, got %q", opts.Forward)
+		}
+		deliver = changeForwarder(opts.Forward)
+	}
+	if err := a.preparePublication(ctx, opts.Publication); err != nil {
+		return err
+	}
+
+	return reconnecting(ctx, "cdc", func(first bool) error {
+		conn, err := a.replicationConn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close(context.WithoutCancel(ctx))
+
+		lsn, err := cdc.CreateSlot(ctx, conn, opts.Slot, opts.Temporary)
+		var pgErr *pgconn.PgError
+		switch {
+		case err == nil:
+			fmt.Fprintf(os.Stderr, "Created replication slot %s at %s\n", opts.Slot, lsn)
+		case !opts.Temporary && errors.As(err, &pgErr) && pgErr.Code == "42710":
+			// duplicate_object: the slot exists, so resume from it
+		default:
+			return fmt.Errorf("creating replication slot %s: %w", opts.Slot, err)
+		}
+		if first {
+			fmt.Fprintf(os.Stderr, "Streaming changes to users from slot %s (Ctrl-C to stop)...\n", opts.Slot)
+		}
+		return cdc.Stream(ctx, conn, opts.Slot, opts.Publication, func(c cdc.Change) error {
+			e, err := newCDCEvent(c)
+			if err != nil {
+				return err
+			}
+			return deliver(ctx, e)
+		})
+	})
+}
+
+// checkCDC rejects what cdc cannot run against before connecting.
+func (a *app) checkCDC(opts cdcOptions) error {
+	if a.usesSQLDB() || a.isCockroach() {
+		return fmt.Errorf("cdc uses PostgreSQL logical replication; DB_DRIVER=%s does not support it", a.cfg().Database.Driver)
+	}
+	if err := cdc.CheckName("slot", opts.Slot); err != nil {
+		return err
+	}
+	return cdc.CheckName("publication", opts.Publication)
+}
+
+// preparePublication checks that the server decodes its WAL and creates
+// the publication of the users table if it does not exist yet.
+func (a *app) preparePublication(ctx context.Context, publication string) error {
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	var walLevel string
+	if err := conn.QueryRow(ctx, "SHOW wal_level").Scan(&walLevel); err != nil {
+		return err
+	}
+	if walLevel != "logical" {
+		return fmt.Errorf("cdc needs wal_level=logical, but the server runs with %s; run `ALTER SYSTEM SET wal_level = logical` and restart it", walLevel)
+	}
+
+	var exists bool
+	err = conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)", publication).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE %s", pgx.Identifier{publication}.Sanitize(), a.usersTable())); err != nil {
+		return fmt.Errorf("creating publication %s: %w", publication, err)
+	}
+	fmt.Fprintf(os.Stderr, "Created publication %s for %s\n", publication, a.usersTable())
+	return nil
+}
+
+// replicationConn opens a logical replication connection with the
+// configured credentials. It is a bare pgconn connection: replication
+// commands are not SQL, so none of the pgx query machinery applies.
+func (a *app) replicationConn(ctx context.Context) (*pgconn.PgConn, error) {
+	a.creds.use(a.cfg().Database.Secrets)
+	cfg, err := a.parseConnConfig(a.cfg().Database.ConnStr)
+	if err != nil {
+		return nil, err
+	}
+	a.creds.apply(ctx, &cfg.Config)
+	cfg.RuntimeParams["replication"] = "database"
+	// A walsender applies statement_timeout to START_REPLICATION too,
+	// which would end the stream
+	delete(cfg.RuntimeParams, "statement_timeout")
+	conn, err := pgconn.ConnectConfig(ctx, &cfg.Config)
+	if err != nil {
+		return nil, fmt.Errorf("opening replication connection (the role needs the REPLICATION attribute): %w", err)
+	}
+	return conn, nil
+}
+
+// dropCDC drops the slot and the publication; either may already be gone.
+func (a *app) dropCDC(ctx context.Context, opts cdcOptions) error {
+	if err := a.checkCDC(opts); err != nil {
+		return err
+	}
+	conn, err := a.replicationConn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+	var pgErr *pgconn.PgError
+	switch err := cdc.DropSlot(ctx, conn, opts.Slot); {
+	case err == nil:
+		fmt.Printf("Dropped replication slot %s\n", opts.Slot)
+	case errors.As(err, &pgErr) && pgErr.Code == "42704":
+		// undefined_object
+		fmt.Printf("No replication slot %s\n", opts.Slot)
+	default:
+		return fmt.Errorf("dropping replication slot %s: %w", opts.Slot, err)
+	}
+
+	sqlConn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer sqlConn.Close(context.WithoutCancel(ctx))
+	if _, err := sqlConn.Exec(ctx, "DROP PUBLICATION IF EXISTS "+pgx.Identifier{opts.Publication}.Sanitize()); err != nil {
+		return fmt.Errorf("dropping publication %s: %w", opts.Publication, err)
+	}
+	fmt.Printf("Dropped publication %s\n", opts.Publication)
+	return nil
+}
+
+// newCDCEvent decodes the rows of c into users. The users table has
+// columns User has no field for, such as password_hash; they are left out.
+func newCDCEvent(c cdc.Change) (cdcEvent, error) {
+	e := cdcEvent{Op: c.Op, Table: c.Schema + "." + c.Table, LSN: c.LSN, XID: c.XID, CommitTime: c.CommitTime}
+	for _, r := range []struct {
+		row *cdc.Row
+		dst **users.User
+	}{{c.New, &e.New}, {c.Old, &e.Old}} {
+		if r.row == nil {
+			continue
+		}
+		var u users.User
+		if err := r.row.Scan(&u); err != nil {
+			return e, fmt.Errorf("decoding %s change at %s: %w", c.Op, c.LSN, err)
+		}
+		*r.dst = &u
+	}
+	return e, nil
+}
+
+// printChange returns a delivery function that writes each change to
+// stdout as a line of JSON or of text.
+func (a *app) printChange(asJSON bool) func(context.Context, cdcEvent) error {
+	enc := json.NewEncoder(os.Stdout)
+	return func(_ context.Context, e cdcEvent) error {
+		if asJSON {
+			return enc.Encode(e)
+		}
+		when := a.displayTime(e.CommitTime).Format(displayTimeLayout)
+		switch {
+		case e.New != nil:
+			fmt.Printf("%s\t%s\t%s\t%s\t%s\n", when, e.Op, e.New.ID, e.New.Username, e.New.Email)
+		case e.Old != nil:
+			fmt.Printf("%s\t%s\t%s\n", when, e.Op, e.Old.ID)
+		default:
+			fmt.Printf("%s\t%s\t%s\n", when, e.Op, e.Table)
+		}
+		return nil
+	}
+}
+
+// changeForwarder returns a delivery function that POSTs each change as
+// JSON to target. Unlike listen --forward it does not drop what fails to
+// arrive: the error ends the stream before the change is confirmed, and
+// it is sent again once the stream resumes.
+func changeForwarder(target string) func(context.Context, cdcEvent) error {
+	client := &http.Client{Timeout: forwardTimeout}
+	return func(ctx context.Context, e cdcEvent) error {
+		body, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("forwarding change at %s: %w", e.LSN, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			return fmt.Errorf("forwarding change at %s: %s", e.LSN, resp.Status)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// archiveTableName is the table cleanup --archive moves rows into.
+	archiveTableName = "archived_rows"
+	// defaultCleanupBatch is how many rows cleanup removes per statement,
+	// so a large backlog does not hold locks for long.
+	defaultCleanupBatch = 10_000
+)
+
+// retention is one table cleanup trims: the rows whose column is older
+// than keep. A zero keep leaves the table alone.
+type retention struct {
+	table  string // in DB_SCHEMA
+	key    string // the primary key of table
+	column string
+	keep   time.Duration
+	// what names the rows in messages.
+	what string
+	// discard deletes the rows even with --archive: expired, they are of
+	// no use to anyone.
+	discard bool
+}
+
+// userRetention removes the users soft-deleted longer ago than keep.
+func userRetention(keep time.Duration) retention {
+	return retention{table: "users", key: "id", column: "deleted_at", keep: keep, what: "soft-deleted users"}
+}
+
+// auditRetention removes the users_audit entries older than keep.
+func auditRetention(keep time.Duration) retention {
+	return retention{table: "users_audit", key: "id", column: "changed_at", keep: keep, what: "audit entries"}
+}
+
+// idempotencyRetention removes the Idempotency-Key responses stored longer
+// ago than keep, by when no client retries its request any more.
+func idempotencyRetention(keep time.Duration) retention {
+	return retention{table: "idempotency_keys", key: "key", column: "created_at", keep: keep, what: "idempotency keys", discard: true}
+}
+
+// newCleanupCmd builds the cleanup command.
+func newCleanupCmd(a *app) *cobra.Command {
+	var deleted, audit, idempotency time.Duration
+	var archive bool
+	var batchSize int
+	cmd := &cobra.Command{
+		Use:   "cleanup",
+		Short: "Delete or archive soft-deleted users, audit entries and idempotency keys past their retention",
+		Long: `Remove the users soft-deleted longer ago than --deleted-older-than, the
+users_audit entries older than --audit-older-than and the Idempotency-Key
+responses of serve stored longer ago than --idempotency-older-than. A zero
+period leaves its table alone; by default deleted users are kept 30 days,
+as by purge, the audit trail is kept for good and idempotency keys for
+IDEMPOTENCY_RETENTION (24h).
+
+The rows go in batches of --batch-size, each its own statement and
+transaction, so a large backlog never holds its locks for long and an
+interrupted run keeps what it removed. With --archive each batch is moved
+into archived_rows (migration 0022) as JSON instead of being dropped;
+users are archived without their password hash. Idempotency keys are
+always dropped.
+
+As with purge, a user that another table still refers to cannot be removed
+and fails the command, and removing a user is itself recorded in the audit
+trail. The same cleanup can run on a schedule as the cleanup task of
+daemon. Needs PostgreSQL or CockroachDB; CockroachDB has no users_audit.`,
+		Example: `  go run ./cmd/go-postgres cleanup
+  go run ./cmd/go-postgres cleanup --deleted-older-than 2160h --audit-older-than 8760h --archive`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if deleted < 0 || audit < 0 || idempotency < 0 {
+				return errors.New("--deleted-older-than, --audit-older-than and --idempotency-older-than must not be negative")
+			}
+			if batchSize < 1 {
+				return errors.New("--batch-size must be at least 1")
+			}
+			if a.usesSQLDB() {
+				return fmt.Errorf("cleanup needs PostgreSQL or CockroachDB, not DB_DRIVER=%s; see purge", a.cfg().Database.Driver)
+			}
+			targets := []retention{userRetention(deleted), auditRetention(audit), idempotencyRetention(idempotency)}
+			return a.runCleanup(cmd.Context(), targets, batchSize, archive)
+		},
+	}
+	cmd.Flags().DurationVar(&deleted, "deleted-older-than", defaultPurgeRetention, "remove the users soft-deleted longer ago than this; 0 keeps them")
+	cmd.Flags().DurationVar(&audit, "audit-older-than", 0, "remove the audit entries older than this; 0 keeps them")
+	cmd.Flags().DurationVar(&idempotency, "idempotency-older-than", a.cfg().App.IdempotencyKeep, "remove the idempotency keys stored longer ago than this; 0 keeps them")
+	cmd.Flags().BoolVar(&archive, "archive", false, "move the rows into archived_rows instead of deleting them")
+	cmd.Flags().IntVar(&batchSize, "batch-size", defaultCleanupBatch, "rows removed per statement")
+	return cmd
+}
+
+// runCleanup implements the cleanup command.
+func (a *app) runCleanup(ctx context.Context, targets []retention, batchSize int, archive bool) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	for _, t := range targets {
+		if t.keep == 0 {
+			continue
+		}
+		n, err := a.trimTable(ctx, pool, t, batchSize, archive)
+		if err != nil {
+			return err
+		}
+		slog.Info("cleanup complete", "table", t.table, "removed", n, "older_than", t.keep, "archived", archive)
+	}
+	return nil
+}
+
+// trimTable removes the rows of t older than t.keep, batchSize at a time,
+// moving them into archived_rows with archive, and returns how many it
+// removed. Each batch commits on its own. It does nothing when t.keep is
+// zero.
+func (a *app) trimTable(ctx context.Context, pool *pgxpool.Pool, t retention, batchSize int, archive bool) (int64, error) {
+	if t.keep == 0 {
+		return 0, nil
+	}
+	if t.table == "users_audit" && a.isCockroach() {
+		return 0, fmt.Errorf("the audit trail is recorded by a PostgreSQL trigger; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	table := pgx.Identifier{a.dbSchema(), t.table}.Sanitize()
+	batch := fmt.Sprintf("SELECT %s FROM %s WHERE %s < now() - $1::interval LIMIT %d", t.key, table, t.column, batchSize)
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", table, t.key, batch)
+	args := []any{t.keep}
+	if archive && !t.discard {
+		stmt = fmt.Sprintf(`WITH removed AS (%s RETURNING *)
+			INSERT INTO %s (source_table, row_data)
+			SELECT $2::text, to_jsonb(removed) - 'password_hash' - 'search' FROM removed`,
+			stmt, pgx.Identifier{a.dbSchema(), archiveTableName}.Sanitize())
+		args = append(args, t.table)
+	}
+
+	var total int64
+	for {
+		tag, err := pool.Exec(ctx, stmt, args...)
+		if err != nil {
+			return total, fmt.Errorf("removed %d %s, then: %w", total, t.what, err)
+		}
+		total += tag.RowsAffected()
+		if tag.RowsAffected() < int64(batchSize) {
+			return total, nil
+		}
+	}
+}
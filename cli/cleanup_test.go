@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestArchiveMigration(t *testing.T) {
+	all, err := migrations.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		if m.Version == 22 {
+			if !strings.Contains(m.SQL, "CREATE TABLE") || !strings.Contains(m.SQL, archiveTableName) {
+				t.Errorf("%s does not create %s", m.Name, archiveTableName)
+			}
+			if !strings.Contains(m.DownSQL, "DROP TABLE") {
+				t.Errorf("%s has no down migration dropping the table", m.Name)
+			}
+			return
+		}
+	}
+	t.Error("migration 0022 is not embedded")
+}
+
+func TestCleanupFlags(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		args   []string
+		want   string
+	}{
+		{"negative period", "", []string{"--deleted-older-than", "-1h"}, "must not be negative"},
+		{"negative audit period", "", []string{"--audit-older-than", "-1h"}, "must not be negative"},
+		{"negative idempotency period", "", []string{"--idempotency-older-than", "-1h"}, "must not be negative"},
+		{"no batch", "", []string{"--batch-size", "0"}, "--batch-size"},
+		{"sqlite", config.DriverSQLite, nil, "needs PostgreSQL or CockroachDB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApp(&config.Config{Database: config.Database{Driver: tt.driver}})
+			a.openPool = func(context.Context) (*pgxpool.Pool, error) {
+				t.Error("cleanup connected despite invalid arguments")
+				return nil, errors.New("no database in this test")
+			}
+			cmd := newCleanupCmd(a)
+			cmd.SetArgs(tt.args)
+			cmd.SilenceUsage, cmd.SilenceErrors = true, true
+			if err := cmd.ExecuteContext(context.Background()); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrimTableKeepsForGood(t *testing.T) {
+	a := newApp(&config.Config{})
+	// A zero period returns before touching the pool
+	if n, err := a.trimTable(context.Background(), nil, auditRetention(0), defaultCleanupBatch, false); n != 0 || err != nil {
+		t.Errorf("trimTable with keep 0 = %d, %v", n, err)
+	}
+}
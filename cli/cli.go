@@ -0,0 +1,456 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newRootCmd builds the command tree. Run without a subcommand, the program
+// performs the quickstart; every subcommand shares the configuration loaded
+// in main and opens its own pool through openPool.
+//
+// configErr is the error (if any) returned by config.Load. Invalid settings
+// stop every command before it runs, except doctor, which reports them.
+func newRootCmd(a *app, configErr error) *cobra.Command {
+	var opts quickstartOptions
+	root := &cobra.Command{
+		Use:   "go-postgres",
+		Short: "PostgreSQL quickstart and small database tool",
+		Long: "Without a command, creates the users table and inserts sample users.\n" +
+			"Subcommands run individual steps and diagnostics.",
+		Args:    cobra.NoArgs,
+		Version: version,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// Flags parsed fine; from here on errors are not usage mistakes
+			cmd.SilenceUsage = true
+			trace.SpanFromContext(cmd.Context()).SetName(cmd.CommandPath())
+			cmd.SetContext(withStartup(cmd.Context(), cmd))
+			var invalid *config.ValidationError
+			if errors.As(configErr, &invalid) {
+				return invalid
+			}
+			return a.checkDryRun(cmd)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if configErr != nil {
+				return fmt.Errorf("error loading .env file: %w", configErr)
+			}
+			return a.runQuickstart(cmd.Context(), opts)
+		},
+		SilenceErrors: true,
+	}
+	// Already applied by config.ParseFlags in main; registered so they parse
+	// and show in --help
+	root.PersistentFlags().AddFlagSet(config.FlagSet())
+	root.Flags().IntVar(&opts.Generate, "generate", 0, "insert N generated fake users instead of the sample data (seed with FAKE_SEED)")
+	root.Flags().StringVar(&opts.Source, "source", "", "provenance label stored with each row when RECORD_PROVENANCE is enabled")
+
+	allowDryRun(migratesSchema(root))
+	root.AddCommand(
+		anySchema(newPingCmd(a)),
+		anySchema(newMigrateCmd(a)),
+		anySchema(newDBCmd(a)),
+		newTableCmd(a),
+		anySchema(newTenantCmd(a)),
+		newRLSCmd(a),
+		migratesSchema(newProvisionCmd(a)),
+		newSeedCmd(a),
+		newImportCmd(a),
+		newInsertCmd(a),
+		newListCmd(a),
+		newPurgeCmd(a),
+		migratesSchema(newCleanupCmd(a)),
+		migratesSchema(newAnonymizeCmd(a)),
+		newUserCmd(a),
+		newProfileCmd(a),
+		newAuditCmd(a),
+		newSearchCmd(a),
+		newVectorCmd(a),
+		newGeoCmd(a),
+		newPostsCmd(a),
+		newStatsCmd(a),
+		newReportCmd(a),
+		newEventsCmd(a),
+		newTwoPhaseCmd(a),
+		newUpdateEmailCmd(a),
+		migratesSchema(newServeCmd(a)),
+		migratesSchema(newDaemonCmd(a)),
+		newDoctorCmd(a, configErr),
+		newVersionCmd(a),
+		newCheckCmd(a),
+		migratesSchema(newTailCmd(a)),
+		newListenCmd(a),
+		newCDCCmd(a),
+		newOutboxCmd(a),
+		anySchema(newLatencyCmd(a)),
+		newBackfillCmd(a),
+		newSnapshotCmd(a),
+		migratesSchema(newRestoreCmd(a)),
+		newBackupCmd(a),
+		newExportCmd(a),
+		anySchema(newExecCmd(a)),
+		anySchema(newConsoleCmd(a)),
+		anySchema(newLocksCmd(a)),
+		anySchema(newTopQueriesCmd(a)),
+		migratesSchema(newLoadtestCmd(a)),
+		migratesSchema(newBenchCmd(a)),
+	)
+	return root
+}
+
+// newPingCmd builds the ping command, which connects, runs a trivial query
+// and reports the server version and round-trip time.
+func newPingCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "ping",
+		Short: "Check that the database is reachable",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			pool, err := a.openPool(ctx)
+			if err != nil {
+				return err
+			}
+			defer pool.Close()
+
+			start := time.Now()
+			var serverVersion string
+			if err := pool.QueryRow(ctx, "SHOW server_version").Scan(&serverVersion); err != nil {
+				return fmt.Errorf("ping failed: %w", err)
+			}
+			slog.Info("pong", "server_version", serverVersion, "duration", time.Since(start).Round(time.Microsecond))
+			return nil
+		},
+	}
+}
+
+// newMigrateCmd builds the migrate command, which applies pending
+// migrations, with `down` and `to` subcommands for rolling back.
+func newMigrateCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Apply pending schema migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runMigrate(cmd.Context(), func(ctx context.Context, q migrations.DB) ([]migrations.Step, error) {
+				return migrations.PlanUp(ctx, q, a.dbSchema())
+			})
+		},
+	}
+	allowDryRun(cmd)
+
+	cmd.AddCommand(allowDryRun(&cobra.Command{
+		Use:   "down [n]",
+		Short: "Roll back the last n applied migrations (default 1)",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n := 1
+			if len(args) == 1 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil {
+					return fmt.Errorf("invalid count %q: %w", args[0], err)
+				}
+			}
+			return a.runMigrate(cmd.Context(), func(ctx context.Context, q migrations.DB) ([]migrations.Step, error) {
+				return migrations.PlanDown(ctx, q, a.dbSchema(), n)
+			})
+		},
+	}), allowDryRun(&cobra.Command{
+		Use:   "to <version>",
+		Short: "Migrate up or down to exactly the given version (0 rolls back everything)",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			target, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version %q: %w", args[0], err)
+			}
+			return a.runMigrate(cmd.Context(), func(ctx context.Context, q migrations.DB) ([]migrations.Step, error) {
+				return migrations.PlanTo(ctx, q, a.dbSchema(), target)
+			})
+		},
+	}), &cobra.Command{
+		Use:   "status",
+		Short: "List the migrations and whether each is applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runMigrateStatus(cmd.Context())
+		},
+	}, &cobra.Command{
+		Use:   "force <version>",
+		Short: "Record the schema as being at the given version, without running anything",
+		Long: `Rewrite schema_migrations so that the migrations up to <version> are
+applied and clean, with the checksums of this build, and none above it
+is. No migration is run.
+
+Use it after a migration that runs outside a transaction failed part way
+and was left dirty: complete its changes by hand and force its version,
+or undo them and force the version before it.`,
+		Example: `  go run ./cmd/go-postgres migrate force 7`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			version, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid version %q: %w", args[0], err)
+			}
+			return a.runMigrateForce(cmd.Context(), version)
+		},
+	}, newMigrateLintCmd(), &cobra.Command{
+		Use:   "repair",
+		Short: "Forget dirty migrations and accept the checksums of modified ones",
+		Long: `Delete the records of dirty migrations, so the next migrate runs them
+again, and record this build's checksum for every applied migration whose
+file changed since it was applied. No migration is run, so changes made
+to an applied file are not applied to the schema: make them with a new
+migration.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runMigrateRepair(cmd.Context())
+		},
+	})
+	return cmd
+}
+
+// newMigrateLintCmd builds migrate lint, which checks migration files
+// without connecting.
+func newMigrateLintCmd() *cobra.Command {
+	var dir string
+	var since int64
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the migration files for mistakes, without connecting",
+		Long: `Check the migration files for numbering gaps and duplicates, missing down
+files, INDEX CONCURRENTLY without the "-- no-transaction" marker, CREATE
+INDEX without CONCURRENTLY on an existing table, DROP without IF EXISTS,
+and ADD COLUMN with a volatile default, which rewrites the table. Each
+problem is printed with its file and line, and the command fails if there
+is any.
+
+Without --dir the migrations built into the binary are checked. The
+statements of versions below --since are skipped, since applied
+migrations cannot be changed; the default is the first version written
+after the check existed.`,
+		Example: `  go run ./cmd/go-postgres migrate lint --dir migrations/sql`,
+		Args:    cobra.NoArgs,
+		// Replaces the root hook: no configuration is needed
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fsys := migrations.Files()
+			if dir != "" {
+				fsys = os.DirFS(dir)
+			}
+			problems, err := migrations.Lint(fsys, since)
+			if err != nil {
+				return err
+			}
+			for _, p := range problems {
+				fmt.Fprintln(cmd.OutOrStdout(), p)
+			}
+			if len(problems) > 0 {
+				return fmt.Errorf("migrate lint: problems found: %d", len(problems))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&dir, "dir", "", "directory of migration files to check instead of the built-in ones")
+	cmd.Flags().Int64Var(&since, "since", migrations.LintFrom, "first version whose statements are checked")
+	return cmd
+}
+
+// runMigrateForce implements migrate force.
+func (a *app) runMigrateForce(ctx context.Context, version int64) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	err = a.withSetupLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		return migrations.Force(ctx, conn, a.dbSchema(), version)
+	})
+	if err != nil {
+		return err
+	}
+	slog.Info("schema version forced", "schema", a.dbSchema(), "version", version)
+	return nil
+}
+
+// runMigrateRepair implements migrate repair.
+func (a *app) runMigrateRepair(ctx context.Context) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	var done migrations.Repaired
+	err = a.withSetupLock(ctx, pool, func(conn *pgxpool.Conn) (err error) {
+		done, err = migrations.Repair(ctx, conn, a.dbSchema())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range done.Removed {
+		slog.Info("dirty migration forgotten; migrate runs it again", "name", name)
+	}
+	for _, name := range done.Realigned {
+		slog.Info("checksum recorded", "name", name)
+	}
+	slog.Info("migrations repaired", "schema", a.dbSchema(), "removed", len(done.Removed), "realigned", len(done.Realigned))
+	return nil
+}
+
+// migrationStatus is the report of migrate status.
+type migrationStatus struct {
+	Schema     string            `json:"schema"`
+	Version    int64             `json:"version"`
+	Latest     int64             `json:"latest"`
+	Migrations []migrationRecord `json:"migrations"`
+}
+
+// migrationRecord is one migration of a migrationStatus. Name is empty for
+// a version applied by a newer build, which this one does not embed.
+type migrationRecord struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+	// Dirty is true for a migration that failed part way; see migrate
+	// repair.
+	Dirty bool `json:"dirty"`
+	// Modified is true for an applied migration whose file changed since.
+	Modified bool `json:"modified"`
+}
+
+// runMigrateStatus implements migrate status.
+func (a *app) runMigrateStatus(ctx context.Context) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	all, err := migrations.All()
+	if err != nil {
+		return err
+	}
+	records, err := migrations.Records(ctx, pool, a.dbSchema())
+	if err != nil {
+		return err
+	}
+	status := migrationStatus{Schema: a.dbSchema(), Latest: migrations.Latest(), Migrations: []migrationRecord{}}
+	for _, m := range all {
+		rec := migrationRecord{Version: m.Version, Name: m.Name}
+		if i := slices.IndexFunc(records, func(r migrations.Record) bool { return r.Version == m.Version }); i >= 0 {
+			r := records[i]
+			rec.Applied, rec.Dirty = true, r.Dirty
+			rec.Modified = r.Checksum != "" && r.Checksum != m.Checksum
+		}
+		status.Migrations = append(status.Migrations, rec)
+	}
+	for _, r := range records {
+		if !slices.ContainsFunc(all, func(m migrations.Migration) bool { return m.Version == r.Version }) {
+			status.Migrations = append(status.Migrations, migrationRecord{Version: r.Version, Applied: true, Dirty: r.Dirty})
+		}
+		status.Version = r.Version
+	}
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, status)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS")
+	for _, m := range status.Migrations {
+		name, state := m.Name, "pending"
+		if name == "" {
+			name = "(not in this build)"
+		}
+		switch {
+		case m.Dirty:
+			state = "dirty"
+		case m.Modified:
+			state = "applied, modified since"
+		case m.Applied:
+			state = "applied"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", m.Version, name, state)
+	}
+	tw.Flush()
+	fmt.Printf("%s is at version %d; this build's latest is %d\n", status.Schema, status.Version, status.Latest)
+	return nil
+}
+
+// runMigrate computes a migration plan and either prints it (DRY_RUN) or
+// runs it, logging each step. A plan that runs is computed and applied
+// under the setup lock, so it cannot go stale while another process
+// migrates. A plan that only goes up also creates the tables declared in
+// the configuration file, as applyMigrations does.
+func (a *app) runMigrate(ctx context.Context, plan func(context.Context, migrations.DB) ([]migrations.Step, error)) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if a.cfg().App.DryRun {
+		steps, err := plan(ctx, pool)
+		if err != nil {
+			return err
+		}
+		a.printMigrationSQL(os.Stdout, steps)
+		return nil
+	}
+	err = a.withSetupLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		steps, err := plan(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := a.createUUIDUsersTable(ctx, conn, steps); err != nil {
+			return err
+		}
+		ran, err := a.runMigrationSteps(db.WithoutQueryTimeout(ctx), conn, steps)
+		for _, s := range ran {
+			slog.Info("migrated", "step", s.String(), "version", s.Version)
+		}
+		if err != nil || slices.ContainsFunc(steps, func(s migrations.Step) bool { return s.Down }) {
+			return err
+		}
+		return a.createConfiguredTables(ctx, conn)
+	})
+	if err != nil {
+		return err
+	}
+	current, err := migrations.Current(ctx, pool, a.dbSchema())
+	if err != nil {
+		return err
+	}
+	slog.Info("schema status", "schema", a.dbSchema(), "version", current, "latest", schemaVersion)
+	return nil
+}
+
+// printMigrationPlan writes the SQL of each step, headed by a comment naming it.
+func printMigrationPlan(w io.Writer, steps []migrations.Step) {
+	if len(steps) == 0 {
+		fmt.Fprintln(w, "-- nothing to do")
+		return
+	}
+	for _, s := range steps {
+		fmt.Fprintf(w, "-- %s\n%s\n", s, strings.TrimSpace(s.Statements()))
+	}
+}
@@ -0,0 +1,374 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// consoleCancelGrace is how long the server has to act on the cancel
+// request sent for Ctrl-C before the connection is dropped instead.
+const consoleCancelGrace = 5 * time.Second
+
+// consoleHelp is printed by \?.
+const consoleHelp = `Statements end with a semicolon and may span lines.
+  \d          list tables, views and sequences in DB_SCHEMA
+  \dt         list tables in DB_SCHEMA
+  \d NAME     describe the columns of table NAME (or SCHEMA.NAME)
+  \dn         list schemas
+  \timing     toggle printing how long each statement took
+  \?          show this help
+  \q          quit (or Ctrl-D)
+Ctrl-C cancels the running statement, or discards the input typed so far.
+`
+
+// consoleMetaQueries are the queries behind the listing meta-commands.
+// $1 is DB_SCHEMA.
+var consoleMetaQueries = map[string]string{
+	`\d`: `SELECT c.relname AS "Name",
+	              CASE c.relkind WHEN 'r' THEN 'table' WHEN 'p' THEN 'partitioned table'
+	                             WHEN 'v' THEN 'view' WHEN 'm' THEN 'materialized view'
+	                             WHEN 'S' THEN 'sequence' WHEN 'f' THEN 'foreign table' END AS "Type"
+	         FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	        WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f')
+	        ORDER BY 1`,
+	`\dt`: `SELECT c.relname AS "Name"
+	          FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	         WHERE n.nspname = $1 AND c.relkind IN ('r', 'p')
+	         ORDER BY 1`,
+}
+
+// consoleSchemasSQL lists the schemas other than the system ones.
+const consoleSchemasSQL = `SELECT nspname AS "Name" FROM pg_namespace
+ WHERE nspname !~ '^pg_' AND nspname <> 'information_schema'
+ ORDER BY 1`
+
+// consoleDescribeSQL lists the columns of table $2 in schema $1.
+const consoleDescribeSQL = `SELECT column_name AS "Column", data_type AS "Type",
+       CASE is_nullable WHEN 'NO' THEN 'not null' ELSE '' END AS "Nullable",
+       column_default AS "Default"
+  FROM information_schema.columns
+ WHERE table_schema = $1 AND table_name = $2
+ ORDER BY ordinal_position`
+
+// newConsoleCmd builds the console command.
+func newConsoleCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "console",
+		Short: "Interactive SQL prompt",
+		Long: `An interactive prompt for SQL on the configured database, for when psql is
+not at hand. Statements end with a semicolon and may span several lines;
+rows are printed as a table. The line can be edited, and Up and Down recall
+earlier lines. \? lists the meta-commands, such as \d to list tables.
+
+Statements run on one connection, so SET and BEGIN last until changed.
+Ctrl-C cancels the statement running, leaving the session open. With input
+that is not a terminal, the statements read are run in turn.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runConsole(cmd.Context())
+		},
+	}
+}
+
+// console is the state of one console session.
+type console struct {
+	cfg    *pgx.ConnConfig
+	schema string // DB_SCHEMA, where \dt and \d look
+	conn   *pgx.Conn
+	out    io.Writer
+	timing bool
+}
+
+// runConsole implements the console command.
+func (a *app) runConsole(ctx context.Context) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("console needs PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	// The pool resolves and checks the connection settings; the session
+	// then runs on a connection of its own, on which a cancelled context
+	// sends the server a cancel request instead of closing the connection
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	cfg := pool.Config().ConnConfig
+	pool.Close()
+	cfg.BuildContextWatcherHandler = func(c *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: c, DeadlineDelay: consoleCancelGrace}
+	}
+	c := &console{cfg: cfg, schema: a.dbSchema(), out: os.Stdout}
+	if c.conn, err = pgx.ConnectConfig(ctx, cfg); err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() { c.conn.Close(context.WithoutCancel(ctx)) }()
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return c.runScript(ctx, os.Stdin)
+	}
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer term.Restore(fd, state)
+	return c.runInteractive(ctx, fd)
+}
+
+// runInteractive reads statements from the terminal until \q or Ctrl-D.
+// The terminal stays in raw mode throughout, so Ctrl-C arrives as a key
+// rather than as SIGINT, which would end the program.
+func (c *console) runInteractive(ctx context.Context, fd int) error {
+	keys := newConsoleKeys(ctx, os.Stdin)
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{keys, os.Stdout}, "")
+	if width, height, err := term.GetSize(fd); err == nil {
+		t.SetSize(width, height)
+	}
+	c.out = t
+	fmt.Fprintf(t, "Connected to %s. Type \\? for help.\n", c.cfg.Database)
+
+	var pending string
+	for {
+		c.ensureConnected(ctx)
+		t.SetPrompt(c.prompt(pending != ""))
+		line, err := t.ReadLine()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, term.ErrPasteIndicator) {
+			return err
+		}
+		if keys.takeCtrlC() {
+			pending = ""
+			continue
+		}
+		if pending == "" && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			if c.meta(ctx, strings.TrimSpace(line)) {
+				return nil
+			}
+			continue
+		}
+		stmts, tail := scanStatements(pending + line + "\n")
+		for _, s := range stmts {
+			stmtCtx, cancel := context.WithCancel(ctx)
+			stop := keys.watch(cancel)
+			c.exec(stmtCtx, s.SQL)
+			stop()
+			cancel()
+		}
+		pending = tail.SQL
+	}
+}
+
+// runScript runs the statements and meta-commands read from r, reporting
+// errors and carrying on as psql does. A last statement without a
+// semicolon is run too.
+func (c *console) runScript(ctx context.Context, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	var pending string
+	for scanner.Scan() {
+		c.ensureConnected(ctx)
+		line := scanner.Text()
+		if pending == "" && strings.HasPrefix(strings.TrimSpace(line), `\`) {
+			if c.meta(ctx, strings.TrimSpace(line)) {
+				return nil
+			}
+			continue
+		}
+		stmts, tail := scanStatements(pending + line + "\n")
+		for _, s := range stmts {
+			c.exec(ctx, s.SQL)
+		}
+		pending = tail.SQL
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if s := strings.TrimSpace(pending); s != "" {
+		c.exec(ctx, s)
+	}
+	return ctx.Err()
+}
+
+// prompt returns the prompt, which shows as psql's does whether a
+// statement is being continued and whether a transaction is open or
+// failed.
+func (c *console) prompt(continued bool) string {
+	mark := "="
+	if continued {
+		mark = "-"
+	}
+	switch c.conn.PgConn().TxStatus() {
+	case 'T':
+		mark += "*"
+	case 'E':
+		mark += "!"
+	}
+	return c.cfg.Database + mark + "> "
+}
+
+// exec runs one statement and prints its result or error.
+func (c *console) exec(ctx context.Context, sql string, args ...any) {
+	start := time.Now()
+	err := execStatement(ctx, c.out, c.conn, sql, args...)
+	if err != nil {
+		fmt.Fprintln(c.out, err)
+	}
+	if c.timing {
+		fmt.Fprintf(c.out, "Time: %.3f ms\n", float64(time.Since(start).Microseconds())/1000)
+	}
+}
+
+// ensureConnected replaces a connection that was lost, for instance to a
+// statement that ignored its cancel request, and says so: the settings of
+// the old session are gone.
+func (c *console) ensureConnected(ctx context.Context) {
+	if !c.conn.IsClosed() || ctx.Err() != nil {
+		return
+	}
+	conn, err := pgx.ConnectConfig(ctx, c.cfg)
+	if err != nil {
+		fmt.Fprintf(c.out, "connection lost and reconnecting failed: %v\n", err)
+		return
+	}
+	c.conn = conn
+	fmt.Fprintln(c.out, "connection lost; reconnected with a new session")
+}
+
+// meta runs a meta-command and reports whether it asks to quit.
+func (c *console) meta(ctx context.Context, line string) (quit bool) {
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch {
+	case cmd == `\q`:
+		return true
+	case cmd == `\?`:
+		fmt.Fprint(c.out, consoleHelp)
+	case cmd == `\timing`:
+		c.timing = !c.timing
+		if c.timing {
+			fmt.Fprintln(c.out, "Timing is on.")
+		} else {
+			fmt.Fprintln(c.out, "Timing is off.")
+		}
+	case cmd == `\dn`:
+		c.exec(ctx, consoleSchemasSQL)
+	case cmd == `\d` && arg != "":
+		schema, table, ok := strings.Cut(arg, ".")
+		if !ok {
+			schema, table = c.schema, arg
+		}
+		c.exec(ctx, consoleDescribeSQL, schema, table)
+	case consoleMetaQueries[cmd] != "":
+		c.exec(ctx, consoleMetaQueries[cmd], c.schema)
+	default:
+		fmt.Fprintf(c.out, "invalid command %s; try \\? for help\n", cmd)
+	}
+	return false
+}
+
+// consoleKeys feeds the key presses read from the terminal to the line
+// editor and, while a statement runs, watches them for Ctrl-C. A goroutine
+// does the reading, so a statement can be cancelled while it blocks.
+type consoleKeys struct {
+	ctx    context.Context
+	chunks chan []byte
+	buf    []byte // read but not yet handed to the line editor
+	ctrlC  bool   // Ctrl-C was pressed at the prompt
+}
+
+func newConsoleKeys(ctx context.Context, r io.Reader) *consoleKeys {
+	k := &consoleKeys{ctx: ctx, chunks: make(chan []byte)}
+	go func() {
+		for {
+			b := make([]byte, 256)
+			n, err := r.Read(b)
+			if n > 0 {
+				k.chunks <- b[:n]
+			}
+			if err != nil {
+				close(k.chunks)
+				return
+			}
+		}
+	}()
+	return k
+}
+
+// Read implements io.Reader for the line editor. The editor takes Ctrl-C
+// for end of input, so it is passed on as Enter, which ends the line, and
+// noted for takeCtrlC to report.
+func (k *consoleKeys) Read(p []byte) (int, error) {
+	if len(k.buf) == 0 {
+		select {
+		case <-k.ctx.Done():
+			return 0, io.EOF
+		case chunk, ok := <-k.chunks:
+			if !ok {
+				return 0, io.EOF
+			}
+			k.buf = chunk
+		}
+	}
+	n := copy(p, k.buf)
+	k.buf = k.buf[n:]
+	for i, b := range p[:n] {
+		if b == 3 {
+			p[i] = '\r'
+			k.ctrlC = true
+		}
+	}
+	return n, nil
+}
+
+// takeCtrlC reports whether Ctrl-C ended the line just read, and clears
+// the mark.
+func (k *consoleKeys) takeCtrlC() bool {
+	pressed := k.ctrlC
+	k.ctrlC = false
+	return pressed
+}
+
+// watch calls cancel when Ctrl-C is pressed, until stop is called. Other
+// keys typed meanwhile are kept for the next prompt.
+func (k *consoleKeys) watch(cancel func()) (stop func()) {
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-done:
+				return
+			case chunk, ok := <-k.chunks:
+				if !ok {
+					return
+				}
+				if i := bytes.IndexByte(chunk, 3); i >= 0 {
+					cancel()
+					chunk = append(chunk[:i:i], chunk[i+1:]...)
+				}
+				k.buf = append(k.buf, chunk...)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/secrets"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// secretRefreshInterval is how long credentials fetched from the secrets
+// manager are reused before the next connection fetches them again.
+const secretRefreshInterval = time.Minute
+
+// secretCredentials keeps the user and password read from the secrets
+// manager, or from .env once the server has rejected the ones the app
+// started with, so every connection, including the ones a long-running
+// pool opens hours after startup, uses the current password.
+type secretCredentials struct {
+	mu             sync.Mutex
+	src            secrets.Source
+	fetched        time.Time
+	user, password string
+	// verified is when a connection last authenticated with the
+	// credentials; checking serializes the checks of beforeConnect.
+	verified time.Time
+	checking sync.Mutex
+}
+
+// use selects the secret to read the credentials from, normally
+// Database.Secrets of the app's configuration. The pool's connections call
+// apply from their own goroutines, so the source is kept here rather than
+// read from the configuration, which serve may replace on reload.
+func (c *secretCredentials) use(src secrets.Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.src != src {
+		c.src, c.fetched = src, time.Time{}
+	}
+}
+
+// apply sets the user and password of cfg from the secret selected by use,
+// fetching it when the cached copy is older than secretRefreshInterval.
+// Without a secrets provider it sets the ones refresh re-read from .env,
+// if any. When the secrets manager cannot be reached, the credentials
+// already in cfg are kept and the failure is logged, since they may well
+// still work.
+func (c *secretCredentials) apply(ctx context.Context, cfg *pgconn.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.src.Provider != "" && time.Since(c.fetched) > secretRefreshInterval {
+		if err := c.fetch(ctx); err != nil {
+			slog.Warn("refreshing credentials from the secrets manager failed; using the configured ones", "provider", c.src.Provider, "err", err)
+			return
+		}
+	}
+	if c.user != "" {
+		cfg.User = c.user
+	}
+	if c.password != "" {
+		cfg.Password = c.password
+	}
+}
+
+// fetch reads the credentials: the user and password of CONN_STR when the
+// secret holds one, DB_USER and DB_PASSWORD otherwise.
+func (c *secretCredentials) fetch(ctx context.Context) error {
+	fields, err := c.src.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+	values := config.SecretOverrides(fields)
+	user, password := values["DB_USER"], values["DB_PASSWORD"]
+	if connStr := values["CONN_STR"]; connStr != "" {
+		parsed, err := pgconn.ParseConfig(connStr)
+		if err != nil {
+			return err
+		}
+		user, password = parsed.User, parsed.Password
+	}
+	c.fetched, c.user, c.password = time.Now(), user, password
+	return nil
+}
+
+// expire makes the next apply fetch the secret again.
+func (c *secretCredentials) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fetched = time.Time{}
+}
+
+// refresh reads the credentials again after the server rejected them: the
+// secret with a secrets provider, the user and password of CONN_STR in
+// .env and the environment otherwise.
+func (c *secretCredentials) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.verified = time.Time{}
+	if c.src.Provider != "" {
+		return c.fetch(ctx)
+	}
+	connStr, err := refreshConnString(ctx)
+	if err != nil {
+		return err
+	}
+	parsed, err := pgconn.ParseConfig(connStr)
+	if err != nil {
+		return err
+	}
+	c.fetched, c.user, c.password = time.Now(), parsed.User, parsed.Password
+	return nil
+}
+
+// check authenticates a connection of its own with cfg and closes it,
+// unless one already did within secretRefreshInterval.
+func (c *secretCredentials) check(ctx context.Context, cfg *pgconn.Config) error {
+	c.checking.Lock()
+	defer c.checking.Unlock()
+	c.mu.Lock()
+	recent := time.Since(c.verified) < secretRefreshInterval
+	c.mu.Unlock()
+	if recent {
+		return nil
+	}
+	conn, err := pgconn.ConnectConfig(ctx, cfg.Copy())
+	if err != nil {
+		return err
+	}
+	conn.Close(ctx)
+	c.mu.Lock()
+	c.verified = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// beforeConnect is the pgxpool hook that applies the app's credentials to
+// each new connection of the pool. pgxpool gives up on a connection the
+// server rejects, so with CREDENTIAL_REFRESH the credentials are first
+// checked on a connection of their own, at most once per
+// secretRefreshInterval: when the server rejects them they are re-read and
+// the pool's connection is the single retry, made with the new ones.
+func (a *app) beforeConnect(ctx context.Context, cfg *pgx.ConnConfig) error {
+	a.creds.apply(ctx, &cfg.Config)
+	if !a.cfg().Database.CredentialRefresh {
+		return nil
+	}
+	// Any other failure is the pool connection's to report
+	if err := a.creds.check(ctx, &cfg.Config); err == nil || !isAuthError(err) {
+		return nil
+	}
+	if err := a.creds.refresh(ctx); err != nil {
+		slog.Warn("authentication failed and refreshing the credentials failed", "err", err)
+		return nil
+	}
+	a.creds.apply(ctx, &cfg.Config)
+	slog.Warn("authentication failed; retrying with refreshed credentials")
+	return nil
+}
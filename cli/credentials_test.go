@@ -0,0 +1,157 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/secrets"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// passwordPostgres serves PostgreSQL connections on a local port that
+// authenticate with the cleartext password held by password, and returns
+// the address and a count of the connections it rejected.
+func passwordPostgres(t *testing.T, password *atomic.Value) (string, *atomic.Int32) {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	var rejected atomic.Int32
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend := pgproto3.NewBackend(conn, conn)
+				if _, err := backend.ReceiveStartupMessage(); err != nil {
+					return
+				}
+				backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+				if backend.Flush() != nil || backend.SetAuthType(pgproto3.AuthTypeCleartextPassword) != nil {
+					return
+				}
+				msg, err := backend.Receive()
+				if err != nil {
+					return
+				}
+				if pw, ok := msg.(*pgproto3.PasswordMessage); !ok || pw.Password != password.Load().(string) {
+					rejected.Add(1)
+					backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "28P01", Message: `password authentication failed for user "app"`})
+					backend.Flush()
+					return
+				}
+				backend.Send(&pgproto3.AuthenticationOk{})
+				backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+				backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				for backend.Flush() == nil {
+					msg, err := backend.Receive()
+					if err != nil {
+						return
+					}
+					switch msg.(type) {
+					case *pgproto3.Query:
+						backend.Send(&pgproto3.EmptyQueryResponse{})
+						backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+					case *pgproto3.Terminate:
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return lis.Addr().String(), &rejected
+}
+
+// vaultSecret serves a Vault KV secret whose DB_PASSWORD is held by
+// password and returns the source that reads it.
+func vaultSecret(t *testing.T, password *atomic.Value) secrets.Source {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"DB_PASSWORD": password.Load()}})
+	}))
+	t.Cleanup(srv.Close)
+	return secrets.Source{Provider: secrets.Vault, ID: "secret/app", VaultAddr: srv.URL, VaultToken: "token"}
+}
+
+// pingPool opens a pool on connStr with the app's BeforeConnect hook and
+// pings it, which opens its first connection.
+func pingPool(t *testing.T, a *app, connStr string) error {
+	t.Helper()
+	cfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BeforeConnect = a.beforeConnect
+	pool, err := pgxpool.NewWithConfig(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	return pool.Ping(t.Context())
+}
+
+func TestPoolRetriesRotatedSecret(t *testing.T) {
+	var password, secret atomic.Value
+	password.Store("new")
+	secret.Store("new")
+	addr, rejected := passwordPostgres(t, &password)
+	src := vaultSecret(t, &secret)
+	connStr := fmt.Sprintf("postgres://app:configured@%s/app?sslmode=disable", addr)
+
+	a := newApp(&config.Config{Database: config.Database{ConnStr: connStr, Secrets: src, CredentialRefresh: true}})
+	a.creds.use(src)
+	// The secret was read before it was rotated, and is not due for a
+	// refresh yet
+	a.creds.fetched, a.creds.password = time.Now(), "old"
+	if err := pingPool(t, a, connStr); err != nil {
+		t.Fatalf("pool connection after the secret was rotated: %v", err)
+	}
+	if n := rejected.Load(); n != 1 {
+		t.Errorf("server rejected %d connections, want only the check with the old password", n)
+	}
+
+	// Credentials checked within the minute are not checked again
+	if err := pingPool(t, a, connStr); err != nil {
+		t.Fatal(err)
+	}
+	if n := rejected.Load(); n != 1 {
+		t.Errorf("server rejected %d connections, want 1", n)
+	}
+
+	a.cfg().Database.CredentialRefresh = false
+	a.creds.fetched, a.creds.password, a.creds.verified = time.Now(), "old", time.Time{}
+	if err := pingPool(t, a, connStr); !isAuthError(err) {
+		t.Errorf("pool connection with CREDENTIAL_REFRESH=false = %v, want the authentication failure", err)
+	}
+}
+
+func TestPoolRereadsRotatedEnvPassword(t *testing.T) {
+	var password atomic.Value
+	password.Store("new")
+	addr, rejected := passwordPostgres(t, &password)
+	connStr := fmt.Sprintf("postgres://app:old@%s/app?sslmode=disable", addr)
+	// The password was rotated in the environment after startup
+	t.Setenv("CONN_STR", fmt.Sprintf("postgres://app:new@%s/app?sslmode=disable", addr))
+
+	a := newApp(&config.Config{Database: config.Database{ConnStr: connStr, CredentialRefresh: true}})
+	if err := pingPool(t, a, connStr); err != nil {
+		t.Fatalf("pool connection after the password was rotated: %v", err)
+	}
+	if n := rejected.Load(); n != 1 {
+		t.Errorf("server rejected %d connections, want only the check with the old password", n)
+	}
+}
@@ -0,0 +1,270 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/events"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// newDaemonCmd builds the daemon command.
+func newDaemonCmd(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "daemon",
+		Short: "Run the jobs scheduled in the configuration file until interrupted",
+		Long: `Run the jobs declared under the jobs key of the configuration file, each on
+its cron schedule, until SIGINT or SIGTERM. The tasks are seed (insert the
+sample users, or count generated ones), audit-cleanup (delete users_audit
+entries older than keep), cleanup (delete or archive the users deleted
+before keep_deleted and the audit entries older than keep, and delete the
+idempotency keys older than IDEMPOTENCY_RETENTION), stats (log row counts
+and table sizes) and event-partitions (create the partitions of events for the current month
+and ahead more).
+
+A run holds an advisory lock named after its job, so with several daemons
+running against one database each run happens once; a daemon that finds the
+lock taken skips that run. On shutdown no new run starts, and the runs in
+progress get SHUTDOWN_GRACE to finish before they are cancelled.
+
+As serve does, the daemon reloads its configuration on SIGHUP, and with
+WATCH_CONFIG=true whenever its .env file is written.`,
+		Example: `  go run ./cmd/go-postgres --config config.yaml daemon`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runDaemon(cmd.Context())
+		},
+	}
+}
+
+// runDaemon implements the daemon command.
+func (a *app) runDaemon(ctx context.Context) error {
+	if len(a.cfg().Jobs) == 0 {
+		return errors.New("no jobs are declared; add a jobs section to the configuration file (see --config)")
+	}
+	first, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	pool := newLivePool(first)
+	defer pool.Close()
+	if err := a.migrateUp(ctx, first); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	if !a.sessionLocks() {
+		slog.Warn("job locks are unavailable on CockroachDB and through PgBouncer; run a single daemon, or runs of the same job may overlap")
+	}
+
+	// Runs get a context of their own, which outlives ctx by up to
+	// SHUTDOWN_GRACE so they can finish
+	runCtx, cancelRuns := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelRuns()
+	var wg sync.WaitGroup
+	for _, job := range a.cfg().Jobs {
+		wg.Go(func() { a.scheduleJob(ctx, runCtx, pool, job) })
+	}
+	slog.Info("daemon started", "jobs", len(a.cfg().Jobs))
+
+	changed, hangup, stopSignals := a.reloadSignals()
+	defer stopSignals()
+	for running := true; running; {
+		select {
+		case <-ctx.Done():
+			running = false
+		case <-changed:
+			a.reloadConfig(ctx, pool, false)
+		case <-hangup:
+			a.reloadConfig(ctx, pool, true)
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(a.cfg().App.ShutdownGrace):
+		slog.Warn("jobs still running after the shutdown grace period; cancelling them", "grace", a.cfg().App.ShutdownGrace)
+		cancelRuns()
+		<-done
+	}
+	slog.Info("daemon stopped")
+	return nil
+}
+
+// scheduleJob runs job each time it is due until ctx is done, on the pool
+// current at the time. The next run is scheduled from the end of the last
+// one, so a run that takes longer than the interval makes the job miss its
+// next slots rather than queue them.
+func (a *app) scheduleJob(ctx, runCtx context.Context, pool *livePool, job config.Job) {
+	for {
+		next := job.Next(time.Now())
+		slog.Debug("job scheduled", "job", job.Name, "next", next)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		a.runJob(runCtx, pool.current.Load(), job)
+	}
+}
+
+// runJob runs job once, unless another session is running it. A run that
+// lost its connection, as when the server restarts, runs again on a fresh
+// one; every task may safely be repeated.
+func (a *app) runJob(ctx context.Context, pool *pgxpool.Pool, job config.Job) {
+	if job.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, job.Timeout)
+		defer cancel()
+	}
+	start := time.Now()
+	var ran bool
+	err := retryReconnected(ctx, pool.Reset, anyLost, func() (err error) {
+		ran, err = a.withJobLock(ctx, pool, job.Name, func() error {
+			return a.runTask(ctx, pool, job)
+		})
+		return err
+	})
+	elapsed := time.Since(start).Round(time.Millisecond)
+	switch {
+	case err != nil:
+		slog.Error("job failed", "job", job.Name, "task", job.Task, "elapsed", elapsed, "err", err)
+	case !ran:
+		slog.Info("job skipped; another run holds its lock", "job", job.Name)
+	default:
+		slog.Info("job done", "job", job.Name, "task", job.Task, "elapsed", elapsed)
+	}
+}
+
+// jobLockKey returns the advisory lock of the job named name.
+func jobLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("job:" + name))
+	return int64(h.Sum64())
+}
+
+// withJobLock runs fn holding the advisory lock of job name, and reports
+// false without running it when another session holds the lock. The lock
+// is taken on a connection set aside for it, as in withSetupLock.
+// Without session locks (see sessionLocks) fn always runs.
+func (a *app) withJobLock(ctx context.Context, pool *pgxpool.Pool, name string, fn func() error) (bool, error) {
+	if !a.sessionLocks() {
+		return true, fn()
+	}
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	key := jobLockKey(name)
+	locked, err := db.TryLock(ctx, conn.Conn(), key)
+	if err != nil || !locked {
+		conn.Release()
+		return false, err
+	}
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := db.Unlock(ctx, conn.Conn(), key); err != nil {
+			slog.Warn("releasing job lock failed; closing its connection", "job", name, "err", err)
+			conn.Conn().Close(ctx)
+		}
+		conn.Release()
+	}()
+	return true, fn()
+}
+
+// runTask does the work of job.
+func (a *app) runTask(ctx context.Context, pool *pgxpool.Pool, job config.Job) error {
+	switch job.Task {
+	case config.TaskSeed:
+		records, source := a.seedInput(quickstartOptions{Generate: job.Count, Source: "job:" + job.Name})
+		result, err := a.seedUsers(ctx, a.newUserRepository(pool), records, source)
+		if err != nil {
+			return err
+		}
+		slog.Info("job seed complete", result.attrs("job", job.Name)...)
+		return nil
+	case config.TaskAuditCleanup:
+		return a.cleanupAudit(ctx, pool, job)
+	case config.TaskCleanup:
+		return a.cleanupJob(ctx, pool, job)
+	case config.TaskStats:
+		return a.logTableStats(ctx, pool, job)
+	case config.TaskEventPartitions:
+		if a.isCockroach() {
+			return fmt.Errorf("the events table is created by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+		}
+		created, err := events.NewStore(pool, a.dbSchema()).EnsurePartitions(ctx, time.Now(), job.Ahead)
+		if err != nil {
+			return err
+		}
+		slog.Info("job event partitions complete", "job", job.Name, "created", created)
+		return nil
+	}
+	return fmt.Errorf("unknown task %q", job.Task)
+}
+
+// cleanupAudit deletes the audit entries older than job.Keep.
+func (a *app) cleanupAudit(ctx context.Context, pool *pgxpool.Pool, job config.Job) error {
+	n, err := a.trimTable(ctx, pool, auditRetention(job.Keep), defaultCleanupBatch, false)
+	if err != nil {
+		return err
+	}
+	slog.Info("job audit cleanup complete", "job", job.Name, "deleted", n, "keep", job.Keep)
+	return nil
+}
+
+// cleanupJob removes the soft-deleted users and audit entries past the
+// retention of job, and the idempotency keys past IDEMPOTENCY_RETENTION, as
+// the cleanup command does.
+func (a *app) cleanupJob(ctx context.Context, pool *pgxpool.Pool, job config.Job) error {
+	attrs := []any{"job", job.Name, "archived", job.Archive}
+	targets := []retention{userRetention(job.KeepDeleted), auditRetention(job.Keep), idempotencyRetention(a.cfg().App.IdempotencyKeep)}
+	for _, t := range targets {
+		n, err := a.trimTable(ctx, pool, t, defaultCleanupBatch, job.Archive)
+		if err != nil {
+			return err
+		}
+		attrs = append(attrs, t.table, n)
+	}
+	slog.Info("job cleanup complete", attrs...)
+	return nil
+}
+
+// logTableStats logs the row count and size of the program's tables.
+func (a *app) logTableStats(ctx context.Context, pool *pgxpool.Pool, job config.Job) error {
+	tables := []string{"users", "posts", "users_audit", "users_outbox"}
+	if a.isCockroach() {
+		// The posts, the audit trail and the outbox are PostgreSQL-only
+		tables = tables[:1]
+	}
+	for _, name := range tables {
+		table := pgx.Identifier{a.dbSchema(), name}.Sanitize()
+		var rows int64
+		if err := pool.QueryRow(ctx, "SELECT count(*) FROM "+table).Scan(&rows); err != nil {
+			return fmt.Errorf("counting %s: %w", name, err)
+		}
+		attrs := []any{"job", job.Name, "table", name, "rows", rows}
+		if !a.isCockroach() {
+			var size int64
+			if err := pool.QueryRow(ctx, "SELECT pg_total_relation_size($1::regclass)", table).Scan(&size); err != nil {
+				return fmt.Errorf("sizing %s: %w", name, err)
+			}
+			attrs = append(attrs, "bytes", size)
+		}
+		slog.Info("table stats", attrs...)
+	}
+	return nil
+}
@@ -0,0 +1,523 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// dialPool opens a connection pool on the configured database. It is the
+// openPool of the app, through which every command gets its pool.
+//
+// Pool sizing comes from the environment:
+//
+//	DB_MAX_CONNS           maximum number of open connections
+//	DB_MIN_CONNS           connections kept open even when idle
+//	DB_MAX_CONN_LIFETIME   connections older than this are closed and replaced
+//	DB_MAX_CONN_IDLE_TIME  idle connections older than this are closed
+//
+// Unset or zero values keep the pgxpool defaults. The startup checks done by
+// connect (credential refresh, missing database, server verification) run
+// once on a dedicated connection before the pool is created, so the pool is
+// built from the connection string that actually worked. The first pool a
+// command opens also logs the startup banner; see runStartup.
+// The caller is responsible for closing the pool.
+func (a *app) dialPool(ctx context.Context) (*pgxpool.Pool, error) {
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	connStr := conn.Config().ConnString()
+	conn.Close(ctx)
+
+	pool, err := db.OpenPool(ctx, connStr, func(cfg *pgxpool.Config) error {
+		a.applyStatementCache(cfg.ConnConfig)
+		cfg.ConnConfig.Tracer = a.newDBTracer()
+		a.applySessionSettings(&cfg.ConnConfig.Config)
+		if err := a.applyTLS(&cfg.ConnConfig.Config); err != nil {
+			return err
+		}
+		// Connections opened later pick up a password rotated in the meantime
+		cfg.BeforeConnect = a.beforeConnect
+		return a.applyPoolSettings(cfg)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := a.runStartup(ctx, pool); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// applyPoolSettings copies the DB_* pool settings onto cfg. With
+// DB_ADAPTIVE_POOL the pool also hands out fewer connections while the
+// server refuses new ones for lack of slots; see db.AdaptiveSize.
+func (a *app) applyPoolSettings(cfg *pgxpool.Config) error {
+	if err := db.PoolSize(a.cfg().Pool).Apply(cfg); err != nil {
+		return err
+	}
+	if a.cfg().Database.AdaptivePool {
+		size := db.NewAdaptiveSize()
+		host := cfg.ConnConfig.Host
+		size.Resized = func(from, to int32) {
+			if to < from {
+				slog.Warn("server refuses connections; shrinking the pool", "host", host, "from", from, "to", to)
+				return
+			}
+			slog.Info("growing the pool back", "host", host, "from", from, "to", to)
+		}
+		size.Apply(cfg)
+	}
+	return nil
+}
+
+// connect resolves the configured connection string and opens a single
+// connection to PostgreSQL outside the pool. Most code should use the pool
+// from openPool; a dedicated connection is for work that must not share one,
+// such as measuring connection setup. The caller is responsible for closing it.
+//
+// If the target database does not exist (SQLState 3D000) and
+// AUTO_CREATE_DATABASE is enabled, it is created through the maintenance
+// database and the connection is retried; otherwise a clear error explains
+// how to create it. With AUTO_CREATE_ROLE, a login role for the connection
+// string's user is created the same way when the server rejects it for not
+// existing, and it owns the database created for it.
+//
+// Transient failures (server not up yet, network errors) are retried with
+// exponential backoff; see connectWithRetry.
+//
+// When CREDENTIAL_REFRESH is enabled (the default) and the server rejects
+// the credentials, the configuration is re-read once: if the password was
+// rotated in .env, the environment or the secrets manager since startup, the
+// connection is retried with the new value instead of failing until a
+// restart.
+func (a *app) connect(ctx context.Context) (*pgx.Conn, error) {
+	if a.usesSQLDB() {
+		return nil, fmt.Errorf("this command needs PostgreSQL; with DB_DRIVER=%s only the quickstart and the seed, import, insert, list, user, update-email and export commands are available", a.cfg().Database.Driver)
+	}
+	connStr := a.cfg().Database.ConnStr
+	a.creds.use(a.cfg().Database.Secrets)
+	cfg, err := a.parseConnConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	a.creds.apply(ctx, &cfg.Config)
+	conn, err := a.connectWithRetry(ctx, cfg)
+	if err != nil && isAuthError(err) && a.cfg().Database.CredentialRefresh {
+		fresh, refreshErr := refreshConnString(ctx)
+		if refreshErr != nil {
+			return nil, fmt.Errorf("failed to connect: %w (refreshing credentials: %v)", err, refreshErr)
+		}
+		freshCfg, parseErr := a.parseConnConfig(fresh)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		a.creds.expire()
+		a.creds.apply(ctx, &freshCfg.Config)
+		if fresh != connStr || freshCfg.User != cfg.User || freshCfg.Password != cfg.Password {
+			slog.Warn("authentication failed; retrying with refreshed credentials")
+			cfg = freshCfg
+			conn, err = pgx.ConnectConfig(ctx, cfg)
+		}
+	}
+	if err != nil && isAuthError(err) && a.cfg().Database.AutoCreateRole && a.cfg().App.DryRun {
+		return nil, fmt.Errorf("failed to connect: %w (AUTO_CREATE_ROLE does not create the role under --dry-run)", err)
+	}
+	if err != nil && isAuthError(err) && a.cfg().Database.AutoCreateRole {
+		created, roleErr := a.createRole(ctx, cfg)
+		if roleErr != nil {
+			return nil, fmt.Errorf("failed to connect: %w (%v)", err, roleErr)
+		}
+		if created {
+			conn, err = pgx.ConnectConfig(ctx, cfg)
+		}
+	}
+	if err != nil && isMissingDatabase(err) {
+		if !a.cfg().Database.AutoCreate {
+			return nil, fmt.Errorf("database %q does not exist; create it with `CREATE DATABASE %s;` or set AUTO_CREATE_DATABASE=true",
+				cfg.Database, pgx.Identifier{cfg.Database}.Sanitize())
+		}
+		if a.cfg().App.DryRun {
+			return nil, fmt.Errorf("database %q does not exist; AUTO_CREATE_DATABASE does not create it under --dry-run", cfg.Database)
+		}
+		if err := a.createDatabase(ctx, cfg); err != nil {
+			return nil, err
+		}
+		conn, err = pgx.ConnectConfig(ctx, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if a.cfg().Database.VerifyPostgres {
+		if err := a.verifyPostgres(ctx, conn); err != nil {
+			conn.Close(ctx)
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// connectWithRetry opens a connection, retrying transient failures so the
+// program can wait for a database that is still starting (common with
+// docker-compose) instead of exiting immediately.
+//
+// Up to CONNECT_MAX_ATTEMPTS attempts are made within CONNECT_TIMEOUT in
+// total. The delay between attempts doubles from 500ms up to 10s, with full
+// jitter so that many instances restarting together do not retry in lockstep.
+// Errors that retrying cannot fix, such as bad credentials, fail immediately.
+func (a *app) connectWithRetry(ctx context.Context, cfg *pgx.ConnConfig) (*pgx.Conn, error) {
+	var conn *pgx.Conn
+	err := a.retryConnect(ctx, func(ctx context.Context) error {
+		var err error
+		conn, err = pgx.ConnectConfig(ctx, cfg)
+		return err
+	}, isTransientConnectError)
+	return conn, err
+}
+
+// retryConnect calls dial until it succeeds, fails with an error transient
+// does not accept, or CONNECT_MAX_ATTEMPTS or CONNECT_TIMEOUT run out; see
+// connectWithRetry. It serves every database driver.
+func (a *app) retryConnect(ctx context.Context, dial func(context.Context) error, transient func(error) bool) error {
+	const (
+		baseDelay = 500 * time.Millisecond
+		maxDelay  = 10 * time.Second
+	)
+	attempts := max(1, a.cfg().Database.ConnectMaxAttempts)
+	if timeout := a.cfg().Database.ConnectTimeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		err := dial(ctx)
+		if err == nil || attempt >= attempts || !transient(err) {
+			return err
+		}
+		sleep := rand.N(delay) + time.Millisecond
+		slog.Warn("connection attempt failed", "attempt", attempt, "max_attempts", attempts, "err", err, "retry_in", sleep.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up waiting for the database: %v)", err, ctx.Err())
+		case <-time.After(sleep):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// isTransientConnectError reports whether a failed connection attempt is
+// worth retrying. Network-level failures are; server errors only when the
+// server says it is starting up or out of connection slots.
+func isTransientConnectError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// cannot_connect_now, too_many_connections
+		return pgErr.Code == "57P03" || pgErr.Code == "53300"
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// verifyPostgres runs SELECT version() and checks that the answer comes from
+// PostgreSQL, so pointing the program at some other service fails with an
+// obvious message rather than a protocol error later on.
+func (a *app) verifyPostgres(ctx context.Context, conn *pgx.Conn) error {
+	var version string
+	if err := conn.QueryRow(ctx, "SELECT version()").Scan(&version); err != nil {
+		return fmt.Errorf("this doesn't look like a PostgreSQL server: SELECT version() failed: %w", err)
+	}
+	return a.validateServerVersion(version)
+}
+
+// validateServerVersion checks a version() string such as
+// "PostgreSQL 16.2 on x86_64-pc-linux-gnu, compiled by gcc ..." against
+// DB_DRIVER. CockroachDB speaks the PostgreSQL protocol but reports
+// "CockroachDB CCL v24.1.0 ...", and needs DB_DRIVER=cockroachdb for its
+// transaction retries and DDL differences. YugabyteDB reports
+// "PostgreSQL 11.2-YB-..." and is taken for PostgreSQL.
+func (a *app) validateServerVersion(version string) error {
+	crdb := strings.HasPrefix(version, "CockroachDB ")
+	switch {
+	case a.isCockroach() && !crdb:
+		return fmt.Errorf("DB_DRIVER=%s but the server is not CockroachDB: version() returned %q", config.DriverCockroach, version)
+	case a.isCockroach():
+		return nil
+	case crdb:
+		return fmt.Errorf("the server is CockroachDB; set DB_DRIVER=%s", config.DriverCockroach)
+	}
+	if !strings.HasPrefix(version, "PostgreSQL ") {
+		return fmt.Errorf("this doesn't look like a PostgreSQL server: version() returned %q", version)
+	}
+	return nil
+}
+
+// isCockroach reports whether DB_DRIVER selects CockroachDB.
+func (a *app) isCockroach() bool {
+	return a.cfg().Database.Driver == config.DriverCockroach
+}
+
+// sessionLocks reports whether advisory locks can be held at session
+// level. CockroachDB has none, and through PgBouncer (PGBOUNCER_MODE) the
+// lock would stay behind on whichever server connection took it, so
+// there the setup and job locks are skipped.
+func (a *app) sessionLocks() bool {
+	return !a.isCockroach() && !a.cfg().Database.PgBouncer
+}
+
+// listenUnavailable explains why LISTEN/NOTIFY cannot be used, or returns
+// "" when it can.
+func (a *app) listenUnavailable() string {
+	switch {
+	case a.isCockroach():
+		return fmt.Sprintf("DB_DRIVER=%s does not support it", config.DriverCockroach)
+	case a.cfg().Database.PgBouncer:
+		return "PgBouncer does not keep a session listening between transactions (PGBOUNCER_MODE); connect to the server directly"
+	}
+	return ""
+}
+
+// txRetryPolicy is how withTx and the seed workers rerun work aborted by a
+// serialization failure or a deadlock: up to TX_MAX_ATTEMPTS runs within
+// TX_RETRY_BUDGET, each retry logged as a warning.
+func (a *app) txRetryPolicy(ctx context.Context) db.RetryPolicy {
+	return db.RetryPolicy{
+		MaxAttempts: a.cfg().Database.TxMaxAttempts,
+		Budget:      a.cfg().Database.TxRetryBudget,
+		OnRetry: func(attempt int, err error, wait time.Duration) {
+			slog.WarnContext(ctx, "transaction aborted; retrying", "attempt", attempt, "max_attempts", a.cfg().Database.TxMaxAttempts, "err", err, "retry_in", wait.Round(time.Millisecond))
+		},
+	}
+}
+
+// withTx runs fn in a transaction like db.WithTx, rerunning it with
+// db.RetryTx when it is aborted by a serialization failure, which
+// CockroachDB returns under any contention, or a deadlock. fn must
+// therefore not have effects outside the transaction.
+func (a *app) withTx(ctx context.Context, b db.Beginner, fn func(pgx.Tx) error) error {
+	return db.RetryTx(ctx, b, a.txRetryPolicy(ctx), fn)
+}
+
+// isUnixSocket reports whether host, as pgconn parses it, is the directory
+// of a unix socket rather than a network host.
+func isUnixSocket(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
+
+// serverAddress names the server cfg connects to: host:port, or for a unix
+// socket the path of the socket file, which PostgreSQL names after the
+// port as libpq expects.
+func serverAddress(cfg *pgconn.Config) string {
+	if isUnixSocket(cfg.Host) {
+		return fmt.Sprintf("%s/.s.PGSQL.%d", cfg.Host, cfg.Port)
+	}
+	return net.JoinHostPort(cfg.Host, strconv.Itoa(int(cfg.Port)))
+}
+
+// parseConnConfig parses connStr and applies the connection settings that
+// come from configuration rather than the connection string itself.
+func (a *app) parseConnConfig(connStr string) (*pgx.ConnConfig, error) {
+	cfg, err := pgx.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	a.applyStatementCache(cfg)
+	cfg.Tracer = a.newDBTracer()
+	a.applySessionSettings(&cfg.Config)
+	if err := a.applyTLS(&cfg.Config); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// newDBTracer returns the pgx tracer shared by every connection: it feeds
+// the Prometheus query histogram and the OpenTelemetry spans, and the SQL
+// log when LOG_SQL is enabled, and the slow query log when SLOW_QUERY_MS
+// is set. With QUERY_TIMEOUT it also puts a deadline
+// on every statement; it comes first, so the others time the statement
+// under that deadline.
+//
+// The tracer outlives a reload of the configuration, so the settings a
+// reload applies to open connections are read as each statement starts.
+func (a *app) newDBTracer() pgx.QueryTracer {
+	tracers := []pgx.QueryTracer{liveQueryTimeout{a}, queryTimer{}, dbTracer{}}
+	if a.cfg().App.LogSQL {
+		tracers = append(tracers, sqlLogger{})
+	}
+	tracers = append(tracers, slowQueryLogger{threshold: func() time.Duration { return a.cfg().App.SlowQuery }})
+	return multitracer.New(tracers...)
+}
+
+// liveQueryTimeout is db.QueryTimeout with the QUERY_TIMEOUT in effect
+// when the statement starts.
+type liveQueryTimeout struct{ a *app }
+
+func (t liveQueryTimeout) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return db.QueryTimeout(t.a.cfg().Database.QueryTimeout).TraceQueryStart(ctx, conn, data)
+}
+
+func (liveQueryTimeout) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	db.QueryTimeout(0).TraceQueryEnd(ctx, conn, data)
+}
+
+// applySessionSettings sets STATEMENT_TIMEOUT, LOCK_TIMEOUT and
+// DB_TIMEZONE on the sessions cfg opens. The time zone decides how the
+// server renders and parses timestamptz text, as in exec and console and
+// the JSON of notifications, and where now()::date falls; it is never the
+// server's default, so the output does not change with the host. Unless
+// DB_SCHEMA, or the schema of the TENANT, is public, it puts that schema
+// first on their search_path: the queries of usersdb, generated by sqlc,
+// name their tables without a schema, and functions and ad hoc SQL then
+// resolve there too. The server's default search_path already ends with
+// public, so for public nothing is sent. With RLS_TENANT it sets
+// app.tenant, which the row-level security policy of migration 0020 reads.
+// With READ_ONLY it turns on default_transaction_read_only, so the server
+// itself refuses any write the application lets through.
+func (a *app) applySessionSettings(cfg *pgconn.Config) {
+	db.SessionTimeouts(cfg, a.cfg().Database.StatementTimeout, a.cfg().Database.LockTimeout)
+	if tz := a.cfg().Database.TimeZone; tz != "" {
+		cfg.RuntimeParams["TimeZone"] = tz
+	}
+	if a.dbSchema() != "public" {
+		cfg.RuntimeParams["search_path"] = pgx.Identifier{a.dbSchema()}.Sanitize() + ", public"
+	}
+	if t := a.cfg().Database.RowTenant; t != "" {
+		cfg.RuntimeParams[rowTenantSetting] = t
+	}
+	if a.cfg().Database.ReadOnly {
+		cfg.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+}
+
+// applyStatementCache configures how pgx caches statements.
+//
+// STATEMENT_CACHE_MODE selects the strategy:
+//   - "prepare" (default) prepares each distinct query once per connection and
+//     reuses it. Fastest, but every cached statement holds memory on the server.
+//   - "describe" caches only the parameter and result descriptions and sends
+//     queries unnamed. Slightly slower, but nothing is kept prepared server-side.
+//   - "exec" caches nothing: each query is described, then run, unnamed.
+//   - "simple" uses the simple protocol, one round trip with the arguments
+//     interpolated by pgx.
+//
+// STATEMENT_CACHE_CAPACITY bounds the number of cached entries per
+// connection in the first two modes; the least recently used entry is
+// evicted (and deallocated in prepare mode) when the cache is full. Zero
+// keeps the pgx default.
+// Both values are validated by the config package.
+func (a *app) applyStatementCache(cfg *pgx.ConnConfig) {
+	db.StatementCache(cfg, a.cfg().Database.StatementCacheMode, a.cfg().Database.StatementCacheCapacity)
+}
+
+// refreshConnString reloads the configuration and resolves the connection
+// string again, picking up rotated credentials.
+func refreshConnString(ctx context.Context) (string, error) {
+	// A missing .env is fine: environment variables are read live.
+	fresh, err := config.Load(ctx)
+	if fresh.Database.ConnStr == "" {
+		return "", err
+	}
+	return fresh.Database.ConnStr, nil
+}
+
+// isAuthError reports whether err is the server rejecting the credentials
+// (invalid_password or invalid_authorization_specification).
+func isAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == "28P01" || pgErr.Code == "28000"
+}
+
+// isMissingDatabase reports whether err is the server saying the requested
+// database does not exist (invalid_catalog_name).
+func isMissingDatabase(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "3D000"
+}
+
+// maintenanceConfig returns cfg pointed at the maintenance database
+// (MAINTENANCE_DB, "postgres" by default), as MAINTENANCE_USER when set and
+// with the same credentials otherwise. It has no query tracer, so the
+// password in CREATE ROLE stays out of the SQL log.
+func (a *app) maintenanceConfig(cfg *pgx.ConnConfig) *pgx.ConnConfig {
+	adminCfg := cfg.Copy()
+	adminCfg.Database = a.cfg().Database.MaintenanceDB
+	if user := a.cfg().Database.MaintenanceUser; user != "" {
+		adminCfg.User = user
+		adminCfg.Password = a.cfg().Database.MaintenancePassword
+	}
+	adminCfg.Tracer = nil
+	return adminCfg
+}
+
+// createDatabase connects to the maintenance database and creates the
+// database named in cfg. With AUTO_CREATE_ROLE and a separate
+// MAINTENANCE_USER, cfg's user becomes its owner, so it can run the
+// migrations.
+func (a *app) createDatabase(ctx context.Context, cfg *pgx.ConnConfig) error {
+	adminCfg := a.maintenanceConfig(cfg)
+	admin, err := pgx.ConnectConfig(ctx, adminCfg)
+	if err != nil {
+		return fmt.Errorf("connecting to maintenance database %q to create %q: %w", adminCfg.Database, cfg.Database, err)
+	}
+	defer admin.Close(ctx)
+
+	// CREATE DATABASE cannot take parameters, so the name is quoted as an identifier.
+	stmt := "CREATE DATABASE " + pgx.Identifier{cfg.Database}.Sanitize()
+	if a.cfg().Database.AutoCreateRole && adminCfg.User != cfg.User {
+		stmt += " OWNER " + pgx.Identifier{cfg.User}.Sanitize()
+	}
+	if _, err := admin.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("creating database %q: %w", cfg.Database, err)
+	}
+	slog.Info("database did not exist and was created", "database", cfg.Database)
+	return nil
+}
+
+// createRole connects to the maintenance database and creates cfg's user
+// as a login role with cfg's password, unless it exists already; created
+// reports whether it did. The server rejects a missing role with the same
+// SQLState as a bad password, so pg_roles decides which one it was.
+func (a *app) createRole(ctx context.Context, cfg *pgx.ConnConfig) (created bool, err error) {
+	adminCfg := a.maintenanceConfig(cfg)
+	admin, err := pgx.ConnectConfig(ctx, adminCfg)
+	if err != nil {
+		return false, fmt.Errorf("connecting to maintenance database %q as %q to create role %q: %w", adminCfg.Database, adminCfg.User, cfg.User, err)
+	}
+	defer admin.Close(ctx)
+
+	var exists bool
+	if err := admin.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = $1)", cfg.User).Scan(&exists); err != nil {
+		return false, fmt.Errorf("looking up role %q: %w", cfg.User, err)
+	}
+	if exists {
+		return false, nil
+	}
+	// Like CREATE DATABASE, CREATE ROLE takes no parameters
+	stmt := "CREATE ROLE " + pgx.Identifier{cfg.User}.Sanitize() + " LOGIN"
+	if cfg.Password != "" {
+		stmt += " PASSWORD " + quoteLiteral(cfg.Password)
+	}
+	if _, err := admin.Exec(ctx, stmt); err != nil {
+		return false, fmt.Errorf("creating role %q: %w", cfg.User, err)
+	}
+	slog.Info("role did not exist and was created", "role", cfg.User)
+	return true, nil
+}
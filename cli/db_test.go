@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+)
+
+func TestValidateServerVersion(t *testing.T) {
+	const (
+		postgres  = "PostgreSQL 16.2 on x86_64-pc-linux-gnu, compiled by gcc (GCC) 12.2.0, 64-bit"
+		cockroach = "CockroachDB CCL v24.1.0 (x86_64-pc-linux-gnu, built 2024/05/15 21:28:29, go1.22.2)"
+		// YSQL reports the PostgreSQL version it forked, then its own
+		yugabyte = "PostgreSQL 11.2-YB-2.20.1.0-b0 on x86_64-pc-linux-gnu, compiled by clang version 16.0.6, 64-bit"
+	)
+	tests := []struct {
+		name, driver, version string
+		wantErr               string // a part of the error, or "" for none
+	}{
+		{"postgres", config.DriverPostgres, postgres, ""},
+		{"default driver", "", postgres, ""},
+		{"yugabyte", config.DriverPostgres, yugabyte, ""},
+		{"cockroach", config.DriverCockroach, cockroach, ""},
+		{"cockroach as postgres", config.DriverPostgres, cockroach, "set DB_DRIVER=" + config.DriverCockroach},
+		{"postgres as cockroach", config.DriverCockroach, postgres, "not CockroachDB"},
+		{"yugabyte as cockroach", config.DriverCockroach, yugabyte, "not CockroachDB"},
+		{"other server", config.DriverPostgres, "Redshift 1.0.64928", "doesn't look like a PostgreSQL server"},
+		{"lower case", config.DriverPostgres, "postgresql 16.2", "doesn't look like a PostgreSQL server"},
+		{"empty", config.DriverPostgres, "", "doesn't look like a PostgreSQL server"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApp(&config.Config{Database: config.Database{Driver: tt.driver}})
+			err := a.validateServerVersion(tt.version)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("DB_DRIVER=%s, version %q: %v", tt.driver, tt.version, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("DB_DRIVER=%s, version %q: error %v, want one containing %q", tt.driver, tt.version, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+)
+
+func TestDoctorAggregation(t *testing.T) {
+	// check returns a check named name that reports status
+	check := func(name string, critical bool, status checkStatus) doctorCheck {
+		return doctorCheck{name: name, critical: critical, run: func(context.Context, *doctorEnv) checkResult {
+			return checkResult{Status: status, Detail: name + " detail", Hint: name + " hint"}
+		}}
+	}
+	tests := []struct {
+		name    string
+		checks  []doctorCheck
+		want    []checkStatus
+		exit    int
+		summary string
+	}{
+		{"all pass", []doctorCheck{
+			check("a", true, statusPass), check("b", false, statusPass),
+		}, []checkStatus{statusPass, statusPass}, 0, "2 passed, 0 warnings, 0 failed, 0 skipped"},
+		{"warnings", []doctorCheck{
+			check("a", true, statusWarn), check("b", false, statusWarn),
+		}, []checkStatus{statusWarn, statusWarn}, 0, "0 passed, 2 warnings, 0 failed, 0 skipped"},
+		{"non-critical failure", []doctorCheck{
+			check("a", true, statusPass), check("b", false, statusFail), check("c", true, statusPass),
+		}, []checkStatus{statusPass, statusFail, statusPass}, 0, "2 passed, 0 warnings, 1 failed, 0 skipped"},
+		{"critical failure skips the rest", []doctorCheck{
+			check("a", true, statusPass), check("b", true, statusFail), check("c", true, statusPass), check("d", false, statusPass),
+		}, []checkStatus{statusPass, statusFail, statusSkip, statusSkip}, 1, "1 passed, 0 warnings, 1 failed, 2 skipped"},
+		{"first check fails", []doctorCheck{
+			check("a", true, statusFail), check("b", true, statusFail),
+		}, []checkStatus{statusFail, statusSkip}, 1, "0 passed, 0 warnings, 1 failed, 1 skipped"},
+		{"skipped by the check itself", []doctorCheck{
+			check("a", true, statusSkip), check("b", true, statusPass),
+		}, []checkStatus{statusSkip, statusPass}, 0, "1 passed, 0 warnings, 0 failed, 1 skipped"},
+		{"no checks", nil, nil, 0, "0 passed, 0 warnings, 0 failed, 0 skipped"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := runChecks(context.Background(), tt.checks, &doctorEnv{})
+			var got []checkStatus
+			for i, r := range results {
+				got = append(got, r.Status)
+				if r.Name != tt.checks[i].name || r.Critical != tt.checks[i].critical {
+					t.Errorf("result %d = %+v, want the name and criticality of check %q", i, r, tt.checks[i].name)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("statuses = %v, want %v", got, tt.want)
+			}
+			if exit := doctorExitCode(results); exit != tt.exit {
+				t.Errorf("exit code = %d, want %d", exit, tt.exit)
+			}
+			var buf bytes.Buffer
+			printReport(&buf, results)
+			if !strings.HasSuffix(buf.String(), "\n"+tt.summary+"\n") {
+				t.Errorf("report does not end with %q:\n%s", tt.summary, buf.String())
+			}
+			for _, r := range results {
+				shown := strings.Contains(buf.String(), r.Name+" hint")
+				if want := r.Status == statusWarn || r.Status == statusFail; shown != want {
+					t.Errorf("hint of %s %s shown: %v, want %v", r.Status, r.Name, shown, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDoctorSkipsNamedBlocker(t *testing.T) {
+	ran := false
+	checks := []doctorCheck{
+		{name: "tcp", critical: true, run: func(context.Context, *doctorEnv) checkResult {
+			return checkResult{Status: statusFail}
+		}},
+		{name: "auth", critical: true, run: func(context.Context, *doctorEnv) checkResult {
+			ran = true
+			return checkResult{Status: statusPass}
+		}},
+	}
+	results := runChecks(context.Background(), checks, &doctorEnv{})
+	if ran {
+		t.Error("a check after a failed critical check ran")
+	}
+	if d := results[1].Detail; !strings.Contains(d, `"tcp"`) {
+		t.Errorf("skip detail = %q, want it to name tcp", d)
+	}
+}
+
+func TestDoctorCommandFailsOnInvalidConfig(t *testing.T) {
+	configErr := &config.ValidationError{Problems: []string{"CONN_STR is required"}}
+	cmd := newDoctorCmd(newApp(&config.Config{}), configErr)
+	cmd.SetArgs(nil)
+	cmd.SilenceErrors = true
+	if err := cmd.ExecuteContext(context.Background()); err == nil || !strings.Contains(err.Error(), "critical check failed") {
+		t.Errorf("doctor with invalid settings: error %v", err)
+	}
+}
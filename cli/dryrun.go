@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// dryRunAnnotation marks the commands that honor DRY_RUN (--dry-run).
+// Every other command refuses to run with it, so that --dry-run never
+// goes unnoticed by a command that would write anyway.
+const dryRunAnnotation = "dryRun"
+
+// allowDryRun marks cmd as honoring DRY_RUN and returns it.
+func allowDryRun(cmd *cobra.Command) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[dryRunAnnotation] = "true"
+	return cmd
+}
+
+// checkDryRun fails when DRY_RUN is set for a command that does not honor
+// it, or with a driver whose statements cannot be shown.
+func (a *app) checkDryRun(cmd *cobra.Command) error {
+	if !a.cfg().App.DryRun {
+		return nil
+	}
+	if cmd.Annotations[dryRunAnnotation] == "" {
+		return fmt.Errorf("%s does not support --dry-run; it is honored by the quickstart, migrate, tenant create, seed, insert and exec", cmd.CommandPath())
+	}
+	if a.usesSQLDB() {
+		return fmt.Errorf("--dry-run needs PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	return nil
+}
+
+// paramPattern matches the $n placeholders of a statement.
+var paramPattern = regexp.MustCompile(`\$(\d+)`)
+
+// renderSQL substitutes args into the placeholders of sql as literals, for
+// display only: statements that run always take bind parameters.
+func renderSQL(sql string, args []any) string {
+	return paramPattern.ReplaceAllStringFunc(sql, func(p string) string {
+		n, _ := strconv.Atoi(p[1:])
+		if n < 1 || n > len(args) {
+			return p
+		}
+		return sqlLiteral(args[n-1])
+	})
+}
+
+// sqlLiteral writes v as a SQL literal.
+func sqlLiteral(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case *string:
+		if v == nil {
+			return "NULL"
+		}
+		return quoteLiteral(*v)
+	case string:
+		return quoteLiteral(v)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(v))
+	case int, int32, int64, float64:
+		return fmt.Sprint(v)
+	case time.Time:
+		return quoteLiteral(v.Format(time.RFC3339Nano))
+	case []string:
+		quoted := make([]string, len(v))
+		for i, s := range v {
+			quoted[i] = quoteLiteral(s)
+		}
+		return "ARRAY[" + strings.Join(quoted, ", ") + "]"
+	}
+	return quoteLiteral(fmt.Sprint(v))
+}
+
+// printMigrationSQL prints what running steps executes: the statements of
+// each step, preceded by the UUID users table of ID_TYPE=uuid when the
+// steps create the table, and followed by the tables declared in the
+// configuration file when the steps only go up.
+func (a *app) printMigrationSQL(w io.Writer, steps []migrations.Step) {
+	up := !slices.ContainsFunc(steps, func(s migrations.Step) bool { return s.Down })
+	if up && a.cfg().Database.IDType == config.IDTypeUUID && slices.ContainsFunc(steps, func(s migrations.Step) bool { return s.Version == 1 }) {
+		fmt.Fprintf(w, "-- users table with UUID ids (ID_TYPE=uuid)\n%s;\n", fmt.Sprintf(usersUUIDTableSQL, a.usersTable()))
+	}
+	printMigrationPlan(w, steps)
+	if up {
+		for _, t := range a.cfg().Tables {
+			fmt.Fprintf(w, "-- declared table %s\n%s;\n", t.Name, a.createTableSQL(t))
+		}
+	}
+}
+
+// printPendingMigrationSQL prints the statements that would bring DB_SCHEMA
+// up to date; see printMigrationSQL.
+func (a *app) printPendingMigrationSQL(ctx context.Context, w io.Writer, q migrations.DB) error {
+	steps, err := migrations.PlanUp(ctx, q, a.dbSchema())
+	if err != nil {
+		return err
+	}
+	a.printMigrationSQL(w, steps)
+	return nil
+}
+
+// printSeedSQL prints the inserts seedUsers would send for records, after
+// the same de-duplication and validation. The users these drop are listed
+// as comments. Rows that turn out to exist are skipped or overwritten by
+// the statement's ON CONFLICT clause, as ON_CONFLICT says; the duplicate
+// check of PRECHECK_DUPLICATES and the savepoint and rename of
+// ON_CONFLICT_EMAIL are not shown.
+func (a *app) printSeedSQL(w io.Writer, records []users.User, batchSource string) error {
+	var result seedResult
+	valid, err := a.prepareSeed(records, batchSource, &result)
+	if err != nil {
+		return err
+	}
+	for _, issue := range result.Issues {
+		fmt.Fprintf(w, "-- %s %s: %s\n", issue.Outcome, issue.Username, issue.Reason)
+	}
+	repo := users.NewRepository(nil, a.userRepositoryOptions())
+	for _, u := range valid {
+		sql, args := repo.InsertStatement(u)
+		fmt.Fprintf(w, "%s;\n", renderSQL(sql, args))
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/hozana-dusabimana/vector"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// embeddingsTableName is the table vector setup creates, holding one
+// embedding per user. It is not a migration: it needs the pgvector
+// extension, which most servers do not have.
+const embeddingsTableName = "user_embeddings"
+
+// vectorMetrics maps the --metric names to pgvector's distance operator and
+// the operator class an index needs to serve it.
+var vectorMetrics = map[string]struct{ operator, opclass string }{
+	"cosine": {"<=>", "vector_cosine_ops"},
+	"l2":     {"<->", "vector_l2_ops"},
+	"ip":     {"<#>", "vector_ip_ops"}, // negative inner product, so smaller is closer
+}
+
+func (a *app) embeddingsTable() string {
+	return pgx.Identifier{a.dbSchema(), embeddingsTableName}.Sanitize()
+}
+
+// newVectorCmd builds the vector command group.
+func newVectorCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "vector",
+		Short: "Store embeddings of users and search them by similarity (pgvector)",
+		Long: `An example of similarity search with the pgvector extension: vector setup
+creates a table of one embedding per user with an approximate
+nearest-neighbor index, vector set stores a user's embedding, and vector
+search lists the users closest to a query vector. Vectors are sent and read
+in pgvector's binary format. Needs PostgreSQL with pgvector installed.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.usesSQLDB() || a.isCockroach() {
+				return fmt.Errorf("vector needs PostgreSQL with pgvector, not DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+
+	var dimensions, lists int
+	var index, metric string
+	setup := &cobra.Command{
+		Use:   "setup",
+		Short: "Enable pgvector and create the embeddings table and its index",
+		Long: `Run CREATE EXTENSION IF NOT EXISTS vector, then create the user_embeddings
+table and its index unless they exist. An HNSW index can be built on an
+empty table; an IVFFlat index learns its lists from the rows present, so
+create it once the table holds data (setup --index ivfflat after loading).
+The index serves searches using the --metric it was built for.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runVectorSetup(cmd.Context(), dimensions, index, metric, lists)
+		},
+	}
+	setup.Flags().IntVar(&dimensions, "dimensions", 3, "number of elements of each embedding")
+	setup.Flags().StringVar(&index, "index", "hnsw", "nearest-neighbor index: hnsw, ivfflat or none")
+	setup.Flags().StringVar(&metric, "metric", "cosine", "distance the index serves: cosine, l2 or ip")
+	setup.Flags().IntVar(&lists, "lists", 100, "number of IVFFlat lists; about rows/1000 up to a million rows")
+
+	var key userKey
+	var embedding string
+	set := &cobra.Command{
+		Use:     "set",
+		Short:   "Store the embedding of a user, replacing any previous one",
+		Example: `  go run ./cmd/go-postgres vector set --username alice --embedding 0.1,0.9,0.2`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := vector.Parse(embedding)
+			if err != nil {
+				return err
+			}
+			return a.runVectorSet(cmd.Context(), key, v)
+		},
+	}
+	bindUserKey(set, &key)
+	set.Flags().StringVar(&embedding, "embedding", "", "the embedding, as comma-separated numbers")
+	set.MarkFlagRequired("embedding")
+
+	var query string
+	var limit int
+	var searchMetric string
+	search := &cobra.Command{
+		Use:     "search",
+		Short:   "Print the users whose embeddings are nearest to a vector",
+		Example: `  go run ./cmd/go-postgres vector search --embedding 0.1,0.8,0.3 --limit 5`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v, err := vector.Parse(query)
+			if err != nil {
+				return err
+			}
+			return a.runVectorSearch(cmd.Context(), v, searchMetric, limit)
+		},
+	}
+	search.Flags().StringVar(&query, "embedding", "", "the vector to search near, as comma-separated numbers")
+	search.Flags().StringVar(&searchMetric, "metric", "cosine", "distance to rank by: cosine, l2 or ip")
+	search.Flags().IntVar(&limit, "limit", 10, "print at most this many users")
+	search.MarkFlagRequired("embedding")
+
+	cmd.AddCommand(migratesSchema(setup), set, search)
+	return cmd
+}
+
+// withVectorConn runs fn on a pool connection that knows the vector type.
+func (a *app) withVectorConn(ctx context.Context, fn func(conn *pgxpool.Conn) error) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if err := vector.Register(ctx, conn.Conn()); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// runVectorSetup implements vector setup.
+func (a *app) runVectorSetup(ctx context.Context, dimensions int, index, metric string, lists int) error {
+	m, ok := vectorMetrics[metric]
+	if !ok {
+		return fmt.Errorf("unknown --metric %q: use cosine, l2 or ip", metric)
+	}
+	if dimensions < 1 || dimensions > vector.MaxDimensions {
+		return fmt.Errorf("--dimensions must be between 1 and %d", vector.MaxDimensions)
+	}
+	var indexSQL string
+	switch index {
+	case "hnsw":
+		indexSQL = fmt.Sprintf("USING hnsw (embedding %s)", m.opclass)
+	case "ivfflat":
+		if lists < 1 {
+			return errors.New("--lists must be at least 1")
+		}
+		indexSQL = fmt.Sprintf("USING ivfflat (embedding %s) WITH (lists = %d)", m.opclass, lists)
+	case "none":
+	default:
+		return fmt.Errorf("unknown --index %q: use hnsw, ivfflat or none", index)
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	// The users table's id is bigint or uuid, as ID_TYPE chose when it was
+	// created
+	var idType string
+	if err := pool.QueryRow(ctx, `SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = 'id'`, a.usersTable()).Scan(&idType); err != nil {
+		return fmt.Errorf("reading the type of users.id: %w", err)
+	}
+	return db.WithTx(db.WithoutQueryTimeout(ctx), pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS vector"); err != nil {
+			return fmt.Errorf("enabling pgvector (is it installed on the server?): %w", err)
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			user_id %s PRIMARY KEY REFERENCES %s (id) ON DELETE CASCADE,
+			embedding vector(%d) NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`, a.embeddingsTable(), idType, a.usersTable(), dimensions)); err != nil {
+			return fmt.Errorf("creating %s: %w", embeddingsTableName, err)
+		}
+		if indexSQL != "" {
+			stmt := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s %s",
+				pgx.Identifier{embeddingsTableName + "_" + index + "_" + metric + "_idx"}.Sanitize(), a.embeddingsTable(), indexSQL)
+			if _, err := tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("creating the %s index: %w", index, err)
+			}
+		}
+		slog.Info("embeddings ready", "table", embeddingsTableName, "index", index, "metric", metric)
+		return nil
+	})
+}
+
+// runVectorSet implements vector set.
+func (a *app) runVectorSet(ctx context.Context, key userKey, v vector.Vector) error {
+	return a.withVectorConn(ctx, func(conn *pgxpool.Conn) error {
+		u, err := key.find(ctx, a.newUserRepository(conn))
+		if err != nil {
+			return fmt.Errorf("user %s: %w", key, err)
+		}
+		_, err = conn.Exec(ctx, `INSERT INTO `+a.embeddingsTable()+` (user_id, embedding) VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET embedding = EXCLUDED.embedding, updated_at = now()`,
+			string(u.ID), v)
+		if err != nil {
+			return fmt.Errorf("storing the embedding of %s: %w", u.Username, err)
+		}
+		slog.Info("embedding stored", "username", u.Username, "dimensions", len(v))
+		return nil
+	})
+}
+
+// runVectorSearch implements vector search. The distance operator in the
+// ORDER BY is what lets an index built for metric serve the query.
+func (a *app) runVectorSearch(ctx context.Context, v vector.Vector, metric string, limit int) error {
+	m, ok := vectorMetrics[metric]
+	if !ok {
+		return fmt.Errorf("unknown --metric %q: use cosine, l2 or ip", metric)
+	}
+	return a.withVectorConn(ctx, func(conn *pgxpool.Conn) error {
+		type match struct {
+			ID        users.ID
+			Username  string
+			Email     string
+			Embedding vector.Vector
+			Distance  float64
+		}
+		matches, err := db.Select[match](ctx, conn, fmt.Sprintf(`SELECT u.id, u.username, u.email, e.embedding, e.embedding %[1]s $1 AS distance
+			FROM %[2]s e JOIN %[3]s u ON u.id = e.user_id
+			WHERE u.%[4]s
+			ORDER BY e.embedding %[1]s $1
+			LIMIT $2`, m.operator, a.embeddingsTable(), a.usersTable(), users.NotDeleted), v, limit)
+		if err != nil {
+			return fmt.Errorf("searching embeddings: %w", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tDISTANCE\tEMBEDDING")
+		for _, m := range matches {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%.4f\t%s\n", m.ID, m.Username, m.Email, m.Distance, m.Embedding)
+		}
+		return tw.Flush()
+	})
+}
@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/events"
+	"github.com/spf13/cobra"
+)
+
+// newEventsCmd builds the events command group, which writes and reads the
+// partitioned events table of migration 0019 and creates its partitions.
+func newEventsCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "events",
+		Short: "Append events to the partitioned events table and read them back",
+		Long: `An example of a high-volume, append-only table: events is partitioned by
+UTC month of created_at, one partition per month named events_YYYY_MM.
+events emit appends an event, events list reads the recent ones, and
+events partitions create adds the partitions of the coming months, which
+must exist before their events arrive; schedule it, or the
+event-partitions job of the daemon, to run regularly. Needs PostgreSQL.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.usesSQLDB() || a.isCockroach() {
+				return fmt.Errorf("the events table is created by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+
+	var e events.Event
+	var payload, at string
+	emit := &cobra.Command{
+		Use:     "emit",
+		Short:   "Append one event",
+		Example: `  go run ./cmd/go-postgres events emit --name signup --payload '{"username": "alice"}'`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e.Payload = json.RawMessage(payload)
+			if at != "" {
+				t, err := time.Parse(time.RFC3339Nano, at)
+				if err != nil {
+					return fmt.Errorf("--at %q is not an RFC 3339 time", at)
+				}
+				e.CreatedAt = t
+			}
+			return a.withEventStore(cmd.Context(), func(ctx context.Context, store *events.Store) error {
+				if err := store.Insert(ctx, &e); err != nil {
+					if errors.Is(err, events.ErrNoPartition) {
+						return fmt.Errorf("%w (run events partitions create)", err)
+					}
+					return fmt.Errorf("appending event: %w", err)
+				}
+				slog.Info("event appended", "name", e.Name, "id", e.ID, "partition", events.PartitionName(e.CreatedAt))
+				return nil
+			})
+		},
+	}
+	emit.Flags().StringVar(&e.Name, "name", "", "name of the event")
+	emit.Flags().StringVar(&payload, "payload", "", "JSON document stored with the event (default {})")
+	emit.Flags().StringVar(&at, "at", "", "time of the event, RFC 3339 (default now)")
+	emit.MarkFlagRequired("name")
+
+	var filter events.Filter
+	var since time.Duration
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Print recent events, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if since > 0 {
+				filter.Since = time.Now().Add(-since)
+			}
+			return a.withEventStore(cmd.Context(), func(ctx context.Context, store *events.Store) error {
+				es, err := store.List(ctx, filter)
+				if err != nil {
+					return fmt.Errorf("listing events: %w", err)
+				}
+				return a.printEvents(es)
+			})
+		},
+	}
+	list.Flags().StringVar(&filter.Name, "name", "", "print only the events with this name")
+	list.Flags().DurationVar(&since, "since", 24*time.Hour, "print only the events of this last period, which spares the older partitions (0 for all)")
+	list.Flags().IntVar(&filter.Limit, "limit", 20, "print at most this many events (0 for all)")
+
+	cmd.AddCommand(emit, list, newEventPartitionsCmd(a))
+	return cmd
+}
+
+// newEventPartitionsCmd builds events partitions and its subcommands.
+func newEventPartitionsCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "partitions",
+		Short: "Create and list the monthly partitions of events",
+	}
+
+	var ahead int
+	create := &cobra.Command{
+		Use:     "create",
+		Short:   "Create the partitions of this month and the next ones that are missing",
+		Example: `  go run ./cmd/go-postgres events partitions create --ahead 3`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withEventStore(cmd.Context(), func(ctx context.Context, store *events.Store) error {
+				created, err := store.EnsurePartitions(ctx, time.Now(), ahead)
+				for _, name := range created {
+					slog.Info("partition created", "partition", name)
+				}
+				if err != nil {
+					return err
+				}
+				if len(created) == 0 {
+					slog.Info("partitions already exist", "ahead", ahead)
+				}
+				return nil
+			})
+		},
+	}
+	create.Flags().IntVar(&ahead, "ahead", 3, "also create the partitions of this many months after the current one")
+
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Print the partitions of events with their bounds and estimated rows",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withEventStore(cmd.Context(), func(ctx context.Context, store *events.Store) error {
+				parts, err := store.Partitions(ctx)
+				if err != nil {
+					return fmt.Errorf("listing partitions: %w", err)
+				}
+				return a.printPartitions(parts)
+			})
+		},
+	}
+
+	cmd.AddCommand(create, list)
+	return cmd
+}
+
+// withEventStore opens a pool and runs fn with an events store over it.
+func (a *app) withEventStore(ctx context.Context, fn func(context.Context, *events.Store) error) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return fn(ctx, events.NewStore(pool, a.dbSchema()))
+}
+
+// printEvents writes es as a table, or as a document with --output json or
+// yaml.
+func (a *app) printEvents(es []events.Event) error {
+	if a.structuredOutput() {
+		if es == nil {
+			es = []events.Event{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"events": es})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED AT\tNAME\tPAYLOAD")
+	for _, e := range es {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.ID, a.displayTime(e.CreatedAt).Format(displayTimeLayout), e.Name, e.Payload)
+	}
+	return tw.Flush()
+}
+
+// printPartitions writes parts as a table, or as a document with --output
+// json or yaml.
+func (a *app) printPartitions(parts []events.Partition) error {
+	if a.structuredOutput() {
+		if parts == nil {
+			parts = []events.Partition{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"partitions": parts})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PARTITION\tROWS (EST.)\tBOUNDS")
+	for _, p := range parts {
+		rows := "-"
+		if p.Rows >= 0 {
+			rows = fmt.Sprint(p.Rows)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.Name, rows, p.Bounds)
+	}
+	return tw.Flush()
+}
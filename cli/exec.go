@@ -0,0 +1,336 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// execOptions are the flags of the exec command.
+type execOptions struct {
+	File     string
+	Query    string
+	Tx       bool
+	Continue bool
+}
+
+// sqlStatement is one statement of a script, with the line it starts on.
+type sqlStatement struct {
+	SQL  string
+	Line int
+}
+
+// splitStatements splits script at the semicolons that end statements. A
+// semicolon inside a quoted string or identifier, a dollar-quoted body or
+// a comment does not end one. Statements holding only comments are left
+// out, and the last one needs no semicolon. psql meta-commands such as \i
+// are not understood and reach the server as they are.
+func splitStatements(script string) []sqlStatement {
+	stmts, tail := scanStatements(script)
+	if tail.SQL != "" {
+		tail.SQL = strings.TrimSpace(tail.SQL)
+		stmts = append(stmts, tail)
+	}
+	return stmts
+}
+
+// scanStatements returns the statements of script that a semicolon ends,
+// as splitStatements does, and the text after the last of them as tail.
+// tail is kept as written, possibly within an open string or comment, and
+// is empty when it holds only white space and comments.
+func scanStatements(script string) (stmts []sqlStatement, tail sqlStatement) {
+	start, line, startLine := 0, 1, 1
+	content := false // whether the current statement has more than comments
+	flush := func(end int) {
+		if content {
+			stmts = append(stmts, sqlStatement{SQL: strings.TrimSpace(script[start:end]), Line: startLine})
+		}
+		start, content = end+1, false
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		if c == '\n' {
+			line++
+		}
+		if !content && !isSpace(c) && !strings.HasPrefix(script[i:], "--") && !strings.HasPrefix(script[i:], "/*") {
+			content, startLine = true, line
+		}
+		switch {
+		case c == ';':
+			flush(i)
+		case c == '\'' || c == '"':
+			// E'...' strings escape quotes with a backslash as well as by
+			// doubling them
+			escapes := c == '\'' && i > 0 && (script[i-1] == 'E' || script[i-1] == 'e')
+			for i++; i < len(script); i++ {
+				if script[i] == '\n' {
+					line++
+				}
+				if escapes && script[i] == '\\' {
+					i++
+					continue
+				}
+				if script[i] == c {
+					if i+1 < len(script) && script[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+		case strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end - 1
+			}
+		case strings.HasPrefix(script[i:], "/*"):
+			// Block comments nest in PostgreSQL
+			depth := 0
+			for ; i < len(script); i++ {
+				switch {
+				case script[i] == '\n':
+					line++
+				case strings.HasPrefix(script[i:], "/*"):
+					depth++
+					i++
+				case strings.HasPrefix(script[i:], "*/"):
+					depth--
+					i++
+				}
+				if depth == 0 {
+					break
+				}
+			}
+		case c == '$' && (i == 0 || !isIdentChar(script[i-1])):
+			tag := dollarTag(script[i:])
+			if tag == "" {
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			body := script[i:]
+			if end >= 0 {
+				body = script[i : i+len(tag)+end+len(tag)]
+			}
+			line += strings.Count(body, "\n")
+			i += len(body) - 1
+		}
+	}
+	if content {
+		tail = sqlStatement{SQL: script[start:], Line: startLine}
+	}
+	return stmts, tail
+}
+
+// dollarTag returns the $tag$ or $$ that s starts with, or "" when the $
+// starts something else, such as a $1 parameter.
+func dollarTag(s string) string {
+	for j := 1; j < len(s); j++ {
+		switch c := s[j]; {
+		case c == '$':
+			return s[:j+1]
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || j > 1 && c >= '0' && c <= '9':
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// newExecCmd builds the exec command.
+func newExecCmd(a *app) *cobra.Command {
+	var opts execOptions
+	cmd := &cobra.Command{
+		Use:   "exec",
+		Short: "Run the statements of a SQL file, or a single statement",
+		Long: `Run SQL without psql. The statements of --file run one after another on one
+connection, so SET, temporary tables and a BEGIN ... COMMIT in the file
+carry from one statement to the next. Rows returned are printed as a table,
+and other statements print their command tag, e.g. INSERT 0 3.
+
+By default the first failing statement stops the script. --continue runs the
+rest and fails at the end. --tx wraps the whole script in one transaction,
+committed only if every statement succeeds; with --continue as well, each
+statement runs in a savepoint and those that fail are undone while the
+others are committed.`,
+		Example: `  go run ./cmd/go-postgres exec --file fixtures.sql --tx
+  go run ./cmd/go-postgres exec --query "SELECT count(*) FROM users"
+  cat fixtures.sql | go run ./cmd/go-postgres exec --file -`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runExec(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.File, "file", "", `SQL file to run, or "-" for stdin`)
+	cmd.Flags().StringVar(&opts.Query, "query", "", "statement to run")
+	cmd.Flags().BoolVar(&opts.Tx, "tx", false, "run everything in one transaction")
+	cmd.Flags().BoolVar(&opts.Continue, "continue", false, "keep going after a failing statement")
+	cmd.MarkFlagsOneRequired("file", "query")
+	cmd.MarkFlagsMutuallyExclusive("file", "query")
+	return allowDryRun(cmd)
+}
+
+// runExec implements the exec command.
+func (a *app) runExec(ctx context.Context, opts execOptions) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("exec needs PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	script := opts.Query
+	if opts.File != "" {
+		var b []byte
+		var err error
+		if opts.File == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(opts.File)
+		}
+		if err != nil {
+			return err
+		}
+		script = string(b)
+	}
+	stmts := splitStatements(script)
+	if len(stmts) == 0 {
+		return errors.New("no statements to run")
+	}
+	if a.cfg().App.DryRun {
+		for _, s := range stmts {
+			fmt.Printf("%s;\n", s.SQL)
+		}
+		return nil
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if !opts.Tx {
+		return execStatements(ctx, stmts, opts.Continue, func(s sqlStatement) error {
+			return execStatement(ctx, os.Stdout, conn, s.SQL)
+		})
+	}
+	var failed error
+	err = db.WithTx(ctx, conn, func(tx pgx.Tx) error {
+		failed = execStatements(ctx, stmts, opts.Continue, func(s sqlStatement) error {
+			if !opts.Continue {
+				return execStatement(ctx, os.Stdout, tx, s.SQL)
+			}
+			// A savepoint keeps the failure from aborting the transaction
+			return db.WithTx(ctx, tx, func(sp pgx.Tx) error {
+				return execStatement(ctx, os.Stdout, sp, s.SQL)
+			})
+		})
+		if opts.Continue {
+			return nil
+		}
+		return failed
+	})
+	switch {
+	case failed != nil && !opts.Continue:
+		return fmt.Errorf("%w; nothing was committed", failed)
+	case err != nil:
+		return fmt.Errorf("committing: %w", err)
+	case failed != nil:
+		return fmt.Errorf("%w; the other statements were committed", failed)
+	}
+	return nil
+}
+
+// execStatements runs each statement with run, stopping at the first
+// failure unless keepGoing is set, in which case every failure is reported
+// as it happens and the count is returned at the end.
+func execStatements(ctx context.Context, stmts []sqlStatement, keepGoing bool, run func(sqlStatement) error) error {
+	var failures int
+	for i, s := range stmts {
+		err := run(s)
+		if err == nil {
+			continue
+		}
+		err = fmt.Errorf("statement %d (line %d): %w", i+1, s.Line, err)
+		if !keepGoing || ctx.Err() != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		failures++
+	}
+	if failures > 0 {
+		return fmt.Errorf("%d of %d statements failed", failures, len(stmts))
+	}
+	return nil
+}
+
+// execStatement runs sql and writes its rows to w, or its command tag when
+// it returns none. The simple protocol is used so every value arrives as
+// the server's text, as psql shows it, whatever its type; args, if any, are
+// interpolated by pgx.
+func execStatement(ctx context.Context, w io.Writer, q users.Querier, sql string, args ...any) error {
+	rows, err := q.Query(ctx, sql, append([]any{pgx.QueryExecModeSimpleProtocol}, args...)...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	fields := rows.FieldDescriptions()
+	if len(fields) == 0 {
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, rows.CommandTag())
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, f := range fields {
+		if i > 0 {
+			fmt.Fprint(tw, "\t")
+		}
+		fmt.Fprint(tw, f.Name)
+	}
+	fmt.Fprintln(tw)
+	var n int
+	for rows.Next() {
+		for i, v := range rows.RawValues() {
+			if i > 0 {
+				fmt.Fprint(tw, "\t")
+			}
+			if v == nil {
+				fmt.Fprint(tw, "NULL")
+			} else {
+				fmt.Fprint(tw, string(v))
+			}
+		}
+		fmt.Fprintln(tw)
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "(%d rows)\n", n)
+	return nil
+}
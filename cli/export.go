@@ -0,0 +1,517 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// exportColumns lists the users columns export can write, in table order.
+// profile exists only in the PostgreSQL schema.
+var exportColumns = []string{"id", "username", "email", "created_at", "updated_at", "source", "profile", "version", "deleted_at"}
+
+// exportTimeLayout formats timestamps read through database/sql the way
+// PostgreSQL's COPY writes a timestamptz in a UTC session, so with the
+// default DB_TIMEZONE a CSV looks the same whatever the driver.
+const exportTimeLayout = "2006-01-02 15:04:05.999999-07"
+
+// exportFormats lists the values of export --format.
+var exportFormats = []string{"csv", "ndjson"}
+
+// exportOptions are the flags of the export command.
+type exportOptions struct {
+	Format  string
+	Out     string
+	Columns []string
+	Header  bool // csv only
+	// IncludeDeleted writes soft-deleted users as well.
+	IncludeDeleted bool
+	// Where is an SQL condition the rows must also meet, written as is into
+	// the query.
+	Where string
+}
+
+// newExportCmd builds the export command.
+func newExportCmd(a *app) *cobra.Command {
+	var opts exportOptions
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the users table to a CSV or NDJSON file",
+		Long: `Stream the users table, oldest first, to a CSV file. With PostgreSQL and
+CockroachDB the server writes the CSV itself with COPY TO; with MySQL and
+SQLite the rows are read and written one at a time. Either way memory use
+stays flat however large the table is.
+
+With --format ndjson each user is written as it is read as one JSON object
+per line, its keys the --columns in order: ids and versions are numbers
+(UUID ids strings), timestamps RFC 3339 in UTC, the profile an object and
+NULL null. The file defaults to users.ndjson; "--out -" writes to stdout
+for piping into jq or a bulk loader.
+
+--where narrows the export, of either format, to the rows that also meet an
+SQL condition on the users columns. The condition must not end the query it
+is written into: semicolons, comments and unbalanced parentheses or quotes
+are refused. The rows are then read in a read-only transaction, with a
+SELECT rather than COPY on PostgreSQL and CockroachDB, so the condition
+cannot change anything even through a function it calls.`,
+		Example: `  go run ./cmd/go-postgres export
+  go run ./cmd/go-postgres export --format ndjson --out - --where "created_at >= '2025-01-01'" | jq .email`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Format == "ndjson" && !cmd.Flags().Changed("out") {
+				opts.Out = "users.ndjson"
+			}
+			return a.runExport(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Format, "format", "csv", "output format ("+strings.Join(exportFormats, " or ")+")")
+	cmd.Flags().StringVar(&opts.Out, "out", "users.csv", `file to write, or "-" for stdout; users.ndjson with --format ndjson`)
+	cmd.Flags().StringSliceVar(&opts.Columns, "columns", exportColumns[:6], "columns to write, in this order (one of "+strings.Join(exportColumns, ", ")+")")
+	cmd.Flags().BoolVar(&opts.Header, "header", true, "write the column names as the first line (csv)")
+	cmd.Flags().BoolVar(&opts.IncludeDeleted, "include-deleted", false, "write soft-deleted users as well")
+	cmd.Flags().StringVar(&opts.Where, "where", "", "SQL condition the exported users must also meet")
+	return cmd
+}
+
+// runExport implements the export command. A file that was only partly
+// written is removed, so a failed export never leaves a truncated file
+// that looks complete.
+func (a *app) runExport(ctx context.Context, opts exportOptions) (err error) {
+	if !slices.Contains(exportFormats, opts.Format) {
+		return fmt.Errorf("unsupported --format %q (one of %s)", opts.Format, strings.Join(exportFormats, ", "))
+	}
+	if len(opts.Columns) == 0 {
+		return fmt.Errorf("--columns must name at least one column")
+	}
+	for _, c := range opts.Columns {
+		if !slices.Contains(exportColumns, c) {
+			return fmt.Errorf("unknown column %q in --columns (one of %s)", c, strings.Join(exportColumns, ", "))
+		}
+	}
+	if a.usesSQLDB() && slices.Contains(opts.Columns, "profile") {
+		return fmt.Errorf("the profile column does not exist with DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	if err := checkWhere(opts.Where); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if opts.Out != "-" {
+		f, err := os.Create(opts.Out)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(opts.Out)
+			}
+		}()
+		w = f
+	}
+
+	// Each row is one line in both formats, after the CSV header if any
+	prog := a.startProgress(ctx, "export", "rows", 0)
+	defer prog.Finish()
+	pw := &progressWriter{w: w, p: prog}
+	if opts.Format == "csv" && opts.Header {
+		pw.skip = 1
+	}
+	w = pw
+
+	var n int64
+	if a.usesSQLDB() {
+		db, err := a.openSQLDB(ctx)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+		if n, err = a.exportSQL(ctx, db, w, opts); err != nil {
+			return fmt.Errorf("export failed: %w", err)
+		}
+	} else {
+		pool, err := a.openPool(ctx)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+		if n, err = a.exportPostgres(ctx, pool, w, opts); err != nil {
+			return fmt.Errorf("export failed: %w", err)
+		}
+	}
+	prog.Finish()
+	slog.Info("users exported", "users", n, "format", opts.Format, "columns", strings.Join(opts.Columns, ","), "path", opts.Out)
+	return nil
+}
+
+// checkWhere refuses a --where condition that could reach past the
+// parentheses exportWhere puts it in: one with a semicolon, a comment or a
+// closing parenthesis without its opening one outside quotes, or with a
+// quote or parenthesis left open. It reads quotes as PostgreSQL and SQLite
+// do, doubled to escape them; dollar quotes are not recognised, so a
+// semicolon in one is refused too.
+func checkWhere(where string) error {
+	depth := 0
+	for i := 0; i < len(where); i++ {
+		switch c := where[i]; c {
+		case '\'', '"', '`':
+			end := i + 1
+			for ; end < len(where); end++ {
+				if where[end] != c {
+					continue
+				}
+				if end+1 < len(where) && where[end+1] == c {
+					end++ // doubled, so part of the quoted text
+					continue
+				}
+				break
+			}
+			if end >= len(where) {
+				return fmt.Errorf("--where has an unterminated %c quote", c)
+			}
+			i = end
+		case ';':
+			return errors.New("--where must be a single condition; it has a semicolon outside quotes")
+		case '-', '/':
+			if strings.HasPrefix(where[i:], "--") || strings.HasPrefix(where[i:], "/*") {
+				return errors.New("--where must not contain comments")
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth--; depth < 0 {
+				return errors.New("--where closes a parenthesis it did not open")
+			}
+		}
+	}
+	if depth > 0 {
+		return errors.New("--where leaves a parenthesis open")
+	}
+	return nil
+}
+
+// exportWhere returns the WHERE clause of the rows opts exports.
+func exportWhere(opts exportOptions) string {
+	var conds []string
+	if !opts.IncludeDeleted {
+		conds = append(conds, users.NotDeleted)
+	}
+	if opts.Where != "" {
+		conds = append(conds, "("+opts.Where+")")
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// exportQuery returns the SELECT of the rows opts exports from table.
+func exportQuery(opts exportOptions, table string) string {
+	// The column names were checked against exportColumns, so they are
+	// safe to splice in
+	return exportSelect(opts, table, strings.Join(opts.Columns, ", "))
+}
+
+// exportTextQuery is exportQuery with each column cast to text, which
+// PostgreSQL renders as COPY does.
+func exportTextQuery(opts exportOptions, table string) string {
+	list := make([]string, len(opts.Columns))
+	for i, c := range opts.Columns {
+		list[i] = c + "::text"
+	}
+	return exportSelect(opts, table, strings.Join(list, ", "))
+}
+
+func exportSelect(opts exportOptions, table, list string) string {
+	return "SELECT " + list + " FROM " + table + exportWhere(opts) + " ORDER BY created_at, id"
+}
+
+// exportPostgres writes the export of opts from pool. Without --where the
+// CSV comes from COPY TO. COPY is sent over the simple protocol, which
+// runs every statement in the text it is given, so with a condition both
+// formats are read with a SELECT over the extended protocol, which runs
+// one, in a read-only transaction.
+func (a *app) exportPostgres(ctx context.Context, pool *pgxpool.Pool, w io.Writer, opts exportOptions) (n int64, err error) {
+	if opts.Where == "" {
+		if opts.Format == "ndjson" {
+			return a.exportPgxNDJSON(ctx, pool, w, opts)
+		}
+		return a.exportCopyCSV(ctx, pool, w, opts)
+	}
+	err = pgx.BeginTxFunc(ctx, pool, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) (err error) {
+		if opts.Format == "ndjson" {
+			n, err = a.exportPgxNDJSON(ctx, tx, w, opts)
+		} else {
+			n, err = a.exportSelectCSV(ctx, tx, w, opts)
+		}
+		return err
+	})
+	return n, err
+}
+
+// exportCopyCSV has the server render the CSV with COPY TO STDOUT and
+// streams it to w as it arrives.
+func (a *app) exportCopyCSV(ctx context.Context, pool *pgxpool.Pool, w io.Writer, opts exportOptions) (int64, error) {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+	query := fmt.Sprintf("COPY (%s) TO STDOUT WITH (FORMAT csv, HEADER %t)", exportQuery(opts, a.usersTable()), opts.Header)
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, w, query)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// exportSelectCSV is exportCopyCSV for a --where condition: it reads the
+// columns as text with a SELECT and writes each row with encoding/csv as
+// it is read.
+func (a *app) exportSelectCSV(ctx context.Context, q users.Querier, w io.Writer, opts exportOptions) (int64, error) {
+	rows, err := q.Query(ctx, exportTextQuery(opts, a.usersTable()), pgx.QueryExecModeExec)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if opts.Header {
+		cw.Write(opts.Columns)
+	}
+	values := make([]*string, len(opts.Columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(values))
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			record[i] = ""
+			if v != nil {
+				record[i] = *v
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// sqlQuerier is what the database/sql exports read from: a *sql.DB, or
+// the *sql.Conn or *sql.Tx exportSQL guards a --where condition with.
+type sqlQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// exportSQL writes the export of opts from db. database/sql hands the
+// query to the driver as it is, and SQLite runs every statement in it,
+// so a --where condition is read on a connection with query_only set, on
+// which SQLite refuses to write, and on MySQL in a read-only transaction.
+func (a *app) exportSQL(ctx context.Context, db *sql.DB, w io.Writer, opts exportOptions) (n int64, err error) {
+	export := exportSQLCSV
+	if opts.Format == "ndjson" {
+		export = exportSQLNDJSON
+	}
+	if opts.Where == "" {
+		return export(ctx, db, w, opts)
+	}
+	if a.cfg().Database.Driver == config.DriverSQLite {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return 0, err
+		}
+		defer conn.ExecContext(context.WithoutCancel(ctx), "PRAGMA query_only = OFF")
+		return export(ctx, conn, w, opts)
+	}
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	return export(ctx, tx, w, opts)
+}
+
+// exportSQLCSV is exportCopyCSV for the database/sql drivers, which have
+// no COPY: it writes each row with encoding/csv as it is read.
+func exportSQLCSV(ctx context.Context, db sqlQuerier, w io.Writer, opts exportOptions) (int64, error) {
+	rows, err := db.QueryContext(ctx, exportQuery(opts, "users"))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	cw := csv.NewWriter(w)
+	if opts.Header {
+		cw.Write(opts.Columns)
+	}
+	values := make([]any, len(opts.Columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	record := make([]string, len(values))
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		for i, v := range values {
+			record[i] = csvField(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	cw.Flush()
+	return n, cw.Error()
+}
+
+// csvField renders a value scanned from database/sql as CSV text. NULL
+// becomes an empty field, as in PostgreSQL's CSV format.
+func csvField(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case time.Time:
+		return v.UTC().Format(exportTimeLayout)
+	case []byte:
+		return string(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// exportRows is what writeNDJSON reads from: pgx.Rows and *sql.Rows both
+// satisfy it.
+type exportRows interface {
+	Next() bool
+	Scan(dest ...any) error
+	Err() error
+}
+
+// exportPgxNDJSON streams the rows opts exports from q to w as NDJSON.
+// The query goes over the extended protocol, even with
+// STATEMENT_CACHE_MODE=simple.
+func (a *app) exportPgxNDJSON(ctx context.Context, q users.Querier, w io.Writer, opts exportOptions) (int64, error) {
+	rows, err := q.Query(ctx, exportQuery(opts, a.usersTable()), pgx.QueryExecModeExec)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	return writeNDJSON(w, rows, opts.Columns)
+}
+
+// exportSQLNDJSON is exportPgxNDJSON for the database/sql drivers.
+func exportSQLNDJSON(ctx context.Context, db sqlQuerier, w io.Writer, opts exportOptions) (int64, error) {
+	rows, err := db.QueryContext(ctx, exportQuery(opts, "users"))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	return writeNDJSON(w, rows, opts.Columns)
+}
+
+// writeNDJSON writes each row of rows to w as it is read, as a JSON object
+// of columns on a line of its own, and returns how many it wrote. Only the
+// current row is held in memory.
+func writeNDJSON(w io.Writer, rows exportRows, columns []string) (int64, error) {
+	bw := bufio.NewWriter(w)
+	values := make([]any, len(columns))
+	dest := make([]any, len(columns))
+	var id users.ID
+	for i, c := range columns {
+		dest[i] = &values[i]
+		if c == "id" {
+			// Scanned as an ID, so a UUID is not read as 16 bytes
+			dest[i] = &id
+		}
+	}
+	var line []byte
+	var n int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, err
+		}
+		line = append(line[:0], '{')
+		for i, c := range columns {
+			if i > 0 {
+				line = append(line, ',')
+			}
+			line = append(line, '"')
+			line = append(line, c...)
+			line = append(line, `":`...)
+			v := ndjsonValue(values[i])
+			if c == "id" {
+				v = id
+			}
+			field, err := json.Marshal(v)
+			if err != nil {
+				return n, fmt.Errorf("column %s: %w", c, err)
+			}
+			line = append(line, field...)
+		}
+		line = append(line, '}', '\n')
+		if _, err := bw.Write(line); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// ndjsonValue readies a value scanned into an any for json.Marshal: times
+// in UTC, and the text MySQL returns as bytes as a string rather than
+// base64.
+func ndjsonValue(v any) any {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC()
+	case []byte:
+		return string(v)
+	default:
+		return v
+	}
+}
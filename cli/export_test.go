@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+func TestExportWhere(t *testing.T) {
+	tests := []struct {
+		name string
+		opts exportOptions
+		want string
+	}{
+		{name: "not deleted", want: " WHERE " + users.NotDeleted},
+		{name: "everything", opts: exportOptions{IncludeDeleted: true}, want: ""},
+		{name: "condition", opts: exportOptions{Where: "id > 5 OR email LIKE '%@b.test'"},
+			want: " WHERE " + users.NotDeleted + " AND (id > 5 OR email LIKE '%@b.test')"},
+		{name: "condition only", opts: exportOptions{IncludeDeleted: true, Where: "id > 5"}, want: " WHERE (id > 5)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exportWhere(tt.opts); got != tt.want {
+				t.Errorf("exportWhere() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWhere(t *testing.T) {
+	tests := []struct {
+		where   string
+		wantErr string
+	}{
+		{where: ""},
+		{where: "created_at >= '2025-01-01'"},
+		{where: "email = 'a;b@example.com'"},
+		{where: "username = 'o''brien'"},
+		{where: `"username" = 'x' AND (id > 1 OR (id < 0))`},
+		{where: "email ~ '^a.*--$'"},
+		{where: "true) TO STDOUT; DROP TABLE users; --", wantErr: "closes a parenthesis"},
+		{where: "true; DROP TABLE users", wantErr: "semicolon"},
+		{where: "id > 1 -- and the rest", wantErr: "comments"},
+		{where: "id > 1 /* x */", wantErr: "comments"},
+		{where: "(id > 1", wantErr: "open"},
+		{where: "email = 'x", wantErr: "unterminated"},
+		{where: "email = $$a;b$$", wantErr: "semicolon"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			err := checkWhere(tt.where)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("checkWhere() = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("checkWhere() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeExportRows hands out fixed rows, scanning them as database/sql
+// does: into a sql.Scanner through its Scan method, and into an *any as
+// is.
+type fakeExportRows struct {
+	rows [][]any
+	next int
+}
+
+func (r *fakeExportRows) Next() bool {
+	r.next++
+	return r.next <= len(r.rows)
+}
+
+func (r *fakeExportRows) Scan(dest ...any) error {
+	row := r.rows[r.next-1]
+	for i, d := range dest {
+		switch d := d.(type) {
+		case sql.Scanner:
+			if err := d.Scan(row[i]); err != nil {
+				return err
+			}
+		case *any:
+			*d = row[i]
+		default:
+			return fmt.Errorf("cannot scan into %T", d)
+		}
+	}
+	return nil
+}
+
+func (r *fakeExportRows) Err() error { return nil }
+
+func TestWriteNDJSON(t *testing.T) {
+	created := time.Date(2025, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	tests := []struct {
+		name    string
+		columns []string
+		rows    [][]any
+		want    string
+	}{
+		{
+			name:    "integer ids",
+			columns: []string{"id", "username", "created_at", "version"},
+			rows:    [][]any{{int64(7), "alice", created, int64(2)}},
+			want:    `{"id":7,"username":"alice","created_at":"2025-03-04T04:06:07Z","version":2}` + "\n",
+		},
+		{
+			name:    "uuid ids",
+			columns: []string{"id", "username"},
+			rows:    [][]any{{"0f8fad5b-d9cb-469f-a165-70867728950e", "bob"}},
+			want:    `{"id":"0f8fad5b-d9cb-469f-a165-70867728950e","username":"bob"}` + "\n",
+		},
+		{
+			name:    "nulls",
+			columns: []string{"id", "source", "deleted_at"},
+			rows:    [][]any{{int64(1), nil, nil}},
+			want:    `{"id":1,"source":null,"deleted_at":null}` + "\n",
+		},
+		{
+			name:    "mysql bytes",
+			columns: []string{"id", "username", "email"},
+			rows:    [][]any{{[]byte("3"), []byte("carol"), []byte("carol@example.com")}},
+			want:    `{"id":3,"username":"carol","email":"carol@example.com"}` + "\n",
+		},
+		{
+			name:    "several rows",
+			columns: []string{"username"},
+			rows:    [][]any{{"a"}, {"b"}},
+			want:    `{"username":"a"}` + "\n" + `{"username":"b"}` + "\n",
+		},
+		{
+			name:    "no rows",
+			columns: []string{"id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			n, err := writeNDJSON(&buf, &fakeExportRows{rows: tt.rows}, tt.columns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != int64(len(tt.rows)) {
+				t.Errorf("wrote %d rows, want %d", n, len(tt.rows))
+			}
+			if buf.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tt.want)
+			}
+		})
+	}
+}
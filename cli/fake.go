@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// fakeProvider produces plausible user data. It is an interface so the
+// built-in word-list generator can be swapped for a richer faker library
+// without touching the code that consumes the users.
+type fakeProvider interface {
+	FirstName() string
+	LastName() string
+	Domain() string
+	Intn(n int) int
+}
+
+// wordListFaker is an offline fakeProvider backed by small word lists.
+// For a given seed it always produces the same sequence.
+type wordListFaker struct {
+	rng *rand.Rand
+}
+
+var (
+	fakeFirstNames = []string{
+		"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy",
+		"mallory", "niaj", "olivia", "peggy", "rupert", "sybil", "trent", "victor", "walter", "yara",
+	}
+	fakeLastNames = []string{
+		"anderson", "brown", "clark", "davis", "evans", "fischer", "garcia", "hughes", "ito", "jones",
+		"kim", "lopez", "miller", "nguyen", "okafor", "patel", "quinn", "rossi", "smith", "turner",
+	}
+	fakeDomains = []string{
+		"example.com", "example.org", "example.net", "mail.example", "corp.example", "test.example",
+	}
+)
+
+// newFaker returns a wordListFaker seeded with seed.
+func newFaker(seed uint64) *wordListFaker {
+	return &wordListFaker{rng: rand.New(rand.NewPCG(seed, seed))}
+}
+
+// FirstName returns a random lower-case first name.
+func (f *wordListFaker) FirstName() string { return fakeFirstNames[f.rng.IntN(len(fakeFirstNames))] }
+
+// LastName returns a random lower-case last name.
+func (f *wordListFaker) LastName() string { return fakeLastNames[f.rng.IntN(len(fakeLastNames))] }
+
+// Domain returns a random reserved example domain.
+func (f *wordListFaker) Domain() string { return fakeDomains[f.rng.IntN(len(fakeDomains))] }
+
+// Intn returns a random integer in [0, n).
+func (f *wordListFaker) Intn(n int) int { return f.rng.IntN(n) }
+
+// fakeSeed returns FAKE_SEED when set, so runs are reproducible, and a
+// time-based seed otherwise.
+func (a *app) fakeSeed() uint64 {
+	if seed := a.cfg().App.FakeSeed; seed != nil {
+		return *seed
+	}
+	return uint64(time.Now().UnixNano())
+}
+
+// generateUsers returns n users with plausible, unique usernames and emails
+// such as "grace.okafor42" / "grace.okafor42@example.org".
+func generateUsers(p fakeProvider, n int) []users.User {
+	return newFakeUsers(p).next(n)
+}
+
+// fakeUsers generates users in successive chunks that stay unique across
+// the whole run, so a large fake seed never has to hold every user at once.
+type fakeUsers struct {
+	p    fakeProvider
+	seen map[string]bool
+}
+
+func newFakeUsers(p fakeProvider) *fakeUsers {
+	return &fakeUsers{p: p, seen: make(map[string]bool)}
+}
+
+// next returns the next n users.
+func (g *fakeUsers) next(n int) []users.User {
+	p := g.p
+	out := make([]users.User, 0, n)
+	for len(out) < n {
+		first, last := p.FirstName(), p.LastName()
+		var username string
+		switch p.Intn(3) {
+		case 0:
+			username = first + "." + last
+		case 1:
+			username = first[:1] + last
+		default:
+			username = fmt.Sprintf("%s.%s%d", first, last, p.Intn(100))
+		}
+		// Disambiguate collisions with a numeric suffix instead of retrying
+		base := username
+		for i := 2; g.seen[username]; i++ {
+			username = fmt.Sprintf("%s%d", base, i)
+		}
+		g.seen[username] = true
+		out = append(out, users.User{
+			Username: username,
+			Email:    strings.ReplaceAll(username, "_", ".") + "@" + p.Domain(),
+		})
+	}
+	return out
+}
@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+)
+
+func TestFakeUsersDeterministic(t *testing.T) {
+	seed := uint64(42)
+	a := newApp(&config.Config{App: config.App{FakeSeed: &seed}})
+	first := generateUsers(newFaker(a.fakeSeed()), 500)
+	second := generateUsers(newFaker(a.fakeSeed()), 500)
+	if !slices.Equal(first, second) {
+		t.Error("two runs with FAKE_SEED=42 generated different users")
+	}
+	if other := generateUsers(newFaker(43), 500); slices.Equal(first, other) {
+		t.Error("FAKE_SEED=42 and 43 generated the same users")
+	}
+
+	seen := make(map[string]bool, len(first))
+	for _, u := range first {
+		if seen[u.Username] {
+			t.Errorf("username %s generated twice", u.Username)
+		}
+		seen[u.Username] = true
+		if !strings.HasPrefix(u.Email, u.Username+"@") {
+			t.Errorf("user %s has email %s, want one made from the username", u.Username, u.Email)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// newUserHooks returns the hooks newApp gives the app, which every pgx
+// users repository of the app calls after its writes; see users.Hooks.
+// None are registered yet. An integration, such as a webhook or the
+// invalidation of another cache, is registered here, where it can use the
+// app:
+//
+//	hooks.AfterCreate(users.AfterCommit, func(ctx context.Context, _ users.Querier, u users.User) error {
+//		return a.notifySignup(ctx, u)
+//	})
+func (a *app) newUserHooks() *users.Hooks {
+	hooks := &users.Hooks{
+		OnError: func(ctx context.Context, err error) {
+			slog.WarnContext(ctx, "user hook failed", "err", err)
+		},
+	}
+	return hooks
+}
+
+// pgxUserRepository returns the pgx users repository on q, calling the
+// app's hooks when any are registered.
+func (a *app) pgxUserRepository(q users.Querier) users.Repository {
+	newRepo := func(q users.Querier) users.Repository {
+		return users.NewRepository(q, a.userRepositoryOptions())
+	}
+	if a.hooks.Empty() {
+		return newRepo(q)
+	}
+	return users.NewHookedRepository(q, newRepo, a.hooks)
+}
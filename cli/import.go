@@ -0,0 +1,347 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// importOptions are the flags of the import command.
+type importOptions struct {
+	File        string
+	UsernameCol string
+	EmailCol    string
+	Header      bool
+	Delimiter   string
+	BatchSize   int
+	Rejects     string
+	Source      string
+	Atomic      bool
+}
+
+// importResult counts the rows of an import. Accepted rows were inserted
+// or, with ON_CONFLICT=upsert, overwrote an existing user; every other row
+// is rejected and written to the rejects file with the reason.
+type importResult struct {
+	Read     int `json:"read"`
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"`
+	Rejected int `json:"rejected"`
+}
+
+func (r importResult) String() string {
+	return fmt.Sprintf("%d rows read: %d accepted (%d inserted, %d updated), %d rejected",
+		r.Read, r.Inserted+r.Updated, r.Inserted, r.Updated, r.Rejected)
+}
+
+// newImportCmd builds the import command.
+func newImportCmd(a *app) *cobra.Command {
+	var opts importOptions
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Insert users from any CSV, mapping its columns to username and email",
+		Long: `Read users from a CSV file whose layout this program does not control.
+--username-column and --email-column name the columns to use, by header
+name (case-insensitive) or by 1-based position; other columns are ignored.
+
+Every row is validated (length limits, email format) before it is sent.
+Valid rows are inserted in batches of --batch-size; rows that fail
+validation or clash with an existing user are rejected. Rejected rows are
+copied to the rejects file, unchanged, with the reason in an extra
+reject_reason column, so they can be fixed and imported again.
+
+With --atomic (PostgreSQL only) the whole import is one transaction: the
+accepted rows are committed together at the end, or not at all if the
+import stops early. Each row is inserted in a savepoint of its own, so a
+rejected row is rolled back alone. That costs two extra statements per
+row compared with the batches.`,
+		Example: `  import --file crm.csv --username-column Login --email-column "E-mail Address"
+  import --file dump.csv --header=false --username-column 2 --email-column 5`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runImport(cmd.Context(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.File, "file", "", "CSV file to import")
+	cmd.Flags().StringVar(&opts.UsernameCol, "username-column", "username", "column holding the username: a header name or a 1-based position")
+	cmd.Flags().StringVar(&opts.EmailCol, "email-column", "email", "column holding the email: a header name or a 1-based position")
+	cmd.Flags().BoolVar(&opts.Header, "header", true, "the first row names the columns")
+	cmd.Flags().StringVar(&opts.Delimiter, "delimiter", ",", "field separator, e.g. ';' or '\\t'")
+	cmd.Flags().IntVar(&opts.BatchSize, "batch-size", 500, "users sent per batch")
+	cmd.Flags().StringVar(&opts.Rejects, "rejects", "", "file to copy rejected rows to (default <file>.rejects.csv)")
+	cmd.Flags().StringVar(&opts.Source, "source", "", "provenance label stored with each row when RECORD_PROVENANCE is enabled (default import:<file name>)")
+	cmd.Flags().BoolVar(&opts.Atomic, "atomic", false, "commit all accepted rows in one transaction, or none if the import stops")
+	cmd.MarkFlagRequired("file")
+	return cmd
+}
+
+// runImport implements the import command.
+func (a *app) runImport(ctx context.Context, opts importOptions) error {
+	if opts.BatchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	if opts.Atomic && a.usesSQLDB() {
+		return fmt.Errorf("--atomic needs PostgreSQL; with DB_DRIVER=%s import commits batch by batch", a.cfg().Database.Driver)
+	}
+	delimiter, err := parseDelimiter(opts.Delimiter)
+	if err != nil {
+		return err
+	}
+	if opts.Rejects == "" {
+		opts.Rejects = strings.TrimSuffix(opts.File, filepath.Ext(opts.File)) + ".rejects.csv"
+	}
+	if opts.Source == "" {
+		opts.Source = "import:" + filepath.Base(opts.File)
+	}
+
+	f, err := os.Open(opts.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cr := csv.NewReader(f)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1 // a short row is rejected by validation, not fatal
+
+	var header []string
+	if opts.Header {
+		if header, err = cr.Read(); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("reading %s: %w", opts.File, err)
+		}
+	}
+	userCol, err := csvColumn(opts.UsernameCol, header, "--username-column")
+	if err != nil {
+		return err
+	}
+	emailCol, err := csvColumn(opts.EmailCol, header, "--email-column")
+	if err != nil {
+		return err
+	}
+
+	rejects := &rejectsFile{path: opts.Rejects, header: header, comma: delimiter}
+	var result importResult
+	prog := a.startProgress(ctx, "import", "rows", 0)
+	defer prog.Finish()
+	importRows := func(create func([]users.User) ([]error, error)) error {
+		var batch []users.User
+		var rows [][]string // the source row of each user in batch
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			outcomes, err := create(batch)
+			if err != nil {
+				return err
+			}
+			for i, err := range outcomes {
+				switch {
+				case err == nil:
+					result.Inserted++
+				case errors.Is(err, users.ErrUpdated):
+					result.Updated++
+				default:
+					result.Rejected++
+					if err := rejects.write(rows[i], err); err != nil {
+						return err
+					}
+				}
+			}
+			slog.Debug("import batch sent", "users", len(batch))
+			prog.Add(int64(len(batch)))
+			batch, rows = batch[:0], rows[:0]
+			return nil
+		}
+
+		for {
+			row, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", opts.File, err)
+			}
+			result.Read++
+			u := users.User{
+				Username: strings.TrimSpace(rowField(userCol, row)),
+				Email:    strings.TrimSpace(rowField(emailCol, row)),
+			}
+			if err := users.Validate(u); err != nil {
+				result.Rejected++
+				if err := rejects.write(row, err); err != nil {
+					return err
+				}
+				prog.Add(1)
+				continue
+			}
+			u.Source = a.provenance(opts.Source)
+			batch, rows = append(batch, u), append(rows, row)
+			if len(batch) == opts.BatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		return flush()
+	}
+	if opts.Atomic {
+		err = a.importAtomically(ctx, importRows)
+	} else {
+		err = a.withUserRepository(ctx, func(ctx context.Context, repo users.Repository) error {
+			return importRows(func(batch []users.User) ([]error, error) {
+				return repo.CreateMany(ctx, batch)
+			})
+		})
+	}
+	prog.Finish()
+	if cerr := rejects.close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("import stopped after %s: %w", result, err)
+	}
+	attrs := []any{"read", result.Read, "inserted", result.Inserted, "updated", result.Updated, "rejected", result.Rejected, "source", opts.Source}
+	if result.Rejected > 0 {
+		attrs = append(attrs, "rejects", opts.Rejects)
+	}
+	slog.Info("import complete", attrs...)
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, result)
+	}
+	fmt.Println(result)
+	return nil
+}
+
+// importAtomically runs an import --atomic: importRows inserts through
+// createInSavepoints, inside one transaction that commits when it returns
+// nil. It is not withTx, which reruns the transaction on CockroachDB: the
+// CSV has been read by then.
+func (a *app) importAtomically(ctx context.Context, importRows func(create func([]users.User) ([]error, error)) error) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return db.WithTx(ctx, pool, func(tx pgx.Tx) error {
+		return importRows(func(batch []users.User) ([]error, error) {
+			return a.createInSavepoints(ctx, tx, batch)
+		})
+	})
+}
+
+// createInSavepoints inserts us one by one into tx, each in a nested
+// db.WithTx, and returns the outcome of each as CreateMany does. A failed
+// insert is rolled back to its savepoint, leaving tx usable for the next.
+// The error is for tx as a whole: the connection was lost, ctx ended, or
+// a savepoint could not be released or rolled back.
+func (a *app) createInSavepoints(ctx context.Context, tx pgx.Tx, us []users.User) ([]error, error) {
+	results := make([]error, len(us))
+	for i := range us {
+		var outcome error
+		err := db.WithTx(ctx, tx, func(sp pgx.Tx) error {
+			outcome = a.newUserRepository(sp).Create(ctx, &us[i])
+			if errors.Is(outcome, users.ErrUpdated) {
+				return nil // overwritten, which is kept like an insert
+			}
+			return outcome
+		})
+		// WithTx returns the insert's own error once the savepoint is rolled
+		// back; anything else means the savepoint, not the row, failed
+		if err != nil && (err != outcome || ctx.Err() != nil) {
+			return results, err
+		}
+		results[i] = outcome
+	}
+	return results, nil
+}
+
+// parseDelimiter turns --delimiter into the rune encoding/csv splits on,
+// accepting \t for a tab since a literal one is awkward to type.
+func parseDelimiter(s string) (rune, error) {
+	if s == `\t` {
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 || r[0] == '"' || r[0] == '\r' || r[0] == '\n' {
+		return 0, fmt.Errorf("--delimiter must be a single character other than a quote or newline, got %q", s)
+	}
+	return r[0], nil
+}
+
+// csvColumn resolves a column mapping flag to a 0-based index: a 1-based
+// position, or a header name matched case-insensitively.
+func csvColumn(spec string, header []string, flag string) (int, error) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("%s: column positions start at 1, got %d", flag, n)
+		}
+		return n - 1, nil
+	}
+	if header == nil {
+		return 0, fmt.Errorf("%s: %q is not a column position, and without --header there are no column names", flag, spec)
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(spec)) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("%s: no column named %q in the header (%s)", flag, spec, strings.Join(header, ", "))
+}
+
+// rowField returns field i of row, or "" when the row is too short.
+func rowField(i int, row []string) string {
+	if i < len(row) {
+		return row[i]
+	}
+	return ""
+}
+
+// rejectsFile collects rejected rows. The file is only created for the
+// first reject, so a clean import leaves nothing behind.
+type rejectsFile struct {
+	path   string
+	header []string
+	comma  rune
+	f      *os.File
+	w      *csv.Writer
+}
+
+// write appends row with reason as an extra last field.
+func (r *rejectsFile) write(row []string, reason error) error {
+	if r.w == nil {
+		f, err := os.Create(r.path)
+		if err != nil {
+			return err
+		}
+		r.f, r.w = f, csv.NewWriter(f)
+		r.w.Comma = r.comma
+		if r.header != nil {
+			r.w.Write(append(append([]string(nil), r.header...), "reject_reason"))
+		}
+	}
+	return r.w.Write(append(append([]string(nil), row...), reason.Error()))
+}
+
+// close flushes and closes the file, if one was created.
+func (r *rejectsFile) close() error {
+	if r.w == nil {
+		return nil
+	}
+	r.w.Flush()
+	if err := r.w.Error(); err != nil {
+		r.f.Close()
+		return err
+	}
+	return r.f.Close()
+}
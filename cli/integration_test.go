@@ -0,0 +1,807 @@
+//go:build integration
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/hozana-dusabimana/testdb"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// The tests in this file run in parallel against a real PostgreSQL
+// server, each on a database of its own. They are built only with -tags
+// integration; testdb.Main starts the server in a container unless
+// TEST_DATABASE_URL names one.
+func TestMain(m *testing.M) {
+	testdb.Main(m)
+}
+
+// loadTestConfig reads the configuration once, with CONN_STR set to the
+// test server, so the commands see the same defaults as in production.
+var loadTestConfig = sync.OnceValues(func() (*config.Config, error) {
+	os.Setenv("CONN_STR", os.Getenv(testdb.EnvVar))
+	cfg, err := config.Load(context.Background())
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		return nil, err
+	}
+	// A missing .env file is fine: everything else has a default
+	return cfg, nil
+})
+
+// newTestApp returns an app whose commands open their pools on a database
+// of t's own from testdb.New, and a pool on it for the test itself.
+func newTestApp(t testing.TB) (*app, *pgxpool.Pool) {
+	t.Helper()
+	pool := testdb.New(t)
+	cfg, err := loadTestConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := *cfg
+	a := newApp(&copied)
+	// Each command closes its pool when it returns, so it gets one of its
+	// own on the same database
+	a.openPool = func(ctx context.Context) (*pgxpool.Pool, error) {
+		return pgxpool.NewWithConfig(ctx, pool.Config())
+	}
+	return a, pool
+}
+
+// execute runs cmd with args and fails t if it returns an error.
+func execute(t *testing.T, cmd *cobra.Command, args ...string) {
+	t.Helper()
+	cmd.SetArgs(args)
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.ExecuteContext(t.Context()); err != nil {
+		t.Fatalf("%s %s: %v", cmd.Name(), strings.Join(args, " "), err)
+	}
+}
+
+func TestIntegrationMigrate(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	schemaAt := func(want int64) {
+		t.Helper()
+		got, err := migrations.Current(ctx, pool, a.dbSchema())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("schema version = %d, want %d", got, want)
+		}
+	}
+
+	// The copy of the template is migrated already
+	schemaAt(migrations.Latest())
+	execute(t, newMigrateCmd(a))
+	schemaAt(migrations.Latest())
+
+	// Every down migration runs, and the tables go with them
+	execute(t, newMigrateCmd(a), "to", "0")
+	schemaAt(0)
+	var table *string
+	if err := pool.QueryRow(ctx, "SELECT to_regclass($1)::text", a.usersTable()).Scan(&table); err != nil {
+		t.Fatal(err)
+	}
+	if table != nil {
+		t.Errorf("%s still exists after migrate to 0", *table)
+	}
+
+	execute(t, newMigrateCmd(a))
+	schemaAt(migrations.Latest())
+}
+
+func TestIntegrationUsers(t *testing.T) {
+	t.Parallel()
+	_, pool := newTestApp(t)
+	ctx := t.Context()
+	repo := users.NewRepository(pool, users.Options{})
+
+	alice := users.User{Username: "alice", Email: "alice@example.com"}
+	if err := repo.Create(ctx, &alice); err != nil {
+		t.Fatal(err)
+	}
+	if alice.ID == "" || alice.Version != 1 || alice.CreatedAt.IsZero() {
+		t.Errorf("created user = %+v, want its ID, version 1 and timestamps", alice)
+	}
+	bob := users.User{Username: "bob", Email: "bob@example.com"}
+	if err := repo.Create(ctx, &bob); err != nil {
+		t.Fatal(err)
+	}
+
+	// A taken username is skipped, a taken email fails
+	err := repo.Create(ctx, &users.User{Username: "alice", Email: "alice2@example.com"})
+	if !errors.Is(err, users.ErrDuplicate) {
+		t.Errorf("creating a taken username: %v, want ErrDuplicate", err)
+	}
+	err = repo.Create(ctx, &users.User{Username: "carol", Email: "bob@example.com"})
+	if !errors.Is(err, users.ErrDuplicateEmail) {
+		t.Errorf("creating with a taken email: %v, want ErrDuplicateEmail", err)
+	}
+
+	got, err := repo.GetByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != alice.ID || got.Email != alice.Email {
+		t.Errorf("GetByUsername = %+v, want %+v", got, alice)
+	}
+	list, _, err := repo.List(ctx, users.Page{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Username != "alice" || list[1].Username != "bob" {
+		t.Errorf("List = %+v, want alice then bob", list)
+	}
+
+	// An update checks the version it read
+	stale := *got
+	got.Email = "alice@example.org"
+	if err := repo.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != 2 {
+		t.Errorf("version after update = %d, want 2", got.Version)
+	}
+	stale.Email = "alice@example.net"
+	if err := repo.Update(ctx, &stale); !errors.Is(err, users.ErrStaleRecord) {
+		t.Errorf("update of a stale record: %v, want ErrStaleRecord", err)
+	}
+
+	// ConflictUpsert overwrites the email of a taken username instead
+	upsert := users.NewRepository(repo.Unwrap(), users.Options{OnConflict: users.ConflictUpsert})
+	bob.Email = "bob@example.org"
+	if err := upsert.Create(ctx, &bob); !errors.Is(err, users.ErrUpdated) {
+		t.Errorf("upsert of a taken username: %v, want ErrUpdated", err)
+	}
+	if got, err := repo.GetByUsername(ctx, "bob"); err != nil || got.Email != "bob@example.org" {
+		t.Errorf("bob after upsert = %+v, %v", got, err)
+	}
+
+	if err := repo.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetByUsername(ctx, "alice"); !errors.Is(err, users.ErrNotFound) {
+		t.Errorf("GetByUsername of a deleted user: %v, want ErrNotFound", err)
+	}
+	if n, err := repo.Count(ctx, users.Filter{IncludeDeleted: true}); err != nil || n != 2 {
+		t.Errorf("Count including deleted = %d, %v, want 2", n, err)
+	}
+	if err := repo.Delete(ctx, "nobody"); !errors.Is(err, users.ErrNotFound) {
+		t.Errorf("Delete of a missing user: %v, want ErrNotFound", err)
+	}
+}
+
+func TestIntegrationImportExport(t *testing.T) {
+	t.Parallel()
+	a, _ := newTestApp(t)
+	dir := t.TempDir()
+	in := filepath.Join(dir, "crm.csv")
+	err := os.WriteFile(in, []byte(`Login,E-mail Address,Plan
+alice,alice@example.com,free
+bob,bob@example.com,pro
+carol,not an email,free
+alice,alice@example.org,pro
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	execute(t, newImportCmd(a), "--file", in, "--username-column", "Login", "--email-column", "E-mail Address", "--batch-size", "2")
+	rejects, err := os.ReadFile(filepath.Join(dir, "crm.rejects.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header, the invalid email and the second alice
+	if lines := strings.Split(strings.TrimSpace(string(rejects)), "\n"); len(lines) != 3 {
+		t.Errorf("rejects file:\n%s\nwant the header and 2 rows", rejects)
+	}
+
+	out := filepath.Join(dir, "users.csv")
+	execute(t, newExportCmd(a), "--out", out, "--columns", "username,email")
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "username,email\nalice,alice@example.com\nbob,bob@example.com\n"
+	if string(got) != want {
+		t.Errorf("export:\n%s\nwant\n%s", got, want)
+	}
+
+	// The export imports back as it is, every user already there
+	execute(t, newImportCmd(a), "--file", out)
+	rejects, err = os.ReadFile(filepath.Join(dir, "users.rejects.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(rejects)), "\n"); len(lines) != 3 {
+		t.Errorf("rejects of the re-import:\n%s\nwant the header and both users", rejects)
+	}
+}
+
+func TestIntegrationCleanupIdempotencyKeys(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	_, err := pool.Exec(ctx, `INSERT INTO idempotency_keys (key, request_hash, created_at)
+		VALUES ('old', 'h', now() - interval '2 days'), ('new', 'h', now())`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Expired keys are dropped, not archived, whatever --archive says
+	execute(t, newCleanupCmd(a), "--idempotency-older-than", "24h", "--archive")
+	rows, _ := pool.Query(ctx, "SELECT key FROM idempotency_keys ORDER BY key")
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != "new" {
+		t.Errorf("idempotency keys after cleanup = %q, want only the new one", keys)
+	}
+	var archived int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM archived_rows WHERE source_table = 'idempotency_keys'").Scan(&archived); err != nil {
+		t.Fatal(err)
+	}
+	if archived != 0 {
+		t.Errorf("%d idempotency keys archived, want none", archived)
+	}
+}
+
+// lockUsers holds a lock on users that makes inserts wait until the
+// returned function, or the end of the test, releases it.
+func lockUsers(t *testing.T, a *app, pool *pgxpool.Pool) (release func()) {
+	t.Helper()
+	tx, err := pool.Begin(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(t.Context(), "LOCK TABLE "+a.usersTable()+" IN SHARE MODE"); err != nil {
+		t.Fatal(err)
+	}
+	release = sync.OnceFunc(func() { tx.Rollback(context.Background()) })
+	t.Cleanup(release)
+	return release
+}
+
+// waitForLockWaiters waits until n statements of the test database wait
+// for a lock.
+func waitForLockWaiters(t *testing.T, pool *pgxpool.Pool, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var waiting int
+		err := pool.QueryRow(t.Context(), `SELECT count(*) FROM pg_stat_activity
+			WHERE datname = current_database() AND wait_event_type = 'Lock'`).Scan(&waiting)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if waiting >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d statements waiting for a lock after 10s, want %d", waiting, n)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// interruptedSeed runs seed --workers 2 --batch-size 5 on 50 users until
+// both workers wait on the lock held by lockUsers, interrupts it, calls
+// whileStopping and returns the users and the dead-letter file it wrote.
+func interruptedSeed(t *testing.T, a *app, pool *pgxpool.Pool, grace time.Duration, whileStopping func()) (records []users.User, deadLetter string) {
+	t.Helper()
+	a.setCfg(func(cfg *config.Config) { cfg.App.ShutdownGrace = grace })
+	for i := range 50 {
+		username := fmt.Sprintf("worker_%d", i)
+		records = append(records, users.User{Username: username, Email: username + "@example.com"})
+	}
+	deadLetter = filepath.Join(t.TempDir(), "seed.dead-letter.csv")
+
+	ctx, interrupt := context.WithCancel(t.Context())
+	done := make(chan error)
+	go func() { done <- a.runConcurrentSeed(ctx, records, "test", 2, 5, deadLetter) }()
+	waitForLockWaiters(t, pool, 2)
+	interrupt()
+	whileStopping()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("interrupted seed: %v, want context.Canceled", err)
+	}
+	return records, deadLetter
+}
+
+// usersAndDeadLetter returns the usernames in the table and those in the
+// dead-letter file, with the reasons given for the latter.
+func usersAndDeadLetter(t *testing.T, a *app, pool *pgxpool.Pool, deadLetter string) (inserted, unsaved, reasons []string) {
+	t.Helper()
+	rows, _ := pool.Query(t.Context(), "SELECT username FROM "+a.usersTable())
+	inserted, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(deadLetter)
+	if errors.Is(err, os.ErrNotExist) {
+		return inserted, nil, nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lines, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range lines[1:] {
+		unsaved, reasons = append(unsaved, line[0]), append(reasons, line[2])
+	}
+	return inserted, unsaved, reasons
+}
+
+func TestIntegrationSeedWorkersFlushOnShutdown(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	release := lockUsers(t, a, pool)
+	// The lock goes once the seed was interrupted, well within the grace
+	records, deadLetter := interruptedSeed(t, a, pool, time.Minute, release)
+
+	inserted, unsaved, reasons := usersAndDeadLetter(t, a, pool, deadLetter)
+	// Each worker held a batch of 5 when it was interrupted
+	if len(inserted) < 10 {
+		t.Errorf("%d users inserted, want at least the 10 of the batches held", len(inserted))
+	}
+	if len(inserted)+len(unsaved) != len(records) {
+		t.Errorf("%d users inserted and %d in the dead-letter file, want %d in all", len(inserted), len(unsaved), len(records))
+	}
+	for _, reason := range reasons {
+		if reason != errSeedStopped.Error() {
+			t.Errorf("dead-letter reason %q, want only users that were never sent", reason)
+			break
+		}
+	}
+	if slices.ContainsFunc(unsaved, func(u string) bool { return slices.Contains(inserted, u) }) {
+		t.Errorf("dead-letter file %q holds users that were inserted %q", unsaved, inserted)
+	}
+}
+
+func TestIntegrationSeedWorkersDeadLetterOnSlowFlush(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	lockUsers(t, a, pool)
+	// The lock outlasts the grace, so no batch can finish
+	records, deadLetter := interruptedSeed(t, a, pool, 100*time.Millisecond, func() {})
+
+	inserted, unsaved, reasons := usersAndDeadLetter(t, a, pool, deadLetter)
+	if len(inserted) != 0 {
+		t.Errorf("%d users inserted while the table was locked", len(inserted))
+	}
+	if len(unsaved) != len(records) {
+		t.Fatalf("%d users in the dead-letter file, want all %d", len(unsaved), len(records))
+	}
+	// The 10 users of the batches held have the error that stopped them
+	if n := len(slices.DeleteFunc(reasons, func(r string) bool { return r == errSeedStopped.Error() })); n != 10 {
+		t.Errorf("%d dead-letter users with the error of their batch, want 10", n)
+	}
+
+	// The file seeds back as it is
+	back, err := loadSeedFile(deadLetter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(back, func(x, y users.User) int { return strings.Compare(x.Username, y.Username) })
+	slices.SortFunc(records, func(x, y users.User) int { return strings.Compare(x.Username, y.Username) })
+	if !slices.Equal(back, records) {
+		t.Errorf("dead-letter file reads back as %+v, want %+v", back, records)
+	}
+}
+
+func TestIntegrationCheckIntegrity(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	// Rows the repository would refuse, written around it
+	_, err := pool.Exec(ctx, "INSERT INTO "+a.usersTable()+` (username, email)
+		VALUES ('alice', 'alice@example.com'), ('bob', 'Alice@Example.com'), ('carol', 'not an email')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	violations, err := a.checkIntegrity(ctx, pool, integrityChecks, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, v := range violations {
+		names = append(names, v.Check.Name)
+	}
+	if want := []string{"users.email_format", "users.email_unique_ci"}; !slices.Equal(names, want) {
+		t.Errorf("violated assertions = %q, want %q", names, want)
+	}
+
+	cmd := newCheckCmd(a)
+	cmd.SetArgs([]string{"integrity"})
+	cmd.SilenceUsage, cmd.SilenceErrors = true, true
+	if err := cmd.ExecuteContext(ctx); err == nil {
+		t.Error("check integrity succeeded with violations, want an error for CI")
+	}
+}
+
+func TestIntegrationMissingDatabase(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	u, err := url.Parse(os.Getenv(testdb.EnvVar))
+	if err != nil || u.Scheme == "" {
+		t.Skipf("%s is not a URL", testdb.EnvVar)
+	}
+	name := "quickstart_missing_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	u.Path = "/" + name
+	a.setCfg(func(cfg *config.Config) { cfg.Database.ConnStr = u.String() })
+	t.Cleanup(func() {
+		if _, err := pool.Exec(context.Background(), "DROP DATABASE IF EXISTS "+pgx.Identifier{name}.Sanitize()); err != nil {
+			t.Errorf("dropping %s: %v", name, err)
+		}
+	})
+
+	// Without AUTO_CREATE_DATABASE the error says how to create it
+	a.setCfg(func(cfg *config.Config) { cfg.Database.AutoCreate = false })
+	_, err = a.connect(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()) || !strings.Contains(err.Error(), "AUTO_CREATE_DATABASE=true") {
+		t.Errorf("connecting to a missing database: %v, want how to create it", err)
+	}
+
+	a.setCfg(func(cfg *config.Config) { cfg.Database.AutoCreate = true })
+	conn, err := a.connect(t.Context())
+	if err != nil {
+		t.Fatalf("connecting with AUTO_CREATE_DATABASE: %v", err)
+	}
+	defer conn.Close(context.Background())
+	if got := conn.Config().Database; got != name {
+		t.Errorf("connected to %q, want the created %q", got, name)
+	}
+}
+
+func TestIntegrationTail(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	repo := users.NewRepository(pool, users.Options{})
+	if err := repo.Create(t.Context(), &users.User{Username: "before", Email: "before@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, stop := context.WithCancel(t.Context())
+	seen := make(chan string, 10)
+	cursor := tailCursor{emit: func(u tailedUser) { seen <- u.Username }}
+	done := make(chan error)
+	go func() { done <- a.tailOnce(ctx, pool, a.cfg().App.TailChannel, time.Hour, true, &cursor) }()
+	next := func() string {
+		t.Helper()
+		select {
+		case username := <-seen:
+			return username
+		case err := <-done:
+			t.Fatalf("tail stopped: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("no user printed by tail within 10s")
+		}
+		return ""
+	}
+
+	// --since prints the existing user once tail listens
+	if got := next(); got != "before" {
+		t.Errorf("tail printed %q first, want before from --since", got)
+	}
+	if err := repo.Create(t.Context(), &users.User{Username: "during", Email: "during@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := next(); got != "during" {
+		t.Errorf("tail printed %q, want the user inserted while it ran", got)
+	}
+	stop()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("tail after Ctrl-C: %v, want context.Canceled", err)
+	}
+}
+
+func TestIntegrationStraySearchPath(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	// A schema of its own, with a users table the queries must not reach
+	_, err := pool.Exec(ctx, `CREATE SCHEMA decoy;
+		CREATE TABLE decoy.users (id int, username text, email text);
+		INSERT INTO decoy.users VALUES (1, 'decoy', 'decoy@example.com')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strayCfg := pool.Config()
+	strayCfg.ConnConfig.RuntimeParams["search_path"] = "decoy"
+	stray, err := pgxpool.NewWithConfig(ctx, strayCfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stray.Close()
+
+	repo := users.NewRepository(stray, a.userRepositoryOptions())
+	if err := repo.Create(ctx, &users.User{Username: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("creating a user with search_path=decoy: %v", err)
+	}
+	if _, err := repo.GetByUsername(ctx, "alice"); err != nil {
+		t.Errorf("reading a user with search_path=decoy: %v", err)
+	}
+	if _, err := repo.GetByUsername(ctx, "decoy"); !errors.Is(err, users.ErrNotFound) {
+		t.Errorf("GetByUsername of the decoy's user: %v, want ErrNotFound", err)
+	}
+	var inPublic, inDecoy int
+	err = pool.QueryRow(ctx, "SELECT (SELECT count(*) FROM public.users), (SELECT count(*) FROM decoy.users)").Scan(&inPublic, &inDecoy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inPublic != 1 || inDecoy != 1 {
+		t.Errorf("%d users in public and %d in decoy, want alice in public and the decoy alone", inPublic, inDecoy)
+	}
+
+	// So do the commands
+	a.openPool = func(ctx context.Context) (*pgxpool.Pool, error) {
+		return pgxpool.NewWithConfig(ctx, strayCfg)
+	}
+	execute(t, newCheckCmd(a), "integrity")
+}
+
+func TestIntegrationBackfill(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	_, err := pool.Exec(ctx, "INSERT INTO "+a.usersTable()+` (username, email)
+		SELECT 'user' || i, 'User' || i || '@Example.com' FROM generate_series(1, 25) i;
+		ALTER TABLE `+a.usersTable()+" ADD COLUMN email_lower text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := backfillOptions{Table: a.usersTable(), Column: "email_lower", Expr: "lower(email)", OnlyNull: true, BatchSize: 10, Delay: time.Hour}
+
+	// Interrupted after its first batch, during the pause
+	interrupted, stop := context.WithCancel(ctx)
+	progress, err := backfill(interrupted, pool, opts, func(backfillProgress) { stop() })
+	if !errors.Is(err, context.Canceled) || progress.Batches != 1 || progress.Rows != 10 {
+		t.Fatalf("interrupted backfill = %+v, %v, want 1 batch of 10 rows and context.Canceled", progress, err)
+	}
+
+	// Resumed after the last id it reported
+	opts.StartAfter, opts.Delay = progress.LastID, 0
+	var batches []backfillProgress
+	progress, err = backfill(ctx, pool, opts, func(p backfillProgress) { batches = append(batches, p) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Batches != 2 || progress.Rows != 15 || len(batches) != 2 {
+		t.Errorf("resumed backfill = %+v in %d reports, want the 15 other rows in 2 batches", progress, len(batches))
+	}
+	var wrong int
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM "+a.usersTable()+" WHERE email_lower IS DISTINCT FROM lower(email)").Scan(&wrong); err != nil {
+		t.Fatal(err)
+	}
+	if wrong != 0 {
+		t.Errorf("%d users without their backfilled email_lower", wrong)
+	}
+}
+
+func TestIntegrationSnapshotRoundTrip(t *testing.T) {
+	t.Parallel()
+	source, sourcePool := newTestApp(t)
+	ctx := t.Context()
+	_, err := sourcePool.Exec(ctx, "INSERT INTO "+source.usersTable()+` (username, email, source, profile, password_hash, deleted_at)
+		VALUES ('alice', 'alice@example.com', NULL, '{}', NULL, NULL),
+			('bob', 'bob@example.com', 'file:crm.csv', '{"bio": "x", "unknown": [1]}', 'hash', now())`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "users.snapshot.json.gz")
+	execute(t, newSnapshotCmd(source), "--out", file, "--gzip")
+
+	// Into another database, which then holds the same rows
+	target, targetPool := newTestApp(t)
+	execute(t, newRestoreCmd(target), "--in", file)
+	before, err := source.takeSnapshot(ctx, sourcePool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := target.takeSnapshot(ctx, targetPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(before.Users)
+	got, _ := json.Marshal(after.Users)
+	if !bytes.Equal(got, want) {
+		t.Errorf("users after restore:\n%s\nwant\n%s", got, want)
+	}
+
+	// The sequence moved past the restored ids
+	repo := users.NewRepository(targetPool, target.userRepositoryOptions())
+	if err := repo.Create(ctx, &users.User{Username: "carol", Email: "carol@example.com"}); err != nil {
+		t.Errorf("inserting after the restore: %v", err)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that log handlers on several goroutines
+// can share.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// The default logger is the whole process's, so this test does not run in
+// parallel with the others.
+func TestIntegrationCopyProgress(t *testing.T) {
+	a, pool := newTestApp(t)
+	a.setCfg(func(cfg *config.Config) { cfg.App.ProgressInterval = 10 * time.Millisecond })
+	var logs lockedBuffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+
+	// Enough rows, each through the triggers of users, to copy for
+	// several intervals
+	archive := &snapshotArchive{Format: snapshotFormat, SchemaVersion: schemaVersion}
+	for i := range 100000 {
+		username := fmt.Sprintf("copied_%d", i)
+		archive.Users = append(archive.Users, snapshotUser{ID: users.ID(strconv.Itoa(i + 1)), Username: username, Email: username + "@example.com", Version: 1})
+	}
+	if err := a.restoreSnapshot(t.Context(), pool, archive, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs.String(), `"msg":"progress","rows_copied":`) {
+		t.Errorf("no COPY progress logged during a restore of %d users; logs:\n%s", len(archive.Users), logs.String())
+	}
+}
+
+func TestIntegrationSeedProvenance(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	a.setCfg(func(cfg *config.Config) { cfg.App.RecordProvenance = true })
+	file := filepath.Join(t.TempDir(), "crm-export.json")
+	err := os.WriteFile(file, []byte(`[{"username": "alice", "email": "alice@example.com"}, {"username": "bob", "email": "bob@example.com"}]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	execute(t, newSeedCmd(a), "--file", file)
+
+	rows, _ := pool.Query(t.Context(), "SELECT coalesce(source, '') FROM "+a.usersTable()+" ORDER BY username")
+	sources, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"file:crm-export.json", "file:crm-export.json"}; !slices.Equal(sources, want) {
+		t.Errorf("sources after seeding %s = %q, want %q", file, sources, want)
+	}
+}
+
+func TestIntegrationLocks(t *testing.T) {
+	t.Parallel()
+	a, pool := newTestApp(t)
+	ctx := t.Context()
+	holder, err := pool.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Rollback(context.Background())
+	if _, err := holder.Exec(ctx, "LOCK TABLE "+a.usersTable()+" IN SHARE MODE"); err != nil {
+		t.Fatal(err)
+	}
+	waiter, err := pool.Acquire(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer waiter.Release()
+	inserted := make(chan error)
+	go func() {
+		_, err := waiter.Exec(ctx, "INSERT INTO "+a.usersTable()+" (username, email) VALUES ('alice', 'alice@example.com')")
+		inserted <- err
+	}()
+	// Released before the deferred calls, which need the insert done
+	defer func() { holder.Rollback(context.Background()); <-inserted }()
+
+	// Sessions of other tests may wait too; this one is the insert's
+	var wait *lockWait
+	for deadline := time.Now().Add(10 * time.Second); wait == nil; time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the blocked insert is not in the report after 10s")
+		}
+		waits, err := currentLockWaits(ctx, pool)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, w := range waits {
+			if w.BlockedPID == int32(waiter.Conn().PgConn().PID()) {
+				wait = &w
+			}
+		}
+	}
+	if wait.BlockingPID != int32(holder.Conn().PgConn().PID()) || wait.Target != "users" || wait.Mode != "RowExclusiveLock" {
+		t.Errorf("lock wait = %+v, want the insert's RowExclusiveLock on users blocked by the LOCK TABLE session", *wait)
+	}
+	var report strings.Builder
+	printLockWaits(&report, []lockWait{*wait})
+	if !strings.Contains(report.String(), "INSERT INTO") || !strings.Contains(report.String(), "LOCK TABLE") {
+		t.Errorf("report does not show the blocked and blocking statements:\n%s", report.String())
+	}
+}
+
+// captureStdout returns what f writes to os.Stdout. os.Stdout is the whole
+// process's, so tests that use it do not run in parallel with the others.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	out := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- b
+	}()
+	f()
+	w.Close()
+	return string(<-out)
+}
+
+func TestIntegrationLoadtest(t *testing.T) {
+	a, pool := newTestApp(t)
+	report := captureStdout(t, func() {
+		execute(t, newLoadtestCmd(a), "--duration", "1s", "--concurrency", "4", "--read-ratio", "0.5")
+	})
+	for _, want := range []string{"Load test: 4 workers", "operations", "reads", "writes", "pool        peak"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("loadtest report lacks %q:\n%s", want, report)
+		}
+	}
+
+	// Nothing the run wrote is left behind
+	var left, audit, outbox int
+	err := pool.QueryRow(t.Context(), `SELECT
+		(SELECT count(*) FROM users WHERE starts_with(username, 'lt_')),
+		(SELECT count(*) FROM users_audit),
+		(SELECT count(*) FROM users_outbox)`).Scan(&left, &audit, &outbox)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if left+audit+outbox != 0 {
+		t.Errorf("after loadtest: %d users, %d audit entries and %d outbox events left, want none", left, audit, outbox)
+	}
+}
@@ -0,0 +1,141 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// integrityCheck is a data-quality assertion about the database.
+// Query must select a single text column identifying each offending row;
+// the assertion holds when the query returns no rows. %[1]s in Query is
+// replaced with the schema-qualified users table.
+type integrityCheck struct {
+	Name        string
+	Description string
+	Query       string
+}
+
+// integrityChecks is the registry of assertions run by `check integrity`.
+// Add an entry here to extend the check; no other code needs to change.
+var integrityChecks = []integrityCheck{
+	{
+		Name:        "users.email_present",
+		Description: "every user has a non-empty email",
+		Query:       `SELECT username FROM %[1]s WHERE email IS NULL OR btrim(email) = ''`,
+	},
+	{
+		Name:        "users.email_format",
+		Description: "every email looks like local@domain",
+		Query:       `SELECT username || ' <' || email || '>' FROM %[1]s WHERE email !~ '^[^@[:space:]]+@[^@[:space:]]+$'`,
+	},
+	{
+		Name:        "users.email_unique_ci",
+		Description: "no two users share an email ignoring case",
+		Query:       `SELECT lower(email) FROM %[1]s GROUP BY lower(email) HAVING count(*) > 1`,
+	},
+	{
+		Name:        "users.username_unique_ci",
+		Description: "no two users share a username ignoring case",
+		Query:       `SELECT lower(username) FROM %[1]s GROUP BY lower(username) HAVING count(*) > 1`,
+	},
+}
+
+// integrityViolation holds the offending rows reported by one assertion.
+type integrityViolation struct {
+	Check   integrityCheck
+	Count   int
+	Samples []string
+}
+
+// newCheckCmd builds the `check` command group. Only `integrity` exists today.
+func newCheckCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Short: "Run data-quality checks",
+	}
+	var samples int
+	integrity := &cobra.Command{
+		Use:   "integrity",
+		Short: "Run data-quality assertions against the users table",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runCheckIntegrity(cmd.Context(), samples)
+		},
+	}
+	integrity.Flags().IntVar(&samples, "samples", 5, "number of offending rows to print per violated assertion")
+	cmd.AddCommand(integrity)
+	return cmd
+}
+
+// runCheckIntegrity implements `check integrity`. It fails when any
+// assertion finds offending rows, so it can gate CI jobs.
+func (a *app) runCheckIntegrity(ctx context.Context, samples int) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	violations, err := a.checkIntegrity(ctx, pool, integrityChecks, samples)
+	if err != nil {
+		return err
+	}
+	printViolations(os.Stdout, integrityChecks, violations)
+	if len(violations) > 0 {
+		return fmt.Errorf("%d integrity assertion(s) failed", len(violations))
+	}
+	return nil
+}
+
+// checkIntegrity runs every assertion and returns those that found
+// offending rows, keeping at most sampleLimit examples of each.
+func (a *app) checkIntegrity(ctx context.Context, pool *pgxpool.Pool, checks []integrityCheck, sampleLimit int) ([]integrityViolation, error) {
+	var violations []integrityViolation
+	for _, c := range checks {
+		rows, err := pool.Query(ctx, fmt.Sprintf(c.Query, a.usersTable()))
+		if err != nil {
+			return nil, fmt.Errorf("integrity check %s: %w", c.Name, err)
+		}
+		v := integrityViolation{Check: c}
+		var offender string
+		_, err = pgx.ForEachRow(rows, []any{&offender}, func() error {
+			v.Count++
+			if len(v.Samples) < sampleLimit {
+				v.Samples = append(v.Samples, offender)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("integrity check %s: %w", c.Name, err)
+		}
+		if v.Count > 0 {
+			violations = append(violations, v)
+		}
+	}
+	return violations, nil
+}
+
+// printViolations reports each assertion as OK or VIOLATED with sample offenders.
+func printViolations(w io.Writer, checks []integrityCheck, violations []integrityViolation) {
+	byName := make(map[string]integrityViolation, len(violations))
+	for _, v := range violations {
+		byName[v.Check.Name] = v
+	}
+	for _, c := range checks {
+		v, bad := byName[c.Name]
+		if !bad {
+			fmt.Fprintf(w, "[OK]       %s: %s\n", c.Name, c.Description)
+			continue
+		}
+		fmt.Fprintf(w, "[VIOLATED] %s: %s (%d offending rows)\n", c.Name, c.Description, v.Count)
+		fmt.Fprintf(w, "           e.g. %s\n", strings.Join(v.Samples, ", "))
+	}
+	fmt.Fprintf(w, "\n%d of %d assertions violated\n", len(violations), len(checks))
+}
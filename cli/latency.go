@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// latencySample collects the durations of successful attempts and counts failures.
+type latencySample struct {
+	durations []time.Duration
+	errors    int
+}
+
+// record adds one attempt to the sample.
+func (s *latencySample) record(d time.Duration, err error) {
+	if err != nil {
+		s.errors++
+		return
+	}
+	s.durations = append(s.durations, d)
+}
+
+// percentile returns the p-th percentile (0 < p <= 100) of durations using
+// the nearest-rank method: the smallest value such that at least p percent
+// of the samples are less than or equal to it. durations must be sorted.
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(durations))))
+	rank = max(1, min(rank, len(durations)))
+	return durations[rank-1]
+}
+
+// newLatencyCmd builds the latency command.
+func newLatencyCmd(a *app) *cobra.Command {
+	var connections, queries int
+	cmd := &cobra.Command{
+		Use:   "latency",
+		Short: "Measure connection and query latency percentiles",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runLatency(cmd.Context(), connections, queries)
+		},
+	}
+	cmd.Flags().IntVar(&connections, "connections", 10, "number of connections to open and close")
+	cmd.Flags().IntVar(&queries, "queries", 100, "number of SELECT 1 queries to run through the pool")
+	return cmd
+}
+
+// runLatency measures how long it takes to establish connections and to run
+// a trivial query, reporting p50/p90/p99 and the error rate for each.
+// Connections are opened outside the pool so that every attempt pays the
+// full setup cost; queries go through the pool as they would in the app.
+func (a *app) runLatency(ctx context.Context, connections, queries int) error {
+
+	var connSample latencySample
+	for range connections {
+		start := time.Now()
+		conn, err := a.connect(ctx)
+		connSample.record(time.Since(start), err)
+		if err == nil {
+			conn.Close(ctx)
+		}
+	}
+
+	var querySample latencySample
+	if queries > 0 {
+		pool, err := a.openPool(ctx)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+		var one int
+		for range queries {
+			start := time.Now()
+			err := pool.QueryRow(ctx, "SELECT 1").Scan(&one)
+			querySample.record(time.Since(start), err)
+		}
+	}
+
+	printLatency(os.Stdout, "connect", connections, connSample)
+	printLatency(os.Stdout, "query", queries, querySample)
+	return nil
+}
+
+// printLatency writes one summary line for a sample of attempts.
+func printLatency(w io.Writer, name string, attempts int, s latencySample) {
+	if attempts == 0 {
+		return
+	}
+	slices.Sort(s.durations)
+	fmt.Fprintf(w, "%-8s n=%-5d p50=%-10s p90=%-10s p99=%-10s errors=%d (%.1f%%)\n",
+		name, attempts,
+		percentile(s.durations, 50).Round(time.Microsecond),
+		percentile(s.durations, 90).Round(time.Microsecond),
+		percentile(s.durations, 99).Round(time.Microsecond),
+		s.errors, 100*float64(s.errors)/float64(attempts))
+}
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	ms := func(ns ...int) []time.Duration {
+		var ds []time.Duration
+		for _, n := range ns {
+			ds = append(ds, time.Duration(n)*time.Millisecond)
+		}
+		return ds
+	}
+	hundred := make([]time.Duration, 100)
+	for i := range hundred {
+		hundred[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{"empty", nil, 50, 0},
+		{"one sample", ms(7), 50, 7 * time.Millisecond},
+		{"one sample p99", ms(7), 99, 7 * time.Millisecond},
+		{"median of an odd count", ms(1, 2, 3, 4, 5), 50, 3 * time.Millisecond},
+		{"median of an even count", ms(1, 2, 3, 4), 50, 2 * time.Millisecond},
+		{"p90 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 90, 9 * time.Millisecond},
+		{"p99 of ten", ms(1, 2, 3, 4, 5, 6, 7, 8, 9, 10), 99, 10 * time.Millisecond},
+		{"p100", ms(1, 2, 3), 100, 3 * time.Millisecond},
+		{"tiny p", ms(1, 2, 3), 0.1, 1 * time.Millisecond},
+		{"p50 of a hundred", hundred, 50, 50 * time.Millisecond},
+		{"p90 of a hundred", hundred, 90, 90 * time.Millisecond},
+		{"p99 of a hundred", hundred, 99, 99 * time.Millisecond},
+		{"above 100", ms(1, 2, 3), 150, 3 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.durations, tt.p); got != tt.want {
+				t.Errorf("percentile(%v, %v) = %v, want %v", tt.durations, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintLatency(t *testing.T) {
+	var s latencySample
+	for _, d := range []time.Duration{3, 1, 2} {
+		s.record(d*time.Millisecond, nil)
+	}
+	s.record(time.Second, errors.New("refused"))
+
+	var b strings.Builder
+	printLatency(&b, "connect", 4, s)
+	for _, want := range []string{"connect", "n=4", "p50=2ms", "p99=3ms", "errors=1 (25.0%)"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("line %q lacks %q", b.String(), want)
+		}
+	}
+
+	b.Reset()
+	printLatency(&b, "query", 0, latencySample{})
+	if b.Len() != 0 {
+		t.Errorf("no attempts printed %q", b.String())
+	}
+}
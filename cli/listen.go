@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/spf13/cobra"
+)
+
+// forwardTimeout bounds each POST made by listen --forward.
+const forwardTimeout = 10 * time.Second
+
+// newListenCmd builds the listen command.
+func newListenCmd(a *app) *cobra.Command {
+	var channels []string
+	var forward string
+	cmd := &cobra.Command{
+		Use:   "listen",
+		Short: "Print or forward the notifications sent on one or more channels",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runListen(cmd.Context(), channels, forward)
+		},
+	}
+	cmd.Flags().StringSliceVar(&channels, "channel", []string{a.cfg().App.TailChannel}, "channel to LISTEN on; repeat or separate with commas for several")
+	cmd.Flags().StringVar(&forward, "forward", "", "POST each payload to this http(s) URL instead of printing it")
+	return cmd
+}
+
+// runListen subscribes to channels on a dedicated connection and hands
+// every notification to stdout or, with forward, to a webhook. Unlike tail
+// it knows nothing about the payloads, so it works for any channel, not
+// only the one the users insert trigger publishes on.
+//
+// A lost connection is re-established and the channels are listened to
+// again; notifications sent while disconnected are lost, as PostgreSQL only
+// delivers them to sessions listening at commit time.
+func (a *app) runListen(ctx context.Context, channels []string, forward string) error {
+	if reason := a.listenUnavailable(); reason != "" {
+		return fmt.Errorf("listen relies on LISTEN/NOTIFY: %s", reason)
+	}
+	if len(channels) == 0 {
+		return errors.New("at least one --channel is required")
+	}
+	deliver := printNotification
+	if forward != "" {
+		u, err := url.Parse(forward)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("--forward must be an http or https URL, got %q", forward)
+		}
+		deliver = forwarder(forward)
+	}
+
+	return reconnecting(ctx, "listen", func(first bool) error {
+		return a.listenOnce(ctx, channels, first, deliver)
+	})
+}
+
+// listenOnce runs a single LISTEN session on its own connection until the
+// connection fails or ctx is cancelled. The connection does not come from
+// a pool: it stays in LISTEN state for its whole life.
+func (a *app) listenOnce(ctx context.Context, channels []string, first bool, deliver func(context.Context, *pgconn.Notification)) error {
+	conn, err := a.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	for _, channel := range channels {
+		if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+			return fmt.Errorf("listening on %s: %w", channel, err)
+		}
+	}
+	if first {
+		fmt.Fprintf(os.Stderr, "Listening on %s (Ctrl-C to stop)...\n", strings.Join(channels, ", "))
+	} else {
+		slog.Info("listen: resubscribed", "channels", channels)
+	}
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			return fmt.Errorf("waiting for notification: %w", err)
+		}
+		deliver(ctx, n)
+	}
+}
+
+// printNotification writes a notification to stdout as
+// "channel<TAB>payload".
+func printNotification(_ context.Context, n *pgconn.Notification) {
+	fmt.Printf("%s\t%s\n", n.Channel, n.Payload)
+}
+
+// forwarder returns a delivery function that POSTs each payload to target,
+// with the channel and the sending backend's pid in X-Notify-Channel and
+// X-Notify-PID headers. A failed delivery is logged and the notification
+// dropped, so a webhook that is down does not stall the subscription.
+func forwarder(target string) func(context.Context, *pgconn.Notification) {
+	client := &http.Client{Timeout: forwardTimeout}
+	return func(ctx context.Context, n *pgconn.Notification) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewBufferString(n.Payload))
+		if err != nil {
+			slog.Warn("listen: forwarding failed", "channel", n.Channel, "err", err)
+			return
+		}
+		contentType := "text/plain; charset=utf-8"
+		if json.Valid([]byte(n.Payload)) {
+			contentType = "application/json"
+		}
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("X-Notify-Channel", n.Channel)
+		req.Header.Set("X-Notify-PID", strconv.FormatUint(uint64(n.PID), 10))
+		resp, err := client.Do(req)
+		if err != nil {
+			slog.Warn("listen: forwarding failed", "channel", n.Channel, "err", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			slog.Warn("listen: forwarding rejected", "channel", n.Channel, "status", resp.Status)
+		}
+	}
+}
+
+// reconnecting runs session until ctx is cancelled, starting it again with
+// exponential backoff (1s up to 30s) whenever it fails. first is true only
+// for the initial session. It is the reconnect loop shared by tail and
+// listen, whose sessions end only when their connection is lost.
+func reconnecting(ctx context.Context, what string, session func(first bool) error) error {
+	backoff := time.Second
+	for first := true; ; first = false {
+		err := session(first)
+		if ctx.Err() != nil {
+			return nil
+		}
+		slog.Warn(what+" connection lost; reconnecting", "err", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// loadtestReport aggregates the results of a load test run.
+type loadtestReport struct {
+	Duration     time.Duration
+	Concurrency  int
+	Reads        latencySample
+	Writes       latencySample
+	MaxConns     int32 // pool size
+	PeakAcquired int32 // most connections in use at once
+	AcquireWaits int64 // acquires that had to wait for a free connection
+}
+
+// merge adds another worker's samples to the report.
+func (r *loadtestReport) merge(reads, writes latencySample) {
+	r.Reads.durations = append(r.Reads.durations, reads.durations...)
+	r.Reads.errors += reads.errors
+	r.Writes.durations = append(r.Writes.durations, writes.durations...)
+	r.Writes.errors += writes.errors
+}
+
+// newLoadtestCmd builds the loadtest command.
+func newLoadtestCmd(a *app) *cobra.Command {
+	var duration time.Duration
+	var concurrency int
+	var readRatio float64
+	cmd := &cobra.Command{
+		Use:   "loadtest",
+		Short: "Measure throughput under concurrent reads and writes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runLoadtest(cmd.Context(), duration, concurrency, readRatio)
+		},
+	}
+	cmd.Flags().DurationVar(&duration, "duration", 10*time.Second, "how long to run")
+	cmd.Flags().IntVar(&concurrency, "concurrency", 8, "number of concurrent workers")
+	cmd.Flags().Float64Var(&readRatio, "read-ratio", 0.8, "fraction of operations that are reads (0..1)")
+	return cmd
+}
+
+// runLoadtest hammers the pool with a mix of reads and writes for a fixed
+// duration and reports throughput, error rate, latency percentiles and peak
+// pool saturation, which helps size DB_MAX_CONNS before production.
+// Writes insert users under a run-specific prefix that is deleted afterwards.
+func (a *app) runLoadtest(ctx context.Context, duration time.Duration, concurrency int, readRatio float64) error {
+	if concurrency < 1 || readRatio < 0 || readRatio > 1 {
+		return fmt.Errorf("--concurrency must be at least 1 and --read-ratio between 0 and 1")
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("lt_%x_", time.Now().UnixNano()&0xffffffff)
+	defer a.cleanupLoadtest(ctx, pool, prefix)
+
+	runCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	report := loadtestReport{Duration: duration, Concurrency: concurrency, MaxConns: pool.Config().MaxConns}
+	waitsBefore := pool.Stat().EmptyAcquireCount()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reads, writes := a.loadtestWorker(runCtx, pool, prefix, w, readRatio)
+			mu.Lock()
+			report.merge(reads, writes)
+			mu.Unlock()
+		}()
+	}
+
+	// Sample pool usage while the workers run
+	sampler := time.NewTicker(50 * time.Millisecond)
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-sampler.C:
+			report.PeakAcquired = max(report.PeakAcquired, pool.Stat().AcquiredConns())
+		}
+	}
+	sampler.Stop()
+	report.Duration = time.Since(start)
+	report.AcquireWaits = pool.Stat().EmptyAcquireCount() - waitsBefore
+
+	printLoadtestReport(os.Stdout, report)
+	return nil
+}
+
+// loadtestWorker issues operations until ctx expires. Reads look up a user
+// this worker wrote earlier (or the sample user before any writes), so they
+// hit the unique index the way real lookups would.
+func (a *app) loadtestWorker(ctx context.Context, pool *pgxpool.Pool, prefix string, worker int, readRatio float64) (reads, writes latencySample) {
+	written := 0
+	for ctx.Err() == nil {
+		start := time.Now()
+		var err error
+		if rand.Float64() < readRatio {
+			username := "alice"
+			if written > 0 {
+				username = fmt.Sprintf("%s%d_%d", prefix, worker, rand.IntN(written))
+			}
+			var id users.ID
+			err = pool.QueryRow(ctx, "SELECT id FROM "+a.usersTable()+" WHERE username = $1", username).Scan(&id)
+			if errors.Is(err, pgx.ErrNoRows) {
+				err = nil
+			}
+			if ctx.Err() == nil {
+				reads.record(time.Since(start), err)
+			}
+			continue
+		}
+		username := fmt.Sprintf("%s%d_%d", prefix, worker, written)
+		_, err = pool.Exec(ctx, "INSERT INTO "+a.usersTable()+" (username, email) VALUES ($1, $2)", username, username+"@loadtest.example")
+		if ctx.Err() == nil {
+			writes.record(time.Since(start), err)
+			if err == nil {
+				written++
+			}
+		}
+	}
+	return reads, writes
+}
+
+// cleanupLoadtest deletes every user written by this run.
+// It runs after an interrupt too, so it only keeps the values of ctx.
+func (a *app) cleanupLoadtest(ctx context.Context, pool *pgxpool.Pool, prefix string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	n, err := a.deleteUsersNamed(ctx, pool, prefix)
+	if err != nil {
+		slog.Error("cleanup failed; delete the load test users manually", "prefix", prefix, "err", err)
+		return
+	}
+	slog.Info("cleaned up load test users", "rows_affected", n)
+}
+
+// deleteUsersNamed deletes the users whose username starts with prefix,
+// which loadtest and bench give the users they write, and returns how many
+// it deleted. starts_with, unlike LIKE, takes the _ of the prefixes
+// literally. On PostgreSQL the triggers of migrations 0010 and 0011 have
+// recorded those users in users_audit and users_outbox, and deleting them
+// adds more audit entries, so the UserCreated events still waiting for the
+// relay and every audit entry of the users go too, in the same
+// transaction. The triggers stay enabled, unlike in backup restore and
+// anonymize, because the application may be writing users meanwhile.
+func (a *app) deleteUsersNamed(ctx context.Context, pool *pgxpool.Pool, prefix string) (int64, error) {
+	if a.isCockroach() {
+		tag, err := pool.Exec(ctx, "DELETE FROM "+a.usersTable()+" WHERE starts_with(username, $1)", prefix)
+		return tag.RowsAffected(), err
+	}
+	var n int64
+	err := pgx.BeginFunc(ctx, pool, func(tx pgx.Tx) error {
+		var ids []string
+		err := tx.QueryRow(ctx, `WITH removed AS (
+				DELETE FROM `+a.usersTable()+` WHERE starts_with(username, $1) RETURNING id::text
+			), events AS (
+				DELETE FROM `+a.outboxTable()+` WHERE event_type = 'UserCreated' AND payload->>'id' IN (SELECT id FROM removed)
+			)
+			SELECT coalesce(array_agg(id), '{}') FROM removed`, prefix).Scan(&ids)
+		if err != nil {
+			return err
+		}
+		n = int64(len(ids))
+		// A separate statement, which sees the entries the DELETE added
+		_, err = tx.Exec(ctx, "DELETE FROM "+pgx.Identifier{a.dbSchema(), "users_audit"}.Sanitize()+" WHERE user_id = ANY($1)", ids)
+		return err
+	})
+	return n, err
+}
+
+// printLoadtestReport writes the throughput and latency summary.
+func printLoadtestReport(w io.Writer, r loadtestReport) {
+	reads := len(r.Reads.durations) + r.Reads.errors
+	writes := len(r.Writes.durations) + r.Writes.errors
+	total := reads + writes
+	failed := r.Reads.errors + r.Writes.errors
+	seconds := r.Duration.Seconds()
+
+	fmt.Fprintf(w, "Load test: %d workers for %s\n", r.Concurrency, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "operations  %d (%.1f ops/s), errors %d", total, float64(total)/seconds, failed)
+	if total > 0 {
+		fmt.Fprintf(w, " (%.2f%%)", 100*float64(failed)/float64(total))
+	}
+	fmt.Fprintln(w)
+	printLatency(w, "reads", reads, r.Reads)
+	printLatency(w, "writes", writes, r.Writes)
+	fmt.Fprintf(w, "pool        peak %d of %d connections in use, %d acquires waited for a free connection\n",
+		r.PeakAcquired, r.MaxConns, r.AcquireWaits)
+}
@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/geo"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// locationsTableName is the table geo setup creates, holding one location
+// per user.
+const locationsTableName = users.LocationsTableName
+
+func (a *app) locationsTable() string {
+	return pgx.Identifier{a.dbSchema(), locationsTableName}.Sanitize()
+}
+
+// newGeoCmd builds the geo command group.
+func newGeoCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "geo",
+		Short: "Store locations of users and find the users near a point (PostGIS)",
+		Long: `An example of geospatial queries with the PostGIS extension: geo setup
+creates a table of one geography point per user, geo set stores a user's
+location and geo near lists the users within a distance of a point, nearest
+first. Needs PostgreSQL with PostGIS installed. With the table in place,
+serve also offers PUT /users/{id}/location and GET /users/near.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.usesSQLDB() || a.isCockroach() {
+				return fmt.Errorf("geo needs PostgreSQL with PostGIS, not DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+
+	setup := &cobra.Command{
+		Use:   "setup",
+		Short: "Enable PostGIS and create the locations table and its index",
+		Long: `Run CREATE EXTENSION IF NOT EXISTS postgis, then create the user_locations
+table and a GiST index on its points unless they exist.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runGeoSetup(cmd.Context())
+		},
+	}
+
+	var key userKey
+	var point geo.Point
+	set := &cobra.Command{
+		Use:     "set",
+		Short:   "Store the location of a user, replacing any previous one",
+		Example: `  go run ./cmd/go-postgres geo set --username alice --lat 52.5200 --lon 13.4050`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runGeoSet(cmd.Context(), key, point)
+		},
+	}
+	bindUserKey(set, &key)
+	bindPoint(set, &point)
+
+	var center geo.Point
+	var km float64
+	var limit int
+	near := &cobra.Command{
+		Use:     "near",
+		Short:   "Print the users within a distance of a point, nearest first",
+		Example: `  go run ./cmd/go-postgres geo near --lat 52.5 --lon 13.4 --km 25`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runGeoNear(cmd.Context(), center, km, limit)
+		},
+	}
+	bindPoint(near, &center)
+	near.Flags().Float64Var(&km, "km", 10, "the distance, in kilometres")
+	near.Flags().IntVar(&limit, "limit", 20, "print at most this many users")
+
+	cmd.AddCommand(migratesSchema(setup), set, near)
+	return cmd
+}
+
+// bindPoint adds the required --lat and --lon flags of cmd.
+func bindPoint(cmd *cobra.Command, p *geo.Point) {
+	cmd.Flags().Float64Var(&p.Lat, "lat", 0, "latitude in degrees, -90 to 90")
+	cmd.Flags().Float64Var(&p.Lon, "lon", 0, "longitude in degrees, -180 to 180")
+	cmd.MarkFlagRequired("lat")
+	cmd.MarkFlagRequired("lon")
+}
+
+// runGeoSetup implements geo setup.
+func (a *app) runGeoSetup(ctx context.Context) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+	// The users table's id is bigint or uuid, as ID_TYPE chose when it was
+	// created
+	var idType string
+	if err := pool.QueryRow(ctx, `SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = 'id'`, a.usersTable()).Scan(&idType); err != nil {
+		return fmt.Errorf("reading the type of users.id: %w", err)
+	}
+	return db.WithTx(db.WithoutQueryTimeout(ctx), pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS postgis"); err != nil {
+			return fmt.Errorf("enabling PostGIS (is it installed on the server?): %w", err)
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			user_id %s PRIMARY KEY REFERENCES %s (id) ON DELETE CASCADE,
+			location geography(Point, %d) NOT NULL,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`, a.locationsTable(), idType, a.usersTable(), geo.SRID)); err != nil {
+			return fmt.Errorf("creating %s: %w", locationsTableName, err)
+		}
+		if _, err := tx.Exec(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING gist (location)",
+			pgx.Identifier{locationsTableName + "_location_idx"}.Sanitize(), a.locationsTable())); err != nil {
+			return fmt.Errorf("creating the location index: %w", err)
+		}
+		slog.Info("locations ready", "table", locationsTableName)
+		return nil
+	})
+}
+
+// runGeoSet implements geo set.
+func (a *app) runGeoSet(ctx context.Context, key userKey, p geo.Point) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	return a.withPostgresRepository(ctx, func(ctx context.Context, repo *users.PostgresRepository) error {
+		u, err := key.find(ctx, repo)
+		if err != nil {
+			return fmt.Errorf("user %s: %w", key, err)
+		}
+		if err := repo.SetLocation(ctx, u.ID, p); err != nil {
+			return fmt.Errorf("storing the location of %s: %w", u.Username, err)
+		}
+		slog.Info("location stored", "username", u.Username, "lat", p.Lat, "lon", p.Lon)
+		return nil
+	})
+}
+
+// runGeoNear implements geo near.
+func (a *app) runGeoNear(ctx context.Context, center geo.Point, km float64, limit int) error {
+	if err := center.Validate(); err != nil {
+		return err
+	}
+	if km < 0 {
+		return errors.New("--km must not be negative")
+	}
+	var found []users.NearbyUser
+	err := a.withPostgresRepository(ctx, func(ctx context.Context, repo *users.PostgresRepository) error {
+		var err error
+		found, err = repo.UsersNear(ctx, center, km*1000, limit)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if a.structuredOutput() {
+		if found == nil {
+			found = []users.NearbyUser{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"users": found})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tLOCATION\tDISTANCE")
+	for _, n := range found {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%.2f km\n", n.ID, n.Username, n.Email, n.Location, n.Meters/1000)
+	}
+	return tw.Flush()
+}
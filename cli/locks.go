@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// lockWait describes one session waiting for a lock held by another.
+type lockWait struct {
+	BlockedPID    int32         `db:"blocked_pid"`
+	BlockedUser   string        `db:"blocked_user"`
+	Waiting       time.Duration `db:"waiting"`
+	BlockedQuery  string        `db:"blocked_query"`
+	Target        string        `db:"target"`
+	Mode          string        `db:"mode"`
+	BlockingPID   int32         `db:"blocking_pid"`
+	BlockingUser  string        `db:"blocking_user"`
+	BlockingState string        `db:"blocking_state"`
+	BlockingQuery string        `db:"blocking_query"`
+}
+
+// lockWaitsSQL pairs every ungranted lock with the sessions blocking it.
+// pg_blocking_pids does the hard part of working out who blocks whom.
+const lockWaitsSQL = `
+SELECT
+	blocked.pid                                      AS blocked_pid,
+	coalesce(blocked.usename, '')                    AS blocked_user,
+	coalesce(now() - blocked.query_start, '0')       AS waiting,
+	left(blocked.query, 80)                          AS blocked_query,
+	coalesce(l.relation::regclass::text, l.locktype) AS target,
+	l.mode                                           AS mode,
+	blocking.pid                                     AS blocking_pid,
+	coalesce(blocking.usename, '')                   AS blocking_user,
+	coalesce(blocking.state, '')                     AS blocking_state,
+	left(blocking.query, 80)                         AS blocking_query
+FROM pg_stat_activity blocked
+JOIN pg_locks l ON l.pid = blocked.pid AND NOT l.granted
+CROSS JOIN LATERAL unnest(pg_blocking_pids(blocked.pid)) AS b(pid)
+JOIN pg_stat_activity blocking ON blocking.pid = b.pid
+ORDER BY waiting DESC, blocked.pid`
+
+// currentLockWaits returns the lock waits in the database right now.
+func currentLockWaits(ctx context.Context, pool *pgxpool.Pool) ([]lockWait, error) {
+	return db.Select[lockWait](ctx, pool, lockWaitsSQL)
+}
+
+// printLockWaits renders lock waits as a readable "who blocks whom" report.
+func printLockWaits(w io.Writer, waits []lockWait) {
+	if len(waits) == 0 {
+		fmt.Fprintln(w, "No sessions are waiting for locks.")
+		return
+	}
+	for _, lw := range waits {
+		fmt.Fprintf(w, "pid %d (%s) has waited %s for %s on %s\n",
+			lw.BlockedPID, lw.BlockedUser, lw.Waiting.Round(time.Millisecond), lw.Mode, lw.Target)
+		fmt.Fprintf(w, "    waiting: %s\n", lw.BlockedQuery)
+		fmt.Fprintf(w, "    blocked by pid %d (%s, %s): %s\n",
+			lw.BlockingPID, lw.BlockingUser, lw.BlockingState, lw.BlockingQuery)
+	}
+}
+
+// newLocksCmd builds the locks command.
+func newLocksCmd(a *app) *cobra.Command {
+	var watch time.Duration
+	cmd := &cobra.Command{
+		Use:   "locks",
+		Short: "Show which sessions are blocked by which",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runLocks(cmd.Context(), watch)
+		},
+	}
+	cmd.Flags().DurationVar(&watch, "watch", 0, "refresh the report at this interval (e.g. 2s)")
+	return cmd
+}
+
+// runLocks implements the locks command. With --watch it refreshes the
+// report on an interval until interrupted.
+func (a *app) runLocks(ctx context.Context, watch time.Duration) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	for {
+		waits, err := currentLockWaits(ctx, pool)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("reading locks failed: %w", err)
+		}
+		if watch > 0 {
+			fmt.Printf("--- %s ---\n", a.displayTime(time.Now()).Format(time.TimeOnly))
+		}
+		printLockWaits(os.Stdout, waits)
+		if watch <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(watch):
+		}
+	}
+}
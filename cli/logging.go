@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/server"
+	"github.com/jackc/pgx/v5"
+)
+
+// logLevel is the minimum level of the logger from newLogger. It can be
+// changed while the program runs, e.g. when serve sees LOG_LEVEL change.
+var logLevel slog.LevelVar
+
+// newLogger returns the logger described by LOG_FORMAT and LOG_LEVEL: JSON
+// for log shippers or, when LOG_FORMAT is unset, human-readable text. The
+// staging and production profiles default LOG_FORMAT to json. Records
+// logged with the context of an API request carry its request_id.
+func newLogger(w io.Writer, c config.App) *slog.Logger {
+	logLevel.Set(parseLogLevel(c.LogLevel))
+	opts := &slog.HandlerOptions{Level: &logLevel, ReplaceAttr: redactAttr}
+	if c.LogFormat == "json" {
+		return slog.New(server.RequestIDHandler{Handler: slog.NewJSONHandler(w, opts)})
+	}
+	return slog.New(server.RequestIDHandler{Handler: slog.NewTextHandler(w, opts)})
+}
+
+// redactAttr masks connection string passwords and other secrets in
+// string and error values (see config.Redact), so no call site has to
+// remember to: a failed connect logs an error that may quote CONN_STR.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	switch v := a.Value.Any().(type) {
+	case string:
+		a.Value = slog.StringValue(config.Redact(v))
+	case error:
+		a.Value = slog.StringValue(config.Redact(v.Error()))
+	}
+	return a
+}
+
+// parseLogLevel converts a LOG_LEVEL value, falling back to info for a bad
+// value, which config.Load has already reported.
+func parseLogLevel(s string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// sqlLogger is the pgx tracer enabled by LOG_SQL (on by default in the
+// development profile). It logs every statement at debug level with its
+// duration and outcome: queries one event each, a batch (CreateMany) as one
+// event listing its distinct statements, and COPY (BulkCreate, restore)
+// with its table and columns. Arguments are counted but never logged, since
+// they may hold personal data; statements carry them as $1, $2, ... The
+// backend pid tells apart the statements of concurrent connections.
+type sqlLogger struct{}
+
+type sqlLoggerKey struct{}
+
+type sqlLoggerStart struct {
+	sql  string
+	args int
+	at   time.Time
+}
+
+func (sqlLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, sqlLoggerKey{}, sqlLoggerStart{sql: data.SQL, args: len(data.Args), at: time.Now()})
+}
+
+func (sqlLogger) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(sqlLoggerKey{}).(sqlLoggerStart)
+	if !ok {
+		return
+	}
+	attrs := []any{"sql", start.sql, "args", start.args, "duration", time.Since(start.at), "rows", data.CommandTag.RowsAffected(), "pid", conn.PgConn().PID()}
+	if data.Err != nil {
+		attrs = append(attrs, "err", data.Err)
+	}
+	slog.DebugContext(ctx, "query", attrs...)
+}
+
+type sqlLoggerBatchKey struct{}
+
+// sqlLoggerBatch accumulates the outcome of the statements of a batch,
+// which pgx reports one by one.
+type sqlLoggerBatch struct {
+	sql    []string // distinct statements, in queue order
+	args   int
+	rows   int64
+	failed int
+	at     time.Time
+}
+
+func (sqlLogger) TraceBatchStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchStartData) context.Context {
+	b := &sqlLoggerBatch{at: time.Now()}
+	for _, q := range data.Batch.QueuedQueries {
+		if !slices.Contains(b.sql, q.SQL) {
+			b.sql = append(b.sql, q.SQL)
+		}
+		b.args += len(q.Arguments)
+	}
+	return context.WithValue(ctx, sqlLoggerBatchKey{}, b)
+}
+
+func (sqlLogger) TraceBatchQuery(ctx context.Context, _ *pgx.Conn, data pgx.TraceBatchQueryData) {
+	if b, ok := ctx.Value(sqlLoggerBatchKey{}).(*sqlLoggerBatch); ok {
+		b.rows += data.CommandTag.RowsAffected()
+		if data.Err != nil {
+			b.failed++
+		}
+	}
+}
+
+func (sqlLogger) TraceBatchEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchEndData) {
+	b, ok := ctx.Value(sqlLoggerBatchKey{}).(*sqlLoggerBatch)
+	if !ok {
+		return
+	}
+	attrs := []any{"sql", strings.Join(b.sql, "; "), "args", b.args, "duration", time.Since(b.at), "rows", b.rows, "failed", b.failed, "pid", conn.PgConn().PID()}
+	if data.Err != nil {
+		attrs = append(attrs, "err", data.Err)
+	}
+	slog.DebugContext(ctx, "batch", attrs...)
+}
+
+func (sqlLogger) TraceCopyFromStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	sql := "COPY " + data.TableName.Sanitize() + " (" + strings.Join(data.ColumnNames, ", ") + ") FROM STDIN"
+	return context.WithValue(ctx, sqlLoggerKey{}, sqlLoggerStart{sql: sql, at: time.Now()})
+}
+
+func (sqlLogger) TraceCopyFromEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	start, ok := ctx.Value(sqlLoggerKey{}).(sqlLoggerStart)
+	if !ok {
+		return
+	}
+	attrs := []any{"sql", start.sql, "duration", time.Since(start.at), "rows", data.CommandTag.RowsAffected(), "pid", conn.PgConn().PID()}
+	if data.Err != nil {
+		attrs = append(attrs, "err", data.Err)
+	}
+	slog.DebugContext(ctx, "copy", attrs...)
+}
+
+// slowQueryLogger is the pgx tracer of SLOW_QUERY_MS. It logs each
+// statement that takes longer than the threshold at warn level, with its
+// duration, the statement with its literals masked and whitespace
+// collapsed, and the function that ran it, and counts it in
+// db_slow_queries_total. Batches and COPY are not timed. threshold is
+// read as each statement starts, so a reload can change it; statements
+// are not timed while it is 0.
+type slowQueryLogger struct {
+	threshold func() time.Duration
+}
+
+type slowQueryKey struct{}
+
+// slowQueryStart is what TraceQueryStart passes on to TraceQueryEnd.
+type slowQueryStart struct {
+	sqlLoggerStart
+	threshold time.Duration
+}
+
+func (l slowQueryLogger) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	threshold := l.threshold()
+	if threshold <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, slowQueryKey{}, slowQueryStart{sqlLoggerStart{sql: data.SQL, args: len(data.Args), at: time.Now()}, threshold})
+}
+
+func (l slowQueryLogger) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(slowQueryKey{}).(slowQueryStart)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(start.at)
+	if elapsed < start.threshold {
+		return
+	}
+	slowQueries.WithLabelValues(sqlCommand(start.sql)).Inc()
+	// A query ends when its rows are closed, which its caller does, so
+	// the caller is still on the stack
+	function, file := queryCaller()
+	attrs := []any{"sql", sanitizeSQL(start.sql), "args", start.args, "duration", elapsed, "threshold", start.threshold,
+		"rows", data.CommandTag.RowsAffected(), "caller", file, "func", function, "pid", conn.PgConn().PID()}
+	if data.Err != nil {
+		attrs = append(attrs, "err", data.Err)
+	}
+	slog.WarnContext(ctx, "slow query", attrs...)
+}
+
+// queryCaller returns the function that ran the statement being traced,
+// and its file and line: the innermost frame outside pgx and the runtime.
+func queryCaller() (function, file string) {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/jackc/") && !strings.HasPrefix(frame.Function, "runtime.") {
+			return strings.TrimPrefix(frame.Function, "github.com/hozana-dusabimana/"),
+				fmt.Sprintf("%s:%d", filepath.Join(filepath.Base(filepath.Dir(frame.File)), filepath.Base(frame.File)), frame.Line)
+		}
+		if !more {
+			return "unknown", "unknown"
+		}
+	}
+}
+
+// sanitizeSQL prepares a statement for the slow query log: string literals,
+// which SQL built by the program may hold user data in, become '?', and
+// runs of whitespace one space.
+func sanitizeSQL(sql string) string {
+	var b strings.Builder
+	inLiteral := false
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case inLiteral && c == '\'' && i+1 < len(sql) && sql[i+1] == '\'':
+			i++ // an escaped quote
+		case inLiteral && c == '\'':
+			inLiteral = false
+			b.WriteString("?'")
+		case inLiteral:
+		case c == '\'':
+			inLiteral = true
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.Join(strings.Fields(b.String()), " ")
+}
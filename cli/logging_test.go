@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitizeSQL(t *testing.T) {
+	tests := []struct {
+		sql, want string
+	}{
+		{"SELECT 1", "SELECT 1"},
+		{"SELECT *\n\tFROM users\n  WHERE id = $1", "SELECT * FROM users WHERE id = $1"},
+		{"SELECT * FROM users WHERE email = 'alice@example.com'", "SELECT * FROM users WHERE email = '?'"},
+		{"SELECT 'it''s', 'b'", "SELECT '?', '?'"},
+		{"SELECT ''", "SELECT '?'"},
+		{"INSERT INTO t VALUES ('a\n  b')", "INSERT INTO t VALUES ('?')"},
+		{`SELECT "quoted name" FROM t`, `SELECT "quoted name" FROM t`},
+		{"SELECT 'unterminated", "SELECT '"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeSQL(tt.sql); got != tt.want {
+			t.Errorf("sanitizeSQL(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+}
+
+// traceQueryEnd stands for the tracer method that calls queryCaller, whose
+// frame queryCaller skips.
+func traceQueryEnd() (function, file string) {
+	return queryCaller()
+}
+
+func TestQueryCaller(t *testing.T) {
+	function, file := traceQueryEnd()
+	if !strings.HasSuffix(function, ".TestQueryCaller") {
+		t.Errorf("function = %q, want TestQueryCaller", function)
+	}
+	// The directory is that of the checkout, whatever its name
+	if !strings.Contains(file, "/logging_test.go:") {
+		t.Errorf("file = %q, want logging_test.go and a line", file)
+	}
+}
+
+func TestSQLCommand(t *testing.T) {
+	for sql, want := range map[string]string{
+		"select * from users": "SELECT",
+		"\n  INSERT INTO t":   "INSERT",
+		"":                    "UNKNOWN",
+	} {
+		if got := sqlCommand(sql); got != want {
+			t.Errorf("sqlCommand(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
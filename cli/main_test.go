@@ -0,0 +1,42 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestCommandUsesAppPool(t *testing.T) {
+	refused := errors.New("no database in this test")
+	a := newApp(&config.Config{})
+	opened := 0
+	a.openPool = func(context.Context) (*pgxpool.Pool, error) {
+		opened++
+		return nil, refused
+	}
+	cmd := newPingCmd(a)
+	cmd.SetArgs(nil)
+	if err := cmd.ExecuteContext(context.Background()); !errors.Is(err, refused) {
+		t.Fatalf("error = %v, want the one of openPool", err)
+	}
+	if opened != 1 {
+		t.Errorf("openPool called %d times, want 1", opened)
+	}
+}
+
+func TestAppsDoNotShareState(t *testing.T) {
+	guarded := newApp(&config.Config{App: config.App{BreakerThreshold: 3}})
+	open := newApp(&config.Config{})
+	if guarded.dbBreaker() == nil {
+		t.Error("BREAKER_THRESHOLD=3 built no breaker")
+	}
+	if open.dbBreaker() != nil {
+		t.Error("an app without BREAKER_THRESHOLD got the breaker of another")
+	}
+	if guarded.dbBreaker() != guarded.dbBreaker() {
+		t.Error("the breaker is not shared within an app")
+	}
+}
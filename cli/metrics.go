@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/server"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds every metric the program exports. A dedicated
+// registry (rather than the global default) keeps /metrics limited to what
+// is registered here.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	userInsertAttempts = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "users_insert_attempts_total",
+		Help: "User inserts attempted, including ones rejected as duplicates.",
+	})
+	userInserts = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "users_inserts_total",
+		Help: "Users inserted successfully.",
+	})
+	userInsertConflicts = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "users_insert_conflicts_total",
+		Help: "User inserts skipped because the username or email already exists.",
+	})
+	userInsertUpdates = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+		Name: "users_insert_updates_total",
+		Help: "User inserts that overwrote an existing user (ON_CONFLICT=upsert).",
+	})
+	queryDuration = promauto.With(metricsRegistry).NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "db_query_duration_seconds",
+		Help:    "Latency of SQL statements, by leading keyword and outcome.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to ~4s
+	}, []string{"command", "status"})
+	slowQueries = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+		Name: "db_slow_queries_total",
+		Help: "SQL statements slower than SLOW_QUERY_MS, by leading keyword.",
+	}, []string{"command"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	metricsRegistry.MustRegister(server.Collectors()...)
+}
+
+// metricsHandler serves the registry in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{Registry: metricsRegistry})
+}
+
+// metricsRepository counts the outcome of every insert on the wrapped
+// repository; other methods pass straight through.
+type metricsRepository struct {
+	users.Repository
+}
+
+func (r metricsRepository) Create(ctx context.Context, u *users.User) error {
+	userInsertAttempts.Inc()
+	err := r.Repository.Create(ctx, u)
+	countInsert(err)
+	return err
+}
+
+func (r metricsRepository) CreateMany(ctx context.Context, us []users.User) ([]error, error) {
+	userInsertAttempts.Add(float64(len(us)))
+	results, err := r.Repository.CreateMany(ctx, us)
+	for _, rowErr := range results {
+		countInsert(rowErr)
+	}
+	return results, err
+}
+
+func (r metricsRepository) BulkCreate(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	userInsertAttempts.Add(float64(len(us)))
+	inserted, updated, err = r.Repository.BulkCreate(ctx, us)
+	if err == nil {
+		userInserts.Add(float64(inserted))
+		userInsertUpdates.Add(float64(updated))
+		userInsertConflicts.Add(float64(int64(len(us)) - inserted - updated))
+	}
+	return inserted, updated, err
+}
+
+func (r metricsRepository) UpsertMany(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	userInsertAttempts.Add(float64(len(us)))
+	inserted, updated, err = r.Repository.UpsertMany(ctx, us)
+	if err == nil {
+		userInserts.Add(float64(inserted))
+		userInsertUpdates.Add(float64(updated))
+		userInsertConflicts.Add(float64(int64(len(us)) - inserted - updated))
+	}
+	return inserted, updated, err
+}
+
+// countInsert counts the outcome of one insert.
+func countInsert(err error) {
+	switch {
+	case err == nil:
+		userInserts.Inc()
+	case errors.Is(err, users.ErrUpdated):
+		userInsertUpdates.Inc()
+	case errors.Is(err, users.ErrDuplicate):
+		userInsertConflicts.Inc()
+	}
+}
+
+// queryTimer is a pgx.QueryTracer feeding db_query_duration_seconds.
+type queryTimer struct{}
+
+type queryTimerKey struct{}
+
+type queryTimerStart struct {
+	command string
+	at      time.Time
+}
+
+func (queryTimer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryTimerKey{}, queryTimerStart{command: sqlCommand(data.SQL), at: time.Now()})
+}
+
+func (queryTimer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryTimerKey{}).(queryTimerStart)
+	if !ok {
+		return
+	}
+	status := "ok"
+	if data.Err != nil {
+		status = "error"
+	}
+	queryDuration.WithLabelValues(start.command, status).Observe(time.Since(start.at).Seconds())
+}
+
+// sqlCommand returns the upper-cased leading keyword of sql (SELECT,
+// INSERT, ...), which keeps the label cardinality small.
+func sqlCommand(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "UNKNOWN"
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// poolStatter is a pool whose statistics can be read: a *pgxpool.Pool, or
+// the livePool of serve, which follows the pool across reconnects.
+type poolStatter interface{ Stat() *pgxpool.Stat }
+
+// poolCollector exports pgxpool statistics, read from the pool at scrape
+// time.
+type poolCollector struct {
+	pool poolStatter
+}
+
+var (
+	poolAcquiredDesc = prometheus.NewDesc("db_pool_acquired_conns", "Connections currently checked out of the pool.", nil, nil)
+	poolIdleDesc     = prometheus.NewDesc("db_pool_idle_conns", "Idle connections in the pool.", nil, nil)
+	poolNewDesc      = prometheus.NewDesc("db_pool_constructing_conns", "Connections being established.", nil, nil)
+	poolTotalDesc    = prometheus.NewDesc("db_pool_total_conns", "Open connections, acquired, idle or being established.", nil, nil)
+	poolMaxDesc      = prometheus.NewDesc("db_pool_max_conns", "Maximum size of the pool (DB_MAX_CONNS).", nil, nil)
+	poolAcquiresDesc = prometheus.NewDesc("db_pool_acquires_total", "Successful acquires from the pool.", nil, nil)
+	poolWaitsDesc    = prometheus.NewDesc("db_pool_empty_acquires_total", "Acquires that had to wait for a free connection.", nil, nil)
+	poolWaitDesc     = prometheus.NewDesc("db_pool_acquire_wait_seconds_total", "Time spent waiting for a connection.", nil, nil)
+)
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(c, ch)
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredDesc, prometheus.GaugeValue, float64(s.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleDesc, prometheus.GaugeValue, float64(s.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolNewDesc, prometheus.GaugeValue, float64(s.ConstructingConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalDesc, prometheus.GaugeValue, float64(s.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxDesc, prometheus.GaugeValue, float64(s.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquiresDesc, prometheus.CounterValue, float64(s.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitsDesc, prometheus.CounterValue, float64(s.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolWaitDesc, prometheus.CounterValue, s.AcquireDuration().Seconds())
+}
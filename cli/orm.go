@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// poolUserRepository returns the users repository on pool that the
+// decorators of newRoutedUserRepository wrap: a PostgresRepository, or
+// with ORM=gorm a GormRepository drawing its connections from the same
+// pool, so both share its tracer, timeouts and session settings. The
+// app's user hooks need the first, whose transactions they join.
+func (a *app) poolUserRepository(pool resettablePool) (users.Repository, error) {
+	if a.cfg().Database.ORM != "gorm" {
+		return a.pgxUserRepository(pool), nil
+	}
+	if !a.hooks.Empty() {
+		return nil, errors.New("user hooks are registered, and they run only on the pgx repository; unset ORM=gorm")
+	}
+	db, err := openGorm(pool)
+	if err != nil {
+		return nil, err
+	}
+	return users.NewGormRepository(db, a.userRepositoryOptions()), nil
+}
+
+// openGorm opens GORM on the connections of pool. GORM's own logger is
+// silenced: the pgx tracer already logs every statement with LOG_SQL,
+// GORM's included, and GORM would report a lookup that finds no user as
+// an error. QueryFields lists the columns instead of SELECT *, and
+// SkipDefaultTransaction leaves single statements out of a transaction, as
+// the pgx repository does.
+func openGorm(pool resettablePool) (*gorm.DB, error) {
+	sqlDB := sql.OpenDB(poolConnector{pool})
+	// Idle connections belong in the pool, where pgx can use them too
+	sqlDB.SetMaxIdleConns(0)
+	return gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:                 logger.Discard,
+		QueryFields:            true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+	})
+}
+
+// poolConnector hands database/sql connections acquired from pool. With
+// serve's livePool they come from whichever pool is current, so GORM
+// follows a reconnect as the pgx repository does.
+type poolConnector struct {
+	pool resettablePool
+}
+
+func (c poolConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var pool *pgxpool.Pool
+	switch p := c.pool.(type) {
+	case *livePool:
+		pool = p.current.Load()
+	case *pgxpool.Pool:
+		pool = p
+	default:
+		return nil, fmt.Errorf("ORM=gorm cannot draw connections from a %T", c.pool)
+	}
+	return stdlib.GetPoolConnector(pool).Connect(ctx)
+}
+
+func (poolConnector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
@@ -0,0 +1,329 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/segmentio/kafka-go"
+	"github.com/spf13/cobra"
+)
+
+var outboxEvents = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "users_outbox_events_total",
+	Help: "Outbox events handed to the message broker, by result: published or failed (left in the outbox for a retry).",
+}, []string{"result"})
+
+// publishTimeout bounds how long the relay waits for the broker to accept
+// one event.
+const publishTimeout = 10 * time.Second
+
+// outboxTable returns the quoted, schema-qualified name of the outbox table
+// that migration 0011 creates.
+func (a *app) outboxTable() string {
+	return pgx.Identifier{a.dbSchema(), "users_outbox"}.Sanitize()
+}
+
+// outboxEvent is one row of users_outbox.
+type outboxEvent struct {
+	ID        int64           `db:"id"`
+	EventType string          `db:"event_type"`
+	Payload   json.RawMessage `db:"payload"`
+	CreatedAt time.Time       `db:"created_at"`
+}
+
+// outboxMessage is the JSON body published for an event. EventID lets
+// consumers drop the duplicates that at-least-once delivery allows.
+type outboxMessage struct {
+	Type       string          `json:"type"`
+	EventID    int64           `json:"event_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	User       json.RawMessage `json:"user"`
+}
+
+// key returns the user id of the event, which Kafka partitions by.
+func (e outboxEvent) key() string {
+	var user struct {
+		ID json.RawMessage `json:"id"`
+	}
+	json.Unmarshal(e.Payload, &user)
+	return strings.Trim(string(user.ID), `"`)
+}
+
+// eventPublisher delivers events to the message broker. Publish returns
+// once the broker has accepted the message, so the relay may delete it
+// from the outbox.
+type eventPublisher interface {
+	Publish(ctx context.Context, key string, body []byte) error
+	Close() error
+}
+
+// newEventPublisher connects to the broker of BROKER_URL: NATS for nats://
+// and tls:// URLs, Kafka for kafka://host1:9092,host2:9092. Both clients
+// reconnect on their own, so a broker that is down makes Publish fail
+// rather than this.
+func newEventPublisher(rawURL, topic string) (eventPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BROKER_URL: %w", err)
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+		nc, err := nats.Connect(rawURL, nats.Name("go-postgres outbox relay"), nats.MaxReconnects(-1), nats.RetryOnFailedConnect(true))
+		if err != nil {
+			return nil, fmt.Errorf("connecting to NATS: %w", err)
+		}
+		return natsPublisher{conn: nc, subject: topic}, nil
+	case "kafka":
+		w := &kafka.Writer{
+			Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+		}
+		return kafkaPublisher{w}, nil
+	}
+	return nil, fmt.Errorf("unsupported BROKER_URL scheme %q", u.Scheme)
+}
+
+// natsPublisher publishes on a NATS subject. Flushing after each message
+// waits for the server's PONG, so Publish only succeeds once the server
+// has the message.
+type natsPublisher struct {
+	conn    *nats.Conn
+	subject string
+}
+
+func (p natsPublisher) Publish(ctx context.Context, _ string, body []byte) error {
+	if err := p.conn.Publish(p.subject, body); err != nil {
+		return err
+	}
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p natsPublisher) Close() error {
+	return p.conn.Drain()
+}
+
+// kafkaPublisher writes to a Kafka topic, keyed by user id, and waits for
+// every in-sync replica to acknowledge.
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func (p kafkaPublisher) Publish(ctx context.Context, key string, body []byte) error {
+	return p.w.WriteMessages(ctx, kafka.Message{Key: []byte(key), Value: body})
+}
+
+func (p kafkaPublisher) Close() error {
+	return p.w.Close()
+}
+
+// relayOutbox publishes the events in users_outbox until ctx is done. It
+// polls every OUTBOX_POLL_INTERVAL, at once again while full batches keep
+// coming, and backs off up to 30s while the broker or the database fails.
+// Events are never lost: one that was not accepted stays in the outbox and
+// is retried.
+func (a *app) relayOutbox(ctx context.Context, b db.Beginner, pub eventPublisher) {
+	const maxBackoff = 30 * time.Second
+	var backoff time.Duration
+	for {
+		n, err := a.relayOutboxBatch(ctx, b, pub)
+		if ctx.Err() != nil {
+			return
+		}
+		wait := a.cfg().App.OutboxPollInterval
+		switch {
+		case err != nil:
+			backoff = min(max(2*backoff, time.Second), maxBackoff)
+			wait = backoff
+			slog.Warn("outbox relay failed", "err", err, "retry_in", wait)
+		case n == a.cfg().App.OutboxBatchSize:
+			backoff, wait = 0, 0
+		default:
+			backoff = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// relayOutboxBatch publishes up to OUTBOX_BATCH_SIZE events, oldest first,
+// and deletes those the broker accepted, in one transaction. The rows are
+// locked with SKIP LOCKED, so several relays share the outbox without
+// publishing an event twice, though not in strict order. Publishing stops
+// at the first failure, which is recorded on the event's row.
+func (a *app) relayOutboxBatch(ctx context.Context, b db.Beginner, pub eventPublisher) (int, error) {
+	var published int
+	var publishErr error
+	err := a.withTx(ctx, b, func(tx pgx.Tx) error {
+		published, publishErr = 0, nil
+		events, err := db.Select[outboxEvent](ctx, tx, "SELECT id, event_type, payload, created_at FROM "+a.outboxTable()+
+			" ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", a.cfg().App.OutboxBatchSize)
+		if err != nil {
+			return err
+		}
+
+		done := make([]int64, 0, len(events))
+		for _, e := range events {
+			body, err := json.Marshal(outboxMessage{Type: e.EventType, EventID: e.ID, OccurredAt: e.CreatedAt, User: e.Payload})
+			if err != nil {
+				return err
+			}
+			publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+			publishErr = pub.Publish(publishCtx, e.key(), body)
+			cancel()
+			if publishErr != nil {
+				outboxEvents.WithLabelValues("failed").Inc()
+				_, err := tx.Exec(ctx, "UPDATE "+a.outboxTable()+" SET attempts = attempts + 1, last_error = $2 WHERE id = $1", e.ID, publishErr.Error())
+				if err != nil {
+					return err
+				}
+				break
+			}
+			done = append(done, e.ID)
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM "+a.outboxTable()+" WHERE id = ANY($1)", done); err != nil {
+			return err
+		}
+		published = len(done)
+		return nil
+	})
+	if err != nil {
+		// Nothing was deleted, so the events published in this batch will
+		// be published again
+		return 0, err
+	}
+	outboxEvents.WithLabelValues("published").Add(float64(published))
+	if publishErr != nil {
+		return published, fmt.Errorf("publishing event: %w", publishErr)
+	}
+	return published, nil
+}
+
+// startOutboxRelay connects to BROKER_URL and relays the outbox of b in the
+// background until ctx is done or the returned function is called, which
+// waits for the relay to stop and closes the broker connection. It does
+// nothing when BROKER_URL is unset.
+func (a *app) startOutboxRelay(ctx context.Context, b db.Beginner) (stop func(), err error) {
+	if a.cfg().App.BrokerURL == "" {
+		return func() {}, nil
+	}
+	pub, err := newEventPublisher(a.cfg().App.BrokerURL, a.cfg().App.BrokerTopic)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		a.relayOutbox(ctx, b, pub)
+	}()
+	slog.Info("outbox relay started", "topic", a.cfg().App.BrokerTopic)
+	return func() {
+		cancel()
+		<-done
+		if err := pub.Close(); err != nil {
+			slog.Warn("closing broker connection failed", "err", err)
+		}
+	}, nil
+}
+
+// newOutboxCmd builds the outbox command group.
+func newOutboxCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "outbox",
+		Short: "Relay and inspect the UserCreated events waiting in the outbox",
+	}
+	cmd.AddCommand(migratesSchema(&cobra.Command{
+		Use:   "relay",
+		Short: "Publish outbox events to BROKER_URL until interrupted",
+		Long: `Publish the UserCreated events of users_outbox to the NATS subject or
+Kafka topic BROKER_TOPIC at BROKER_URL, deleting each once the broker has
+accepted it. serve runs the same relay when BROKER_URL is set; several
+relays may run at once.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runOutboxRelay(cmd.Context())
+		},
+	}), &cobra.Command{
+		Use:   "status",
+		Short: "Print how many events are waiting and the last publish error",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runOutboxStatus(cmd.Context())
+		},
+	})
+	return cmd
+}
+
+// runOutboxRelay implements outbox relay.
+func (a *app) runOutboxRelay(ctx context.Context) error {
+	if a.cfg().App.BrokerURL == "" {
+		return errors.New("BROKER_URL is not set")
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	stop, err := a.startOutboxRelay(ctx, pool)
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	stop()
+	slog.Info("outbox relay stopped")
+	return nil
+}
+
+// runOutboxStatus implements outbox status.
+func (a *app) runOutboxStatus(ctx context.Context) error {
+	if a.isCockroach() {
+		return fmt.Errorf("the outbox is filled by a PostgreSQL trigger; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	var pending int64
+	var oldest *time.Time
+	var maxAttempts int
+	var lastError *string
+	err = pool.QueryRow(ctx, `SELECT count(*), min(created_at), coalesce(max(attempts), 0),
+		(SELECT last_error FROM `+a.outboxTable()+` WHERE last_error IS NOT NULL ORDER BY id LIMIT 1)
+		FROM `+a.outboxTable()).Scan(&pending, &oldest, &maxAttempts, &lastError)
+	if err != nil {
+		if isUndefinedTable(err) {
+			return errors.New("users_outbox does not exist; run migrate first")
+		}
+		return err
+	}
+	fmt.Printf("pending events: %d\n", pending)
+	if oldest != nil {
+		fmt.Printf("oldest: %s (%s ago)\n", a.displayTime(*oldest).Format(displayTimeLayout), time.Since(*oldest).Round(time.Second))
+	}
+	if lastError != nil {
+		fmt.Printf("failed attempts: up to %d; first error: %s\n", maxAttempts, *lastError)
+	}
+	return nil
+}
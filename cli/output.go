@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"go.yaml.in/yaml/v3"
+)
+
+// structuredOutput reports whether OUTPUT (--output) asks for JSON or YAML
+// rather than the tables written for people.
+func (a *app) structuredOutput() bool {
+	return a.cfg().App.Output == "json" || a.cfg().App.Output == "yaml"
+}
+
+// displayTimeLayout is the layout of the times in tables, followed by the
+// zone's abbreviation so a reader never has to guess it.
+const displayTimeLayout = time.DateTime + " MST"
+
+// displayTime returns t in DISPLAY_TIMEZONE, the zone of every time printed
+// for people to read. JSON and YAML output, the APIs and the logs write
+// times as RFC 3339 with their offset instead, and are left alone.
+func (a *app) displayTime(t time.Time) time.Time {
+	if loc := a.cfg().App.DisplayZone; loc != nil {
+		return t.In(loc)
+	}
+	return t.UTC()
+}
+
+// writeStructured writes v to w as one JSON or YAML document, per OUTPUT.
+// The field names are those of v's json tags in both formats, so a script
+// can switch between them.
+func (a *app) writeStructured(w io.Writer, v any) error {
+	return writeDocument(w, v, a.cfg().App.Output)
+}
+
+// writeDocument is writeStructured for a command with a format flag of its
+// own: YAML when format is "yaml", JSON otherwise.
+func writeDocument(w io.Writer, v any, format string) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if format != "yaml" {
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	}
+	return writeYAML(w, b)
+}
+
+// writeYAML writes the JSON document b as block-style YAML, keeping the
+// order of its fields.
+func writeYAML(w io.Writer, b []byte) error {
+	// JSON is YAML in flow style, so it parses as it is
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	var blockStyle func(*yaml.Node)
+	blockStyle = func(n *yaml.Node) {
+		n.Style = 0
+		for _, c := range n.Content {
+			blockStyle(c)
+		}
+	}
+	blockStyle(&doc)
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// userStream writes a list of users as they are read, without holding
+// them all, as the users field of a JSON or YAML document whose other
+// fields are given at the end.
+type userStream struct {
+	w    io.Writer
+	yaml bool // --output yaml rather than json
+	n    int
+}
+
+// add writes u.
+func (s *userStream) add(u users.User) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if s.yaml {
+		if s.n == 0 {
+			fmt.Fprintln(s.w, "users:")
+		}
+		s.n++
+		// A one-element list encodes as "- field: ..." lines; indented, they
+		// follow one another as the items of users
+		var item bytes.Buffer
+		if err := writeYAML(&item, append(append([]byte{'['}, b...), ']')); err != nil {
+			return err
+		}
+		for line := range strings.Lines(item.String()) {
+			if _, err := fmt.Fprint(s.w, "  "+line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	sep := ","
+	if s.n == 0 {
+		sep = `{"users":[`
+	}
+	s.n++
+	_, err = fmt.Fprintf(s.w, "%s%s", sep, b)
+	return err
+}
+
+// close ends the document with the fields of rest, an object.
+func (s *userStream) close(rest any) error {
+	b, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	if s.yaml {
+		if s.n == 0 {
+			fmt.Fprintln(s.w, "users: []")
+		}
+		if bytes.Equal(b, []byte("{}")) {
+			return nil
+		}
+		return writeYAML(s.w, b)
+	}
+	if s.n == 0 {
+		fmt.Fprint(s.w, `{"users":[`)
+	}
+	fields := bytes.TrimPrefix(b, []byte("{"))
+	if len(fields) > 1 {
+		fmt.Fprint(s.w, "],")
+	} else {
+		fmt.Fprint(s.w, "]")
+	}
+	_, err = fmt.Fprintf(s.w, "%s\n", fields)
+	return err
+}
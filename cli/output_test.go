@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+)
+
+func TestPrintUsersInDisplayZone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deleted := created.Add(time.Hour)
+	records := []users.User{
+		{ID: "1", Username: "alice", Email: "alice@example.com", CreatedAt: created},
+		{ID: "2", Username: "bob", Email: "bob@example.com", CreatedAt: created.In(time.FixedZone("", -7*3600)), DeletedAt: &deleted},
+	}
+
+	tests := []struct {
+		name string
+		zone *time.Location
+		want []string
+	}{
+		{"unset", nil, []string{"2024-05-01 12:00:00 UTC"}},
+		{"UTC", time.UTC, []string{"2024-05-01 12:00:00 UTC"}},
+		{"Tokyo", tokyo, []string{"2024-05-01 21:00:00 JST", "deleted 2024-05-01 22:00:00 JST"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApp(&config.Config{App: config.App{DisplayZone: tt.zone}})
+			var b strings.Builder
+			a.printUsers(&b, records)
+			lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+			if len(lines) != 3 || !strings.HasPrefix(lines[0], "ID") {
+				t.Fatalf("table = %q", b.String())
+			}
+			// Both rows are the same instant, whatever zone it came in
+			for _, want := range tt.want {
+				if !strings.Contains(lines[2], want) {
+					t.Errorf("row %q lacks %q", lines[2], want)
+				}
+			}
+			if !strings.Contains(lines[1], tt.want[0]) || strings.Contains(lines[1], "deleted") {
+				t.Errorf("row %q, want %q and no deleted cell", lines[1], tt.want[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// newPasswordCmds builds the user subcommands that deal with passwords:
+// register, login and set-password. None of them takes the password as a
+// flag, where it would end up in the shell history and the process list;
+// see readPassword.
+func newPasswordCmds(a *app) []*cobra.Command {
+	var username, email string
+	register := &cobra.Command{
+		Use:   "register",
+		Short: "Insert a user with a password",
+		Long: `Insert a user with a bcrypt-hashed password, hashed at BCRYPT_COST.
+Unlike insert, a taken username or email is always an error.
+
+The password is prompted for (twice) on a terminal, or read from the first
+line of standard input otherwise.`,
+		Example: `  user register --username alice --email alice@example.com
+  printf '%s\n' "$ALICE_PASSWORD" | user register --username alice --email alice@example.com`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			u := users.User{Username: username, Email: email}
+			if err := users.Validate(u); err != nil {
+				return err
+			}
+			password, err := readNewPassword()
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				if err := repo.Register(ctx, &u, password); err != nil {
+					return fmt.Errorf("registering %s: %w", username, err)
+				}
+				slog.Info("user registered", "id", u.ID, "username", u.Username)
+				return nil
+			})
+		},
+	}
+	register.Flags().StringVar(&username, "username", "", "username of the new user")
+	register.Flags().StringVar(&email, "email", "", "email address of the new user")
+	register.MarkFlagRequired("username")
+	register.MarkFlagRequired("email")
+
+	var loginUsername string
+	login := &cobra.Command{
+		Use:   "login",
+		Short: "Check a username and password and print the user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := readPassword("Password: ")
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				u, err := repo.Authenticate(ctx, loginUsername, password)
+				if err != nil {
+					return err
+				}
+				a.printUser(os.Stdout, u)
+				return nil
+			})
+		},
+	}
+	login.Flags().StringVar(&loginUsername, "username", "", "username to log in as")
+	login.MarkFlagRequired("username")
+
+	var setKey userKey
+	set := &cobra.Command{
+		Use:   "set-password",
+		Short: "Replace a user's password",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := readNewPassword()
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				username, err := setKey.username(ctx, repo)
+				if err != nil {
+					return err
+				}
+				if err := repo.SetPassword(ctx, username, password); err != nil {
+					return fmt.Errorf("user %s: %w", setKey, err)
+				}
+				slog.Info("password set", "username", username)
+				return nil
+			})
+		},
+	}
+	bindUserKey(set, &setKey)
+
+	return []*cobra.Command{register, login, set}
+}
+
+// readNewPassword reads a password to store. On a terminal it asks twice,
+// so a typo does not lock the user out, and checks the length limits
+// before anything is sent to the database.
+func readNewPassword() (string, error) {
+	password, err := readPassword("New password: ")
+	if err != nil {
+		return "", err
+	}
+	if err := users.ValidatePassword(password); err != nil {
+		return "", err
+	}
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		again, err := readPassword("Repeat password: ")
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+// readPassword prompts for a password without echoing it when standard
+// input is a terminal, and otherwise reads the first line of standard
+// input, so scripts can pipe one in.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("reading password: %w", err)
+		}
+		return string(b), nil
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading password: %w", err)
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", errors.New("no password on standard input")
+	}
+	return line, nil
+}
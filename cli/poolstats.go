@@ -0,0 +1,59 @@
+package cli
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// reportPoolStats logs the statistics of pool every interval until ctx is
+// done. name tells the pools of the primary and the replicas apart.
+//
+// The gauges (acquired, idle, constructing) are read at the time of the
+// event; the acquire counts and waits cover the interval since the last
+// one, so a burst shows up in the event after it. An event is a warning
+// when every connection was checked out and acquires had to wait: the
+// pool is exhausted, and DB_MAX_CONNS or the work done per connection
+// needs a look.
+func reportPoolStats(ctx context.Context, name string, pool poolStatter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	prev := pool.Stat()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s := pool.Stat()
+		acquires := s.AcquireCount() - prev.AcquireCount()
+		waits := s.EmptyAcquireCount() - prev.EmptyAcquireCount()
+		waited := s.AcquireDuration() - prev.AcquireDuration()
+		canceled := s.CanceledAcquireCount() - prev.CanceledAcquireCount()
+		if acquires < 0 {
+			// The pool was replaced (SIGHUP) and its counters restarted
+			acquires, waits, waited, canceled = s.AcquireCount(), s.EmptyAcquireCount(), s.AcquireDuration(), s.CanceledAcquireCount()
+		}
+		prev = s
+
+		var avgWait time.Duration
+		if acquires > 0 {
+			avgWait = waited / time.Duration(acquires)
+		}
+		level := slog.LevelInfo
+		if waits > 0 && s.AcquiredConns() >= s.MaxConns() {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "pool stats",
+			"pool", name,
+			"acquired", s.AcquiredConns(),
+			"idle", s.IdleConns(),
+			"constructing", s.ConstructingConns(),
+			"max", s.MaxConns(),
+			"acquires", acquires,
+			"waited_acquires", waits,
+			"canceled_acquires", canceled,
+			"avg_acquire_wait", avgWait.Round(time.Microsecond),
+		)
+	}
+}
@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// postsTableName is the table of migration 0015.
+const postsTableName = "posts"
+
+func (a *app) postsTable() string {
+	return pgx.Identifier{a.dbSchema(), postsTableName}.Sanitize()
+}
+
+// newPostsCmd builds the posts command group, which writes and reads the
+// posts table of migration 0015.
+func newPostsCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "posts",
+		Short: "Write posts for users and count them per user",
+		Long: `An example of a second table related to users: each post belongs to a user
+through a foreign key and is deleted with it. posts create adds a post,
+posts list prints the posts of a user and posts counts joins users with
+their posts to count them. Needs PostgreSQL; serve also offers
+POST and GET /users/{id}/posts and GET /users/post-counts.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.usesSQLDB() || a.isCockroach() {
+				return fmt.Errorf("the posts table is created by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+
+	var createKey userKey
+	var post users.Post
+	create := &cobra.Command{
+		Use:     "create",
+		Short:   "Add a post written by a user",
+		Example: `  go run ./cmd/go-postgres posts create --username alice --title "Hello" --body "My first post"`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := users.ValidatePost(post); err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				u, err := createKey.find(ctx, repo)
+				if err != nil {
+					return fmt.Errorf("user %s: %w", createKey, err)
+				}
+				post.UserID = u.ID
+				if err := repo.CreatePost(ctx, &post); err != nil {
+					return fmt.Errorf("creating post: %w", err)
+				}
+				slog.Info("post created", "username", u.Username, "id", post.ID)
+				return nil
+			})
+		},
+	}
+	bindUserKey(create, &createKey)
+	create.Flags().StringVar(&post.Title, "title", "", "title of the post (at most 200 characters)")
+	create.Flags().StringVar(&post.Body, "body", "", "text of the post")
+	create.MarkFlagRequired("title")
+
+	var listKey userKey
+	var listLimit int
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "Print the posts of a user, newest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				u, err := listKey.find(ctx, repo)
+				if err != nil {
+					return fmt.Errorf("user %s: %w", listKey, err)
+				}
+				posts, err := repo.Posts(ctx, u.ID, listLimit)
+				if err != nil {
+					return fmt.Errorf("listing posts: %w", err)
+				}
+				return a.printPosts(posts)
+			})
+		},
+	}
+	bindUserKey(list, &listKey)
+	list.Flags().IntVar(&listLimit, "limit", 20, "print at most this many posts (0 for all)")
+
+	var countsLimit int
+	counts := &cobra.Command{
+		Use:   "counts",
+		Short: "Print users with the number of posts each has written, most first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				counts, err := repo.PostCounts(ctx, countsLimit)
+				if err != nil {
+					return fmt.Errorf("counting posts: %w", err)
+				}
+				return a.printPostCounts(counts)
+			})
+		},
+	}
+	counts.Flags().IntVar(&countsLimit, "limit", 20, "print at most this many users (0 for all)")
+
+	cmd.AddCommand(create, list, counts)
+	return cmd
+}
+
+// printPosts writes posts as a table, or as a document with --output json
+// or yaml.
+func (a *app) printPosts(posts []users.Post) error {
+	if a.structuredOutput() {
+		if posts == nil {
+			posts = []users.Post{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"posts": posts})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCREATED AT\tTITLE")
+	for _, p := range posts {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", p.ID, a.displayTime(p.CreatedAt).Format(displayTimeLayout), p.Title)
+	}
+	return tw.Flush()
+}
+
+// printPostCounts writes counts as a table, or as a document with --output
+// json or yaml.
+func (a *app) printPostCounts(counts []users.UserPosts) error {
+	if a.structuredOutput() {
+		if counts == nil {
+			counts = []users.UserPosts{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"users": counts})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tPOSTS")
+	for _, c := range counts {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", c.ID, c.Username, c.Email, c.Posts)
+	}
+	return tw.Flush()
+}
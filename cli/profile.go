@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newProfileCmd builds the profile command group, which works with the
+// JSONB profile column of the users table.
+func newProfileCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Get, set, merge or search user profiles (JSONB)",
+	}
+
+	var getKey userKey
+	get := &cobra.Command{
+		Use:   "get",
+		Short: "Print a user's profile as JSON",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				username, err := getKey.username(ctx, repo)
+				if err != nil {
+					return err
+				}
+				p, err := repo.Profile(ctx, username)
+				if err != nil {
+					return fmt.Errorf("user %s: %w", getKey, err)
+				}
+				return printProfile(p)
+			})
+		},
+	}
+	bindUserKey(get, &getKey)
+
+	var setKey userKey
+	var setJSON string
+	set := &cobra.Command{
+		Use:   "set",
+		Short: "Replace a user's profile",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := parseProfile(setJSON)
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				username, err := setKey.username(ctx, repo)
+				if err != nil {
+					return err
+				}
+				if err := repo.SetProfile(ctx, username, p); err != nil {
+					return fmt.Errorf("user %s: %w", setKey, err)
+				}
+				slog.Info("profile set", "username", username)
+				return nil
+			})
+		},
+	}
+	bindUserKey(set, &setKey)
+	set.Flags().StringVar(&setJSON, "json", "", `profile as JSON, e.g. '{"display_name":"Alice","tags":["admin"]}'`)
+	set.MarkFlagRequired("json")
+
+	var mergeKey userKey
+	var mergeJSON string
+	merge := &cobra.Command{
+		Use:   "merge",
+		Short: "Overwrite some profile fields and print the result",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			patch, err := parseProfile(mergeJSON)
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				username, err := mergeKey.username(ctx, repo)
+				if err != nil {
+					return err
+				}
+				p, err := repo.MergeProfile(ctx, username, patch)
+				if err != nil {
+					return fmt.Errorf("user %s: %w", mergeKey, err)
+				}
+				return printProfile(p)
+			})
+		},
+	}
+	bindUserKey(merge, &mergeKey)
+	merge.Flags().StringVar(&mergeJSON, "json", "", `fields to overwrite as JSON, e.g. '{"locale":"fr-FR"}'`)
+	merge.MarkFlagRequired("json")
+
+	var findJSON string
+	var page users.Page
+	find := &cobra.Command{
+		Use:   "find",
+		Short: "Print the users whose profile contains the given fields",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			match, err := parseProfile(findJSON)
+			if err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				records, err := repo.FindByProfile(ctx, match, page)
+				if err != nil {
+					return fmt.Errorf("searching profiles failed: %w", err)
+				}
+				a.printUsers(os.Stdout, records)
+				return nil
+			})
+		},
+	}
+	find.Flags().StringVar(&findJSON, "json", "", `fields to match as JSON, e.g. '{"tags":["admin"]}'`)
+	find.Flags().IntVar(&page.Limit, "limit", 0, "print at most this many users (0 for all)")
+	find.Flags().IntVar(&page.Offset, "offset", 0, "skip this many users first")
+	find.MarkFlagRequired("json")
+
+	cmd.AddCommand(get, set, merge, find)
+	return cmd
+}
+
+// parseProfile decodes a --json flag, rejecting fields users.Profile does
+// not have so a typo is not silently dropped.
+func parseProfile(s string) (users.Profile, error) {
+	var p users.Profile
+	dec := json.NewDecoder(bytes.NewBufferString(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return p, fmt.Errorf("invalid --json: %w", err)
+	}
+	return p, nil
+}
+
+// printProfile writes p to stdout as indented JSON.
+func printProfile(p *users.Profile) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
@@ -0,0 +1,312 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/term"
+)
+
+// barRedrawInterval is how often a progress bar is redrawn, however long
+// PROGRESS_INTERVAL is.
+const barRedrawInterval = 250 * time.Millisecond
+
+// progressState is what a progressReporter shows: done of total rows
+// (total is 0 when not known in advance) after elapsed.
+type progressState struct {
+	Operation string
+	Unit      string
+	Done      int64
+	Total     int64
+	Elapsed   time.Duration
+}
+
+// rate returns the rows per second so far.
+func (s progressState) rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Done) / s.Elapsed.Seconds()
+}
+
+// eta returns how long the remaining rows should take at the rate so far,
+// and false when that cannot be told.
+func (s progressState) eta() (time.Duration, bool) {
+	r := s.rate()
+	if s.Total <= 0 || r <= 0 || s.Done > s.Total {
+		return 0, false
+	}
+	return time.Duration(float64(s.Total-s.Done) / r * float64(time.Second)), true
+}
+
+// progressReporter shows the progress of a client-side operation.
+type progressReporter interface {
+	// report shows s; it is called periodically from one goroutine.
+	report(s progressState)
+	// clear removes whatever report left on screen before the summary.
+	clear()
+}
+
+// logProgress reports progress as "progress" log events.
+type logProgress struct{}
+
+func (logProgress) report(s progressState) {
+	attrs := []any{"operation", s.Operation, s.Unit, s.Done}
+	if s.Total > 0 {
+		attrs = append(attrs, s.Unit+"_total", s.Total)
+	}
+	attrs = append(attrs, s.Unit+"_per_second", int64(s.rate()))
+	if eta, ok := s.eta(); ok {
+		attrs = append(attrs, "eta", eta.Round(time.Second))
+	}
+	slog.Info("progress", attrs...)
+}
+
+func (logProgress) clear() {}
+
+// barProgress redraws one line on w, a terminal:
+//
+//	import  [=========>          ]  45%  45000/100000 rows  12345/s  ETA 4s
+//
+// Without a total the bar is left out.
+type barProgress struct {
+	w io.Writer
+}
+
+func (b barProgress) report(s progressState) {
+	var line strings.Builder
+	fmt.Fprintf(&line, "%s  ", s.Operation)
+	if s.Total > 0 {
+		const width = 20
+		frac := min(1, float64(s.Done)/float64(s.Total))
+		filled := int(frac * width)
+		bar := strings.Repeat("=", filled)
+		if filled < width {
+			bar += ">" + strings.Repeat(" ", width-filled-1)
+		}
+		fmt.Fprintf(&line, "[%s] %3.0f%%  %d/%d %s", bar, frac*100, s.Done, s.Total, s.Unit)
+	} else {
+		fmt.Fprintf(&line, "%d %s", s.Done, s.Unit)
+	}
+	fmt.Fprintf(&line, "  %.0f/s", s.rate())
+	if eta, ok := s.eta(); ok {
+		fmt.Fprintf(&line, "  ETA %s", eta.Round(time.Second))
+	} else {
+		fmt.Fprintf(&line, "  %s", s.Elapsed.Round(time.Second))
+	}
+	// Carriage return and erase the line, so a shorter line leaves nothing
+	// of the last one
+	fmt.Fprintf(b.w, "\r\033[K%s", line.String())
+}
+
+func (b barProgress) clear() {
+	fmt.Fprint(b.w, "\r\033[K")
+}
+
+// progress tracks a long client-side operation, such as a seed, an import,
+// an export or a backup, and has its progressReporter show the rows done,
+// the rate and, when the total is known, the time left. It reports every
+// PROGRESS_INTERVAL, or redraws a bar more often, until Finish or until
+// ctx is done. A nil *progress, as startProgress returns when
+// PROGRESS_INTERVAL is 0, does nothing.
+type progress struct {
+	ctx      context.Context
+	state    progressState // Operation, Unit and Total
+	start    time.Time
+	done     atomic.Int64
+	reporter progressReporter
+	stop     chan struct{}
+	stopped  chan struct{}
+	finish   sync.Once
+}
+
+// startProgress starts tracking operation, which processes total units
+// (0 when not known in advance), reporting as PROGRESS says: a bar on
+// stderr, log events, or by default a bar when stderr is a terminal and
+// the logs are text.
+func (a *app) startProgress(ctx context.Context, operation, unit string, total int64) *progress {
+	interval := a.cfg().App.ProgressInterval
+	if interval <= 0 {
+		return nil
+	}
+	var reporter progressReporter = logProgress{}
+	switch a.cfg().App.Progress {
+	case "bar":
+		reporter = barProgress{os.Stderr}
+	case "", "auto":
+		if a.cfg().App.LogFormat != "json" && term.IsTerminal(int(os.Stderr.Fd())) {
+			reporter = barProgress{os.Stderr}
+		}
+	}
+	if _, ok := reporter.(barProgress); ok {
+		interval = min(interval, barRedrawInterval)
+	}
+	p := &progress{
+		ctx:      ctx,
+		state:    progressState{Operation: operation, Unit: unit, Total: total},
+		start:    time.Now(),
+		reporter: reporter,
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go p.run(interval)
+	return p
+}
+
+func (p *progress) run(interval time.Duration) {
+	defer close(p.stopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			p.reporter.report(p.snapshot())
+		}
+	}
+}
+
+func (p *progress) snapshot() progressState {
+	s := p.state
+	s.Done = p.done.Load()
+	s.Elapsed = time.Since(p.start)
+	return s
+}
+
+// Add counts n more units done. It is safe for concurrent use.
+func (p *progress) Add(n int64) {
+	if p != nil {
+		p.done.Add(n)
+	}
+}
+
+// Finish stops the reporting. When the operation was cancelled, by Ctrl-C
+// or SIGTERM, it logs a summary of what was done before it stopped; the
+// command logs its own outcome otherwise. Only the first call does
+// anything, so it can be deferred and called early too.
+func (p *progress) Finish() {
+	if p != nil {
+		p.finish.Do(p.stopReporting)
+	}
+}
+
+func (p *progress) stopReporting() {
+	close(p.stop)
+	<-p.stopped
+	p.reporter.clear()
+	if p.ctx.Err() == nil {
+		return
+	}
+	s := p.snapshot()
+	attrs := []any{"operation", s.Operation, s.Unit, s.Done}
+	if s.Total > 0 {
+		attrs = append(attrs, s.Unit+"_total", s.Total)
+	}
+	attrs = append(attrs, "elapsed", s.Elapsed.Round(time.Millisecond), s.Unit+"_per_second", int64(s.rate()))
+	slog.Warn(s.Operation+" interrupted", attrs...)
+}
+
+// progressWriter counts the lines written through it as units done of p,
+// for output with one row per line such as COPY's text and CSV formats
+// and NDJSON. The first skip lines, such as a CSV header, are not counted.
+type progressWriter struct {
+	w    io.Writer
+	p    *progress
+	skip int
+}
+
+func (pw *progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	lines := int64(bytes.Count(b[:n], []byte{'\n'}))
+	if pw.skip > 0 {
+		skipped := min(int64(pw.skip), lines)
+		pw.skip -= int(skipped)
+		lines -= skipped
+	}
+	pw.p.Add(lines)
+	return n, err
+}
+
+// watchServerProgress logs the progress of the statement running on backend
+// pid every PROGRESS_INTERVAL until ctx is cancelled. It queries through
+// another pooled connection because the watched one is busy with the long
+// statement.
+//
+// COPY progress comes from pg_stat_progress_copy ("copied 40000 of ~100000
+// rows", using total as the estimate when positive). Servers older than
+// PostgreSQL 14 lack that view; there it falls back to pg_stat_activity and
+// reports how long the statement has been running. A zero interval
+// disables progress reporting.
+func (a *app) watchServerProgress(ctx context.Context, pool *pgxpool.Pool, pid uint32, total int64) {
+	interval := a.cfg().App.ProgressInterval
+	if interval <= 0 {
+		return
+	}
+
+	useCopyView := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if useCopyView {
+			var processed int64
+			err := pool.QueryRow(ctx, "SELECT tuples_processed FROM pg_stat_progress_copy WHERE pid = $1", pid).Scan(&processed)
+			switch {
+			case err == nil:
+				if total > 0 {
+					slog.Info("progress", "rows_copied", processed, "rows_estimated", total)
+				} else {
+					slog.Info("progress", "rows_copied", processed)
+				}
+				continue
+			case errors.Is(err, pgx.ErrNoRows):
+				continue // the COPY has not started yet or already finished
+			case isUndefinedTable(err):
+				useCopyView = false
+			default:
+				if ctx.Err() == nil {
+					slog.Warn("progress query failed", "err", err)
+				}
+				return
+			}
+		}
+
+		var state string
+		var elapsed time.Duration
+		err := pool.QueryRow(ctx, "SELECT state, now() - query_start FROM pg_stat_activity WHERE pid = $1", pid).Scan(&state, &elapsed)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, pgx.ErrNoRows) {
+				slog.Warn("progress query failed", "err", err)
+			}
+			continue
+		}
+		slog.Info("progress", "state", state, "elapsed", elapsed.Round(time.Second))
+	}
+}
+
+// isUndefinedTable reports whether err is SQLState 42P01 (undefined_table),
+// which is how a missing system view shows up on older servers.
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42P01"
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// defaultPurgeRetention is how long purge keeps soft-deleted users by
+// default, long enough to undo a mistaken delete.
+const defaultPurgeRetention = 30 * 24 * time.Hour
+
+// newPurgeCmd builds the purge command, which removes for good the users
+// deleted longer ago than a retention period.
+func newPurgeCmd(a *app) *cobra.Command {
+	var olderThan time.Duration
+	cmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Remove soft-deleted users for good once they are older than --older-than",
+		Long: `Deleting a user only marks it with a deleted_at time: every other command,
+and the APIs of serve, then behave as if it were gone, but its row stays,
+and so do its username and email, which cannot be reused until it is
+purged. Creating the same username again restores it.
+
+purge removes the rows marked longer ago than --older-than. Rows that
+another table still refers to cannot be removed, and fail the command.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if olderThan < 0 {
+				return fmt.Errorf("--older-than must not be negative")
+			}
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				n, err := repo.Purge(ctx, olderThan)
+				if err != nil {
+					return fmt.Errorf("purging users failed: %w", err)
+				}
+				slog.Info("users purged", "rows_affected", n, "older_than", olderThan)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().DurationVar(&olderThan, "older-than", defaultPurgeRetention, "remove the users deleted longer ago than this")
+	return cmd
+}
@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"golang.org/x/time/rate"
+)
+
+var writeThrottleSeconds = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+	Name: "users_write_throttle_seconds_total",
+	Help: "Time writes spent waiting for the MAX_WRITES_PER_SEC rate limiter.",
+})
+
+// newWriteLimiter returns the token bucket of MAX_WRITES_PER_SEC, which
+// lets every write through when no limit is set; see app.writeLimiter. It
+// is made either way, so a reload can set a limit on the repositories
+// already built.
+func (a *app) newWriteLimiter() *rate.Limiter {
+	return rate.NewLimiter(writeLimit(a.cfg().App))
+}
+
+// writeLimit returns the rate and burst of MAX_WRITES_PER_SEC and
+// WRITE_BURST: rate.Inf when no limit is set, and one second's worth of
+// writes when no burst is.
+func writeLimit(cfg config.App) (rate.Limit, int) {
+	perSec := cfg.MaxWritesPerSec
+	if perSec <= 0 {
+		return rate.Inf, 0
+	}
+	burst := cfg.WriteBurst
+	if burst == 0 {
+		burst = max(1, int(math.Ceil(perSec)))
+	}
+	return rate.Limit(perSec), burst
+}
+
+// setWriteLimit applies the MAX_WRITES_PER_SEC and WRITE_BURST of cfg to
+// the writes to come, as a reload does.
+func (a *app) setWriteLimit(cfg config.App) {
+	limit, burst := writeLimit(cfg)
+	limiter := a.writeLimiter()
+	limiter.SetLimit(limit)
+	limiter.SetBurst(burst)
+}
+
+// limitWrites wraps repo so each written row takes a token from
+// writeLimiter.
+func (a *app) limitWrites(repo users.Repository) users.Repository {
+	return rateLimitedRepository{Repository: repo, limiter: a.writeLimiter()}
+}
+
+// rateLimitedRepository delays writes to stay under a rows-per-second
+// budget, so a bulk seed against a shared database leaves it room for
+// other clients. Reads pass straight through. A write whose context is
+// cancelled, or whose deadline would pass while waiting, fails without
+// reaching the database.
+type rateLimitedRepository struct {
+	users.Repository
+	limiter *rate.Limiter
+}
+
+// wait takes n tokens. A batch larger than the bucket is let through in
+// bucket-sized steps, because WaitN refuses more than the burst at once.
+// Without a limit it returns at once, and a reload lifting the limit
+// lets the steps still to go through.
+func (r rateLimitedRepository) wait(ctx context.Context, n int) error {
+	start := time.Now()
+	defer func() { writeThrottleSeconds.Add(time.Since(start).Seconds()) }()
+	for n > 0 && r.limiter.Limit() != rate.Inf {
+		step := min(n, r.limiter.Burst())
+		if err := r.limiter.WaitN(ctx, step); err != nil {
+			return fmt.Errorf("waiting for the write rate limit: %w", err)
+		}
+		n -= step
+	}
+	return nil
+}
+
+func (r rateLimitedRepository) Create(ctx context.Context, u *users.User) error {
+	if err := r.wait(ctx, 1); err != nil {
+		return err
+	}
+	return r.Repository.Create(ctx, u)
+}
+
+func (r rateLimitedRepository) CreateMany(ctx context.Context, us []users.User) ([]error, error) {
+	if err := r.wait(ctx, len(us)); err != nil {
+		results := make([]error, len(us))
+		for i := range results {
+			results[i] = err
+		}
+		return results, err
+	}
+	return r.Repository.CreateMany(ctx, us)
+}
+
+func (r rateLimitedRepository) BulkCreate(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	if err := r.wait(ctx, len(us)); err != nil {
+		return 0, 0, err
+	}
+	return r.Repository.BulkCreate(ctx, us)
+}
+
+func (r rateLimitedRepository) UpsertMany(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	if err := r.wait(ctx, len(us)); err != nil {
+		return 0, 0, err
+	}
+	return r.Repository.UpsertMany(ctx, us)
+}
+
+func (r rateLimitedRepository) Update(ctx context.Context, u *users.User) error {
+	if err := r.wait(ctx, 1); err != nil {
+		return err
+	}
+	return r.Repository.Update(ctx, u)
+}
+
+func (r rateLimitedRepository) Delete(ctx context.Context, username string) error {
+	if err := r.wait(ctx, 1); err != nil {
+		return err
+	}
+	return r.Repository.Delete(ctx, username)
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"context"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// rejectWrites wraps repo so every write fails with users.ErrReadOnly, and
+// returns repo unchanged unless READ_ONLY is set.
+func (a *app) rejectWrites(repo users.Repository) users.Repository {
+	if !a.cfg().Database.ReadOnly {
+		return repo
+	}
+	return readOnlyRepository{repo}
+}
+
+// readOnlyRepository refuses writes before they reach the database, which
+// with READ_ONLY would refuse them too but with a message about the
+// transaction rather than the setting. Reads pass straight through.
+type readOnlyRepository struct {
+	users.Repository
+}
+
+func (readOnlyRepository) Create(context.Context, *users.User) error {
+	return users.ErrReadOnly
+}
+
+func (readOnlyRepository) CreateMany(_ context.Context, us []users.User) ([]error, error) {
+	results := make([]error, len(us))
+	for i := range results {
+		results[i] = users.ErrReadOnly
+	}
+	return results, users.ErrReadOnly
+}
+
+func (readOnlyRepository) BulkCreate(context.Context, []users.User) (inserted, updated int64, err error) {
+	return 0, 0, users.ErrReadOnly
+}
+
+func (readOnlyRepository) UpsertMany(context.Context, []users.User) (inserted, updated int64, err error) {
+	return 0, 0, users.ErrReadOnly
+}
+
+func (readOnlyRepository) Update(context.Context, *users.User) error {
+	return users.ErrReadOnly
+}
+
+func (readOnlyRepository) Delete(context.Context, string) error {
+	return users.ErrReadOnly
+}
+
+func (readOnlyRepository) Purge(context.Context, time.Duration) (int64, error) {
+	return 0, users.ErrReadOnly
+}
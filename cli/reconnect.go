@@ -0,0 +1,197 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var reconnectRetries = promauto.With(metricsRegistry).NewCounter(prometheus.CounterOpts{
+	Name: "db_reconnect_retries_total",
+	Help: "Operations run again on a fresh connection after the database dropped theirs.",
+})
+
+// resettablePool is a pool whose connections can all be discarded at once:
+// *pgxpool.Pool, and *livePool for serve.
+type resettablePool interface {
+	users.Querier
+	Reset()
+}
+
+// connectionLost reports whether err means the server dropped the
+// connection under the call, as a restart or failover does: it announced
+// an admin or crash shutdown (57P01, 57P02), reported a connection
+// exception (class 08), or the socket closed. A timeout is not a lost
+// connection; the server may just be slow.
+func connectionLost(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57P01" || pgErr.Code == "57P02" || strings.HasPrefix(pgErr.Code, "08")
+	}
+	if pgconn.SafeToRetry(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && !netErr.Timeout()
+}
+
+// unapplied reports whether a write that failed with err certainly did not
+// take effect: pgx had not sent it, or the server said it was shutting the
+// session down, which rolls back the transaction in progress. When the
+// socket broke with the statement in flight, it may have committed, so
+// such a write is not retried.
+func unapplied(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57P01" || pgErr.Code == "57P02"
+	}
+	return pgconn.SafeToRetry(err)
+}
+
+// anyLost is the retry condition of reads, which are always safe to repeat.
+func anyLost(error) bool { return true }
+
+// reconnectDelay is how long a retry waits after a lost connection, so a
+// server coming back from a restart has a moment to accept connections.
+const reconnectDelay = 250 * time.Millisecond
+
+// retryReconnected runs fn, and if it failed because the connection was
+// lost (see connectionLost) and safe accepts the error, discards every
+// connection of the pool with reset and runs fn once more. The other
+// connections of a restarted server are broken too, and would otherwise
+// fail the next calls one by one.
+func retryReconnected(ctx context.Context, reset func(), safe func(error) bool, fn func() error) error {
+	err := fn()
+	if !connectionLost(err) || !safe(err) || ctx.Err() != nil {
+		return err
+	}
+	slog.WarnContext(ctx, "database connection lost; retrying on a fresh connection", "err", err)
+	reconnectRetries.Inc()
+	reset()
+	select {
+	case <-ctx.Done():
+		return err
+	case <-time.After(reconnectDelay):
+	}
+	return fn()
+}
+
+// reconnectRepository runs each repository call again, once, when the
+// database dropped the connection it ran on. Reads are repeated whenever
+// the connection was lost; writes only when the failure shows they were
+// not applied (see unapplied). ForEachUser is never repeated, since fn has
+// already seen some of the users.
+type reconnectRepository struct {
+	users.Repository
+	reset func()
+}
+
+// retryOnReconnect wraps repo in a reconnectRepository that discards the
+// connections of pool before retrying.
+func retryOnReconnect(repo users.Repository, pool resettablePool) users.Repository {
+	return reconnectRepository{Repository: repo, reset: pool.Reset}
+}
+
+func (r reconnectRepository) read(ctx context.Context, fn func() error) error {
+	return retryReconnected(ctx, r.reset, anyLost, fn)
+}
+
+func (r reconnectRepository) write(ctx context.Context, fn func() error) error {
+	return retryReconnected(ctx, r.reset, unapplied, fn)
+}
+
+func (r reconnectRepository) Create(ctx context.Context, u *users.User) error {
+	return r.write(ctx, func() error { return r.Repository.Create(ctx, u) })
+}
+
+func (r reconnectRepository) CreateMany(ctx context.Context, us []users.User) (results []error, err error) {
+	err = r.write(ctx, func() (err error) {
+		results, err = r.Repository.CreateMany(ctx, us)
+		return err
+	})
+	return results, err
+}
+
+func (r reconnectRepository) BulkCreate(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	err = r.write(ctx, func() (err error) {
+		inserted, updated, err = r.Repository.BulkCreate(ctx, us)
+		return err
+	})
+	return inserted, updated, err
+}
+
+func (r reconnectRepository) UpsertMany(ctx context.Context, us []users.User) (inserted, updated int64, err error) {
+	err = r.write(ctx, func() (err error) {
+		inserted, updated, err = r.Repository.UpsertMany(ctx, us)
+		return err
+	})
+	return inserted, updated, err
+}
+
+func (r reconnectRepository) GetByID(ctx context.Context, id users.ID) (u *users.User, err error) {
+	err = r.read(ctx, func() (err error) {
+		u, err = r.Repository.GetByID(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+func (r reconnectRepository) GetByIDs(ctx context.Context, ids []users.ID) (us []users.User, err error) {
+	err = r.read(ctx, func() (err error) {
+		us, err = r.Repository.GetByIDs(ctx, ids)
+		return err
+	})
+	return us, err
+}
+
+func (r reconnectRepository) GetByUsername(ctx context.Context, username string) (u *users.User, err error) {
+	err = r.read(ctx, func() (err error) {
+		u, err = r.Repository.GetByUsername(ctx, username)
+		return err
+	})
+	return u, err
+}
+
+func (r reconnectRepository) List(ctx context.Context, page users.Page) (records []users.User, next string, err error) {
+	err = r.read(ctx, func() (err error) {
+		records, next, err = r.Repository.List(ctx, page)
+		return err
+	})
+	return records, next, err
+}
+
+func (r reconnectRepository) Count(ctx context.Context, f users.Filter) (n int64, err error) {
+	err = r.read(ctx, func() (err error) {
+		n, err = r.Repository.Count(ctx, f)
+		return err
+	})
+	return n, err
+}
+
+func (r reconnectRepository) Update(ctx context.Context, u *users.User) error {
+	return r.write(ctx, func() error { return r.Repository.Update(ctx, u) })
+}
+
+func (r reconnectRepository) Delete(ctx context.Context, username string) error {
+	return r.write(ctx, func() error { return r.Repository.Delete(ctx, username) })
+}
+
+func (r reconnectRepository) Purge(ctx context.Context, olderThan time.Duration) (n int64, err error) {
+	err = r.write(ctx, func() (err error) {
+		n, err = r.Repository.Purge(ctx, olderThan)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,125 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestConnectionLost(t *testing.T) {
+	timeout := &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+	tests := []struct {
+		name            string
+		err             error
+		lost, unapplied bool
+	}{
+		{"nil", nil, false, false},
+		{"not found", users.ErrNotFound, false, false},
+		{"unique violation", &pgconn.PgError{Code: "23505"}, false, false},
+		{"admin shutdown", &pgconn.PgError{Code: "57P01"}, true, true},
+		{"crash shutdown", fmt.Errorf("deleting: %w", &pgconn.PgError{Code: "57P02"}), true, true},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, true, false},
+		{"eof", io.EOF, true, false},
+		{"unexpected eof", fmt.Errorf("reading: %w", io.ErrUnexpectedEOF), true, false},
+		{"closed", net.ErrClosed, true, false},
+		{"reset", &net.OpError{Op: "write", Err: errors.New("connection reset by peer")}, true, false},
+		{"timeout", timeout, false, false},
+		{"deadline", context.DeadlineExceeded, false, false},
+		{"canceled", context.Canceled, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := connectionLost(tt.err); got != tt.lost {
+				t.Errorf("connectionLost(%v) = %v, want %v", tt.err, got, tt.lost)
+			}
+			if got := tt.lost && unapplied(tt.err); got != tt.unapplied {
+				t.Errorf("unapplied(%v) = %v, want %v", tt.err, got, tt.unapplied)
+			}
+		})
+	}
+}
+
+// flakyRepository fails its GetByID and Delete calls with the errors of
+// fails, in turn, then succeeds.
+type flakyRepository struct {
+	users.Repository
+	fails []error
+	calls int
+}
+
+func (r *flakyRepository) call() error {
+	r.calls++
+	if len(r.fails) == 0 {
+		return nil
+	}
+	err := r.fails[0]
+	r.fails = r.fails[1:]
+	return err
+}
+
+func (r *flakyRepository) GetByID(ctx context.Context, id users.ID) (*users.User, error) {
+	if err := r.call(); err != nil {
+		return nil, err
+	}
+	return &users.User{ID: id}, nil
+}
+
+func (r *flakyRepository) Delete(ctx context.Context, username string) error {
+	return r.call()
+}
+
+func TestReconnectRepository(t *testing.T) {
+	ctx := context.Background()
+	shutdown := &pgconn.PgError{Code: "57P01"}
+	tests := []struct {
+		name    string
+		fails   []error
+		op      func(users.Repository) error
+		wantErr error
+		calls   int
+		resets  int
+	}{
+		{"read after eof", []error{io.EOF}, getUser, nil, 2, 1},
+		{"read after two losses", []error{io.EOF, io.EOF}, getUser, io.EOF, 2, 1},
+		{"read not found", []error{users.ErrNotFound}, getUser, users.ErrNotFound, 1, 0},
+		{"delete after shutdown", []error{shutdown}, deleteUser, nil, 2, 1},
+		{"delete after eof", []error{io.EOF}, deleteUser, io.EOF, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flaky := &flakyRepository{fails: tt.fails}
+			resets := 0
+			repo := reconnectRepository{Repository: flaky, reset: func() { resets++ }}
+			if err := tt.op(repo); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if flaky.calls != tt.calls || resets != tt.resets {
+				t.Errorf("%d calls and %d resets, want %d and %d", flaky.calls, resets, tt.calls, tt.resets)
+			}
+		})
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	flaky := &flakyRepository{fails: []error{io.EOF}}
+	repo := reconnectRepository{Repository: flaky, reset: func() {}}
+	if _, err := repo.GetByID(canceled, "1"); !errors.Is(err, io.EOF) || flaky.calls != 1 {
+		t.Errorf("read with a canceled context: %v after %d calls, want no retry", err, flaky.calls)
+	}
+}
+
+func getUser(repo users.Repository) error {
+	_, err := repo.GetByID(context.Background(), "1")
+	return err
+}
+
+func deleteUser(repo users.Repository) error {
+	return repo.Delete(context.Background(), "alice")
+}
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// livePool forwards to the current pool of serve, so a reload can replace
+// the pool without rebuilding the handlers that use it.
+type livePool struct {
+	current atomic.Pointer[pgxpool.Pool]
+}
+
+var _ users.Querier = (*livePool)(nil)
+
+func newLivePool(pool *pgxpool.Pool) *livePool {
+	p := &livePool{}
+	p.current.Store(pool)
+	return p
+}
+
+func (p *livePool) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return p.current.Load().Exec(ctx, sql, args...)
+}
+
+func (p *livePool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return p.current.Load().Query(ctx, sql, args...)
+}
+
+func (p *livePool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return p.current.Load().QueryRow(ctx, sql, args...)
+}
+
+func (p *livePool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	return p.current.Load().SendBatch(ctx, b)
+}
+
+func (p *livePool) CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error) {
+	return p.current.Load().CopyFrom(ctx, table, columns, src)
+}
+
+func (p *livePool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.current.Load().Begin(ctx)
+}
+
+func (p *livePool) Acquire(ctx context.Context) (*pgxpool.Conn, error) {
+	return p.current.Load().Acquire(ctx)
+}
+
+func (p *livePool) Stat() *pgxpool.Stat {
+	return p.current.Load().Stat()
+}
+
+// Reset closes the connections of the current pool, as pgxpool.Pool.Reset.
+func (p *livePool) Reset() {
+	p.current.Load().Reset()
+}
+
+// Close closes the current pool.
+func (p *livePool) Close() {
+	p.current.Load().Close()
+}
+
+// replace makes pool current. The previous pool is closed in the
+// background once the requests still using its connections are done.
+func (p *livePool) replace(pool *pgxpool.Pool) {
+	old := p.current.Swap(pool)
+	go old.Close()
+}
+
+// reloadSignals returns the channels serve and daemon reload their
+// configuration on: changed receives when the configuration file is
+// written, if WATCH_CONFIG is set, and hangup on SIGHUP. stop stops the
+// SIGHUP notifications.
+func (a *app) reloadSignals() (changed <-chan struct{}, hangup <-chan os.Signal, stop func()) {
+	written := make(chan struct{}, 1)
+	if a.cfg().App.WatchConfig {
+		config.Watch(func() {
+			select {
+			case written <- struct{}{}:
+			default: // a reload is already pending
+			}
+		})
+	}
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	return written, sighup, func() { signal.Stop(sighup) }
+}
+
+// reloadConfig re-reads the configuration for serve and daemon and applies
+// what changed. LOG_LEVEL, QUERY_TIMEOUT, SLOW_QUERY_MS,
+// MAX_WRITES_PER_SEC and WRITE_BURST take effect immediately, the ones the
+// tracer reads from the next statement of every connection. Pool settings
+// (DB_MAX_CONNS and the other DB_*_CONN* settings) are applied by opening a
+// new pool and swapping it in. Connection settings (CONN_STR, TLS,
+// secrets and the other session settings) need new connections too, but
+// are only applied when reconnect is set (on SIGHUP); otherwise a warning
+// says how to apply them. Any other setting needs a restart, which a
+// warning lists (see restartSettings). Invalid
+// configuration is rejected as a whole and the running settings stay in
+// place.
+func (a *app) reloadConfig(ctx context.Context, pool *livePool, reconnect bool) {
+	fresh, err := config.Load(ctx)
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		slog.Error("configuration change rejected", "err", err)
+		return
+	}
+
+	current := a.cfg()
+	if fresh.App.LogLevel != current.App.LogLevel {
+		logLevel.Set(parseLogLevel(fresh.App.LogLevel))
+		slog.Info("log level changed", "from", current.App.LogLevel, "to", fresh.App.LogLevel)
+		a.setCfg(func(cfg *config.Config) { cfg.App.LogLevel = fresh.App.LogLevel })
+	}
+	if fresh.Database.QueryTimeout != current.Database.QueryTimeout {
+		slog.Info("query timeout changed", "from", current.Database.QueryTimeout, "to", fresh.Database.QueryTimeout)
+		a.setCfg(func(cfg *config.Config) { cfg.Database.QueryTimeout = fresh.Database.QueryTimeout })
+	}
+	if fresh.App.SlowQuery != current.App.SlowQuery {
+		slog.Info("slow query threshold changed", "from", current.App.SlowQuery, "to", fresh.App.SlowQuery)
+		a.setCfg(func(cfg *config.Config) { cfg.App.SlowQuery = fresh.App.SlowQuery })
+	}
+	if fresh.App.MaxWritesPerSec != current.App.MaxWritesPerSec || fresh.App.WriteBurst != current.App.WriteBurst {
+		a.setWriteLimit(fresh.App)
+		slog.Info("write rate limit changed", "max_writes_per_sec", fresh.App.MaxWritesPerSec, "write_burst", fresh.App.WriteBurst)
+		a.setCfg(func(cfg *config.Config) {
+			cfg.App.MaxWritesPerSec, cfg.App.WriteBurst = fresh.App.MaxWritesPerSec, fresh.App.WriteBurst
+		})
+	}
+	if changed := restartSettings(current, fresh); len(changed) > 0 {
+		slog.Warn("settings changed that only a restart applies", "settings", changed)
+	}
+
+	current = a.cfg()
+	connChanged, poolChanged := fresh.Database != current.Database, fresh.Pool != current.Pool
+	if !connChanged && !poolChanged {
+		if reconnect {
+			slog.Info("connection settings unchanged; keeping the pool")
+		}
+		return
+	}
+	if connChanged && !reconnect {
+		slog.Warn("connection settings changed; send SIGHUP to reconnect with them")
+		return
+	}
+
+	previous := current
+	a.setCfg(func(cfg *config.Config) { cfg.Database, cfg.Pool = fresh.Database, fresh.Pool })
+	newPool, err := a.openPool(ctx)
+	if err == nil {
+		if err = a.migrateUp(ctx, newPool); err != nil {
+			newPool.Close()
+		}
+	}
+	if err != nil {
+		a.settings.Store(previous)
+		a.creds.use(previous.Database.Secrets)
+		slog.Error("reconnecting with the new settings failed; keeping the current pool", "err", err)
+		return
+	}
+	pool.replace(newPool)
+	if connChanged {
+		slog.Info("reconnected with the new connection settings")
+	} else {
+		slog.Info("pool replaced with the new pool settings", "max_conns", fresh.Pool.MaxConns, "min_conns", fresh.Pool.MinConns)
+	}
+}
+
+// liveAppSettings are the fields of config.App that reloadConfig applies.
+var liveAppSettings = map[string]bool{"LogLevel": true, "SlowQuery": true, "MaxWritesPerSec": true, "WriteBurst": true}
+
+// restartSettings returns the names of the settings that differ between
+// current and fresh and that reloadConfig does not apply: the fields of
+// App other than liveAppSettings, and the tables and jobs of the
+// configuration file. Database and Pool are reloadConfig's to compare.
+func restartSettings(current, fresh *config.Config) []string {
+	var changed []string
+	was, is := reflect.ValueOf(current.App), reflect.ValueOf(fresh.App)
+	for i := range was.NumField() {
+		name := was.Type().Field(i).Name
+		if liveAppSettings[name] {
+			continue
+		}
+		a, b := was.Field(i).Interface(), is.Field(i).Interface()
+		// A zone loaded twice is two values; its name tells them apart
+		if zone, ok := a.(*time.Location); ok {
+			a, b = zone.String(), b.(*time.Location).String()
+		}
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, "App."+name)
+		}
+	}
+	if !reflect.DeepEqual(current.Tables, fresh.Tables) {
+		changed = append(changed, "Tables")
+	}
+	if !reflect.DeepEqual(current.Jobs, fresh.Jobs) {
+		changed = append(changed, "Jobs")
+	}
+	return changed
+}
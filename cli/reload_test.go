@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/time/rate"
+)
+
+// loadEnvConfig loads the configuration from the environment, with CONN_STR
+// pointed at a server that is never dialled.
+func loadEnvConfig(t *testing.T) *config.Config {
+	t.Helper()
+	t.Setenv("CONN_STR", "postgres://app@127.0.0.1:1/app")
+	cfg, err := config.Load(t.Context())
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestReloadConfigAppliesLive(t *testing.T) {
+	defer logLevel.Set(logLevel.Level())
+	a := newApp(loadEnvConfig(t))
+	opened := 0
+	a.openPool = func(context.Context) (*pgxpool.Pool, error) {
+		opened++
+		return nil, errors.New("server unreachable")
+	}
+
+	// Requests and jobs read the settings while they are replaced
+	ctx, cancel := context.WithCancel(t.Context())
+	var readers sync.WaitGroup
+	for range 4 {
+		readers.Go(func() {
+			for ctx.Err() == nil {
+				_ = a.cfg().Database.QueryTimeout + a.cfg().Pool.MaxConnLifetime
+			}
+		})
+	}
+	defer readers.Wait()
+	defer cancel()
+
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("QUERY_TIMEOUT", "3s")
+	a.reloadConfig(t.Context(), nil, false)
+	if got := logLevel.Level(); got != slog.LevelDebug {
+		t.Errorf("log level after reload = %v, want debug", got)
+	}
+	if got := a.cfg().Database.QueryTimeout; got != 3*time.Second {
+		t.Errorf("QUERY_TIMEOUT after reload = %v, want 3s", got)
+	}
+	if opened != 0 {
+		t.Errorf("reload of LOG_LEVEL and QUERY_TIMEOUT opened %d pools, want none", opened)
+	}
+
+	// The tracer of the connections already open picks the timeout up
+	traced := liveQueryTimeout{a}.TraceQueryStart(t.Context(), nil, pgx.TraceQueryStartData{})
+	if deadline, ok := traced.Deadline(); !ok || time.Until(deadline) > 3*time.Second {
+		t.Errorf("statement deadline = %v, %v, want one within 3s", deadline, ok)
+	}
+
+	// A pool setting opens a new pool without waiting for SIGHUP; when
+	// that fails, the running settings stay
+	before := a.cfg().Pool
+	t.Setenv("DB_MAX_CONNS", "7")
+	a.reloadConfig(t.Context(), nil, false)
+	if opened != 1 {
+		t.Errorf("reload of DB_MAX_CONNS opened %d pools, want 1", opened)
+	}
+	if got := a.cfg().Pool; got != before {
+		t.Errorf("pool settings after a failed reconnect = %+v, want %+v", got, before)
+	}
+
+	// A connection setting waits for SIGHUP
+	t.Setenv("CONN_STR", "postgres://other@127.0.0.1:1/app")
+	a.reloadConfig(t.Context(), nil, false)
+	if opened != 1 {
+		t.Errorf("reload of CONN_STR without SIGHUP opened a pool")
+	}
+	a.reloadConfig(t.Context(), nil, true)
+	if opened != 2 {
+		t.Errorf("reload of CONN_STR on SIGHUP opened %d pools in all, want 2", opened)
+	}
+}
+
+func TestReloadOnWatchedFile(t *testing.T) {
+	if _, ok := os.LookupEnv("LOG_LEVEL"); ok {
+		t.Skip("LOG_LEVEL is set in the environment, which takes precedence over .env")
+	}
+	defer logLevel.Set(logLevel.Level())
+	t.Chdir(t.TempDir())
+	writeEnv := func(level string) {
+		t.Helper()
+		env := "CONN_STR=postgres://app@127.0.0.1:1/app\nWATCH_CONFIG=true\nLOG_LEVEL=" + level + "\n"
+		if err := os.WriteFile(".env", []byte(env), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeEnv("info")
+	cfg, err := config.Load(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := newApp(cfg)
+	logLevel.Set(slog.LevelInfo)
+	changed, _, stop := a.reloadSignals()
+	defer stop()
+
+	writeEnv("warn")
+	timeout := time.After(5 * time.Second)
+	for logLevel.Level() != slog.LevelWarn {
+		select {
+		case <-changed:
+			a.reloadConfig(t.Context(), nil, false)
+		case <-timeout:
+			t.Fatalf("log level %v 5s after LOG_LEVEL=warn was written to .env", logLevel.Level())
+		}
+	}
+}
+
+func TestReloadWriteLimitAndRestartSettings(t *testing.T) {
+	a := newApp(loadEnvConfig(t))
+	limiter := a.writeLimiter()
+	if limiter.Limit() != rate.Inf {
+		t.Fatalf("write limit without MAX_WRITES_PER_SEC = %v, want none", limiter.Limit())
+	}
+
+	t.Setenv("MAX_WRITES_PER_SEC", "50")
+	t.Setenv("SLOW_QUERY_MS", "200")
+	t.Setenv("SERVE_ADDR", ":9999")
+	a.reloadConfig(t.Context(), nil, false)
+	// The limiter the repositories already hold is the one that changed
+	if limiter.Limit() != 50 || limiter.Burst() != 50 {
+		t.Errorf("write limit after reload = %v, burst %d, want 50 and 50", limiter.Limit(), limiter.Burst())
+	}
+	if got := a.cfg().App.SlowQuery; got != 200*time.Millisecond {
+		t.Errorf("SLOW_QUERY_MS after reload = %v, want 200ms", got)
+	}
+	if got := a.cfg().App.ServeAddr; got == ":9999" {
+		t.Errorf("SERVE_ADDR applied by a reload, want it left for a restart")
+	}
+
+	fresh := *a.cfg()
+	fresh.App.ServeAddr, fresh.App.LogLevel, fresh.Jobs = ":9999", "error", []config.Job{{Name: "stats"}}
+	got := restartSettings(a.cfg(), &fresh)
+	if want := []string{"App.ServeAddr", "Jobs"}; !slices.Equal(got, want) {
+		t.Errorf("restartSettings = %q, want %q", got, want)
+	}
+}
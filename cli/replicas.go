@@ -0,0 +1,230 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// replicaCooldown is how long a replica that failed a read is skipped
+// before reads are sent to it again.
+const replicaCooldown = 30 * time.Second
+
+var routedReads = promauto.With(metricsRegistry).NewCounterVec(prometheus.CounterOpts{
+	Name: "users_reads_routed_total",
+	Help: "User reads by where they were served: replica, or primary when no replica was available.",
+}, []string{"target"})
+
+// replica is one read replica: its pool, a repository on it, and until when
+// it is considered down.
+type replica struct {
+	name string // user@host:port/database, for logs
+	pool *pgxpool.Pool
+	repo users.Repository
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// available reports whether reads may be sent to the replica.
+func (r *replica) available() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().After(r.downUntil)
+}
+
+// markDown takes the replica out of rotation for replicaCooldown.
+func (r *replica) markDown(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downUntil = time.Now().Add(replicaCooldown)
+	slog.Warn("read replica unavailable; reading from the primary", "replica", r.name, "retry_in", replicaCooldown, "err", err)
+}
+
+// replicaSet holds the pools of the READ_CONN_STR replicas. Reads are
+// spread over them round-robin.
+type replicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+// openReplicas creates a pool for each READ_CONN_STR replica, or returns
+// nil when none is configured. The pools connect lazily, so a replica that
+// is down at startup only costs a fallback on the first read sent to it.
+// They share the TLS, statement cache and pool settings of the primary.
+func (a *app) openReplicas(ctx context.Context) (*replicaSet, error) {
+	connStrs := a.cfg().Database.Replicas()
+	if len(connStrs) == 0 {
+		return nil, nil
+	}
+	set := &replicaSet{}
+	for i, connStr := range connStrs {
+		cfg, err := pgxpool.ParseConfig(connStr)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("READ_CONN_STR replica %d: invalid connection string: %w", i+1, err)
+		}
+		a.applyStatementCache(cfg.ConnConfig)
+		cfg.ConnConfig.Tracer = a.newDBTracer()
+		a.applySessionSettings(&cfg.ConnConfig.Config)
+		if err := a.applyTLS(&cfg.ConnConfig.Config); err != nil {
+			set.Close()
+			return nil, err
+		}
+		if err := a.applyPoolSettings(cfg); err != nil {
+			set.Close()
+			return nil, err
+		}
+		pool, err := pgxpool.NewWithConfig(ctx, cfg)
+		if err != nil {
+			set.Close()
+			return nil, fmt.Errorf("READ_CONN_STR replica %d: creating connection pool: %w", i+1, err)
+		}
+		repo, err := a.poolUserRepository(pool)
+		if err != nil {
+			pool.Close()
+			set.Close()
+			return nil, err
+		}
+		c := cfg.ConnConfig
+		set.replicas = append(set.replicas, &replica{
+			name: fmt.Sprintf("%s@%s/%s", c.User, serverAddress(&c.Config), c.Database),
+			pool: pool,
+			// Neither the breaker nor the write limit: a replica outage must
+			// not open the primary's breaker, and replicas are not written to
+			repo: metricsRepository{repo},
+		})
+	}
+	slog.Info("routing reads to replicas", "replicas", len(set.replicas))
+	return set, nil
+}
+
+// Close closes every replica pool. It is safe on a nil set.
+func (s *replicaSet) Close() {
+	if s == nil {
+		return
+	}
+	for _, r := range s.replicas {
+		r.pool.Close()
+	}
+}
+
+// all returns the replicas, none for a nil set.
+func (s *replicaSet) all() []*replica {
+	if s == nil {
+		return nil
+	}
+	return s.replicas
+}
+
+// pick returns the next available replica in round-robin order, or nil
+// when all of them are down.
+func (s *replicaSet) pick() *replica {
+	start := s.next.Add(1)
+	for i := range uint64(len(s.replicas)) {
+		if r := s.replicas[(start+i)%uint64(len(s.replicas))]; r.available() {
+			return r
+		}
+	}
+	return nil
+}
+
+// routeReads sends the read methods of repo to replicas and leaves writes on
+// repo, the primary. It returns repo unchanged when there are no replicas.
+func routeReads(repo users.Repository, replicas *replicaSet) users.Repository {
+	if replicas == nil {
+		return repo
+	}
+	return routedRepository{Repository: repo, replicas: replicas}
+}
+
+// routedRepository serves GetByID, GetByUsername, List, ForEachUser and
+// Count from a replica. A read that fails because the replica is
+// unreachable (see isOutage) takes the replica out of rotation and is
+// retried on the primary, as are reads made while every replica is down. Replicas lag
+// behind the primary, so a read may not see a write made just before it.
+type routedRepository struct {
+	users.Repository
+	replicas *replicaSet
+}
+
+// read runs fn on a replica, falling back to the primary.
+func (r routedRepository) read(ctx context.Context, fn func(users.Repository) error) error {
+	if rep := r.replicas.pick(); rep != nil {
+		err := fn(rep.repo)
+		if !isOutage(err) || ctx.Err() != nil {
+			routedReads.WithLabelValues("replica").Inc()
+			return err
+		}
+		rep.markDown(err)
+	}
+	routedReads.WithLabelValues("primary").Inc()
+	return fn(r.Repository)
+}
+
+func (r routedRepository) GetByID(ctx context.Context, id users.ID) (u *users.User, err error) {
+	err = r.read(ctx, func(repo users.Repository) (err error) {
+		u, err = repo.GetByID(ctx, id)
+		return err
+	})
+	return u, err
+}
+
+func (r routedRepository) GetByIDs(ctx context.Context, ids []users.ID) (us []users.User, err error) {
+	err = r.read(ctx, func(repo users.Repository) (err error) {
+		us, err = repo.GetByIDs(ctx, ids)
+		return err
+	})
+	return us, err
+}
+
+func (r routedRepository) GetByUsername(ctx context.Context, username string) (u *users.User, err error) {
+	err = r.read(ctx, func(repo users.Repository) (err error) {
+		u, err = repo.GetByUsername(ctx, username)
+		return err
+	})
+	return u, err
+}
+
+func (r routedRepository) List(ctx context.Context, page users.Page) (records []users.User, next string, err error) {
+	err = r.read(ctx, func(repo users.Repository) (err error) {
+		records, next, err = repo.List(ctx, page)
+		return err
+	})
+	return records, next, err
+}
+
+// ForEachUser scans a replica. Restarting the scan on the primary would
+// call fn again for users it has already seen, so a replica that fails
+// mid-scan is still taken out of rotation but its error is returned; only a
+// failure before the first row falls back.
+func (r routedRepository) ForEachUser(ctx context.Context, fn func(users.User) error) error {
+	var seen bool
+	var err error
+	return r.read(ctx, func(repo users.Repository) error {
+		if seen {
+			return err
+		}
+		err = repo.ForEachUser(ctx, func(u users.User) error {
+			seen = true
+			return fn(u)
+		})
+		return err
+	})
+}
+
+func (r routedRepository) Count(ctx context.Context, f users.Filter) (n int64, err error) {
+	err = r.read(ctx, func(repo users.Repository) (err error) {
+		n, err = repo.Count(ctx, f)
+		return err
+	})
+	return n, err
+}
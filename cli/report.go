@@ -0,0 +1,207 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// reportSections lists the reports of the report command, in the order
+// they are printed.
+var reportSections = []string{"signups", "domains", "duplicates"}
+
+// reportOptions are the flags of the report command.
+type reportOptions struct {
+	Format string
+	Days   int
+	Top    int
+}
+
+// report holds the sections the report command was asked for; the others
+// are nil.
+type report struct {
+	Signups    []users.DailySignups
+	Domains    []users.EmailDomain
+	Duplicates []users.DuplicateEmail
+}
+
+// newReportCmd builds the report command.
+func newReportCmd(a *app) *cobra.Command {
+	var opts reportOptions
+	cmd := &cobra.Command{
+		Use:   "report [signups|domains|duplicates]...",
+		Short: "Print signups per day, users per email domain and duplicate emails",
+		Long: `Run aggregate queries on the users table as it is now and print the
+results: signups per UTC day (signups), users per email domain with their
+share of the total (domains), and the emails that several users share when
+case is ignored (duplicates). Name the sections to print; without any,
+all three are printed.
+
+--format prints a table for each section (the default), one JSON or YAML
+document, or CSV, which holds one section and so needs exactly one named.
+Signups count soft-deleted users too, as the user_stats view of stats
+does; the other sections leave them out. Needs PostgreSQL or CockroachDB.`,
+		Example: `  go run ./cmd/go-postgres report
+  go run ./cmd/go-postgres report domains --top 5
+  go run ./cmd/go-postgres report signups --days 7 --format csv > signups.csv`,
+		ValidArgs: reportSections,
+		Args:      cobra.OnlyValidArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Format == "" {
+				opts.Format = a.cfg().App.Output
+			}
+			if len(args) == 0 {
+				args = reportSections
+			}
+			if err := a.checkReportOptions(opts, args); err != nil {
+				return err
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				r, err := runReport(ctx, repo, opts, args)
+				if err != nil {
+					return err
+				}
+				return writeUsersReport(os.Stdout, r, opts.Format)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&opts.Format, "format", "", "table, json, yaml or csv (default OUTPUT)")
+	cmd.Flags().IntVar(&opts.Days, "days", 30, "signups of this many most recent UTC days, today included (0 for all)")
+	cmd.Flags().IntVar(&opts.Top, "top", 10, "print at most this many domains and duplicate emails (0 for all)")
+	return cmd
+}
+
+// checkReportOptions refuses what the report command cannot print before
+// it connects.
+func (a *app) checkReportOptions(opts reportOptions, sections []string) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("report needs PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	if !slices.Contains([]string{"table", "json", "yaml", "csv"}, opts.Format) {
+		return fmt.Errorf("unsupported --format %q (one of table, json, yaml, csv)", opts.Format)
+	}
+	if opts.Format == "csv" && len(sections) != 1 {
+		return fmt.Errorf("--format csv prints one section; name one of %s", strings.Join(reportSections, ", "))
+	}
+	if opts.Days < 0 || opts.Top < 0 {
+		return fmt.Errorf("--days and --top must not be negative")
+	}
+	return nil
+}
+
+// runReport runs the queries of the named sections.
+func runReport(ctx context.Context, store users.ReportStore, opts reportOptions, sections []string) (report, error) {
+	var r report
+	var err error
+	if slices.Contains(sections, "signups") {
+		var since time.Time
+		if opts.Days > 0 {
+			since = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-opts.Days)
+		}
+		if r.Signups, err = store.SignupsPerDay(ctx, since); err != nil {
+			return r, fmt.Errorf("counting signups: %w", err)
+		}
+		r.Signups = nonNil(r.Signups)
+	}
+	if slices.Contains(sections, "domains") {
+		if r.Domains, err = store.EmailDomains(ctx, opts.Top); err != nil {
+			return r, fmt.Errorf("counting email domains: %w", err)
+		}
+		r.Domains = nonNil(r.Domains)
+	}
+	if slices.Contains(sections, "duplicates") {
+		if r.Duplicates, err = store.DuplicateEmails(ctx, opts.Top); err != nil {
+			return r, fmt.Errorf("finding duplicate emails: %w", err)
+		}
+		r.Duplicates = nonNil(r.Duplicates)
+	}
+	return r, nil
+}
+
+// nonNil returns s, or an empty slice in its place, so a section that was
+// asked for and found nothing is still printed.
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+	return s
+}
+
+// writeUsersReport writes r to w in format.
+func writeUsersReport(w io.Writer, r report, format string) error {
+	switch format {
+	case "json", "yaml":
+		doc := map[string]any{}
+		if r.Signups != nil {
+			doc["signups_per_day"] = r.Signups
+		}
+		if r.Domains != nil {
+			doc["email_domains"] = r.Domains
+		}
+		if r.Duplicates != nil {
+			doc["duplicate_emails"] = r.Duplicates
+		}
+		return writeDocument(w, doc, format)
+	case "csv":
+		return writeReportCSV(w, r)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	sep := ""
+	if r.Signups != nil {
+		fmt.Fprintf(tw, "Signups per day\nDAY\tSIGNUPS\n")
+		for _, s := range r.Signups {
+			fmt.Fprintf(tw, "%s\t%d\n", s.Day.Format(time.DateOnly), s.Signups)
+		}
+		sep = "\n"
+	}
+	if r.Domains != nil {
+		fmt.Fprintf(tw, "%sUsers per email domain\nDOMAIN\tUSERS\tSHARE\n", sep)
+		for _, d := range r.Domains {
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\n", d.Domain, d.Users, d.Share)
+		}
+		sep = "\n"
+	}
+	if r.Duplicates != nil {
+		fmt.Fprintf(tw, "%sDuplicate emails, ignoring case\nEMAIL\tUSERS\tUSERNAMES\n", sep)
+		for _, d := range r.Duplicates {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", d.Email, d.Users, strings.Join(d.Usernames, ", "))
+		}
+	}
+	return tw.Flush()
+}
+
+// writeReportCSV writes the one section of r with a header line.
+// Usernames, which cannot contain spaces, are joined by one.
+func writeReportCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	switch {
+	case r.Signups != nil:
+		cw.Write([]string{"day", "signups"})
+		for _, s := range r.Signups {
+			cw.Write([]string{s.Day.Format(time.DateOnly), strconv.FormatInt(s.Signups, 10)})
+		}
+	case r.Domains != nil:
+		cw.Write([]string{"domain", "users", "share"})
+		for _, d := range r.Domains {
+			cw.Write([]string{d.Domain, strconv.FormatInt(d.Users, 10), strconv.FormatFloat(d.Share, 'f', 1, 64)})
+		}
+	case r.Duplicates != nil:
+		cw.Write([]string{"email", "users", "usernames"})
+		for _, d := range r.Duplicates {
+			cw.Write([]string{d.Email, strconv.FormatInt(d.Users, 10), strings.Join(d.Usernames, " ")})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
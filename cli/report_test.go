@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+)
+
+// fixedReports is a users.ReportStore answering with fixed rows, and
+// remembering the arguments it was given.
+type fixedReports struct {
+	since time.Time
+	limit int
+}
+
+func (f *fixedReports) SignupsPerDay(ctx context.Context, since time.Time) ([]users.DailySignups, error) {
+	f.since = since
+	return []users.DailySignups{{Day: time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), Signups: 3}}, nil
+}
+
+func (f *fixedReports) EmailDomains(ctx context.Context, limit int) ([]users.EmailDomain, error) {
+	f.limit = limit
+	return []users.EmailDomain{{Domain: "example.com", Users: 3, Share: 75}, {Domain: "example.org", Users: 1, Share: 25}}, nil
+}
+
+func (f *fixedReports) DuplicateEmails(ctx context.Context, limit int) ([]users.DuplicateEmail, error) {
+	return nil, nil
+}
+
+func TestRunReport(t *testing.T) {
+	store := &fixedReports{}
+	r, err := runReport(context.Background(), store, reportOptions{Days: 7, Top: 5}, []string{"signups", "duplicates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Signups == nil || r.Domains != nil || r.Duplicates == nil || len(r.Duplicates) != 0 {
+		t.Errorf("report = %+v, want signups and empty duplicates alone", r)
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if want := today.AddDate(0, 0, -6); !store.since.Equal(want) {
+		t.Errorf("since = %v, want %v: seven days, today included", store.since, want)
+	}
+
+	if _, err := runReport(context.Background(), store, reportOptions{Top: 5}, []string{"signups", "domains"}); err != nil {
+		t.Fatal(err)
+	}
+	if !store.since.IsZero() || store.limit != 5 {
+		t.Errorf("since %v, limit %d; want zero and 5", store.since, store.limit)
+	}
+}
+
+func TestWriteUsersReport(t *testing.T) {
+	r, err := runReport(context.Background(), &fixedReports{}, reportOptions{}, reportSections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		format string
+		r      report
+		want   []string
+	}{
+		{"table", r, []string{"Signups per day", "2024-05-02  3", "example.com  3      75.0%", "Duplicate emails, ignoring case\nEMAIL"}},
+		{"json", r, []string{`"signups_per_day":[{"day":"2024-05-02T00:00:00Z","signups":3}]`, `"duplicate_emails":[]`, `"share":75`}},
+		{"yaml", r, []string{"signups_per_day:\n", "- domain: example.com\n", "duplicate_emails: []\n"}},
+		{"csv", report{Domains: r.Domains}, []string{"domain,users,share\nexample.com,3,75.0\nexample.org,1,25.0\n"}},
+		{"csv", report{Signups: r.Signups}, []string{"day,signups\n2024-05-02,3\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var b strings.Builder
+			if err := writeUsersReport(&b, tt.r, tt.format); err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("output lacks %q:\n%s", want, b.String())
+				}
+			}
+		})
+	}
+
+	// Sections not asked for are left out of every format
+	var b strings.Builder
+	writeUsersReport(&b, report{Domains: r.Domains}, "json")
+	if strings.Contains(b.String(), "signups") {
+		t.Errorf("JSON of the domains alone = %s", b.String())
+	}
+}
+
+func TestCheckReportOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		driver   string
+		opts     reportOptions
+		sections []string
+		wantErr  string
+	}{
+		{"table", "", reportOptions{Format: "table"}, reportSections, ""},
+		{"csv of one", "", reportOptions{Format: "csv"}, []string{"domains"}, ""},
+		{"csv of all", "", reportOptions{Format: "csv"}, reportSections, "one section"},
+		{"unknown format", "", reportOptions{Format: "xml"}, reportSections, "unsupported --format"},
+		{"negative days", "", reportOptions{Format: "json", Days: -1}, reportSections, "must not be negative"},
+		{"mysql", config.DriverMySQL, reportOptions{Format: "table"}, reportSections, "needs PostgreSQL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newApp(&config.Config{Database: config.Database{Driver: tt.driver}})
+			err := a.checkReportOptions(tt.opts, tt.sections)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
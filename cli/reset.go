@@ -0,0 +1,153 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/events"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/hozana-dusabimana/server"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// protectedEnvs are the APP_ENV values db reset refuses to run under.
+var protectedEnvs = map[string]bool{"prod": true, "production": true}
+
+// newDBCmd builds the db command group.
+func newDBCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Development helpers for the program's tables",
+	}
+	var yes, recreate bool
+	reset := &cobra.Command{
+		Use:   "reset",
+		Short: "Empty the program's tables, or drop and recreate them (--recreate)",
+		Long: `Truncate users, posts, users_audit, users_outbox, events,
+idempotency_keys, archived_rows, user_embeddings, user_locations and the tables declared
+in the configuration file in DB_SCHEMA and restart their ids, so the
+quickstart can be run again from scratch. With --recreate every migration is rolled back and applied again
+instead, which also picks up edits to the migrations made during
+development; user_embeddings and user_locations are dropped, and vector
+setup and geo setup create them again.
+
+Every user is lost, so --yes is required, and the command refuses to run
+when APP_ENV is prod or production.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runDBReset(cmd.Context(), yes, recreate)
+		},
+	}
+	reset.Flags().BoolVar(&yes, "yes", false, "confirm that every user may be deleted")
+	reset.Flags().BoolVar(&recreate, "recreate", false, "roll back and re-apply every migration instead of truncating")
+	cmd.AddCommand(reset)
+	return cmd
+}
+
+// runDBReset implements db reset.
+func (a *app) runDBReset(ctx context.Context, yes, recreate bool) error {
+	if env := a.cfg().App.Env; protectedEnvs[strings.ToLower(env)] {
+		return fmt.Errorf("db reset refuses to run with APP_ENV=%s", env)
+	}
+	if !yes {
+		return fmt.Errorf("db reset deletes every user in schema %q; run it again with --yes", a.dbSchema())
+	}
+	if a.usesSQLDB() {
+		return fmt.Errorf("db reset needs PostgreSQL or CockroachDB; with DB_DRIVER=%s delete the database instead", a.cfg().Database.Driver)
+	}
+	if t := a.cfg().Database.RowTenant; t != "" {
+		// TRUNCATE and DROP pass over row-level security
+		return fmt.Errorf("db reset would delete the users of every tenant, not only those of RLS_TENANT=%s; unset it to reset", t)
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	return a.withSetupLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		if recreate {
+			return a.recreateTables(ctx, conn)
+		}
+		return a.truncateTables(ctx, conn)
+	})
+}
+
+// truncateTables empties the tables of backupTables, the events,
+// idempotency keys, archive, embeddings and locations tables and the tables
+// declared in the configuration file that exist, in one statement, and
+// restarts their id sequences. All but backupTables may reference users,
+// which could not be truncated without them.
+func (a *app) truncateTables(ctx context.Context, conn *pgxpool.Conn) error {
+	var tables []string
+	names := append(slices.Clone(backupTables), events.TableName, server.IdempotencyTableName, archiveTableName, embeddingsTableName, locationsTableName)
+	for _, name := range append(names, a.configuredTableNames()...) {
+		table := pgx.Identifier{a.dbSchema(), name}.Sanitize()
+		var exists bool
+		if err := conn.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			tables = append(tables, table)
+		}
+	}
+	if len(tables) == 0 {
+		return errors.New("there are no tables to reset; run migrate first")
+	}
+	stmt := "TRUNCATE " + strings.Join(tables, ", ")
+	// CockroachDB does not implement RESTART IDENTITY
+	if !a.isCockroach() {
+		stmt += " RESTART IDENTITY"
+	}
+	if _, err := conn.Exec(ctx, stmt); err != nil {
+		return fmt.Errorf("truncating tables: %w", err)
+	}
+	slog.Info("tables reset", "schema", a.dbSchema(), "tables", len(tables))
+	return nil
+}
+
+// recreateTables drops the tables declared in the configuration file and
+// the embeddings and locations tables, repairs the migrations (see
+// migrations.Repair), rolls back every applied one and applies them all
+// again, which creates the declared tables anew.
+func (a *app) recreateTables(ctx context.Context, conn *pgxpool.Conn) error {
+	ctx = db.WithoutQueryTimeout(ctx)
+	// Newest first, as later tables may reference earlier ones
+	names := append([]string{embeddingsTableName, locationsTableName}, a.configuredTableNames()...)
+	for _, name := range slices.Backward(names) {
+		if _, err := conn.Exec(ctx, "DROP TABLE IF EXISTS "+pgx.Identifier{a.dbSchema(), name}.Sanitize()); err != nil {
+			return fmt.Errorf("dropping table %s: %w", name, err)
+		}
+	}
+	// Edited and dirty migrations are about to be run anew anyway
+	repaired, err := migrations.Repair(ctx, conn, a.dbSchema())
+	if err != nil {
+		return fmt.Errorf("repairing migrations: %w", err)
+	}
+	if len(repaired.Removed)+len(repaired.Realigned) > 0 {
+		slog.Info("migrations repaired", "removed", len(repaired.Removed), "realigned", len(repaired.Realigned))
+	}
+	steps, err := migrations.PlanTo(ctx, conn, a.dbSchema(), 0)
+	if err != nil {
+		return err
+	}
+	ran, err := a.runMigrationSteps(ctx, conn, steps)
+	for _, s := range ran {
+		slog.Info("migrated", "step", s.String(), "version", s.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("rolling back migrations: %w", err)
+	}
+	if err := a.applyMigrations(ctx, conn); err != nil {
+		return fmt.Errorf("re-applying migrations: %w", err)
+	}
+	slog.Info("tables recreated", "schema", a.dbSchema(), "version", schemaVersion)
+	return nil
+}
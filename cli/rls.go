@@ -0,0 +1,165 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// rowTenantSetting is the session setting the row-level security policy of
+// migration 0020 compares users.tenant with; RLS_TENANT sets it.
+const rowTenantSetting = "app.tenant"
+
+// newRLSCmd builds the rls command group, which shows and switches how the
+// row-level security policy of migration 0020 applies.
+func newRLSCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rls",
+		Short: "Inspect and force the row-level security of the users table",
+		Long: `Migration 0020 gives each user an owner, users.tenant, and enables a
+row-level security policy: a session whose app.tenant setting (RLS_TENANT)
+names a tenant sees and changes only that tenant's users, and the users it
+inserts become that tenant's. Without RLS_TENANT every user is visible.
+
+PostgreSQL exempts the table's owner, superusers and roles with BYPASSRLS
+from the policy. The program usually connects as the owner, which created
+the table; rls force applies the policy to the owner as well, and
+rls unforce exempts it again. rls status reports how the policy applies
+to the current connection. Needs PostgreSQL.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.cfg().Database.Driver != config.DriverPostgres {
+				return fmt.Errorf("row-level security is set up by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Report whether the policy applies to this connection and which users it sees",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			pool, err := a.openPool(ctx)
+			if err != nil {
+				return err
+			}
+			defer pool.Close()
+			status, err := a.readRLSStatus(ctx, pool)
+			if err != nil {
+				return err
+			}
+			return a.printRLSStatus(status)
+		},
+	}, &cobra.Command{
+		Use:   "force",
+		Short: "Apply the policy to the table's owner as well",
+		Long: `Run ALTER TABLE users FORCE ROW LEVEL SECURITY, so that the policy also
+limits the table's owner, usually the role the program connects as.
+PostgreSQL refuses COPY FROM into a table whose policy applies, so backup
+restore and snapshot restore fail until rls unforce.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.setForceRLS(cmd.Context(), true)
+		},
+	}, &cobra.Command{
+		Use:   "unforce",
+		Short: "Exempt the table's owner from the policy again",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.setForceRLS(cmd.Context(), false)
+		},
+	})
+	return cmd
+}
+
+// rlsStatus describes how the policy applies to the current connection.
+type rlsStatus struct {
+	Enabled bool   `json:"enabled"` // ENABLE ROW LEVEL SECURITY (migration 0020)
+	Forced  bool   `json:"forced"`  // FORCE ROW LEVEL SECURITY (rls force)
+	Role    string `json:"role"`
+	// Exempt gives the reason the policy does not apply to Role, if any.
+	Exempt  string `json:"exempt,omitempty"`
+	Tenant  string `json:"tenant"`
+	Visible int64  `json:"visible_users"`
+}
+
+// readRLSStatus reads the table's settings and the connection's role and
+// counts the users the connection can see.
+func (a *app) readRLSStatus(ctx context.Context, pool *pgxpool.Pool) (*rlsStatus, error) {
+	var s rlsStatus
+	var owner, superuser, bypass bool
+	err := pool.QueryRow(ctx, `SELECT c.relrowsecurity, c.relforcerowsecurity, current_user,
+			pg_has_role(c.relowner, 'USAGE'), r.rolsuper, r.rolbypassrls,
+			coalesce(current_setting($2, true), '')
+		FROM pg_class c, pg_roles r
+		WHERE c.oid = $1::regclass AND r.rolname = current_user`, a.usersTable(), rowTenantSetting).
+		Scan(&s.Enabled, &s.Forced, &s.Role, &owner, &superuser, &bypass, &s.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !s.Enabled:
+		s.Exempt = "row-level security is not enabled; run migrate"
+	case superuser:
+		s.Exempt = "the role is a superuser"
+	case bypass:
+		s.Exempt = "the role has BYPASSRLS"
+	case owner && !s.Forced:
+		s.Exempt = "the role owns the table; run rls force"
+	}
+	if err := pool.QueryRow(ctx, "SELECT count(*) FROM "+a.usersTable()).Scan(&s.Visible); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// printRLSStatus writes s as lines, or as a document with --output json or
+// yaml.
+func (a *app) printRLSStatus(s *rlsStatus) error {
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, s)
+	}
+	applies := "yes"
+	if s.Exempt != "" {
+		applies = "no: " + s.Exempt
+	}
+	tenant := s.Tenant
+	if tenant == "" {
+		tenant = "none (every user is visible)"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "enabled:\t%t\n", s.Enabled)
+	fmt.Fprintf(tw, "forced:\t%t\n", s.Forced)
+	fmt.Fprintf(tw, "role:\t%s\n", s.Role)
+	fmt.Fprintf(tw, "policy applies:\t%s\n", applies)
+	fmt.Fprintf(tw, "tenant:\t%s\n", tenant)
+	fmt.Fprintf(tw, "visible users:\t%d\n", s.Visible)
+	return tw.Flush()
+}
+
+// setForceRLS implements rls force and rls unforce.
+func (a *app) setForceRLS(ctx context.Context, force bool) error {
+	stmt := "ALTER TABLE " + a.usersTable() + " NO FORCE ROW LEVEL SECURITY"
+	if force {
+		stmt = "ALTER TABLE " + a.usersTable() + " FORCE ROW LEVEL SECURITY"
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if _, err := pool.Exec(ctx, stmt); err != nil {
+		return err
+	}
+	slog.Info("row-level security updated", "table", "users", "forced", force)
+	return nil
+}
@@ -0,0 +1,224 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// dbSchema returns the schema that holds this program's tables (DB_SCHEMA,
+// "public" by default).
+func (a *app) dbSchema() string {
+	return a.cfg().Database.Schema
+}
+
+// usersTable returns the quoted, schema-qualified name of the users table.
+// The commands' own SQL names the table this way so it behaves the same
+// whatever the session's search_path happens to be.
+func (a *app) usersTable() string {
+	return pgx.Identifier{a.dbSchema(), "users"}.Sanitize()
+}
+
+// migrateUp applies the pending migrations in DB_SCHEMA, holding the
+// setup lock so that concurrent processes take turns; see withSetupLock.
+func (a *app) migrateUp(ctx context.Context, pool *pgxpool.Pool) error {
+	if a.cfg().Database.ReadOnly {
+		// Nothing will be written, so there is nothing to take turns at
+		return a.applyMigrations(ctx, pool)
+	}
+	return a.withSetupLock(ctx, pool, func(conn *pgxpool.Conn) error {
+		return a.applyMigrations(ctx, conn)
+	})
+}
+
+// applyMigrations applies the pending migrations (see the migrations
+// package) in DB_SCHEMA and logs each one it applies, then creates the
+// tables declared in the configuration file (see createConfiguredTables).
+// Given a transaction, both commit or roll back with it. With READ_ONLY
+// nothing is applied: a schema that is not up to date is an error.
+func (a *app) applyMigrations(ctx context.Context, q migrations.DB) error {
+	ctx = db.WithoutQueryTimeout(ctx)
+	steps, err := migrations.PlanUp(ctx, q, a.dbSchema())
+	if err != nil {
+		return err
+	}
+	if a.cfg().Database.ReadOnly {
+		if len(steps) > 0 {
+			return fmt.Errorf("%w: %d migrations are pending in schema %q; run go run ./cmd/go-postgres migrate without READ_ONLY against the primary", users.ErrReadOnly, len(steps), a.dbSchema())
+		}
+		return nil
+	}
+	if err := a.createUUIDUsersTable(ctx, q, steps); err != nil {
+		return err
+	}
+	applied, err := a.runMigrationSteps(ctx, q, steps)
+	for _, m := range applied {
+		slog.Info("applied migration", "name", m.Name, "version", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+	return a.createConfiguredTables(ctx, q)
+}
+
+// runMigrationSteps runs steps in DB_SCHEMA with migrations.Run. Through
+// PgBouncer (PGBOUNCER_MODE) a no-transaction migration cannot count on
+// one session for its settings and lock, so a plan that has one runs in a
+// single transaction instead, where such a migration builds its indexes
+// without CONCURRENTLY; either the whole plan is applied or none of it.
+func (a *app) runMigrationSteps(ctx context.Context, q migrations.DB, steps []migrations.Step) ([]migrations.Step, error) {
+	if !a.cfg().Database.PgBouncer || !slices.ContainsFunc(steps, func(s migrations.Step) bool { return s.NoTransaction }) {
+		return migrations.Run(ctx, q, a.dbSchema(), steps)
+	}
+	var ran []migrations.Step
+	err := pgx.BeginFunc(ctx, q, func(tx pgx.Tx) (err error) {
+		ran, err = migrations.Run(ctx, tx, a.dbSchema(), steps)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ran, nil
+}
+
+// usersUUIDTableSQL is the users table of migration 0001 with a UUID
+// primary key instead of SERIAL. %s is the schema-qualified table.
+const usersUUIDTableSQL = `CREATE TABLE IF NOT EXISTS %s (
+	id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+	username VARCHAR(50) UNIQUE NOT NULL,
+	email VARCHAR(100) UNIQUE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+// createUUIDUsersTable implements ID_TYPE=uuid. When steps are about to
+// apply migration 0001, it creates the users table with a UUID key first;
+// 0001 uses IF NOT EXISTS, so it then leaves that table alone and the later
+// migrations alter it as usual. The id type is thus fixed when the table is
+// created: ID_TYPE does not convert an existing table.
+func (a *app) createUUIDUsersTable(ctx context.Context, q migrations.DB, steps []migrations.Step) error {
+	createsUsers := slices.ContainsFunc(steps, func(s migrations.Step) bool { return s.Version == 1 && !s.Down })
+	if a.cfg().Database.IDType != config.IDTypeUUID || !createsUsers {
+		return nil
+	}
+	tx, err := q.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, fmt.Sprintf(usersUUIDTableSQL, a.usersTable())); err != nil {
+		return fmt.Errorf("creating users table with UUID ids: %w", err)
+	}
+	return tx.Commit(ctx)
+}
+
+// schemaVersion identifies the layout of the tables: the version of the
+// newest embedded migration. Snapshots record it so that one taken by one
+// version is not silently restored into another.
+var schemaVersion = int(migrations.Latest())
+
+// schemaAnnotation marks the commands that the schema version check of
+// runStartup treats specially; see migratesSchema and anySchema.
+const schemaAnnotation = "schema"
+
+const (
+	// schemaMigrates marks a command that applies the pending migrations
+	// itself, so a schema behind this build is not a problem for it.
+	schemaMigrates = "migrates"
+	// schemaAny marks a command that works whatever the version of the
+	// schema, such as migrate, which moves it, or exec, which runs the
+	// SQL it is given.
+	schemaAny = "any"
+)
+
+// migratesSchema marks cmd as applying the pending migrations before it
+// uses the tables, and returns it.
+func migratesSchema(cmd *cobra.Command) *cobra.Command {
+	return annotateSchema(cmd, schemaMigrates)
+}
+
+// anySchema marks cmd, and the subcommands it has, as working with a
+// schema of any version, and returns it.
+func anySchema(cmd *cobra.Command) *cobra.Command {
+	return annotateSchema(cmd, schemaAny)
+}
+
+func annotateSchema(cmd *cobra.Command, value string) *cobra.Command {
+	if cmd.Annotations == nil {
+		cmd.Annotations = map[string]string{}
+	}
+	cmd.Annotations[schemaAnnotation] = value
+	return cmd
+}
+
+// schemaNeeds returns the schema annotation of cmd, or of the nearest
+// command above it that has one. The root command's own, for the
+// quickstart, is not inherited.
+func schemaNeeds(cmd *cobra.Command) string {
+	for c := cmd; c != nil && (c == cmd || c.HasParent()); c = c.Parent() {
+		if v := c.Annotations[schemaAnnotation]; v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// checkSchemaVersion compares current, the version of schema, with
+// latest, that of this build, for a command whose schema annotation is
+// needs. A schema newer than the build fails every command that is not
+// marked anySchema: this build does not know what the newer migrations
+// changed. One that is behind fails with SCHEMA_BEHIND=fail, and is only
+// worth a warning otherwise, unless the command migrates it anyway.
+func checkSchemaVersion(schema string, current, latest int64, needs, behind string) (warning string, err error) {
+	switch {
+	case needs == schemaAny || current == latest:
+		return "", nil
+	case current > latest:
+		return "", fmt.Errorf("schema %q is at version %d, newer than this build's %d: upgrade this program, or roll the schema back to %d with the build that migrated it",
+			schema, current, latest, latest)
+	case needs == schemaMigrates:
+		return "", nil
+	}
+	msg := fmt.Sprintf("schema %q is at version %d, behind this build's %d: run migrations to version %d with go run ./cmd/go-postgres migrate", schema, current, latest, latest)
+	if behind == "fail" {
+		return "", errors.New(msg)
+	}
+	return msg, nil
+}
+
+// expectedUsersColumns maps each column of the users table to the data type
+// reported by information_schema.columns. It is used to detect drift between
+// the schema this program expects and the one actually deployed.
+var expectedUsersColumns = map[string]string{
+	"id":            "integer",
+	"username":      "character varying",
+	"email":         "character varying",
+	"created_at":    "timestamp with time zone",
+	"updated_at":    "timestamp with time zone",
+	"version":       "bigint",
+	"deleted_at":    "timestamp with time zone",
+	"source":        "text",
+	"profile":       "jsonb",
+	"search":        "tsvector",
+	"password_hash": "text",
+	"tenant":        "text",
+}
+
+// usersNotifyTriggerSQL points the insert trigger of migration 0004 at
+// another notification channel. %[1]s is the schema-qualified table, %[2]s
+// the quoted schema and %[3]s the channel name as a quoted literal.
+const usersNotifyTriggerSQL = `
+DROP TRIGGER IF EXISTS users_notify_insert ON %[1]s;
+
+CREATE TRIGGER users_notify_insert
+	AFTER INSERT ON %[1]s
+	FOR EACH ROW EXECUTE FUNCTION %[2]s.notify_user_inserted(%[3]s);`
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name              string
+		current           int64
+		needs, behind     string
+		wantWarn, wantErr string
+	}{
+		{name: "matching", current: 16},
+		{name: "matching with any", current: 16, needs: schemaAny},
+		{name: "behind warns", current: 12, behind: "warn", wantWarn: "behind this build's 16: run migrations to version 16"},
+		{name: "behind fails", current: 12, behind: "fail", wantErr: "behind this build's 16: run migrations to version 16"},
+		{name: "empty schema fails", current: 0, behind: "fail", wantErr: "at version 0, behind"},
+		{name: "behind, migrating command", current: 12, needs: schemaMigrates, behind: "fail"},
+		{name: "behind, any schema", current: 12, needs: schemaAny, behind: "fail"},
+		{name: "ahead fails", current: 17, behind: "warn", wantErr: "newer than this build's 16"},
+		{name: "ahead fails a migrating command", current: 17, needs: schemaMigrates, wantErr: "newer than this build's 16"},
+		{name: "ahead, any schema", current: 17, needs: schemaAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warning, err := checkSchemaVersion("public", tt.current, 16, tt.needs, tt.behind)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+			if !strings.Contains(warning, tt.wantWarn) || (tt.wantWarn == "") != (warning == "") {
+				t.Errorf("warning = %q, want %q", warning, tt.wantWarn)
+			}
+		})
+	}
+}
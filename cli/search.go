@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newSearchCmd builds the search command.
+func newSearchCmd(a *app) *cobra.Command {
+	var page users.Page
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Full-text search over usernames, emails and profiles",
+		Long: `Search the users with a to_tsquery expression and print the matches,
+most relevant first. Combine terms with & (and), | (or) and ! (not), and
+append :* for a prefix match:
+
+  search alice
+  search 'ali:* | bob'
+  search 'example.com & !admin'`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if a.isCockroach() {
+				return fmt.Errorf("search needs the search column, which is not created with DB_DRIVER=%s", config.DriverCockroach)
+			}
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				results, err := repo.Search(ctx, args[0], page)
+				if err != nil {
+					return fmt.Errorf("search failed: %w", err)
+				}
+				printSearchResults(os.Stdout, results)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().IntVar(&page.Limit, "limit", 20, "print at most this many users (0 for all)")
+	cmd.Flags().IntVar(&page.Offset, "offset", 0, "skip this many matches first")
+	return cmd
+}
+
+// printSearchResults writes results as an aligned table with their rank.
+func printSearchResults(w io.Writer, results []users.SearchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tID\tUSERNAME\tEMAIL")
+	for _, r := range results {
+		fmt.Fprintf(tw, "%.4f\t%s\t%s\t%s\n", r.Rank, r.ID, r.Username, r.Email)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "%d matches\n", len(results))
+}
@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hozana-dusabimana/users"
+	"go.yaml.in/yaml/v3"
+)
+
+// seedRecord is one user as written in a seed file.
+type seedRecord struct {
+	Username string `json:"username" yaml:"username"`
+	Email    string `json:"email" yaml:"email"`
+}
+
+// loadSeedFile reads user records from path. The format follows the
+// extension:
+//
+//	.json         an array of {"username": ..., "email": ...} objects
+//	.yaml, .yml   a list of mappings with username and email keys
+//	.csv          a header row naming username and email columns (in any
+//	              order; other columns are ignored), then one user per row
+//
+// Records are returned in file order and are not validated here.
+func loadSeedFile(path string) ([]users.User, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []seedRecord
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.NewDecoder(f).Decode(&records)
+	case ".yaml", ".yml":
+		err = yaml.NewDecoder(f).Decode(&records)
+		if errors.Is(err, io.EOF) {
+			err = nil // an empty file holds no records
+		}
+	case ".csv":
+		records, err = readSeedCSV(f)
+	default:
+		return nil, fmt.Errorf("%s: unsupported seed file type %q (use .json, .yaml, .yml or .csv)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	out := make([]users.User, len(records))
+	for i, r := range records {
+		out[i] = users.User{Username: strings.TrimSpace(r.Username), Email: strings.TrimSpace(r.Email)}
+	}
+	return out, nil
+}
+
+// readSeedCSV reads seed records from CSV with a header row.
+func readSeedCSV(r io.Reader) ([]seedRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // short rows are reported by validation, not rejected here
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(header[i]))
+	}
+	userCol, emailCol := slices.Index(header, "username"), slices.Index(header, "email")
+	if userCol < 0 || emailCol < 0 {
+		return nil, fmt.Errorf("header must name a username and an email column, got %q", strings.Join(header, ","))
+	}
+
+	var records []seedRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var rec seedRecord
+		if userCol < len(row) {
+			rec.Username = row[userCol]
+		}
+		if emailCol < len(row) {
+			rec.Email = row[emailCol]
+		}
+		records = append(records, rec)
+	}
+}
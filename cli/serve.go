@@ -0,0 +1,184 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/hozana-dusabimana/server"
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newServeCmd builds the serve command.
+func newServeCmd(a *app) *cobra.Command {
+	var addr string
+	var grace time.Duration
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the users table as a JSON REST API, a gRPC API or both (SERVE_API)",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runServe(cmd.Context(), addr, grace)
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", a.cfg().App.ServeAddr, "address to listen on")
+	cmd.Flags().DurationVar(&grace, "grace", a.cfg().App.ShutdownGrace, "how long in-flight requests may finish after SIGINT/SIGTERM")
+	return cmd
+}
+
+// runServe serves the users API until ctx is cancelled (SIGINT or SIGTERM),
+// then stops accepting connections and waits up to grace for in-flight
+// requests before closing the pool. SERVE_API selects the REST API on addr,
+// the gRPC API on GRPC_ADDR, or both.
+//
+// While it runs, SIGHUP, and with WATCH_CONFIG an edit to the .env file,
+// reloads the configuration; SIGHUP also reconnects with changed
+// connection settings. See reloadConfig.
+func (a *app) runServe(ctx context.Context, addr string, grace time.Duration) error {
+	first, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	pool := newLivePool(first)
+	defer pool.Close()
+	if err := a.migrateUp(ctx, first); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	replicas, err := a.openReplicas(ctx)
+	if err != nil {
+		return err
+	}
+	defer replicas.Close()
+	if interval := a.cfg().App.PoolStatsInterval; interval > 0 {
+		go reportPoolStats(ctx, "primary", pool, interval)
+		for _, r := range replicas.all() {
+			go reportPoolStats(ctx, r.name, r.pool, interval)
+		}
+	}
+
+	stopRelay, err := a.startOutboxRelay(ctx, pool)
+	if err != nil {
+		return err
+	}
+	defer stopRelay()
+
+	if err := metricsRegistry.Register(poolCollector{pool}); err != nil {
+		return err
+	}
+	repo, err := a.newRoutedUserRepository(pool, replicas)
+	if err != nil {
+		return err
+	}
+	var feed *server.Feed
+	reason := a.listenUnavailable()
+	if reason != "" {
+		slog.Info("live feed disabled: it relies on LISTEN/NOTIFY", "reason", reason)
+	} else {
+		feed = server.NewFeed()
+		go a.runFeed(ctx, feed, pool)
+	}
+
+	deps := server.Deps{
+		Logger:          slog.Default(),
+		Version:         version,
+		Repo:            repo,
+		Pool:            pool,
+		TxRepository:    a.newUserRepository,
+		Feed:            feed,
+		FeedUnavailable: reason,
+		Metrics:         metricsHandler(),
+		BreakerState:    func() string { return a.dbBreaker().State() },
+	}
+	if err := a.addOptionalStores(ctx, pool, &deps); err != nil {
+		return err
+	}
+	api, err := server.New(*a.cfg(), deps)
+	if err != nil {
+		return err
+	}
+
+	serveErr := make(chan error, 2)
+	var srv *http.Server
+	serveAPI, grpcAddr := a.cfg().App.ServeAPI, a.cfg().App.GRPCAddr
+	if serveAPI != "grpc" {
+		srv = api.HTTPServer(ctx, addr)
+		go func() {
+			slog.Info("serving users API", "addr", addr)
+			serveErr <- srv.ListenAndServe()
+		}()
+	}
+	if serveAPI != "rest" {
+		grpcSrv := api.GRPCServer()
+		if err := server.ServeGRPC(grpcSrv, grpcAddr, serveErr); err != nil {
+			return err
+		}
+		// Runs first among the deferred calls, so the pool is still open
+		// while the calls in progress finish
+		defer func() {
+			if feed != nil {
+				feed.Shutdown()
+			}
+			server.StopGRPC(grpcSrv, grace)
+		}()
+	}
+
+	changed, hangup, stopSignals := a.reloadSignals()
+	defer stopSignals()
+
+	for running := true; running; {
+		select {
+		case err := <-serveErr:
+			return err
+		case <-ctx.Done():
+			running = false
+		case <-changed:
+			a.reloadConfig(ctx, pool, false)
+		case <-hangup:
+			a.reloadConfig(ctx, pool, true)
+		}
+	}
+	slog.Info("shutting down; waiting for in-flight requests", "grace", grace)
+	if srv == nil {
+		return nil
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), grace)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// Grace period over: drop the remaining connections
+		srv.Close()
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	return nil
+}
+
+// addOptionalStores sets the posts and locations stores of deps for the
+// tables that exist on pool: posts once migration 0015 has run, which it
+// does on PostgreSQL only, and user_locations once geo setup has.
+func (a *app) addOptionalStores(ctx context.Context, pool users.Querier, deps *server.Deps) error {
+	if a.isCockroach() {
+		return nil
+	}
+	repo := users.NewRepository(pool, a.userRepositoryOptions())
+	for _, t := range []struct {
+		table, hint string
+		set         func()
+	}{
+		{a.postsTable(), "posts endpoints disabled: run migrate to enable them", func() { deps.Posts = repo }},
+		{a.locationsTable(), "location endpoints disabled: run geo setup to enable them", func() { deps.Locations = repo }},
+	} {
+		var exists bool
+		if err := pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", t.table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			slog.Debug(t.hint)
+			continue
+		}
+		t.set()
+	}
+	return nil
+}
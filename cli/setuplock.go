@@ -0,0 +1,74 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// withSetupLock runs fn on a connection of pool that holds the setup lock,
+// so that of several processes starting at once only one migrates at a
+// time and the others wait, up to SETUP_LOCK_TIMEOUT, then find the work
+// done. The setup lock is the advisory lock migrations take themselves,
+// held here at session level across the whole plan; the migrations fn runs
+// on conn take it again and are granted it at once.
+//
+// Without session locks (see sessionLocks) fn runs unlocked. On
+// CockroachDB concurrent migrators are kept apart by serializable
+// isolation, as in migrations.Run; through PgBouncer each migration still
+// takes the lock for its own transaction.
+func (a *app) withSetupLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn) error) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	if !a.sessionLocks() {
+		defer conn.Release()
+		return fn(conn)
+	}
+	if err := db.Lock(ctx, conn.Conn(), migrations.LockKey, a.cfg().App.SetupLockTimeout, a.setupLockWaiting); err != nil {
+		conn.Release()
+		return setupLockError(err)
+	}
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		if err := db.Unlock(ctx, conn.Conn(), migrations.LockKey); err != nil {
+			// Back in the pool, the connection would keep the lock and
+			// block every other process until it was closed
+			slog.Warn("releasing setup lock failed; closing its connection", "err", err)
+			conn.Conn().Close(ctx)
+		}
+		conn.Release()
+	}()
+	return fn(conn)
+}
+
+// lockSetupTx takes the setup lock for the rest of tx. The quickstart
+// migrates and seeds in one transaction, so holding the lock until it
+// commits keeps a second instance from seeding alongside the first.
+func (a *app) lockSetupTx(ctx context.Context, tx pgx.Tx) error {
+	err := db.LockXact(ctx, tx, migrations.LockKey, a.cfg().App.SetupLockTimeout, a.setupLockWaiting)
+	if err != nil {
+		return setupLockError(err)
+	}
+	return nil
+}
+
+// setupLockWaiting logs that another process holds the setup lock.
+func (a *app) setupLockWaiting() {
+	slog.Info("waiting for another process to finish migrating or seeding", "timeout", a.cfg().App.SetupLockTimeout)
+}
+
+// setupLockError explains a failure to take the setup lock.
+func setupLockError(err error) error {
+	if errors.Is(err, db.ErrLockTimeout) {
+		return fmt.Errorf("another process is still migrating or seeding (raise SETUP_LOCK_TIMEOUT to wait longer): %w", err)
+	}
+	return fmt.Errorf("taking setup lock: %w", err)
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// snapshotFormat identifies files written by the snapshot command.
+const snapshotFormat = "go-sql-quickstart/users-snapshot"
+
+// snapshotArchive is the portable representation of the users table.
+type snapshotArchive struct {
+	Format        string         `json:"format"`
+	SchemaVersion int            `json:"schema_version"`
+	TakenAt       time.Time      `json:"taken_at"`
+	Users         []snapshotUser `json:"users"`
+}
+
+// snapshotUser is one row of the users table as stored in an archive.
+type snapshotUser struct {
+	ID        users.ID   `json:"id" db:"id"`
+	Username  string     `json:"username" db:"username"`
+	Email     string     `json:"email" db:"email"`
+	CreatedAt *time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at" db:"updated_at"`
+	Version   int64      `json:"version" db:"version"`
+	Source    *string    `json:"source,omitempty" db:"source"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Profile is kept as raw JSON, so keys users.Profile does not know
+	// about survive the round trip.
+	Profile json.RawMessage `json:"profile,omitempty" db:"profile"`
+	// PasswordHash is archived so a restore keeps users able to log in;
+	// treat snapshot files as secrets accordingly.
+	PasswordHash *string `json:"password_hash,omitempty" db:"password_hash"`
+}
+
+// takeSnapshot reads every user into an archive stamped with the schema version.
+func (a *app) takeSnapshot(ctx context.Context, pool *pgxpool.Pool) (*snapshotArchive, error) {
+	users, err := db.Select[snapshotUser](ctx, pool, "SELECT id, username, email, created_at, updated_at, version, source, deleted_at, profile, password_hash FROM "+a.usersTable()+" ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotArchive{
+		Format:        snapshotFormat,
+		SchemaVersion: schemaVersion,
+		TakenAt:       time.Now().UTC(),
+		Users:         users,
+	}, nil
+}
+
+// restoreSnapshot recreates the archived rows, preserving ids and timestamps.
+// The schema is created first if needed. Rows are loaded with COPY inside one
+// transaction, so a failed restore leaves the table untouched, and progress
+// is reported from the server while the COPY runs. With replace, existing
+// rows are deleted first; otherwise a clashing row aborts the restore.
+func (a *app) restoreSnapshot(ctx context.Context, pool *pgxpool.Pool, archive *snapshotArchive, replace bool) error {
+	if archive.Format != snapshotFormat {
+		return fmt.Errorf("not a users snapshot (format %q)", archive.Format)
+	}
+	if archive.SchemaVersion != schemaVersion {
+		return fmt.Errorf("snapshot has schema version %d but this program uses version %d", archive.SchemaVersion, schemaVersion)
+	}
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return err
+	}
+
+	return a.withTx(ctx, pool, func(tx pgx.Tx) error {
+		if replace {
+			if _, err := tx.Exec(ctx, "DELETE FROM "+a.usersTable()); err != nil {
+				return fmt.Errorf("clearing users: %w", err)
+			}
+		}
+
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		go a.watchServerProgress(watchCtx, pool, tx.Conn().PgConn().PID(), int64(len(archive.Users)))
+		_, err := tx.CopyFrom(ctx,
+			pgx.Identifier{a.dbSchema(), "users"},
+			[]string{"id", "username", "email", "created_at", "updated_at", "version", "source", "deleted_at", "profile", "password_hash"},
+			pgx.CopyFromSlice(len(archive.Users), func(i int) ([]any, error) {
+				u := archive.Users[i]
+				id, err := u.ID.CopyValue()
+				if err != nil {
+					return nil, err
+				}
+				profile := u.Profile
+				if profile == nil {
+					profile = json.RawMessage("{}")
+				}
+				return []any{id, u.Username, u.Email, u.CreatedAt, u.UpdatedAt, u.Version, u.Source, u.DeletedAt, profile, u.PasswordHash}, nil
+			}))
+		stopWatching()
+		if err != nil {
+			return fmt.Errorf("restoring users: %w", err)
+		}
+		return a.resetIDSequence(ctx, tx, a.usersTable())
+	})
+}
+
+// resetIDSequence moves the sequence behind table's id column past the ids
+// it holds, so inserts after a restore don't collide with restored rows.
+// UUID ids, and CockroachDB's unique_rowid() ones, have no sequence.
+// table is quoted and schema-qualified.
+func (a *app) resetIDSequence(ctx context.Context, tx pgx.Tx, table string) error {
+	if a.isCockroach() {
+		return nil
+	}
+	var sequence *string
+	if err := tx.QueryRow(ctx, "SELECT pg_get_serial_sequence($1, 'id')", table).Scan(&sequence); err != nil {
+		return fmt.Errorf("finding id sequence of %s: %w", table, err)
+	}
+	if sequence == nil {
+		return nil
+	}
+	_, err := tx.Exec(ctx, "SELECT setval($1, GREATEST((SELECT max(id) FROM "+table+"), 1))", *sequence)
+	if err != nil {
+		return fmt.Errorf("resetting id sequence of %s: %w", table, err)
+	}
+	return nil
+}
+
+// writeSnapshot encodes an archive as JSON, optionally gzip-compressed.
+func writeSnapshot(w io.Writer, archive *snapshotArchive, compress bool) error {
+	if compress {
+		zw := gzip.NewWriter(w)
+		if err := json.NewEncoder(zw).Encode(archive); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return json.NewEncoder(w).Encode(archive)
+}
+
+// readSnapshot decodes an archive, detecting gzip compression from the
+// file's magic bytes so restore needs no flag for it.
+func readSnapshot(r io.Reader) (*snapshotArchive, error) {
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		src = zr
+	}
+	var archive snapshotArchive
+	if err := json.NewDecoder(src).Decode(&archive); err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return &archive, nil
+}
+
+// newSnapshotCmd builds the snapshot command.
+func newSnapshotCmd(a *app) *cobra.Command {
+	var out string
+	var compress bool
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save the users table to a portable file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runSnapshot(cmd.Context(), out, compress)
+		},
+	}
+	cmd.Flags().StringVar(&out, "out", "users.snapshot.json", "file to write")
+	cmd.Flags().BoolVar(&compress, "gzip", false, "gzip-compress the archive")
+	return cmd
+}
+
+// runSnapshot implements the snapshot command.
+func (a *app) runSnapshot(ctx context.Context, out string, compress bool) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	archive, err := a.takeSnapshot(ctx, pool)
+	if err != nil {
+		return fmt.Errorf("snapshot failed: %w", err)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := writeSnapshot(f, archive, compress); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s failed: %w", out, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	slog.Info("snapshot saved", "users", len(archive.Users), "schema_version", archive.SchemaVersion, "path", out)
+	return nil
+}
+
+// newRestoreCmd builds the restore command.
+func newRestoreCmd(a *app) *cobra.Command {
+	var in string
+	var replace bool
+	cmd := &cobra.Command{
+		Use:   "restore",
+		Short: "Load a snapshot back into the users table",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runRestore(cmd.Context(), in, replace)
+		},
+	}
+	cmd.Flags().StringVar(&in, "in", "users.snapshot.json", "snapshot file to restore (plain or gzip)")
+	cmd.Flags().BoolVar(&replace, "replace", false, "delete existing users before restoring")
+	return cmd
+}
+
+// runRestore implements the restore command.
+func (a *app) runRestore(ctx context.Context, in string, replace bool) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	archive, err := readSnapshot(f)
+	if err != nil {
+		return err
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if err := a.restoreSnapshot(ctx, pool, archive, replace); err != nil {
+		return fmt.Errorf("restore failed: %w", err)
+	}
+	slog.Info("snapshot restored", "users", len(archive.Users), "path", in)
+	return nil
+}
@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	_ "modernc.org/sqlite" // registers the "sqlite" database/sql driver
+)
+
+// openSQLDB opens the database/sql pool used with DB_DRIVER=mysql or
+// sqlite, waits for the server like connect does and creates the users
+// table if it is missing, or adds the columns it gained since. The caller
+// is responsible for closing the pool.
+//
+// For MySQL, CONN_STR is a go-sql-driver DSN such as
+// user:password@tcp(localhost:3306)/testdb. For SQLite it is the database
+// file, created on first use, or a file: URI.
+//
+// With READ_ONLY the sessions are read-only, MySQL's through
+// transaction_read_only and SQLite's through the query_only pragma, and
+// the table is neither created nor upgraded.
+//
+// DB_MAX_CONNS, DB_MAX_CONN_LIFETIME and DB_MAX_CONN_IDLE_TIME size the pool;
+// database/sql never opens connections ahead of use, so DB_MIN_CONNS has no
+// effect.
+func (a *app) openSQLDB(ctx context.Context) (*sql.DB, error) {
+	var db *sql.DB
+	var schema string
+	var upgrades []users.ColumnUpgrade
+	transient := func(error) bool { return false }
+	switch a.cfg().Database.Driver {
+	case config.DriverMySQL:
+		connector, err := a.mysqlConnector(a.cfg().Database.ConnStr)
+		if err != nil {
+			return nil, err
+		}
+		db, schema, upgrades, transient = sql.OpenDB(connector), users.MySQLSchema, users.MySQLUpgrades, isTransientMySQLError
+	case config.DriverSQLite:
+		var err error
+		if db, err = sql.Open("sqlite", a.sqliteDSN(a.cfg().Database.ConnStr)); err != nil {
+			return nil, err
+		}
+		schema, upgrades = users.SQLiteSchema, users.SQLiteUpgrades
+	default:
+		return nil, fmt.Errorf("DB_DRIVER=%s is not a database/sql driver", a.cfg().Database.Driver)
+	}
+
+	settings := a.cfg().Pool
+	if settings.MaxConns > 0 {
+		db.SetMaxOpenConns(int(settings.MaxConns))
+	}
+	db.SetConnMaxLifetime(settings.MaxConnLifetime)
+	db.SetConnMaxIdleTime(settings.MaxConnIdleTime)
+
+	if err := a.retryConnect(ctx, db.PingContext, transient); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if a.cfg().Database.ReadOnly {
+		return db, nil
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating users table: %w", err)
+	}
+	// A table created by an older schema lacks the columns added since
+	for _, upgrade := range upgrades {
+		if rows, err := db.QueryContext(ctx, "SELECT "+upgrade.Column+" FROM users LIMIT 0"); err == nil {
+			rows.Close()
+		} else if _, err := db.ExecContext(ctx, upgrade.SQL); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("adding %s column: %w", upgrade.Column, err)
+		}
+	}
+	return db, nil
+}
+
+// mysqlConnector parses a go-sql-driver DSN and makes timestamps be stored
+// and read as UTC, as the PostgreSQL columns are.
+func (a *app) mysqlConnector(connStr string) (driver.Connector, error) {
+	dsn, err := mysql.ParseDSN(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MySQL DSN: %w", err)
+	}
+	dsn.ParseTime, dsn.Loc = true, time.UTC
+	if dsn.Params == nil {
+		dsn.Params = map[string]string{}
+	}
+	dsn.Params["time_zone"] = "'+00:00'"
+	if a.cfg().Database.ReadOnly {
+		dsn.Params["transaction_read_only"] = "1"
+	}
+	connector, err := mysql.NewConnector(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MySQL DSN: %w", err)
+	}
+	return connector, nil
+}
+
+// isTransientMySQLError reports whether a failed ping is worth retrying:
+// network failures, and the server being out of connections (1040).
+func isTransientMySQLError(err error) bool {
+	var myErr *mysql.MySQLError
+	if errors.As(err, &myErr) {
+		return myErr.Number == 1040
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// sqliteDSN adds the pragmas every connection needs to the database file
+// name: a busy timeout, so concurrent writers wait for the lock instead of
+// failing with SQLITE_BUSY, and write-ahead logging, so readers do not
+// block the writer. With READ_ONLY, query_only makes every write fail.
+func (a *app) sqliteDSN(file string) string {
+	sep := "?"
+	if strings.Contains(file, "?") {
+		sep = "&"
+	}
+	dsn := file + sep + "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	if a.cfg().Database.ReadOnly {
+		dsn += "&_pragma=query_only(1)"
+	}
+	return dsn
+}
+
+// newSQLUserRepository is newUserRepository for DB_DRIVER=mysql or sqlite.
+// db may be a pool, a single connection or a transaction.
+func (a *app) newSQLUserRepository(db users.SQLQuerier) users.Repository {
+	if a.cfg().Database.Driver == config.DriverSQLite {
+		return a.guardUserRepository(metricsRepository{users.NewSQLiteRepository(db, a.userRepositoryOptions())})
+	}
+	return a.guardUserRepository(metricsRepository{users.NewMySQLRepository(db, a.userRepositoryOptions())})
+}
+
+// usesSQLDB reports whether DB_DRIVER selects a database/sql driver rather
+// than pgx.
+func (a *app) usesSQLDB() bool {
+	driver := a.cfg().Database.Driver
+	return driver == config.DriverMySQL || driver == config.DriverSQLite
+}
+
+// openUserRepository connects to the database selected by DB_DRIVER and
+// returns a users repository on it, with the function that disconnects.
+func (a *app) openUserRepository(ctx context.Context) (users.Repository, func(), error) {
+	if a.usesSQLDB() {
+		db, err := a.openSQLDB(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return a.newSQLUserRepository(db), func() { db.Close() }, nil
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	replicas, err := a.openReplicas(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, nil, err
+	}
+	closeAll := func() {
+		replicas.Close()
+		pool.Close()
+	}
+	repo, err := a.newRoutedUserRepository(pool, replicas)
+	if err != nil {
+		closeAll()
+		return nil, nil, err
+	}
+	return repo, closeAll, nil
+}
+
+// runSQLQuickstart is runQuickstart for DB_DRIVER=mysql or sqlite: it
+// checks the connection, makes sure the users table exists and seeds it in
+// one transaction. The table is created by openSQLDB, outside the
+// transaction: MySQL commits DDL on the spot anyway, and CREATE TABLE IF
+// NOT EXISTS leaves nothing half done.
+func (a *app) runSQLQuickstart(ctx context.Context, opts quickstartOptions) error {
+	db, err := a.openSQLDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Read as text: SQLite only parses a time.Time for a column declared
+	// DATETIME, not for an expression
+	var nowText string
+	if err := db.QueryRowContext(ctx, "SELECT CAST(CURRENT_TIMESTAMP AS CHAR)").Scan(&nowText); err != nil {
+		return fmt.Errorf("QueryRow failed: %w", err)
+	}
+	now, err := time.Parse(time.DateTime, nowText)
+	if err != nil {
+		return fmt.Errorf("parsing the database time: %w", err)
+	}
+	slog.Info("users table ready", "driver", a.cfg().Database.Driver)
+
+	records, batchSource := a.seedInput(opts)
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return bootstrapError(bootstrapBegin, err)
+	}
+	defer tx.Rollback()
+	result, err := a.seedUsers(ctx, a.newSQLUserRepository(tx), records, batchSource)
+	if err != nil {
+		return bootstrapError(bootstrapSeed, err)
+	}
+	if result.Failed > 0 {
+		a.printSeedIssues(os.Stdout, result.Issues)
+		return bootstrapError(bootstrapSeed, fmt.Errorf("%d users failed", result.Failed))
+	}
+	if err := tx.Commit(); err != nil {
+		return bootstrapError(bootstrapCommit, err)
+	}
+
+	slog.Info("quickstart complete", result.attrs(slog.Time("db_time", a.displayTime(now)), slog.String("developer", a.cfg().App.Developer))...)
+	a.printSeedResult(os.Stdout, result)
+	return nil
+}
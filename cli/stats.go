@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newStatsCmd builds the stats command group, which refreshes and reads
+// the user_stats materialized view of migration 0017.
+func newStatsCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Refresh and print the signups per day kept in the user_stats view",
+		Long: `An example of a read model: user_stats is a materialized view that keeps
+the number of users who signed up each UTC day, so reading it costs the
+same however large users grows. It is as current as its last refresh;
+stats refresh recomputes it without blocking readers, and stats show
+prints it. Needs PostgreSQL.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := cmd.Root().PersistentPreRunE(cmd, args); err != nil {
+				return err
+			}
+			if a.usesSQLDB() || a.isCockroach() {
+				return fmt.Errorf("the user_stats view is created by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+			}
+			return nil
+		},
+	}
+
+	refresh := &cobra.Command{
+		Use:     "refresh",
+		Short:   "Recompute user_stats from the users table",
+		Example: `  go run ./cmd/go-postgres stats refresh`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// A refresh reads all of users, which may take longer than
+			// QUERY_TIMEOUT allows a query
+			ctx := db.WithoutQueryTimeout(cmd.Context())
+			return a.withPostgresRepository(ctx, func(ctx context.Context, repo *users.PostgresRepository) error {
+				start := time.Now()
+				if err := repo.RefreshStats(ctx); err != nil {
+					return fmt.Errorf("refreshing user_stats: %w", err)
+				}
+				slog.Info("user_stats refreshed", "elapsed", time.Since(start).Round(time.Millisecond))
+				return nil
+			})
+		},
+	}
+
+	var days int
+	show := &cobra.Command{
+		Use:     "show",
+		Short:   "Print the signups per day as of the last refresh, newest first",
+		Example: `  go run ./cmd/go-postgres stats show --days 7`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withPostgresRepository(cmd.Context(), func(ctx context.Context, repo *users.PostgresRepository) error {
+				stats, err := repo.DailySignups(ctx, days)
+				if err != nil {
+					return fmt.Errorf("reading user_stats: %w", err)
+				}
+				return a.printDailySignups(stats)
+			})
+		},
+	}
+	show.Flags().IntVar(&days, "days", 30, "print at most this many days, counting only days with signups (0 for all)")
+
+	cmd.AddCommand(refresh, show)
+	return cmd
+}
+
+// printDailySignups writes stats as a table, or as a document with
+// --output json or yaml.
+func (a *app) printDailySignups(stats []users.DailySignups) error {
+	if a.structuredOutput() {
+		if stats == nil {
+			stats = []users.DailySignups{}
+		}
+		return a.writeStructured(os.Stdout, map[string]any{"days": stats})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DAY\tSIGNUPS")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\n", s.Day.Format(time.DateOnly), s.Signups)
+	}
+	return tw.Flush()
+}
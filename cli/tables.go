@@ -0,0 +1,206 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// createTableSQL returns the CREATE TABLE IF NOT EXISTS statement of a
+// table declared in the configuration file, in DB_SCHEMA. Names were
+// checked by config.Load, so only the table names need quoting.
+func (a *app) createTableSQL(t config.Table) string {
+	var defs []string
+	for _, c := range t.Columns {
+		def := c.Name + " " + strings.TrimSpace(c.Type)
+		if c.PrimaryKey {
+			def += " PRIMARY KEY"
+		}
+		if c.NotNull {
+			def += " NOT NULL"
+		}
+		if c.Unique {
+			def += " UNIQUE"
+		}
+		if c.Default != "" {
+			def += " DEFAULT " + c.Default
+		}
+		if table, column := c.ReferencedTable(); table != "" {
+			def += " REFERENCES " + pgx.Identifier{a.dbSchema(), table}.Sanitize()
+			if column != "" {
+				def += " (" + column + ")"
+			}
+		}
+		defs = append(defs, def)
+	}
+	for _, group := range t.Unique {
+		defs = append(defs, "UNIQUE ("+strings.Join(group, ", ")+")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n\t%s\n)",
+		pgx.Identifier{a.dbSchema(), t.Name}.Sanitize(), strings.Join(defs, ",\n\t"))
+}
+
+// createConfiguredTables creates the tables declared in the configuration
+// file that do not exist yet, in the order declared, so a table may
+// reference the ones before it and the program's own. Existing tables are
+// left as they are, even when their declaration has changed since. It
+// uses db.WithTx rather than withTx, since applyMigrations may be given a
+// transaction that CockroachDB retries as a whole.
+func (a *app) createConfiguredTables(ctx context.Context, b db.Beginner) error {
+	if len(a.cfg().Tables) == 0 {
+		return nil
+	}
+	return db.WithTx(ctx, b, func(tx pgx.Tx) error {
+		for _, t := range a.cfg().Tables {
+			if _, err := tx.Exec(ctx, a.createTableSQL(t)); err != nil {
+				return fmt.Errorf("creating table %s: %w", t.Name, err)
+			}
+		}
+		slog.Debug("configured tables ensured", "tables", len(a.cfg().Tables))
+		return nil
+	})
+}
+
+// configuredTableNames returns the names of the declared tables, in the
+// order declared.
+func (a *app) configuredTableNames() []string {
+	var names []string
+	for _, t := range a.cfg().Tables {
+		names = append(names, t.Name)
+	}
+	return names
+}
+
+// configuredTable returns the declaration of table name.
+func (a *app) configuredTable(name string) (config.Table, error) {
+	for _, t := range a.cfg().Tables {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	names := a.configuredTableNames()
+	if len(names) == 0 {
+		return config.Table{}, errors.New("no tables are declared; add a tables section to the configuration file (see --config)")
+	}
+	return config.Table{}, fmt.Errorf("table %q is not declared; the configuration file declares %s", name, strings.Join(names, ", "))
+}
+
+// insertRow inserts one row into the declared table t, taking the columns
+// of values and the defaults for the others, and returns the row as
+// inserted. Values are sent as text, which PostgreSQL converts to the
+// column's type.
+func (a *app) insertRow(ctx context.Context, q users.Querier, t config.Table, values map[string]string) (map[string]any, error) {
+	var cols, params []string
+	var args []any
+	for _, c := range t.Columns {
+		if v, ok := values[c.Name]; ok {
+			cols = append(cols, c.Name)
+			args = append(args, v)
+			params = append(params, fmt.Sprintf("$%d", len(args)))
+		}
+	}
+	if len(cols) < len(values) {
+		for name := range values {
+			if !slices.ContainsFunc(t.Columns, func(c config.Column) bool { return c.Name == name }) {
+				return nil, fmt.Errorf("table %s has no column %q", t.Name, name)
+			}
+		}
+	}
+	stmt := "INSERT INTO " + pgx.Identifier{a.dbSchema(), t.Name}.Sanitize()
+	if len(cols) == 0 {
+		stmt += " DEFAULT VALUES"
+	} else {
+		stmt += " (" + strings.Join(cols, ", ") + ") VALUES (" + strings.Join(params, ", ") + ")"
+	}
+	rows, err := q.Query(ctx, stmt+" RETURNING *", args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectOneRow(rows, pgx.RowToMap)
+}
+
+// newTableCmd builds the table command group.
+func newTableCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "table",
+		Short: "Inspect and fill the tables declared in the configuration file",
+		Long: `Tables declared under the tables key of the configuration file are created
+with CREATE TABLE IF NOT EXISTS whenever the program migrates, after its
+own tables.`,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "sql",
+		Short: "Print the CREATE TABLE statements of the declared tables",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(a.cfg().Tables) == 0 {
+				return errors.New("no tables are declared; add a tables section to the configuration file (see --config)")
+			}
+			for _, t := range a.cfg().Tables {
+				fmt.Printf("%s;\n\n", a.createTableSQL(t))
+			}
+			return nil
+		},
+	}, migratesSchema(&cobra.Command{
+		Use:   "insert TABLE [COLUMN=VALUE ...]",
+		Short: "Insert a row into a declared table and print it",
+		Long: `Insert one row into a declared table. Columns left out take their default,
+or NULL. Values are given as text, as in psql, and converted by the database:
+42, true, 2025-01-31 or {a,b} for an array.`,
+		Example: `  go run ./cmd/go-postgres --config config.yaml table insert teams name=red owner_id=1`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runTableInsert(cmd.Context(), args[0], args[1:])
+		},
+	}))
+	return cmd
+}
+
+// runTableInsert implements table insert.
+func (a *app) runTableInsert(ctx context.Context, name string, assignments []string) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("declared tables need PostgreSQL or CockroachDB, not DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	t, err := a.configuredTable(name)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	for _, a := range assignments {
+		col, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return fmt.Errorf("%q is not COLUMN=VALUE", a)
+		}
+		values[col] = v
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migration failed: %w", err)
+	}
+
+	row, err := a.insertRow(ctx, pool, t, values)
+	if err != nil {
+		return fmt.Errorf("inserting into %s: %w", t.Name, err)
+	}
+	for _, c := range t.Columns {
+		if v := row[c.Name]; v == nil {
+			fmt.Printf("%s: NULL\n", c.Name)
+		} else {
+			fmt.Printf("%s: %v\n", c.Name, v)
+		}
+	}
+	return nil
+}
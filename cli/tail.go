@@ -0,0 +1,188 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// tailedUser is the JSON payload published by the insert trigger.
+type tailedUser struct {
+	ID        users.ID  `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newTailCmd builds the tail command.
+func newTailCmd(a *app) *cobra.Command {
+	var since time.Duration
+	var channel string
+	cmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Print new users as they are inserted",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runTail(cmd.Context(), since, channel)
+		},
+	}
+	cmd.Flags().DurationVar(&since, "since", 0, "print users created within this window before streaming (e.g. 1h)")
+	cmd.Flags().StringVar(&channel, "channel", a.cfg().App.TailChannel, "notification channel used by the insert trigger")
+	return cmd
+}
+
+// runTail prints new users as they are inserted, like `tail -f` for the
+// users table. With --since it first prints the rows created within that
+// window. Lost connections are re-established and any rows inserted while
+// disconnected are printed before streaming resumes.
+func (a *app) runTail(ctx context.Context, since time.Duration, channel string) error {
+	if reason := a.listenUnavailable(); reason != "" {
+		return fmt.Errorf("tail relies on LISTEN/NOTIFY: %s", reason)
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	cursor := tailCursor{emit: func(u tailedUser) {
+		fmt.Printf("%s\t%s\t%s\t%s\n", u.ID, u.Username, u.Email, a.displayTime(u.CreatedAt).Format(displayTimeLayout))
+	}}
+	return reconnecting(ctx, "tail", func(first bool) error {
+		return a.tailOnce(ctx, pool, channel, since, first, &cursor)
+	})
+}
+
+// tailOnce runs a single LISTEN session until the connection fails or ctx
+// is cancelled. On the first session it prints the --since backlog; on later
+// sessions it catches up on rows created since the last one printed.
+//
+// The session's connection is taken out of the pool (hijacked) and closed at
+// the end, so a connection left in LISTEN state is never handed to other code.
+func (a *app) tailOnce(ctx context.Context, pool *pgxpool.Pool, channel string, since time.Duration, first bool, cursor *tailCursor) error {
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return err
+	}
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	conn := pooled.Hijack()
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if err := a.startListening(ctx, conn, channel, since, first, cursor); err != nil {
+		return err
+	}
+	if first {
+		fmt.Fprintf(os.Stderr, "Waiting for new users on channel %q (Ctrl-C to stop)...\n", channel)
+	}
+	return forwardNotifications(ctx, conn, cursor)
+}
+
+// startListening points the insert trigger at channel, listens on it and
+// catches up on the users conn could have missed; see catchUp.
+func (a *app) startListening(ctx context.Context, conn *pgx.Conn, channel string, since time.Duration, first bool, cursor *tailCursor) error {
+	quoted := pgx.Identifier{channel}.Sanitize()
+	if _, err := conn.Exec(ctx, fmt.Sprintf(usersNotifyTriggerSQL, a.usersTable(), pgx.Identifier{a.dbSchema()}.Sanitize(), quoteLiteral(channel))); err != nil {
+		return fmt.Errorf("pointing notify trigger at %s: %w", channel, err)
+	}
+	// LISTEN before reading the backlog so no insert falls between the two
+	if _, err := conn.Exec(ctx, "LISTEN "+quoted); err != nil {
+		return fmt.Errorf("listening on %s: %w", channel, err)
+	}
+	return a.catchUp(ctx, conn, since, first, cursor)
+}
+
+// forwardNotifications passes cursor each user notified on conn until the
+// connection fails or ctx is cancelled.
+func forwardNotifications(ctx context.Context, conn *pgx.Conn, cursor *tailCursor) error {
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			return fmt.Errorf("waiting for notification: %w", err)
+		}
+		var u tailedUser
+		if err := json.Unmarshal([]byte(n.Payload), &u); err != nil {
+			slog.Warn("ignoring malformed user notification", "payload", n.Payload, "err", err)
+			continue
+		}
+		cursor.add(u)
+	}
+}
+
+// catchUp passes cursor the users a LISTEN session could have missed: on
+// the first session those created within since, on later ones those created
+// since the newest it has seen. Run it after LISTEN, so no insert falls
+// between the two.
+func (a *app) catchUp(ctx context.Context, conn *pgx.Conn, since time.Duration, first bool, cursor *tailCursor) error {
+	var rows pgx.Rows
+	var err error
+	switch {
+	case first && since > 0:
+		rows, err = conn.Query(ctx, `SELECT id, username, email, created_at FROM `+a.usersTable()+`
+			WHERE created_at >= now() - $1::interval ORDER BY created_at, id`, since)
+	case !first:
+		rows, err = conn.Query(ctx, `SELECT id, username, email, created_at FROM `+a.usersTable()+`
+			WHERE created_at >= $1 ORDER BY created_at, id`, cursor.createdAt)
+	}
+	if rows != nil {
+		var u tailedUser
+		_, err = pgx.ForEachRow(rows, []any{&u.ID, &u.Username, &u.Email, &u.CreatedAt}, func() error {
+			cursor.add(u)
+			return nil
+		})
+	}
+	if err == nil && cursor.createdAt.IsZero() {
+		// Nothing printed yet: a reconnect catches up from here
+		err = conn.QueryRow(ctx, "SELECT now()").Scan(&cursor.createdAt)
+	}
+	if err != nil {
+		return fmt.Errorf("reading recent users: %w", err)
+	}
+	return nil
+}
+
+// tailCursor remembers the newest user emitted, so that a catch-up query
+// and the notifications it overlaps with emit each user once. Users are
+// ordered by created_at rather than id, since UUID ids have no order.
+type tailCursor struct {
+	emit      func(tailedUser)  // called once for each new user
+	createdAt time.Time         // newest created_at emitted
+	printed   map[users.ID]bool // the users emitted with exactly that created_at
+}
+
+// add emits u unless it is older than the newest user emitted or was
+// already emitted. created_at is a timestamptz, so queries and the JSON of
+// notifications carry its offset, and times compare as instants whatever
+// zone either was written in.
+func (c *tailCursor) add(u tailedUser) {
+	switch {
+	case u.CreatedAt.Before(c.createdAt):
+		return
+	case u.CreatedAt.Equal(c.createdAt) && c.printed[u.ID]:
+		return
+	case u.CreatedAt.After(c.createdAt) || c.printed == nil:
+		c.createdAt, c.printed = u.CreatedAt, map[users.ID]bool{}
+	}
+	c.printed[u.ID] = true
+	c.emit(u)
+}
+
+// quoteLiteral quotes s as a SQL string literal for statements that cannot
+// take bind parameters, such as trigger arguments in DDL.
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// newTenantCmd builds the tenant command group. A tenant is a schema named
+// config.TenantSchemaPrefix + name holding its own copy of every table;
+// --tenant (or TENANT) points any other command at it.
+func newTenantCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "tenant",
+		Short: "Provision and list tenants, each a schema of its own",
+	}
+	cmd.AddCommand(allowDryRun(&cobra.Command{
+		Use:   "create <name>",
+		Short: "Create a tenant's schema and migrate it to the latest version",
+		Long: `Create the schema tenant_<name> if it does not exist and apply every
+pending migration in it. Running it again for an existing tenant upgrades
+its schema, so it also serves to migrate tenants after an upgrade.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runTenantCreate(cmd.Context(), args[0])
+		},
+	}), &cobra.Command{
+		Use:   "list",
+		Short: "Print every tenant with its schema version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runTenantList(cmd.Context())
+		},
+	})
+	return cmd
+}
+
+// useTenant points the rest of the process at tenant name, as --tenant
+// does at startup. It fails when --tenant names another tenant.
+func (a *app) useTenant(name string) error {
+	schema, err := config.TenantSchema(name)
+	if err != nil {
+		return err
+	}
+	if t := a.cfg().Database.Tenant; t != "" && t != name {
+		return fmt.Errorf("--tenant=%s conflicts with tenant %q", t, name)
+	}
+	a.setCfg(func(cfg *config.Config) {
+		cfg.Database.Tenant, cfg.Database.Schema = name, schema
+	})
+	return nil
+}
+
+// runTenantCreate implements tenant create.
+func (a *app) runTenantCreate(ctx context.Context, name string) error {
+	if err := a.useTenant(name); err != nil {
+		return err
+	}
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	createSchema := "CREATE SCHEMA IF NOT EXISTS " + pgx.Identifier{a.dbSchema()}.Sanitize()
+	if a.cfg().App.DryRun {
+		fmt.Printf("%s;\n", createSchema)
+		return a.printPendingMigrationSQL(ctx, os.Stdout, pool)
+	}
+	if _, err := pool.Exec(ctx, createSchema); err != nil {
+		return fmt.Errorf("creating schema %q: %w", a.dbSchema(), err)
+	}
+	if err := a.migrateUp(ctx, pool); err != nil {
+		return fmt.Errorf("migrating tenant %q: %w", name, err)
+	}
+	current, err := migrations.Current(ctx, pool, a.dbSchema())
+	if err != nil {
+		return err
+	}
+	slog.Info("tenant ready", "tenant", name, "schema", a.dbSchema(), "version", current)
+	return nil
+}
+
+// tenantInfo is one line of tenant list.
+type tenantInfo struct {
+	Name    string
+	Schema  string
+	Version int64
+}
+
+// runTenantList implements tenant list.
+func (a *app) runTenantList(ctx context.Context) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	// _ is a LIKE wildcard, so the prefix is compared rather than matched
+	schemas, err := db.SelectColumn[string](ctx, pool, "SELECT nspname FROM pg_namespace WHERE left(nspname, length($1)) = $1 ORDER BY nspname", config.TenantSchemaPrefix)
+	if err != nil {
+		return fmt.Errorf("listing tenants: %w", err)
+	}
+	tenants := make([]tenantInfo, 0, len(schemas))
+	for _, schema := range schemas {
+		current, err := migrations.Current(ctx, pool, schema)
+		if err != nil {
+			return fmt.Errorf("reading schema version of %q: %w", schema, err)
+		}
+		tenants = append(tenants, tenantInfo{
+			Name:    strings.TrimPrefix(schema, config.TenantSchemaPrefix),
+			Schema:  schema,
+			Version: current,
+		})
+	}
+	printTenants(os.Stdout, tenants)
+	return nil
+}
+
+// printTenants writes tenants as an aligned table, marking those whose
+// schema is behind this build's migrations.
+func printTenants(w io.Writer, tenants []tenantInfo) {
+	if len(tenants) == 0 {
+		fmt.Fprintln(w, "No tenants")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TENANT\tSCHEMA\tVERSION")
+	for _, t := range tenants {
+		version := fmt.Sprint(t.Version)
+		if t.Version < int64(schemaVersion) {
+			version += fmt.Sprintf(" (latest %d: run tenant create %s)", schemaVersion, t.Name)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", t.Name, t.Schema, version)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// applyTLS replaces the TLS settings parsed from the connection string
+// with the ones built from DB_SSLMODE, DB_SSLROOTCERT, DB_SSLCERT, DB_SSLKEY
+// and DB_SSLSERVERNAME, so TLS can be configured without hand-crafting
+// sslmode and file parameters into CONN_STR. Without DB_SSLMODE the
+// connection string's own settings are kept.
+//
+// Every host of the connection string is tried with the TLS variants the
+// mode allows, in libpq's order: prefer tries TLS before plain text, allow
+// plain text before TLS.
+func (a *app) applyTLS(cfg *pgconn.Config) error {
+	settings := a.cfg().Database.TLS
+	if settings.Mode == "" {
+		return nil
+	}
+
+	type hostPort struct {
+		host string
+		port uint16
+	}
+	hosts := []hostPort{{cfg.Host, cfg.Port}}
+	for _, fb := range cfg.Fallbacks {
+		if hp := (hostPort{fb.Host, fb.Port}); hp != hosts[len(hosts)-1] {
+			hosts = append(hosts, hp)
+		}
+	}
+
+	var attempts []*pgconn.FallbackConfig
+	for _, hp := range hosts {
+		tlsConfig, err := newTLSConfig(settings, hp.host)
+		if err != nil {
+			return err
+		}
+		variants := []*tls.Config{tlsConfig}
+		switch {
+		case tlsConfig == nil:
+		case settings.Mode == "prefer":
+			variants = []*tls.Config{tlsConfig, nil}
+		case settings.Mode == "allow":
+			variants = []*tls.Config{nil, tlsConfig}
+		}
+		for _, v := range variants {
+			attempts = append(attempts, &pgconn.FallbackConfig{Host: hp.host, Port: hp.port, TLSConfig: v})
+		}
+	}
+	cfg.Host, cfg.Port, cfg.TLSConfig = attempts[0].Host, attempts[0].Port, attempts[0].TLSConfig
+	cfg.Fallbacks = attempts[1:]
+	return nil
+}
+
+// newTLSConfig returns the client TLS configuration for one host, or nil
+// when the mode disables TLS or the host is a unix socket directory.
+//
+// require only encrypts, unless a root certificate is given, in which case
+// it verifies the chain like verify-ca (as libpq does). verify-ca checks
+// that the server certificate is signed by the root CA; verify-full also
+// checks that it was issued for the host, or for DB_SSLSERVERNAME when the
+// host is reached under another name (a tunnel or an IP address).
+func newTLSConfig(settings config.TLS, host string) (*tls.Config, error) {
+	if settings.Mode == "disable" || strings.HasPrefix(host, "/") {
+		return nil, nil
+	}
+	serverName := settings.ServerName
+	if serverName == "" {
+		serverName = host
+	}
+	c := &tls.Config{ServerName: serverName}
+
+	var roots *x509.CertPool // nil verifies against the system trust store
+	if settings.RootCert != "" && settings.RootCert != "system" {
+		pem, err := os.ReadFile(settings.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("reading DB_SSLROOTCERT: %w", err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("DB_SSLROOTCERT %s contains no PEM certificates", settings.RootCert)
+		}
+	}
+	if settings.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(settings.Cert, settings.Key)
+		if err != nil {
+			return nil, fmt.Errorf("loading DB_SSLCERT/DB_SSLKEY: %w", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	switch {
+	case settings.Mode == "verify-full":
+		c.RootCAs = roots
+	case settings.Mode == "verify-ca" || settings.RootCert != "":
+		// The standard verification always checks the host name, so it is
+		// switched off and the chain verified by hand
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server sent no certificate")
+			}
+			opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range cs.PeerCertificates[1:] {
+				opts.Intermediates.AddCert(cert)
+			}
+			_, err := cs.PeerCertificates[0].Verify(opts)
+			return err
+		}
+	default:
+		c.InsecureSkipVerify = true
+	}
+	return c, nil
+}
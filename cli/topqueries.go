@@ -0,0 +1,180 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+)
+
+// topQuery is one normalized statement of pg_stat_statements, with its
+// totals since the statistics were last reset.
+type topQuery struct {
+	Query   string  `db:"query" json:"query"`
+	Calls   int64   `db:"calls" json:"calls"`
+	TotalMS float64 `db:"total_ms" json:"total_ms"`
+	MeanMS  float64 `db:"mean_ms" json:"mean_ms"`
+	Rows    int64   `db:"rows" json:"rows"`
+	// Percent is the share of the execution time of every statement of
+	// the database.
+	Percent float64 `db:"percent" json:"percent"`
+}
+
+// topQueriesOrder maps --sort to the column the statements are ranked by.
+var topQueriesOrder = map[string]string{
+	"total": "total_ms",
+	"mean":  "mean_ms",
+	"calls": "calls",
+}
+
+// topQueriesSQL reads the statements of the current database from the
+// pg_stat_statements view in schema %[1]s, ranked by %[4]s. PostgreSQL 13
+// renamed total_time and mean_time, which %[2]s and %[3]s name.
+const topQueriesSQL = `
+SELECT * FROM (
+	SELECT
+		s.query,
+		s.calls,
+		s.%[2]s AS total_ms,
+		s.%[3]s AS mean_ms,
+		s.rows,
+		coalesce(100 * s.%[2]s / nullif(sum(s.%[2]s) OVER (), 0), 0) AS percent
+	FROM %[1]s.pg_stat_statements s
+	WHERE s.dbid = (SELECT oid FROM pg_database WHERE datname = current_database())
+) q
+ORDER BY %[4]s DESC
+LIMIT $1`
+
+// newTopQueriesCmd builds the top-queries command.
+func newTopQueriesCmd(a *app) *cobra.Command {
+	var limit int
+	var sort string
+	var enable, reset bool
+	cmd := &cobra.Command{
+		Use:   "top-queries",
+		Short: "Show the statements that cost the database the most time",
+		Long: `Read pg_stat_statements and print the statements run on the configured
+database that took the most time in total (--sort total), per call (mean)
+or ran most often (calls), since the statistics were last reset. The
+statements are normalized by the server, with constants replaced by $1,
+$2, ..., so each line covers every run of one statement.
+
+pg_stat_statements must be in shared_preload_libraries, which takes a
+server restart, and the extension created in the database. --enable runs
+CREATE EXTENSION IF NOT EXISTS pg_stat_statements first, which needs the
+CREATE privilege on the database. --reset clears the statistics after
+printing them, so the next run covers only what happened in between; it
+needs a superuser or the pg_stat_statements_reset function granted.
+Needs PostgreSQL.`,
+		Example: `  go run ./cmd/go-postgres top-queries --enable
+  go run ./cmd/go-postgres top-queries --sort mean --limit 5
+  go run ./cmd/go-postgres top-queries --reset --output json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			order, ok := topQueriesOrder[sort]
+			if !ok {
+				return fmt.Errorf("unknown --sort %q; use total, mean or calls", sort)
+			}
+			if limit < 1 {
+				return errors.New("--limit must be at least 1")
+			}
+			if a.isCockroach() {
+				return fmt.Errorf("top-queries reads pg_stat_statements, which DB_DRIVER=%s does not have; see crdb_internal.statement_statistics", a.cfg().Database.Driver)
+			}
+			return a.runTopQueries(cmd.Context(), order, limit, enable, reset)
+		},
+	}
+	cmd.Flags().IntVar(&limit, "limit", 10, "how many statements to print")
+	cmd.Flags().StringVar(&sort, "sort", "total", "rank by total time, mean time per call, or calls")
+	cmd.Flags().BoolVar(&enable, "enable", false, "create the pg_stat_statements extension when it is missing")
+	cmd.Flags().BoolVar(&reset, "reset", false, "clear the statistics after printing them")
+	return cmd
+}
+
+// runTopQueries implements the top-queries command.
+func (a *app) runTopQueries(ctx context.Context, order string, limit int, enable, reset bool) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	if enable {
+		if _, err := pool.Exec(ctx, "CREATE EXTENSION IF NOT EXISTS pg_stat_statements"); err != nil {
+			return fmt.Errorf("creating the pg_stat_statements extension: %w", err)
+		}
+	}
+	schema, err := statStatementsSchema(ctx, pool)
+	if err != nil {
+		return err
+	}
+	var newNames bool
+	if err := pool.QueryRow(ctx, "SELECT current_setting('server_version_num')::int >= 130000").Scan(&newNames); err != nil {
+		return err
+	}
+	total, mean := "total_time", "mean_time"
+	if newNames {
+		total, mean = "total_exec_time", "mean_exec_time"
+	}
+
+	queries, err := db.Select[topQuery](ctx, pool, fmt.Sprintf(topQueriesSQL, schema, total, mean, order), limit)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "55000" {
+		return errors.New("pg_stat_statements is not loaded; add it to shared_preload_libraries in postgresql.conf and restart the server")
+	}
+	if err != nil {
+		return fmt.Errorf("reading pg_stat_statements: %w", err)
+	}
+	if err := a.printTopQueries(queries); err != nil {
+		return err
+	}
+	if reset {
+		if _, err := pool.Exec(ctx, "SELECT "+schema+".pg_stat_statements_reset()"); err != nil {
+			return fmt.Errorf("resetting pg_stat_statements: %w", err)
+		}
+	}
+	return nil
+}
+
+// statStatementsSchema returns the quoted schema pg_stat_statements is
+// installed in, which need not be on the search_path.
+func statStatementsSchema(ctx context.Context, pool *pgxpool.Pool) (string, error) {
+	var schema string
+	err := pool.QueryRow(ctx, "SELECT extnamespace::regnamespace::text FROM pg_extension WHERE extname = 'pg_stat_statements'").Scan(&schema)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", errors.New("the pg_stat_statements extension is not installed in this database; run again with --enable")
+	}
+	// regnamespace quotes the name where needed
+	return schema, err
+}
+
+// printTopQueries writes queries as a table, or as a document with
+// --output json or yaml. Statements are printed on one line, cut at 80
+// characters in the table.
+func (a *app) printTopQueries(queries []topQuery) error {
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, map[string]any{"queries": queries})
+	}
+	if len(queries) == 0 {
+		fmt.Println("No statements recorded yet.")
+		return nil
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CALLS\tTOTAL MS\tMEAN MS\tROWS\t% TIME\tQUERY")
+	for _, q := range queries {
+		query := strings.Join(strings.Fields(q.Query), " ")
+		if r := []rune(query); len(r) > 80 {
+			query = string(r[:77]) + "..."
+		}
+		fmt.Fprintf(tw, "%d\t%.1f\t%.2f\t%d\t%.1f\t%s\n", q.Calls, q.TotalMS, q.MeanMS, q.Rows, q.Percent, query)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/server"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.40.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the program's spans. It goes through the global provider,
+// which is a no-op until setupTracing installs an exporting one.
+var tracer = otel.Tracer("github.com/hozana-dusabimana")
+
+// setupTracing exports spans over OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT
+// (e.g. http://localhost:4318). Without an endpoint tracing stays disabled
+// and costs next to nothing. The returned function flushes buffered spans
+// and must be called before the process exits.
+func setupTracing(ctx context.Context, c config.App) (shutdown func(context.Context) error, err error) {
+	if c.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	// Like the OpenTelemetry SDKs, treat the endpoint as a base URL
+	endpoint := strings.TrimRight(c.OTLPEndpoint, "/") + "/v1/traces"
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(c.ServiceName), semconv.ServiceVersion(version)),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return provider.Shutdown, nil
+}
+
+// endSpan records err on span, if any, and ends it. The message is
+// redacted first: a connection error can quote the connection string, and
+// spans are shipped to a collector.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		msg := config.Redact(err.Error())
+		span.RecordError(errors.New(msg))
+		span.SetStatus(codes.Error, msg)
+	}
+	span.End()
+}
+
+// dbTracer turns pgx and pgxpool events into spans: one per connection
+// attempt, pool acquire, query and COPY. Query spans carry the SQL text
+// (or prepared statement name), the operation and the rows affected.
+type dbTracer struct{}
+
+var (
+	_ pgx.QueryTracer       = dbTracer{}
+	_ pgx.ConnectTracer     = dbTracer{}
+	_ pgx.CopyFromTracer    = dbTracer{}
+	_ pgxpool.AcquireTracer = dbTracer{}
+)
+
+func dbAttributes(cfg *pgx.ConnConfig) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		semconv.DBSystemNamePostgreSQL,
+		semconv.DBNamespace(cfg.Database),
+		semconv.ServerAddress(cfg.Host),
+		semconv.ServerPort(int(cfg.Port)),
+	}
+}
+
+func (dbTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	command := sqlCommand(data.SQL)
+	ctx, span := tracer.Start(ctx, command, trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(dbAttributes(conn.Config())...)
+	span.SetAttributes(semconv.DBOperationName(command), semconv.DBQueryText(data.SQL))
+	if id := server.RequestID(ctx); id != "" {
+		span.SetAttributes(attribute.String("request.id", id))
+	}
+	return ctx
+}
+
+func (dbTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	endSpan(span, data.Err)
+}
+
+func (dbTracer) TraceConnectStart(ctx context.Context, data pgx.TraceConnectStartData) context.Context {
+	ctx, span := tracer.Start(ctx, "connect", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(dbAttributes(data.ConnConfig)...)
+	return ctx
+}
+
+func (dbTracer) TraceConnectEnd(ctx context.Context, data pgx.TraceConnectEndData) {
+	endSpan(trace.SpanFromContext(ctx), data.Err)
+}
+
+func (dbTracer) TraceCopyFromStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceCopyFromStartData) context.Context {
+	ctx, span := tracer.Start(ctx, "COPY", trace.WithSpanKind(trace.SpanKindClient))
+	span.SetAttributes(dbAttributes(conn.Config())...)
+	span.SetAttributes(semconv.DBOperationName("COPY"), semconv.DBCollectionName(data.TableName.Sanitize()))
+	return ctx
+}
+
+func (dbTracer) TraceCopyFromEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceCopyFromEndData) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	endSpan(span, data.Err)
+}
+
+func (dbTracer) TraceAcquireStart(ctx context.Context, _ *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "pool.acquire")
+	return ctx
+}
+
+func (dbTracer) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	endSpan(trace.SpanFromContext(ctx), data.Err)
+}
@@ -0,0 +1,211 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// newUserAddCmd builds user add, which inserts users given as flags or
+// typed in at a prompt.
+func newUserAddCmd(a *app) *cobra.Command {
+	var u users.User
+	var source string
+	var interactive bool
+	cmd := &cobra.Command{
+		Use:   "add",
+		Short: "Add a user, or with --interactive as many as are typed in",
+		Long: `Insert the user given by --username and --email, like insert.
+
+With --interactive the users are read from standard input instead: the
+username, then the email, each checked as it is entered and asked again
+when invalid, then a confirmation before the insert. It goes on to the
+next user until end of input (Ctrl-D). A duplicate or another failed
+insert is reported and the prompt moves on.
+
+When standard input is not a terminal, as in
+  printf 'carol\ncarol@example.com\n' | go run ./cmd/go-postgres user add --interactive
+the lines are taken in pairs without prompts or confirmation, and an
+invalid line stops the command.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interactive {
+				if cmd.Flags().Changed("username") || cmd.Flags().Changed("email") {
+					return errors.New("--interactive reads the users from standard input; drop --username and --email")
+				}
+				return a.runInteractiveAdd(cmd.Context(), os.Stdin, source)
+			}
+			if u.Username == "" || u.Email == "" {
+				return errors.New("--username and --email are required without --interactive")
+			}
+			if err := users.Validate(u); err != nil {
+				return err
+			}
+			u.Source = a.provenance(source)
+			if a.cfg().App.DryRun {
+				a.printInsertSQL(u)
+				return nil
+			}
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				return createUser(ctx, repo, &u)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&u.Username, "username", "", "username of the new user")
+	cmd.Flags().StringVar(&u.Email, "email", "", "email of the new user")
+	cmd.Flags().StringVar(&source, "source", "cli", "provenance label stored with the rows when RECORD_PROVENANCE is enabled")
+	cmd.Flags().BoolVar(&interactive, "interactive", false, "prompt for users on standard input until end of input")
+	return allowDryRun(cmd)
+}
+
+// printInsertSQL prints the insert of u, for --dry-run.
+func (a *app) printInsertSQL(u users.User) {
+	sql, args := users.NewRepository(nil, a.userRepositoryOptions()).InsertStatement(u)
+	fmt.Printf("%s;\n", renderSQL(sql, args))
+}
+
+// createUser inserts u and logs the outcome, as insert does.
+func createUser(ctx context.Context, repo users.Repository, u *users.User) error {
+	switch err := repo.Create(ctx, u); {
+	case errors.Is(err, users.ErrUpdated):
+		slog.Info("user updated", "username", u.Username, "id", u.ID)
+	case err != nil:
+		return fmt.Errorf("failed to insert user %s: %w", u.Username, err)
+	default:
+		slog.Info("user inserted", "username", u.Username, "id", u.ID)
+	}
+	return nil
+}
+
+// userPrompt reads the answers of user add --interactive. On a terminal it
+// prompts on standard error and asks again after an invalid answer;
+// otherwise it reads lines silently and an invalid one is an error.
+type userPrompt struct {
+	in       *bufio.Scanner
+	terminal bool
+	line     int
+}
+
+// ask returns the next answer to question that check accepts, with
+// surrounding spaces removed. At end of input it returns io.EOF.
+func (p *userPrompt) ask(question string, check func(string) error) (string, error) {
+	for {
+		if p.terminal {
+			fmt.Fprint(os.Stderr, question)
+		}
+		if !p.in.Scan() {
+			if p.terminal {
+				fmt.Fprintln(os.Stderr)
+			}
+			if err := p.in.Err(); err != nil {
+				return "", err
+			}
+			return "", io.EOF
+		}
+		p.line++
+		answer := strings.TrimSpace(p.in.Text())
+		if check == nil {
+			return answer, nil
+		}
+		err := check(answer)
+		if err == nil {
+			return answer, nil
+		}
+		if !p.terminal {
+			return "", fmt.Errorf("line %d: %w", p.line, err)
+		}
+		fmt.Fprintf(os.Stderr, "  %v\n", err)
+	}
+}
+
+// confirm asks whether to go ahead, yes being the default. Without a
+// terminal there is no one to ask, and the answer is yes.
+func (p *userPrompt) confirm(question string) (bool, error) {
+	if !p.terminal {
+		return true, nil
+	}
+	answer, err := p.ask(question+" [Y/n] ", nil)
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "", "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// runInteractiveAdd implements user add --interactive, reading from in.
+func (a *app) runInteractiveAdd(ctx context.Context, in *os.File, source string) error {
+	p := &userPrompt{in: bufio.NewScanner(in), terminal: term.IsTerminal(int(in.Fd()))}
+	var result seedResult
+	add := func(ctx context.Context, repo users.Repository) error {
+		if p.terminal {
+			fmt.Fprintln(os.Stderr, "Enter the users to add; end with Ctrl-D.")
+		}
+		for {
+			username, err := p.ask("Username: ", users.ValidateUsername)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			email, err := p.ask("Email: ", users.ValidateEmail)
+			if errors.Is(err, io.EOF) {
+				slog.Warn("input ended before the email; user not added", "username", username)
+				break
+			}
+			if err != nil {
+				return err
+			}
+			ok, err := p.confirm(fmt.Sprintf("Add %s <%s>?", username, email))
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			u := users.User{Username: username, Email: email, Source: a.provenance(source)}
+			if !ok {
+				result.skip(u, "not confirmed")
+				continue
+			}
+			if a.cfg().App.DryRun {
+				a.printInsertSQL(u)
+				continue
+			}
+			switch err := repo.Create(ctx, &u); {
+			case errors.Is(err, users.ErrUpdated):
+				result.Updated++
+				slog.Info("user updated", "username", u.Username, "id", u.ID)
+			case errors.Is(err, users.ErrDuplicate):
+				result.skip(u, err)
+			case err != nil:
+				result.fail(u, err)
+			default:
+				result.Inserted++
+				slog.Info("user inserted", "username", u.Username, "id", u.ID)
+			}
+		}
+		return nil
+	}
+
+	if a.cfg().App.DryRun {
+		return add(ctx, nil)
+	}
+	if err := a.withUserRepository(ctx, add); err != nil {
+		return err
+	}
+	a.printSeedResult(os.Stdout, result)
+	return nil
+}
@@ -0,0 +1,974 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/server"
+	"github.com/hozana-dusabimana/users"
+	"github.com/spf13/cobra"
+)
+
+// newUserRepository returns the users repository configured by a.cfg,
+// instrumented with the insert counters exported on /metrics, guarded by
+// the circuit breaker and held to MAX_WRITES_PER_SEC. db may be a pool, a
+// single connection or a transaction.
+func (a *app) newUserRepository(db users.Querier) users.Repository {
+	return a.guardUserRepository(metricsRepository{a.pgxUserRepository(db)})
+}
+
+// newRoutedUserRepository is newUserRepository with reads sent to
+// replicas, when there are any, and calls that lost their connection run
+// again on a fresh one of pool. The breaker sits in front of the routing
+// and the retry, so it only sees an outage when the primary fails too and
+// a reconnect did not help, and the cache in front of the breaker, so
+// cached users are served while it is open. With ORM=gorm the repository
+// underneath is GORM's; see poolUserRepository.
+func (a *app) newRoutedUserRepository(pool resettablePool, replicas *replicaSet) (users.Repository, error) {
+	repo, err := a.poolUserRepository(pool)
+	if err != nil {
+		return nil, err
+	}
+	return a.guardUserRepository(retryOnReconnect(routeReads(metricsRepository{repo}, replicas), pool)), nil
+}
+
+// guardUserRepository puts the breaker, the cache, the write limit and,
+// with READ_ONLY, the refusal of writes in front of repo.
+func (a *app) guardUserRepository(repo users.Repository) users.Repository {
+	return a.rejectWrites(a.limitWrites(a.cacheReads(a.guardWithBreaker(repo))))
+}
+
+// userRepositoryOptions returns the repository options set in a.cfg().
+func (a *app) userRepositoryOptions() users.Options {
+	return users.Options{
+		Schema:             a.dbSchema(),
+		PrecheckDuplicates: a.cfg().App.PrecheckDuplicates,
+		OnConflict:         users.ConflictStrategy(a.cfg().App.OnConflict),
+		OnEmailConflict:    users.ConflictStrategy(a.cfg().App.OnEmailConflict),
+		CockroachDB:        a.isCockroach(),
+		BcryptCost:         a.cfg().App.BcryptCost,
+	}
+}
+
+// Values accepted by DEDUP_KEEP.
+const (
+	dedupKeepFirst = "first"
+	dedupKeepLast  = "last"
+)
+
+// dedupeUsers removes records whose username already appears elsewhere in
+// the slice, so known duplicates never reach the database.
+//
+// keep selects which occurrence survives: "first" (the default) or "last".
+// The surviving records keep their original relative order; the dropped
+// records are returned separately so the caller can report them.
+func dedupeUsers(records []users.User, keep string) (kept, dropped []users.User, err error) {
+	winner := make(map[string]int, len(records))
+	for i, u := range records {
+		_, seen := winner[u.Username]
+		switch keep {
+		case "", dedupKeepFirst:
+			if !seen {
+				winner[u.Username] = i
+			}
+		case dedupKeepLast:
+			winner[u.Username] = i
+		default:
+			return nil, nil, fmt.Errorf("invalid DEDUP_KEEP %q: must be %q or %q", keep, dedupKeepFirst, dedupKeepLast)
+		}
+	}
+	for i, u := range records {
+		if winner[u.Username] == i {
+			kept = append(kept, u)
+		} else {
+			dropped = append(dropped, u)
+		}
+	}
+	return kept, dropped, nil
+}
+
+// provenance returns the value to store in the source column: the label
+// when RECORD_PROVENANCE is enabled and NULL otherwise.
+func (a *app) provenance(label string) *string {
+	if !a.cfg().App.RecordProvenance || label == "" {
+		return nil
+	}
+	return &label
+}
+
+// seedResult counts what happened to each record passed to seedUsers. Its
+// String form, e.g. "2 inserted, 0 updated, 1 skipped, 0 invalid, 0 failed",
+// is the result printed by the quickstart and seed.
+type seedResult struct {
+	Inserted int `json:"inserted"`
+	Updated  int `json:"updated"` // existing users overwritten with ON_CONFLICT=upsert
+	Skipped  int `json:"skipped"` // duplicates, in the input or already in the table
+	Invalid  int `json:"invalid"` // rejected by users.Validate
+	Failed   int `json:"failed"`  // database errors
+	// Issues names the users counted as skipped, invalid or failed, with
+	// the reason. Users skipped by bulkSeedUsers are counted only.
+	Issues []seedIssue `json:"issues"`
+}
+
+// seedIssue is a user seedUsers did not insert, and why.
+type seedIssue struct {
+	Username string `json:"username"`
+	Outcome  string `json:"outcome"` // skipped, invalid or failed
+	Reason   string `json:"reason"`
+}
+
+// skip counts u as skipped for reason.
+func (r *seedResult) skip(u users.User, reason any) {
+	slog.Info("user skipped", "username", u.Username, "reason", reason)
+	r.Skipped++
+	r.Issues = append(r.Issues, seedIssue{Username: u.Username, Outcome: "skipped", Reason: fmt.Sprint(reason)})
+}
+
+// reject counts u as invalid because of err.
+func (r *seedResult) reject(u users.User, err error) {
+	slog.Warn("user skipped", "username", u.Username, "reason", err)
+	r.Invalid++
+	r.Issues = append(r.Issues, seedIssue{Username: u.Username, Outcome: "invalid", Reason: err.Error()})
+}
+
+// fail counts u as failed because of err.
+func (r *seedResult) fail(u users.User, err error) {
+	slog.Error("user insert failed", "username", u.Username, "err", err)
+	r.Failed++
+	r.Issues = append(r.Issues, seedIssue{Username: u.Username, Outcome: "failed", Reason: err.Error()})
+}
+
+func (r seedResult) String() string {
+	return fmt.Sprintf("%d inserted, %d updated, %d skipped, %d invalid, %d failed", r.Inserted, r.Updated, r.Skipped, r.Invalid, r.Failed)
+}
+
+// add accumulates the counts of o, e.g. of one batch of a larger seed.
+func (r *seedResult) add(o seedResult) {
+	r.Inserted += o.Inserted
+	r.Updated += o.Updated
+	r.Skipped += o.Skipped
+	r.Invalid += o.Invalid
+	r.Failed += o.Failed
+	r.Issues = append(r.Issues, o.Issues...)
+}
+
+// maxPrintedIssues bounds the issues printSeedResult lists, so a seed that
+// skips most of a large file still ends with a readable summary.
+const maxPrintedIssues = 20
+
+// printSeedResult writes the issues of r as an aligned table, then the
+// counts. With --output json or yaml it writes r whole, every issue
+// included.
+func (a *app) printSeedResult(w io.Writer, r seedResult) {
+	if a.structuredOutput() {
+		if r.Issues == nil {
+			r.Issues = []seedIssue{}
+		}
+		a.writeStructured(w, r)
+		return
+	}
+	a.printSeedIssues(w, r.Issues)
+	fmt.Fprintln(w, r)
+}
+
+// printSeedIssues writes up to maxPrintedIssues issues as an aligned table,
+// or all of them with --output json or yaml.
+func (a *app) printSeedIssues(w io.Writer, issues []seedIssue) {
+	if a.structuredOutput() {
+		if issues == nil {
+			issues = []seedIssue{}
+		}
+		a.writeStructured(w, struct {
+			Issues []seedIssue `json:"issues"`
+		}{issues})
+		return
+	}
+	if len(issues) == 0 {
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "USERNAME\tOUTCOME\tREASON")
+	for _, issue := range issues[:min(len(issues), maxPrintedIssues)] {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", issue.Username, issue.Outcome, issue.Reason)
+	}
+	tw.Flush()
+	if n := len(issues) - maxPrintedIssues; n > 0 {
+		fmt.Fprintf(w, "... and %d more; see the log for every user\n", n)
+	}
+}
+
+// attrs returns the counts as slog key-value pairs followed by extra.
+func (r seedResult) attrs(extra ...any) []any {
+	return append([]any{"inserted", r.Inserted, "updated", r.Updated, "skipped", r.Skipped, "invalid", r.Invalid, "failed", r.Failed}, extra...)
+}
+
+// seedUsers inserts records in one batch, logging one event per user. When
+// DEDUP_INPUT is enabled, duplicates within the slice are dropped first;
+// invalid records are dropped next, and rows that already exist in the table
+// are skipped or overwritten by the repository, as ON_CONFLICT says for a
+// taken username and ON_CONFLICT_EMAIL for a taken email; see
+// skippedDuplicate for which count as failures. Individual insert failures are
+// logged and counted rather than stopping the seed.
+func (a *app) seedUsers(ctx context.Context, repo users.Repository, records []users.User, batchSource string) (seedResult, error) {
+	var result seedResult
+	valid, err := a.prepareSeed(records, batchSource, &result)
+	if err != nil {
+		return result, err
+	}
+
+	outcomes, err := repo.CreateMany(ctx, valid)
+	for i, u := range valid {
+		switch err := outcomes[i]; {
+		case err == nil:
+			slog.Info("user inserted", "username", u.Username, "id", u.ID)
+			result.Inserted++
+		case errors.Is(err, users.ErrUpdated):
+			slog.Info("user updated", "username", u.Username, "id", u.ID)
+			result.Updated++
+		case a.skippedDuplicate(err):
+			result.skip(u, err)
+		default:
+			result.fail(u, err)
+		}
+	}
+	return result, err
+}
+
+// skippedDuplicate reports whether a seed counts err as a skipped duplicate
+// rather than a failure: any duplicate unless ON_CONFLICT=fail, and an email
+// that ON_CONFLICT_EMAIL skipped whatever ON_CONFLICT says.
+func (a *app) skippedDuplicate(err error) bool {
+	if errors.Is(err, users.ErrDuplicateEmail) && a.cfg().App.OnEmailConflict != string(users.ConflictFail) {
+		return true
+	}
+	return errors.Is(err, users.ErrDuplicate) && a.cfg().App.OnConflict != string(users.ConflictFail)
+}
+
+// bulkSeedUsers loads records with COPY (see users.Repository.BulkCreate).
+// It suits files of tens of thousands of users, at the price of reporting
+// only how many were inserted, updated or skipped rather than which.
+func (a *app) bulkSeedUsers(ctx context.Context, repo users.Repository, records []users.User, batchSource string) (seedResult, error) {
+	var result seedResult
+	valid, err := a.prepareSeed(records, batchSource, &result)
+	if err != nil {
+		return result, err
+	}
+	inserted, updated, err := repo.BulkCreate(ctx, valid)
+	if err != nil {
+		result.Failed = len(valid)
+		return result, err
+	}
+	result.Inserted = int(inserted)
+	result.Updated = int(updated)
+	result.Skipped += len(valid) - int(inserted) - int(updated)
+	return result, nil
+}
+
+// upsertSeedUsers upserts records in one statement whatever ON_CONFLICT
+// says (see users.Repository.UpsertMany). Like bulkSeedUsers it reports
+// counts only.
+func (a *app) upsertSeedUsers(ctx context.Context, repo users.Repository, records []users.User, batchSource string) (seedResult, error) {
+	var result seedResult
+	valid, err := a.prepareSeed(records, batchSource, &result)
+	if err != nil {
+		return result, err
+	}
+	inserted, updated, err := repo.UpsertMany(ctx, valid)
+	if err != nil {
+		result.Failed = len(valid)
+		return result, err
+	}
+	result.Inserted = int(inserted)
+	result.Updated = int(updated)
+	result.Skipped += len(valid) - int(inserted) - int(updated)
+	return result, nil
+}
+
+// prepareSeed drops what should not reach the database and labels the
+// rest with batchSource: duplicates within records when DEDUP_INPUT is
+// enabled, then records failing users.Validate. Both are counted in result.
+func (a *app) prepareSeed(records []users.User, batchSource string, result *seedResult) ([]users.User, error) {
+	// Optionally drop intra-slice duplicates before they reach the database
+	// The ON CONFLICT clause in Create stays in place for rows that already exist in the table
+	if a.cfg().App.DedupInput {
+		kept, dropped, err := dedupeUsers(records, a.cfg().App.DedupKeep)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range dropped {
+			result.skip(u, "duplicate in input")
+		}
+		records = kept
+	}
+
+	valid := make([]users.User, 0, len(records))
+	for _, u := range records {
+		if err := users.Validate(u); err != nil {
+			result.reject(u, err)
+			continue
+		}
+		u.Source = a.provenance(batchSource)
+		valid = append(valid, u)
+	}
+	return valid, nil
+}
+
+// printUsers writes records as an aligned table.
+func (a *app) printUsers(w io.Writer, records []users.User) {
+	tw := newUserTable(w)
+	for _, u := range records {
+		a.printUserRow(tw, u)
+	}
+	tw.Flush()
+}
+
+// streamBlock is how many rows streamUsers buffers before writing them.
+const streamBlock = 1000
+
+// streamUsers writes the users scan passes to its callback in the table
+// layout of printUsers, without holding them all: the table is flushed
+// every streamBlock rows, so columns are aligned per block rather than
+// across the whole output. It returns how many users were written.
+func (a *app) streamUsers(w io.Writer, scan func(fn func(users.User) error) error) (int, error) {
+	tw := newUserTable(w)
+	n := 0
+	err := scan(func(u users.User) error {
+		a.printUserRow(tw, u)
+		if n++; n%streamBlock == 0 {
+			return tw.Flush()
+		}
+		return nil
+	})
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return n, err
+}
+
+// newUserTable returns a tabwriter for a user table, header written.
+func newUserTable(w io.Writer) *tabwriter.Writer {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tCREATED AT")
+	return tw
+}
+
+// printUserRow writes one row of a user table; a soft-deleted user gets a
+// trailing cell saying when.
+func (a *app) printUserRow(tw *tabwriter.Writer, u users.User) {
+	fmt.Fprintf(tw, "%s\t%s\t%s\t%s", u.ID, u.Username, u.Email, a.displayTime(u.CreatedAt).Format(displayTimeLayout))
+	if u.DeletedAt != nil {
+		fmt.Fprintf(tw, "\tdeleted %s", a.displayTime(*u.DeletedAt).Format(displayTimeLayout))
+	}
+	fmt.Fprintln(tw)
+}
+
+// newSeedCmd builds the seed command, which inserts users from a file, the
+// sample users or generated ones without the rest of the quickstart.
+func newSeedCmd(a *app) *cobra.Command {
+	var opts quickstartOptions
+	var file, deadLetter string
+	var bulk, upsert bool
+	var fake, batchSize, workers int
+	cmd := &cobra.Command{
+		Use:   "seed",
+		Short: "Insert users from a JSON/YAML/CSV --file, the sample users, or --generate N fake ones",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if fake > 0 && a.cfg().App.DryRun {
+				source := opts.Source
+				if source == "" {
+					source = fmt.Sprintf("fake:seed=%d", a.fakeSeed())
+				}
+				fmt.Println("-- --fake loads these rows with COPY in batches; shown as the inserts of a plain seed")
+				return a.printSeedSQL(os.Stdout, newFakeUsers(newFaker(a.fakeSeed())).next(fake), source)
+			}
+			if fake > 0 {
+				return a.runFakeSeed(cmd.Context(), fake, batchSize, opts.Source)
+			}
+			records, batchSource := a.seedInput(opts)
+			if file != "" {
+				var err error
+				if records, err = loadSeedFile(file); err != nil {
+					return err
+				}
+				batchSource = "file:" + filepath.Base(file)
+				if opts.Source != "" {
+					batchSource = opts.Source
+				}
+			}
+
+			if a.cfg().App.DryRun {
+				switch {
+				case bulk:
+					fmt.Println("-- --bulk loads these rows with COPY and one INSERT ... SELECT; shown as the inserts of a plain seed")
+				case upsert:
+					fmt.Println("-- --upsert sends these rows as arrays in one INSERT ... SELECT FROM unnest(...) ON CONFLICT DO UPDATE; shown as the inserts of a plain seed")
+				case workers > 1:
+					fmt.Printf("-- --workers sends these inserts in batches of %d over %d connections\n", batchSize, workers)
+				}
+				return a.printSeedSQL(os.Stdout, records, batchSource)
+			}
+			if workers > 1 {
+				if deadLetter == "" {
+					deadLetter = "seed.dead-letter.csv"
+					if file != "" {
+						deadLetter = strings.TrimSuffix(file, filepath.Ext(file)) + ".dead-letter.csv"
+					}
+				}
+				return a.runConcurrentSeed(cmd.Context(), records, batchSource, workers, batchSize, deadLetter)
+			}
+			seed := a.seedUsers
+			switch {
+			case bulk:
+				seed = a.bulkSeedUsers
+			case upsert:
+				seed = a.upsertSeedUsers
+			}
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				result, err := seed(ctx, repo, records, batchSource)
+				if err != nil {
+					return err
+				}
+				slog.Info("seed complete", result.attrs("source", batchSource)...)
+				a.printSeedResult(os.Stdout, result)
+				return nil
+			})
+		},
+	}
+	cmd.Flags().StringVar(&file, "file", "", "read users from this .json, .yaml, .yml or .csv file")
+	cmd.Flags().IntVar(&opts.Generate, "generate", 0, "insert N generated fake users instead of the sample data (seed with FAKE_SEED)")
+	cmd.Flags().StringVar(&opts.Source, "source", "", "provenance label stored with each row when RECORD_PROVENANCE is enabled")
+	cmd.Flags().BoolVar(&bulk, "bulk", false, "load with COPY through a staging table; much faster for large files, reports counts only")
+	cmd.Flags().BoolVar(&upsert, "upsert", false, "insert new users and overwrite the email of existing ones in one statement, whatever ON_CONFLICT says; reports counts only")
+	cmd.Flags().IntVar(&fake, "fake", 0, "load N generated fake users with COPY, in batches, for benchmarks and demos (seed with FAKE_SEED)")
+	cmd.Flags().IntVar(&batchSize, "batch-size", 5000, "users per batch with --fake or --workers")
+	cmd.Flags().IntVar(&workers, "workers", 1, "insert with this many concurrent workers, each on its own connection; batches commit separately")
+	cmd.Flags().StringVar(&deadLetter, "dead-letter", "", "with --workers, CSV file to write the users not inserted to when the seed stops (default <file>.dead-letter.csv, or seed.dead-letter.csv)")
+	cmd.MarkFlagsMutuallyExclusive("file", "generate", "fake")
+	cmd.MarkFlagsMutuallyExclusive("workers", "bulk", "upsert")
+	cmd.MarkFlagsMutuallyExclusive("workers", "fake")
+	cmd.MarkFlagsMutuallyExclusive("fake", "upsert")
+	return allowDryRun(cmd)
+}
+
+// runConcurrentSeed implements seed --workers; see seedConcurrently. With
+// DEDUP_INPUT the whole input is deduplicated before it is shared out, as
+// a worker only ever sees its own batches. The users the workers did not
+// insert are written to deadLetter, which seed --file reads back.
+func (a *app) runConcurrentSeed(ctx context.Context, records []users.User, batchSource string, workers, batchSize int, deadLetter string) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("--workers needs PostgreSQL; with DB_DRIVER=%s seed inserts on a single connection", a.cfg().Database.Driver)
+	}
+	if batchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	var deduped seedResult
+	if a.cfg().App.DedupInput {
+		var dropped []users.User
+		var err error
+		if records, dropped, err = dedupeUsers(records, a.cfg().App.DedupKeep); err != nil {
+			return err
+		}
+		for _, u := range dropped {
+			deduped.skip(u, "duplicate in input")
+		}
+	}
+
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+
+	start := time.Now()
+	prog := a.startProgress(ctx, "seed", "users", int64(len(records)))
+	stats, unsaved, err := a.seedConcurrently(ctx, pool, records, batchSource, workers, batchSize, prog)
+	prog.Finish()
+	if n, dlErr := writeDeadLetter(deadLetter, unsaved); dlErr != nil {
+		err = errors.Join(err, fmt.Errorf("writing the users not inserted to %s: %w", deadLetter, dlErr))
+	} else if n > 0 {
+		slog.Warn("users not inserted written to the dead-letter file; seed it again to insert them", "users", n, "file", deadLetter)
+	}
+	total := totalStats(stats)
+	total.add(deduped)
+	if !a.structuredOutput() {
+		printWorkerStats(os.Stdout, stats)
+	}
+	if err != nil {
+		return fmt.Errorf("seed stopped (%s): %w", total, err)
+	}
+	slog.Info("seed complete", total.attrs("source", batchSource, "workers", len(stats), "elapsed", time.Since(start).Round(time.Millisecond))...)
+	a.printSeedResult(os.Stdout, total)
+	return nil
+}
+
+// runFakeSeed loads n generated users through the bulk path, batchSize at
+// a time: memory stays flat however large n is, each batch commits on its
+// own so progress survives an interrupted run, and no per-user log lines
+// drown the output. Usernames are unique across the whole run; users that
+// already exist in the table, from an earlier run with the same FAKE_SEED
+// for example, count as skipped.
+func (a *app) runFakeSeed(ctx context.Context, n, batchSize int, source string) error {
+	if batchSize < 1 {
+		return fmt.Errorf("--batch-size must be at least 1")
+	}
+	seed := a.fakeSeed()
+	if source == "" {
+		source = fmt.Sprintf("fake:seed=%d", seed)
+	}
+	gen := newFakeUsers(newFaker(seed))
+	return a.withUserRepository(ctx, func(ctx context.Context, repo users.Repository) error {
+		var total seedResult
+		start := time.Now()
+		prog := a.startProgress(ctx, "seed", "users", int64(n))
+		defer prog.Finish()
+		for done := 0; done < n; {
+			batch := gen.next(min(batchSize, n-done))
+			result, err := a.bulkSeedUsers(ctx, repo, batch, source)
+			total.add(result)
+			if err != nil {
+				return fmt.Errorf("fake seed stopped after %d of %d users (%s): %w", done, n, total, err)
+			}
+			done += len(batch)
+			prog.Add(int64(len(batch)))
+		}
+		prog.Finish()
+		elapsed := time.Since(start)
+		slog.Info("seed complete", total.attrs("source", source, "elapsed", elapsed.Round(time.Millisecond),
+			"users_per_second", int(float64(n)/elapsed.Seconds()))...)
+		a.printSeedResult(os.Stdout, total)
+		return nil
+	})
+}
+
+// newInsertCmd builds the insert command, which adds a single user.
+func newInsertCmd(a *app) *cobra.Command {
+	var u users.User
+	var source string
+	var viaFunction bool
+	cmd := &cobra.Command{
+		Use:   "insert",
+		Short: "Insert one user",
+		Long: `Insert one user, following ON_CONFLICT and ON_CONFLICT_EMAIL for a taken
+username or email.
+
+With --via-function the insert is made by the register_user function of
+migration 0018 instead, which refuses any taken username or email, an
+email taken in another case included. Needs PostgreSQL.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := users.Validate(u); err != nil {
+				return err
+			}
+			u.Source = a.provenance(source)
+			if viaFunction {
+				return a.registerViaFunction(cmd.Context(), &u)
+			}
+			if a.cfg().App.DryRun {
+				a.printInsertSQL(u)
+				return nil
+			}
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				return createUser(ctx, repo, &u)
+			})
+		},
+	}
+	cmd.Flags().StringVar(&u.Username, "username", "", "username of the new user")
+	cmd.Flags().StringVar(&u.Email, "email", "", "email of the new user")
+	cmd.Flags().StringVar(&source, "source", "cli", "provenance label stored with the row when RECORD_PROVENANCE is enabled")
+	cmd.Flags().BoolVar(&viaFunction, "via-function", false, "insert through the register_user stored function (PostgreSQL)")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("email")
+	return allowDryRun(cmd)
+}
+
+// registerViaFunction implements insert --via-function.
+func (a *app) registerViaFunction(ctx context.Context, u *users.User) error {
+	if a.usesSQLDB() || a.isCockroach() {
+		return fmt.Errorf("the register_user function is created by a PostgreSQL-only migration; there is none with DB_DRIVER=%s", a.cfg().Database.Driver)
+	}
+	if a.cfg().App.DryRun {
+		sql, args := users.NewRepository(nil, a.userRepositoryOptions()).RegisterUserStatement(*u)
+		fmt.Printf("%s;\n", renderSQL(sql, args))
+		return nil
+	}
+	return a.withPostgresRepository(ctx, func(ctx context.Context, repo *users.PostgresRepository) error {
+		if err := repo.RegisterUser(ctx, u); err != nil {
+			return fmt.Errorf("failed to register user %s: %w", u.Username, err)
+		}
+		slog.Info("user inserted", "username", u.Username, "id", u.ID, "via", "register_user")
+		return nil
+	})
+}
+
+// newListCmd builds the list command, which prints one page of users
+// followed by the total so callers can work out how many pages exist, and
+// the cursor to the next page when there is one. Without --limit, --offset
+// or --after every user is printed, streamed rather than loaded at once.
+func newListCmd(a *app) *cobra.Command {
+	var page users.Page
+	var fetchSize int
+	var prefix, domain, after, before string
+	var includeDeleted bool
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "Print users, oldest first",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("fetch-size") {
+				return a.runCursorList(cmd.Context(), fetchSize)
+			}
+			filter, err := users.ParseFilter(prefix, domain, after, before)
+			if err != nil {
+				return err
+			}
+			filter.IncludeDeleted = includeDeleted
+			page.Filter = filter
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				if page == (users.Page{}) {
+					return a.listAllUsers(ctx, repo, repo.ForEachUser)
+				}
+				records, next, err := repo.List(ctx, page)
+				if err != nil {
+					return fmt.Errorf("listing users failed: %w", err)
+				}
+				total, err := repo.Count(ctx, page.Filter)
+				if err != nil {
+					return fmt.Errorf("counting users failed: %w", err)
+				}
+				if a.structuredOutput() {
+					if records == nil {
+						records = []users.User{}
+					}
+					return a.writeStructured(os.Stdout, server.UserList{Users: records, Total: total, NextCursor: next})
+				}
+				a.printUsers(os.Stdout, records)
+				fmt.Println(pageSummary(page, len(records), total))
+				if next != "" {
+					fmt.Printf("Next page: --after %s\n", next)
+				}
+				return nil
+			})
+		},
+	}
+	cmd.Flags().IntVar(&page.Limit, "limit", 0, "print at most this many users (0 for all)")
+	cmd.Flags().IntVar(&page.Offset, "offset", 0, "skip this many users first")
+	cmd.Flags().StringVar(&page.After, "after", "", "start after the page that printed this cursor (faster than --offset on large tables)")
+	cmd.Flags().IntVar(&fetchSize, "fetch-size", 0, "print all users through a server-side cursor, fetching this many rows at a time (PostgreSQL only)")
+	cmd.Flags().StringVar(&prefix, "username-prefix", "", "only users whose username starts with this")
+	cmd.Flags().StringVar(&domain, "email-domain", "", "only users whose email is at this domain")
+	cmd.Flags().StringVar(&after, "created-after", "", "only users created after this RFC 3339 time or date")
+	cmd.Flags().StringVar(&before, "created-before", "", "only users created before this RFC 3339 time or date")
+	cmd.Flags().BoolVar(&includeDeleted, "include-deleted", false, "print soft-deleted users as well, marked with when they were deleted")
+	cmd.MarkFlagsMutuallyExclusive("offset", "after")
+	for _, name := range []string{"username-prefix", "email-domain", "created-after", "created-before", "include-deleted"} {
+		cmd.MarkFlagsMutuallyExclusive("fetch-size", name)
+	}
+	cmd.MarkFlagsMutuallyExclusive("fetch-size", "limit")
+	cmd.MarkFlagsMutuallyExclusive("fetch-size", "offset")
+	cmd.MarkFlagsMutuallyExclusive("fetch-size", "after")
+	return cmd
+}
+
+// listAllUsers prints every user as scan yields them, then the total.
+func (a *app) listAllUsers(ctx context.Context, repo users.Repository, scan func(context.Context, func(users.User) error) error) error {
+	if a.structuredOutput() {
+		return a.streamStructuredUsers(ctx, repo, scan)
+	}
+	n, err := a.streamUsers(os.Stdout, func(fn func(users.User) error) error {
+		return scan(ctx, fn)
+	})
+	if err != nil {
+		return fmt.Errorf("listing users failed after %d users: %w", n, err)
+	}
+	total, err := repo.Count(ctx, users.Filter{})
+	if err != nil {
+		return fmt.Errorf("counting users failed: %w", err)
+	}
+	fmt.Println(pageSummary(users.Page{}, n, total))
+	return nil
+}
+
+// streamStructuredUsers is listAllUsers for --output json or yaml: the
+// document of GET /users, written as the users are read.
+func (a *app) streamStructuredUsers(ctx context.Context, repo users.Repository, scan func(context.Context, func(users.User) error) error) error {
+	stream := userStream{w: os.Stdout, yaml: a.cfg().App.Output == "yaml"}
+	if err := scan(ctx, stream.add); err != nil {
+		return fmt.Errorf("listing users failed after %d users: %w", stream.n, err)
+	}
+	total, err := repo.Count(ctx, users.Filter{})
+	if err != nil {
+		return fmt.Errorf("counting users failed: %w", err)
+	}
+	return stream.close(struct {
+		Total int64 `json:"total"`
+	}{total})
+}
+
+// runCursorList implements list --fetch-size, which reads the users
+// through ForEachUserCursor.
+func (a *app) runCursorList(ctx context.Context, fetchSize int) error {
+	if a.usesSQLDB() {
+		return fmt.Errorf("--fetch-size needs PostgreSQL; with DB_DRIVER=%s list streams the rows of a single query", a.cfg().Database.Driver)
+	}
+	return a.withPostgresRepository(ctx, func(ctx context.Context, repo *users.PostgresRepository) error {
+		return a.listAllUsers(ctx, repo, func(ctx context.Context, fn func(users.User) error) error {
+			return repo.ForEachUserCursor(ctx, fetchSize, fn)
+		})
+	})
+}
+
+// pageSummary describes which rows of total a page of n rows covers, e.g.
+// "Showing 11-20 of 42 users (page 2 of 5)". A page reached by cursor does
+// not know its position, so only its size is reported.
+func pageSummary(page users.Page, n int, total int64) string {
+	noun := "users"
+	if page.Filter != (users.Filter{IncludeDeleted: page.Filter.IncludeDeleted}) {
+		noun = "matching users"
+	}
+	if n == 0 {
+		return fmt.Sprintf("Showing 0 of %d %s", total, noun)
+	}
+	if page.After != "" {
+		return fmt.Sprintf("Showing %d of %d %s", n, total, noun)
+	}
+	s := fmt.Sprintf("Showing %d-%d of %d %s", page.Offset+1, page.Offset+n, total, noun)
+	if page.Limit > 0 {
+		pages := (total + int64(page.Limit) - 1) / int64(page.Limit)
+		s += fmt.Sprintf(" (page %d of %d)", page.Offset/page.Limit+1, pages)
+	}
+	return s
+}
+
+// newUpdateEmailCmd builds the update-email command, kept for scripts
+// written before `user update` existed.
+func newUpdateEmailCmd(a *app) *cobra.Command {
+	var username, email, expected string
+	cmd := &cobra.Command{
+		Use:        "update-email",
+		Short:      "Change a user's email, optionally only if it was not modified since",
+		Deprecated: `use "user update" instead`,
+		Args:       cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runUserUpdate(cmd.Context(), userKey{Username: username}, email, 0, expected)
+		},
+	}
+	cmd.Flags().StringVar(&username, "username", "", "user to update")
+	cmd.Flags().StringVar(&email, "email", "", "new email address")
+	cmd.Flags().StringVar(&expected, "expected-updated-at", "", "only update if updated_at still equals this RFC 3339 timestamp")
+	cmd.MarkFlagRequired("username")
+	cmd.MarkFlagRequired("email")
+	return cmd
+}
+
+// userKey identifies one user by id or by username, whichever is set.
+type userKey struct {
+	ID       string
+	Username string
+}
+
+// bindUserKey registers the mutually exclusive --id and --username flags,
+// one of which is required.
+func bindUserKey(cmd *cobra.Command, key *userKey) {
+	cmd.Flags().StringVar(&key.ID, "id", "", "id of the user (an integer, or a UUID with ID_TYPE=uuid)")
+	cmd.Flags().StringVar(&key.Username, "username", "", "username of the user")
+	cmd.MarkFlagsMutuallyExclusive("id", "username")
+	cmd.MarkFlagsOneRequired("id", "username")
+}
+
+// find loads the user identified by key.
+func (key userKey) find(ctx context.Context, repo users.Repository) (*users.User, error) {
+	if key.Username != "" {
+		return repo.GetByUsername(ctx, key.Username)
+	}
+	id, err := users.ParseID(key.ID)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetByID(ctx, id)
+}
+
+func (key userKey) String() string {
+	if key.Username != "" {
+		return key.Username
+	}
+	return "#" + key.ID
+}
+
+// newUserCmd builds the `user` command group for working with one user.
+func newUserCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "user",
+		Short: "Add, get, update, delete or register a single user",
+	}
+
+	var getKey userKey
+	get := &cobra.Command{
+		Use:   "get",
+		Short: "Print one user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				u, err := getKey.find(ctx, repo)
+				if err != nil {
+					return fmt.Errorf("user %s: %w", getKey, err)
+				}
+				a.printUser(os.Stdout, u)
+				return nil
+			})
+		},
+	}
+	bindUserKey(get, &getKey)
+
+	var updateKey userKey
+	var email, expected string
+	var expectedVersion int64
+	update := &cobra.Command{
+		Use:   "update",
+		Short: "Change a user's email, optionally only if it was not modified since",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runUserUpdate(cmd.Context(), updateKey, email, expectedVersion, expected)
+		},
+	}
+	bindUserKey(update, &updateKey)
+	update.Flags().StringVar(&email, "email", "", "new email address")
+	update.Flags().Int64Var(&expectedVersion, "expected-version", 0, "only update if the version still equals this one")
+	update.Flags().StringVar(&expected, "expected-updated-at", "", "only update if updated_at still equals this RFC 3339 timestamp")
+	update.MarkFlagRequired("email")
+
+	var deleteKey userKey
+	del := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete one user",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.withUserRepository(cmd.Context(), func(ctx context.Context, repo users.Repository) error {
+				username := deleteKey.Username
+				if username == "" {
+					u, err := deleteKey.find(ctx, repo)
+					if err != nil {
+						return fmt.Errorf("user %s: %w", deleteKey, err)
+					}
+					username = u.Username
+				}
+				if err := repo.Delete(ctx, username); err != nil {
+					return fmt.Errorf("user %s: %w", deleteKey, err)
+				}
+				slog.Info("user deleted", "username", username, "rows_affected", 1)
+				return nil
+			})
+		},
+	}
+	bindUserKey(del, &deleteKey)
+
+	cmd.AddCommand(newUserAddCmd(a), get, update, del)
+	cmd.AddCommand(newPasswordCmds(a)...)
+	return cmd
+}
+
+// withUserRepository connects (see openUserRepository), runs fn with a
+// repository on the connection and disconnects again.
+func (a *app) withUserRepository(ctx context.Context, fn func(context.Context, users.Repository) error) error {
+	repo, closeRepo, err := a.openUserRepository(ctx)
+	if err != nil {
+		return err
+	}
+	defer closeRepo()
+	return fn(ctx, repo)
+}
+
+// withPostgresRepository connects to PostgreSQL and runs fn with a
+// repository on the pool. It serves the commands built on columns the
+// MySQL and SQLite tables lack, such as profile, so unlike
+// withUserRepository it does not follow DB_DRIVER.
+func (a *app) withPostgresRepository(ctx context.Context, fn func(context.Context, *users.PostgresRepository) error) error {
+	pool, err := a.openPool(ctx)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	return fn(ctx, users.NewRepository(pool, a.userRepositoryOptions()))
+}
+
+// username returns the username key identifies, looking it up by id when
+// only --id was given.
+func (key userKey) username(ctx context.Context, repo users.Repository) (string, error) {
+	if key.Username != "" {
+		return key.Username, nil
+	}
+	u, err := key.find(ctx, repo)
+	if err != nil {
+		return "", fmt.Errorf("user %s: %w", key, err)
+	}
+	return u.Username, nil
+}
+
+// printUser writes every field of u, one per line, or u as a document
+// with --output json or yaml.
+func (a *app) printUser(w io.Writer, u *users.User) {
+	if a.structuredOutput() {
+		a.writeStructured(w, u)
+		return
+	}
+	source := "-"
+	if u.Source != nil {
+		source = *u.Source
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "id:\t%s\n", u.ID)
+	fmt.Fprintf(tw, "username:\t%s\n", u.Username)
+	fmt.Fprintf(tw, "email:\t%s\n", u.Email)
+	fmt.Fprintf(tw, "created_at:\t%s\n", a.displayTime(u.CreatedAt).Format(time.RFC3339Nano))
+	fmt.Fprintf(tw, "updated_at:\t%s\n", a.displayTime(u.UpdatedAt).Format(time.RFC3339Nano))
+	fmt.Fprintf(tw, "version:\t%d\n", u.Version)
+	fmt.Fprintf(tw, "source:\t%s\n", source)
+	if u.DeletedAt != nil {
+		fmt.Fprintf(tw, "deleted_at:\t%s\n", a.displayTime(*u.DeletedAt).Format(time.RFC3339Nano))
+	}
+	tw.Flush()
+}
+
+// runUserUpdate changes the email of the user identified by key. With
+// version or expected set, the change only applies if nobody modified the
+// user since that version or updated_at value was read (optimistic
+// concurrency).
+func (a *app) runUserUpdate(ctx context.Context, key userKey, email string, version int64, expected string) error {
+	if version < 0 {
+		return fmt.Errorf("invalid --expected-version: must be positive")
+	}
+	u := users.User{Username: key.Username, Email: email, Version: version}
+	if expected != "" {
+		t, err := time.Parse(time.RFC3339Nano, expected)
+		if err != nil {
+			return fmt.Errorf("invalid --expected-updated-at: %w", err)
+		}
+		u.UpdatedAt = t
+	}
+	if err := users.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	return a.withUserRepository(ctx, func(ctx context.Context, repo users.Repository) error {
+		if u.Username == "" {
+			found, err := key.find(ctx, repo)
+			if err != nil {
+				return fmt.Errorf("user %s: %w", key, err)
+			}
+			u.Username = found.Username
+		}
+		if err := repo.Update(ctx, &u); err != nil {
+			return fmt.Errorf("failed to update user %s: %w", key, err)
+		}
+		slog.Info("user updated", "username", u.Username, "version", u.Version, "updated_at", u.UpdatedAt.Format(time.RFC3339Nano))
+		return nil
+	})
+}
@@ -0,0 +1,61 @@
+package cli
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+func TestDedupeUsers(t *testing.T) {
+	// Each record is username:email, so the survivor of a duplicate shows
+	records := func(specs ...string) []users.User {
+		var us []users.User
+		for _, s := range specs {
+			name, email, _ := strings.Cut(s, ":")
+			us = append(us, users.User{Username: name, Email: email})
+		}
+		return us
+	}
+	specs := func(us []users.User) []string {
+		var out []string
+		for _, u := range us {
+			out = append(out, u.Username+":"+u.Email)
+		}
+		return out
+	}
+	input := records("alice:1", "bob:2", "alice:3", "carol:4", "bob:5", "alice:6")
+	tests := []struct {
+		name          string
+		records       []users.User
+		keep          string
+		kept, dropped []string
+	}{
+		{"default keeps the first", input, "", []string{"alice:1", "bob:2", "carol:4"}, []string{"alice:3", "bob:5", "alice:6"}},
+		{"first", input, dedupKeepFirst, []string{"alice:1", "bob:2", "carol:4"}, []string{"alice:3", "bob:5", "alice:6"}},
+		{"last", input, dedupKeepLast, []string{"carol:4", "bob:5", "alice:6"}, []string{"alice:1", "bob:2", "alice:3"}},
+		{"no duplicates", records("alice:1", "bob:2"), dedupKeepLast, []string{"alice:1", "bob:2"}, nil},
+		{"same email, other usernames", records("alice:x", "bob:x"), "", []string{"alice:x", "bob:x"}, nil},
+		{"usernames differing in case", records("alice:1", "Alice:2"), "", []string{"alice:1", "Alice:2"}, nil},
+		{"empty", nil, "", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, dropped, err := dedupeUsers(tt.records, tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := specs(kept); !slices.Equal(got, tt.kept) {
+				t.Errorf("kept %q, want %q", got, tt.kept)
+			}
+			if got := specs(dropped); !slices.Equal(got, tt.dropped) {
+				t.Errorf("dropped %q, want %q", got, tt.dropped)
+			}
+		})
+	}
+
+	if _, _, err := dedupeUsers(input, "newest"); err == nil || !strings.Contains(err.Error(), "DEDUP_KEEP") {
+		t.Errorf("DEDUP_KEEP=newest: error = %v", err)
+	}
+}
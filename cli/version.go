@@ -0,0 +1,198 @@
+package cli
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+)
+
+// versionInfo is what the version command reports.
+type versionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Built   string `json:"built,omitempty"`
+	// Modified is true for a build of a checkout with uncommitted changes.
+	Modified bool   `json:"modified"`
+	Go       string `json:"go"`
+	Platform string `json:"platform"`
+	Driver   string `json:"driver"`
+	// DriverModule is the Go module that talks to the database, with its
+	// version.
+	DriverModule  string `json:"driver_module"`
+	ServerVersion string `json:"server_version,omitempty"`
+	// ServerError says why ServerVersion is missing.
+	ServerError string `json:"server_error,omitempty"`
+}
+
+// driverModules are the Go modules behind each DB_DRIVER.
+var driverModules = map[string]string{
+	config.DriverPostgres:  "github.com/jackc/pgx/v5",
+	config.DriverCockroach: "github.com/jackc/pgx/v5",
+	config.DriverMySQL:     "github.com/go-sql-driver/mysql",
+	config.DriverSQLite:    "modernc.org/sqlite",
+}
+
+// newVersionCmd builds the version command.
+func newVersionCmd(a *app) *cobra.Command {
+	var offline bool
+	var timeout time.Duration
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Report the build and the version of the database server",
+		Long: `Print the application version, the git commit and time of the build, the
+Go and driver versions, and the version of the server the configuration
+points at: what to include when reporting a problem. The commit and the
+time come from -ldflags (see cli.version and cli.buildDate) or from the
+build information the Go toolchain embeds.
+
+Asking the server takes one connection, without the retries and the
+database creation of the other commands; when it fails, the error is
+reported in place of the version. --offline skips it. Invalid settings do
+not stop the command.`,
+		Args: cobra.NoArgs,
+		// Replaces the root hook, like doctor, so a broken configuration
+		// still gets a version report
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info := a.readVersionInfo()
+			switch {
+			case offline:
+			case a.cfg().Database.ConnStr == "":
+				info.ServerError = "no connection string is configured"
+			default:
+				info.ServerVersion, info.ServerError = a.serverVersion(cmd.Context(), timeout)
+			}
+			return a.printVersionInfo(info)
+		},
+	}
+	cmd.Flags().BoolVar(&offline, "offline", false, "do not connect to the server")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Second, "how long to wait for the server")
+	return cmd
+}
+
+// readVersionInfo returns the build's part of the report.
+func (a *app) readVersionInfo() versionInfo {
+	info := versionInfo{
+		Version:  version,
+		Commit:   buildCommit(),
+		Built:    buildDate,
+		Modified: buildSetting("vcs.modified") == "true",
+		Go:       runtime.Version(),
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Driver:   a.cfg().Database.Driver,
+	}
+	if info.Built == "" {
+		info.Built = buildSetting("vcs.time")
+	}
+	module := driverModules[info.Driver]
+	info.DriverModule = module + " " + moduleVersion(module)
+	return info
+}
+
+// moduleVersion returns the version of module path the binary was built
+// with, or "unknown".
+func moduleVersion(path string) string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == path {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			return dep.Version
+		}
+	}
+	return "unknown"
+}
+
+// serverVersion connects once and returns the server's version, or the
+// reason it could not.
+func (a *app) serverVersion(ctx context.Context, timeout time.Duration) (v, problem string) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	switch a.cfg().Database.Driver {
+	case config.DriverSQLite:
+		// SQLite is a library, so its version is the one built in; an
+		// in-memory database asks it without creating the file
+		db, err := sql.Open("sqlite", ":memory:")
+		if err != nil {
+			return "", err.Error()
+		}
+		defer db.Close()
+		if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&v); err != nil {
+			return "", err.Error()
+		}
+		return "SQLite " + v, ""
+	case config.DriverMySQL:
+		connector, err := a.mysqlConnector(a.cfg().Database.ConnStr)
+		if err != nil {
+			return "", err.Error()
+		}
+		db := sql.OpenDB(connector)
+		defer db.Close()
+		if err := db.QueryRowContext(ctx, "SELECT VERSION()").Scan(&v); err != nil {
+			return "", err.Error()
+		}
+		return "MySQL " + v, ""
+	}
+	cfg, err := a.parseConnConfig(a.cfg().Database.ConnStr)
+	if err != nil {
+		return "", err.Error()
+	}
+	a.creds.use(a.cfg().Database.Secrets)
+	a.creds.apply(ctx, &cfg.Config)
+	conn, err := pgx.ConnectConfig(ctx, cfg)
+	if err != nil {
+		return "", err.Error()
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+	if err := conn.QueryRow(ctx, "SELECT version()").Scan(&v); err != nil {
+		return "", err.Error()
+	}
+	return v, ""
+}
+
+// printVersionInfo writes info as lines, or as a document with --output
+// json or yaml.
+func (a *app) printVersionInfo(info versionInfo) error {
+	if a.structuredOutput() {
+		return a.writeStructured(os.Stdout, info)
+	}
+	commit := info.Commit
+	if info.Modified {
+		commit += " (modified)"
+	}
+	built := info.Built
+	if built == "" {
+		built = "unknown"
+	}
+	server := info.ServerVersion
+	switch {
+	case info.ServerError != "":
+		server = "unavailable: " + info.ServerError
+	case server == "":
+		server = "not queried (--offline)"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "version:\t%s\n", info.Version)
+	fmt.Fprintf(tw, "commit:\t%s\n", commit)
+	fmt.Fprintf(tw, "built:\t%s\n", built)
+	fmt.Fprintf(tw, "go:\t%s %s\n", info.Go, info.Platform)
+	fmt.Fprintf(tw, "driver:\t%s (%s)\n", info.Driver, info.DriverModule)
+	fmt.Fprintf(tw, "server:\t%s\n", server)
+	return tw.Flush()
+}
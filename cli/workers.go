@@ -0,0 +1,218 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/sync/errgroup"
+)
+
+// workerStats is what one insert worker did.
+type workerStats struct {
+	Worker  int
+	Batches int
+	Busy    time.Duration // time spent inserting, excluding waits for input
+	seedResult
+	unsaved []unsavedUsers
+}
+
+// unsavedUsers are users seedConcurrently did not insert, and why.
+type unsavedUsers struct {
+	Users []users.User
+	Err   error
+}
+
+// errSeedStopped is the reason given for the users still waiting to be
+// sent when the seed stopped.
+var errSeedStopped = errors.New("not sent before the seed stopped")
+
+// seedConcurrently inserts records with workers goroutines. A producer
+// feeds the records into a channel; each worker holds its own pooled
+// connection for the whole run, pulls records from the channel and sends
+// them batchSize at a time through seedUsers. Batches commit
+// independently, so unlike the single-transaction seed a failure leaves
+// the earlier batches in place. Workers whose batches deadlock on each
+// other send them again; see retryAbortedRepository.
+//
+// prog counts the users of each batch sent. The workers run in an errgroup: the first batch-level error (a lost
+// connection) stops the producer and the other workers, and is returned
+// with the stats gathered so far. When ctx is cancelled the producer
+// stops, and the workers get SHUTDOWN_GRACE to insert the batches they
+// hold and those queued in the channel before their context is cancelled
+// too. The users of the batches that failed, those left in the channel
+// and those never sent are returned as unsaved, for the dead-letter file.
+// Per-user failures are counted, as in seedUsers, and do not stop the run.
+func (a *app) seedConcurrently(ctx context.Context, pool *pgxpool.Pool, records []users.User, batchSource string, workers, batchSize int, prog *progress) ([]workerStats, []unsavedUsers, error) {
+	if maxConns := int(pool.Config().MaxConns); workers > maxConns {
+		// A worker keeps its connection until the input runs dry, so the
+		// extra workers would only wait for one to be released
+		slog.Warn("fewer connections than workers; raise DB_MAX_CONNS to use them all", "workers", workers, "max_conns", maxConns)
+		workers = maxConns
+	}
+
+	// The workers outlive ctx by SHUTDOWN_GRACE
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+	grace := a.cfg().App.ShutdownGrace
+	defer context.AfterFunc(ctx, func() { time.AfterFunc(grace, cancelWork) })()
+
+	stats := make([]workerStats, workers)
+	g, work := errgroup.WithContext(work)
+	input := make(chan users.User, batchSize)
+	var unsent []users.User
+	g.Go(func() error {
+		defer close(input)
+		for i, u := range records {
+			select {
+			case input <- u:
+			case <-work.Done():
+				unsent = records[i:]
+				return nil
+			case <-ctx.Done():
+				unsent = records[i:]
+				return nil
+			}
+		}
+		return nil
+	})
+	for w := range stats {
+		stats[w].Worker = w + 1
+		g.Go(func() error {
+			return a.insertWorker(work, pool, input, batchSource, batchSize, &stats[w], prog)
+		})
+	}
+	err := g.Wait()
+
+	var unsaved []unsavedUsers
+	for _, s := range stats {
+		unsaved = append(unsaved, s.unsaved...)
+	}
+	// Workers that stopped early left users in the channel
+	var queued []users.User
+	for u := range input {
+		queued = append(queued, u)
+	}
+	if left := append(queued, unsent...); len(left) > 0 {
+		unsaved = append(unsaved, unsavedUsers{left, errSeedStopped})
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return stats, unsaved, err
+}
+
+// insertWorker is one worker of seedConcurrently. It writes only its own
+// stats, so no locking is needed; a batch that fails is kept in
+// stats.unsaved.
+func (a *app) insertWorker(ctx context.Context, pool *pgxpool.Pool, input <-chan users.User, batchSource string, batchSize int, stats *workerStats, prog *progress) error {
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("worker %d: %w", stats.Worker, err)
+	}
+	defer conn.Release()
+	repo := retryAbortedRepository{a.newUserRepository(conn), a.txRetryPolicy}
+
+	batch := make([]users.User, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		start := time.Now()
+		result, err := a.seedUsers(ctx, repo, batch, batchSource)
+		stats.Busy += time.Since(start)
+		stats.Batches++
+		stats.add(result)
+		prog.Add(int64(len(batch)))
+		if err != nil {
+			err = fmt.Errorf("worker %d: %w", stats.Worker, err)
+			stats.unsaved = append(stats.unsaved, unsavedUsers{slices.Clone(batch), err})
+			return err
+		}
+		batch = batch[:0]
+		return nil
+	}
+	for u := range input {
+		batch = append(batch, u)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// retryAbortedRepository reruns the inserts of a repository that is not
+// inside a transaction when a deadlock or serialization failure aborts
+// them, as concurrent workers inserting into the same indexes can cause,
+// following policy. A batch of CreateMany runs as one implicit
+// transaction, so an aborted one was rolled back whole and is sent again
+// whole; a user inserted on its own is inserted again alone.
+type retryAbortedRepository struct {
+	users.Repository
+	policy func(ctx context.Context) db.RetryPolicy
+}
+
+func (r retryAbortedRepository) CreateMany(ctx context.Context, us []users.User) (results []error, err error) {
+	policy := r.policy(ctx)
+	err = db.Retry(ctx, policy, func() (err error) {
+		results, err = r.Repository.CreateMany(ctx, us)
+		return err
+	})
+	if err != nil {
+		return results, err
+	}
+	for i, err := range results {
+		if db.Retryable(err) {
+			results[i] = db.Retry(ctx, policy, func() error { return r.Repository.Create(ctx, &us[i]) })
+		}
+	}
+	return results, nil
+}
+
+// writeDeadLetter writes the users of unsaved to a CSV file at path, with
+// the reason each one was not inserted as an extra column, and returns how
+// many there were. The file is only created when there are some.
+func writeDeadLetter(path string, unsaved []unsavedUsers) (int, error) {
+	out := &rejectsFile{path: path, header: []string{"username", "email"}, comma: ','}
+	n := 0
+	for _, batch := range unsaved {
+		for _, u := range batch.Users {
+			if err := out.write([]string{u.Username, u.Email}, batch.Err); err != nil {
+				out.close()
+				return n, err
+			}
+			n++
+		}
+	}
+	return n, out.close()
+}
+
+// totalStats sums the results of all workers.
+func totalStats(stats []workerStats) seedResult {
+	var total seedResult
+	for _, s := range stats {
+		total.add(s.seedResult)
+	}
+	return total
+}
+
+// printWorkerStats writes one line per worker as an aligned table.
+func printWorkerStats(w io.Writer, stats []workerStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "WORKER\tBATCHES\tINSERTED\tUPDATED\tSKIPPED\tINVALID\tFAILED\tBUSY\t")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t\n",
+			s.Worker, s.Batches, s.Inserted, s.Updated, s.Skipped, s.Invalid, s.Failed, s.Busy.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"context"
+
+	"github.com/hozana-dusabimana/server"
+)
+
+// runFeed listens on TAIL_CHANNEL and broadcasts each new user to feed
+// until ctx is done. A lost connection is re-established with backoff, and the users
+// inserted meanwhile are broadcast before the live ones, as with tail.
+func (a *app) runFeed(ctx context.Context, feed *server.Feed, pool *livePool) {
+	cursor := tailCursor{emit: func(u tailedUser) { feed.Broadcast(server.FeedUser(u)) }}
+	reconnecting(ctx, "live feed", func(first bool) error {
+		pooled, err := pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		// The LISTEN connection never goes back to the pool
+		conn := pooled.Hijack()
+		defer conn.Close(context.WithoutCancel(ctx))
+		if err := a.startListening(ctx, conn, a.cfg().App.TailChannel, 0, first, &cursor); err != nil {
+			return err
+		}
+		return forwardNotifications(ctx, conn, &cursor)
+	})
+}
@@ -0,0 +1,13 @@
+// Command go-postgres is a PostgreSQL database connection example and a
+// small tool for the users table; see package cli for the commands.
+package main
+
+import (
+	"os"
+
+	"github.com/hozana-dusabimana/cli"
+)
+
+func main() {
+	os.Exit(cli.Run(os.Args[1:]))
+}
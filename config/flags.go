@@ -0,0 +1,84 @@
+package config
+
+import (
+	"io"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// flagKeys are the settings that can be overridden on the command line,
+// each by a flag named after the key in lower kebab case: CONN_STR is
+// --conn-str. DB_PASSWORD, MAINTENANCE_PASSWORD and VAULT_TOKEN are left
+// out on purpose, because other users of the machine can read command lines.
+var flagKeys = []string{
+	"APP_ENV", "Developer", "DRY_RUN", "OUTPUT",
+	"DB_DRIVER", "CONN_STR", "CONN_STR_TEMPLATE", "READ_CONN_STR",
+	"DB_HOST", "DB_PORT", "DB_USER", "DB_NAME", "DB_SCHEMA", "TENANT", "RLS_TENANT", "ID_TYPE",
+	"DB_SSLMODE", "DB_SSLROOTCERT", "DB_SSLCERT", "DB_SSLKEY", "DB_SSLSERVERNAME",
+	"SECRETS_PROVIDER", "SECRET_ID", "VAULT_ADDR",
+	"MAINTENANCE_DB", "MAINTENANCE_USER", "AUTO_CREATE_DATABASE", "AUTO_CREATE_ROLE", "CREDENTIAL_REFRESH", "VERIFY_POSTGRES",
+	"CONNECT_MAX_ATTEMPTS", "CONNECT_TIMEOUT", "TX_MAX_ATTEMPTS", "TX_RETRY_BUDGET", "QUERY_TIMEOUT", "STATEMENT_TIMEOUT", "LOCK_TIMEOUT",
+	"STATEMENT_CACHE_MODE", "STATEMENT_CACHE_CAPACITY", "PGBOUNCER_MODE", "READ_ONLY", "ORM",
+	"DB_MAX_CONNS", "DB_MIN_CONNS", "DB_MAX_CONN_LIFETIME", "DB_MAX_CONN_IDLE_TIME", "DB_ADAPTIVE_POOL",
+	"STARTUP_BANNER", "DEDUP_INPUT", "DEDUP_KEEP", "RECORD_PROVENANCE", "PRECHECK_DUPLICATES", "ON_CONFLICT", "ON_CONFLICT_EMAIL",
+	"MAX_WRITES_PER_SEC", "WRITE_BURST", "BREAKER_THRESHOLD", "BREAKER_COOLDOWN", "CACHE_URL", "CACHE_TTL", "BCRYPT_COST",
+	"BROKER_URL", "BROKER_TOPIC", "OUTBOX_POLL_INTERVAL", "OUTBOX_BATCH_SIZE",
+	"FAKE_SEED", "TAIL_CHANNEL", "BACKFILL_BATCH_SIZE", "BACKFILL_DELAY", "PROGRESS_INTERVAL", "PROGRESS", "POOL_STATS_INTERVAL",
+	"SETUP_LOCK_TIMEOUT", "SCHEMA_BEHIND", "DOCTOR_TIMEOUT", "REQUIRED_EXTENSIONS",
+	"SERVE_ADDR", "SERVE_API", "GRPC_ADDR", "GRPC_TIMEOUT", "REQUEST_TIMEOUT", "IDEMPOTENCY_RETENTION", "SHUTDOWN_GRACE", "WATCH_CONFIG",
+	"LOG_FORMAT", "LOG_LEVEL", "LOG_SQL", "SLOW_QUERY_MS",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_SERVICE_NAME",
+}
+
+// boolKeys are the flagKeys that may be given without a value, e.g.
+// --log-sql for --log-sql=true.
+var boolKeys = map[string]bool{
+	"AUTO_CREATE_DATABASE": true, "AUTO_CREATE_ROLE": true, "CREDENTIAL_REFRESH": true, "VERIFY_POSTGRES": true,
+	"STARTUP_BANNER": true, "DEDUP_INPUT": true, "RECORD_PROVENANCE": true,
+	"PRECHECK_DUPLICATES": true, "LOG_SQL": true, "DRY_RUN": true, "READ_ONLY": true, "DB_ADAPTIVE_POOL": true,
+	"WATCH_CONFIG": true,
+}
+
+// FlagName returns the command-line flag that overrides key.
+func FlagName(key string) string {
+	return strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+}
+
+// FlagSet returns the --config flag and one flag per setting in flagKeys.
+// Every flag takes the same text as the setting in .env and is validated
+// by Load with it.
+func FlagSet() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("config", pflag.ContinueOnError)
+	fs.String("config", "", "YAML, TOML or JSON configuration file, read beneath .env (default $APP_CONFIG)")
+	for _, key := range flagKeys {
+		fs.String(FlagName(key), "", "overrides "+key)
+		if boolKeys[key] {
+			fs.Lookup(FlagName(key)).NoOptDefVal = "true"
+		}
+	}
+	return fs
+}
+
+// ParseFlags picks the flags of FlagSet out of args and binds them to Viper,
+// so they take precedence over every other source in Load. It must run
+// before Load, which in turn runs before the command line is parsed for
+// real, because commands take their flag defaults from the configuration.
+// Other flags belong to the commands and are skipped here; mistakes in
+// them are reported when the command line is parsed in full.
+func ParseFlags(args []string) {
+	fs := FlagSet()
+	fs.BoolP("help", "h", false, "") // cobra's, not an error here
+	fs.ParseErrorsAllowlist.UnknownFlags = true
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	fs.Parse(args)
+
+	if path, _ := fs.GetString("config"); path != "" {
+		SetFile(path)
+	}
+	for _, key := range flagKeys {
+		viper.BindPFlag(key, fs.Lookup(FlagName(key)))
+	}
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/viper"
+)
+
+// Tasks a Job can run.
+const (
+	TaskSeed            = "seed"             // insert the sample users, or Count generated ones
+	TaskAuditCleanup    = "audit-cleanup"    // delete users_audit entries older than Keep
+	TaskCleanup         = "cleanup"          // delete or Archive users deleted before KeepDeleted and audit entries older than Keep, and expired idempotency keys
+	TaskStats           = "stats"            // log row counts and table sizes
+	TaskEventPartitions = "event-partitions" // create the partitions of events for this month and Ahead more
+)
+
+// Job is a recurring task run by the daemon command, declared under the
+// jobs key of the configuration file:
+//
+//	jobs:
+//	  - name: prune-audit
+//	    schedule: "0 3 * * *"
+//	    task: audit-cleanup
+//	    keep: 720h
+//	  - name: stats
+//	    schedule: "@every 5m"
+//	    task: stats
+//	    timeout: 30s
+//
+// Schedule is a five-field cron expression (minute, hour, day of month,
+// month, day of week) in the local time zone, or a descriptor such as
+// @hourly, @daily or @every 10m.
+type Job struct {
+	Name     string `mapstructure:"name"`
+	Schedule string `mapstructure:"schedule"`
+	Task     string `mapstructure:"task"`
+	// Timeout cancels a run that takes longer; zero means no limit.
+	Timeout time.Duration `mapstructure:"timeout"`
+	// Count is how many generated users TaskSeed inserts; zero inserts the
+	// sample users.
+	Count int `mapstructure:"count"`
+	// Keep is how long TaskAuditCleanup and TaskCleanup keep audit
+	// entries; zero keeps them for good in TaskCleanup.
+	Keep time.Duration `mapstructure:"keep"`
+	// KeepDeleted is how long TaskCleanup keeps soft-deleted users; zero
+	// keeps them for good.
+	KeepDeleted time.Duration `mapstructure:"keep_deleted"`
+	// Archive makes TaskCleanup move the rows into archived_rows rather
+	// than delete them.
+	Archive bool `mapstructure:"archive"`
+	// Ahead is how many months after the current one TaskEventPartitions
+	// creates partitions for.
+	Ahead int `mapstructure:"ahead"`
+}
+
+// Next returns the first time after t that j is due. The schedule was
+// checked by Load.
+func (j Job) Next(t time.Time) time.Time {
+	s, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return time.Time{}
+	}
+	return s.Next(t)
+}
+
+// jobs reads and checks the jobs key.
+func (r *reader) jobs() []Job {
+	if !viper.IsSet("jobs") {
+		return nil
+	}
+	var jobs []Job
+	if err := viper.UnmarshalKey("jobs", &jobs); err != nil {
+		r.problem("jobs: " + err.Error())
+		return nil
+	}
+	if len(jobs) > 0 && (r.driver == DriverMySQL || r.driver == DriverSQLite) {
+		r.problem(fmt.Sprintf("jobs are not supported with DB_DRIVER=%s", r.driver))
+	}
+	seen := map[string]bool{}
+	for i, j := range jobs {
+		where := fmt.Sprintf("jobs[%d]", i)
+		if j.Name != "" {
+			where = fmt.Sprintf("job %q", j.Name)
+		}
+		if _, err := cron.ParseStandard(j.Schedule); err != nil {
+			r.problem(fmt.Sprintf("%s: invalid schedule %q: %v", where, j.Schedule, err))
+		}
+		switch {
+		case j.Name == "":
+			r.problem(where + ": name is required")
+		case seen[j.Name]:
+			r.problem(where + " is declared twice")
+		}
+		seen[j.Name] = true
+		switch j.Task {
+		case TaskSeed:
+			if j.Count < 0 {
+				r.problem(fmt.Sprintf("%s: count must not be negative", where))
+			}
+		case TaskAuditCleanup:
+			if j.Keep <= 0 {
+				r.problem(fmt.Sprintf("%s: keep is required for %s, e.g. keep: 720h", where, TaskAuditCleanup))
+			}
+		case TaskCleanup:
+			if j.Keep < 0 || j.KeepDeleted < 0 {
+				r.problem(fmt.Sprintf("%s: keep and keep_deleted must not be negative", where))
+			}
+			if j.Keep == 0 && j.KeepDeleted == 0 {
+				r.problem(fmt.Sprintf("%s: %s needs keep, keep_deleted or both, e.g. keep_deleted: 720h", where, TaskCleanup))
+			}
+		case TaskStats:
+		case TaskEventPartitions:
+			if j.Ahead < 0 {
+				r.problem(fmt.Sprintf("%s: ahead must not be negative", where))
+			}
+		default:
+			r.problem(fmt.Sprintf("%s: task must be one of %s, %s, %s, %s, %s (got %q)", where, TaskSeed, TaskAuditCleanup, TaskCleanup, TaskStats, TaskEventPartitions, j.Task))
+		}
+		if j.Timeout < 0 {
+			r.problem(fmt.Sprintf("%s: timeout must not be negative", where))
+		}
+	}
+	return jobs
+}
@@ -0,0 +1,37 @@
+package config
+
+import "regexp"
+
+// redacted replaces a secret in text passed through Redact. It is what pgx
+// prints for the password of a connection string it cannot parse.
+const redacted = "xxxxx"
+
+var (
+	// urlPassword is the password of a URL such as postgres://user:pw@host.
+	// It runs to the last @ of the word, since a password with an unescaped
+	// @ is exactly the kind of connection string that fails to parse.
+	urlPassword = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^\s]*@`)
+	// mysqlPassword is the password of a go-sql-driver DSN such as
+	// user:pw@tcp(host:3306)/db.
+	mysqlPassword = regexp.MustCompile(`([\w.%-]+:)[^@\s]*@(\w*\()`)
+	// secretParam is a key=value pair whose key names a secret: a password
+	// or sslpassword query parameter, a password=... keyword in a libpq
+	// connection string, or a setting such as DB_PASSWORD or VAULT_TOKEN
+	// in a configuration error. The value may be quoted.
+	secretParam = regexp.MustCompile(`(?i)([\w.-]*(?:password|passwd|pwd|token|secret)[\w-]*=)("[^"]*"|'[^']*'|[^\s&;,'"]*)`)
+)
+
+// Redact masks the secrets of every connection string and secret setting
+// in s, which may be a bare connection string or a longer text such as an
+// error message quoting one. Host, port, user and database are kept, so the
+// result still says where a failed connection was going.
+//
+// pgx masks the userinfo password in its own parse errors, but not a
+// password given as a query parameter, and MySQL DSNs and configuration
+// errors are not masked at all. Everything that logs or prints such text
+// goes through Redact: the logger, the doctor report and trace spans.
+func Redact(s string) string {
+	s = urlPassword.ReplaceAllString(s, "${1}"+redacted+"@")
+	s = mysqlPassword.ReplaceAllString(s, "${1}"+redacted+"@${2}")
+	return secretParam.ReplaceAllString(s, "${1}"+redacted)
+}
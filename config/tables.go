@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// Table is an extra table declared under the tables key of the
+// configuration file. The program creates it with CREATE TABLE IF NOT
+// EXISTS next to its own tables, in DB_SCHEMA, whenever it migrates:
+//
+//	tables:
+//	  - name: teams
+//	    columns:
+//	      - {name: id, type: serial, primary_key: true}
+//	      - {name: name, type: varchar(100), not_null: true, unique: true}
+//	      - {name: owner_id, type: integer, references: users(id)}
+//	      - {name: created_at, type: timestamp, default: CURRENT_TIMESTAMP}
+//
+// Only a configuration file can declare tables; .env files and the
+// environment cannot express a list.
+type Table struct {
+	Name    string   `mapstructure:"name"`
+	Columns []Column `mapstructure:"columns"`
+	// Unique lists groups of columns that must be unique together.
+	Unique [][]string `mapstructure:"unique"`
+}
+
+// Column is a column of a Table. Type and Default are SQL, written into
+// the statement as they are: the configuration file is trusted like a
+// migration.
+type Column struct {
+	Name       string `mapstructure:"name"`
+	Type       string `mapstructure:"type"`
+	PrimaryKey bool   `mapstructure:"primary_key"`
+	NotNull    bool   `mapstructure:"not_null"`
+	Unique     bool   `mapstructure:"unique"`
+	Default    string `mapstructure:"default"`
+	// References is table or table(column), in the same schema.
+	References string `mapstructure:"references"`
+}
+
+// identifierPattern matches the table and column names a Table may use:
+// the unquoted identifiers PostgreSQL leaves as they are.
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// columnTypePattern matches a type name of one or more words, with an
+// optional length or precision and array suffix, such as varchar(100),
+// numeric(10, 2), timestamp with time zone or text[]. It keeps a typo from
+// turning into a statement other than the one meant.
+var columnTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_ ]*(\(\s*\d+\s*(,\s*\d+\s*)?\))?(\[\])?$`)
+
+// referencesPattern matches table or table(column).
+var referencesPattern = regexp.MustCompile(`^([a-z_][a-z0-9_]*)(\(([a-z_][a-z0-9_]*)\))?$`)
+
+// programTables are the tables and views of the migrations and the tables
+// of the vector and geo setup commands, which a Table may not replace.
+var programTables = map[string]bool{
+	"users": true, "posts": true, "users_audit": true, "users_outbox": true, "user_stats": true, "events": true, "idempotency_keys": true, "schema_migrations": true,
+	"user_embeddings": true, "user_locations": true,
+}
+
+// tables reads and checks the tables key.
+func (r *reader) tables() []Table {
+	if !viper.IsSet("tables") {
+		return nil
+	}
+	var tables []Table
+	if err := viper.UnmarshalKey("tables", &tables); err != nil {
+		r.problem("tables: " + err.Error())
+		return nil
+	}
+	if len(tables) > 0 && (r.driver == DriverMySQL || r.driver == DriverSQLite) {
+		r.problem(fmt.Sprintf("tables are not supported with DB_DRIVER=%s", r.driver))
+	}
+	seen := map[string]bool{}
+	for i, t := range tables {
+		where := fmt.Sprintf("tables[%d]", i)
+		if t.Name != "" {
+			where = fmt.Sprintf("table %q", t.Name)
+		}
+		switch {
+		case !identifierPattern.MatchString(t.Name):
+			r.problem(fmt.Sprintf("%s: name must be lower-case letters, digits and underscores, starting with a letter or underscore", where))
+		case programTables[t.Name]:
+			r.problem(fmt.Sprintf("%s: the program's own tables cannot be declared", where))
+		case seen[t.Name]:
+			r.problem(fmt.Sprintf("%s is declared twice", where))
+		}
+		seen[t.Name] = true
+		r.checkColumns(where, t)
+	}
+	return tables
+}
+
+// checkColumns checks the columns and unique groups of t.
+func (r *reader) checkColumns(where string, t Table) {
+	if len(t.Columns) == 0 {
+		r.problem(where + ": no columns")
+	}
+	names := map[string]bool{}
+	for _, c := range t.Columns {
+		switch {
+		case !identifierPattern.MatchString(c.Name):
+			r.problem(fmt.Sprintf("%s: column name %q must be lower-case letters, digits and underscores", where, c.Name))
+		case names[c.Name]:
+			r.problem(fmt.Sprintf("%s: column %s is declared twice", where, c.Name))
+		case !columnTypePattern.MatchString(strings.TrimSpace(c.Type)):
+			r.problem(fmt.Sprintf("%s: column %s has no valid type (got %q)", where, c.Name, c.Type))
+		case c.References != "" && !referencesPattern.MatchString(c.References):
+			r.problem(fmt.Sprintf("%s: column %s references %q; use table or table(column)", where, c.Name, c.References))
+		}
+		names[c.Name] = true
+	}
+	for _, group := range t.Unique {
+		for _, name := range group {
+			if !names[name] {
+				r.problem(fmt.Sprintf("%s: unique names column %q, which is not declared", where, name))
+			}
+		}
+	}
+}
+
+// ReferencedTable returns the table and column of c.References, the column
+// being empty when only the table is named.
+func (c Column) ReferencedTable() (table, column string) {
+	m := referencesPattern.FindStringSubmatch(c.References)
+	if m == nil {
+		return "", ""
+	}
+	return m[1], m[3]
+}
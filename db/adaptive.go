@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// AdaptiveSize lowers the number of connections a pool hands out while
+// the server refuses new ones with too_many_connections (53300), as when
+// other clients have taken up its max_connections, and raises it back
+// once the refusals stop. Each time Errors refusals arrive within Window
+// the limit is halved, but never below the pool's MinConns (or one); after
+// RecoverAfter without a refusal it grows by one connection, and again
+// after every further RecoverAfter, up to MaxConns. Acquires beyond the
+// limit wait for a connection to be released, and connections released
+// while the pool holds more than the limit are closed.
+//
+// A zero field takes the default of NewAdaptiveSize. An AdaptiveSize
+// belongs to a single pool; see Apply.
+type AdaptiveSize struct {
+	Errors       int
+	Window       time.Duration
+	RecoverAfter time.Duration
+	// Resized, if set, is called with the old and new limit on every
+	// change. It runs with the AdaptiveSize locked and must not block.
+	Resized func(from, to int32)
+
+	now func() time.Time
+
+	mu       sync.Mutex
+	pool     *pgxpool.Pool
+	min, max int32
+	limit    int32
+	inUse    int32
+	refused  []time.Time   // refusals within Window
+	changed  time.Time     // last refusal or resize
+	freed    chan struct{} // closed when a slot may have become free
+}
+
+// NewAdaptiveSize returns an AdaptiveSize that halves the limit on three
+// refusals within ten seconds and grows it every thirty seconds without
+// one.
+func NewAdaptiveSize() *AdaptiveSize {
+	return &AdaptiveSize{Errors: 3, Window: 10 * time.Second, RecoverAfter: 30 * time.Second}
+}
+
+// adaptiveSlot marks the context of an acquire that holds a slot.
+type adaptiveSlot struct{}
+
+// Apply installs s on cfg, whose MinConns and MaxConns bound the limit, so
+// call it after PoolSize.Apply. It adds s to the tracer of cfg and chains
+// its AfterRelease hook.
+func (s *AdaptiveSize) Apply(cfg *pgxpool.Config) {
+	def := NewAdaptiveSize()
+	if s.Errors <= 0 {
+		s.Errors = def.Errors
+	}
+	if s.Window <= 0 {
+		s.Window = def.Window
+	}
+	if s.RecoverAfter <= 0 {
+		s.RecoverAfter = def.RecoverAfter
+	}
+	if s.now == nil {
+		s.now = time.Now
+	}
+	s.max, s.limit = cfg.MaxConns, cfg.MaxConns
+	s.min = max(cfg.MinConns, 1)
+	s.freed = make(chan struct{})
+
+	if cfg.ConnConfig.Tracer == nil {
+		cfg.ConnConfig.Tracer = multitracer.New(s)
+	} else {
+		cfg.ConnConfig.Tracer = multitracer.New(cfg.ConnConfig.Tracer, s)
+	}
+	afterRelease := cfg.AfterRelease
+	cfg.AfterRelease = func(conn *pgx.Conn) bool {
+		if afterRelease != nil && !afterRelease(conn) {
+			return false
+		}
+		return !s.surplus()
+	}
+}
+
+// Limit returns the number of connections the pool may hand out now.
+func (s *AdaptiveSize) Limit() int32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// TraceAcquireStart waits until the pool hands out fewer connections than
+// the limit, or ctx is done, which fails the acquire.
+func (s *AdaptiveSize) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, _ pgxpool.TraceAcquireStartData) context.Context {
+	for {
+		s.mu.Lock()
+		s.pool = pool
+		s.grow()
+		if s.inUse < s.limit {
+			s.inUse++
+			s.mu.Unlock()
+			return context.WithValue(ctx, adaptiveSlot{}, true)
+		}
+		freed := s.freed
+		s.mu.Unlock()
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx
+		}
+	}
+}
+
+// TraceAcquireEnd gives the slot of a failed acquire back and counts a
+// refusal of the server.
+func (s *AdaptiveSize) TraceAcquireEnd(ctx context.Context, _ *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+	if data.Err == nil {
+		return
+	}
+	if ctx.Value(adaptiveSlot{}) != nil {
+		s.release()
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(data.Err, &pgErr) && pgErr.Code == "53300" {
+		s.refusal()
+	}
+}
+
+// TraceRelease gives the slot of a released connection back.
+func (s *AdaptiveSize) TraceRelease(*pgxpool.Pool, pgxpool.TraceReleaseData) {
+	s.release()
+}
+
+// TraceQueryStart and TraceQueryEnd do nothing; they make s a
+// pgx.QueryTracer, as multitracer needs.
+func (s *AdaptiveSize) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (s *AdaptiveSize) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func (s *AdaptiveSize) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Connections taken without Acquire, as by AcquireAllIdle, held no slot
+	if s.inUse > 0 {
+		s.inUse--
+	}
+	s.grow()
+	s.wake()
+}
+
+// refusal halves the limit once Errors refusals fell within Window.
+func (s *AdaptiveSize) refusal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.changed = now
+	kept := s.refused[:0]
+	for _, t := range s.refused {
+		if now.Sub(t) < s.Window {
+			kept = append(kept, t)
+		}
+	}
+	s.refused = append(kept, now)
+	if len(s.refused) < s.Errors {
+		return
+	}
+	s.refused = s.refused[:0]
+	s.resize(max(s.limit/2, s.min))
+}
+
+// grow raises the limit by one if RecoverAfter passed since the last
+// refusal or resize. s must be locked.
+func (s *AdaptiveSize) grow() {
+	if s.limit < s.max && s.now().Sub(s.changed) >= s.RecoverAfter {
+		s.resize(s.limit + 1)
+		s.wake()
+	}
+}
+
+// resize sets the limit to n. s must be locked.
+func (s *AdaptiveSize) resize(n int32) {
+	if n == s.limit {
+		return
+	}
+	if s.Resized != nil {
+		s.Resized(s.limit, n)
+	}
+	s.limit = n
+	s.changed = s.now()
+}
+
+// wake lets the acquires waiting for a slot check again. s must be locked.
+func (s *AdaptiveSize) wake() {
+	close(s.freed)
+	s.freed = make(chan struct{})
+}
+
+// surplus reports whether the pool holds more connections than the limit,
+// so a released one should be closed rather than kept idle.
+func (s *AdaptiveSize) surplus() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pool != nil && s.pool.Stat().TotalConns() > s.limit
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fakeServer accepts PostgreSQL connections on a local port and, while
+// refusing is set, turns them away with too_many_connections.
+type fakeServer struct {
+	lis      net.Listener
+	refusing atomic.Bool
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	s := &fakeServer{lis: lis}
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeServer) connString() string {
+	return fmt.Sprintf("postgres://app@%s/app?sslmode=disable", s.lis.Addr())
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	if s.refusing.Load() {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "53300", Message: "sorry, too many clients already"})
+		backend.Flush()
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+	for {
+		if msg, err := backend.Receive(); err != nil {
+			return
+		} else if _, ok := msg.(*pgproto3.Terminate); ok {
+			return
+		}
+	}
+}
+
+func TestAdaptiveSize(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	server.refusing.Store(true)
+
+	var (
+		mu      sync.Mutex
+		resizes []string
+		elapsed atomic.Int64
+	)
+	start := time.Now()
+	size := &AdaptiveSize{
+		Errors:       3,
+		Window:       time.Minute,
+		RecoverAfter: time.Minute,
+		Resized: func(from, to int32) {
+			mu.Lock()
+			defer mu.Unlock()
+			resizes = append(resizes, fmt.Sprintf("%d->%d", from, to))
+		},
+		now: func() time.Time { return start.Add(time.Duration(elapsed.Load())) },
+	}
+	advance := func(d time.Duration) { elapsed.Add(int64(d)) }
+
+	cfg, err := pgxpool.ParseConfig(server.connString())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (PoolSize{MaxConns: 8, MinConns: 2}).Apply(cfg); err != nil {
+		t.Fatal(err)
+	}
+	size.Apply(cfg)
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	// Refusals spread wider than Window do not add up
+	for range 3 {
+		pool.Acquire(ctx)
+		advance(50 * time.Second)
+	}
+	if got := size.Limit(); got != 8 {
+		t.Fatalf("limit after spread out refusals = %d, want 8", got)
+	}
+
+	// Sustained refusals halve the limit down to MinConns
+	advance(2 * time.Minute)
+	for i := range 9 {
+		if _, err := pool.Acquire(ctx); err == nil {
+			t.Fatalf("acquire %d succeeded while the server refuses connections", i)
+		}
+	}
+	if got := size.Limit(); got != 2 {
+		t.Fatalf("limit after 9 refusals = %d, want MinConns 2", got)
+	}
+	server.refusing.Store(false)
+
+	// Once the refusals stop, the limit grows one connection at a time
+	advance(time.Minute)
+	var held []*pgxpool.Conn
+	defer func() {
+		for _, conn := range held {
+			conn.Release()
+		}
+	}()
+	for range 3 {
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, conn)
+	}
+	if got := size.Limit(); got != 3 {
+		t.Fatalf("limit a minute after the last refusal = %d, want 3", got)
+	}
+	short, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Acquire(short); err == nil {
+		t.Fatal("acquired a fourth connection with a limit of 3")
+	}
+	for range 5 {
+		advance(time.Minute)
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		held = append(held, conn)
+	}
+	if got := size.Limit(); got != 8 {
+		t.Fatalf("limit after recovering = %d, want MaxConns 8", got)
+	}
+
+	// Connections beyond a lowered limit are closed as they are released
+	for range 3 {
+		size.refusal()
+	}
+	for _, conn := range held {
+		conn.Release()
+	}
+	held = nil
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Stat().TotalConns() > 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := pool.Stat().TotalConns(); n > 4 {
+		t.Errorf("pool holds %d connections with a limit of 4", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"8->4", "4->2", "2->3", "3->4", "4->5", "5->6", "6->7", "7->8", "8->4"}
+	if !slices.Equal(resizes, want) {
+		t.Errorf("resizes = %v, want %v", resizes, want)
+	}
+}
+
+func TestAdaptiveSizeIgnoresOtherErrors(t *testing.T) {
+	size := NewAdaptiveSize()
+	cfg, err := pgxpool.ParseConfig("postgres://localhost/test?pool_max_conns=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size.Apply(cfg)
+	for _, err := range []error{
+		&pgconn.PgError{Code: "57P03"},
+		context.DeadlineExceeded,
+		fmt.Errorf("dial: %w", &net.OpError{Op: "dial"}),
+	} {
+		for range size.Errors {
+			ctx := size.TraceAcquireStart(context.Background(), nil, pgxpool.TraceAcquireStartData{})
+			size.TraceAcquireEnd(ctx, nil, pgxpool.TraceAcquireEndData{Err: err})
+		}
+	}
+	if got := size.Limit(); got != 4 {
+		t.Errorf("limit = %d after errors other than 53300, want 4", got)
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// trackedTx is a transaction begun by WithTx, or a savepoint begun inside
+// one, that keeps the functions OnCommit queued on it until the outermost
+// transaction commits. A savepoint that is released hands its queue to
+// the transaction it was begun in; one that is rolled back drops it.
+type trackedTx struct {
+	pgx.Tx
+	parent  *trackedTx
+	begunOn Beginner
+	pending []func(Beginner)
+}
+
+func (t *trackedTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := t.Tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedTx{Tx: tx, parent: t}, nil
+}
+
+func (t *trackedTx) Commit(ctx context.Context) error {
+	pending := t.pending
+	t.pending = nil
+	if err := t.Tx.Commit(ctx); err != nil {
+		return err
+	}
+	if t.parent != nil {
+		t.parent.pending = append(t.parent.pending, pending...)
+		return nil
+	}
+	for _, fn := range pending {
+		fn(t.begunOn)
+	}
+	return nil
+}
+
+func (t *trackedTx) Rollback(ctx context.Context) error {
+	t.pending = nil
+	return t.Tx.Rollback(ctx)
+}
+
+// Tracked reports whether tx was begun by WithTx, or nested in a
+// transaction that was, so that OnCommit can queue on it.
+func Tracked(tx pgx.Tx) bool {
+	_, ok := tx.(*trackedTx)
+	return ok
+}
+
+// OnCommit queues fn to run once tx and every transaction it is nested in
+// have committed, and reports whether it did. fn is passed the Beginner
+// the outermost transaction was begun on, as tx is closed by then. It is
+// dropped if any of those transactions rolls back instead. OnCommit
+// queues nothing, and reports false, for a transaction Tracked does not
+// report.
+func OnCommit(tx pgx.Tx, fn func(b Beginner)) bool {
+	t, ok := tx.(*trackedTx)
+	if ok {
+		t.pending = append(t.pending, fn)
+	}
+	return ok
+}
@@ -0,0 +1,134 @@
+// Package db holds database helpers shared by the commands and packages
+// that are not specific to one table.
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Beginner starts transactions. *pgxpool.Pool and *pgx.Conn start real
+// transactions; pgx.Tx starts a savepoint, so WithTx nests.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// WithTx runs fn in a transaction begun on b. The transaction is committed
+// when fn returns nil and rolled back when it returns an error or panics;
+// a panic is re-raised after the rollback. Statements inside fn must use tx,
+// not b, to take part in the transaction.
+//
+// Called with a pgx.Tx as b, WithTx nests: fn runs after SAVEPOINT, and
+// its work is kept with RELEASE SAVEPOINT or undone with ROLLBACK TO
+// SAVEPOINT. A statement that fails inside fn thus no longer aborts the
+// enclosing transaction, which carries on as if fn had never run; this
+// lets a long transaction give up on one row and keep the others.
+//
+// Functions queued on tx, or on a savepoint begun in it, with OnCommit run
+// after the outermost transaction commits.
+func WithTx(ctx context.Context, b Beginner, fn func(tx pgx.Tx) error) (err error) {
+	tx, err := b.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	if _, nested := b.(pgx.Tx); !nested {
+		tx = &trackedTx{Tx: tx, begunOn: b}
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			// The context may already be cancelled; roll back regardless
+			tx.Rollback(context.WithoutCancel(ctx))
+			panic(p)
+		}
+		if err != nil {
+			if rbErr := tx.Rollback(context.WithoutCancel(ctx)); rbErr != nil && !errors.Is(rbErr, pgx.ErrTxClosed) {
+				err = errors.Join(err, fmt.Errorf("rollback: %w", rbErr))
+			}
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
+	return nil
+}
+
+// Retryable reports whether err aborted a transaction that can succeed
+// when simply run again: a serialization_failure (SQLSTATE 40001), which
+// CockroachDB routinely returns under contention and PostgreSQL at
+// SERIALIZABLE or REPEATABLE READ, or a deadlock_detected (40P01), which
+// PostgreSQL returns to one of two writers that locked rows in opposite
+// orders.
+func Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// RetryPolicy bounds how often Retry and RetryTx run fn again.
+type RetryPolicy struct {
+	// MaxAttempts counts the first run too; 1 or less never retries.
+	MaxAttempts int
+	// Budget caps the time from the first run to the start of the last
+	// retry; a retry that could not start within it is not made. Zero
+	// means no limit beyond MaxAttempts.
+	Budget time.Duration
+	// OnRetry, if not nil, is called before each retry with the attempt
+	// that failed, its error and the wait before the next.
+	OnRetry func(attempt int, err error, wait time.Duration)
+}
+
+// after is time.After, which tests replace to skip the waits of Retry.
+var after = time.After
+
+// Retry runs fn until it returns nil or an error that is not Retryable, or
+// the attempts or budget of p run out, and returns its last error. The
+// wait between runs doubles from 50ms up to 1s, with jitter so that the
+// writers that collided do not collide again in lockstep.
+func Retry(ctx context.Context, p RetryPolicy, fn func() error) error {
+	start := time.Now()
+	delay := 50 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !Retryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+		wait := rand.N(delay) + delay
+		if p.Budget > 0 && time.Since(start)+wait > p.Budget {
+			return err
+		}
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-after(wait):
+		}
+		delay = min(2*delay, time.Second)
+	}
+}
+
+// RetryTx is WithTx rerun with Retry: a transaction aborted by a
+// serialization failure or a deadlock is rolled back and run again whole,
+// as CockroachDB's client retry guidance and PostgreSQL's documentation
+// both advise. fn may therefore run more than once and must not have
+// effects outside tx.
+//
+// Nested in a transaction (b is a pgx.Tx), fn runs once: the locks and
+// snapshot that made it fail belong to the enclosing transaction, and only
+// rerunning that can get past them.
+func RetryTx(ctx context.Context, b Beginner, p RetryPolicy, fn func(tx pgx.Tx) error) error {
+	if _, nested := b.(pgx.Tx); nested {
+		return WithTx(ctx, b, fn)
+	}
+	return Retry(ctx, p, func() error { return WithTx(ctx, b, fn) })
+}
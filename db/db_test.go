@@ -0,0 +1,135 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeBeginner begins fakeTxs, counting them.
+type fakeBeginner struct {
+	begun *int
+}
+
+func (b fakeBeginner) Begin(context.Context) (pgx.Tx, error) {
+	*b.begun++
+	return fakeTx{begun: b.begun}, nil
+}
+
+// fakeTx is a transaction that runs no statements. Begin starts a
+// savepoint, counted like a transaction.
+type fakeTx struct {
+	pgx.Tx
+	begun *int
+}
+
+func (t fakeTx) Begin(context.Context) (pgx.Tx, error) {
+	*t.begun++
+	return t, nil
+}
+
+func (fakeTx) Commit(context.Context) error   { return nil }
+func (fakeTx) Rollback(context.Context) error { return nil }
+
+// noWait makes Retry skip its waits for the rest of the test.
+func noWait(t *testing.T) {
+	t.Cleanup(func() { after = time.After })
+	after = func(time.Duration) <-chan time.Time {
+		ch := make(chan time.Time, 1)
+		ch <- time.Now()
+		return ch
+	}
+}
+
+func pgErr(code string) error {
+	return &pgconn.PgError{Code: code}
+}
+
+func TestRetryTx(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		errs        []error // returned by the attempts in turn; nil after
+		nested      bool
+		wantRuns    int
+		wantCode    string
+	}{
+		{name: "succeeds", maxAttempts: 5, wantRuns: 1},
+		{name: "deadlock then serialization failure", maxAttempts: 5, errs: []error{pgErr("40P01"), pgErr("40001")}, wantRuns: 3},
+		{name: "attempts run out", maxAttempts: 3, errs: []error{pgErr("40001"), pgErr("40P01"), pgErr("40001"), pgErr("40001")}, wantRuns: 3, wantCode: "40001"},
+		{name: "not retryable", maxAttempts: 5, errs: []error{pgErr("23505")}, wantRuns: 1, wantCode: "23505"},
+		{name: "no retries", maxAttempts: 1, errs: []error{pgErr("40001")}, wantRuns: 1, wantCode: "40001"},
+		{name: "nested", maxAttempts: 5, errs: []error{pgErr("40001")}, nested: true, wantRuns: 1, wantCode: "40001"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noWait(t)
+			var begun int
+			var b Beginner = fakeBeginner{&begun}
+			if tt.nested {
+				b = fakeTx{begun: &begun}
+			}
+			runs := 0
+			err := RetryTx(context.Background(), b, RetryPolicy{MaxAttempts: tt.maxAttempts}, func(pgx.Tx) error {
+				runs++
+				if runs <= len(tt.errs) {
+					return tt.errs[runs-1]
+				}
+				return nil
+			})
+			if runs != tt.wantRuns || begun != tt.wantRuns {
+				t.Errorf("ran %d times in %d transactions, want %d", runs, begun, tt.wantRuns)
+			}
+			var got *pgconn.PgError
+			switch {
+			case tt.wantCode == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tt.wantCode != "" && (!errors.As(err, &got) || got.Code != tt.wantCode):
+				t.Errorf("error = %v, want SQLSTATE %s", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	noWait(t)
+	var waits []time.Duration
+	p := RetryPolicy{
+		MaxAttempts: 10,
+		OnRetry:     func(_ int, _ error, wait time.Duration) { waits = append(waits, wait) },
+	}
+	Retry(context.Background(), p, func() error { return pgErr("40001") })
+
+	if len(waits) != 9 {
+		t.Fatalf("retried %d times, want 9", len(waits))
+	}
+	delay := 50 * time.Millisecond
+	for i, wait := range waits {
+		if wait < delay || wait >= 2*delay {
+			t.Errorf("wait %d = %v, want within [%v, %v)", i+1, wait, delay, 2*delay)
+		}
+		delay = min(2*delay, time.Second)
+	}
+	if last := waits[len(waits)-1]; last >= 2*time.Second {
+		t.Errorf("last wait = %v, want the 1s delay to cap it below 2s", last)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	noWait(t)
+	runs := 0
+	p := RetryPolicy{MaxAttempts: 10, Budget: 120 * time.Millisecond}
+	Retry(context.Background(), p, func() error {
+		runs++
+		return pgErr("40P01")
+	})
+	// The first wait is at most 100ms and the second at least 100ms, so
+	// only one retry fits in the budget
+	if runs != 2 {
+		t.Errorf("ran %d times, want 2", runs)
+	}
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrLockTimeout reports that an advisory lock was still held by another
+// session when the wait for it ran out.
+var ErrLockTimeout = errors.New("timed out waiting for advisory lock")
+
+// lockPollInterval is how often Lock and LockXact retry a lock held by
+// another session.
+const lockPollInterval = 500 * time.Millisecond
+
+// rowQuerier runs a query returning one row; pgx.Tx and *pgx.Conn do.
+type rowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// LockXact takes the advisory lock key for the rest of tx; it is released
+// when tx commits or rolls back. See Lock for how it waits.
+func LockXact(ctx context.Context, tx pgx.Tx, key int64, timeout time.Duration, waiting func()) error {
+	return waitLock(ctx, tx, "pg_try_advisory_xact_lock", key, timeout, waiting)
+}
+
+// Lock takes the session-level advisory lock key on conn. It is held until
+// Unlock or until the connection closes, so a process that dies holding it
+// does not block the others for good.
+//
+// A lock held by another session is polled for with the non-blocking
+// pg_try_advisory_lock rather than waited for in the server, so neither
+// statement_timeout nor lock_timeout cuts the wait short. waiting, if not
+// nil, is called once when the first attempt fails. Lock gives up with
+// ErrLockTimeout after timeout, or waits until ctx is done when timeout is
+// zero.
+func Lock(ctx context.Context, conn *pgx.Conn, key int64, timeout time.Duration, waiting func()) error {
+	return waitLock(ctx, conn, "pg_try_advisory_lock", key, timeout, waiting)
+}
+
+// TryLock takes the session-level advisory lock key on conn if no other
+// session holds it, and reports whether it did. It does not wait. A lock
+// taken is released as with Lock.
+func TryLock(ctx context.Context, conn *pgx.Conn, key int64) (bool, error) {
+	var locked bool
+	err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked)
+	return locked, err
+}
+
+// Unlock releases the session-level advisory lock key taken by Lock or
+// TryLock.
+func Unlock(ctx context.Context, conn *pgx.Conn, key int64) error {
+	var released bool
+	if err := conn.QueryRow(ctx, "SELECT pg_advisory_unlock($1)", key).Scan(&released); err != nil {
+		return err
+	}
+	if !released {
+		return fmt.Errorf("advisory lock %d was not held", key)
+	}
+	return nil
+}
+
+// waitLock calls the try-lock function fn until it grants key.
+func waitLock(ctx context.Context, q rowQuerier, fn string, key int64, timeout time.Duration, waiting func()) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for first := true; ; first = false {
+		var locked bool
+		if err := q.QueryRow(ctx, "SELECT "+fn+"($1)", key).Scan(&locked); err != nil {
+			return err
+		}
+		if locked {
+			return nil
+		}
+		if first && waiting != nil {
+			waiting()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline:
+			return fmt.Errorf("%w %d after %s", ErrLockTimeout, key, timeout)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PoolSize bounds a connection pool. A zero field keeps the pgxpool
+// default. config.Pool converts to it directly: db.PoolSize(cfg.Pool).
+type PoolSize struct {
+	MaxConns        int32
+	MinConns        int32
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+}
+
+// Apply copies s onto cfg. It fails if the result would keep more idle
+// connections than it may open.
+func (s PoolSize) Apply(cfg *pgxpool.Config) error {
+	if s.MaxConns > 0 {
+		cfg.MaxConns = s.MaxConns
+	}
+	if s.MinConns > 0 {
+		cfg.MinConns = s.MinConns
+	}
+	if cfg.MinConns > cfg.MaxConns {
+		return fmt.Errorf("DB_MIN_CONNS (%d) must not exceed DB_MAX_CONNS (%d)", cfg.MinConns, cfg.MaxConns)
+	}
+	if s.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = s.MaxConnLifetime
+	}
+	if s.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = s.MaxConnIdleTime
+	}
+	return nil
+}
+
+// StatementCache sets how connections opened with cfg cache statements.
+// Mode "describe" caches only the parameter and result descriptions and
+// sends queries unnamed; "exec" caches nothing and describes each query
+// on every run; "simple" sends queries over the simple protocol, with the
+// arguments interpolated client-side. Those three work through a pooler
+// such as PgBouncer in transaction mode, which hands each transaction a
+// server connection that may hold another client's prepared statements.
+// Anything else prepares each distinct query once per connection.
+// capacity bounds the entries per connection of the modes that cache;
+// zero keeps the pgx default.
+func StatementCache(cfg *pgx.ConnConfig, mode string, capacity int) {
+	switch mode {
+	case "exec":
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeExec
+	case "simple":
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	case "describe":
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeCacheDescribe
+		if capacity > 0 {
+			cfg.DescriptionCacheCapacity = capacity
+		}
+	default:
+		cfg.DefaultQueryExecMode = pgx.QueryExecModeCacheStatement
+		if capacity > 0 {
+			cfg.StatementCacheCapacity = capacity
+		}
+	}
+}
+
+// OpenPool parses connStr, lets configure adjust the result, and opens a
+// pool with it. configure may be nil. The pool connects lazily, so an
+// unreachable server is reported by the first query, not here. The
+// caller is responsible for closing the pool.
+func OpenPool(ctx context.Context, connStr string, configure func(*pgxpool.Config) error) (*pgxpool.Pool, error) {
+	cfg, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection string: %w", err)
+	}
+	if configure != nil {
+		if err := configure(cfg); err != nil {
+			return nil, err
+		}
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("creating connection pool: %w", err)
+	}
+	return pool, nil
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestPoolSizeApply(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         PoolSize
+		wantMin      int32
+		wantMax      int32
+		wantLifetime time.Duration
+		wantErr      string
+	}{
+		{name: "defaults kept", wantMin: 0, wantMax: 4, wantLifetime: time.Hour},
+		{name: "sized", size: PoolSize{MaxConns: 20, MinConns: 5, MaxConnLifetime: time.Minute}, wantMin: 5, wantMax: 20, wantLifetime: time.Minute},
+		{name: "min above max", size: PoolSize{MaxConns: 2, MinConns: 3}, wantErr: "DB_MIN_CONNS (3) must not exceed DB_MAX_CONNS (2)"},
+		{name: "min above default max", size: PoolSize{MinConns: 10}, wantErr: "must not exceed DB_MAX_CONNS (4)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := pgxpool.ParseConfig("postgres://localhost/test?pool_max_conns=4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tt.size.Apply(cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.MinConns != tt.wantMin || cfg.MaxConns != tt.wantMax || cfg.MaxConnLifetime != tt.wantLifetime {
+				t.Errorf("min %d, max %d, lifetime %v; want %d, %d, %v", cfg.MinConns, cfg.MaxConns, cfg.MaxConnLifetime, tt.wantMin, tt.wantMax, tt.wantLifetime)
+			}
+		})
+	}
+}
+
+func TestStatementCache(t *testing.T) {
+	tests := []struct {
+		mode      string
+		wantMode  pgx.QueryExecMode
+		statement int
+		describe  int
+	}{
+		{mode: "prepare", wantMode: pgx.QueryExecModeCacheStatement, statement: 64},
+		{mode: "describe", wantMode: pgx.QueryExecModeCacheDescribe, describe: 64},
+		{mode: "exec", wantMode: pgx.QueryExecModeExec},
+		{mode: "simple", wantMode: pgx.QueryExecModeSimpleProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			cfg, err := pgx.ParseConfig("postgres://localhost/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defaultStatement, defaultDescribe := cfg.StatementCacheCapacity, cfg.DescriptionCacheCapacity
+			StatementCache(cfg, tt.mode, 64)
+			if cfg.DefaultQueryExecMode != tt.wantMode {
+				t.Errorf("mode = %v, want %v", cfg.DefaultQueryExecMode, tt.wantMode)
+			}
+			if tt.statement == 0 {
+				tt.statement = defaultStatement
+			}
+			if tt.describe == 0 {
+				tt.describe = defaultDescribe
+			}
+			if cfg.StatementCacheCapacity != tt.statement || cfg.DescriptionCacheCapacity != tt.describe {
+				t.Errorf("capacities = %d, %d; want %d, %d", cfg.StatementCacheCapacity, cfg.DescriptionCacheCapacity, tt.statement, tt.describe)
+			}
+		})
+	}
+}
+
+func TestOpenPool(t *testing.T) {
+	ctx := context.Background()
+	if _, err := OpenPool(ctx, "postgres://localhost:notaport/test", nil); err == nil || !strings.Contains(err.Error(), "invalid connection string") {
+		t.Errorf("bad connection string: error = %v", err)
+	}
+
+	pool, err := OpenPool(ctx, "postgres://localhost/test", func(cfg *pgxpool.Config) error {
+		StatementCache(cfg.ConnConfig, "describe", 0)
+		return PoolSize{MaxConns: 7}.Apply(cfg)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	cfg := pool.Config()
+	if cfg.MaxConns != 7 || cfg.ConnConfig.DefaultQueryExecMode != pgx.QueryExecModeCacheDescribe {
+		t.Errorf("pool opened with max %d and mode %v", cfg.MaxConns, cfg.ConnConfig.DefaultQueryExecMode)
+	}
+
+	_, err = OpenPool(ctx, "postgres://localhost/test", func(cfg *pgxpool.Config) error {
+		return PoolSize{MaxConns: 1, MinConns: 2}.Apply(cfg)
+	})
+	if err == nil {
+		t.Error("configure error not returned")
+	}
+}
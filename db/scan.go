@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Queryer runs queries. *pgxpool.Pool, *pgxpool.Conn, *pgx.Conn and pgx.Tx
+// all are one.
+type Queryer interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// Select runs sql and returns its rows as a []T. Each column is scanned
+// into the field of T of the same name, as pgx.RowToStructByName does: the
+// db tag, or else the field name, compared case-insensitively. A column
+// without a field, or an exported field without a column, is an error, so
+// a query and its struct cannot drift apart unnoticed; tag a field db:"-"
+// to leave it out.
+func Select[T any](ctx context.Context, q Queryer, sql string, args ...any) ([]T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByName[T])
+}
+
+// Get is Select for a query that returns a single row. No row is
+// pgx.ErrNoRows and more than one pgx.ErrTooManyRows, so a caller can
+// tell a missing record from a failure.
+func Get[T any](ctx context.Context, q Queryer, sql string, args ...any) (*T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectExactlyOneRow(rows, pgx.RowToAddrOfStructByName[T])
+}
+
+// SelectColumn runs sql, which selects a single column, and returns its
+// values as a []T.
+func SelectColumn[T any](ctx context.Context, q Queryer, sql string, args ...any) ([]T, error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowTo[T])
+}
+
+// Each is Select for results too large to hold: it calls fn with each row
+// as a T as it arrives, and stops at the first error fn returns. The rows
+// stay open, and with them the connection, until fn has seen the last.
+func Each[T any](ctx context.Context, q Queryer, fn func(T) error, sql string, args ...any) error {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := pgx.RowToStructByName[T](rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
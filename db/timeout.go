@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// QueryTimeout is a pgx.QueryTracer that gives every statement run with
+// Exec, Query or QueryRow a deadline of its own, so a query stuck on a
+// hung connection or a slow plan fails instead of waiting forever. The
+// deadline runs from the start of the statement until its rows are closed;
+// a caller's earlier deadline still wins. COPY and batches are not traced
+// as queries and are not bounded.
+//
+// A timed-out statement fails with context.DeadlineExceeded, and pgx
+// closes its connection, since the server may still be running it. Use a
+// server-side statement_timeout (see SessionTimeouts) to have PostgreSQL
+// stop the statement itself.
+type QueryTimeout time.Duration
+
+type queryTimeoutKey struct{}
+
+type noQueryTimeoutKey struct{}
+
+// WithoutQueryTimeout returns a context whose statements QueryTimeout does
+// not bound, for work that is expected to run long, such as migrations.
+func WithoutQueryTimeout(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noQueryTimeoutKey{}, true)
+}
+
+func (t QueryTimeout) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	if t <= 0 || ctx.Value(noQueryTimeoutKey{}) != nil {
+		return ctx
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(t))
+	return context.WithValue(ctx, queryTimeoutKey{}, cancel)
+}
+
+func (QueryTimeout) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	if cancel, ok := ctx.Value(queryTimeoutKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+// SessionTimeouts sets the statement_timeout and lock_timeout of every
+// session opened with cfg, as startup parameters. Zero leaves the server's
+// setting alone. PostgreSQL cancels a statement that runs longer than
+// statement, or waits longer than lock for a lock, with SQLSTATE 57014 or
+// 55P03 respectively.
+func SessionTimeouts(cfg *pgconn.Config, statement, lock time.Duration) {
+	if cfg.RuntimeParams == nil {
+		cfg.RuntimeParams = map[string]string{}
+	}
+	if statement > 0 {
+		cfg.RuntimeParams["statement_timeout"] = strconv.FormatInt(statement.Milliseconds(), 10)
+	}
+	if lock > 0 {
+		cfg.RuntimeParams["lock_timeout"] = strconv.FormatInt(lock.Milliseconds(), 10)
+	}
+}
@@ -0,0 +1,171 @@
+// Package events writes and reads the events table of migration 0019, an
+// append-only log partitioned by month of created_at, and creates the
+// partitions it needs ahead of time.
+//
+// The table is partitioned by range: each partition holds the events of
+// one UTC month and is named events_YYYY_MM. PostgreSQL routes an insert
+// to its partition, and skips the partitions a query's bounds on
+// created_at rule out. An event for a month without a partition cannot be
+// stored, which is ErrNoPartition; EnsurePartitions prevents it.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TableName is the partitioned table.
+const TableName = "events"
+
+// ErrNoPartition means an event's created_at falls in a month that has no
+// partition yet.
+var ErrNoPartition = errors.New("no partition of events covers the time of the event")
+
+// Event is a row of events. Payload is any JSON document; an empty one is
+// stored as {}.
+type Event struct {
+	ID        int64           `db:"id" json:"id"`
+	Name      string          `db:"name" json:"name"`
+	Payload   json.RawMessage `db:"payload" json:"payload"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+// DB is what a Store needs from the database. *pgxpool.Pool, *pgx.Conn and
+// pgx.Tx satisfy it.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+}
+
+// Store reads and writes the events table of one schema.
+type Store struct {
+	db     DB
+	schema string
+	table  string
+}
+
+// NewStore returns a Store for the events table in schema.
+func NewStore(q DB, schema string) *Store {
+	return &Store{db: q, schema: schema, table: pgx.Identifier{schema, TableName}.Sanitize()}
+}
+
+// validate checks e before it is written.
+func validate(e Event) error {
+	switch {
+	case e.Name == "":
+		return errors.New("event name is empty")
+	case len(e.Name) > 100:
+		return fmt.Errorf("event name %q is longer than 100 characters", e.Name)
+	case len(e.Payload) > 0 && !json.Valid(e.Payload):
+		return fmt.Errorf("payload of event %q is not valid JSON", e.Name)
+	}
+	return nil
+}
+
+// payload returns the payload to store for e.
+func payload(e Event) json.RawMessage {
+	if len(e.Payload) == 0 {
+		return json.RawMessage("{}")
+	}
+	return e.Payload
+}
+
+// Insert stores e and fills in its ID, and its CreatedAt when zero, with
+// the time of the insert.
+func (s *Store) Insert(ctx context.Context, e *Event) error {
+	if err := validate(*e); err != nil {
+		return err
+	}
+	var at *time.Time
+	if !e.CreatedAt.IsZero() {
+		at = &e.CreatedAt
+	}
+	err := s.db.QueryRow(ctx, `INSERT INTO `+s.table+` (name, payload, created_at)
+		VALUES ($1, $2, coalesce($3, clock_timestamp()))
+		RETURNING id, created_at`, e.Name, payload(*e), at).Scan(&e.ID, &e.CreatedAt)
+	return insertError(err)
+}
+
+// InsertMany stores events with one COPY, which suits the volumes an
+// append-only log receives. A zero CreatedAt is taken as now; the IDs are
+// not reported back. Either every event is stored or none.
+func (s *Store) InsertMany(ctx context.Context, es []Event) (int64, error) {
+	now := time.Now()
+	rows := make([][]any, len(es))
+	for i, e := range es {
+		if err := validate(e); err != nil {
+			return 0, fmt.Errorf("event %d: %w", i+1, err)
+		}
+		at := e.CreatedAt
+		if at.IsZero() {
+			at = now
+		}
+		rows[i] = []any{e.Name, payload(e), at}
+	}
+	n, err := s.db.CopyFrom(ctx, pgx.Identifier{s.schema, TableName}, []string{"name", "payload", "created_at"}, pgx.CopyFromRows(rows))
+	return n, insertError(err)
+}
+
+// insertError reports the error PostgreSQL raises for a row no partition
+// accepts as ErrNoPartition.
+func insertError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23514" && pgErr.ConstraintName == "" {
+		return fmt.Errorf("%w: %s", ErrNoPartition, pgErr.Message)
+	}
+	return err
+}
+
+// Filter selects events for List. Zero fields select everything.
+type Filter struct {
+	Name string
+	// Since and Until bound created_at, Since included and Until not. The
+	// bounds are what lets PostgreSQL skip partitions.
+	Since, Until time.Time
+	// Limit caps the number of events; zero returns them all.
+	Limit int
+}
+
+// List returns the events f selects, newest first. Only the conditions f
+// sets are written into the query, so that the bounds on created_at are
+// plain comparisons the planner can prune partitions with.
+func (s *Store) List(ctx context.Context, f Filter) ([]Event, error) {
+	if f.Limit < 0 {
+		return nil, errors.New("limit must not be negative")
+	}
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.Name != "" {
+		add("name = $%d", f.Name)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("created_at < $%d", f.Until)
+	}
+	sql := `SELECT id, name, payload, created_at FROM ` + s.table
+	if len(where) > 0 {
+		sql += " WHERE " + strings.Join(where, " AND ")
+	}
+	sql += " ORDER BY created_at DESC, id DESC"
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		sql += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return db.Select[Event](ctx, s.db, sql, args...)
+}
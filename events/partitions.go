@@ -0,0 +1,78 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// Partition is a partition of events.
+type Partition struct {
+	Name string `db:"name" json:"name"`
+	// Bounds is the partition's range as PostgreSQL prints it, e.g.
+	// FOR VALUES FROM ('2026-10-01 00:00:00+00') TO ('2026-11-01 00:00:00+00').
+	Bounds string `db:"bounds" json:"bounds"`
+	// Rows is the planner's estimate, as of the last ANALYZE; -1 before
+	// the first.
+	Rows int64 `db:"rows" json:"rows"`
+}
+
+// monthStart returns the first instant of t's month, in UTC.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// PartitionName returns the name of the partition that holds the events
+// of t's UTC month.
+func PartitionName(t time.Time) string {
+	return TableName + "_" + monthStart(t).Format("2006_01")
+}
+
+// EnsurePartitions creates the partitions of the month of now and of the
+// ahead months after it that do not exist yet, and returns the names of
+// those it created. Running it again creates nothing, so it can be
+// scheduled as often as wanted; a month that is already covered by a
+// partition of another name is an error.
+func (s *Store) EnsurePartitions(ctx context.Context, now time.Time, ahead int) ([]string, error) {
+	if ahead < 0 {
+		return nil, fmt.Errorf("cannot create partitions %d months ahead", ahead)
+	}
+	var created []string
+	month := monthStart(now)
+	for range ahead + 1 {
+		next := month.AddDate(0, 1, 0)
+		name := PartitionName(month)
+		table := pgx.Identifier{s.schema, name}.Sanitize()
+		var exists bool
+		if err := s.db.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+			return created, err
+		}
+		if !exists {
+			// DDL takes no parameters; the bounds are formatted here and
+			// the names are quoted
+			stmt := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+				table, s.table, month.Format(time.RFC3339), next.Format(time.RFC3339))
+			if _, err := s.db.Exec(ctx, stmt); err != nil {
+				return created, fmt.Errorf("creating partition %s: %w", name, err)
+			}
+			created = append(created, name)
+		}
+		month = next
+	}
+	return created, nil
+}
+
+// Partitions returns the partitions of events, oldest first.
+func (s *Store) Partitions(ctx context.Context) ([]Partition, error) {
+	return db.Select[Partition](ctx, s.db, `SELECT c.relname AS name,
+			pg_get_expr(c.relpartbound, c.oid) AS bounds,
+			c.reltuples::bigint AS rows
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass
+		ORDER BY c.relname`, s.table)
+}
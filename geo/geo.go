@@ -0,0 +1,152 @@
+// Package geo reads and writes the points of PostGIS geography columns.
+//
+// PostGIS has no fixed OID, and pgx knows nothing of its types, so pgx
+// exchanges them as text: a geography is sent and returned as hex-encoded
+// EWKB, the extended well-known binary of PostGIS. Point implements
+// sql.Scanner and driver.Valuer over that form, so it works on any
+// connection of a pool without registering a type first, and reads the
+// binary form as well for the occasions it is requested.
+//
+// EWKB is a byte-order flag (1 little-endian, 0 big-endian), a 32-bit
+// geometry type whose high bits flag a Z or M coordinate and an SRID, the
+// SRID when flagged, then the coordinates as 64-bit floats.
+package geo
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// SRID is the spatial reference of the points: WGS 84, the longitude and
+// latitude of GPS, and the default of the geography type.
+const SRID = 4326
+
+// EWKB geometry type and its flags.
+const (
+	wkbPoint = 1
+	flagZ    = 0x80000000
+	flagM    = 0x40000000
+	flagSRID = 0x20000000
+)
+
+// Point is a position on the WGS 84 ellipsoid, in degrees.
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Validate reports an error unless p is a position on Earth.
+func (p Point) Validate() error {
+	switch {
+	case math.IsNaN(p.Lat) || p.Lat < -90 || p.Lat > 90:
+		return fmt.Errorf("latitude %v is not between -90 and 90", p.Lat)
+	case math.IsNaN(p.Lon) || p.Lon < -180 || p.Lon > 180:
+		return fmt.Errorf("longitude %v is not between -180 and 180", p.Lon)
+	}
+	return nil
+}
+
+func (p Point) String() string {
+	return fmt.Sprintf("%.6f,%.6f", p.Lat, p.Lon)
+}
+
+// EWKB returns p as little-endian EWKB with SRID.
+func (p Point) EWKB() []byte {
+	b := []byte{1}
+	b = binary.LittleEndian.AppendUint32(b, wkbPoint|flagSRID)
+	b = binary.LittleEndian.AppendUint32(b, SRID)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Lon))
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(p.Lat))
+}
+
+// Value implements driver.Valuer, giving p in the hex form PostGIS parses.
+func (p Point) Value() (driver.Value, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return hex.EncodeToString(p.EWKB()), nil
+}
+
+// Scan implements sql.Scanner. It accepts hex-encoded EWKB, the text form
+// of geometry and geography, and raw EWKB, their binary form. Only points
+// can be scanned; a Z or M coordinate is dropped.
+func (p *Point) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case string:
+		var err error
+		if b, err = hex.DecodeString(v); err != nil {
+			return fmt.Errorf("geo: %w", err)
+		}
+	case []byte:
+		b = v
+		// The text form may arrive as bytes too
+		if len(v) > 0 && v[0] == '0' {
+			if d, err := hex.DecodeString(string(v)); err == nil {
+				b = d
+			}
+		}
+	case nil:
+		return errors.New("geo: cannot scan NULL into Point")
+	default:
+		return fmt.Errorf("geo: cannot scan %T into Point", src)
+	}
+	return p.decode(b)
+}
+
+// decode reads the EWKB point b.
+func (p *Point) decode(b []byte) error {
+	if len(b) < 5 {
+		return fmt.Errorf("geo: %d bytes is too short for a point", len(b))
+	}
+	var order binary.ByteOrder
+	switch b[0] {
+	case 0:
+		order = binary.BigEndian
+	case 1:
+		order = binary.LittleEndian
+	default:
+		return fmt.Errorf("geo: invalid byte order %d", b[0])
+	}
+	typ := order.Uint32(b[1:])
+	if kind := typ &^ (flagZ | flagM | flagSRID); kind != wkbPoint {
+		return fmt.Errorf("geo: geometry type %d is not a point", kind)
+	}
+	b = b[5:]
+	if typ&flagSRID != 0 {
+		if len(b) < 4 {
+			return errors.New("geo: truncated SRID")
+		}
+		if srid := order.Uint32(b); srid != SRID {
+			return fmt.Errorf("geo: SRID %d, want %d", srid, SRID)
+		}
+		b = b[4:]
+	}
+	dims := 2
+	if typ&flagZ != 0 {
+		dims++
+	}
+	if typ&flagM != 0 {
+		dims++
+	}
+	if len(b) != 8*dims {
+		return fmt.Errorf("geo: %d bytes for a point of %d coordinates", len(b), dims)
+	}
+	lon := math.Float64frombits(order.Uint64(b))
+	lat := math.Float64frombits(order.Uint64(b[8:]))
+	if math.IsNaN(lon) || math.IsNaN(lat) {
+		return errors.New("geo: the point is empty")
+	}
+	*p = Point{Lat: lat, Lon: lon}
+	return nil
+}
+
+var (
+	_ sql.Scanner   = (*Point)(nil)
+	_ driver.Valuer = Point{}
+)
@@ -0,0 +1,9 @@
+// Package graph is the GraphQL API of the users table: the executable
+// schema and models generated by gqlgen from schema.graphqls. The
+// resolvers implementing ResolverRoot are in package server. After
+// editing the schema, regenerate the code with:
+//
+//	go generate ./graph
+package graph
+
+//go:generate go run github.com/99designs/gqlgen@v0.17.94 generate
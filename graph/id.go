@@ -0,0 +1,21 @@
+package graph
+
+import (
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/hozana-dusabimana/users"
+)
+
+// MarshalUserID writes the id of a user as an ID, letting User bind to
+// users.User without a resolver of its own for the field.
+func MarshalUserID(id users.ID) graphql.Marshaler {
+	return graphql.MarshalID(id.String())
+}
+
+// UnmarshalUserID reads an ID that names a user.
+func UnmarshalUserID(v any) (users.ID, error) {
+	s, err := graphql.UnmarshalID(v)
+	if err != nil {
+		return "", err
+	}
+	return users.ParseID(s)
+}
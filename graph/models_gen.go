@@ -0,0 +1,51 @@
+// Code generated by github.com/99designs/gqlgen, DO NOT EDIT.
+
+package graph
+
+import (
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+type CreateUserInput struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type CreateUserPayload struct {
+	User *users.User `json:"user"`
+	// True when ON_CONFLICT=upsert overwrote an existing user.
+	Updated bool `json:"updated"`
+}
+
+type Mutation struct {
+}
+
+type Query struct {
+}
+
+type UpdateUserInput struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	// When given, the update only applies if the user is unchanged since.
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+	// When given, the update only applies if the user still has this version.
+	Version *int `json:"version,omitempty"`
+}
+
+type UserFilter struct {
+	UsernamePrefix *string    `json:"usernamePrefix,omitempty"`
+	EmailDomain    *string    `json:"emailDomain,omitempty"`
+	CreatedAfter   *time.Time `json:"createdAfter,omitempty"`
+	CreatedBefore  *time.Time `json:"createdBefore,omitempty"`
+}
+
+// A page of users, as GET /users returns.
+type UserPage struct {
+	Users []*users.User `json:"users"`
+	// The number of users matching the filter, on all pages.
+	Total int `json:"total"`
+	// Passed back as after to fetch the next page; null on the last page.
+	NextCursor *string `json:"nextCursor,omitempty"`
+}
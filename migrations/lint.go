@@ -0,0 +1,231 @@
+package migrations
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Problem is a mistake Lint found in a migration file.
+type Problem struct {
+	File    string
+	Line    int // 0 for the file as a whole
+	Message string
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return p.File + ": " + p.Message
+	}
+	return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+}
+
+// LintFrom is the first embedded migration written after Lint, from which
+// on the statement checks apply.
+const LintFrom = 23
+
+// Files returns the embedded migration files, for Lint.
+func Files() fs.FS {
+	sub, err := fs.Sub(files, "sql")
+	if err != nil {
+		panic(err)
+	}
+	return sub
+}
+
+// The statements Lint looks for, matched against masked SQL.
+var (
+	createTablePattern = regexp.MustCompile(`(?i)\bCREATE\s+(?:UNLOGGED\s+TABLE|TABLE|MATERIALIZED\s+VIEW)\s+(?:IF\s+NOT\s+EXISTS\s+)?([\w."]+)`)
+	alterTablePattern  = regexp.MustCompile(`(?i)^\s*ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?([\w."]+)`)
+	createIndexPattern = regexp.MustCompile(`(?i)\bCREATE\s+(?:UNIQUE\s+)?INDEX\s+(CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(?:[\w."]+\s+)?ON\s+(?:ONLY\s+)?([\w."]+)`)
+	dropPattern        = regexp.MustCompile(`(?i)\bDROP\s+(TABLE|INDEX|VIEW|MATERIALIZED\s+VIEW|FUNCTION|PROCEDURE|TRIGGER|TYPE|DOMAIN|SEQUENCE|SCHEMA|EXTENSION|POLICY|COLUMN|CONSTRAINT)\s+(?:CONCURRENTLY\s+)?(IF\s+EXISTS\b)?`)
+	addColumnPattern   = regexp.MustCompile(`(?i)\bADD\s+(?:COLUMN\s+)?(?:IF\s+NOT\s+EXISTS\s+)?([\w"]+)\s`)
+	volatilePattern    = regexp.MustCompile(`(?is)\bDEFAULT\b.*\b(random|gen_random_uuid|uuid_generate_v[14]|clock_timestamp|timeofday|nextval)\s*\(|\b(?:small|big)?serial\b`)
+)
+
+// addConstraintWords are the words after ADD that add a constraint rather
+// than a column.
+var addConstraintWords = []string{"constraint", "primary", "unique", "check", "foreign", "exclude"}
+
+// Lint checks the migration files in the root of fsys for mistakes that
+// do not show until they run, or until they run on a large table:
+//
+//   - misnamed files, duplicate versions and gaps in the numbering
+//   - up files without a down file, and down files without an up file
+//   - CREATE, DROP or REINDEX INDEX CONCURRENTLY without the
+//     "-- no-transaction" marker, which PostgreSQL refuses in a transaction
+//   - CREATE INDEX without CONCURRENTLY on a table the file does not
+//     create, which blocks writes to it while the index builds
+//   - DROP without IF EXISTS
+//   - ADD COLUMN with a volatile default, such as random() or a serial
+//     type, on a table the file does not create, which rewrites the table
+//     under an ACCESS EXCLUSIVE lock
+//
+// The statements of versions below since are not checked: migrations
+// applied before Lint existed cannot be changed, because their checksums
+// are recorded (see Verify). LintFrom is the version from which the
+// embedded migrations are expected to pass. The problems are returned in
+// file and line order.
+func Lint(fsys fs.FS, since int64) ([]Problem, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	ups := map[int64]string{}
+	downs := map[int64]string{}
+	bodies := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || path.Ext(e.Name()) != ".sql" {
+			continue
+		}
+		m := fileNamePattern.FindStringSubmatch(e.Name())
+		if m == nil {
+			problems = append(problems, Problem{File: e.Name(), Message: "not named NNNN_description.sql or NNNN_description.down.sql"})
+			continue
+		}
+		version, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			problems = append(problems, Problem{File: e.Name(), Message: err.Error()})
+			continue
+		}
+		body, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		bodies[e.Name()] = string(body)
+		byVersion := ups
+		if m[3] != "" {
+			byVersion = downs
+		}
+		if other, dup := byVersion[version]; dup {
+			problems = append(problems, Problem{File: e.Name(), Message: fmt.Sprintf("version %d is used by %s too", version, other)})
+			continue
+		}
+		byVersion[version] = e.Name()
+	}
+
+	versions := slices.Sorted(func(yield func(int64) bool) {
+		for v := range ups {
+			if !yield(v) {
+				return
+			}
+		}
+	})
+	for i, v := range versions {
+		if i > 0 && v != versions[i-1]+1 {
+			problems = append(problems, Problem{File: ups[v], Message: fmt.Sprintf("version %d follows %d; the versions between are missing", v, versions[i-1])})
+		}
+		if _, ok := downs[v]; !ok {
+			problems = append(problems, Problem{File: ups[v], Message: "has no down file, so it cannot be rolled back"})
+		}
+	}
+	for v, name := range downs {
+		if _, ok := ups[v]; !ok {
+			problems = append(problems, Problem{File: name, Message: "has no up file"})
+		}
+	}
+
+	for v, name := range ups {
+		if v < since {
+			continue
+		}
+		noTx := hasMarker(bodies[name], noTransactionMarker)
+		problems = append(problems, lintFile(name, bodies[name], noTx)...)
+		if down, ok := downs[v]; ok {
+			problems = append(problems, lintFile(down, bodies[down], noTx)...)
+		}
+	}
+	slices.SortFunc(problems, func(a, b Problem) int {
+		if c := strings.Compare(a.File, b.File); c != 0 {
+			return c
+		}
+		return a.Line - b.Line
+	})
+	return problems, nil
+}
+
+// lintFile checks the statements of the migration file name. noTx tells
+// whether its migration carries the no-transaction marker.
+func lintFile(name, body string, noTx bool) []Problem {
+	var problems []Problem
+	report := func(offset int, format string, args ...any) {
+		line := 1 + strings.Count(body[:offset], "\n")
+		problems = append(problems, Problem{File: name, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	// Tables created by dynamic SQL in a DO block count too
+	created := map[string]bool{}
+	for _, m := range createTablePattern.FindAllStringSubmatch(body, -1) {
+		created[tableName(m[1])] = true
+	}
+
+	masked := mask(body)
+	for _, span := range statementSpans(body, masked) {
+		stmt := masked[span[0]:span[1]]
+		at := func(i int) int { return span[0] + i }
+
+		if loc := concurrentlyPattern.FindStringIndex(stmt); loc != nil && !noTx {
+			report(at(loc[0]), "INDEX CONCURRENTLY cannot run in a transaction; put %q on the first line of the up file", noTransactionMarker)
+		}
+		for _, m := range createIndexPattern.FindAllStringSubmatchIndex(stmt, -1) {
+			table := tableName(body[at(m[4]):at(m[5])])
+			if m[2] < 0 && !created[table] {
+				report(at(m[0]), "CREATE INDEX on %s blocks writes to it while the index builds; use CREATE INDEX CONCURRENTLY in a %q migration", table, noTransactionMarker)
+			}
+		}
+		for _, m := range dropPattern.FindAllStringSubmatchIndex(stmt, -1) {
+			if m[4] < 0 {
+				report(at(m[0]), "DROP %s without IF EXISTS fails when the object is already gone", strings.ToUpper(strings.Join(strings.Fields(stmt[m[2]:m[3]]), " ")))
+			}
+		}
+		if m := alterTablePattern.FindStringSubmatchIndex(stmt); m != nil {
+			table := tableName(body[at(m[2]):at(m[3])])
+			if created[table] {
+				continue
+			}
+			for _, m := range addColumnPattern.FindAllStringSubmatchIndex(stmt, -1) {
+				if slices.Contains(addConstraintWords, strings.ToLower(stmt[m[2]:m[3]])) {
+					continue
+				}
+				if volatilePattern.MatchString(columnDefinition(stmt[m[1]:])) {
+					report(at(m[0]), "ADD COLUMN %s with a volatile default rewrites %s under an ACCESS EXCLUSIVE lock; add the column without it, backfill in batches, then set the default", stmt[m[2]:m[3]], table)
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// columnDefinition returns the start of s up to the first comma outside
+// parentheses: the rest of an ADD COLUMN clause.
+func columnDefinition(s string) string {
+	depth := 0
+	for i, c := range s {
+		switch {
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// tableName returns name without its schema and quotes, in lower case
+// unless it was quoted.
+func tableName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		name = name[i+1:]
+	}
+	if unquoted, ok := strings.CutPrefix(name, `"`); ok {
+		return strings.TrimSuffix(unquoted, `"`)
+	}
+	return strings.ToLower(name)
+}
@@ -0,0 +1,104 @@
+package migrations
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLint(t *testing.T) {
+	file := func(body string) *fstest.MapFile { return &fstest.MapFile{Data: []byte(body)} }
+	fsys := fstest.MapFS{
+		"0001_create_things.sql": file(`CREATE TABLE things (id BIGSERIAL PRIMARY KEY, name TEXT);
+-- A new table may be indexed in its own transaction
+CREATE INDEX things_name_idx ON things (name);
+ALTER TABLE things ADD COLUMN token UUID DEFAULT gen_random_uuid();
+`),
+		"0001_create_things.down.sql": file("DROP TABLE IF EXISTS things;\n"),
+		"0002_index_things.sql": file(`-- Looks things up by name
+CREATE INDEX things_lower_name_idx
+	ON things (lower(name));
+CREATE INDEX CONCURRENTLY IF NOT EXISTS things_id_idx ON things (id);
+`),
+		"0002_index_things.down.sql": file("DROP INDEX things_lower_name_idx;\nDROP INDEX IF EXISTS things_id_idx;\n"),
+		"0003_add_things_columns.sql": file(`ALTER TABLE things
+	ADD COLUMN price NUMERIC(10, 2) DEFAULT random(),
+	ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	ADD COLUMN seq BIGSERIAL,
+	ADD CONSTRAINT things_name_key UNIQUE (name),
+	ADD COLUMN note TEXT DEFAULT 'random()';
+`),
+		"0004_things_concurrently.sql": file(`-- postgres-only
+-- no-transaction
+CREATE INDEX CONCURRENTLY IF NOT EXISTS things_price_idx ON things (price);
+`),
+		"0004_things_concurrently.down.sql": file("DROP INDEX CONCURRENTLY IF EXISTS things_price_idx;\n"),
+		"0006_drop_things.sql":              file("/* DROP TABLE things; */ ALTER TABLE things DROP COLUMN note;\n"),
+		"0006_drop_things.down.sql":         file("ALTER TABLE things ADD COLUMN IF NOT EXISTS note TEXT;\n"),
+		"0007_dup.sql":                      file("SELECT 1;\n"),
+		"0007_other.sql":                    file("SELECT 2;\n"),
+		"0007_dup.down.sql":                 file("SELECT 1;\n"),
+		"0008_missing_up.down.sql":          file("SELECT 1;\n"),
+		"0009_reindex.sql":                  file("REINDEX INDEX CONCURRENTLY things_name_idx;\n"),
+		"0009_reindex.down.sql":             file("SELECT 1;\n"),
+		"readme.txt":                        file("not a migration"),
+		"9_Bad Name.sql":                    file("SELECT 1;\n"),
+	}
+	problems, err := Lint(fsys, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range problems {
+		got = append(got, p.String())
+	}
+	// Not flagged: the index and the volatile default on the table 0001
+	// creates, DEFAULT now(), the string 'random()', the constraint, and
+	// the DROP TABLE in a comment
+	want := []string{
+		"0002_index_things.down.sql:1: DROP INDEX without IF EXISTS",
+		"0002_index_things.sql:2: CREATE INDEX on things blocks writes",
+		"0002_index_things.sql:4: INDEX CONCURRENTLY cannot run in a transaction",
+		"0003_add_things_columns.sql: has no down file",
+		"0003_add_things_columns.sql:2: ADD COLUMN price with a volatile default rewrites things",
+		"0003_add_things_columns.sql:4: ADD COLUMN seq with a volatile default",
+		"0006_drop_things.sql: version 6 follows 4",
+		"0006_drop_things.sql:1: DROP COLUMN without IF EXISTS",
+		"0007_other.sql: version 7 is used by 0007_dup.sql too",
+		"0008_missing_up.down.sql: has no up file",
+		"0009_reindex.sql: version 9 follows 7",
+		"0009_reindex.sql:1: INDEX CONCURRENTLY cannot run in a transaction",
+		"9_Bad Name.sql: not named NNNN_description.sql",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("problems:\n%s\nwant %d", strings.Join(got, "\n"), len(want))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("problem %d = %q, want one starting %q", i, got[i], want[i])
+		}
+	}
+
+	// Older versions keep their file-level checks only
+	problems, err = Lint(fsys, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.ContainsFunc(problems, func(p Problem) bool { return p.Line > 0 && p.File < "0004" }) {
+		t.Errorf("statements below version 4 checked: %v", problems)
+	}
+	if !slices.ContainsFunc(problems, func(p Problem) bool { return p.File == "0003_add_things_columns.sql" && p.Line == 0 }) {
+		t.Errorf("missing down file below version 4 not reported: %v", problems)
+	}
+}
+
+func TestLintEmbedded(t *testing.T) {
+	problems, err := Lint(Files(), LintFrom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Error(p)
+	}
+}
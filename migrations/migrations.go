@@ -0,0 +1,812 @@
+// Package migrations applies the versioned SQL files embedded in sql/ and
+// records each applied version in a schema_migrations table.
+//
+// Files are named NNNN_description.sql; the number is the version and
+// migrations run in ascending order, each in its own transaction. An optional
+// NNNN_description.down.sql next to it reverts the change, which makes the
+// version eligible for rollback. Statements are written without a schema: the
+// transaction's search_path is set to the target schema, so the same files
+// serve any DB_SCHEMA.
+//
+// A migration whose first line is "-- postgres-only" uses features
+// CockroachDB lacks; there it is recorded as applied without running.
+//
+// A migration headed "-- no-transaction" runs outside any transaction, for
+// statements PostgreSQL refuses inside one such as CREATE INDEX
+// CONCURRENTLY. Its statements are sent one at a time on a single session,
+// so a failure may leave some of them done; write each so that it can be
+// run again, e.g. with IF NOT EXISTS. The marker may follow
+// "-- postgres-only" or stand on the first line itself.
+//
+// Each applied version is recorded with a checksum of its file, and a plan
+// is refused while a recorded checksum differs from the file's, so an
+// applied migration that was edited afterwards is noticed rather than left
+// to diverge between databases. A no-transaction migration is recorded as
+// dirty while it runs; if it fails, the record stays dirty and plans are
+// refused until Force or Repair resolves it. A migration run in a
+// transaction is never dirty, since a failure leaves nothing of it behind.
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// Migration is one versioned schema change.
+type Migration struct {
+	Version int64
+	Name    string // file name without the .sql extension, e.g. 0001_create_users
+	SQL     string
+	DownSQL string // empty when the migration cannot be rolled back
+	// PostgresOnly marks a migration that is skipped on CockroachDB.
+	PostgresOnly bool
+	// NoTransaction marks a migration that runs outside a transaction, in
+	// both directions.
+	NoTransaction bool
+	// Checksum is the SHA-256 of SQL, in hex, with line endings normalized.
+	Checksum string
+}
+
+// The markers a migration may carry on its leading comment lines.
+const (
+	postgresOnlyMarker  = "-- postgres-only"
+	noTransactionMarker = "-- no-transaction"
+)
+
+// hasMarker reports whether marker is one of the comment lines that open
+// body.
+func hasMarker(body, marker string) bool {
+	for line := range strings.Lines(body) {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			return false
+		}
+		if line == marker {
+			return true
+		}
+	}
+	return false
+}
+
+// Step is a migration to run in one direction.
+type Step struct {
+	Migration
+	Down bool
+}
+
+// Statements returns the SQL the step executes.
+func (s Step) Statements() string {
+	if s.Down {
+		return s.DownSQL
+	}
+	return s.SQL
+}
+
+func (s Step) String() string {
+	if s.Down {
+		return "down " + s.Name
+	}
+	return "up " + s.Name
+}
+
+// DB is what migrations need from the database. *pgxpool.Pool,
+// *pgxpool.Conn and *pgx.Conn satisfy it, and so does pgx.Tx: each
+// migration then runs in a savepoint of that transaction and commits or
+// rolls back with it, a NoTransaction one included (see run).
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// fileNamePattern matches migration file names and captures the version,
+// the name and whether the file is a down migration.
+var fileNamePattern = regexp.MustCompile(`^((\d+)_[a-z0-9_]+?)(\.down)?\.sql$`)
+
+// LockKey is the advisory lock taken while migrating, so two processes
+// starting at once do not apply the same migration twice. A caller that
+// holds it already, in the same session, for work that must not interleave
+// with another process's migrations, is granted it again at once.
+const LockKey = 7_353_820_441
+
+// createTrackingTableSQL creates the table that records applied versions,
+// and adds the checksum and dirty columns to one created before they were.
+const createTrackingTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	checksum TEXT,
+	dirty BOOLEAN NOT NULL DEFAULT false
+);
+ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS checksum TEXT;
+ALTER TABLE schema_migrations ADD COLUMN IF NOT EXISTS dirty BOOLEAN NOT NULL DEFAULT false`
+
+// All returns every embedded migration in version order. It fails on
+// misnamed files, duplicate versions and down files without an up file,
+// which are programming errors.
+func All() ([]Migration, error) {
+	entries, err := fs.ReadDir(files, "sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int64]*Migration{}
+	downs := map[int64]string{}
+	for _, e := range entries {
+		m := fileNamePattern.FindStringSubmatch(e.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration file %q is not named NNNN_description.sql or NNNN_description.down.sql", e.Name())
+		}
+		version, err := strconv.ParseInt(m[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %q: %w", e.Name(), err)
+		}
+		body, err := files.ReadFile(path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if m[3] != "" {
+			downs[version] = string(body)
+			continue
+		}
+		if other, dup := byVersion[version]; dup {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", other.Name, m[1], version)
+		}
+		byVersion[version] = &Migration{
+			Version:       version,
+			Name:          m[1],
+			SQL:           string(body),
+			PostgresOnly:  hasMarker(string(body), postgresOnlyMarker),
+			NoTransaction: hasMarker(string(body), noTransactionMarker),
+			Checksum:      checksum(string(body)),
+		}
+	}
+	for version, body := range downs {
+		m, ok := byVersion[version]
+		if !ok {
+			return nil, fmt.Errorf("down migration for version %d has no matching up migration", version)
+		}
+		m.DownSQL = body
+	}
+
+	out := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		out = append(out, *m)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// checksum returns the Checksum of a migration file. Line endings are
+// normalized so that a checkout with CRLF endings agrees with one without.
+func checksum(body string) string {
+	sum := sha256.Sum256([]byte(strings.ReplaceAll(body, "\r\n", "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Latest returns the highest embedded version, i.e. the version of the
+// schema this build expects.
+func Latest() int64 {
+	all, err := All()
+	if err != nil || len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+// Record is a version recorded in schema_migrations.
+type Record struct {
+	Version int64
+	Name    string
+	// Checksum is that of the file the version was applied from; empty for
+	// a version applied before checksums were recorded.
+	Checksum string
+	// Dirty marks a no-transaction migration that started and did not
+	// finish, in either direction.
+	Dirty bool
+}
+
+// Records returns the versions recorded in schema, in ascending order. It
+// returns nothing when schema_migrations does not exist yet. A table that
+// predates the checksum and dirty columns reads as clean, without
+// checksums.
+func Records(ctx context.Context, db DB, schema string) ([]Record, error) {
+	table := pgx.Identifier{schema, "schema_migrations"}.Sanitize()
+	var exists bool
+	if err := db.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, nil
+	}
+	var columns int
+	err := db.QueryRow(ctx, `SELECT count(*) FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = 'schema_migrations' AND column_name IN ('checksum', 'dirty')`, schema).Scan(&columns)
+	if err != nil {
+		return nil, err
+	}
+	query := "SELECT version, name, '' AS checksum, false AS dirty FROM " + table + " ORDER BY version"
+	if columns == 2 {
+		query = "SELECT version, name, coalesce(checksum, '') AS checksum, dirty FROM " + table + " ORDER BY version"
+	}
+	rows, err := db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.CollectRows(rows, pgx.RowToStructByPos[Record])
+}
+
+// Applied returns the versions recorded in schema, in ascending order,
+// dirty ones included. It returns nothing when schema_migrations does not
+// exist yet.
+func Applied(ctx context.Context, db DB, schema string) ([]int64, error) {
+	records, err := Records(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]int64, len(records))
+	for i, r := range records {
+		versions[i] = r.Version
+	}
+	return versions, nil
+}
+
+// Current returns the highest version applied in schema, or 0 when nothing
+// has been applied (including when schema_migrations does not exist yet).
+func Current(ctx context.Context, db DB, schema string) (int64, error) {
+	applied, err := Applied(ctx, db, schema)
+	if err != nil || len(applied) == 0 {
+		return 0, err
+	}
+	return applied[len(applied)-1], nil
+}
+
+// ErrNoDownMigration is returned when a rollback would need to revert a
+// migration that has no .down.sql file.
+var ErrNoDownMigration = errors.New("migration has no down migration")
+
+// ErrDirty is returned when a migration is recorded as dirty: it failed part
+// way, and the schema holds some of its changes.
+var ErrDirty = errors.New("migration is dirty")
+
+// ErrChecksumMismatch is returned when an applied migration's file has
+// changed since it was applied.
+var ErrChecksumMismatch = errors.New("applied migration has been modified")
+
+// Verify fails with ErrDirty when a version recorded in schema is dirty,
+// and with ErrChecksumMismatch when one was applied from a file other than
+// the one this build embeds. Versions without a checksum, and versions this
+// build does not embed, are not compared.
+func Verify(ctx context.Context, db DB, schema string) error {
+	all, err := All()
+	if err != nil {
+		return err
+	}
+	records, err := Records(ctx, db, schema)
+	if err != nil {
+		return err
+	}
+	var modified []string
+	for _, r := range records {
+		if r.Dirty {
+			return fmt.Errorf("%w: %s failed part way; complete or undo its changes by hand and run migrate force %d or %d, or run migrate repair to have it run again",
+				ErrDirty, r.Name, r.Version, previousVersion(all, r.Version))
+		}
+		i := slices.IndexFunc(all, func(m Migration) bool { return m.Version == r.Version })
+		if i >= 0 && r.Checksum != "" && r.Checksum != all[i].Checksum {
+			modified = append(modified, r.Name)
+		}
+	}
+	if len(modified) > 0 {
+		return fmt.Errorf("%w: %s changed after being applied; restore the file, or run migrate repair to accept the change",
+			ErrChecksumMismatch, strings.Join(modified, ", "))
+	}
+	return nil
+}
+
+// previousVersion returns the version that precedes version in all, or 0.
+func previousVersion(all []Migration, version int64) int64 {
+	var prev int64
+	for _, m := range all {
+		if m.Version >= version {
+			break
+		}
+		prev = m.Version
+	}
+	return prev
+}
+
+// PlanUp returns the steps that apply every pending migration.
+func PlanUp(ctx context.Context, db DB, schema string) ([]Step, error) {
+	return PlanTo(ctx, db, schema, Latest())
+}
+
+// PlanDown returns the steps that roll back the n most recently applied
+// migrations. Asking for more than are applied is an error rather than a
+// partial rollback, so the schema can never be taken below version 0.
+func PlanDown(ctx context.Context, db DB, schema string, n int) ([]Step, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("cannot roll back %d migrations: the count must be at least 1", n)
+	}
+	applied, err := Applied(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	if n > len(applied) {
+		return nil, fmt.Errorf("cannot roll back %d migrations: only %d applied, and the schema cannot go below version 0", n, len(applied))
+	}
+	target := int64(0)
+	if n < len(applied) {
+		target = applied[len(applied)-n-1]
+	}
+	return PlanTo(ctx, db, schema, target)
+}
+
+// PlanTo returns the steps that bring the schema to exactly target: pending
+// migrations up to and including target are applied in ascending order, and
+// applied migrations above it are rolled back in descending order. Target 0
+// rolls back everything. A schema that fails Verify has no plan.
+func PlanTo(ctx context.Context, db DB, schema string, target int64) ([]Step, error) {
+	if target < 0 {
+		return nil, fmt.Errorf("cannot migrate to version %d: the schema cannot go below version 0", target)
+	}
+	all, err := All()
+	if err != nil {
+		return nil, err
+	}
+	if target != 0 && !slices.ContainsFunc(all, func(m Migration) bool { return m.Version == target }) {
+		return nil, fmt.Errorf("no migration has version %d", target)
+	}
+	if err := Verify(ctx, db, schema); err != nil {
+		return nil, err
+	}
+	applied, err := Applied(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []Step
+	for _, m := range all {
+		if m.Version <= target && !slices.Contains(applied, m.Version) {
+			steps = append(steps, Step{Migration: m})
+		}
+	}
+	for i := len(all) - 1; i >= 0; i-- {
+		m := all[i]
+		if m.Version > target && slices.Contains(applied, m.Version) {
+			if m.DownSQL == "" {
+				return nil, fmt.Errorf("%w: %s", ErrNoDownMigration, m.Name)
+			}
+			steps = append(steps, Step{Migration: m, Down: true})
+		}
+	}
+	return steps, nil
+}
+
+// Run executes steps in order and returns the ones it ran. Each step runs in
+// its own transaction together with its schema_migrations change, so a
+// failure leaves the schema at the last fully applied version. Steps made
+// moot by a concurrent migrator are skipped.
+func Run(ctx context.Context, db DB, schema string, steps []Step) ([]Step, error) {
+	var ran []Step
+	for _, s := range steps {
+		done, err := run(ctx, db, schema, s)
+		if err != nil {
+			return ran, fmt.Errorf("migration %s: %w", s, err)
+		}
+		if done {
+			ran = append(ran, s)
+		}
+	}
+	return ran, nil
+}
+
+// Up applies every pending migration in schema and returns the ones it applied.
+func Up(ctx context.Context, db DB, schema string) ([]Step, error) {
+	steps, err := PlanUp(ctx, db, schema)
+	if err != nil {
+		return nil, err
+	}
+	return Run(ctx, db, schema, steps)
+}
+
+// Force records schema as being at exactly version, without running
+// anything: the embedded migrations up to version become applied and
+// clean, with the checksums of this build, and every version above it is
+// forgotten. It is the way out of a dirty migration whose changes were
+// completed (force its version) or undone (force the one before) by hand.
+func Force(ctx context.Context, db DB, schema string, version int64) error {
+	if version < 0 {
+		return fmt.Errorf("cannot force version %d: the schema cannot go below version 0", version)
+	}
+	all, err := All()
+	if err != nil {
+		return err
+	}
+	if version != 0 && !slices.ContainsFunc(all, func(m Migration) bool { return m.Version == version }) {
+		return fmt.Errorf("no migration has version %d", version)
+	}
+	return tracking(ctx, db, schema, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version > $1", version); err != nil {
+			return err
+		}
+		for _, m := range all {
+			if m.Version > version {
+				break
+			}
+			_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)
+				ON CONFLICT (version) DO UPDATE SET name = excluded.name, checksum = excluded.checksum, dirty = false`, m.Version, m.Name, m.Checksum)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Repaired is what Repair changed.
+type Repaired struct {
+	// Removed names the dirty migrations that were forgotten.
+	Removed []string
+	// Realigned names the applied migrations whose checksum was recorded
+	// anew, because it differed from this build's or was missing.
+	Realigned []string
+}
+
+// Repair forgets the dirty migrations in schema, so the next plan runs them
+// again, and records this build's checksum for every other applied
+// migration it embeds. Nothing else is run: the statements a dirty
+// migration did finish stay, which its IF NOT EXISTS clauses expect.
+func Repair(ctx context.Context, db DB, schema string) (Repaired, error) {
+	var done Repaired
+	all, err := All()
+	if err != nil {
+		return done, err
+	}
+	err = tracking(ctx, db, schema, func(tx pgx.Tx) error {
+		records, err := Records(ctx, tx, schema)
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if r.Dirty {
+				if _, err := tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", r.Version); err != nil {
+					return err
+				}
+				done.Removed = append(done.Removed, r.Name)
+				continue
+			}
+			i := slices.IndexFunc(all, func(m Migration) bool { return m.Version == r.Version })
+			if i < 0 || r.Checksum == all[i].Checksum {
+				continue
+			}
+			if _, err := tx.Exec(ctx, "UPDATE schema_migrations SET checksum = $2 WHERE version = $1", r.Version, all[i].Checksum); err != nil {
+				return err
+			}
+			done.Realigned = append(done.Realigned, r.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return Repaired{}, err
+	}
+	return done, nil
+}
+
+// tracking runs fn in a transaction of db that holds the migration lock and
+// has the tracking table of schema on its search_path.
+func tracking(ctx context.Context, db DB, schema string, fn func(tx pgx.Tx) error) error {
+	return pgx.BeginFunc(ctx, db, func(tx pgx.Tx) error {
+		if tx.Conn().PgConn().ParameterStatus("crdb_version") == "" {
+			if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(LockKey)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+pgx.Identifier{schema}.Sanitize()); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, createTrackingTableSQL); err != nil {
+			return err
+		}
+		return fn(tx)
+	})
+}
+
+// run executes s unless the recorded state already reflects it, reporting
+// whether it ran.
+//
+// A NoTransaction step runs outside a transaction (see runOutsideTx) unless
+// db is one already. Then it runs in a savepoint like any other, with
+// CONCURRENTLY dropped from its index statements: the index is built while
+// writes to its table wait, as it would be in a plain migration. That is
+// the quickstart's case, which migrates in the transaction that seeds.
+func run(ctx context.Context, db DB, schema string, s Step) (bool, error) {
+	stmts := s.Statements()
+	if s.NoTransaction {
+		if _, inTx := db.(pgx.Tx); !inTx {
+			return runOutsideTx(ctx, db, schema, s)
+		}
+		stmts = concurrentlyPattern.ReplaceAllString(stmts, "INDEX")
+	}
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Held until commit; concurrent migrators queue here and then see the
+	// step as already done. CockroachDB has no advisory locks, but its
+	// transactions are serializable, so the second migrator to write the
+	// tracking row is aborted instead
+	crdb := tx.Conn().PgConn().ParameterStatus("crdb_version") != ""
+	if !crdb {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", int64(LockKey)); err != nil {
+			return false, err
+		}
+	}
+	if _, err := tx.Exec(ctx, "SET LOCAL search_path TO "+pgx.Identifier{schema}.Sanitize()); err != nil {
+		return false, err
+	}
+	// Building an index on a large table may take longer than any
+	// statement_timeout meant for queries; lock_timeout stays in force, so
+	// a migration blocked behind a long transaction still gives up
+	if _, err := tx.Exec(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, createTrackingTableSQL); err != nil {
+		return false, err
+	}
+	var applied bool
+	if err := tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", s.Version).Scan(&applied); err != nil {
+		return false, err
+	}
+	if applied != s.Down {
+		return false, nil
+	}
+	if !crdb || !s.PostgresOnly {
+		if _, err := tx.Exec(ctx, stmts); err != nil {
+			return false, err
+		}
+	}
+	if s.Down {
+		_, err = tx.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", s.Version)
+	} else {
+		_, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)", s.Version, s.Name, s.Checksum)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
+
+// concurrentlyPattern matches the CONCURRENTLY of CREATE, DROP and REINDEX
+// INDEX statements, which may not run in a transaction.
+var concurrentlyPattern = regexp.MustCompile(`(?i)\bINDEX\s+CONCURRENTLY\b`)
+
+// runOutsideTx executes the NoTransaction step s on a single session of db,
+// one statement at a time, unless the recorded state already reflects it.
+// The session takes the advisory lock, search_path and statement_timeout a
+// transaction would set with SET LOCAL, and gives them back before the
+// connection is returned.
+//
+// The tracking row is marked dirty before the statements run, inserted so
+// for an up step, and made clean, or deleted for a down step, once they
+// are done. A failure part way leaves the row dirty with some of the
+// statements done, and plans are refused until Force or Repair resolves
+// it. A CREATE INDEX CONCURRENTLY that fails leaves an invalid index
+// behind, which IF NOT EXISTS would then keep, so the step refuses to run
+// while the schema has one.
+func runOutsideTx(ctx context.Context, db DB, schema string, s Step) (done bool, err error) {
+	conn, release, err := session(ctx, db)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	crdb := conn.PgConn().ParameterStatus("crdb_version") != ""
+	if !crdb {
+		if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", int64(LockKey)); err != nil {
+			return false, err
+		}
+	}
+	defer func() {
+		ctx := context.WithoutCancel(ctx)
+		reset := "RESET search_path; RESET statement_timeout"
+		if !crdb {
+			reset += fmt.Sprintf("; SELECT pg_advisory_unlock(%d)", int64(LockKey))
+		}
+		if _, resetErr := conn.Exec(ctx, reset); resetErr != nil {
+			// Back in a pool, the session would keep the lock and the
+			// schema of this migration
+			conn.Close(ctx)
+			if err == nil {
+				err = fmt.Errorf("restoring the session: %w", resetErr)
+			}
+		}
+	}()
+	if _, err := conn.Exec(ctx, "SET search_path TO "+pgx.Identifier{schema}.Sanitize()); err != nil {
+		return false, err
+	}
+	if _, err := conn.Exec(ctx, "SET statement_timeout = 0"); err != nil {
+		return false, err
+	}
+	if _, err := conn.Exec(ctx, createTrackingTableSQL); err != nil {
+		return false, err
+	}
+	var applied bool
+	if err := conn.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", s.Version).Scan(&applied); err != nil {
+		return false, err
+	}
+	if applied != s.Down {
+		return false, nil
+	}
+	if !crdb && !s.Down {
+		if err := checkInvalidIndexes(ctx, conn, schema); err != nil {
+			return false, err
+		}
+	}
+	if s.Down {
+		_, err = conn.Exec(ctx, "UPDATE schema_migrations SET dirty = true WHERE version = $1", s.Version)
+	} else {
+		_, err = conn.Exec(ctx, "INSERT INTO schema_migrations (version, name, checksum, dirty) VALUES ($1, $2, $3, true) ON CONFLICT (version) DO NOTHING", s.Version, s.Name, s.Checksum)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !crdb || !s.PostgresOnly {
+		for _, stmt := range splitStatements(s.Statements()) {
+			if _, err := conn.Exec(ctx, stmt); err != nil {
+				if !crdb {
+					if invalid := checkInvalidIndexes(ctx, conn, schema); invalid != nil {
+						err = fmt.Errorf("%w; %w", err, invalid)
+					}
+				}
+				return false, err
+			}
+		}
+	}
+	if s.Down {
+		_, err = conn.Exec(ctx, "DELETE FROM schema_migrations WHERE version = $1", s.Version)
+	} else {
+		_, err = conn.Exec(ctx, "UPDATE schema_migrations SET dirty = false, applied_at = now() WHERE version = $1", s.Version)
+	}
+	return err == nil, err
+}
+
+// session returns a connection of db to run statements on outside a
+// transaction, and the function that gives it back.
+func session(ctx context.Context, db DB) (*pgx.Conn, func(), error) {
+	switch d := db.(type) {
+	case *pgx.Conn:
+		return d, func() {}, nil
+	case *pgxpool.Conn:
+		return d.Conn(), func() {}, nil
+	case *pgxpool.Pool:
+		c, err := d.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.Conn(), c.Release, nil
+	}
+	return nil, nil, fmt.Errorf("cannot run outside a transaction on a %T", db)
+}
+
+// checkInvalidIndexes fails when schema has indexes marked invalid, which
+// a failed CREATE INDEX CONCURRENTLY leaves behind, naming them.
+func checkInvalidIndexes(ctx context.Context, conn *pgx.Conn, schema string) error {
+	rows, err := conn.Query(ctx, `SELECT c.relname FROM pg_index i
+		JOIN pg_class c ON c.oid = i.indexrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND NOT i.indisvalid
+		ORDER BY c.relname`, schema)
+	if err != nil {
+		return err
+	}
+	names, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil || len(names) == 0 {
+		return err
+	}
+	return fmt.Errorf("invalid index %s left by a failed concurrent build; drop it with DROP INDEX CONCURRENTLY and migrate again", strings.Join(names, ", "))
+}
+
+// splitStatements splits sql into its statements at the semicolons outside
+// comments, quoted strings and identifiers, and dollar-quoted bodies. Parts
+// that hold nothing but comments and space are dropped.
+func splitStatements(sql string) []string {
+	var stmts []string
+	for _, span := range statementSpans(sql, mask(sql)) {
+		stmts = append(stmts, sql[span[0]:span[1]])
+	}
+	return stmts
+}
+
+// statementSpans returns the start and end offsets in sql of its
+// statements, trimmed of space, given masked = mask(sql).
+func statementSpans(sql, masked string) [][2]int {
+	var spans [][2]int
+	start := 0
+	for i := 0; i <= len(masked); i++ {
+		if i < len(masked) && masked[i] != ';' {
+			continue
+		}
+		if strings.TrimSpace(masked[start:i]) != "" {
+			part := sql[start:i]
+			from := start + len(part) - len(strings.TrimLeftFunc(part, unicode.IsSpace))
+			spans = append(spans, [2]int{from, start + len(strings.TrimRightFunc(part, unicode.IsSpace))})
+		}
+		start = i + 1
+	}
+	return spans
+}
+
+// mask returns sql with its comments blanked out, and the insides of its
+// quoted strings and identifiers and dollar-quoted bodies too, keeping the
+// quotes and tags. Newlines stay, so offsets and line numbers in the result
+// are those of sql, and only code is left to search.
+func mask(sql string) string {
+	out := []byte(sql)
+	blank := func(from, to int) {
+		for j := from; j < to; j++ {
+			if out[j] != '\n' {
+				out[j] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case strings.HasPrefix(sql[i:], "--"):
+			end := len(sql)
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				end = i + j
+			}
+			blank(i, end)
+			i = end
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := len(sql)
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				end = i + j + 4
+			}
+			blank(i, end)
+			i = end - 1
+		case c == '\'' || c == '"':
+			// A doubled quote inside reads as a close and a reopen
+			end := len(sql)
+			if j := strings.IndexByte(sql[i+1:], c); j >= 0 {
+				end = i + 1 + j
+			}
+			blank(i+1, end)
+			i = end
+		case c == '$' && dollarTagPattern.MatchString(sql[i:]):
+			tag := dollarTagPattern.FindString(sql[i:])
+			end := len(sql)
+			if j := strings.Index(sql[i+len(tag):], tag); j >= 0 {
+				end = i + len(tag) + j
+			}
+			blank(i+len(tag), end)
+			i = min(end+len(tag), len(sql)) - 1
+		}
+	}
+	return string(out)
+}
+
+// dollarTagPattern matches the opening of a dollar-quoted string: $$ or
+// $tag$.
+var dollarTagPattern = regexp.MustCompile(`^\$([A-Za-z_][A-Za-z0-9_]*)?\$`)
@@ -0,0 +1,122 @@
+package migrations
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHasMarker(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       bool
+	}{
+		{"first line", "-- no-transaction\nCREATE INDEX CONCURRENTLY i ON t (c);", true},
+		{"after other comments", "-- Adds an index.\n-- postgres-only\n  -- no-transaction  \r\nCREATE INDEX CONCURRENTLY i ON t (c);", true},
+		{"after a statement", "CREATE INDEX i ON t (c);\n-- no-transaction\n", false},
+		{"prefix of a longer comment", "-- no-transaction needed here\nSELECT 1;", false},
+		{"inside a block comment", "/* -- no-transaction */\nSELECT 1;", false},
+		{"none", "SELECT 1;", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasMarker(tt.body, noTransactionMarker); got != tt.want {
+				t.Errorf("hasMarker(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllMarksNoTransaction(t *testing.T) {
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	marked := map[string]bool{}
+	for _, m := range all {
+		marked[m.Name] = m.NoTransaction
+	}
+	if !marked["0016_add_users_lower_email_idx"] {
+		t.Error("the CONCURRENTLY index of 0016 runs in a transaction")
+	}
+	if marked["0001_create_users"] {
+		t.Error("0001 runs outside a transaction")
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name, sql string
+		want      []string
+	}{
+		{"one", "SELECT 1", []string{"SELECT 1"}},
+		{"two", "SELECT 1;\nSELECT 2;\n", []string{"SELECT 1", "SELECT 2"}},
+		{"comments only", "-- no-transaction\n/* nothing */;\n", nil},
+		{"line comment", "-- first; not a statement\nSELECT 1;", []string{"-- first; not a statement\nSELECT 1"}},
+		{"block comment", "SELECT /* a; b */ 1; SELECT 2", []string{"SELECT /* a; b */ 1", "SELECT 2"}},
+		{"string", "INSERT INTO t VALUES ('a;b'); SELECT 2", []string{"INSERT INTO t VALUES ('a;b')", "SELECT 2"}},
+		{"doubled quote", "SELECT 'it''s; fine'; SELECT 2", []string{"SELECT 'it''s; fine'", "SELECT 2"}},
+		{"identifier", `CREATE TABLE "a;b" (c int); SELECT 2`, []string{`CREATE TABLE "a;b" (c int)`, "SELECT 2"}},
+		{"dollar quoted", "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql; SELECT 2",
+			[]string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", "SELECT 2"}},
+		{"tagged dollar quote", "DO $body$ BEGIN PERFORM 1; END $body$; SELECT 2",
+			[]string{"DO $body$ BEGIN PERFORM 1; END $body$", "SELECT 2"}},
+		{"positional parameter", "SELECT $1; SELECT 2", []string{"SELECT $1", "SELECT 2"}},
+		{"unterminated string", "SELECT 'a; b", []string{"SELECT 'a; b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.sql); !slices.Equal(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	unix := "CREATE TABLE t (id int);\nCREATE INDEX ON t (id);\n"
+	windows := strings.ReplaceAll(unix, "\n", "\r\n")
+	if checksum(unix) != checksum(windows) {
+		t.Error("CRLF line endings change the checksum")
+	}
+	if len(checksum(unix)) != 64 {
+		t.Errorf("checksum = %q, want 64 hex digits", checksum(unix))
+	}
+	if checksum(unix) == checksum(unix+"-- edited\n") {
+		t.Error("an edit leaves the checksum unchanged")
+	}
+
+	all, err := All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range all {
+		if m.Checksum != checksum(m.SQL) {
+			t.Errorf("%s: Checksum is not that of its up file", m.Name)
+		}
+	}
+}
+
+func TestPreviousVersion(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 5}, {Version: 7}}
+	tests := []struct {
+		version, want int64
+	}{
+		{1, 0},
+		{2, 1},
+		{5, 2},
+		{6, 5}, // not a migration: the one below it
+		{7, 5},
+		{100, 7},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := previousVersion(all, tt.version); got != tt.want {
+			t.Errorf("previousVersion(%d) = %d, want %d", tt.version, got, tt.want)
+		}
+	}
+	if got := previousVersion(nil, 3); got != 0 {
+		t.Errorf("previousVersion with no migrations = %d", got)
+	}
+}
@@ -0,0 +1,8 @@
+-- The original users table. UNIQUE constraints on username and email
+-- prevent duplicate entries; created_at records when each row was inserted.
+CREATE TABLE IF NOT EXISTS users (
+	id SERIAL PRIMARY KEY,
+	username VARCHAR(50) UNIQUE NOT NULL,
+	email VARCHAR(100) UNIQUE NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
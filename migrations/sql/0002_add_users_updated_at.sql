@@ -0,0 +1,3 @@
+-- updated_at records the last modification and doubles as a version token
+-- for optimistic concurrency (see update-email).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP;
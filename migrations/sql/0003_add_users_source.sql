@@ -0,0 +1,2 @@
+-- source records where a row was imported from (see RECORD_PROVENANCE).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS source TEXT;
@@ -0,0 +1,16 @@
+-- postgres-only
+-- Publishes every inserted user as JSON with pg_notify, for tail and
+-- listen. The channel is the trigger argument; tail re-points the trigger
+-- when TAIL_CHANNEL names another one.
+CREATE OR REPLACE FUNCTION notify_user_inserted() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], row_to_json(NEW)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS users_notify_insert ON users;
+
+CREATE TRIGGER users_notify_insert
+	AFTER INSERT ON users
+	FOR EACH ROW EXECUTE FUNCTION notify_user_inserted('users_inserted');
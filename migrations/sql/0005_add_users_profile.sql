@@ -0,0 +1,4 @@
+-- profile holds optional user details as JSONB (see users.Profile). The
+-- GIN index serves containment (@>) queries on any key.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile JSONB NOT NULL DEFAULT '{}';
+CREATE INDEX IF NOT EXISTS users_profile_idx ON users USING GIN (profile);
@@ -0,0 +1,11 @@
+-- postgres-only
+-- search is the full-text document of a user, kept up to date by
+-- PostgreSQL: the username weighs most (A), then the two halves of the
+-- email (B), then every string in the profile (C). The 'simple'
+-- configuration neither stems nor drops stop words, which suits names.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', username), 'A') ||
+	setweight(to_tsvector('simple', replace(email, '@', ' ')), 'B') ||
+	setweight(jsonb_to_tsvector('simple', profile, '["string"]'), 'C')
+) STORED;
+CREATE INDEX IF NOT EXISTS users_search_idx ON users USING GIN (search);
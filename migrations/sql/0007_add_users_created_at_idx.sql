@@ -0,0 +1,3 @@
+-- Listing pages by (created_at, id), with or without a cursor, walks this
+-- index instead of sorting the whole table.
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
@@ -0,0 +1,7 @@
+-- postgres-only
+CREATE OR REPLACE FUNCTION notify_user_inserted() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], row_to_json(NEW)::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,9 @@
+-- postgres-only
+-- Leaves password_hash (added by 0009) out of the insert notifications:
+-- anyone allowed to LISTEN would otherwise receive every new hash.
+CREATE OR REPLACE FUNCTION notify_user_inserted() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify(TG_ARGV[0], (to_jsonb(NEW) - 'password_hash')::text);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
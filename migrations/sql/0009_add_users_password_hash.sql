@@ -0,0 +1,3 @@
+-- password_hash holds a bcrypt hash (see users.PasswordStore). It is NULL
+-- for users who never set a password, and they cannot log in.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_audit_change ON users;
+DROP FUNCTION IF EXISTS audit_user_change();
+DROP TABLE IF EXISTS users_audit;
@@ -0,0 +1,59 @@
+-- postgres-only
+-- users_audit records every INSERT, UPDATE and DELETE on users: the row
+-- before and after as JSON, and the columns that changed. The generated
+-- search column is left out, and so is the value of password_hash; a
+-- password change shows up by name in changed_columns only. An UPDATE
+-- that changes nothing is not recorded.
+CREATE TABLE IF NOT EXISTS users_audit (
+	id BIGSERIAL PRIMARY KEY,
+	user_id TEXT NOT NULL,
+	operation TEXT NOT NULL CHECK (operation IN ('INSERT', 'UPDATE', 'DELETE')),
+	old_row JSONB,
+	new_row JSONB,
+	changed_columns TEXT[] NOT NULL DEFAULT '{}',
+	changed_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+	changed_by TEXT NOT NULL DEFAULT current_user
+);
+
+CREATE INDEX IF NOT EXISTS users_audit_user_id_idx ON users_audit (user_id, id);
+
+-- SET search_path FROM CURRENT pins the schema the migration runs in, so
+-- the trigger finds users_audit whatever the search_path of the session
+-- that changes a user.
+CREATE OR REPLACE FUNCTION audit_user_change() RETURNS trigger AS $$
+DECLARE
+	old_row jsonb;
+	new_row jsonb;
+	changed text[] := '{}';
+BEGIN
+	IF TG_OP <> 'INSERT' THEN
+		old_row := to_jsonb(OLD) - 'search';
+	END IF;
+	IF TG_OP <> 'DELETE' THEN
+		new_row := to_jsonb(NEW) - 'search';
+	END IF;
+	IF TG_OP = 'UPDATE' THEN
+		SELECT coalesce(array_agg(n.key ORDER BY n.key), '{}') INTO changed
+		FROM jsonb_each(new_row) AS n
+		WHERE n.value IS DISTINCT FROM old_row -> n.key;
+		IF cardinality(changed) = 0 THEN
+			RETURN NULL;
+		END IF;
+	END IF;
+	INSERT INTO users_audit (user_id, operation, old_row, new_row, changed_columns)
+	VALUES (
+		coalesce(new_row, old_row) ->> 'id',
+		TG_OP,
+		old_row - 'password_hash',
+		new_row - 'password_hash',
+		changed
+	);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+DROP TRIGGER IF EXISTS users_audit_change ON users;
+
+CREATE TRIGGER users_audit_change
+	AFTER INSERT OR UPDATE OR DELETE ON users
+	FOR EACH ROW EXECUTE FUNCTION audit_user_change();
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS users_outbox_insert ON users;
+DROP FUNCTION IF EXISTS enqueue_user_created();
+DROP TABLE IF EXISTS users_outbox;
@@ -0,0 +1,35 @@
+-- postgres-only
+-- users_outbox holds a UserCreated event for every inserted user, written
+-- in the inserting transaction, so an event exists exactly when the user
+-- does. The outbox relay publishes the rows to the message broker and
+-- deletes them; until one runs, they wait here. The password hash, the
+-- profile and the search column are left out of the payload.
+CREATE TABLE IF NOT EXISTS users_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event_type TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+	attempts INT NOT NULL DEFAULT 0,
+	last_error TEXT
+);
+
+-- SET search_path FROM CURRENT pins the schema, as for the audit trigger.
+CREATE OR REPLACE FUNCTION enqueue_user_created() RETURNS trigger AS $$
+BEGIN
+	INSERT INTO users_outbox (event_type, payload)
+	VALUES ('UserCreated', jsonb_build_object(
+		'id', NEW.id,
+		'username', NEW.username,
+		'email', NEW.email,
+		'created_at', NEW.created_at,
+		'source', NEW.source
+	));
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql SET search_path FROM CURRENT;
+
+DROP TRIGGER IF EXISTS users_outbox_insert ON users;
+
+CREATE TRIGGER users_outbox_insert
+	AFTER INSERT ON users
+	FOR EACH ROW EXECUTE FUNCTION enqueue_user_created();
@@ -0,0 +1,5 @@
+-- version counts the changes to a row, starting at 1. Every UPDATE of the
+-- users repository increments it, and update-email and PUT /users/{id}
+-- only apply when it still holds the value the caller read (see
+-- users.ErrStaleRecord). Unlike updated_at, two changes never share one.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
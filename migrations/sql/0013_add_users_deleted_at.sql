@@ -0,0 +1,6 @@
+-- deleted_at marks a soft-deleted user: the users repository's Delete sets
+-- it rather than removing the row, and every query except purge and
+-- list --include-deleted skips such rows. purge removes them once they are
+-- older than its retention period. The partial index keeps that scan cheap.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- postgres-only
+ALTER TABLE users
+	ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE current_setting('TimeZone'),
+	ALTER COLUMN updated_at TYPE TIMESTAMP USING updated_at AT TIME ZONE current_setting('TimeZone'),
+	ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE current_setting('TimeZone');
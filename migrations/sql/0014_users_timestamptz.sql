@@ -0,0 +1,12 @@
+-- postgres-only
+-- The timestamps of users become timestamptz, so each names an instant
+-- rather than a wall-clock time whose zone was whatever the server's was.
+-- Existing values are read in the session's TimeZone, which the program
+-- sets from DB_TIMEZONE (default UTC): set it to the zone the server used
+-- before, if that was not UTC, when applying this migration. CockroachDB
+-- rewrites a column to change its type only behind an experimental
+-- setting, so there the columns stay TIMESTAMP.
+ALTER TABLE users
+	ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE current_setting('TimeZone'),
+	ALTER COLUMN updated_at TYPE TIMESTAMPTZ USING updated_at AT TIME ZONE current_setting('TimeZone'),
+	ALTER COLUMN deleted_at TYPE TIMESTAMPTZ USING deleted_at AT TIME ZONE current_setting('TimeZone');
@@ -0,0 +1,24 @@
+-- postgres-only
+-- posts holds what users write: each row belongs to one user and goes with
+-- it, through ON DELETE CASCADE, when purge removes the user. A soft-deleted
+-- user keeps its posts until then. user_id takes the type of users.id, an
+-- integer or a UUID as ID_TYPE chose, so the table is created by a
+-- statement built at run time.
+DO $$
+BEGIN
+	EXECUTE format($sql$
+		CREATE TABLE IF NOT EXISTS posts (
+			id BIGSERIAL PRIMARY KEY,
+			user_id %s NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+			title VARCHAR(200) NOT NULL,
+			body TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp()
+		)$sql$,
+		(SELECT format_type(atttypid, atttypmod) FROM pg_attribute
+			WHERE attrelid = 'users'::regclass AND attname = 'id'));
+END
+$$;
+
+-- Serves the posts of one user, newest first, and the join that counts
+-- them per user.
+CREATE INDEX IF NOT EXISTS posts_user_id_idx ON posts (user_id, id);
@@ -0,0 +1,7 @@
+-- no-transaction
+-- Finding a user by email whatever its case, and the duplicate-email check
+-- of integrity, which groups by lower(email), read this index instead of
+-- the whole table. CONCURRENTLY builds it without blocking writes to
+-- users, which is why the migration cannot run in a transaction. Listing
+-- by created_at already has the index of migration 0007.
+CREATE INDEX CONCURRENTLY IF NOT EXISTS users_lower_email_idx ON users (lower(email));
@@ -0,0 +1,13 @@
+-- postgres-only
+-- user_stats is a read model: the number of users who signed up each day,
+-- by UTC day, soft-deleted users included. A materialized view keeps the
+-- result of the query rather than running it on every read, so it shows
+-- the users as of its last REFRESH (see stats refresh). The unique index on
+-- day lets REFRESH ... CONCURRENTLY swap in new rows while readers keep
+-- reading the old ones.
+CREATE MATERIALIZED VIEW IF NOT EXISTS user_stats AS
+	SELECT (created_at AT TIME ZONE 'UTC')::date AS day, count(*) AS signups
+	FROM users
+	GROUP BY 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_stats_day_idx ON user_stats (day);
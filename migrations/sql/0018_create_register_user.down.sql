@@ -0,0 +1 @@
+DROP FUNCTION IF EXISTS register_user;
@@ -0,0 +1,55 @@
+-- postgres-only
+-- register_user inserts a user and hands the new row back through its OUT
+-- parameters, for callers that go through the database rather than this
+-- program's repository. Unlike a plain INSERT it also refuses an email that
+-- differs from a taken one only in case, which the lower(email) index of
+-- migration 0016 answers. Each refusal raises an exception with a SQLSTATE
+-- of its own, so that callers can tell them apart without reading the
+-- message:
+--
+--   UR001  the username or email is empty
+--   UR002  the username is taken, by a deleted user too
+--   UR003  the email is taken, whatever its case
+--
+-- Two registrations racing past the checks still meet the unique
+-- constraints, which raise unique_violation (23505) as usual. The
+-- parameters take the types of the columns, so the id is an integer or a
+-- UUID as ID_TYPE chose; SET search_path FROM CURRENT pins the schema the
+-- function was created in, so it finds its table whatever the caller's
+-- search_path.
+CREATE OR REPLACE FUNCTION register_user(
+	p_username users.username%TYPE,
+	p_email users.email%TYPE,
+	p_source users.source%TYPE DEFAULT NULL,
+	OUT id users.id%TYPE,
+	OUT username users.username%TYPE,
+	OUT email users.email%TYPE,
+	OUT created_at users.created_at%TYPE,
+	OUT updated_at users.updated_at%TYPE,
+	OUT version users.version%TYPE,
+	OUT source users.source%TYPE
+)
+LANGUAGE plpgsql
+SET search_path FROM CURRENT
+AS $$
+#variable_conflict use_column
+BEGIN
+	IF btrim(coalesce(p_username, '')) = '' OR btrim(coalesce(p_email, '')) = '' THEN
+		RAISE EXCEPTION 'username and email are required'
+			USING ERRCODE = 'UR001';
+	END IF;
+	IF EXISTS (SELECT 1 FROM users u WHERE u.username = p_username) THEN
+		RAISE EXCEPTION 'username % is already taken', p_username
+			USING ERRCODE = 'UR002', HINT = 'Choose another username.';
+	END IF;
+	IF EXISTS (SELECT 1 FROM users u WHERE lower(u.email) = lower(p_email)) THEN
+		RAISE EXCEPTION 'email % is already taken', p_email
+			USING ERRCODE = 'UR003', DETAIL = 'Emails are compared without regard to case.';
+	END IF;
+
+	INSERT INTO users AS u (username, email, source)
+	VALUES (p_username, p_email, p_source)
+	RETURNING u.id, u.username, u.email, u.created_at, u.updated_at, u.version, u.source
+	INTO id, username, email, created_at, updated_at, version, source;
+END
+$$;
@@ -0,0 +1,32 @@
+-- postgres-only
+-- events is an append-only log split into one partition per UTC month of
+-- created_at. A query bounded in time reads only the partitions it needs,
+-- and a month that is no longer wanted goes with a DROP TABLE instead of a
+-- long DELETE. The primary key has to include the partition key. An insert
+-- for a month without a partition fails, so the partitions of the current
+-- and the next month are created here, and events partitions create (or
+-- the event-partitions job of the daemon) adds the months after that.
+CREATE TABLE IF NOT EXISTS events (
+	id BIGSERIAL,
+	name VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL DEFAULT '{}',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+	PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Created on the partitioned table, the index is created on every
+-- partition, present and future.
+CREATE INDEX IF NOT EXISTS events_name_created_at_idx ON events (name, created_at);
+
+DO $$
+DECLARE
+	first_month timestamp := date_trunc('month', now() AT TIME ZONE 'UTC');
+BEGIN
+	FOR i IN 0..1 LOOP
+		EXECUTE format('CREATE TABLE IF NOT EXISTS %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+			'events_' || to_char(first_month + make_interval(months => i), 'YYYY_MM'),
+			(first_month + make_interval(months => i)) AT TIME ZONE 'UTC',
+			(first_month + make_interval(months => i + 1)) AT TIME ZONE 'UTC');
+	END LOOP;
+END
+$$;
@@ -0,0 +1,5 @@
+DROP POLICY IF EXISTS users_tenant_isolation ON users;
+ALTER TABLE users NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE users DISABLE ROW LEVEL SECURITY;
+DROP INDEX IF EXISTS users_tenant_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS tenant;
@@ -0,0 +1,21 @@
+-- postgres-only
+-- Row-level security: tenant names the owner of a user, and the policy
+-- lets a session see and change only the users of the owner in its
+-- app.tenant setting (RLS_TENANT). A session without one sees every user,
+-- so nothing changes until a tenant is set. New users take the session's
+-- tenant by default; existing users keep none and are then visible only
+-- to sessions without a tenant. The default is set after the column is
+-- added so that existing rows are not given the migrating session's
+-- tenant.
+--
+-- ENABLE applies the policy to every role but the table's owner,
+-- superusers and roles with BYPASSRLS; rls force extends it to the owner.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant TEXT;
+ALTER TABLE users ALTER COLUMN tenant SET DEFAULT nullif(current_setting('app.tenant', true), '');
+CREATE INDEX IF NOT EXISTS users_tenant_idx ON users (tenant);
+
+ALTER TABLE users ENABLE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS users_tenant_isolation ON users;
+CREATE POLICY users_tenant_isolation ON users
+	USING (coalesce(current_setting('app.tenant', true), '') = '' OR tenant = current_setting('app.tenant', true))
+	WITH CHECK (coalesce(current_setting('app.tenant', true), '') = '' OR tenant = current_setting('app.tenant', true));
@@ -0,0 +1,17 @@
+-- idempotency_keys remembers the response to each POST sent with an
+-- Idempotency-Key header, so a client that retries after a timeout or a
+-- dropped connection gets the first answer again instead of a second
+-- user or a 409. The row is written in the transaction of the insert it
+-- answers for: there is a stored response exactly when the insert
+-- happened. request_hash tells a retry from another request reusing the
+-- key.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key VARCHAR(255) PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INT,
+	location TEXT,
+	response TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
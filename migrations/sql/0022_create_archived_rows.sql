@@ -0,0 +1,13 @@
+-- archived_rows keeps what cleanup --archive removes from users and
+-- users_audit: each row as JSON, with the table it came from. The JSON
+-- does not depend on the columns of that table, so later migrations can
+-- change them without touching the archive. Rows of users are archived
+-- without their password_hash and search columns.
+CREATE TABLE IF NOT EXISTS archived_rows (
+	id BIGSERIAL PRIMARY KEY,
+	source_table TEXT NOT NULL,
+	row_data JSONB NOT NULL,
+	archived_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS archived_rows_source_table_idx ON archived_rows (source_table, archived_at);
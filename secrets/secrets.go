@@ -0,0 +1,124 @@
+// Package secrets fetches database credentials from a secrets manager, so
+// passwords need not be stored in .env.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// Providers accepted in Source.Provider.
+const (
+	AWS   = "aws"   // AWS Secrets Manager
+	Vault = "vault" // HashiCorp Vault, KV engine version 1 or 2
+)
+
+// Source names a secret and where to read it from.
+type Source struct {
+	Provider string // AWS or Vault; empty disables secrets
+	ID       string // AWS secret name or ARN, or Vault path such as "secret/data/app"
+	// VaultAddr and VaultToken locate and authenticate to Vault; AWS
+	// credentials come from the usual AWS_* variables, shared config
+	// files or the instance role.
+	VaultAddr  string
+	VaultToken string
+}
+
+// Fetch reads the secret and returns its fields. A secret holding a JSON
+// object yields its string fields as they are; any other secret is taken
+// to be a bare password and returned as DB_PASSWORD.
+func (s Source) Fetch(ctx context.Context) (map[string]string, error) {
+	var raw string
+	var err error
+	switch s.Provider {
+	case AWS:
+		raw, err = s.fetchAWS(ctx)
+	case Vault:
+		return s.fetchVault(ctx)
+	default:
+		return nil, fmt.Errorf("unknown secrets provider %q", s.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if json.Unmarshal([]byte(raw), &fields) != nil {
+		return map[string]string{"DB_PASSWORD": raw}, nil
+	}
+	return stringFields(fields), nil
+}
+
+func (s Source) fetchAWS(ctx context.Context) (string, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("loading AWS configuration: %w", err)
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &s.ID})
+	if err != nil {
+		return "", fmt.Errorf("reading secret %q from AWS Secrets Manager: %w", s.ID, err)
+	}
+	if out.SecretString == nil {
+		return "", fmt.Errorf("secret %q has no string value", s.ID)
+	}
+	return *out.SecretString, nil
+}
+
+// fetchVault reads s.ID through the Vault HTTP API. KV version 2 nests the
+// fields one level deeper, under data.data, than version 1.
+func (s Source) fetchVault(ctx context.Context) (map[string]string, error) {
+	if s.VaultAddr == "" || s.VaultToken == "" {
+		return nil, errors.New("VAULT_ADDR and VAULT_TOKEN are required to read secrets from Vault")
+	}
+	endpoint, err := url.JoinPath(s.VaultAddr, "v1", strings.TrimPrefix(s.ID, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAULT_ADDR: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", s.VaultToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading secret %q from Vault: %w", s.ID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading secret %q from Vault: %s", s.ID, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decoding Vault response: %w", err)
+	}
+	fields := body.Data
+	if nested, ok := fields["data"].(map[string]any); ok {
+		fields = nested
+	}
+	return stringFields(fields), nil
+}
+
+// stringFields keeps the string values of fields and formats numbers, such
+// as the port of an RDS-managed secret, as text.
+func stringFields(fields map[string]any) map[string]string {
+	out := make(map[string]string, len(fields))
+	for k, v := range fields {
+		switch v := v.(type) {
+		case string:
+			out[k] = v
+		case float64:
+			out[k] = fmt.Sprint(v)
+		}
+	}
+	return out
+}
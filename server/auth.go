@@ -0,0 +1,245 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/userspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// apiKeyHeader carries an API key; in gRPC metadata it is lower case.
+const apiKeyHeader = "X-API-Key"
+
+// Errors of authenticate. Their text is all a client is told; why a
+// credential was refused is only logged.
+var (
+	errUnauthenticated = errors.New("authentication required")
+	errForbidden       = errors.New("the credentials do not allow writes")
+)
+
+// authenticator checks the credentials of writes to the APIs of serve: an
+// API key of API_KEYS in the X-API-Key header, or a bearer token signed
+// with JWT_SECRET or JWT_PUBLIC_KEY. Reads need none.
+type authenticator struct {
+	apiKeys [][]byte
+	keys    map[string]any // verification key by jwt.SigningMethod family
+	parser  *jwt.Parser
+	scope   string
+}
+
+// newAuthenticator returns the authenticator of cfg, or nil when it sets
+// no credentials and writes are open.
+func newAuthenticator(cfg config.App) (*authenticator, error) {
+	if len(cfg.APIKeys) == 0 && cfg.JWTSecret == "" && cfg.JWTPublicKey == "" {
+		return nil, nil
+	}
+	a := &authenticator{keys: map[string]any{}, scope: cfg.JWTScope}
+	for _, k := range cfg.APIKeys {
+		a.apiKeys = append(a.apiKeys, []byte(k))
+	}
+	var methods []string
+	if cfg.JWTSecret != "" {
+		a.keys["HS"] = []byte(cfg.JWTSecret)
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.JWTPublicKey != "" {
+		key, family, err := parsePublicKey(cfg.JWTPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_PUBLIC_KEY: %w", err)
+		}
+		a.keys[family] = key
+		switch family {
+		case "RS":
+			methods = append(methods, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512")
+		case "ES":
+			methods = append(methods, "ES256", "ES384", "ES512")
+		case "EdDSA":
+			methods = append(methods, "EdDSA")
+		}
+	}
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired(), jwt.WithLeeway(30 * time.Second)}
+	if cfg.JWTIssuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.JWTIssuer))
+	}
+	if cfg.JWTAudience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.JWTAudience))
+	}
+	a.parser = jwt.NewParser(opts...)
+	return a, nil
+}
+
+// parsePublicKey reads a PEM public key, given as is or as the name of a
+// file holding it, and returns it with the family of signing methods that
+// use it.
+func parsePublicKey(s string) (any, string, error) {
+	pem := []byte(s)
+	if !strings.HasPrefix(s, "-----BEGIN") {
+		var err error
+		if pem, err = os.ReadFile(s); err != nil {
+			return nil, "", err
+		}
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pem); err == nil {
+		return key, "RS", nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(pem); err == nil {
+		return key, "ES", nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pem); err == nil {
+		return key, "EdDSA", nil
+	}
+	return nil, "", errors.New("not a PEM-encoded RSA, ECDSA or Ed25519 public key")
+}
+
+// jwtClaims are the claims authenticate reads besides the registered ones.
+// scope is the OAuth 2.0 form, space-separated; some issuers use scp, a
+// list, instead.
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	Scope string           `json:"scope"`
+	Scp   jwt.ClaimStrings `json:"scp"`
+}
+
+// authenticate checks the credentials get returns for the headers
+// X-API-Key and Authorization. It returns an error wrapping
+// errUnauthenticated when they are missing or invalid, and errForbidden
+// when a valid token lacks JWT_SCOPE.
+func (a *authenticator) authenticate(get func(header string) string) error {
+	if key := get(apiKeyHeader); key != "" {
+		if !slices.ContainsFunc(a.apiKeys, func(k []byte) bool { return subtle.ConstantTimeCompare(k, []byte(key)) == 1 }) {
+			return fmt.Errorf("%w: unknown API key", errUnauthenticated)
+		}
+		return nil
+	}
+	scheme, token, ok := strings.Cut(get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return errUnauthenticated
+	}
+	if a.parser == nil {
+		return fmt.Errorf("%w: bearer tokens are not accepted without JWT_SECRET or JWT_PUBLIC_KEY", errUnauthenticated)
+	}
+	var claims jwtClaims
+	if _, err := a.parser.ParseWithClaims(strings.TrimSpace(token), &claims, a.key); err != nil {
+		return fmt.Errorf("%w: %v", errUnauthenticated, err)
+	}
+	if a.scope != "" && !slices.Contains(strings.Fields(claims.Scope), a.scope) && !slices.Contains(claims.Scp, a.scope) {
+		return fmt.Errorf("%w: token of %q lacks scope %q", errForbidden, claims.Subject, a.scope)
+	}
+	return nil
+}
+
+// key is the jwt.Keyfunc of the parser: the key configured for the
+// family of the token's signing method. WithValidMethods has already
+// refused methods no key is configured for.
+func (a *authenticator) key(t *jwt.Token) (any, error) {
+	family := t.Method.Alg()
+	switch {
+	case family == "EdDSA":
+	case strings.HasPrefix(family, "PS"):
+		family = "RS" // RSA-PSS, verified with the same RSA key
+	default:
+		family = family[:2]
+	}
+	if key, ok := a.keys[family]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("no key for %s", t.Method.Alg())
+}
+
+// schemes names the kinds of credentials a accepts: bearer, apiKey or both.
+func (a *authenticator) schemes() []string {
+	var schemes []string
+	if a.parser != nil {
+		schemes = append(schemes, "bearer")
+	}
+	if len(a.apiKeys) > 0 {
+		schemes = append(schemes, "apiKey")
+	}
+	return schemes
+}
+
+// challenge is the WWW-Authenticate header of a 401, naming the schemes
+// accepted.
+func (a *authenticator) challenge() string {
+	var challenges []string
+	for _, scheme := range a.schemes() {
+		if scheme == "bearer" {
+			challenges = append(challenges, `Bearer realm="users"`)
+		} else {
+			challenges = append(challenges, `ApiKey header="`+apiKeyHeader+`"`)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// logRefusal logs why a write was refused, which its client is not told.
+func logRefusal(ctx context.Context, api string, err error) {
+	slog.WarnContext(ctx, "write refused", "api", api, "reason", err)
+}
+
+// requireAuth wraps next so it only runs for requests that authenticate,
+// answering 401 or 403 with a bare errorBody otherwise.
+func (a *authenticator) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := a.authenticate(r.Header.Get)
+		switch {
+		case err == nil:
+			next(w, r)
+			return
+		case errors.Is(err, errForbidden):
+			logRefusal(r.Context(), "rest", err)
+			writeJSON(w, http.StatusForbidden, errorBody{errForbidden.Error()})
+		default:
+			logRefusal(r.Context(), "rest", err)
+			w.Header().Set("WWW-Authenticate", a.challenge())
+			writeJSON(w, http.StatusUnauthorized, errorBody{errUnauthenticated.Error()})
+		}
+	}
+}
+
+// grpcWrites are the methods of UserService that require authentication.
+var grpcWrites = map[string]bool{
+	userspb.UserService_CreateUser_FullMethodName: true,
+	userspb.UserService_UpdateUser_FullMethodName: true,
+	userspb.UserService_DeleteUser_FullMethodName: true,
+}
+
+// authorizeWrites is the gRPC interceptor of a: calls to grpcWrites must
+// carry the credentials in their x-api-key or authorization metadata, and
+// are refused with Unauthenticated or PermissionDenied otherwise.
+func (a *authenticator) authorizeWrites(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if !grpcWrites[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	err := a.authenticate(func(header string) string {
+		if v := md.Get(header); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	})
+	switch {
+	case err == nil:
+		return handler(ctx, req)
+	case errors.Is(err, errForbidden):
+		logRefusal(ctx, "grpc", err)
+		return nil, status.Error(codes.PermissionDenied, errForbidden.Error())
+	default:
+		logRefusal(ctx, "grpc", err)
+		return nil, status.Error(codes.Unauthenticated, errUnauthenticated.Error())
+	}
+}
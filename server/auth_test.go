@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/userspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+var authConfig = config.Config{App: config.App{
+	APIKeys:   []string{"key-1"},
+	JWTSecret: "s3cret",
+	JWTIssuer: "users-test",
+	JWTScope:  "users:write",
+}}
+
+// signToken returns an HS256 token of claims signed with secret.
+func signToken(t *testing.T, secret string, claims jwt.Claims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// dialGRPC serves srv on an in-memory listener and returns a client of it.
+func dialGRPC(t *testing.T, srv *grpc.Server) userspb.UserServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return userspb.NewUserServiceClient(conn)
+}
+
+func TestAuthenticatedWrites(t *testing.T) {
+	s, err := New(authConfig, Deps{Logger: quietLogger, Repo: newSQLiteRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	client := dialGRPC(t, s.GRPCServer())
+
+	hour := jwt.NewNumericDate(time.Now().Add(time.Hour))
+	claims := func(issuer, scope string, expires *jwt.NumericDate) jwtClaims {
+		return jwtClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "tester", Issuer: issuer, ExpiresAt: expires}, Scope: scope}
+	}
+	bearer := func(c jwt.Claims) []string {
+		return []string{"Authorization", "Bearer " + signToken(t, "s3cret", c)}
+	}
+	tests := []struct {
+		name    string
+		header  []string
+		rest    int
+		graphQL string
+		grpc    codes.Code
+	}{
+		{"no credentials", nil, http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"api key", []string{apiKeyHeader, "key-1"}, http.StatusCreated, "", codes.OK},
+		{"unknown api key", []string{apiKeyHeader, "key-2"}, http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"token", bearer(claims("users-test", "users:read users:write", hour)), http.StatusCreated, "", codes.OK},
+		{"token with scp", bearer(jwtClaims{
+			RegisteredClaims: jwt.RegisteredClaims{Issuer: "users-test", ExpiresAt: hour},
+			Scp:              jwt.ClaimStrings{"users:write"},
+		}), http.StatusCreated, "", codes.OK},
+		{"wrong issuer", bearer(claims("someone-else", "users:write", hour)), http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"expired", bearer(claims("users-test", "users:write", jwt.NewNumericDate(time.Now().Add(-time.Hour)))), http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"no expiry", bearer(claims("users-test", "users:write", nil)), http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"wrong secret", []string{"Authorization", "Bearer " + signToken(t, "other", claims("users-test", "users:write", hour))}, http.StatusUnauthorized, "UNAUTHENTICATED", codes.Unauthenticated},
+		{"missing scope", bearer(claims("users-test", "users:read", hour)), http.StatusForbidden, "FORBIDDEN", codes.PermissionDenied},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username := fmt.Sprintf("user%d", i)
+
+			rec := serve(h, "POST", "/users", `{"username": "`+username+`rest", "email": "`+username+`rest@example.com"}`, tt.header...)
+			if rec.Code != tt.rest {
+				t.Errorf("POST /users: status %d, want %d; body %s", rec.Code, tt.rest, rec.Body)
+			}
+			if tt.rest == http.StatusUnauthorized && !strings.Contains(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+				t.Errorf("WWW-Authenticate = %q", rec.Header().Get("WWW-Authenticate"))
+			}
+
+			gqlHeader := append([]string{"Content-Type", "application/json"}, tt.header...)
+			rec = serve(h, "POST", "/graphql",
+				`{"query": "mutation { createUser(input: {username: \"`+username+`gql\", email: \"`+username+`gql@example.com\"}) { updated } }"}`, gqlHeader...)
+			var resp graphQLResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("POST /graphql: status %d, body %s", rec.Code, rec.Body)
+			}
+			if resp.code() != tt.graphQL {
+				t.Errorf("GraphQL code = %q, want %q; errors %+v", resp.code(), tt.graphQL, resp.Errors)
+			}
+
+			ctx := context.Background()
+			if tt.header != nil {
+				ctx = metadata.AppendToOutgoingContext(ctx, strings.ToLower(tt.header[0]), tt.header[1])
+			}
+			_, err := client.CreateUser(ctx, &userspb.CreateUserRequest{Username: username + "grpc", Email: username + "grpc@example.com"})
+			if code := status.Code(err); code != tt.grpc {
+				t.Errorf("gRPC CreateUser: %v, want %s", err, tt.grpc)
+			}
+		})
+	}
+}
+
+func TestReadsNeedNoCredentials(t *testing.T) {
+	s, err := New(authConfig, Deps{Logger: quietLogger, Repo: newSQLiteRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := s.Handler()
+	if rec := serve(h, "GET", "/users", ""); rec.Code != http.StatusOK {
+		t.Errorf("GET /users: status %d", rec.Code)
+	}
+	if resp := postGraphQL(t, h, `{ users { total } }`, nil); len(resp.Errors) > 0 {
+		t.Errorf("GraphQL users: %+v", resp.Errors)
+	}
+	client := dialGRPC(t, s.GRPCServer())
+	if _, err := client.ListUsers(context.Background(), &userspb.ListUsersRequest{}); err != nil {
+		t.Errorf("gRPC ListUsers: %v", err)
+	}
+}
+
+func TestNewAuthenticator(t *testing.T) {
+	if a, err := newAuthenticator(config.App{}); a != nil || err != nil {
+		t.Errorf("newAuthenticator without credentials = %v, %v; want nil", a, err)
+	}
+	if _, err := newAuthenticator(config.App{JWTPublicKey: "-----BEGIN PUBLIC KEY-----\nnot a key\n-----END PUBLIC KEY-----"}); err == nil {
+		t.Error("newAuthenticator accepted a malformed JWT_PUBLIC_KEY")
+	}
+}
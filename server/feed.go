@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/hozana-dusabimana/users"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	feedClients = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "users_feed_clients",
+		Help: "WebSocket clients connected to the live feed of new users at /ws.",
+	})
+	feedDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "users_feed_disconnects_total",
+		Help: "Live feed clients disconnected, by reason: closed, slow, error or shutdown.",
+	}, []string{"reason"})
+)
+
+// Collectors returns the metrics of the server, for the caller to register
+// with the registry it serves.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{feedClients, feedDisconnects}
+}
+
+const (
+	// feedBuffer is how many users may wait to be sent to one client before
+	// the client counts as too slow and is disconnected.
+	feedBuffer = 256
+	// feedWriteTimeout bounds how long a client may take to accept one
+	// message or answer a ping.
+	feedWriteTimeout = 10 * time.Second
+	// feedPingInterval is how often idle clients are pinged, so dead
+	// connections are noticed and proxies keep live ones open.
+	feedPingInterval = 30 * time.Second
+)
+
+// FeedUser is a new user as the live feed sends it.
+type FeedUser struct {
+	ID        users.ID  `json:"id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Feed broadcasts new users to the WebSocket clients of /ws and the
+// WatchUsers streams of gRPC. Whoever creates it feeds it with Broadcast,
+// so one LISTEN connection can serve every client.
+type Feed struct {
+	mu       sync.Mutex
+	clients  map[*feedClient]struct{}
+	stopping chan struct{} // closed when the server shuts down
+	stopOnce sync.Once
+}
+
+// feedClient is the queue of one connected client. slow is closed, and
+// the client dropped from the feed, when its queue overflows.
+type feedClient struct {
+	send chan []byte
+	slow chan struct{}
+}
+
+// NewFeed returns a Feed with no clients.
+func NewFeed() *Feed {
+	return &Feed{clients: map[*feedClient]struct{}{}, stopping: make(chan struct{})}
+}
+
+// Broadcast queues u for every client. A client whose queue is full is
+// dropped rather than waited for, so one slow reader never holds up the
+// others or the LISTEN connection.
+func (f *Feed) Broadcast(u FeedUser) {
+	msg, err := json.Marshal(u)
+	if err != nil {
+		slog.Warn("live feed: encoding user failed", "err", err)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.clients {
+		select {
+		case c.send <- msg:
+		default:
+			delete(f.clients, c)
+			close(c.slow)
+		}
+	}
+}
+
+func (f *Feed) subscribe() *feedClient {
+	c := &feedClient{send: make(chan []byte, feedBuffer), slow: make(chan struct{})}
+	f.mu.Lock()
+	f.clients[c] = struct{}{}
+	f.mu.Unlock()
+	feedClients.Inc()
+	return c
+}
+
+func (f *Feed) unsubscribe(c *feedClient) {
+	f.mu.Lock()
+	delete(f.clients, c)
+	f.mu.Unlock()
+	feedClients.Dec()
+}
+
+// Shutdown closes every client connection with "going away". The server
+// does not track hijacked connections, so it is registered with
+// http.Server.RegisterOnShutdown.
+func (f *Feed) Shutdown() {
+	f.stopOnce.Do(func() { close(f.stopping) })
+}
+
+// ServeHTTP upgrades the request to a WebSocket and sends each new user to
+// it as a JSON text message until the client goes away.
+func (f *Feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		// Accept has answered the request already
+		slog.Debug("live feed: upgrade refused", "err", err)
+		return
+	}
+	c := f.subscribe()
+	defer f.unsubscribe(c)
+
+	reason := f.serveClient(conn.CloseRead(r.Context()), conn, c)
+	feedDisconnects.WithLabelValues(reason).Inc()
+	slog.Debug("live feed client disconnected", "remote", r.RemoteAddr, "reason", reason)
+}
+
+// serveClient writes the queue of c to conn and pings it while idle. It
+// returns why the client was let go. ctx is done once the client closes the
+// connection; the messages it sends are discarded.
+func (f *Feed) serveClient(ctx context.Context, conn *websocket.Conn, c *feedClient) string {
+	ping := time.NewTicker(feedPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.CloseNow()
+			return "closed"
+		case <-f.stopping:
+			conn.Close(websocket.StatusGoingAway, "server shutting down")
+			return "shutdown"
+		case <-c.slow:
+			conn.Close(websocket.StatusTryAgainLater, "client too slow; reconnect")
+			return "slow"
+		case msg := <-c.send:
+			writeCtx, cancel := context.WithTimeout(ctx, feedWriteTimeout)
+			err := conn.Write(writeCtx, websocket.MessageText, msg)
+			cancel()
+			if err != nil {
+				conn.CloseNow()
+				return "error"
+			}
+		case <-ping.C:
+			pingCtx, cancel := context.WithTimeout(ctx, feedWriteTimeout)
+			err := conn.Ping(pingCtx)
+			cancel()
+			if err != nil {
+				conn.CloseNow()
+				return "error"
+			}
+		}
+	}
+}
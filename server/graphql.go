@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+	"github.com/hozana-dusabimana/graph"
+	"github.com/hozana-dusabimana/users"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// How long a userLoader waits for more lookups before fetching a batch, and
+// the most ids it fetches at once.
+const (
+	userLoaderWait     = 5 * time.Millisecond
+	userLoaderMaxBatch = 100
+)
+
+// registerGraphQLRoutes adds the GraphQL API backed by repo to mux:
+//
+//	POST /graphql  run the query of a {"query", "variables"} body
+//	GET  /graphql  run ?query= with ?variables=; queries only
+//
+// Introspection is enabled, so GraphQL clients can discover the schema.
+// With auth set, mutations need its credentials in the request headers.
+// The users created are marked with source.
+func registerGraphQLRoutes(mux *http.ServeMux, repo users.Repository, auth *authenticator, source *string) {
+	srv := handler.New(graph.NewExecutableSchema(graph.Config{
+		Resolvers: &graphResolver{repo: repo, source: source},
+	}))
+	srv.AddTransport(transport.GET{})
+	srv.AddTransport(transport.POST{})
+	srv.Use(extension.Introspection{})
+	srv.SetErrorPresenter(graphQLError)
+	if auth != nil {
+		srv.AroundOperations(auth.authorizeMutations)
+	}
+	mux.Handle("/graphql", withUserLoader(srv, repo))
+}
+
+// graphResolver implements the resolvers of graph over a users.Repository,
+// as usersHandler does the REST API. No field name is shared between Query
+// and Mutation, so one type serves as both.
+type graphResolver struct {
+	repo   users.Repository
+	source *string // provenance of created users
+}
+
+func (r *graphResolver) Query() graph.QueryResolver       { return r }
+func (r *graphResolver) Mutation() graph.MutationResolver { return r }
+
+func (r *graphResolver) Users(ctx context.Context, limit, offset *int, after *string, filter *graph.UserFilter) (*graph.UserPage, error) {
+	if deref(limit) < 0 || deref(offset) < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", users.ErrInvalid)
+	}
+	page := users.Page{Limit: deref(limit), Offset: deref(offset), After: deref(after)}
+	if filter != nil {
+		page.Filter = users.Filter{
+			UsernamePrefix: deref(filter.UsernamePrefix),
+			EmailDomain:    deref(filter.EmailDomain),
+			CreatedAfter:   deref(filter.CreatedAfter),
+			CreatedBefore:  deref(filter.CreatedBefore),
+		}
+	}
+	records, next, err := r.repo.List(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	total, err := r.repo.Count(ctx, page.Filter)
+	if err != nil {
+		return nil, err
+	}
+	result := &graph.UserPage{Users: []*users.User{}, Total: int(total)}
+	for i := range records {
+		result.Users = append(result.Users, &records[i])
+	}
+	if next != "" {
+		result.NextCursor = &next
+	}
+	return result, nil
+}
+
+// User looks a user up by id through the request's userLoader, so the
+// lookups of one query are batched, or by username.
+func (r *graphResolver) User(ctx context.Context, id, username *string) (*users.User, error) {
+	var u *users.User
+	var err error
+	switch {
+	case (id == nil) == (username == nil):
+		return nil, fmt.Errorf("%w: give either id or username", users.ErrInvalid)
+	case id != nil:
+		parsed, parseErr := users.ParseID(*id)
+		if parseErr != nil {
+			return nil, nil
+		}
+		u, err = userLoaderFrom(ctx).load(ctx, parsed)
+	default:
+		u, err = r.repo.GetByUsername(ctx, *username)
+	}
+	if errors.Is(err, users.ErrNotFound) {
+		return nil, nil
+	}
+	return u, err
+}
+
+func (r *graphResolver) CreateUser(ctx context.Context, input graph.CreateUserInput) (*graph.CreateUserPayload, error) {
+	u := users.User{Username: input.Username, Email: input.Email, Source: r.source}
+	if err := users.Validate(u); err != nil {
+		return nil, err
+	}
+	err := r.repo.Create(ctx, &u)
+	updated := errors.Is(err, users.ErrUpdated)
+	if err != nil && !updated {
+		return nil, err
+	}
+	return &graph.CreateUserPayload{User: &u, Updated: updated}, nil
+}
+
+func (r *graphResolver) UpdateUser(ctx context.Context, input graph.UpdateUserInput) (*users.User, error) {
+	u, err := r.lookup(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	u.Email = input.Email
+	if err := users.ValidateEmail(u.Email); err != nil {
+		return nil, err
+	}
+	if input.Version != nil && *input.Version < 1 {
+		return nil, fmt.Errorf("%w: version must be positive", users.ErrInvalid)
+	}
+	u.Version, u.UpdatedAt = int64(deref(input.Version)), deref(input.UpdatedAt)
+	if err := r.repo.Update(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *graphResolver) DeleteUser(ctx context.Context, id string) (bool, error) {
+	u, err := r.lookup(ctx, id)
+	if errors.Is(err, users.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := r.repo.Delete(ctx, u.Username); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// lookup loads the user with the given id; an id that is neither an
+// integer nor a UUID names no user.
+func (r *graphResolver) lookup(ctx context.Context, id string) (*users.User, error) {
+	parsed, err := users.ParseID(id)
+	if err != nil {
+		return nil, users.ErrNotFound
+	}
+	return r.repo.GetByID(ctx, parsed)
+}
+
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// graphQLError maps repository errors to the "code" extension of a
+// GraphQL error as writeError does to HTTP statuses. Anything unexpected is
+// logged and reported as a bare internal error so database details do not
+// leak.
+func graphQLError(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+	var open Unavailable
+	var code string
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		code = "TIMEOUT"
+	case errors.As(err, &open):
+		code = "UNAVAILABLE"
+	case errors.Is(err, users.ErrInvalid):
+		code = "BAD_USER_INPUT"
+	case errors.Is(err, users.ErrNotFound):
+		code = "NOT_FOUND"
+	case errors.Is(err, users.ErrDuplicate), errors.Is(err, users.ErrStaleRecord), errors.Is(err, users.ErrForeignKeyViolation):
+		code = "CONFLICT"
+	case errors.Is(err, users.ErrReadOnly):
+		code = "READ_ONLY"
+	case errors.Is(err, users.ErrConnectionFailed):
+		slog.ErrorContext(ctx, "graphql request failed", "err", err)
+		code = "UNAVAILABLE"
+		gqlErr.Message = users.ErrConnectionFailed.Error()
+	case errors.As(err, new(*gqlerror.Error)):
+		// Raised by gqlgen itself, such as an argument of the wrong type
+		return gqlErr
+	default:
+		slog.ErrorContext(ctx, "graphql request failed", "err", err)
+		code = "INTERNAL_SERVER_ERROR"
+		gqlErr.Message = "internal error"
+	}
+	if gqlErr.Extensions == nil {
+		gqlErr.Extensions = map[string]any{}
+	}
+	gqlErr.Extensions["code"] = code
+	return gqlErr
+}
+
+// authorizeMutations is the operation middleware of a: a mutation runs
+// only when the headers of its request authenticate, and is answered with
+// an UNAUTHENTICATED or FORBIDDEN error otherwise.
+func (a *authenticator) authorizeMutations(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	op := graphql.GetOperationContext(ctx)
+	if op.Operation == nil || op.Operation.Operation != ast.Mutation {
+		return next(ctx)
+	}
+	err := a.authenticate(op.Headers.Get)
+	if err == nil {
+		return next(ctx)
+	}
+	logRefusal(ctx, "graphql", err)
+	refusal := &gqlerror.Error{Message: errUnauthenticated.Error(), Extensions: map[string]any{"code": "UNAUTHENTICATED"}}
+	if errors.Is(err, errForbidden) {
+		refusal = &gqlerror.Error{Message: errForbidden.Error(), Extensions: map[string]any{"code": "FORBIDDEN"}}
+	}
+	return graphql.OneShot(&graphql.Response{Errors: gqlerror.List{refusal}})
+}
+
+type userLoaderKey struct{}
+
+// withUserLoader gives every request to next a userLoader of its own.
+func withUserLoader(next http.Handler, repo users.Repository) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := &userLoader{ctx: r.Context(), repo: repo, loaded: map[users.ID]*loadedUser{}}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userLoaderKey{}, l)))
+	})
+}
+
+func userLoaderFrom(ctx context.Context) *userLoader {
+	return ctx.Value(userLoaderKey{}).(*userLoader)
+}
+
+// userLoader batches the user lookups of one GraphQL request into
+// GetByIDs calls and remembers their results for the rest of the request.
+// gqlgen resolves sibling fields concurrently, so the lookups arriving
+// within userLoaderWait of the first are fetched together: a query
+// selecting many users by id, and once users have relations the users they
+// point at, costs one query rather than one per user.
+type userLoader struct {
+	ctx  context.Context // of the request, for the batches
+	repo users.Repository
+
+	mu      sync.Mutex
+	loaded  map[users.ID]*loadedUser
+	pending []users.ID // not yet fetched
+}
+
+// loadedUser is the result of looking up one id, ready once done is closed.
+type loadedUser struct {
+	done chan struct{}
+	u    *users.User
+	err  error
+}
+
+// load returns the user with the given id or users.ErrNotFound.
+func (l *userLoader) load(ctx context.Context, id users.ID) (*users.User, error) {
+	l.mu.Lock()
+	result, ok := l.loaded[id]
+	if !ok {
+		result = &loadedUser{done: make(chan struct{})}
+		l.loaded[id] = result
+		l.pending = append(l.pending, id)
+		switch len(l.pending) {
+		case userLoaderMaxBatch:
+			go l.fetch(l.takePending())
+		case 1:
+			time.AfterFunc(userLoaderWait, func() {
+				l.mu.Lock()
+				batch := l.takePending()
+				l.mu.Unlock()
+				l.fetch(batch)
+			})
+		}
+	}
+	l.mu.Unlock()
+	select {
+	case <-result.done:
+		return result.u, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// takePending returns the ids waiting to be fetched and their results,
+// leaving none. l.mu must be held.
+func (l *userLoader) takePending() map[users.ID]*loadedUser {
+	batch := make(map[users.ID]*loadedUser, len(l.pending))
+	for _, id := range l.pending {
+		batch[id] = l.loaded[id]
+	}
+	l.pending = nil
+	return batch
+}
+
+// fetch looks up the users of batch and completes their results.
+func (l *userLoader) fetch(batch map[users.ID]*loadedUser) {
+	if len(batch) == 0 {
+		return // taken by a batch that filled up
+	}
+	ids := make([]users.ID, 0, len(batch))
+	for id := range batch {
+		ids = append(ids, id)
+	}
+	found, err := l.repo.GetByIDs(l.ctx, ids)
+	for i := range found {
+		if result, ok := batch[found[i].ID]; ok {
+			result.u = &found[i]
+		}
+	}
+	for _, result := range batch {
+		if err != nil {
+			result.err = err
+		} else if result.u == nil {
+			result.err = users.ErrNotFound
+		}
+		close(result.done)
+	}
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// countingRepository counts the GetByIDs calls made to its Repository.
+type countingRepository struct {
+	users.Repository
+	batches atomic.Int32
+}
+
+func (r *countingRepository) GetByIDs(ctx context.Context, ids []users.ID) ([]users.User, error) {
+	r.batches.Add(1)
+	return r.Repository.GetByIDs(ctx, ids)
+}
+
+// graphQLResponse is the body of a /graphql response.
+type graphQLResponse struct {
+	Data   map[string]json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string         `json:"message"`
+		Extensions map[string]any `json:"extensions"`
+	} `json:"errors"`
+}
+
+// code returns the code extension of the first error of r, if any.
+func (r graphQLResponse) code() string {
+	if len(r.Errors) == 0 {
+		return ""
+	}
+	code, _ := r.Errors[0].Extensions["code"].(string)
+	return code
+}
+
+// postGraphQL runs query with variables on h.
+func postGraphQL(t *testing.T, h http.Handler, query string, variables map[string]any) graphQLResponse {
+	t.Helper()
+	body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := serve(h, "POST", "/graphql", string(body), "Content-Type", "application/json")
+	var resp graphQLResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("POST /graphql: status %d, body %s", rec.Code, rec.Body)
+	}
+	return resp
+}
+
+const createUserMutation = `mutation($username: String!, $email: String!) {
+	createUser(input: {username: $username, email: $email}) { user { id username version } updated }
+}`
+
+// createGraphQLUser creates a user through h and returns its id.
+func createGraphQLUser(t *testing.T, h http.Handler, username string) string {
+	t.Helper()
+	resp := postGraphQL(t, h, createUserMutation, map[string]any{"username": username, "email": username + "@example.com"})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("createUser %s: %+v", username, resp.Errors)
+	}
+	var created struct {
+		User struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+			Version  int    `json:"version"`
+		} `json:"user"`
+		Updated bool `json:"updated"`
+	}
+	if err := json.Unmarshal(resp.Data["createUser"], &created); err != nil {
+		t.Fatal(err)
+	}
+	if created.User.Username != username || created.User.Version != 1 || created.Updated {
+		t.Fatalf("createUser %s = %+v", username, created)
+	}
+	return created.User.ID
+}
+
+func newGraphQLHandler(repo users.Repository) http.Handler {
+	mux := http.NewServeMux()
+	registerGraphQLRoutes(mux, repo, nil, nil)
+	return mux
+}
+
+func TestGraphQLMutations(t *testing.T) {
+	h := newGraphQLHandler(newSQLiteRepository(t))
+	id := createGraphQLUser(t, h, "alice")
+
+	resp := postGraphQL(t, h, `mutation($id: ID!) { updateUser(input: {id: $id, email: "alice@example.org"}) { email version } }`,
+		map[string]any{"id": id})
+	if len(resp.Errors) > 0 || !strings.Contains(string(resp.Data["updateUser"]), `"version":2`) {
+		t.Errorf("updateUser = %s, %+v", resp.Data["updateUser"], resp.Errors)
+	}
+
+	resp = postGraphQL(t, h, `mutation($id: ID!) { deleteUser(id: $id) }`, map[string]any{"id": id})
+	if string(resp.Data["deleteUser"]) != "true" {
+		t.Errorf("deleteUser = %s, %+v", resp.Data["deleteUser"], resp.Errors)
+	}
+	resp = postGraphQL(t, h, `mutation($id: ID!) { deleteUser(id: $id) }`, map[string]any{"id": id})
+	if string(resp.Data["deleteUser"]) != "false" {
+		t.Errorf("deleteUser of a deleted user = %s, %+v", resp.Data["deleteUser"], resp.Errors)
+	}
+}
+
+func TestGraphQLErrorCodes(t *testing.T) {
+	h := newGraphQLHandler(newSQLiteRepository(t))
+	id := createGraphQLUser(t, h, "alice")
+
+	tests := []struct {
+		name      string
+		query     string
+		variables map[string]any
+		want      string
+	}{
+		{"duplicate", createUserMutation, map[string]any{"username": "alice", "email": "other@example.com"}, "CONFLICT"},
+		{"invalid email", createUserMutation, map[string]any{"username": "bob", "email": "not an email"}, "BAD_USER_INPUT"},
+		{"unknown id", `mutation { updateUser(input: {id: "999", email: "x@example.com"}) { id } }`, nil, "NOT_FOUND"},
+		{"stale version", `mutation($id: ID!) { updateUser(input: {id: $id, email: "x@example.com", version: 7}) { id } }`, map[string]any{"id": id}, "CONFLICT"},
+		{"negative limit", `{ users(limit: -1) { total } }`, nil, "BAD_USER_INPUT"},
+		{"id and username", `{ user(id: "1", username: "alice") { id } }`, nil, "BAD_USER_INPUT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if resp := postGraphQL(t, h, tt.query, tt.variables); resp.code() != tt.want {
+				t.Errorf("code = %q, want %q; errors %+v", resp.code(), tt.want, resp.Errors)
+			}
+		})
+	}
+}
+
+func TestGraphQLBatchesUserLookups(t *testing.T) {
+	repo := &countingRepository{Repository: newSQLiteRepository(t)}
+	h := newGraphQLHandler(repo)
+	alice := createGraphQLUser(t, h, "alice")
+	bob := createGraphQLUser(t, h, "bob")
+
+	resp := postGraphQL(t, h, `query($a: ID!, $b: ID!) {
+		a: user(id: $a) { username }
+		b: user(id: $b) { username }
+		again: user(id: $a) { username }
+		missing: user(id: "999") { username }
+	}`, map[string]any{"a": alice, "b": bob})
+	if len(resp.Errors) > 0 {
+		t.Fatalf("errors: %+v", resp.Errors)
+	}
+	want := map[string]string{"a": `{"username":"alice"}`, "b": `{"username":"bob"}`, "again": `{"username":"alice"}`, "missing": "null"}
+	for alias, data := range want {
+		if got := string(resp.Data[alias]); got != data {
+			t.Errorf("%s = %s, want %s", alias, got, data)
+		}
+	}
+	if n := repo.batches.Load(); n != 1 {
+		t.Errorf("GetByIDs called %d times, want once", n)
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"github.com/hozana-dusabimana/users"
+	"github.com/hozana-dusabimana/userspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// userService implements userspb.UserServiceServer over a users.Repository,
+// as usersHandler does the REST API.
+type userService struct {
+	userspb.UnimplementedUserServiceServer
+	repo            users.Repository
+	feed            *Feed
+	feedUnavailable string  // why feed is nil
+	source          *string // provenance of created users
+}
+
+// GRPCServer returns a gRPC server offering the UserService over the
+// repository, with the feed serving WatchUsers. With credentials
+// configured, the calls that write need them. Server reflection is enabled
+// so tools such as grpcurl can discover the API.
+func (s *Server) GRPCServer() *grpc.Server {
+	unary := []grpc.UnaryServerInterceptor{logUnaryCalls, s.defaultDeadline}
+	if s.auth != nil {
+		unary = append(unary, s.auth.authorizeWrites)
+	}
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(logStreamCalls),
+	)
+	userspb.RegisterUserServiceServer(srv, &userService{
+		repo:            s.deps.Repo,
+		feed:            s.deps.Feed,
+		feedUnavailable: s.deps.FeedUnavailable,
+		source:          s.provenance("grpc"),
+	})
+	reflection.Register(srv)
+	return srv
+}
+
+// ServeGRPC serves srv on addr, sending the error that stops it to errs.
+func ServeGRPC(srv *grpc.Server, addr string, errs chan<- error) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		slog.Info("serving users gRPC API", "addr", addr)
+		errs <- srv.Serve(lis)
+	}()
+	return nil
+}
+
+// StopGRPC stops srv from accepting calls and waits up to grace for those
+// in progress, then cancels what is left.
+func StopGRPC(srv *grpc.Server, grace time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(grace):
+		srv.Stop()
+	}
+}
+
+// logUnaryCalls logs every call with its status code and duration. As
+// logRequests does for HTTP, it gives the call a request id, taken from
+// its x-request-id metadata when usable and sent back in the header, and
+// answers a handler that panics with Internal.
+func logUnaryCalls(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	start := time.Now()
+	ctx = grpcRequestID(ctx)
+	defer func() {
+		if p := recover(); p != nil {
+			slog.ErrorContext(ctx, "handler panicked", "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal error")
+		}
+		logCall(ctx, info.FullMethod, start, err)
+	}()
+	return handler(ctx, req)
+}
+
+// logStreamCalls logs every stream as logUnaryCalls does a call, once it
+// ends.
+func logStreamCalls(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	start := time.Now()
+	ctx := grpcRequestID(ss.Context())
+	defer func() {
+		if p := recover(); p != nil {
+			slog.ErrorContext(ctx, "handler panicked", "panic", p, "stack", string(debug.Stack()))
+			err = status.Error(codes.Internal, "internal error")
+		}
+		logCall(ctx, info.FullMethod, start, err)
+	}()
+	return handler(srv, &requestIDStream{ServerStream: ss, ctx: ctx})
+}
+
+// grpcRequestID returns ctx carrying the request id of the call, and sends
+// the id back in the response header.
+func grpcRequestID(ctx context.Context) context.Context {
+	var sent string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(requestIDHeader); len(v) > 0 {
+			sent = v[0]
+		}
+	}
+	id := incomingRequestID(sent)
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	return withRequestID(ctx, id)
+}
+
+// requestIDStream is a grpc.ServerStream whose context carries the request
+// id.
+type requestIDStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDStream) Context() context.Context { return s.ctx }
+
+func logCall(ctx context.Context, method string, start time.Time, err error) {
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "grpc call", "method", method, "code", status.Code(err).String(),
+		"elapsed", time.Since(start).Round(time.Microsecond))
+}
+
+// defaultDeadline gives a call that arrives without a deadline one of
+// GRPC_TIMEOUT, so a client that never gives up cannot hold a connection
+// of the pool forever. A deadline set by the client is kept as it is.
+// WatchUsers is a stream and is meant to run until the client leaves, so
+// it is not limited.
+func (s *Server) defaultDeadline(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if _, ok := ctx.Deadline(); !ok && s.cfg.App.GRPCTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.App.GRPCTimeout)
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+func (s *userService) CreateUser(ctx context.Context, req *userspb.CreateUserRequest) (*userspb.CreateUserResponse, error) {
+	u := users.User{Username: req.GetUsername(), Email: req.GetEmail(), Source: s.source}
+	if err := users.Validate(u); err != nil {
+		return nil, grpcError(err)
+	}
+	err := s.repo.Create(ctx, &u)
+	updated := errors.Is(err, users.ErrUpdated)
+	if err != nil && !updated {
+		return nil, grpcError(err)
+	}
+	return &userspb.CreateUserResponse{User: userToProto(&u), Updated: updated}, nil
+}
+
+func (s *userService) GetUser(ctx context.Context, req *userspb.GetUserRequest) (*userspb.User, error) {
+	var u *users.User
+	var err error
+	switch key := req.GetKey().(type) {
+	case *userspb.GetUserRequest_Id:
+		u, err = s.lookup(ctx, key.Id)
+	case *userspb.GetUserRequest_Username:
+		u, err = s.repo.GetByUsername(ctx, key.Username)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "id or username is required")
+	}
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return userToProto(u), nil
+}
+
+func (s *userService) ListUsers(ctx context.Context, req *userspb.ListUsersRequest) (*userspb.ListUsersResponse, error) {
+	if req.GetLimit() < 0 || req.GetOffset() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit and offset must not be negative")
+	}
+	page := users.Page{
+		Limit:  int(req.GetLimit()),
+		Offset: int(req.GetOffset()),
+		After:  req.GetAfter(),
+		Filter: users.Filter{UsernamePrefix: req.GetUsernamePrefix(), EmailDomain: req.GetEmailDomain()},
+	}
+	if req.CreatedAfter != nil {
+		page.Filter.CreatedAfter = req.GetCreatedAfter().AsTime()
+	}
+	if req.CreatedBefore != nil {
+		page.Filter.CreatedBefore = req.GetCreatedBefore().AsTime()
+	}
+	records, next, err := s.repo.List(ctx, page)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	total, err := s.repo.Count(ctx, page.Filter)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &userspb.ListUsersResponse{Total: total, NextCursor: next}
+	for i := range records {
+		resp.Users = append(resp.Users, userToProto(&records[i]))
+	}
+	return resp, nil
+}
+
+func (s *userService) UpdateUser(ctx context.Context, req *userspb.UpdateUserRequest) (*userspb.User, error) {
+	u, err := s.lookup(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	u.Email = req.GetEmail()
+	if err := users.ValidateEmail(u.Email); err != nil {
+		return nil, grpcError(err)
+	}
+	if req.Version != nil && req.GetVersion() < 1 {
+		return nil, status.Error(codes.InvalidArgument, "version must be positive")
+	}
+	u.Version, u.UpdatedAt = req.GetVersion(), time.Time{}
+	if req.UpdatedAt != nil {
+		u.UpdatedAt = req.GetUpdatedAt().AsTime()
+	}
+	if err := s.repo.Update(ctx, u); err != nil {
+		return nil, grpcError(err)
+	}
+	return userToProto(u), nil
+}
+
+func (s *userService) DeleteUser(ctx context.Context, req *userspb.DeleteUserRequest) (*emptypb.Empty, error) {
+	u, err := s.lookup(ctx, req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	if err := s.repo.Delete(ctx, u.Username); err != nil {
+		return nil, grpcError(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// WatchUsers sends the users of the live feed, as /ws does, until the
+// client cancels or the server shuts down.
+func (s *userService) WatchUsers(req *userspb.WatchUsersRequest, stream grpc.ServerStreamingServer[userspb.User]) error {
+	if s.feed == nil {
+		return status.Error(codes.Unimplemented, "the live feed relies on LISTEN/NOTIFY: "+s.feedUnavailable)
+	}
+	c := s.feed.subscribe()
+	defer s.feed.unsubscribe(c)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-s.feed.stopping:
+			return status.Error(codes.Unavailable, "server shutting down")
+		case <-c.slow:
+			return status.Error(codes.ResourceExhausted, "client too slow; reconnect")
+		case msg := <-c.send:
+			var t FeedUser
+			if err := json.Unmarshal(msg, &t); err != nil {
+				return status.Error(codes.Internal, "decoding user: "+err.Error())
+			}
+			u := &userspb.User{Id: t.ID.String(), Username: t.Username, Email: t.Email, CreatedAt: timestamppb.New(t.CreatedAt)}
+			if err := stream.Send(u); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// lookup loads the user with the given id; an id that is neither an
+// integer nor a UUID names no user.
+func (s *userService) lookup(ctx context.Context, id string) (*users.User, error) {
+	parsed, err := users.ParseID(id)
+	if err != nil {
+		return nil, users.ErrNotFound
+	}
+	return s.repo.GetByID(ctx, parsed)
+}
+
+func userToProto(u *users.User) *userspb.User {
+	return &userspb.User{
+		Id:        u.ID.String(),
+		Username:  u.Username,
+		Email:     u.Email,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		UpdatedAt: timestamppb.New(u.UpdatedAt),
+		Version:   u.Version,
+		Source:    u.Source,
+	}
+}
+
+// grpcError maps repository errors to status codes as writeError does to
+// HTTP ones. Anything unexpected is logged and reported as a bare Internal
+// so database details do not leak.
+func grpcError(err error) error {
+	var open Unavailable
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.As(err, &open):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, users.ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, users.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, users.ErrDuplicate):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, users.ErrStaleRecord):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, users.ErrForeignKeyViolation):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, users.ErrReadOnly):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, users.ErrConnectionFailed):
+		slog.Error("grpc call failed", "err", err)
+		return status.Error(codes.Unavailable, users.ErrConnectionFailed.Error())
+	}
+	slog.Error("grpc call failed", "err", err)
+	return status.Error(codes.Internal, "internal error")
+}
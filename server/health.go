@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/hozana-dusabimana/migrations"
+)
+
+// readyTimeout bounds each readiness check, so a database that hangs fails
+// the probe instead of piling up probe requests.
+const readyTimeout = 2 * time.Second
+
+// readiness is the body of GET /readyz.
+type readiness struct {
+	Ready      bool     `json:"ready"`
+	Problems   []string `json:"problems,omitempty"`
+	Migrations struct {
+		Current int64 `json:"current"`
+		Latest  int64 `json:"latest"`
+		Pending int   `json:"pending"`
+	} `json:"migrations"`
+	Pool poolSaturation `json:"pool"`
+	// Breaker is the circuit breaker state of the users repository:
+	// closed, half-open or open. It is informational, like Pool.
+	Breaker string `json:"breaker,omitempty"`
+}
+
+// poolSaturation describes how busy the connection pool is. Saturation is
+// the share of MaxConns in use; near 1, requests start queueing for a
+// connection, and WaitCount shows how many already had to.
+type poolSaturation struct {
+	Acquired   int32   `json:"acquired"`
+	Idle       int32   `json:"idle"`
+	Total      int32   `json:"total"`
+	Max        int32   `json:"max"`
+	Saturation float64 `json:"saturation"`
+	WaitCount  int64   `json:"wait_count"`
+}
+
+// registerHealthRoutes adds the Kubernetes-style probes to mux:
+//
+//	GET /healthz  200 while the process serves requests (liveness)
+//	GET /readyz   200 when the database answers and every migration is
+//	              applied, 503 otherwise (readiness)
+//
+// A saturated pool is reported by /readyz but does not fail it: taking a
+// busy replica out of rotation would only push its load onto the others.
+func (s *Server) registerHealthRoutes(mux *apiMux) {
+	mux.handle("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}, apiOp{
+		ID: "healthz", Tag: "health", Summary: "Liveness probe",
+		Responses: []apiResponse{{Status: http.StatusOK, Description: `The process serves requests: {"status": "ok"}.`, Body: map[string]string{}}},
+	})
+	mux.handle("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready := s.checkReadiness(r.Context())
+		status := http.StatusOK
+		if !ready.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, ready)
+	}, apiOp{
+		ID: "readyz", Tag: "health", Summary: "Readiness probe",
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The database answers and every migration is applied.", Body: readiness{}},
+			{Status: http.StatusServiceUnavailable, Description: "Not ready; problems says why.", Body: readiness{}},
+		},
+	})
+}
+
+// checkReadiness runs SELECT 1 and compares the applied migrations with the
+// embedded ones, each within readyTimeout.
+func (s *Server) checkReadiness(ctx context.Context) readiness {
+	var ready readiness
+	if s.deps.BreakerState != nil {
+		ready.Breaker = s.deps.BreakerState()
+	}
+	pool, schema := s.deps.Pool, s.cfg.Database.Schema
+	stat := pool.Stat()
+	ready.Pool = poolSaturation{
+		Acquired:  stat.AcquiredConns(),
+		Idle:      stat.IdleConns(),
+		Total:     stat.TotalConns(),
+		Max:       stat.MaxConns(),
+		WaitCount: stat.EmptyAcquireCount(),
+	}
+	if ready.Pool.Max > 0 {
+		ready.Pool.Saturation = float64(ready.Pool.Acquired) / float64(ready.Pool.Max)
+	}
+
+	pingCtx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	if _, err := pool.Exec(pingCtx, "SELECT 1"); err != nil {
+		ready.Problems = append(ready.Problems, "database unreachable: "+err.Error())
+		return ready
+	}
+
+	migrateCtx, cancel := context.WithTimeout(ctx, readyTimeout)
+	defer cancel()
+	ready.Migrations.Latest = migrations.Latest()
+	current, err := migrations.Current(migrateCtx, pool, schema)
+	if err == nil {
+		var pending []migrations.Step
+		pending, err = migrations.PlanUp(migrateCtx, pool, schema)
+		ready.Migrations.Current, ready.Migrations.Pending = current, len(pending)
+	}
+	switch {
+	case err != nil:
+		ready.Problems = append(ready.Problems, "reading migrations: "+err.Error())
+	case ready.Migrations.Pending > 0:
+		ready.Problems = append(ready.Problems, "migrations pending")
+	}
+	ready.Ready = len(ready.Problems) == 0
+	return ready
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// idempotencyKeyHeader names the request header that makes a POST
+	// idempotent.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayHeader marks a response that was stored by an
+	// earlier request with the same key.
+	idempotencyReplayHeader = "Idempotent-Replayed"
+
+	// IdempotencyTableName is the table that records Idempotency-Key
+	// requests and their responses.
+	IdempotencyTableName = "idempotency_keys"
+)
+
+func (s *Server) idempotencyTable() string {
+	return pgx.Identifier{s.cfg.Database.Schema, IdempotencyTableName}.Sanitize()
+}
+
+type idempotencyTxKey struct{}
+
+// idempotent makes the POST handler next answer each Idempotency-Key once.
+// The first request with a key runs next in a transaction of the pool that
+// also records the key, the hash of the request and the response; next
+// writes through txUserRepository, so its insert commits with the
+// response or not at all. A later request with the key gets the stored
+// response back, marked with Idempotent-Replayed, without running next;
+// with another body it is refused with 422. A request that arrives while
+// the first is still running waits for it on the key's row. Responses of
+// 5xx are not stored, so a retry runs next again. Requests without the
+// header, and with READ_ONLY every request, go straight to next.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	table := s.idempotencyTable()
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(idempotencyKeyHeader)
+		if key == "" || s.cfg.Database.ReadOnly {
+			next(w, r)
+			return
+		}
+		if len(key) > 255 {
+			writeError(w, r, fmt.Errorf("%w: %s is longer than 255 characters", users.ErrInvalid, idempotencyKeyHeader))
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorBody{"reading the body: " + err.Error()})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		var stored *bufferedResponse
+		var storedHash string
+		res := &bufferedResponse{header: http.Header{}}
+		err = db.WithTx(r.Context(), s.deps.Pool, func(tx pgx.Tx) error {
+			tag, err := tx.Exec(r.Context(), "INSERT INTO "+table+" (key, request_hash) VALUES ($1, $2) ON CONFLICT (key) DO NOTHING", key, hash)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() == 0 {
+				stored = &bufferedResponse{header: http.Header{}}
+				var location string
+				var response []byte
+				err := tx.QueryRow(r.Context(), "SELECT request_hash, status, coalesce(location, ''), response FROM "+table+" WHERE key = $1", key).
+					Scan(&storedHash, &stored.status, &location, &response)
+				if location != "" {
+					stored.header.Set("Location", location)
+				}
+				stored.body.Write(response)
+				return err
+			}
+
+			// A savepoint, so a failed insert leaves the transaction
+			// able to record its response
+			sp, err := tx.Begin(r.Context())
+			if err != nil {
+				return err
+			}
+			next(res, r.WithContext(context.WithValue(r.Context(), idempotencyTxKey{}, sp)))
+			if res.status >= http.StatusInternalServerError {
+				return errResponseNotStored
+			}
+			if res.status >= http.StatusBadRequest {
+				err = sp.Rollback(r.Context())
+			} else {
+				err = sp.Commit(r.Context())
+			}
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(r.Context(), "UPDATE "+table+" SET status = $2, location = nullif($3, ''), response = $4 WHERE key = $1",
+				key, res.status, res.header.Get("Location"), res.body.String())
+			return err
+		})
+		switch {
+		case errors.Is(err, errResponseNotStored):
+			res.writeTo(w)
+		case err != nil:
+			writeError(w, r, err)
+		case stored == nil:
+			res.writeTo(w)
+		case storedHash != hash:
+			writeJSON(w, http.StatusUnprocessableEntity, errorBody{idempotencyKeyHeader + " was already used with another request"})
+		default:
+			stored.header.Set("Content-Type", "application/json")
+			stored.header.Set(idempotencyReplayHeader, "true")
+			stored.writeTo(w)
+		}
+	}
+}
+
+// errResponseNotStored rolls back the transaction of idempotent after a
+// server error.
+var errResponseNotStored = errors.New("response not stored")
+
+// txUserRepository returns a repository made by txRepo on the transaction
+// idempotent put in ctx, or repo when there is none.
+func txUserRepository(ctx context.Context, repo users.Repository, txRepo func(users.Querier) users.Repository) users.Repository {
+	if tx, ok := ctx.Value(idempotencyTxKey{}).(pgx.Tx); ok {
+		return txRepo(tx)
+	}
+	return repo
+}
+
+// bufferedResponse is an http.ResponseWriter that keeps the response, so
+// idempotent can store it before sending it.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+// writeTo sends the response to w.
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	for name, values := range b.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(b.body.Len()))
+	w.WriteHeader(b.status)
+	w.Write(b.body.Bytes())
+}
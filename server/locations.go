@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hozana-dusabimana/geo"
+	"github.com/hozana-dusabimana/users"
+)
+
+// locationsHandler serves the locations of users:
+//
+//	PUT /users/{id}/location  store the location {"lat", "lon"} of a user
+//	GET /users/near           users within ?km (default 10) of ?lat and
+//	                          ?lon, nearest first; ?limit defaults to 20
+type locationsHandler struct {
+	store users.LocationStore
+}
+
+// registerLocationRoutes adds the location endpoints to mux, served by
+// store.
+func registerLocationRoutes(mux *apiMux, store users.LocationStore) {
+	h := &locationsHandler{store: store}
+	mux.handle("PUT /users/{id}/location", h.set, apiOp{
+		ID: "setUserLocation", Tag: "locations", Summary: "Store the location of a user, replacing any previous one",
+		Params: []apiParam{{Name: "id", In: "path", Description: "The id of the user: an integer or a UUID."}},
+		Body:   geo.Point{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The location, stored.", Body: geo.Point{}},
+			apiError(http.StatusBadRequest, "The point is not on Earth."),
+			apiError(http.StatusNotFound, "No user has this id."),
+		},
+	})
+	mux.handle("GET /users/near", h.near, apiOp{
+		ID: "listUsersNear", Tag: "locations", Summary: "List the users near a point, nearest first",
+		Params: []apiParam{
+			{Name: "lat", Type: 0.0, Required: true, Description: "Latitude in degrees, -90 to 90."},
+			{Name: "lon", Type: 0.0, Required: true, Description: "Longitude in degrees, -180 to 180."},
+			{Name: "km", Type: 0.0, Description: "The distance, in kilometres; 10 by default."},
+			{Name: "limit", Type: 0, Description: "The most users to return; 20 by default."},
+		},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The users within km of the point.", Body: map[string][]users.NearbyUser{}},
+			apiError(http.StatusBadRequest, "A parameter is missing or malformed."),
+		},
+	})
+}
+
+func (h *locationsHandler) set(w http.ResponseWriter, r *http.Request) {
+	id, err := users.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, users.ErrNotFound)
+		return
+	}
+	var p geo.Point
+	if !decodeJSON(w, r, &p) {
+		return
+	}
+	if err := p.Validate(); err != nil {
+		writeError(w, r, fmt.Errorf("%w: %v", users.ErrInvalid, err))
+		return
+	}
+	if err := h.store.SetLocation(r.Context(), id, p); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *locationsHandler) near(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	params := map[string]float64{"lat": 0, "lon": 0, "km": 10, "limit": 20}
+	for name := range params {
+		s := q.Get(name)
+		if s == "" {
+			if name == "lat" || name == "lon" {
+				writeError(w, r, fmt.Errorf("%w: %s is required", users.ErrInvalid, name))
+				return
+			}
+			continue
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			writeError(w, r, fmt.Errorf("%w: %s must be a number", users.ErrInvalid, name))
+			return
+		}
+		if f < 0 && (name == "km" || name == "limit") {
+			writeError(w, r, fmt.Errorf("%w: %s must not be negative", users.ErrInvalid, name))
+			return
+		}
+		params[name] = f
+	}
+	center := geo.Point{Lat: params["lat"], Lon: params["lon"]}
+	if err := center.Validate(); err != nil {
+		writeError(w, r, fmt.Errorf("%w: %v", users.ErrInvalid, err))
+		return
+	}
+	found, err := h.store.UsersNear(r.Context(), center, params["km"]*1000, int(params["limit"]))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if found == nil {
+		found = []users.NearbyUser{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": found})
+}
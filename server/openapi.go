@@ -0,0 +1,321 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/hozana-dusabimana/users"
+	swaggerFiles "github.com/swaggo/files/v2"
+)
+
+// apiMux is the http.ServeMux of serve that also documents the JSON
+// endpoints registered with handle. The OpenAPI document served at
+// /openapi.json is built from the same calls that route the requests, and
+// the schemas of bodies from the Go types the handlers read and write, so
+// it cannot fall out of step with them. Endpoints registered on the
+// embedded mux directly, such as /metrics and /ws, are left out.
+//
+// With auth set, the endpoints registered with handle for methods other
+// than GET require its credentials, and are documented so.
+type apiMux struct {
+	*http.ServeMux
+	auth    *authenticator
+	paths   map[string]map[string]any // path, then lowercase method
+	schemas map[string]any            // components/schemas, by type name
+}
+
+func newAPIMux(auth *authenticator) *apiMux {
+	return &apiMux{ServeMux: http.NewServeMux(), auth: auth, paths: map[string]map[string]any{}, schemas: map[string]any{}}
+}
+
+// apiOp describes an endpoint for the OpenAPI document.
+type apiOp struct {
+	ID      string // operationId, the name client generators give it
+	Tag     string
+	Summary string
+	// Params are the query parameters, and the path parameters where
+	// they need a description or type; others are documented as strings.
+	Params []apiParam
+	// Body is a value of the type the handler decodes the request body
+	// into; nil when it reads none.
+	Body      any
+	Responses []apiResponse
+}
+
+type apiParam struct {
+	Name        string
+	In          string // query unless path or header
+	Description string
+	Type        any // a value of the parameter's type; string when nil
+	Required    bool
+}
+
+type apiResponse struct {
+	Status      int
+	Description string
+	Body        any // a value of the type of the JSON body; nil when none
+}
+
+// errorBody is the body of every error response.
+type errorBody struct {
+	Error string `json:"error"`
+}
+
+// apiError documents an error response with an errorBody.
+func apiError(status int, description string) apiResponse {
+	return apiResponse{Status: status, Description: description, Body: errorBody{}}
+}
+
+// apiSchemas are the schemas of types whose JSON form is not that of
+// their Go kind.
+var apiSchemas = map[reflect.Type]map[string]any{
+	reflect.TypeFor[time.Time](): {"type": "string", "format": "date-time"},
+	// An integer id is written as a number, a UUID as a string
+	reflect.TypeFor[users.ID](): {
+		"oneOf":       []any{map[string]any{"type": "integer", "format": "int64"}, map[string]any{"type": "string", "format": "uuid"}},
+		"description": "An integer or a UUID, as ID_TYPE chose when the table was created.",
+		"example":     42,
+	},
+}
+
+// apiReadOnly lists the fields the server sets, which a client may send
+// but that are ignored.
+var apiReadOnly = map[reflect.Type][]string{
+	reflect.TypeFor[users.User](): {"id", "created_at", "updated_at", "version", "source", "deleted_at"},
+	reflect.TypeFor[users.Post](): {"id", "user_id", "created_at"},
+}
+
+// apiSecuritySchemes are the schemes an authenticator may accept, by the
+// names authenticator.schemes gives them.
+var apiSecuritySchemes = map[string]any{
+	"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+	"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": apiKeyHeader},
+}
+
+var pathParam = regexp.MustCompile(`\{(\w+)\}`)
+
+// handle registers h for pattern, as HandleFunc does, and adds op to the
+// document under the method and path of pattern.
+func (m *apiMux) handle(pattern string, h http.HandlerFunc, op apiOp) {
+	method, path, _ := strings.Cut(pattern, " ")
+	if m.auth != nil && method != http.MethodGet {
+		h = m.auth.requireAuth(h)
+		op.Responses = append(op.Responses,
+			apiError(http.StatusUnauthorized, "The credentials are missing or invalid."),
+			apiError(http.StatusForbidden, "The token lacks the scope JWT_SCOPE requires."))
+	}
+	m.HandleFunc(pattern, h)
+
+	operation := map[string]any{"operationId": op.ID, "summary": op.Summary}
+	if op.Tag != "" {
+		operation["tags"] = []string{op.Tag}
+	}
+	var params []any
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		p := apiParam{Name: match[1], In: "path", Required: true}
+		if i := slices.IndexFunc(op.Params, func(q apiParam) bool { return q.In == "path" && q.Name == p.Name }); i >= 0 {
+			p.Description, p.Type = op.Params[i].Description, op.Params[i].Type
+		}
+		params = append(params, m.param(p))
+	}
+	for _, p := range op.Params {
+		if p.In != "path" {
+			if p.In == "" {
+				p.In = "query"
+			}
+			params = append(params, m.param(p))
+		}
+	}
+	if params != nil {
+		operation["parameters"] = params
+	}
+	if op.Body != nil {
+		operation["requestBody"] = map[string]any{"required": true, "content": m.jsonContent(op.Body)}
+	}
+	responses := map[string]any{}
+	for _, r := range op.Responses {
+		response := map[string]any{"description": r.Description}
+		if r.Body != nil {
+			response["content"] = m.jsonContent(r.Body)
+		}
+		responses[strconv.Itoa(r.Status)] = response
+	}
+	operation["responses"] = responses
+	if m.auth != nil && method != http.MethodGet {
+		var security []any
+		for _, scheme := range m.auth.schemes() {
+			security = append(security, map[string]any{scheme: []string{}})
+		}
+		operation["security"] = security
+	}
+
+	if m.paths[path] == nil {
+		m.paths[path] = map[string]any{}
+	}
+	m.paths[path][strings.ToLower(method)] = operation
+}
+
+func (m *apiMux) param(p apiParam) map[string]any {
+	schema := map[string]any{"type": "string"}
+	if p.Type != nil {
+		schema = m.schema(reflect.TypeOf(p.Type))
+	}
+	param := map[string]any{"name": p.Name, "in": p.In, "required": p.Required, "schema": schema}
+	if p.Description != "" {
+		param["description"] = p.Description
+	}
+	return param
+}
+
+func (m *apiMux) jsonContent(v any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": m.schema(reflect.TypeOf(v))}}
+}
+
+// schema returns the JSON Schema of t as encoding/json writes it. Named
+// structs are added to the components and referred to.
+func (m *apiMux) schema(t reflect.Type) map[string]any {
+	if s, ok := apiSchemas[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		// OpenAPI 3.0 ignores the siblings of a $ref, so a nullable
+		// reference is wrapped
+		s := m.schema(t.Elem())
+		if s["$ref"] != nil {
+			return map[string]any{"allOf": []any{s}, "nullable": true}
+		}
+		nullable := map[string]any{"nullable": true}
+		for k, v := range s {
+			nullable[k] = v
+		}
+		return nullable
+	case reflect.Struct:
+		if t.Name() == "" {
+			return m.structSchema(t)
+		}
+		name := []rune(t.Name())
+		name[0] = unicode.ToUpper(name[0])
+		if _, ok := m.schemas[string(name)]; !ok {
+			m.schemas[string(name)] = nil // placed first, in case t refers to itself
+			m.schemas[string(name)] = m.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + string(name)}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": m.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": m.schema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	}
+	return map[string]any{}
+}
+
+// structSchema returns the object schema of the struct t. Fields without
+// omitempty are required, as encoding/json always writes them, and a
+// validate tag's max= becomes the maxLength of a string.
+func (m *apiMux) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		prop := m.schema(f.Type)
+		extra := map[string]any{}
+		for _, rule := range strings.Split(f.Tag.Get("validate"), ",") {
+			if n, ok := strings.CutPrefix(rule, "max="); ok && f.Type.Kind() == reflect.String {
+				extra["maxLength"], _ = strconv.Atoi(n)
+			}
+		}
+		if slices.Contains(apiReadOnly[t], name) {
+			extra["readOnly"] = true
+		}
+		if len(extra) > 0 {
+			if prop["$ref"] != nil {
+				extra["allOf"] = []any{prop}
+			} else {
+				for k, v := range prop {
+					extra[k] = v
+				}
+			}
+			prop = extra
+		}
+		properties[name] = prop
+		if !strings.Contains(opts, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	schema := map[string]any{"type": "object", "properties": properties}
+	if required != nil {
+		schema["required"] = required
+	}
+	return schema
+}
+
+// serveDocs adds GET /openapi.json, the document of the endpoints
+// registered so far, and GET /docs/, Swagger UI showing it. Register it
+// last.
+func (m *apiMux) serveDocs(version string) {
+	doc := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Users API",
+			"version": version,
+			"description": "The users table served as JSON. Errors are answered as " +
+				`{"error": "..."}` + " with a status chosen from the kind of error, never from its text.",
+		},
+		"paths":      m.paths,
+		"components": map[string]any{"schemas": m.schemas},
+	}
+	if m.auth != nil {
+		schemes := map[string]any{}
+		for _, scheme := range m.auth.schemes() {
+			schemes[scheme] = apiSecuritySchemes[scheme]
+		}
+		doc["components"].(map[string]any)["securitySchemes"] = schemes
+	}
+	m.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, doc)
+	})
+	m.Handle("GET /docs/", http.StripPrefix("/docs/", http.FileServerFS(swaggerFiles.FS)))
+	m.HandleFunc("GET /docs/swagger-initializer.js", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/javascript; charset=utf-8")
+		w.Write([]byte(swaggerInitializer))
+	})
+}
+
+// swaggerInitializer replaces the script of the Swagger UI distribution
+// that names the document to show, which points at an example.
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+)
+
+// openAPIDoc is the part of the OpenAPI document the tests look at.
+type openAPIDoc struct {
+	OpenAPI string `json:"openapi"`
+	Info    struct {
+		Version string `json:"version"`
+	} `json:"info"`
+	Paths map[string]map[string]struct {
+		OperationID string           `json:"operationId"`
+		Parameters  []map[string]any `json:"parameters"`
+		Responses   map[string]any   `json:"responses"`
+		Security    []map[string]any `json:"security"`
+	} `json:"paths"`
+	Components struct {
+		Schemas         map[string]map[string]any `json:"schemas"`
+		SecuritySchemes map[string]any            `json:"securitySchemes"`
+	} `json:"components"`
+}
+
+func getOpenAPIDoc(t *testing.T, h http.Handler) openAPIDoc {
+	t.Helper()
+	rec := serve(h, "GET", "/openapi.json", "")
+	var doc openAPIDoc
+	if err := json.Unmarshal(rec.Body.Bytes(), &doc); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json: status %d, body %s", rec.Code, rec.Body)
+	}
+	return doc
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	s, err := New(config.Config{}, Deps{Logger: quietLogger, Version: "1.2.3", Repo: newSQLiteRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := getOpenAPIDoc(t, s.Handler())
+	if doc.OpenAPI != "3.0.3" || doc.Info.Version != "1.2.3" {
+		t.Errorf("openapi %q, version %q", doc.OpenAPI, doc.Info.Version)
+	}
+	for path, methods := range map[string][]string{
+		"/users":      {"get", "post"},
+		"/users/{id}": {"get", "put", "delete"},
+	} {
+		for _, method := range methods {
+			op, ok := doc.Paths[path][method]
+			if !ok {
+				t.Errorf("%s %s is not documented", method, path)
+				continue
+			}
+			if op.OperationID == "" || len(op.Responses) == 0 {
+				t.Errorf("%s %s = %+v, want an operationId and responses", method, path, op)
+			}
+			if op.Security != nil {
+				t.Errorf("%s %s requires credentials without any configured", method, path)
+			}
+		}
+	}
+	if _, ok := doc.Paths["/metrics"]; ok {
+		t.Error("/metrics, registered on the mux directly, is documented")
+	}
+	if _, ok := doc.Components.Schemas["User"]; !ok {
+		t.Errorf("schemas %v lack User", slices.Collect(maps.Keys(doc.Components.Schemas)))
+	}
+	if doc.Components.SecuritySchemes != nil {
+		t.Errorf("securitySchemes = %v without credentials", doc.Components.SecuritySchemes)
+	}
+}
+
+func TestOpenAPIDocumentsAuth(t *testing.T) {
+	h := newTestHandler(t, config.Config{App: config.App{APIKeys: []string{"secret"}}})
+	doc := getOpenAPIDoc(t, h)
+	if _, ok := doc.Components.SecuritySchemes["apiKey"]; !ok {
+		t.Fatalf("securitySchemes = %v, want apiKey", doc.Components.SecuritySchemes)
+	}
+	if op := doc.Paths["/users"]["post"]; op.Security == nil || op.Responses["401"] == nil {
+		t.Errorf("POST /users = %+v, want security and a 401 response", op)
+	}
+	if op := doc.Paths["/users"]["get"]; op.Security != nil {
+		t.Errorf("GET /users requires credentials: %v", op.Security)
+	}
+}
+
+func TestSwaggerUI(t *testing.T) {
+	h := newTestHandler(t, config.Config{})
+	rec := serve(h, "GET", "/docs/", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "swagger-ui") {
+		t.Errorf("GET /docs/: status %d", rec.Code)
+	}
+	rec = serve(h, "GET", "/docs/swagger-initializer.js", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `url: "/openapi.json"`) {
+		t.Errorf("GET /docs/swagger-initializer.js: status %d, body %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/javascript") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
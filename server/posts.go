@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/hozana-dusabimana/users"
+)
+
+// postsHandler serves the posts of users:
+//
+//	POST /users/{id}/posts      add a post {"title", "body"} of a user
+//	GET  /users/{id}/posts      the posts of a user, newest first; ?limit
+//	                            defaults to 20
+//	GET  /users/post-counts     users with their number of posts, most
+//	                            first; ?limit defaults to 20
+type postsHandler struct {
+	repo users.PostStore
+}
+
+// registerPostRoutes adds the posts endpoints to mux, served by repo.
+func registerPostRoutes(mux *apiMux, repo users.PostStore) {
+	h := &postsHandler{repo: repo}
+	id := apiParam{Name: "id", In: "path", Description: "The id of the user: an integer or a UUID."}
+	limit := apiParam{Name: "limit", Type: 0, Description: "The most entries to return; 20 by default, 0 for all."}
+	mux.handle("POST /users/{id}/posts", h.create, apiOp{
+		ID: "createPost", Tag: "posts", Summary: "Add a post written by a user",
+		Params: []apiParam{id},
+		Body:   users.Post{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "The post, created.", Body: users.Post{}},
+			apiError(http.StatusBadRequest, "The title is missing or too long."),
+			apiError(http.StatusNotFound, "No user has this id."),
+		},
+	})
+	mux.handle("GET /users/{id}/posts", h.list, apiOp{
+		ID: "listPosts", Tag: "posts", Summary: "List the posts of a user, newest first",
+		Params: []apiParam{id, limit},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The posts of the user; none for an unknown user.", Body: map[string][]users.Post{}},
+			apiError(http.StatusBadRequest, "The limit is malformed."),
+			apiError(http.StatusNotFound, "The id is neither an integer nor a UUID."),
+		},
+	})
+	mux.handle("GET /users/post-counts", h.counts, apiOp{
+		ID: "listPostCounts", Tag: "posts", Summary: "List users with the number of posts each has written, most first",
+		Params: []apiParam{limit},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The users and their post counts.", Body: map[string][]users.UserPosts{}},
+			apiError(http.StatusBadRequest, "The limit is malformed."),
+		},
+	})
+}
+
+func (h *postsHandler) create(w http.ResponseWriter, r *http.Request) {
+	id, err := users.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, users.ErrNotFound)
+		return
+	}
+	var p users.Post
+	if !decodeJSON(w, r, &p) {
+		return
+	}
+	p.UserID = id
+	if err := h.repo.CreatePost(r.Context(), &p); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (h *postsHandler) list(w http.ResponseWriter, r *http.Request) {
+	id, err := users.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, users.ErrNotFound)
+		return
+	}
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	posts, err := h.repo.Posts(r.Context(), id, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if posts == nil {
+		posts = []users.Post{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"posts": posts})
+}
+
+func (h *postsHandler) counts(w http.ResponseWriter, r *http.Request) {
+	limit, ok := limitParam(w, r)
+	if !ok {
+		return
+	}
+	counts, err := h.repo.PostCounts(r.Context(), limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if counts == nil {
+		counts = []users.UserPosts{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"users": counts})
+}
+
+// limitParam reads ?limit, 20 when absent. When it is malformed it writes
+// the error response and returns false.
+func limitParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	s := r.URL.Query().Get("limit")
+	if s == "" {
+		return 20, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		writeError(w, r, fmt.Errorf("%w: limit must be a non-negative integer", users.ErrInvalid))
+		return 0, false
+	}
+	return n, true
+}
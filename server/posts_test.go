@@ -0,0 +1,144 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+)
+
+// memoryPosts is a users.PostStore in memory, knowing the user 1 alone.
+type memoryPosts struct {
+	posts []users.Post
+}
+
+func (m *memoryPosts) CreatePost(ctx context.Context, p *users.Post) error {
+	if err := users.ValidatePost(*p); err != nil {
+		return err
+	}
+	if p.UserID != "1" {
+		return fmt.Errorf("%w: no user has id %s", users.ErrNotFound, p.UserID)
+	}
+	p.ID, p.CreatedAt = int64(len(m.posts)+1), time.Now()
+	m.posts = append(m.posts, *p)
+	return nil
+}
+
+func (m *memoryPosts) Posts(ctx context.Context, userID users.ID, limit int) ([]users.Post, error) {
+	var found []users.Post
+	for _, p := range slices.Backward(m.posts) {
+		if p.UserID == userID && (limit == 0 || len(found) < limit) {
+			found = append(found, p)
+		}
+	}
+	return found, nil
+}
+
+func (m *memoryPosts) PostCounts(ctx context.Context, limit int) ([]users.UserPosts, error) {
+	return []users.UserPosts{{User: users.User{ID: "1", Username: "alice"}, Posts: int64(len(m.posts))}}, nil
+}
+
+func newPostsHandler(t *testing.T) http.Handler {
+	t.Helper()
+	s, err := New(config.Config{}, Deps{Logger: quietLogger, Repo: newSQLiteRepository(t), Posts: &memoryPosts{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Handler()
+}
+
+func TestPostsRoutes(t *testing.T) {
+	h := newPostsHandler(t)
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"POST", "/users/1/posts", `{"title": "first", "body": "hello"}`, http.StatusCreated},
+		{"POST", "/users/1/posts", `{"title": "second"}`, http.StatusCreated},
+		{"POST", "/users/1/posts", `{"body": "no title"}`, http.StatusBadRequest},
+		{"POST", "/users/1/posts", `{"title": "` + strings.Repeat("t", 201) + `"}`, http.StatusBadRequest},
+		{"POST", "/users/2/posts", `{"title": "nobody's"}`, http.StatusNotFound},
+		{"POST", "/users/not-an-id/posts", `{"title": "x"}`, http.StatusNotFound},
+		{"GET", "/users/1/posts?limit=-1", "", http.StatusBadRequest},
+		{"GET", "/users/post-counts?limit=x", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := serve(h, tt.method, tt.target, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d; body %s", tt.method, tt.target, rec.Code, tt.want, rec.Body)
+		}
+	}
+
+	rec := serve(h, "GET", "/users/1/posts?limit=1", "")
+	var list struct{ Posts []users.Post }
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Posts) != 1 || list.Posts[0].Title != "second" {
+		t.Errorf("GET /users/1/posts?limit=1 = %s, want the second post alone", rec.Body)
+	}
+	if rec := serve(h, "GET", "/users/2/posts", ""); strings.TrimSpace(rec.Body.String()) != `{"posts":[]}` {
+		t.Errorf("posts of a user without any = %s", rec.Body)
+	}
+	rec = serve(h, "GET", "/users/post-counts", "")
+	if !strings.Contains(rec.Body.String(), `"username":"alice"`) || !strings.Contains(rec.Body.String(), `"posts":2`) {
+		t.Errorf("GET /users/post-counts = %s", rec.Body)
+	}
+}
+
+func TestValidatePost(t *testing.T) {
+	tests := []struct {
+		title string
+		valid bool
+	}{
+		{"a title", true},
+		{strings.Repeat("t", 200), true},
+		{"", false},
+		{strings.Repeat("t", 201), false},
+	}
+	for _, tt := range tests {
+		if err := users.ValidatePost(users.Post{Title: tt.title}); (err == nil) != tt.valid {
+			t.Errorf("ValidatePost of a %d-character title = %v", len(tt.title), err)
+		}
+	}
+}
+
+func TestPostsOpenAPI(t *testing.T) {
+	doc := getOpenAPIDoc(t, newPostsHandler(t))
+	for path, method := range map[string]string{
+		"/users/{id}/posts":  "post",
+		"/users/post-counts": "get",
+	} {
+		if _, ok := doc.Paths[path][method]; !ok {
+			t.Errorf("%s %s is not documented", method, path)
+		}
+	}
+
+	post := doc.Components.Schemas["Post"]
+	properties, _ := post["properties"].(map[string]any)
+	title, _ := properties["title"].(map[string]any)
+	if title["maxLength"] != float64(200) {
+		t.Errorf("Post.title = %v, want a maxLength of 200", title)
+	}
+	if id, _ := properties["id"].(map[string]any); id["readOnly"] != true {
+		t.Errorf("Post.id = %v, want readOnly", id)
+	}
+
+	counts := doc.Components.Schemas["UserPosts"]
+	properties, _ = counts["properties"].(map[string]any)
+	for _, field := range []string{"username", "email", "posts"} {
+		if properties[field] == nil {
+			t.Errorf("UserPosts lacks %s: %v", field, properties)
+		}
+	}
+}
+
+func TestPostsRoutesNeedAStore(t *testing.T) {
+	h := newTestHandler(t, config.Config{})
+	if rec := serve(h, "GET", "/users/post-counts", ""); rec.Code == http.StatusOK {
+		t.Errorf("GET /users/post-counts without a PostStore: status %d", rec.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+)
+
+// requestIDHeader carries the id of a request: taken from the client when
+// it sends a usable one, so its logs and ours can be joined, and returned
+// in the response either way.
+const requestIDHeader = "X-Request-ID"
+
+// requestIDPattern matches the request ids taken from clients. Anything
+// else is replaced, so a client cannot forge log lines through the header.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// quietPaths are polled by probes and scrapers; their requests are logged
+// at debug level so they do not drown the others.
+var quietPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+type requestIDKey struct{}
+
+// withRequestID returns ctx carrying the request id id. The logger adds it
+// to every record logged with the context, the SQL log included, and the
+// query spans carry it as an attribute.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request id of ctx, or "" outside a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// incomingRequestID returns the request id sent by a client, or a new one
+// when it sent none or one that does not match requestIDPattern.
+func incomingRequestID(sent string) string {
+	if requestIDPattern.MatchString(sent) {
+		return sent
+	}
+	return rand.Text()
+}
+
+// statusRecorder remembers the status and size of a response. Unwrap lets
+// http.ResponseController and the WebSocket upgrade of /ws reach the
+// connection beneath.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// logRequests gives every request an id and logs it once answered with
+// its method, path, status, size and duration. A handler that panics is
+// logged with its stack and answered 500, rather than taking the server
+// down with it; http.ErrAbortHandler keeps its meaning of dropping the
+// connection. Both go to logger.
+func logRequests(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := incomingRequestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, id)
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(withRequestID(r.Context(), id))
+
+		defer func() {
+			if p := recover(); p != nil {
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+				logger.ErrorContext(r.Context(), "handler panicked", "panic", p, "stack", string(debug.Stack()))
+				if rec.status == 0 {
+					writeJSON(rec, http.StatusInternalServerError, errorBody{http.StatusText(http.StatusInternalServerError)})
+				}
+			}
+			level := slog.LevelInfo
+			switch {
+			case rec.status >= 500:
+				level = slog.LevelError
+			case quietPaths[r.URL.Path] && rec.status < 400:
+				level = slog.LevelDebug
+			}
+			logger.Log(r.Context(), level, "request", "method", r.Method, "path", r.URL.Path, "status", rec.status,
+				"bytes", rec.bytes, "elapsed", time.Since(start).Round(time.Microsecond), "remote", r.RemoteAddr)
+		}()
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK // nothing written: net/http sends an empty 200
+		}
+	})
+}
+
+// RequestIDHandler is a slog.Handler that adds the request id of the
+// context to each record before passing it to the Handler it wraps.
+type RequestIDHandler struct {
+	slog.Handler
+}
+
+func (h RequestIDHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h RequestIDHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return RequestIDHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h RequestIDHandler) WithGroup(name string) slog.Handler {
+	return RequestIDHandler{h.Handler.WithGroup(name)}
+}
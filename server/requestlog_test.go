@@ -0,0 +1,133 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// logRecords decodes the JSON records written to buf.
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var r map[string]any
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("log line %q: %v", line, err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(RequestIDHandler{Handler: slog.NewJSONHandler(&buf, nil)})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ok", func(w http.ResponseWriter, r *http.Request) {
+		logger.InfoContext(r.Context(), "handling")
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h := logRequests(logger, mux)
+
+	tests := []struct {
+		name, sent string
+		kept       bool
+	}{
+		{"none", "", false},
+		{"client id", "abc-123.4:5_6", true},
+		{"newline", "forged\nlevel=ERROR", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			var header []string
+			if tt.sent != "" {
+				header = []string{requestIDHeader, tt.sent}
+			}
+			rec := serve(h, "GET", "/ok", "", header...)
+			id := rec.Header().Get(requestIDHeader)
+			if !requestIDPattern.MatchString(id) || (id == tt.sent) != tt.kept {
+				t.Fatalf("%s = %q for %q sent", requestIDHeader, id, tt.sent)
+			}
+			records := logRecords(t, &buf)
+			if len(records) != 2 {
+				t.Fatalf("logged %d records, want 2: %s", len(records), buf.String())
+			}
+			for _, r := range records {
+				if r["request_id"] != id {
+					t.Errorf("record %q has request_id %v, want %q", r["msg"], r["request_id"], id)
+				}
+			}
+			if r := records[1]; r["msg"] != "request" || r["status"] != float64(http.StatusOK) || r["bytes"] != float64(2) {
+				t.Errorf("request record = %v", r)
+			}
+		})
+	}
+
+	buf.Reset()
+	rec := serve(h, "GET", "/panic", "")
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), `"error"`) {
+		t.Errorf("GET /panic: status %d, body %s", rec.Code, rec.Body)
+	}
+	records := logRecords(t, &buf)
+	if len(records) != 2 || records[0]["msg"] != "handler panicked" || records[0]["panic"] != "boom" {
+		t.Fatalf("records of a panic = %v", records)
+	}
+	if r := records[1]; r["level"] != "ERROR" || r["status"] != float64(http.StatusInternalServerError) {
+		t.Errorf("request record of a panic = %v", r)
+	}
+}
+
+func TestQuietPathsLogAtDebug(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil)) // Info and above
+	h := logRequests(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve(h, "GET", "/healthz", "")
+	if buf.Len() != 0 {
+		t.Errorf("a healthy probe was logged: %s", buf.String())
+	}
+	serve(h, "GET", "/users", "")
+	if buf.Len() == 0 {
+		t.Error("GET /users was not logged")
+	}
+}
+
+func TestLogUnaryCallsRecovers(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/users.v1.UserService/GetUser"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDHeader, "call-1"))
+
+	var seen string
+	_, err := logUnaryCalls(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		seen = RequestID(ctx)
+		panic("boom")
+	})
+	if status.Code(err) != codes.Internal {
+		t.Errorf("error of a panicking handler = %v, want Internal", err)
+	}
+	if seen != "call-1" {
+		t.Errorf("request id in the handler = %q, want the one of the metadata", seen)
+	}
+
+	ctx = metadata.NewIncomingContext(context.Background(), metadata.Pairs(requestIDHeader, "bad id"))
+	logUnaryCalls(ctx, nil, info, func(ctx context.Context, req any) (any, error) {
+		seen = RequestID(ctx)
+		return nil, nil
+	})
+	if seen == "bad id" || !requestIDPattern.MatchString(seen) {
+		t.Errorf("malformed request id kept as %q", seen)
+	}
+}
@@ -0,0 +1,456 @@
+// Package server serves a users.Repository as a JSON REST API, with its
+// OpenAPI document, a GraphQL endpoint and a live feed, and as a gRPC API.
+// It reads nothing from package state: New takes the configuration and
+// every part it serves, so the serve command wires it to the database,
+// and a test can serve any repository, a fake included, without one.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Pool is the connection pool of the database behind the repository.
+// *pgxpool.Pool satisfies it.
+type Pool interface {
+	users.Querier
+	Stat() *pgxpool.Stat
+}
+
+// Deps are the parts a Server serves. Repo is required; any other field
+// may be left zero, which leaves out what needs it.
+type Deps struct {
+	// Logger receives the request log; slog.Default() when nil.
+	Logger *slog.Logger
+	// Version is the version of the API in its OpenAPI document.
+	Version string
+	// Repo is the repository of every API.
+	Repo users.Repository
+	// Pool backs /readyz and Idempotency-Key. TxRepository returns the
+	// repository on a transaction of Pool that a create with an
+	// Idempotency-Key writes through, and is required along with Pool.
+	Pool         Pool
+	TxRepository func(tx users.Querier) users.Repository
+	// Feed serves /ws and WatchUsers. When it is nil, FeedUnavailable
+	// says why, for WatchUsers to report.
+	Feed            *Feed
+	FeedUnavailable string
+	// Posts serves the posts routes and Locations the location routes.
+	Posts     users.PostStore
+	Locations users.LocationStore
+	// Metrics serves /metrics.
+	Metrics http.Handler
+	// BreakerState returns the state of the circuit breaker in front of
+	// Repo, which /readyz reports.
+	BreakerState func() string
+}
+
+// Server is the users API of serve.
+type Server struct {
+	cfg    config.Config
+	deps   Deps
+	logger *slog.Logger
+	auth   *authenticator // nil leaves writes open
+}
+
+// New returns the server of deps configured by cfg. It reads the
+// credentials of writes (API_KEYS, JWT_SECRET, JWT_PUBLIC_KEY), the
+// deadlines (REQUEST_TIMEOUT, GRPC_TIMEOUT), RECORD_PROVENANCE, READ_ONLY
+// and DB_SCHEMA, once, here.
+func New(cfg config.Config, deps Deps) (*Server, error) {
+	if deps.Repo == nil {
+		return nil, errors.New("server: Deps.Repo is required")
+	}
+	if deps.Pool != nil && deps.TxRepository == nil {
+		return nil, errors.New("server: Deps.TxRepository is required with Deps.Pool")
+	}
+	s := &Server{cfg: cfg, deps: deps, logger: deps.Logger}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	var err error
+	if s.auth, err = newAuthenticator(cfg.App); err != nil {
+		return nil, err
+	}
+	if s.auth == nil {
+		s.logger.Warn("writes are open to every client: set API_KEYS, JWT_SECRET or JWT_PUBLIC_KEY to require credentials")
+	}
+	return s, nil
+}
+
+// Handler returns the REST API: the routes of every endpoint, wrapped in
+// request logging and tracing.
+func (s *Server) Handler() http.Handler {
+	mux := newAPIMux(s.auth)
+	s.registerUsersRoutes(mux)
+	registerGraphQLRoutes(mux.ServeMux, s.deps.Repo, s.auth, s.provenance("graphql"))
+	if s.deps.Pool != nil {
+		s.registerHealthRoutes(mux)
+	}
+	if s.deps.Locations != nil {
+		registerLocationRoutes(mux, s.deps.Locations)
+	}
+	if s.deps.Posts != nil {
+		registerPostRoutes(mux, s.deps.Posts)
+	}
+	if s.deps.Metrics != nil {
+		mux.Handle("GET /metrics", s.deps.Metrics)
+	}
+	if s.deps.Feed != nil {
+		mux.Handle("GET /ws", s.deps.Feed)
+	}
+	mux.serveDocs(s.deps.Version)
+	return logRequests(s.logger, traceRequests(s.requestDeadline(mux)))
+}
+
+// provenance returns the source recorded on the users created through
+// api, or nil without RECORD_PROVENANCE.
+func (s *Server) provenance(api string) *string {
+	if !s.cfg.App.RecordProvenance {
+		return nil
+	}
+	return &api
+}
+
+// requestDeadline gives each request REQUEST_TIMEOUT to finish, the REST
+// counterpart of defaultDeadline, so a slow query cannot hold a connection
+// of the pool forever. The live feed is meant to run until the client
+// leaves, so it is not limited.
+func (s *Server) requestDeadline(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := s.cfg.App.RequestTimeout; d > 0 && r.URL.Path != "/ws" {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HTTPServer returns an http.Server for the REST API on addr. Requests get
+// a context of their own rather than ctx, so a signal lets them finish
+// within the grace period instead of cancelling them.
+func (s *Server) HTTPServer(ctx context.Context, addr string) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return context.WithoutCancel(ctx) },
+	}
+	if s.deps.Feed != nil {
+		srv.RegisterOnShutdown(s.deps.Feed.Shutdown)
+	}
+	return srv
+}
+
+// usersHandler exposes a users.Repository over HTTP:
+//
+//	POST   /users       create a user from {"username", "email"}; 200 when
+//	                    ON_CONFLICT or ON_CONFLICT_EMAIL=upsert overwrote
+//	                    an existing one
+//	GET    /users       list users; ?limit and ?offset page through them
+//	GET    /users/{id}  fetch one user
+//	PUT    /users/{id}  change the email; "version" or "updated_at" makes it
+//	                    conditional
+//	DELETE /users/{id}  delete one user
+type usersHandler struct {
+	repo   users.Repository
+	txRepo func(users.Querier) users.Repository // see txUserRepository
+	source *string                              // provenance of created users
+}
+
+// registerUsersRoutes adds the /users endpoints of the repository to mux.
+// With a pool, POST /users honours Idempotency-Key; see idempotent.
+func (s *Server) registerUsersRoutes(mux *apiMux) {
+	h := &usersHandler{repo: s.deps.Repo, txRepo: s.deps.TxRepository, source: s.provenance("api")}
+	id := apiParam{Name: "id", In: "path", Description: "The id of the user: an integer or a UUID."}
+	readOnly := apiError(http.StatusForbidden, "The service runs with READ_ONLY and writes nothing.")
+	create, createOp := h.create, apiOp{
+		ID: "createUser", Tag: "users", Summary: "Create a user",
+		Body: users.User{},
+		Responses: []apiResponse{
+			{Status: http.StatusCreated, Description: "The user, created; Location names it.", Body: users.User{}},
+			{Status: http.StatusOK, Description: "ON_CONFLICT=upsert overwrote the user with this username, or ON_CONFLICT_EMAIL=upsert renamed the user with this email.", Body: users.User{}},
+			apiError(http.StatusBadRequest, "The username or email is invalid."),
+			apiError(http.StatusConflict, "The username or email is taken."),
+			readOnly,
+		},
+	}
+	if s.deps.Pool != nil {
+		create = s.idempotent(h.create)
+		createOp.Params = append(createOp.Params, apiParam{Name: idempotencyKeyHeader, In: "header",
+			Description: "A key of up to 255 characters chosen by the client. A retry with the same key and body gets the first response again, with Idempotent-Replayed: true, instead of creating the user twice."})
+		createOp.Responses = append(createOp.Responses,
+			apiError(http.StatusUnprocessableEntity, "The Idempotency-Key was already used with another body."))
+	}
+	mux.handle("POST /users", create, createOp)
+	mux.handle("GET /users", h.list, apiOp{
+		ID: "listUsers", Tag: "users", Summary: "List users, oldest first",
+		Params: []apiParam{
+			{Name: "limit", Type: 0, Description: "The most users to return."},
+			{Name: "offset", Type: 0, Description: "The number of users to skip."},
+			{Name: "after", Description: "The nextCursor of the previous page, to fetch the page after it."},
+			{Name: "username_prefix", Description: "Only users whose username starts with this."},
+			{Name: "email_domain", Description: "Only users with an email at this domain."},
+			{Name: "created_after", Description: "Only users created at or after this RFC 3339 time or date."},
+			{Name: "created_before", Description: "Only users created before this RFC 3339 time or date."},
+			{Name: "include_deleted", Type: false, Description: "Include soft-deleted users, which have a deleted_at."},
+		},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "A page of users and the number matching the filters.", Body: UserList{}},
+			apiError(http.StatusBadRequest, "A parameter is malformed."),
+		},
+	})
+	mux.handle("GET /users/{id}", h.get, apiOp{
+		ID: "getUser", Tag: "users", Summary: "Fetch a user", Params: []apiParam{id},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The user.", Body: users.User{}},
+			apiError(http.StatusNotFound, "No user has this id."),
+		},
+	})
+	mux.handle("PUT /users/{id}", h.update, apiOp{
+		ID: "updateUser", Tag: "users", Summary: "Change the email of a user", Params: []apiParam{id},
+		Body: userUpdateRequest{},
+		Responses: []apiResponse{
+			{Status: http.StatusOK, Description: "The user, updated.", Body: users.User{}},
+			apiError(http.StatusBadRequest, "The email is invalid."),
+			apiError(http.StatusNotFound, "No user has this id."),
+			apiError(http.StatusConflict, "The email is taken, or the user changed since the version or updated_at sent."),
+			readOnly,
+		},
+	})
+	mux.handle("DELETE /users/{id}", h.delete, apiOp{
+		ID: "deleteUser", Tag: "users", Summary: "Delete a user", Params: []apiParam{id},
+		Responses: []apiResponse{
+			{Status: http.StatusNoContent, Description: "The user is soft-deleted, until purge removes it."},
+			apiError(http.StatusNotFound, "No user has this id."),
+			readOnly,
+		},
+	})
+}
+
+// UserList is the body of GET /users, and of list with --output json or
+// yaml.
+type UserList struct {
+	Users []users.User `json:"users"`
+	Total int64        `json:"total"`
+	// NextCursor is passed back as ?after= to fetch the next page; absent
+	// on the last page.
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// userUpdateRequest is the body of PUT /users/{id}. Version and UpdatedAt
+// are optional checks that the user is still as the client read it.
+type userUpdateRequest struct {
+	Email     string     `json:"email"`
+	Version   *int64     `json:"version,omitempty"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+func (h *usersHandler) create(w http.ResponseWriter, r *http.Request) {
+	var u users.User
+	if !decodeJSON(w, r, &u) {
+		return
+	}
+	u = users.User{Username: u.Username, Email: u.Email, Source: h.source}
+	if err := users.Validate(u); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	status := http.StatusCreated
+	switch err := txUserRepository(r.Context(), h.repo, h.txRepo).Create(r.Context(), &u); {
+	case errors.Is(err, users.ErrUpdated):
+		status = http.StatusOK
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+	w.Header().Set("Location", "/users/"+u.ID.String())
+	writeJSON(w, status, u)
+}
+
+func (h *usersHandler) list(w http.ResponseWriter, r *http.Request) {
+	var page users.Page
+	for name, dst := range map[string]*int{"limit": &page.Limit, "offset": &page.Offset} {
+		s := r.URL.Query().Get(name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, fmt.Errorf("%w: %s must be a non-negative integer", users.ErrInvalid, name))
+			return
+		}
+		*dst = n
+	}
+	page.After = r.URL.Query().Get("after")
+	q := r.URL.Query()
+	filter, err := users.ParseFilter(q.Get("username_prefix"), q.Get("email_domain"), q.Get("created_after"), q.Get("created_before"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if s := q.Get("include_deleted"); s != "" {
+		if filter.IncludeDeleted, err = strconv.ParseBool(s); err != nil {
+			writeError(w, r, fmt.Errorf("%w: include_deleted must be true or false", users.ErrInvalid))
+			return
+		}
+	}
+	page.Filter = filter
+	records, next, err := h.repo.List(r.Context(), page)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	total, err := h.repo.Count(r.Context(), page.Filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if records == nil {
+		records = []users.User{} // encode as [] rather than null
+	}
+	writeJSON(w, http.StatusOK, UserList{Users: records, Total: total, NextCursor: next})
+}
+
+func (h *usersHandler) get(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *usersHandler) update(w http.ResponseWriter, r *http.Request) {
+	var req userUpdateRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	u, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	u.Email = req.Email
+	if err := users.ValidateEmail(u.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	u.Version, u.UpdatedAt = 0, time.Time{}
+	if req.Version != nil {
+		if *req.Version < 1 {
+			writeError(w, r, fmt.Errorf("%w: version must be positive", users.ErrInvalid))
+			return
+		}
+		u.Version = *req.Version
+	}
+	if req.UpdatedAt != nil {
+		u.UpdatedAt = *req.UpdatedAt
+	}
+	if err := h.repo.Update(r.Context(), u); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, u)
+}
+
+func (h *usersHandler) delete(w http.ResponseWriter, r *http.Request) {
+	u, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	if err := h.repo.Delete(r.Context(), u.Username); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lookup loads the user named by the {id} path segment, writing the error
+// response itself when that fails.
+func (h *usersHandler) lookup(w http.ResponseWriter, r *http.Request) (*users.User, bool) {
+	id, err := users.ParseID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, r, users.ErrNotFound)
+		return nil, false
+	}
+	u, err := h.repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeError(w, r, err)
+		return nil, false
+	}
+	return u, true
+}
+
+// decodeJSON reads the request body into v, answering 400 when it is not
+// valid JSON.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorBody{"invalid JSON body: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// Unavailable is an error of a repository that refuses calls for a
+// while, as a circuit breaker does while it is open. The APIs report it as
+// the service being unavailable, with REST's Retry-After set to
+// RetryAfter.
+type Unavailable interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// writeError maps repository errors to status codes. Anything unexpected is
+// logged, with the request id of r, and reported as a bare 500 so database
+// details do not leak.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	var open Unavailable
+	switch {
+	case errors.As(err, &open):
+		// Expected during an outage and already logged by the breaker
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(open.RetryAfter().Seconds()))))
+	case errors.Is(err, users.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, users.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, users.ErrDuplicate), errors.Is(err, users.ErrStaleRecord), errors.Is(err, users.ErrForeignKeyViolation):
+		status = http.StatusConflict
+	case errors.Is(err, users.ErrReadOnly):
+		status = http.StatusForbidden
+	case errors.Is(err, users.ErrConnectionFailed):
+		status = http.StatusServiceUnavailable
+		slog.ErrorContext(r.Context(), "request failed", "err", err)
+		err = users.ErrConnectionFailed
+	case errors.Is(err, context.DeadlineExceeded):
+		status = http.StatusGatewayTimeout
+		slog.WarnContext(r.Context(), "request timed out", "err", err)
+		err = errors.New("request timed out")
+	default:
+		slog.ErrorContext(r.Context(), "request failed", "err", err)
+		err = errors.New(http.StatusText(status))
+	}
+	writeJSON(w, status, errorBody{err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("writing response failed", "err", err)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hozana-dusabimana/config"
+	"github.com/hozana-dusabimana/users"
+	_ "modernc.org/sqlite"
+)
+
+// quietLogger discards the request log of the servers under test.
+var quietLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+// newSQLiteRepository returns a repository on a SQLite database in a file
+// of its own, with the users table created.
+func newSQLiteRepository(t *testing.T) users.Repository {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(users.SQLiteSchema); err != nil {
+		t.Fatal(err)
+	}
+	return users.NewSQLiteRepository(db, users.Options{})
+}
+
+// newTestHandler returns the REST API of a Server configured by cfg over
+// a SQLite repository, without a pool.
+func newTestHandler(t *testing.T, cfg config.Config) http.Handler {
+	t.Helper()
+	s, err := New(cfg, Deps{Logger: quietLogger, Repo: newSQLiteRepository(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Handler()
+}
+
+// serve sends a request with body, if not empty, to h and returns the
+// response.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, target, r)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNewRequiresDeps(t *testing.T) {
+	if _, err := New(config.Config{}, Deps{Logger: quietLogger}); err == nil {
+		t.Error("New without a repository succeeded")
+	}
+}
+
+func TestUsersRoutes(t *testing.T) {
+	h := newTestHandler(t, config.Config{})
+
+	rec := serve(h, "POST", "/users", `{"username": "alice", "email": "alice@example.com"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST /users: status %d, body %s", rec.Code, rec.Body)
+	}
+	location := rec.Header().Get("Location")
+	if !strings.HasPrefix(location, "/users/") {
+		t.Fatalf("Location = %q", location)
+	}
+
+	rec = serve(h, "GET", "/users", "")
+	var list UserList
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /users: status %d, body %s", rec.Code, rec.Body)
+	}
+	if list.Total != 1 || len(list.Users) != 1 || list.Users[0].Username != "alice" {
+		t.Errorf("GET /users = %+v, want alice alone", list)
+	}
+
+	tests := []struct {
+		method, target, body string
+		want                 int
+	}{
+		{"GET", location, "", http.StatusOK},
+		{"GET", "/users/999", "", http.StatusNotFound},
+		{"GET", "/users?limit=-1", "", http.StatusBadRequest},
+		{"POST", "/users", `{"username": "alice", "email": "other@example.com"}`, http.StatusConflict},
+		{"POST", "/users", `{"username": "", "email": "nobody@example.com"}`, http.StatusBadRequest},
+		{"PUT", location, `{"email": "alice@example.org"}`, http.StatusOK},
+		{"PUT", location, `{"email": "alice@example.net", "version": 1}`, http.StatusConflict},
+		{"DELETE", location, "", http.StatusNoContent},
+		{"GET", location, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := serve(h, tt.method, tt.target, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d; body %s", tt.method, tt.target, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
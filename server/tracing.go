@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the server. It goes through the global
+// provider, so it exports wherever the program set tracing up to.
+var tracer = otel.Tracer("github.com/hozana-dusabimana/server")
+
+// traceRequests starts a root span per HTTP request, continuing the trace
+// of a caller that sent a traceparent header, so the query spans of each
+// request group under it rather than under the long-running serve span.
+// The span, like the query spans, carries the id logRequests gave the
+// request.
+func traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindServer)}
+		if !trace.SpanContextFromContext(ctx).IsRemote() {
+			opts = append(opts, trace.WithNewRoot())
+		}
+		ctx, span := tracer.Start(ctx, r.Method, opts...)
+		defer span.End()
+		if id := RequestID(ctx); id != "" {
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+		// The mux records the matched route on r, e.g. "GET /users/{id}"
+		if r.Pattern != "" {
+			span.SetName(r.Pattern)
+		}
+	})
+}
@@ -0,0 +1,53 @@
+//go:build integration
+
+package testdb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// Image is the PostgreSQL image Main starts.
+const Image = "postgres:17-alpine"
+
+// Main runs the tests of m and exits with their status. Unless EnvVar
+// already names a server, it first starts a throwaway PostgreSQL container
+// with testcontainers-go, which needs a Docker daemon, points EnvVar at it
+// and removes it once the tests are done. Call it from TestMain.
+func Main(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if os.Getenv(EnvVar) != "" {
+		return m.Run()
+	}
+	ctx := context.Background()
+	ctr, err := postgres.Run(ctx, Image,
+		postgres.WithDatabase("quickstart"),
+		postgres.WithUsername("quickstart"),
+		postgres.WithPassword("quickstart"),
+		postgres.BasicWaitStrategies(),
+	)
+	defer func() {
+		if err := testcontainers.TerminateContainer(ctr); err != nil {
+			fmt.Fprintf(os.Stderr, "testdb: removing the PostgreSQL container: %v\n", err)
+		}
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testdb: starting PostgreSQL in a container: %v\n", err)
+		return 1
+	}
+	connStr, err := ctr.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "testdb: %v\n", err)
+		return 1
+	}
+	os.Setenv(EnvVar, connStr)
+	return m.Run()
+}
@@ -0,0 +1,56 @@
+//go:build integration
+
+package testdb
+
+import (
+	"testing"
+
+	"github.com/hozana-dusabimana/migrations"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestMain(m *testing.M) {
+	Main(m)
+}
+
+func TestCopiesAreIsolated(t *testing.T) {
+	ctx := t.Context()
+	first, second := New(t), New(t)
+
+	var firstName, secondName string
+	if err := first.QueryRow(ctx, "SELECT current_database()").Scan(&firstName); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.QueryRow(ctx, "SELECT current_database()").Scan(&secondName); err != nil {
+		t.Fatal(err)
+	}
+	if firstName == secondName {
+		t.Fatalf("both pools are on %s", firstName)
+	}
+
+	if _, err := first.Exec(ctx, "INSERT INTO users (username, email) VALUES ('alice', 'alice@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	// The second copy neither sees the row nor clashes with it
+	var n int
+	if err := second.QueryRow(ctx, "SELECT count(*) FROM users").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("second copy holds %d users after an insert into the first", n)
+	}
+	if _, err := second.Exec(ctx, "INSERT INTO users (username, email) VALUES ('alice', 'alice@example.com')"); err != nil {
+		t.Errorf("inserting the same user into the second copy: %v", err)
+	}
+
+	// Both copies were made from the same template, migrated once
+	for _, pool := range []*pgxpool.Pool{first, second} {
+		version, err := migrations.Current(ctx, pool, "public")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != migrations.Latest() {
+			t.Errorf("copy at version %d, want %d", version, migrations.Latest())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// AuditEntry is one change to a user, as recorded in users_audit by the
+// trigger of migration 0010.
+type AuditEntry struct {
+	ID int64 `db:"id" json:"id"`
+	// UserID is the id of the changed user, who may since have been
+	// deleted.
+	UserID ID `db:"user_id" json:"user_id"`
+	// Operation is INSERT, UPDATE or DELETE.
+	Operation string `db:"operation" json:"operation"`
+	// Old and New are the row before and after the change, column by
+	// column; Old is nil for an INSERT and New for a DELETE. Neither holds
+	// password_hash.
+	Old map[string]any `db:"old_row" json:"old,omitempty"`
+	New map[string]any `db:"new_row" json:"new,omitempty"`
+	// Changed lists the columns an UPDATE changed, password_hash included.
+	Changed   []string  `db:"changed_columns" json:"changed,omitempty"`
+	ChangedAt time.Time `db:"changed_at" json:"changed_at"`
+	// ChangedBy is the database role that made the change.
+	ChangedBy string `db:"changed_by" json:"changed_by"`
+}
+
+// Username returns the username of the user the entry is about: after the
+// change, or before it for a DELETE.
+func (e AuditEntry) Username() string {
+	row := e.New
+	if row == nil {
+		row = e.Old
+	}
+	s, _ := row["username"].(string)
+	return s
+}
+
+// AuditFilter selects audit entries. Zero fields do not filter.
+type AuditFilter struct {
+	UserID ID
+	// Username matches the username before or after the change, so a
+	// renamed or deleted user's history is still found.
+	Username string
+	// Since excludes changes made before it.
+	Since time.Time
+	// Limit caps the number of entries; zero means no limit.
+	Limit int
+}
+
+// AuditLog reads the change history of users. Only PostgresRepository
+// implements it: the trigger that writes users_audit is PostgreSQL-only,
+// so the table does not exist on CockroachDB, MySQL or SQLite.
+type AuditLog interface {
+	// History returns the entries matching f, newest first.
+	History(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+}
+
+var _ AuditLog = (*PostgresRepository)(nil)
+
+// History returns the audit entries matching f, newest first. The
+// (user_id, id) index serves a lookup by user id; a username is matched
+// against the JSON of every entry, which suits the table sizes of an
+// occasional inspection.
+func (r *PostgresRepository) History(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	if f.Limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalid)
+	}
+	var userID, username *string
+	if f.UserID != "" {
+		s := string(f.UserID)
+		userID = &s
+	}
+	if f.Username != "" {
+		username = &f.Username
+	}
+	var since *time.Time
+	if !f.Since.IsZero() {
+		since = &f.Since
+	}
+	var limit *int
+	if f.Limit > 0 {
+		limit = &f.Limit
+	}
+	return db.Select[AuditEntry](ctx, r.db, `SELECT id, user_id, operation, old_row, new_row, changed_columns, changed_at, changed_by
+		FROM `+pgx.Identifier{r.opts.Schema, "users_audit"}.Sanitize()+`
+		WHERE ($1::text IS NULL OR user_id = $1)
+			AND ($2::text IS NULL OR new_row->>'username' = $2 OR old_row->>'username' = $2)
+			AND ($3::timestamptz IS NULL OR changed_at >= $3)
+		ORDER BY id DESC
+		LIMIT $4`, userID, username, since, limit)
+}
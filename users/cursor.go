@@ -0,0 +1,62 @@
+package users
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// cursor is the position after which a keyset page starts: the sort key
+// (created_at, id) of the last user of the previous page. It travels as
+// base64 JSON so callers treat it as an opaque token and the encoding can
+// change without breaking them beyond invalidating old cursors.
+type cursor struct {
+	CreatedAt time.Time `json:"t"`
+	ID        ID        `json:"i"`
+}
+
+// cursorAfter returns the cursor that continues a listing after u.
+func cursorAfter(u User) string {
+	b, _ := json.Marshal(cursor{CreatedAt: u.CreatedAt, ID: u.ID})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// parseCursor decodes a cursor made by cursorAfter. A token that does not
+// decode is ErrInvalid.
+func parseCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(b, &c)
+	}
+	if err == nil && (c.CreatedAt.IsZero() || c.ID == "") {
+		err = fmt.Errorf("missing fields")
+	}
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor %q", ErrInvalid, s)
+	}
+	return c, nil
+}
+
+// checkPage rejects a page List cannot serve.
+func checkPage(page Page) error {
+	switch {
+	case page.Limit < 0 || page.Offset < 0:
+		return fmt.Errorf("%w: limit and offset must not be negative", ErrInvalid)
+	case page.After != "" && page.Offset > 0:
+		return fmt.Errorf("%w: a cursor cannot be combined with an offset", ErrInvalid)
+	}
+	return checkFilter(page.Filter)
+}
+
+// nextCursor trims the extra row List fetched to detect a following page
+// and returns the page together with the cursor to that following page, or
+// "" when there is none or the page is unlimited.
+func nextCursor(records []User, page Page) ([]User, string) {
+	if page.Limit == 0 || len(records) <= page.Limit {
+		return records, ""
+	}
+	records = records[:page.Limit]
+	return records, cursorAfter(records[len(records)-1])
+}
@@ -0,0 +1,74 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// refinedError is a sentinel that reads and matches as its parent too, so
+// existing checks for the parent keep working.
+type refinedError struct {
+	parent error
+}
+
+func (e *refinedError) Error() string { return e.parent.Error() }
+func (e *refinedError) Unwrap() error { return e.parent }
+
+// duplicateKind returns ErrDuplicateEmail or ErrDuplicateUsername when the
+// constraint or message names the column, and ErrDuplicate otherwise.
+func duplicateKind(text string) error {
+	switch {
+	case strings.Contains(text, "email"):
+		return ErrDuplicateEmail
+	case strings.Contains(text, "username"):
+		return ErrDuplicateUsername
+	}
+	return ErrDuplicate
+}
+
+// pgError translates the PostgreSQL errors callers branch on into the
+// package's errors, keeping the server's detail in the message:
+//
+//   - unique_violation (23505) becomes ErrDuplicateUsername or
+//     ErrDuplicateEmail, by constraint name
+//   - foreign_key_violation (23503) becomes ErrForeignKeyViolation
+//   - a row-level security violation (42501) becomes ErrDuplicate: the
+//     repository only meets one when an upsert runs into a user that the
+//     policy hides, which belongs to another tenant
+//   - read_only_sql_transaction (25006) becomes ErrReadOnly
+//   - connection_exception (class 08), admin or crash shutdown (57P01 to
+//     57P03), failures to connect, and network errors other than timeouts
+//     become ErrConnectionFailed, wrapping the original error as well
+//
+// Any other error is returned unchanged.
+func pgError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505":
+			return fmt.Errorf("%w: %s", duplicateKind(pgErr.ConstraintName), pgErr.Detail)
+		case pgErr.Code == "23503":
+			return fmt.Errorf("%w: %s", ErrForeignKeyViolation, pgErr.Detail)
+		case pgErr.Code == "25006":
+			return fmt.Errorf("%w: %s", ErrReadOnly, pgErr.Message)
+		case pgErr.Code == "42501" && strings.Contains(pgErr.Message, "row-level security"):
+			return fmt.Errorf("%w: it belongs to another tenant (%s)", ErrDuplicate, pgErr.Message)
+		case strings.HasPrefix(pgErr.Code, "08"), pgErr.Code == "57P01", pgErr.Code == "57P02", pgErr.Code == "57P03":
+			return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+		}
+		return err
+	}
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || (errors.As(err, &netErr) && !netErr.Timeout()) {
+		return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package users
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Filter narrows List and Count to the users matching every field set.
+// The zero Filter matches every user.
+type Filter struct {
+	// UsernamePrefix matches usernames starting with it.
+	UsernamePrefix string
+	// EmailDomain matches emails at this domain, ignoring case; a leading
+	// "@" is optional.
+	EmailDomain string
+	// CreatedAfter and CreatedBefore bound created_at, both exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// IncludeDeleted matches soft-deleted users as well; they are left
+	// out otherwise.
+	IncludeDeleted bool
+}
+
+// ParseFilter builds a Filter from its fields as text, as the list command
+// and GET /users take them. Times are RFC 3339, or dates meaning midnight
+// UTC.
+func ParseFilter(prefix, domain, after, before string) (Filter, error) {
+	f := Filter{UsernamePrefix: prefix, EmailDomain: domain}
+	for _, bound := range []struct {
+		name, value string
+		dst         *time.Time
+	}{{"created-after", after, &f.CreatedAfter}, {"created-before", before, &f.CreatedBefore}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, bound.value)
+		if err != nil {
+			t, err = time.Parse(time.DateOnly, bound.value)
+		}
+		if err != nil {
+			return f, fmt.Errorf("%w: %s %q is neither an RFC 3339 time nor a date", ErrInvalid, bound.name, bound.value)
+		}
+		*bound.dst = t
+	}
+	return f, nil
+}
+
+// NotDeleted is the condition that leaves out the users Delete marked as
+// deleted. Every statement that reads or changes users as the application
+// sees them adds it; only Filter.IncludeDeleted and Purge look past it.
+// Queries of other packages over the users table use it too.
+const NotDeleted = "deleted_at IS NULL"
+
+// checkFilter rejects a filter that cannot match anything by mistake.
+func checkFilter(f Filter) error {
+	switch {
+	case strings.Contains(strings.TrimPrefix(f.EmailDomain, "@"), "@"):
+		return fmt.Errorf("%w: email domain %q contains an @", ErrInvalid, f.EmailDomain)
+	case !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore):
+		return fmt.Errorf("%w: created-after must be earlier than created-before", ErrInvalid)
+	}
+	return nil
+}
+
+// whereBuilder composes a WHERE clause from conditions whose values are
+// always passed as arguments, never spliced into the text. Conditions are
+// written with ? for each value; placeholder renders the n-th argument in
+// the dialect, so PostgreSQL gets $n.
+type whereBuilder struct {
+	placeholder func(n int) string
+	conds       []string
+	args        []any
+}
+
+// newWhereBuilder returns a builder whose arguments follow args, which the
+// rest of the statement already uses.
+func newWhereBuilder(placeholder func(n int) string, args ...any) *whereBuilder {
+	return &whereBuilder{placeholder: placeholder, args: args}
+}
+
+// pgPlaceholder renders PostgreSQL's numbered placeholders.
+func pgPlaceholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// sqlPlaceholder renders the ? of MySQL and SQLite.
+func sqlPlaceholder(int) string {
+	return "?"
+}
+
+// add appends cond, replacing each ? with a placeholder for the next of
+// args in turn.
+func (b *whereBuilder) add(cond string, args ...any) {
+	var sb strings.Builder
+	for _, part := range strings.SplitAfter(cond, "?") {
+		if strings.HasSuffix(part, "?") {
+			b.args = append(b.args, args[0])
+			args = args[1:]
+			part = strings.TrimSuffix(part, "?") + b.placeholder(len(b.args))
+		}
+		sb.WriteString(part)
+	}
+	b.conds = append(b.conds, "("+sb.String()+")")
+}
+
+// addIDs adds a condition matching the users with any of ids.
+func (b *whereBuilder) addIDs(ids []ID) {
+	args := make([]any, len(ids))
+	for i, id := range ids {
+		args[i] = string(id)
+	}
+	b.add("id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+")", args...)
+}
+
+// addFilter adds the conditions of f. formatTime turns a time into the
+// argument the dialect compares created_at with.
+func (b *whereBuilder) addFilter(f Filter, formatTime func(time.Time) any) {
+	if !f.IncludeDeleted {
+		b.add(NotDeleted)
+	}
+	if f.UsernamePrefix != "" {
+		b.add(`username LIKE ? ESCAPE '!'`, escapeLike(f.UsernamePrefix)+"%")
+	}
+	if d := strings.TrimPrefix(f.EmailDomain, "@"); d != "" {
+		b.add(`lower(email) LIKE ? ESCAPE '!'`, "%@"+escapeLike(strings.ToLower(d)))
+	}
+	if !f.CreatedAfter.IsZero() {
+		b.add("created_at > ?", formatTime(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		b.add("created_at < ?", formatTime(f.CreatedBefore))
+	}
+}
+
+// clause returns " WHERE" and the conditions joined with AND, or "" when
+// there are none.
+func (b *whereBuilder) clause() string {
+	if len(b.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(b.conds, " AND ")
+}
+
+// escapeLike escapes the LIKE wildcards of s with !, the ESCAPE character
+// of the filters. A backslash would be read as an escape by MySQL's string
+// literals too.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
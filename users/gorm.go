@@ -0,0 +1,490 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// gormUser is the users table as GORM maps it. User carries no gorm tags,
+// and left to itself GORM would fill created_at and updated_at from the
+// client's clock on insert; the tags leave them, like id and version, to
+// the table's defaults, as the other repositories do.
+type gormUser struct {
+	ID        ID         `gorm:"column:id;primaryKey"`
+	Username  string     `gorm:"column:username"`
+	Email     string     `gorm:"column:email"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime:false"`
+	UpdatedAt time.Time  `gorm:"column:updated_at;autoUpdateTime:false"`
+	Version   int64      `gorm:"column:version"`
+	Source    *string    `gorm:"column:source"`
+	DeletedAt *time.Time `gorm:"column:deleted_at"`
+}
+
+func (m gormUser) user() User {
+	return User{
+		ID:        m.ID,
+		Username:  m.Username,
+		Email:     m.Email,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+		Version:   m.Version,
+		Source:    m.Source,
+		DeletedAt: m.DeletedAt,
+	}
+}
+
+// returned fills in u with the columns an insert or overwrite returned.
+func (m gormUser) returned(u *User) {
+	u.ID, u.CreatedAt, u.UpdatedAt, u.Version, u.Source = m.ID, m.CreatedAt, m.UpdatedAt, m.Version, m.Source
+}
+
+// gormReturning is the RETURNING list of the statements that fill in a
+// created user.
+var gormReturning = clause.Returning{Columns: []clause.Column{
+	{Name: "id"}, {Name: "created_at"}, {Name: "updated_at"}, {Name: "version"}, {Name: "source"},
+}}
+
+// gormBatchSize is the number of rows per INSERT of BulkCreate.
+const gormBatchSize = 500
+
+// GormRepository is the Repository written with GORM's query builder
+// rather than SQL text, for comparing the ORM with PostgresRepository on
+// the same database. It behaves the same except where noted: GORM builds
+// one statement per call and has no equivalent of a pgx.Batch, COPY or
+// an array parameter, so CreateMany, BulkCreate and upserts take a
+// statement per user, and an upsert is an insert followed by an update,
+// as in SQLiteRepository. Options.CockroachDB is not needed, as nothing
+// here reads xmax or creates a temporary table.
+type GormRepository struct {
+	db    *gorm.DB
+	table string
+	opts  Options
+}
+
+var _ Repository = (*GormRepository)(nil)
+
+// NewGormRepository returns a repository for the users table in
+// opts.Schema that runs its statements through db, a GORM session on
+// PostgreSQL. db may be inside a transaction.
+func NewGormRepository(db *gorm.DB, opts Options) *GormRepository {
+	if opts.Schema == "" {
+		opts.Schema = "public"
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if opts.OnEmailConflict == "" {
+		opts.OnEmailConflict = ConflictFail
+	}
+	// GORM quotes each part of a dotted table name
+	return &GormRepository{db: db, table: opts.Schema + ".users", opts: opts}
+}
+
+// users starts a statement on the users table.
+func (r *GormRepository) users(ctx context.Context) *gorm.DB {
+	return r.db.WithContext(ctx).Table(r.table)
+}
+
+// Create inserts u, handling a taken username as Options.OnConflict says:
+// skip and upsert insert with ON CONFLICT (username) DO NOTHING, and upsert
+// then overwrites the email of the existing user in a second statement.
+// An email that another user holds is handled as Options.OnEmailConflict
+// says, in a savepoint as in PostgresRepository.Create.
+func (r *GormRepository) Create(ctx context.Context, u *User) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	if err := r.precheck(ctx, u); err != nil {
+		return err
+	}
+	if r.opts.OnEmailConflict == ConflictFail {
+		return r.insert(ctx, u)
+	}
+	err := r.savepoint(ctx, func(tx *GormRepository) error { return tx.insert(ctx, u) })
+	switch {
+	case !errors.Is(err, ErrDuplicateEmail):
+		return err
+	case r.opts.OnEmailConflict == ConflictUpsert:
+		return r.savepoint(ctx, func(tx *GormRepository) error { return tx.takeEmail(ctx, u) })
+	}
+	return fmt.Errorf("%w: email %q is already taken", ErrDuplicateEmail, u.Email)
+}
+
+// precheck looks for an existing user with u's username or email when
+// PrecheckDuplicates applies; see PostgresRepository.Create.
+func (r *GormRepository) precheck(ctx context.Context, u *User) error {
+	if !r.opts.PrecheckDuplicates || r.opts.OnConflict != ConflictSkip || r.opts.OnEmailConflict == ConflictUpsert {
+		return nil
+	}
+	var field string
+	err := r.users(ctx).Select("CASE WHEN username = ? THEN 'username' ELSE 'email' END", u.Username).
+		Where("username = ? OR email = ?", u.Username, u.Email).Limit(1).Row().Scan(&field)
+	switch {
+	case err == nil:
+		value := u.Username
+		if field == "email" {
+			value = u.Email
+		}
+		return fmt.Errorf("%w: %s %q is already taken", duplicateKind(field), field, value)
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	default:
+		return pgError(err)
+	}
+}
+
+// insert runs the INSERT of Create and, for ConflictUpsert, the overwrite.
+func (r *GormRepository) insert(ctx context.Context, u *User) error {
+	m := gormUser{Username: u.Username, Email: u.Email, Source: u.Source}
+	q := r.users(ctx).Select("username", "email", "source").Clauses(gormReturning)
+	if r.opts.OnConflict != ConflictFail {
+		q = q.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "username"}}, DoNothing: true})
+	}
+	res := q.Create(&m)
+	switch {
+	case res.Error != nil:
+		return pgError(res.Error)
+	case res.RowsAffected == 0 && r.opts.OnConflict == ConflictUpsert:
+		return r.overwrite(ctx, u)
+	case res.RowsAffected == 0:
+		return fmt.Errorf("%w: username %q is already taken", ErrDuplicateUsername, u.Username)
+	}
+	m.returned(u)
+	return nil
+}
+
+// overwrite is the update half of an upsert: it sets the email of the
+// existing user named u.Username, unless it is already the same, and
+// restores the user if it was soft-deleted.
+func (r *GormRepository) overwrite(ctx context.Context, u *User) error {
+	var m gormUser
+	res := r.users(ctx).Model(&m).Clauses(gormReturning).
+		Where("username = ? AND (email IS DISTINCT FROM ? OR deleted_at IS NOT NULL)", u.Username, u.Email).
+		Updates(map[string]any{
+			"email":      u.Email,
+			"updated_at": gorm.Expr("clock_timestamp()"),
+			"version":    gorm.Expr("version + 1"),
+			"deleted_at": nil,
+		})
+	switch {
+	case res.Error != nil:
+		return pgError(res.Error)
+	case res.RowsAffected == 0:
+		return fmt.Errorf("%w: username %q already has email %q", ErrDuplicateUsername, u.Username, u.Email)
+	}
+	m.returned(u)
+	return ErrUpdated
+}
+
+// takeEmail is ConflictUpsert of Options.OnEmailConflict; see
+// PostgresRepository.takeEmail.
+func (r *GormRepository) takeEmail(ctx context.Context, u *User) error {
+	var m gormUser
+	res := r.users(ctx).Model(&m).Clauses(gormReturning).
+		Where("email = ? AND (username <> ? OR deleted_at IS NOT NULL)", u.Email, u.Username).
+		Updates(map[string]any{
+			"username":   u.Username,
+			"updated_at": gorm.Expr("clock_timestamp()"),
+			"version":    gorm.Expr("version + 1"),
+			"deleted_at": nil,
+		})
+	switch {
+	case res.Error != nil:
+		return pgError(res.Error)
+	case res.RowsAffected == 0:
+		return fmt.Errorf("%w: email %q already belongs to %q", ErrDuplicateEmail, u.Email, u.Username)
+	}
+	m.returned(u)
+	return ErrUpdated
+}
+
+// savepoint runs fn in a GORM transaction, which is a savepoint when r.db
+// is in one already, and returns the outcome of fn; see
+// PostgresRepository.savepoint.
+func (r *GormRepository) savepoint(ctx context.Context, fn func(tx *GormRepository) error) error {
+	var outcome error
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		outcome = fn(&GormRepository{db: tx, table: r.table, opts: r.opts})
+		if errors.Is(outcome, ErrUpdated) {
+			return nil
+		}
+		return outcome
+	})
+	if err != nil && err != outcome {
+		return pgError(err)
+	}
+	return outcome
+}
+
+// CreateMany creates the users one by one; see createEach.
+func (r *GormRepository) CreateMany(ctx context.Context, us []User) ([]error, error) {
+	return createEach(ctx, r.Create, us)
+}
+
+// BulkCreate inserts us in one transaction. With ConflictSkip and
+// ConflictFail it hands them to GORM's CreateInBatches, gormBatchSize rows
+// per INSERT, with ON CONFLICT DO NOTHING for ConflictSkip; with
+// ConflictUpsert it creates them one by one, so a username given twice is
+// inserted and then overwritten. An email clash fails the whole load, and
+// the IDs of us are only filled in by an upsert.
+func (r *GormRepository) BulkCreate(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	if err := validateAll(us); err != nil {
+		return 0, 0, err
+	}
+	if len(us) == 0 {
+		return 0, 0, nil
+	}
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if r.opts.OnConflict == ConflictUpsert {
+			opts := r.opts
+			opts.PrecheckDuplicates = false
+			opts.OnEmailConflict = ConflictFail
+			txRepo := &GormRepository{db: tx, table: r.table, opts: opts}
+			for i := range us {
+				switch err := txRepo.Create(ctx, &us[i]); {
+				case err == nil:
+					inserted++
+				case errors.Is(err, ErrUpdated):
+					updated++
+				case errors.Is(err, ErrDuplicateUsername):
+				default:
+					return err
+				}
+			}
+			return nil
+		}
+
+		rows := make([]gormUser, len(us))
+		for i, u := range us {
+			rows[i] = gormUser{Username: u.Username, Email: u.Email, Source: u.Source}
+		}
+		q := tx.Table(r.table).Select("username", "email", "source")
+		if r.opts.OnConflict == ConflictSkip {
+			q = q.Clauses(clause.OnConflict{DoNothing: true})
+		}
+		res := q.CreateInBatches(rows, gormBatchSize)
+		inserted = res.RowsAffected
+		return res.Error
+	})
+	if err != nil {
+		return 0, 0, pgError(err)
+	}
+	return inserted, updated, nil
+}
+
+// UpsertMany runs BulkCreate with ConflictUpsert, filling in the users
+// written. Users whose email is already the same are left alone and
+// counted in neither.
+func (r *GormRepository) UpsertMany(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	opts := r.opts
+	opts.OnConflict = ConflictUpsert
+	return (&GormRepository{db: r.db, table: r.table, opts: opts}).BulkCreate(ctx, us)
+}
+
+// GetByID returns the user with the given id. An id of the wrong kind for
+// the table matches no user, as in PostgresRepository.GetByID.
+func (r *GormRepository) GetByID(ctx context.Context, id ID) (*User, error) {
+	return r.getOne(ctx, "id = ?", string(id))
+}
+
+// GetByIDs returns the users with the given ids, asking for each kind of
+// id separately as PostgresRepository.GetByIDs does.
+func (r *GormRepository) GetByIDs(ctx context.Context, ids []ID) ([]User, error) {
+	var integers, uuids []string
+	for _, id := range ids {
+		if id.isInteger() {
+			integers = append(integers, string(id))
+		} else {
+			uuids = append(uuids, string(id))
+		}
+	}
+	var found []User
+	for _, kind := range [][]string{integers, uuids} {
+		if len(kind) == 0 {
+			continue
+		}
+		var rows []gormUser
+		err := r.users(ctx).Where("id IN ?", kind).Where(NotDeleted).Find(&rows).Error
+		if isInvalidText(err) {
+			continue
+		}
+		if err != nil {
+			return nil, pgError(err)
+		}
+		for _, m := range rows {
+			found = append(found, m.user())
+		}
+	}
+	return found, nil
+}
+
+// GetByUsername returns the user with the given username.
+func (r *GormRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return r.getOne(ctx, "username = ?", username)
+}
+
+// getOne returns the single user matching where, translating GORM's
+// ErrRecordNotFound, and an id of the wrong kind, into ErrNotFound.
+func (r *GormRepository) getOne(ctx context.Context, where string, arg any) (*User, error) {
+	var m gormUser
+	err := r.users(ctx).Where(where, arg).Where(NotDeleted).Take(&m).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) || isInvalidText(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, pgError(err)
+	}
+	u := m.user()
+	return &u, nil
+}
+
+// isInvalidText reports whether err is PostgreSQL's invalid_text_representation,
+// which comparing the id column with an id of the other kind raises.
+func isInvalidText(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02"
+}
+
+// filtered starts a statement on the users matching where, built with
+// sqlPlaceholder, the ? GORM numbers itself.
+func (r *GormRepository) filtered(ctx context.Context, where *whereBuilder) *gorm.DB {
+	q := r.users(ctx)
+	if len(where.conds) > 0 {
+		q = q.Where(strings.Join(where.conds, " AND "), where.args...)
+	}
+	return q
+}
+
+// List returns the users in page, ordered by creation time and id; see
+// PostgresRepository.List.
+func (r *GormRepository) List(ctx context.Context, page Page) ([]User, string, error) {
+	if err := checkPage(page); err != nil {
+		return nil, "", err
+	}
+	where := newWhereBuilder(sqlPlaceholder)
+	if page.After != "" {
+		c, err := parseCursor(page.After)
+		if err != nil {
+			return nil, "", err
+		}
+		where.add("(created_at, id) > (?, ?)", c.CreatedAt, string(c.ID))
+	}
+	where.addFilter(page.Filter, pgTime)
+	q := r.filtered(ctx, where).Order("created_at, id")
+	if page.Limit > 0 {
+		// One extra row tells whether a next page exists
+		q = q.Limit(page.Limit + 1)
+	}
+	if page.Offset > 0 {
+		q = q.Offset(page.Offset)
+	}
+	var rows []gormUser
+	if err := q.Find(&rows).Error; err != nil {
+		return nil, "", pgError(err)
+	}
+	records := make([]User, len(rows))
+	for i, m := range rows {
+		records[i] = m.user()
+	}
+	records, next := nextCursor(records, page)
+	return records, next, nil
+}
+
+// ForEachUser calls fn for every user as the rows are read, scanning them
+// as the database/sql repositories do.
+func (r *GormRepository) ForEachUser(ctx context.Context, fn func(User) error) error {
+	rows, err := r.users(ctx).Select(columns).Where(NotDeleted).Order("created_at, id").Rows()
+	if err != nil {
+		return pgError(err)
+	}
+	return eachUser(rows, fn)
+}
+
+// Count returns the number of rows in the users table matching f.
+func (r *GormRepository) Count(ctx context.Context, f Filter) (int64, error) {
+	if err := checkFilter(f); err != nil {
+		return 0, err
+	}
+	where := newWhereBuilder(sqlPlaceholder)
+	where.addFilter(f, pgTime)
+	var n int64
+	err := r.filtered(ctx, where).Count(&n).Error
+	return n, pgError(err)
+}
+
+// Update changes the user's email; see Repository.Update for the
+// optimistic-concurrency contract. The version and time checks are only
+// added to the WHERE clause when u sets them.
+func (r *GormRepository) Update(ctx context.Context, u *User) error {
+	if err := ValidateEmail(u.Email); err != nil {
+		return err
+	}
+	version, updatedAt := expectedVersion(u)
+	var m gormUser
+	q := r.users(ctx).Model(&m).Clauses(clause.Returning{Columns: []clause.Column{{Name: "updated_at"}, {Name: "version"}}}).
+		Where("username = ?", u.Username).Where(NotDeleted)
+	if version != nil {
+		q = q.Where("version = ?", *version)
+	}
+	if updatedAt != nil {
+		q = q.Where("updated_at = ?", *updatedAt)
+	}
+	res := q.Updates(map[string]any{
+		"email":      u.Email,
+		"updated_at": gorm.Expr("clock_timestamp()"),
+		"version":    gorm.Expr("version + 1"),
+	})
+	if res.Error != nil {
+		return pgError(res.Error)
+	}
+	if res.RowsAffected > 0 {
+		u.UpdatedAt, u.Version = m.UpdatedAt, m.Version
+		return nil
+	}
+
+	// No row matched: tell a missing user apart from a stale version
+	var n int64
+	if err := r.users(ctx).Where("username = ?", u.Username).Where(NotDeleted).Count(&n).Error; err != nil {
+		return pgError(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrStaleRecord
+}
+
+// Delete marks the user with the given username as deleted. Like any
+// change, it bumps updated_at and version.
+func (r *GormRepository) Delete(ctx context.Context, username string) error {
+	res := r.users(ctx).Where("username = ?", username).Where(NotDeleted).Updates(map[string]any{
+		"deleted_at": gorm.Expr("clock_timestamp()"),
+		"updated_at": gorm.Expr("clock_timestamp()"),
+		"version":    gorm.Expr("version + 1"),
+	})
+	if res.Error != nil {
+		return pgError(res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Purge deletes the rows Delete marked more than olderThan ago. A row
+// another table still refers to fails the whole purge with
+// ErrForeignKeyViolation.
+func (r *GormRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	res := r.users(ctx).Where("deleted_at < now() - ?::bigint * interval '1 microsecond'", olderThan.Microseconds()).Delete(&gormUser{})
+	if res.Error != nil {
+		return 0, pgError(res.Error)
+	}
+	return res.RowsAffected, nil
+}
@@ -0,0 +1,106 @@
+package users
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a GORM logger keeping the SQL of every statement.
+type sqlRecorder struct {
+	logger.Interface
+	sql []string
+}
+
+func (r *sqlRecorder) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	sql, _ := fc()
+	r.sql = append(r.sql, sql)
+}
+
+// dryRunRepository returns a GormRepository configured as openGorm does,
+// whose statements are built but never sent, and the recorder of their
+// SQL. The DSN is never dialed.
+func dryRunRepository(t *testing.T, opts Options) (*GormRepository, *sqlRecorder) {
+	t.Helper()
+	rec := &sqlRecorder{Interface: logger.Discard}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=127.0.0.1 port=1"}), &gorm.Config{
+		Logger:                 rec,
+		QueryFields:            true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		DryRun:                 true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGormRepository(db, opts), rec
+}
+
+func TestGormDryRun(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		opts Options
+		run  func(r *GormRepository)
+		want []string // fragments of the first statement
+		not  string   // a fragment it must not have
+	}{
+		{"create", Options{}, func(r *GormRepository) {
+			r.Create(ctx, &User{Username: "alice", Email: "alice@example.com"})
+		}, []string{`INSERT INTO "app"."users" ("username","email","source")`, `ON CONFLICT ("username") DO NOTHING`, `RETURNING "id","created_at","updated_at","version","source"`}, ""},
+		{"create failing on conflict", Options{OnConflict: ConflictFail}, func(r *GormRepository) {
+			r.Create(ctx, &User{Username: "alice", Email: "alice@example.com"})
+		}, []string{`INSERT INTO "app"."users"`, `RETURNING "id"`}, "ON CONFLICT"},
+		{"get", Options{}, func(r *GormRepository) {
+			r.GetByUsername(ctx, "alice")
+		}, []string{`FROM "app"."users"`, `username = 'alice' AND deleted_at IS NULL`, `LIMIT 1`}, ""},
+		{"list", Options{}, func(r *GormRepository) {
+			r.List(ctx, Page{Limit: 10, Filter: Filter{EmailDomain: "example.com"}})
+		}, []string{`deleted_at IS NULL`, `ORDER BY created_at, id`, `LIMIT 11`}, ""},
+		{"list deleted", Options{}, func(r *GormRepository) {
+			r.List(ctx, Page{Filter: Filter{IncludeDeleted: true}})
+		}, []string{`FROM "app"."users" ORDER BY created_at, id`}, "deleted_at IS NULL"},
+		{"count", Options{}, func(r *GormRepository) {
+			r.Count(ctx, Filter{UsernamePrefix: "a_l"})
+		}, []string{`SELECT count(*) FROM "app"."users"`, `username LIKE 'a!_l%' ESCAPE '!'`}, ""},
+		{"update", Options{}, func(r *GormRepository) {
+			r.Update(ctx, &User{Username: "alice", Email: "alice@example.org", Version: 2})
+		}, []string{`UPDATE "app"."users" SET "email"='alice@example.org'`, `"version"=version + 1`, `AND version = 2`, `RETURNING "updated_at","version"`}, ""},
+		{"delete", Options{}, func(r *GormRepository) {
+			r.Delete(ctx, "alice")
+		}, []string{`UPDATE "app"."users" SET "deleted_at"=clock_timestamp()`, `WHERE username = 'alice' AND deleted_at IS NULL`}, ""},
+		{"purge", Options{}, func(r *GormRepository) {
+			r.Purge(ctx, time.Hour)
+		}, []string{`DELETE FROM "app"."users" WHERE deleted_at < now() - 3600000000::bigint`}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Schema = "app"
+			repo, rec := dryRunRepository(t, opts)
+			tt.run(repo)
+			if len(rec.sql) == 0 {
+				t.Fatal("no statement built")
+			}
+			for _, fragment := range tt.want {
+				if !strings.Contains(rec.sql[0], fragment) {
+					t.Errorf("SQL %s\nlacks %s", rec.sql[0], fragment)
+				}
+			}
+			if tt.not != "" && strings.Contains(rec.sql[0], tt.not) {
+				t.Errorf("SQL %s\nhas %s", rec.sql[0], tt.not)
+			}
+		})
+	}
+
+	repo, rec := dryRunRepository(t, Options{})
+	repo.Count(ctx, Filter{})
+	if len(rec.sql) != 1 || !strings.Contains(rec.sql[0], `FROM "public"."users"`) {
+		t.Errorf("without a schema: %q, want public.users", rec.sql)
+	}
+}
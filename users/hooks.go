@@ -0,0 +1,256 @@
+package users
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrUntrackedTx is returned by a write of a HookedRepository with
+// AfterCommit hooks on a transaction that db.OnCommit cannot queue on.
+var ErrUntrackedTx = errors.New("AfterCommit hooks need a transaction begun by db.WithTx")
+
+// HookPhase says when a hook runs relative to the transaction of the write
+// that triggered it.
+type HookPhase int
+
+const (
+	// AfterCommit runs the hook once the write is committed. It cannot undo
+	// the write, and its error goes to Hooks.OnError rather than to the
+	// caller, whose write did succeed. It suits cache invalidation and
+	// notifications, which must not announce a write that was rolled back.
+	// A write on a transaction commits with the outermost transaction, so
+	// its hook waits for that; see NewHookedRepository.
+	AfterCommit HookPhase = iota
+	// InTransaction runs the hook in the transaction of the write, after
+	// its statement and before the commit. The statements the hook runs
+	// commit with the write or not at all, and an error it returns rolls
+	// the write back and is returned in its place.
+	InTransaction
+)
+
+// Hook is called with a user that a write created or updated, as the
+// write left it. q runs statements in the transaction of the write for an
+// InTransaction hook. For an AfterCommit one it is the repository's
+// Querier, or, when that is a transaction, the Querier the outermost
+// transaction was begun on (nil if that is only a db.Beginner).
+type Hook func(ctx context.Context, q Querier, u User) error
+
+type phasedHook struct {
+	phase HookPhase
+	fn    Hook
+}
+
+// Hooks holds the hooks a HookedRepository calls. Register them before the
+// repository is first used; the zero value has none.
+type Hooks struct {
+	afterCreate, afterUpdate []phasedHook
+	// OnError, if not nil, receives the errors of AfterCommit hooks.
+	OnError func(ctx context.Context, err error)
+}
+
+// AfterCreate registers fn for each user that Create or CreateMany
+// inserts.
+func (h *Hooks) AfterCreate(phase HookPhase, fn Hook) {
+	h.afterCreate = append(h.afterCreate, phasedHook{phase, fn})
+}
+
+// AfterUpdate registers fn for each user that Update changes and each
+// existing user that Create or CreateMany overwrote, reporting ErrUpdated.
+func (h *Hooks) AfterUpdate(phase HookPhase, fn Hook) {
+	h.afterUpdate = append(h.afterUpdate, phasedHook{phase, fn})
+}
+
+// Empty reports whether no hook is registered.
+func (h *Hooks) Empty() bool {
+	return len(h.afterCreate) == 0 && len(h.afterUpdate) == 0
+}
+
+// has reports whether a hook of phase is registered.
+func (h *Hooks) has(phase HookPhase) bool {
+	for _, hs := range [][]phasedHook{h.afterCreate, h.afterUpdate} {
+		for _, hk := range hs {
+			if hk.phase == phase {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// after returns the hooks a write with outcome calls: those of AfterCreate
+// for an insert, of AfterUpdate for ErrUpdated, and none for a failure.
+func (h *Hooks) after(outcome error) []phasedHook {
+	switch {
+	case outcome == nil:
+		return h.afterCreate
+	case errors.Is(outcome, ErrUpdated):
+		return h.afterUpdate
+	}
+	return nil
+}
+
+// HookedRepository is a Repository that calls Hooks after its writes, so
+// integrations can follow the users written without changes to the
+// repository that writes them. Create, CreateMany and Update call hooks.
+// BulkCreate and UpsertMany, which do not say which users they wrote, do
+// not, and neither do Delete and Purge. Reads go straight through.
+type HookedRepository struct {
+	Repository
+	db      Querier
+	newRepo func(Querier) Repository
+	hooks   *Hooks
+}
+
+var _ Repository = (*HookedRepository)(nil)
+
+// NewHookedRepository returns a HookedRepository whose writes run on the
+// repository newRepo returns for db; NewRepository with fixed Options is
+// typical. When an InTransaction hook is registered, each write instead
+// runs on the repository newRepo returns for a transaction begun on db,
+// and commits after the hooks. When db is itself a transaction, that is a
+// savepoint, and AfterCommit hooks wait for the outermost transaction to
+// commit, which db.OnCommit arranges. db must then have been begun by
+// db.WithTx, or nested in a transaction that was: a write with AfterCommit
+// hooks on any other transaction returns ErrUntrackedTx without running.
+func NewHookedRepository(db Querier, newRepo func(Querier) Repository, hooks *Hooks) *HookedRepository {
+	return &HookedRepository{Repository: newRepo(db), db: db, newRepo: newRepo, hooks: hooks}
+}
+
+// write runs fn on the repository and Querier the write goes through: r's
+// own, or those of a transaction of r.db when an InTransaction hook is
+// registered, which commits when fn returns nil.
+func (r *HookedRepository) write(ctx context.Context, fn func(repo Repository, q Querier) error) error {
+	if tx, ok := r.db.(pgx.Tx); ok && r.hooks.has(AfterCommit) && !db.Tracked(tx) {
+		return ErrUntrackedTx
+	}
+	if !r.hooks.has(InTransaction) {
+		return fn(r.Repository, r.db)
+	}
+	return db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		return fn(r.newRepo(tx), tx)
+	})
+}
+
+// inTransaction calls the InTransaction hooks of hs for u on q, stopping
+// at the first error.
+func (r *HookedRepository) inTransaction(ctx context.Context, q Querier, hs []phasedHook, u User) error {
+	for _, hk := range hs {
+		if hk.phase == InTransaction {
+			if err := hk.fn(ctx, q, u); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// afterCommit calls the AfterCommit hooks of hs for u, handing their
+// errors to OnError. When r.db is a transaction they are queued to run
+// once the outermost transaction commits.
+func (r *HookedRepository) afterCommit(ctx context.Context, hs []phasedHook, u User) {
+	if tx, ok := r.db.(pgx.Tx); ok {
+		db.OnCommit(tx, func(b db.Beginner) {
+			q, _ := b.(Querier)
+			r.runAfterCommit(ctx, q, hs, u)
+		})
+		return
+	}
+	r.runAfterCommit(ctx, r.db, hs, u)
+}
+
+// runAfterCommit calls the AfterCommit hooks of hs for u on q.
+func (r *HookedRepository) runAfterCommit(ctx context.Context, q Querier, hs []phasedHook, u User) {
+	for _, hk := range hs {
+		if hk.phase == AfterCommit {
+			if err := hk.fn(ctx, q, u); err != nil && r.hooks.OnError != nil {
+				r.hooks.OnError(ctx, err)
+			}
+		}
+	}
+}
+
+func (r *HookedRepository) Create(ctx context.Context, u *User) error {
+	var outcome error
+	err := r.write(ctx, func(repo Repository, q Querier) error {
+		outcome = repo.Create(ctx, u)
+		if outcome != nil && !errors.Is(outcome, ErrUpdated) {
+			return outcome
+		}
+		return r.inTransaction(ctx, q, r.hooks.after(outcome), *u)
+	})
+	if err != nil {
+		return err
+	}
+	r.afterCommit(ctx, r.hooks.after(outcome), *u)
+	return outcome
+}
+
+// CreateMany calls the hooks of each user written. A failing
+// InTransaction hook rolls the whole batch back, and the users reported
+// written get its error instead. So does a failed insert that aborted the
+// transaction of the write, which can then only roll back; one that left
+// it usable, such as a skipped duplicate username, does not.
+func (r *HookedRepository) CreateMany(ctx context.Context, us []User) (results []error, err error) {
+	var rollbackErr error
+	err = r.write(ctx, func(repo Repository, q Querier) error {
+		var err error
+		if results, err = repo.CreateMany(ctx, us); err != nil {
+			return err
+		}
+		if aborted(q) {
+			for _, outcome := range results {
+				if outcome != nil && !errors.Is(outcome, ErrUpdated) {
+					rollbackErr = outcome
+					return outcome
+				}
+			}
+		}
+		for i := range us {
+			if rollbackErr = r.inTransaction(ctx, q, r.hooks.after(results[i]), us[i]); rollbackErr != nil {
+				return rollbackErr
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if rollbackErr != nil {
+			for i, outcome := range results {
+				if outcome == nil || errors.Is(outcome, ErrUpdated) {
+					results[i] = rollbackErr
+				}
+			}
+		}
+		return results, err
+	}
+	for i := range us {
+		r.afterCommit(ctx, r.hooks.after(results[i]), us[i])
+	}
+	return results, nil
+}
+
+func (r *HookedRepository) Update(ctx context.Context, u *User) error {
+	err := r.write(ctx, func(repo Repository, q Querier) error {
+		if err := repo.Update(ctx, u); err != nil {
+			return err
+		}
+		return r.inTransaction(ctx, q, r.hooks.afterUpdate, *u)
+	})
+	if err != nil {
+		return err
+	}
+	r.afterCommit(ctx, r.hooks.afterUpdate, *u)
+	return nil
+}
+
+// aborted reports whether q is a transaction that a failed statement
+// aborted, so that it can only roll back.
+func aborted(q Querier) bool {
+	tx, ok := q.(pgx.Tx)
+	if !ok || tx.Conn() == nil {
+		return false
+	}
+	return tx.Conn().PgConn().TxStatus() == 'E'
+}
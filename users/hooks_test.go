@@ -0,0 +1,200 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeDB is a Querier whose transactions only record what happens to
+// them in log; the statements are left to fakeRepo.
+type fakeDB struct {
+	Querier
+	log *[]string
+}
+
+func (f fakeDB) Begin(context.Context) (pgx.Tx, error) {
+	*f.log = append(*f.log, "begin")
+	return fakeTx{log: f.log}, nil
+}
+
+type fakeTx struct {
+	pgx.Tx
+	log *[]string
+}
+
+func (t fakeTx) Begin(context.Context) (pgx.Tx, error) {
+	*t.log = append(*t.log, "savepoint")
+	return t, nil
+}
+
+func (t fakeTx) Commit(context.Context) error {
+	*t.log = append(*t.log, "commit")
+	return nil
+}
+
+func (t fakeTx) Rollback(context.Context) error {
+	*t.log = append(*t.log, "rollback")
+	return nil
+}
+
+func (t fakeTx) Conn() *pgx.Conn { return nil }
+
+// fakeRepo answers the writes of a HookedRepository with outcomes,
+// logging each.
+type fakeRepo struct {
+	Repository
+	log      *[]string
+	outcomes []error
+}
+
+func (r fakeRepo) Create(context.Context, *User) error {
+	*r.log = append(*r.log, "create")
+	return r.outcomes[0]
+}
+
+func (r fakeRepo) CreateMany(_ context.Context, us []User) ([]error, error) {
+	*r.log = append(*r.log, "create many")
+	return slices.Clone(r.outcomes[:len(us)]), nil
+}
+
+func (r fakeRepo) Update(context.Context, *User) error {
+	*r.log = append(*r.log, "update")
+	return r.outcomes[0]
+}
+
+// logHook returns a Hook that logs name.
+func logHook(log *[]string, name string) Hook {
+	return func(context.Context, Querier, User) error {
+		*log = append(*log, name)
+		return nil
+	}
+}
+
+func newLoggedRepository(q Querier, log *[]string, outcomes ...error) *HookedRepository {
+	hooks := &Hooks{}
+	hooks.AfterCreate(InTransaction, logHook(log, "in tx"))
+	hooks.AfterCreate(AfterCommit, logHook(log, "after commit"))
+	hooks.AfterUpdate(InTransaction, logHook(log, "in tx"))
+	hooks.AfterUpdate(AfterCommit, logHook(log, "after commit"))
+	newRepo := func(Querier) Repository { return fakeRepo{log: log, outcomes: outcomes} }
+	return NewHookedRepository(q, newRepo, hooks)
+}
+
+func TestHookedRepositoryWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		write   func(context.Context, Repository) error
+		outcome error
+		want    []string
+	}{
+		{
+			name:    "create",
+			write:   func(ctx context.Context, r Repository) error { return r.Create(ctx, &User{}) },
+			outcome: nil,
+			want:    []string{"begin", "create", "in tx", "commit", "after commit"},
+		},
+		{
+			name:    "create overwrites",
+			write:   func(ctx context.Context, r Repository) error { return r.Create(ctx, &User{}) },
+			outcome: ErrUpdated,
+			want:    []string{"begin", "create", "in tx", "commit", "after commit"},
+		},
+		{
+			name:    "create fails",
+			write:   func(ctx context.Context, r Repository) error { return r.Create(ctx, &User{}) },
+			outcome: ErrDuplicateUsername,
+			want:    []string{"begin", "create", "rollback"},
+		},
+		{
+			name:    "update fails",
+			write:   func(ctx context.Context, r Repository) error { return r.Update(ctx, &User{}) },
+			outcome: ErrStaleRecord,
+			want:    []string{"begin", "update", "rollback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			repo := newLoggedRepository(fakeDB{log: &log}, &log, tt.outcome)
+			if err := tt.write(context.Background(), repo); err != tt.outcome {
+				t.Errorf("error = %v, want %v", err, tt.outcome)
+			}
+			if !slices.Equal(log, tt.want) {
+				t.Errorf("log = %q, want %q", log, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookedRepositoryCreateManyKeepsUsableTransaction(t *testing.T) {
+	var log []string
+	repo := newLoggedRepository(fakeDB{log: &log}, &log, nil, ErrDuplicateUsername)
+	results, err := repo.CreateMany(context.Background(), make([]User, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0] != nil || results[1] != ErrDuplicateUsername {
+		t.Errorf("results = %v", results)
+	}
+	want := []string{"begin", "create many", "in tx", "commit", "after commit"}
+	if !slices.Equal(log, want) {
+		t.Errorf("log = %q, want %q", log, want)
+	}
+}
+
+func TestHookedRepositoryAfterCommitWaitsForOutermostCommit(t *testing.T) {
+	rollback := errors.New("rolled back")
+	tests := []struct {
+		name string
+		fail bool
+		want []string
+	}{
+		{
+			name: "committed",
+			want: []string{"begin", "savepoint", "create", "in tx", "commit", "done", "commit", "after commit"},
+		},
+		{
+			name: "rolled back",
+			fail: true,
+			want: []string{"begin", "savepoint", "create", "in tx", "commit", "done", "rollback"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log []string
+			err := db.WithTx(context.Background(), fakeDB{log: &log}, func(tx pgx.Tx) error {
+				repo := newLoggedRepository(tx, &log, nil)
+				if err := repo.Create(context.Background(), &User{}); err != nil {
+					return err
+				}
+				log = append(log, "done")
+				if tt.fail {
+					return rollback
+				}
+				return nil
+			})
+			if tt.fail != (err == rollback) {
+				t.Errorf("error = %v", err)
+			}
+			if !slices.Equal(log, tt.want) {
+				t.Errorf("log = %q, want %q", log, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookedRepositoryUntrackedTx(t *testing.T) {
+	var log []string
+	repo := newLoggedRepository(fakeTx{log: &log}, &log, nil)
+	if err := repo.Create(context.Background(), &User{}); !errors.Is(err, ErrUntrackedTx) {
+		t.Errorf("error = %v, want ErrUntrackedTx", err)
+	}
+	if len(log) != 0 {
+		t.Errorf("log = %q, want nothing run", log)
+	}
+}
@@ -0,0 +1,106 @@
+package users
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// ID identifies a user. It holds the text form of the id column, which is
+// an auto-incrementing integer unless the table was created with
+// ID_TYPE=uuid, in which case it is a UUID. Keeping the text lets one User
+// struct, and the same queries, serve either kind of table: PostgreSQL
+// parses a text parameter into whatever type the id column has.
+type ID string
+
+// ParseID checks that s is an integer or a UUID and returns it in
+// canonical form: the integer without leading zeros or sign, or the UUID in
+// lower case with hyphens. Anything else is ErrInvalid.
+func ParseID(s string) (ID, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+		return ID(strconv.FormatInt(n, 10)), nil
+	}
+	var u pgtype.UUID
+	if len(s) == 36 && u.Scan(s) == nil {
+		return ID(u.String()), nil
+	}
+	return "", fmt.Errorf("%w: id %q is neither a positive integer nor a UUID", ErrInvalid, s)
+}
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// ScanText implements pgtype.TextScanner, through which pgx scans integer
+// and uuid columns alike.
+func (id *ID) ScanText(v pgtype.Text) error {
+	*id = ID(v.String)
+	return nil
+}
+
+var _ pgtype.TextScanner = (*ID)(nil)
+
+// Scan implements sql.Scanner for the MySQL and SQLite drivers, which
+// return integer ids as int64.
+func (id *ID) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*id = ""
+	case int64:
+		*id = ID(strconv.FormatInt(v, 10))
+	case []byte:
+		*id = ID(v)
+	case string:
+		*id = ID(v)
+	default:
+		return fmt.Errorf("cannot scan %T into users.ID", src)
+	}
+	return nil
+}
+
+var _ sql.Scanner = (*ID)(nil)
+
+// isInteger reports whether id is an integer id.
+func (id ID) isInteger() bool {
+	_, err := strconv.ParseInt(string(id), 10, 64)
+	return err == nil
+}
+
+// MarshalJSON writes an integer id as a JSON number, as it was before UUID
+// ids existed, and a UUID as a string.
+func (id ID) MarshalJSON() ([]byte, error) {
+	if id.isInteger() {
+		return []byte(id), nil
+	}
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON accepts either form MarshalJSON writes.
+func (id *ID) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		return json.Unmarshal(b, (*string)(id))
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*id = ID(n)
+	return nil
+}
+
+// CopyValue returns id in a form pgx can write to the id column with COPY,
+// which unlike a query parameter is sent in binary and must match the
+// column type: an int64 for an integer id, a pgtype.UUID for a UUID.
+func (id ID) CopyValue() (any, error) {
+	if n, err := strconv.ParseInt(string(id), 10, 64); err == nil {
+		return n, nil
+	}
+	var u pgtype.UUID
+	if err := u.Scan(string(id)); err != nil {
+		return nil, fmt.Errorf("%w: id %q is neither an integer nor a UUID", ErrInvalid, id)
+	}
+	return u, nil
+}
@@ -0,0 +1,73 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/geo"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// NearbyUser is a user found near a point, and how far away.
+type NearbyUser struct {
+	ID       ID        `db:"id" json:"id"`
+	Username string    `db:"username" json:"username"`
+	Email    string    `db:"email" json:"email"`
+	Location geo.Point `db:"location" json:"location"`
+	Meters   float64   `db:"meters" json:"distance_m"`
+}
+
+// LocationStore stores the location of users and finds the users near a
+// point. Only PostgresRepository implements it, on PostgreSQL with
+// PostGIS, once the user_locations table has been created by geo setup.
+type LocationStore interface {
+	// SetLocation stores p as the location of the user id, replacing any
+	// previous one. A user that does not exist is ErrNotFound.
+	SetLocation(ctx context.Context, id ID, p geo.Point) error
+	// UsersNear returns up to limit users located within meters of
+	// center, nearest first.
+	UsersNear(ctx context.Context, center geo.Point, meters float64, limit int) ([]NearbyUser, error)
+}
+
+var _ LocationStore = (*PostgresRepository)(nil)
+
+// LocationsTableName is the table of user locations. Unlike the others it
+// is not created by a migration, as it needs the PostGIS extension.
+const LocationsTableName = "user_locations"
+
+// locationsTable returns the quoted, schema-qualified name of the
+// locations table.
+func (r *PostgresRepository) locationsTable() string {
+	return pgx.Identifier{r.opts.Schema, LocationsTableName}.Sanitize()
+}
+
+// SetLocation upserts the location of the user id.
+func (r *PostgresRepository) SetLocation(ctx context.Context, id ID, p geo.Point) error {
+	_, err := r.db.Exec(ctx, `INSERT INTO `+r.locationsTable()+` (user_id, location) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET location = EXCLUDED.location, updated_at = now()`,
+		string(id), p)
+	// The user may have been deleted since it was looked up, or never
+	// existed when the id came from a request
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		return ErrNotFound
+	}
+	return err
+}
+
+// UsersNear selects the users through the GiST index with ST_DWithin and
+// orders them by distance with the <-> operator.
+func (r *PostgresRepository) UsersNear(ctx context.Context, center geo.Point, meters float64, limit int) ([]NearbyUser, error) {
+	found, err := db.Select[NearbyUser](ctx, r.db, `SELECT u.id, u.username, u.email, l.location, ST_Distance(l.location, $1::geography) AS meters
+		FROM `+r.locationsTable()+` l JOIN `+r.table+` u ON u.id = l.user_id
+		WHERE ST_DWithin(l.location, $1::geography, $2) AND u.`+NotDeleted+`
+		ORDER BY l.location <-> $1::geography
+		LIMIT $3`, center, meters, limit)
+	if err != nil {
+		return nil, fmt.Errorf("searching locations: %w", err)
+	}
+	return found, nil
+}
@@ -0,0 +1,257 @@
+package users
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQLSchema creates the users table in MySQL with the same columns and
+// constraints the PostgreSQL migrations produce. The migrations themselves
+// are PostgreSQL-only.
+const MySQLSchema = `CREATE TABLE IF NOT EXISTS users (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	username VARCHAR(50) NOT NULL UNIQUE,
+	email VARCHAR(100) NOT NULL UNIQUE,
+	created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	version BIGINT NOT NULL DEFAULT 1,
+	source TEXT,
+	deleted_at DATETIME(6)
+)`
+
+// MySQLUpgrades add the columns MySQLSchema gained to older tables, in the
+// order they were added.
+var MySQLUpgrades = []ColumnUpgrade{
+	{"version", "ALTER TABLE users ADD COLUMN version BIGINT NOT NULL DEFAULT 1"},
+	{"deleted_at", "ALTER TABLE users ADD COLUMN deleted_at DATETIME(6)"},
+}
+
+// MySQLRepository is the Repository backed by MySQL through database/sql.
+// It differs from PostgresRepository in dialect only: ? placeholders, ON
+// DUPLICATE KEY UPDATE instead of ON CONFLICT, and no RETURNING, so the
+// generated columns are read back with a second query.
+type MySQLRepository struct {
+	sqlRepository
+}
+
+var _ Repository = (*MySQLRepository)(nil)
+
+// NewMySQLRepository returns a repository for the users table of the
+// database db is connected to; opts.Schema is not used, since a MySQL
+// schema is the database itself.
+func NewMySQLRepository(db SQLQuerier, opts Options) *MySQLRepository {
+	return &MySQLRepository{newSQLRepository(db, opts, "2006-01-02 15:04:05.000000", mysqlError)}
+}
+
+// Create inserts u, handling a taken username as Options.OnConflict says.
+//
+// MySQL has no statement-level DO NOTHING that leaves other errors alone,
+// so skip and fail both send a plain INSERT and report the duplicate-key
+// error as ErrDuplicate; unlike PostgreSQL, the error does not abort an
+// enclosing transaction. Upsert uses ON DUPLICATE KEY UPDATE, whose affected
+// row count tells an insert (1) from an update (2) and from no change (0).
+// ON DUPLICATE KEY fires on the email key too, so an email taken by another
+// user is not overwritten either way; it is handled as
+// Options.OnEmailConflict says.
+func (r *MySQLRepository) Create(ctx context.Context, u *User) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	if err := r.precheck(ctx, u); err != nil {
+		return err
+	}
+
+	insert := `INSERT INTO users (username, email, source) VALUES (?, ?, ?)`
+	if r.opts.OnConflict == ConflictUpsert {
+		// updated_at and version are assigned first, while email and
+		// deleted_at still hold the old values, and a soft-deleted user is
+		// restored; LAST_INSERT_ID(id) makes LastInsertId report the
+		// updated row
+		insert += ` ON DUPLICATE KEY UPDATE
+			updated_at = IF(email = VALUES(email) AND deleted_at IS NULL, updated_at, CURRENT_TIMESTAMP(6)),
+			version = IF(email = VALUES(email) AND deleted_at IS NULL, version, version + 1),
+			deleted_at = NULL,
+			email = VALUES(email),
+			id = LAST_INSERT_ID(id)`
+	}
+	res, err := r.db.ExecContext(ctx, insert, u.Username, u.Email, u.Source)
+	if err != nil {
+		if err = mysqlError(err); errors.Is(err, ErrDuplicateEmail) {
+			return r.emailTaken(ctx, u, err, "CURRENT_TIMESTAMP(6)")
+		}
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.emailTaken(ctx, u, fmt.Errorf("%w: email %q is already taken", ErrDuplicateEmail, u.Email), "CURRENT_TIMESTAMP(6)")
+	}
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	u.ID = ID(strconv.FormatInt(lastID, 10))
+	err = r.db.QueryRowContext(ctx, "SELECT created_at, updated_at, version, source FROM users WHERE id = ?", lastID).
+		Scan(&u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	if err != nil {
+		return err
+	}
+	if affected == 2 {
+		return ErrUpdated
+	}
+	return nil
+}
+
+// CreateMany creates the users one by one; see createEach.
+func (r *MySQLRepository) CreateMany(ctx context.Context, us []User) ([]error, error) {
+	return createEach(ctx, r.Create, us)
+}
+
+// mysqlBulkChunk is how many rows BulkCreate sends per INSERT, well below
+// the default max_allowed_packet for rows of this size.
+const mysqlBulkChunk = 500
+
+// BulkCreate inserts us with multi-row INSERT statements in one
+// transaction. LOAD DATA would be faster but needs local_infile enabled on
+// the server, which it is not by default.
+//
+// With ConflictSkip, ON DUPLICATE KEY UPDATE id = id skips a clash on any
+// unique column, including duplicates within us. With ConflictUpsert later
+// occurrences of a username overwrite earlier ones. MySQL counts an updated
+// row as two affected rows, so the inserts are counted from the growth of
+// the table instead. With ConflictFail any duplicate fails the whole load.
+func (r *MySQLRepository) BulkCreate(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	if err := validateAll(us); err != nil {
+		return 0, 0, err
+	}
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	var before int64
+	if r.opts.OnConflict == ConflictUpsert {
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&before); err != nil {
+			return 0, 0, err
+		}
+	}
+	var affected int64
+	for start := 0; start < len(us); start += mysqlBulkChunk {
+		chunk := us[start:min(start+mysqlBulkChunk, len(us))]
+		query := "INSERT INTO users (username, email, source) VALUES "
+		args := make([]any, 0, 3*len(chunk))
+		for i, u := range chunk {
+			if i > 0 {
+				query += ", "
+			}
+			query += "(?, ?, ?)"
+			args = append(args, u.Username, u.Email, u.Source)
+		}
+		switch r.opts.OnConflict {
+		case ConflictUpsert:
+			query += ` ON DUPLICATE KEY UPDATE
+				updated_at = IF(email = VALUES(email) AND deleted_at IS NULL, updated_at, CURRENT_TIMESTAMP(6)),
+				version = IF(email = VALUES(email) AND deleted_at IS NULL, version, version + 1),
+				deleted_at = NULL,
+				email = VALUES(email)`
+		case ConflictFail:
+		default:
+			query += " ON DUPLICATE KEY UPDATE id = id"
+		}
+		res, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, 0, mysqlError(err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		affected += n
+	}
+
+	inserted = affected
+	if r.opts.OnConflict == ConflictUpsert {
+		var after int64
+		if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM users").Scan(&after); err != nil {
+			return 0, 0, err
+		}
+		inserted = after - before
+		updated = (affected - inserted) / 2
+	}
+	return inserted, updated, tx.Commit()
+}
+
+// UpsertMany runs BulkCreate with ConflictUpsert: MySQL has no array
+// parameters to unnest, so the users go in multi-row INSERT statements.
+// The IDs of us are not filled in.
+func (r *MySQLRepository) UpsertMany(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	opts := r.opts
+	opts.OnConflict = ConflictUpsert
+	return NewMySQLRepository(r.db, opts).BulkCreate(ctx, us)
+}
+
+// mysqlError translates MySQL errors as pgError does PostgreSQL's: a
+// duplicate entry (1062) by the key it names, a foreign key failure (1451,
+// 1452), a write in a read-only transaction (1792) and a dropped
+// connection. Any other error is returned unchanged.
+func mysqlError(err error) error {
+	var myErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &myErr) && myErr.Number == 1062:
+		// "Duplicate entry 'x' for key 'users.email'": the value may
+		// contain anything, so only the key is looked at
+		_, key, _ := strings.Cut(myErr.Message, " for key ")
+		return fmt.Errorf("%w: %s", duplicateKind(key), myErr.Message)
+	case errors.As(err, &myErr) && (myErr.Number == 1451 || myErr.Number == 1452):
+		return fmt.Errorf("%w: %s", ErrForeignKeyViolation, myErr.Message)
+	case errors.As(err, &myErr) && myErr.Number == 1792:
+		return fmt.Errorf("%w: %s", ErrReadOnly, myErr.Message)
+	case errors.Is(err, mysql.ErrInvalidConn), errors.Is(err, driver.ErrBadConn):
+		return fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+	return err
+}
+
+// Update changes the user's email; see Repository.Update for the
+// optimistic-concurrency contract. Without RETURNING, the new updated_at
+// and version are read back afterwards.
+func (r *MySQLRepository) Update(ctx context.Context, u *User) error {
+	if err := ValidateEmail(u.Email); err != nil {
+		return err
+	}
+	version, updatedAt := expectedVersion(u)
+	res, err := r.db.ExecContext(ctx, `UPDATE users SET email = ?, updated_at = CURRENT_TIMESTAMP(6), version = version + 1
+		WHERE username = ? AND `+NotDeleted+` AND (? IS NULL OR version = ?) AND (? IS NULL OR updated_at = ?)`,
+		u.Email, u.Username, version, version, updatedAt, updatedAt)
+	if err != nil {
+		return mysqlError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return r.missingOrConflict(ctx, u.Username)
+	}
+	return r.db.QueryRowContext(ctx, "SELECT updated_at, version FROM users WHERE username = ?", u.Username).Scan(&u.UpdatedAt, &u.Version)
+}
+
+// Delete marks the user with the given username as deleted.
+func (r *MySQLRepository) Delete(ctx context.Context, username string) error {
+	return r.delete(ctx, username, "CURRENT_TIMESTAMP(6)")
+}
+
+// Purge deletes the rows Delete marked more than olderThan ago.
+func (r *MySQLRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return r.purge(ctx, "CURRENT_TIMESTAMP(6) - INTERVAL ? MICROSECOND", olderThan.Microseconds())
+}
@@ -0,0 +1,158 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/usersdb"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrBadCredentials means a username and password did not match. It does
+// not say which was wrong, so callers cannot be used to probe for
+// usernames.
+var ErrBadCredentials = errors.New("invalid username or password")
+
+// Password length limits. bcrypt only reads the first 72 bytes, so longer
+// passwords are rejected rather than silently truncated.
+const (
+	MinPasswordLength = 8
+	MaxPasswordBytes  = 72
+)
+
+// PasswordStore registers users with a password and checks credentials.
+// The bcrypt hash stays inside the repository: no method returns it and it
+// is not a field of User, so it cannot end up in logs or API responses.
+// Only PostgresRepository implements it.
+type PasswordStore interface {
+	// Register inserts u with a password, like Create with ConflictFail:
+	// a taken username or email is ErrDuplicate and never overwritten.
+	Register(ctx context.Context, u *User, password string) error
+	// Authenticate returns the user if password is theirs, and
+	// ErrBadCredentials otherwise, including for unknown usernames and
+	// users without a password.
+	Authenticate(ctx context.Context, username, password string) (*User, error)
+	// SetPassword replaces the password of the named user.
+	SetPassword(ctx context.Context, username, password string) error
+}
+
+var _ PasswordStore = (*PostgresRepository)(nil)
+
+// ValidatePassword checks a password against the length limits.
+func ValidatePassword(password string) error {
+	switch {
+	case len([]rune(password)) < MinPasswordLength:
+		return fmt.Errorf("%w: password must be at least %d characters", ErrInvalid, MinPasswordLength)
+	case len(password) > MaxPasswordBytes:
+		return fmt.Errorf("%w: password must be at most %d bytes", ErrInvalid, MaxPasswordBytes)
+	}
+	return nil
+}
+
+// bcryptCost returns Options.BcryptCost, or bcrypt's default when unset.
+func (r *PostgresRepository) bcryptCost() int {
+	if r.opts.BcryptCost == 0 {
+		return bcrypt.DefaultCost
+	}
+	return r.opts.BcryptCost
+}
+
+// hashPassword validates and hashes password at the configured cost.
+func (r *PostgresRepository) hashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), r.bcryptCost())
+	if err != nil {
+		return "", fmt.Errorf("hashing password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Register inserts u with the bcrypt hash of password.
+func (r *PostgresRepository) Register(ctx context.Context, u *User, password string) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	hash, err := r.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	err = r.db.QueryRow(ctx, `INSERT INTO `+r.table+` (username, email, source, password_hash)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`, u.Username, u.Email, u.Source, hash).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version)
+	return pgError(err)
+}
+
+// Authenticate compares password with the stored hash. When there is no
+// hash to compare with, it compares with a dummy one instead, so an
+// unknown username takes as long to reject as a wrong password.
+//
+// A hash made at another cost than the configured one is replaced after a
+// successful login, which is the only time the plain password is at hand;
+// raising BcryptCost thus upgrades users as they log in.
+func (r *PostgresRepository) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	row, err := db.Get[struct {
+		User
+		PasswordHash *string `db:"password_hash"`
+	}](ctx, r.db, "SELECT "+columns+", password_hash FROM "+r.table+" WHERE username = $1 AND "+NotDeleted, username)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil || row.PasswordHash == nil {
+		bcrypt.CompareHashAndPassword(dummyHash(r.bcryptCost()), []byte(password))
+		return nil, ErrBadCredentials
+	}
+	hash := []byte(*row.PasswordHash)
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, ErrBadCredentials
+	}
+	if cost, err := bcrypt.Cost(hash); err == nil && cost != r.bcryptCost() {
+		// Best effort: the login itself has succeeded either way
+		if newHash, err := bcrypt.GenerateFromPassword([]byte(password), r.bcryptCost()); err == nil {
+			r.db.Exec(ctx, "UPDATE "+r.table+" SET password_hash = $2 WHERE username = $1 AND password_hash = $3",
+				username, string(newHash), *row.PasswordHash)
+		}
+	}
+	return &row.User, nil
+}
+
+// SetPassword stores the hash of password for the named user and bumps
+// updated_at and version.
+func (r *PostgresRepository) SetPassword(ctx context.Context, username, password string) error {
+	hash, err := r.hashPassword(password)
+	if err != nil {
+		return err
+	}
+	n, err := r.q.SetPasswordHash(ctx, usersdb.SetPasswordHashParams{PasswordHash: hash, Username: username})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+var (
+	dummyHashesMu sync.Mutex
+	dummyHashes   = map[int][]byte{}
+)
+
+// dummyHash returns a hash of a random-looking password at cost, made once
+// per cost, for Authenticate to compare with when there is no real hash.
+func dummyHash(cost int) []byte {
+	dummyHashesMu.Lock()
+	defer dummyHashesMu.Unlock()
+	h, ok := dummyHashes[cost]
+	if !ok {
+		h, _ = bcrypt.GenerateFromPassword([]byte("no such user, no such password"), cost)
+		dummyHashes[cost] = h
+	}
+	return h
+}
@@ -0,0 +1,126 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Post is something a user wrote, a row of the posts table of migration
+// 0015. It belongs to the user UserID and is removed with it.
+type Post struct {
+	ID        int64     `db:"id" json:"id"`
+	UserID    ID        `db:"user_id" json:"user_id"`
+	Title     string    `db:"title" json:"title" validate:"required,max=200"`
+	Body      string    `db:"body" json:"body"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// UserPosts is a user and the number of posts it has written.
+type UserPosts struct {
+	User
+	Posts int64 `db:"posts" json:"posts"`
+}
+
+// ValidatePost checks a post against the validate tags of Post: the title
+// is required and within the column limit.
+func ValidatePost(p Post) error {
+	return validationError(validate.Struct(p))
+}
+
+// PostStore writes posts and reads them along with their users. Only
+// PostgresRepository implements it: migration 0015 is PostgreSQL-only, so
+// the table does not exist on CockroachDB, MySQL or SQLite.
+type PostStore interface {
+	// CreatePost inserts p for the user p.UserID and fills in its ID and
+	// CreatedAt. A user that does not exist or was deleted is ErrNotFound.
+	CreatePost(ctx context.Context, p *Post) error
+	// Posts returns the posts of the user with the given id, newest
+	// first; at most limit of them unless limit is zero.
+	Posts(ctx context.Context, userID ID, limit int) ([]Post, error)
+	// PostCounts returns the users with the number of posts each has
+	// written, most first, users without posts included; at most limit of
+	// them unless limit is zero.
+	PostCounts(ctx context.Context, limit int) ([]UserPosts, error)
+}
+
+var _ PostStore = (*PostgresRepository)(nil)
+
+// postsTable returns the quoted, schema-qualified name of the posts table.
+func (r *PostgresRepository) postsTable() string {
+	return pgx.Identifier{r.opts.Schema, "posts"}.Sanitize()
+}
+
+// CreatePost inserts p by selecting its author from users, so a deleted
+// user, which the foreign key alone would accept, inserts nothing either.
+// An id of the wrong kind for the table matches no user, as in GetByID.
+func (r *PostgresRepository) CreatePost(ctx context.Context, p *Post) error {
+	if err := ValidatePost(*p); err != nil {
+		return err
+	}
+	err := r.db.QueryRow(ctx, `INSERT INTO `+r.postsTable()+` (user_id, title, body)
+		SELECT id, $2, $3 FROM `+r.table+` WHERE id = $1 AND `+NotDeleted+`
+		RETURNING id, created_at`, string(p.UserID), p.Title, p.Body).Scan(&p.ID, &p.CreatedAt)
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.Is(err, pgx.ErrNoRows), errors.As(err, &pgErr) && pgErr.Code == "22P02":
+		return fmt.Errorf("%w: no user has id %s", ErrNotFound, p.UserID)
+	case err != nil:
+		return pgError(err)
+	}
+	return nil
+}
+
+// Posts returns the posts of the user with the given id, newest first,
+// through the (user_id, id) index.
+func (r *PostgresRepository) Posts(ctx context.Context, userID ID, limit int) ([]Post, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalid)
+	}
+	posts, err := db.Select[Post](ctx, r.db, `SELECT id, user_id, title, body, created_at
+		FROM `+r.postsTable()+` WHERE user_id = $1 ORDER BY id DESC LIMIT $2`, string(userID), nullLimit(limit))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return nil, nil
+	}
+	return posts, pgError(err)
+}
+
+// PostCounts joins users with their posts and counts them per user. The
+// LEFT JOIN keeps the users without posts, whose count is zero; grouping
+// by the primary key lets the query select every column of users. Ties
+// are broken oldest user first.
+func (r *PostgresRepository) PostCounts(ctx context.Context, limit int) ([]UserPosts, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalid)
+	}
+	counts, err := db.Select[UserPosts](ctx, r.db, `SELECT u.id, u.username, u.email, u.created_at, u.updated_at, u.version, u.source, u.deleted_at,
+			count(p.id) AS posts
+		FROM `+r.table+` u
+		LEFT JOIN `+r.postsTable()+` p ON p.user_id = u.id
+		WHERE u.deleted_at IS NULL
+		GROUP BY u.id
+		ORDER BY posts DESC, u.created_at, u.id
+		LIMIT $1`, nullLimit(limit))
+	return counts, pgError(err)
+}
+
+// nullLimit turns a limit of zero into NULL, for which LIMIT returns every
+// row.
+func nullLimit(limit int) *int {
+	if limit == 0 {
+		return nil
+	}
+	return &limit
+}
+
+// limitParam is nullLimit for the queries of usersdb.
+func limitParam(limit int) pgtype.Int4 {
+	return pgtype.Int4{Int32: int32(limit), Valid: limit != 0}
+}
@@ -0,0 +1,115 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/usersdb"
+	"github.com/jackc/pgx/v5"
+)
+
+// Profile is the optional detail about a user kept in the JSONB profile
+// column. The repository marshals it to JSON on the way in and unmarshals
+// it on the way out, so callers never handle the JSON text. Keys outside
+// these fields survive a merge but are dropped by a read-modify-write
+// through Profile.
+type Profile struct {
+	DisplayName string            `json:"display_name,omitempty"`
+	Locale      string            `json:"locale,omitempty"`
+	Timezone    string            `json:"timezone,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// ProfileStore reads and writes user profiles. Only PostgresRepository
+// implements it: the MySQL and SQLite tables have no profile column.
+type ProfileStore interface {
+	// Profile returns the profile of the named user or ErrNotFound.
+	Profile(ctx context.Context, username string) (*Profile, error)
+	// SetProfile replaces the profile of the named user.
+	SetProfile(ctx context.Context, username string, p Profile) error
+	// MergeProfile overwrites the top-level keys set in patch and returns
+	// the resulting profile.
+	MergeProfile(ctx context.Context, username string, patch Profile) (*Profile, error)
+	// FindByProfile returns one page of the users whose profile contains
+	// match, oldest first.
+	FindByProfile(ctx context.Context, match Profile, page Page) ([]User, error)
+}
+
+var _ ProfileStore = (*PostgresRepository)(nil)
+
+// Profile returns the profile of the named user; an empty profile when
+// none was ever set.
+func (r *PostgresRepository) Profile(ctx context.Context, username string) (*Profile, error) {
+	raw, err := r.q.GetProfile(ctx, username)
+	return decodeProfile(raw, err)
+}
+
+// SetProfile replaces the profile of the named user and bumps updated_at
+// and version, which invalidates versions read for an optimistic Update.
+func (r *PostgresRepository) SetProfile(ctx context.Context, username string, p Profile) error {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	n, err := r.q.SetProfile(ctx, usersdb.SetProfileParams{Profile: raw, Username: username})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MergeProfile merges patch into the stored profile with the jsonb ||
+// operator, in a single statement so concurrent merges of different keys
+// do not overwrite each other. The merge is shallow: a field set in patch
+// replaces the stored value whole, so patching Tags or Attributes replaces
+// the list or map rather than adding to it. Empty fields are omitted from
+// the JSON and leave the stored value alone.
+func (r *PostgresRepository) MergeProfile(ctx context.Context, username string, patch Profile) (*Profile, error) {
+	raw, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	merged, err := r.q.MergeProfile(ctx, usersdb.MergeProfileParams{Patch: raw, Username: username})
+	return decodeProfile(merged, err)
+}
+
+// decodeProfile turns the profile column read by a usersdb query, and the
+// error of the query, into a Profile.
+func decodeProfile(raw []byte, err error) (*Profile, error) {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// FindByProfile returns the users whose profile contains match in the
+// sense of the jsonb @> operator: every field set in match must be present
+// with the same value, Tags need only be a subset of the user's tags and
+// Attributes a subset of theirs. The GIN index on profile serves the
+// containment test, so it stays fast as the table grows.
+func (r *PostgresRepository) FindByProfile(ctx context.Context, match Profile, page Page) ([]User, error) {
+	if page.Limit < 0 || page.Offset < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalid)
+	}
+	var limit *int
+	if page.Limit > 0 {
+		limit = &page.Limit
+	}
+	return db.Select[User](ctx, r.db, "SELECT "+columns+" FROM "+r.table+`
+		WHERE profile @> $1::jsonb AND `+NotDeleted+`
+		ORDER BY created_at, id LIMIT $2 OFFSET $3`, match, limit, page.Offset)
+}
@@ -0,0 +1,117 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// fakePostgres serves PostgreSQL connections on a local port that answer
+// every simple query with a single int4 row holding 42, and returns the
+// connection string of a pool of at most one connection to it.
+func fakePostgres(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				backend := pgproto3.NewBackend(conn, conn)
+				if _, err := backend.ReceiveStartupMessage(); err != nil {
+					return
+				}
+				backend.Send(&pgproto3.AuthenticationOk{})
+				// Which pgx needs to send queries over the simple protocol
+				backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+				backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				for backend.Flush() == nil {
+					msg, err := backend.Receive()
+					if err != nil {
+						return
+					}
+					switch msg.(type) {
+					case *pgproto3.Query:
+						backend.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("n"), DataTypeOID: 23, DataTypeSize: 4, TypeModifier: -1}}})
+						backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte("42")}})
+						backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+						backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+					case *pgproto3.Terminate:
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return fmt.Sprintf("postgres://app@%s/app?sslmode=disable&default_query_exec_mode=simple_protocol&pool_max_conns=1", lis.Addr())
+}
+
+func TestRawReleasesPooledConnection(t *testing.T) {
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, fakePostgres(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+	repo := NewRepository(pool, Options{})
+	if repo.Unwrap() != Querier(pool) {
+		t.Errorf("Unwrap = %v, want the pool", repo.Unwrap())
+	}
+
+	// With a single connection, the second round only gets one if the
+	// first gave it back
+	for i := range 2 {
+		timeout, cancel := context.WithTimeout(ctx, 2*time.Second)
+		conn, release, err := repo.Raw(timeout)
+		cancel()
+		if err != nil {
+			t.Fatalf("Raw %d: %v", i, err)
+		}
+		if n := pool.Stat().AcquiredConns(); n != 1 {
+			t.Errorf("Raw %d: %d connections acquired, want 1", i, n)
+		}
+		var n int
+		if err := conn.QueryRow(ctx, "SELECT 42").Scan(&n); err != nil || n != 42 {
+			t.Errorf("custom query on the raw connection = %d, %v", n, err)
+		}
+		release()
+		if n := pool.Stat().AcquiredConns(); n != 0 {
+			t.Errorf("after release %d: %d connections acquired, want 0", i, n)
+		}
+	}
+}
+
+func TestRawOfConnection(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fakePostgres(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(ctx)
+	raw, release, err := NewRepository(conn, Options{}).Raw(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if raw != conn {
+		t.Error("Raw of a repository on a connection is not that connection")
+	}
+	// release does not close a connection the caller owns
+	if _, err := conn.Exec(ctx, "SELECT 42"); err != nil {
+		t.Errorf("connection after release: %v", err)
+	}
+}
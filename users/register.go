@@ -0,0 +1,71 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Registrar creates users through the register_user function of migration
+// 0018 instead of an INSERT of its own. Only PostgresRepository implements
+// it: the migration is PostgreSQL-only.
+type Registrar interface {
+	// RegisterUser inserts u like Create with ConflictFail, and fills in
+	// the columns the database sets. An email taken in another case is
+	// refused as well: ErrDuplicateEmail.
+	RegisterUser(ctx context.Context, u *User) error
+}
+
+var _ Registrar = (*PostgresRepository)(nil)
+
+// The SQLSTATEs register_user raises, besides those of the INSERT itself.
+const (
+	registerMissing      = "UR001"
+	registerUsernameUsed = "UR002"
+	registerEmailUsed    = "UR003"
+)
+
+// RegisterUserStatement returns the call RegisterUser makes for u and its
+// arguments, so that --dry-run can print it.
+func (r *PostgresRepository) RegisterUserStatement(u User) (string, []any) {
+	fn := pgx.Identifier{r.opts.Schema, "register_user"}.Sanitize()
+	return `SELECT id, created_at, updated_at, version, source FROM ` + fn + `($1, $2, $3)`,
+		[]any{u.Username, u.Email, u.Source}
+}
+
+// RegisterUser calls register_user. A function with OUT parameters returns
+// one row whose columns are named after them, so the call selects them
+// like the columns of a table. Its exceptions arrive as *pgconn.PgError,
+// which registerUserError maps by SQLSTATE.
+func (r *PostgresRepository) RegisterUser(ctx context.Context, u *User) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	sql, args := r.RegisterUserStatement(*u)
+	err := r.db.QueryRow(ctx, sql, args...).Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	return registerUserError(err)
+}
+
+// registerUserError turns the exceptions register_user raises into the
+// package's errors, keeping the function's message: UR001 becomes
+// ErrInvalid, UR002 ErrDuplicateUsername and UR003 ErrDuplicateEmail. Any
+// other error goes through pgError, which handles a unique violation of a
+// registration that lost a race.
+func registerUserError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return pgError(err)
+	}
+	switch pgErr.Code {
+	case registerMissing:
+		return fmt.Errorf("%w: %s", ErrInvalid, pgErr.Message)
+	case registerUsernameUsed:
+		return fmt.Errorf("%w: %s", ErrDuplicateUsername, pgErr.Message)
+	case registerEmailUsed:
+		return fmt.Errorf("%w: %s", ErrDuplicateEmail, pgErr.Message)
+	}
+	return pgError(err)
+}
@@ -0,0 +1,97 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// EmailDomain is a row of EmailDomains: the users whose email is at
+// Domain, and the percentage of all users that makes.
+type EmailDomain struct {
+	Domain string  `db:"domain" json:"domain"`
+	Users  int64   `db:"users" json:"users"`
+	Share  float64 `db:"share" json:"share"`
+}
+
+// DuplicateEmail is a row of DuplicateEmails: an email, in lower case,
+// that Users users share when case is ignored, and their usernames, oldest
+// first.
+type DuplicateEmail struct {
+	Email     string   `db:"email" json:"email"`
+	Users     int64    `db:"users" json:"users"`
+	Usernames []string `db:"usernames" json:"usernames"`
+}
+
+// ReportStore runs the aggregate queries of the report command on the
+// users table as it is now, unlike the user_stats view of StatsStore. Only
+// PostgresRepository implements it; the queries run on PostgreSQL and
+// CockroachDB alike.
+type ReportStore interface {
+	// SignupsPerDay returns the users who signed up each UTC day since
+	// since, soft-deleted users included, newest first; every day when
+	// since is zero. Days without signups are left out.
+	SignupsPerDay(ctx context.Context, since time.Time) ([]DailySignups, error)
+	// EmailDomains returns the domains of the users' emails, ignoring
+	// case, with the most users first; at most limit of them unless limit
+	// is zero.
+	EmailDomains(ctx context.Context, limit int) ([]EmailDomain, error)
+	// DuplicateEmails returns the emails that more than one user has when
+	// case is ignored, which the unique index on email lets through; at
+	// most limit of them unless limit is zero.
+	DuplicateEmails(ctx context.Context, limit int) ([]DuplicateEmail, error)
+}
+
+var _ ReportStore = (*PostgresRepository)(nil)
+
+// SignupsPerDay groups users by the UTC date of created_at, as user_stats
+// does.
+func (r *PostgresRepository) SignupsPerDay(ctx context.Context, since time.Time) ([]DailySignups, error) {
+	rows, err := r.q.SignupsPerDay(ctx, pgtype.Timestamptz{Time: since, Valid: !since.IsZero()})
+	if err != nil {
+		return nil, pgError(err)
+	}
+	days := make([]DailySignups, len(rows))
+	for i, row := range rows {
+		days[i] = DailySignups{Day: row.Day.Time, Signups: row.Signups}
+	}
+	return days, nil
+}
+
+// EmailDomains groups the users that are not deleted by the part of their
+// email after the @. The share comes from a window over the grouped rows,
+// so the total is counted in the same scan.
+func (r *PostgresRepository) EmailDomains(ctx context.Context, limit int) ([]EmailDomain, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalid)
+	}
+	rows, err := r.q.EmailDomains(ctx, limitParam(limit))
+	if err != nil {
+		return nil, pgError(err)
+	}
+	domains := make([]EmailDomain, len(rows))
+	for i, row := range rows {
+		domains[i] = EmailDomain(row)
+	}
+	return domains, nil
+}
+
+// DuplicateEmails groups the users that are not deleted by lower(email),
+// which the index of migration 0016 serves on PostgreSQL, and keeps the
+// groups of more than one.
+func (r *PostgresRepository) DuplicateEmails(ctx context.Context, limit int) ([]DuplicateEmail, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: limit must not be negative", ErrInvalid)
+	}
+	rows, err := r.q.DuplicateEmails(ctx, limitParam(limit))
+	if err != nil {
+		return nil, pgError(err)
+	}
+	dups := make([]DuplicateEmail, len(rows))
+	for i, row := range rows {
+		dups[i] = DuplicateEmail(row)
+	}
+	return dups, nil
+}
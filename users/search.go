@@ -0,0 +1,54 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// SearchResult is a user matched by Search, with its relevance.
+type SearchResult struct {
+	User
+	// Rank is ts_rank of the match: higher is more relevant. A match on
+	// the username outranks one on the email, which outranks the profile.
+	Rank float32 `db:"rank" json:"rank"`
+}
+
+// Search runs a full-text query against the search column (username,
+// email and profile strings) and returns one page of the matches, most
+// relevant first. query is in to_tsquery syntax: terms combined with &
+// (and), | (or) and ! (not), and a :* suffix for prefix matching, as in
+// "ali:* & !bob". A query that does not parse is reported as ErrInvalid.
+//
+// The GIN index on search serves the @@ match; ranking then only touches
+// the matching rows. Search is PostgreSQL-only, like the column itself.
+func (r *PostgresRepository) Search(ctx context.Context, query string, page Page) ([]SearchResult, error) {
+	if page.Limit < 0 || page.Offset < 0 {
+		return nil, fmt.Errorf("%w: limit and offset must not be negative", ErrInvalid)
+	}
+	var limit *int
+	if page.Limit > 0 {
+		limit = &page.Limit
+	}
+	results, err := db.Select[SearchResult](ctx, r.db, "SELECT "+columns+`, ts_rank(search, q) AS rank
+		FROM `+r.table+`, to_tsquery('simple', $1) AS q
+		WHERE search @@ q AND `+NotDeleted+`
+		ORDER BY rank DESC, id LIMIT $2 OFFSET $3`, query, limit, page.Offset)
+	if err != nil {
+		return nil, invalidQueryError(query, err)
+	}
+	return results, nil
+}
+
+// invalidQueryError wraps the syntax error to_tsquery raises for a
+// malformed query in ErrInvalid and returns any other error unchanged.
+func invalidQueryError(query string, err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42601" {
+		return fmt.Errorf("%w: search query %q: %s", ErrInvalid, query, pgErr.Message)
+	}
+	return err
+}
@@ -0,0 +1,311 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SQLQuerier is the subset of database/sql the MySQL and SQLite
+// repositories need. *sql.DB, *sql.Conn and *sql.Tx all satisfy it.
+type SQLQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+var (
+	_ SQLQuerier = (*sql.DB)(nil)
+	_ SQLQuerier = (*sql.Conn)(nil)
+	_ SQLQuerier = (*sql.Tx)(nil)
+)
+
+// sqlRepository holds the Repository methods whose SQL is the same in
+// every database/sql dialect. MySQLRepository and SQLiteRepository embed it
+// and add the statements that differ.
+type sqlRepository struct {
+	db   SQLQuerier
+	opts Options
+	// timeLayout formats a time the way the dialect stores created_at, so
+	// a cursor compares equal to the row it came from. The time keeps the
+	// zone it was scanned in: the wall clock is what the column holds.
+	timeLayout string
+	// dialectError translates the dialect's errors as pgError does.
+	dialectError func(error) error
+}
+
+func newSQLRepository(db SQLQuerier, opts Options, timeLayout string, dialectError func(error) error) sqlRepository {
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if opts.OnEmailConflict == "" {
+		opts.OnEmailConflict = ConflictFail
+	}
+	return sqlRepository{db: db, opts: opts, timeLayout: timeLayout, dialectError: dialectError}
+}
+
+// precheck looks for an existing user with u's username or email when
+// PrecheckDuplicates applies; see PostgresRepository.Create.
+func (r *sqlRepository) precheck(ctx context.Context, u *User) error {
+	if !r.opts.PrecheckDuplicates || r.opts.OnConflict != ConflictSkip || r.opts.OnEmailConflict == ConflictUpsert {
+		return nil
+	}
+	var field string
+	err := r.db.QueryRowContext(ctx, `SELECT CASE WHEN username = ? THEN 'username' ELSE 'email' END
+		FROM users WHERE username = ? OR email = ? LIMIT 1`, u.Username, u.Username, u.Email).Scan(&field)
+	switch {
+	case err == nil:
+		value := u.Username
+		if field == "email" {
+			value = u.Email
+		}
+		return fmt.Errorf("%w: %s %q is already taken", duplicateKind(field), field, value)
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	default:
+		return err
+	}
+}
+
+// createEach implements CreateMany with one create call per user. The
+// database/sql drivers have no equivalent of a pgx.Batch, so this takes a
+// round trip per user; run it in a transaction to make the batch atomic.
+func createEach(ctx context.Context, create func(context.Context, *User) error, us []User) ([]error, error) {
+	results := make([]error, len(us))
+	for i := range us {
+		results[i] = create(ctx, &us[i])
+		if errors.Is(results[i], context.Canceled) || errors.Is(results[i], context.DeadlineExceeded) {
+			return results, results[i]
+		}
+	}
+	return results, nil
+}
+
+// GetByID returns the user with the given id.
+func (r *sqlRepository) GetByID(ctx context.Context, id ID) (*User, error) {
+	return r.getOne(ctx, "id = ?", string(id))
+}
+
+// GetByIDs returns the users with the given ids.
+func (r *sqlRepository) GetByIDs(ctx context.Context, ids []ID) ([]User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	where := newWhereBuilder(sqlPlaceholder)
+	where.addIDs(ids)
+	where.add(NotDeleted)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+columns+" FROM users"+where.clause(), where.args...)
+	if err != nil {
+		return nil, r.dialectError(err)
+	}
+	return collectUsers(rows)
+}
+
+// GetByUsername returns the user with the given username.
+func (r *sqlRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return r.getOne(ctx, "username = ?", username)
+}
+
+func (r *sqlRepository) getOne(ctx context.Context, where string, arg any) (*User, error) {
+	var u User
+	err := scanUser(r.db.QueryRowContext(ctx, "SELECT "+columns+" FROM users WHERE "+where+" AND "+NotDeleted, arg), &u)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, r.dialectError(err)
+	}
+	return &u, nil
+}
+
+// List returns the users in page, ordered by creation time and id. The
+// cursor comparison is spelled out rather than written as a row value so
+// both dialects can use the (created_at, id) order of an index for it.
+func (r *sqlRepository) List(ctx context.Context, page Page) ([]User, string, error) {
+	if err := checkPage(page); err != nil {
+		return nil, "", err
+	}
+	// Neither dialect has LIMIT ALL, and MySQL accepts OFFSET only after a LIMIT
+	limit := int64(math.MaxInt64)
+	if page.Limit > 0 {
+		limit = int64(page.Limit) + 1
+	}
+	where := newWhereBuilder(sqlPlaceholder)
+	if page.After != "" {
+		c, err := parseCursor(page.After)
+		if err != nil {
+			return nil, "", err
+		}
+		at := c.CreatedAt.Format(r.timeLayout)
+		where.add("created_at > ? OR (created_at = ? AND id > ?)", at, at, string(c.ID))
+	}
+	where.addFilter(page.Filter, r.formatTime)
+	rows, err := r.db.QueryContext(ctx, "SELECT "+columns+" FROM users"+where.clause()+" ORDER BY created_at, id LIMIT ? OFFSET ?", append(where.args, limit, page.Offset)...)
+	if err != nil {
+		return nil, "", r.dialectError(err)
+	}
+	out, err := collectUsers(rows)
+	if err != nil {
+		return nil, "", r.dialectError(err)
+	}
+	out, next := nextCursor(out, page)
+	return out, next, nil
+}
+
+// ForEachUser calls fn for every user as the rows are read. The MySQL
+// driver streams the result set; SQLite steps through it row by row.
+func (r *sqlRepository) ForEachUser(ctx context.Context, fn func(User) error) error {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+columns+" FROM users WHERE "+NotDeleted+" ORDER BY created_at, id")
+	if err != nil {
+		return err
+	}
+	return eachUser(rows, fn)
+}
+
+// ColumnUpgrade adds Column to a users table that MySQLSchema or
+// SQLiteSchema created before it had the column. Neither dialect has a
+// migration history to tell, so the caller checks for the column first.
+type ColumnUpgrade struct {
+	Column string
+	SQL    string
+}
+
+// scanUser scans the columns constant into u.
+func scanUser(row interface{ Scan(dest ...any) error }, u *User) error {
+	return row.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source, &u.DeletedAt)
+}
+
+// eachUser calls fn with each user of rows, scanned with scanUser, and
+// closes rows. database/sql has no struct scanning of its own, so this is
+// the sqlRepository's counterpart of db.Each.
+func eachUser(rows *sql.Rows, fn func(User) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		var u User
+		if err := scanUser(rows, &u); err != nil {
+			return err
+		}
+		if err := fn(u); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// collectUsers returns the users of rows, as db.Select does.
+func collectUsers(rows *sql.Rows) ([]User, error) {
+	var found []User
+	err := eachUser(rows, func(u User) error {
+		found = append(found, u)
+		return nil
+	})
+	return found, err
+}
+
+// Count returns the number of rows in the users table matching f.
+func (r *sqlRepository) Count(ctx context.Context, f Filter) (int64, error) {
+	if err := checkFilter(f); err != nil {
+		return 0, err
+	}
+	where := newWhereBuilder(sqlPlaceholder)
+	where.addFilter(f, r.formatTime)
+	var n int64
+	err := r.db.QueryRowContext(ctx, "SELECT count(*) FROM users"+where.clause(), where.args...).Scan(&n)
+	return n, r.dialectError(err)
+}
+
+// formatTime formats t as the dialect stores created_at.
+func (r *sqlRepository) formatTime(t time.Time) any {
+	return t.UTC().Format(r.timeLayout)
+}
+
+// emailTaken handles an insert of u that failed with err because another
+// user holds u.Email, as Options.OnEmailConflict says; see
+// PostgresRepository.Create. now is the dialect's current time. The
+// dialects run the rename without a savepoint, since a failed statement
+// does not abort their transactions.
+func (r *sqlRepository) emailTaken(ctx context.Context, u *User, err error, now string) error {
+	switch r.opts.OnEmailConflict {
+	case ConflictFail:
+		return err
+	case ConflictSkip:
+		return fmt.Errorf("%w: email %q is already taken", ErrDuplicateEmail, u.Email)
+	}
+	res, err := r.db.ExecContext(ctx, "UPDATE users SET username = ?, updated_at = "+now+", version = version + 1, deleted_at = NULL WHERE email = ? AND (username <> ? OR deleted_at IS NOT NULL)",
+		u.Username, u.Email, u.Username)
+	if err != nil {
+		return r.dialectError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("%w: email %q already belongs to %q", ErrDuplicateEmail, u.Email, u.Username)
+	}
+	err = r.db.QueryRowContext(ctx, "SELECT id, created_at, updated_at, version, source FROM users WHERE email = ?", u.Email).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	if err != nil {
+		return r.dialectError(err)
+	}
+	return ErrUpdated
+}
+
+// missingOrConflict explains why a conditional update of username matched
+// no row: ErrNotFound when the user does not exist, ErrStaleRecord otherwise.
+func (r *sqlRepository) missingOrConflict(ctx context.Context, username string) error {
+	var exists bool
+	if err := r.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM users WHERE username = ? AND "+NotDeleted+")", username).Scan(&exists); err != nil {
+		return r.dialectError(err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrStaleRecord
+}
+
+// Delete marks the user with the given username as deleted, bumping
+// updated_at and version. now is the dialect's current time, as the
+// timestamp columns store it.
+func (r *sqlRepository) delete(ctx context.Context, username, now string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE users SET deleted_at = "+now+", updated_at = "+now+", version = version + 1 WHERE username = ? AND "+NotDeleted, username)
+	if err != nil {
+		return r.dialectError(err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// purge deletes the rows marked as deleted before, an expression of the
+// dialect for the cutoff time whose ? is filled in with arg.
+func (r *sqlRepository) purge(ctx context.Context, before string, arg any) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "DELETE FROM users WHERE deleted_at < "+before, arg)
+	if err != nil {
+		return 0, r.dialectError(err)
+	}
+	return res.RowsAffected()
+}
+
+// beginner starts a transaction; *sql.DB and *sql.Conn are beginners.
+type beginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// begin starts the transaction BulkCreate runs in. database/sql has no
+// nested transactions, so a repository on a *sql.Tx cannot bulk load.
+func (r *sqlRepository) begin(ctx context.Context) (*sql.Tx, error) {
+	db, ok := r.db.(beginner)
+	if !ok {
+		return nil, errors.New("BulkCreate needs a *sql.DB or *sql.Conn, not a transaction")
+	}
+	return db.BeginTx(ctx, nil)
+}
@@ -0,0 +1,224 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// sqliteNow is the current time as SQLite stores it in the timestamp
+// columns: UTC text with millisecond precision, which the driver scans into
+// time.Time because the columns are declared DATETIME.
+const sqliteNow = `strftime('%Y-%m-%d %H:%M:%f', 'now')`
+
+// SQLiteSchema is the PostgreSQL users table translated to SQLite: SERIAL
+// becomes INTEGER PRIMARY KEY (an alias of the rowid, assigned on insert),
+// and the timestamps default to sqliteNow. The migrations use PostgreSQL
+// DDL that SQLite does not accept, so this replaces them.
+const SQLiteSchema = `CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY,
+	username VARCHAR(50) NOT NULL UNIQUE,
+	email VARCHAR(100) NOT NULL UNIQUE,
+	created_at DATETIME NOT NULL DEFAULT (` + sqliteNow + `),
+	updated_at DATETIME NOT NULL DEFAULT (` + sqliteNow + `),
+	version INTEGER NOT NULL DEFAULT 1,
+	source TEXT,
+	deleted_at DATETIME
+)`
+
+// SQLiteUpgrades add the columns SQLiteSchema gained to older tables, in
+// the order they were added.
+var SQLiteUpgrades = []ColumnUpgrade{
+	{"version", "ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1"},
+	{"deleted_at", "ALTER TABLE users ADD COLUMN deleted_at DATETIME"},
+}
+
+// SQLiteRepository is the Repository backed by an embedded SQLite database
+// through database/sql. SQLite understands ON CONFLICT and RETURNING much
+// like PostgreSQL, so its statements stay close to PostgresRepository's.
+type SQLiteRepository struct {
+	sqlRepository
+}
+
+var _ Repository = (*SQLiteRepository)(nil)
+
+// NewSQLiteRepository returns a repository for the users table of the
+// SQLite database db is open on; opts.Schema is not used.
+func NewSQLiteRepository(db SQLQuerier, opts Options) *SQLiteRepository {
+	return &SQLiteRepository{newSQLRepository(db, opts, "2006-01-02 15:04:05.000", sqliteError)}
+}
+
+// Create inserts u, handling a taken username as Options.OnConflict says:
+// skip and upsert insert with ON CONFLICT (username) DO NOTHING, and upsert
+// then overwrites the email of the existing user in a second statement,
+// since SQLite's RETURNING cannot tell an inserted row from an updated one.
+// An email that another user holds is handled as Options.OnEmailConflict
+// says.
+func (r *SQLiteRepository) Create(ctx context.Context, u *User) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	if err := r.precheck(ctx, u); err != nil {
+		return err
+	}
+
+	onConflict := " ON CONFLICT (username) DO NOTHING"
+	if r.opts.OnConflict == ConflictFail {
+		onConflict = ""
+	}
+	err := r.db.QueryRowContext(ctx, `INSERT INTO users (username, email, source) VALUES (?, ?, ?)`+onConflict+`
+		RETURNING id, created_at, updated_at, version, source`, u.Username, u.Email, u.Source).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && r.opts.OnConflict == ConflictUpsert:
+		return r.overwrite(ctx, u)
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: username %q is already taken", ErrDuplicateUsername, u.Username)
+	case err != nil:
+		return r.createError(ctx, u, err)
+	}
+	return nil
+}
+
+// createError translates a failed insert or overwrite of u, handing a
+// taken email to emailTaken.
+func (r *SQLiteRepository) createError(ctx context.Context, u *User, err error) error {
+	err = sqliteError(err)
+	if errors.Is(err, ErrDuplicateEmail) {
+		return r.emailTaken(ctx, u, err, sqliteNow)
+	}
+	return err
+}
+
+// overwrite is the update half of an upsert: it sets the email of the
+// existing user named u.Username, unless it is already the same, and
+// restores the user if it was soft-deleted.
+func (r *SQLiteRepository) overwrite(ctx context.Context, u *User) error {
+	err := r.db.QueryRowContext(ctx, `UPDATE users SET email = ?, updated_at = `+sqliteNow+`, version = version + 1, deleted_at = NULL
+		WHERE username = ? AND (email IS NOT ? OR deleted_at IS NOT NULL)
+		RETURNING id, created_at, updated_at, version, source`, u.Email, u.Username, u.Email).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: username %q already has email %q", ErrDuplicateUsername, u.Username, u.Email)
+	case err != nil:
+		return r.createError(ctx, u, err)
+	}
+	return ErrUpdated
+}
+
+// CreateMany creates the users one by one; see createEach.
+func (r *SQLiteRepository) CreateMany(ctx context.Context, us []User) ([]error, error) {
+	return createEach(ctx, r.Create, us)
+}
+
+// BulkCreate creates us one by one in a single transaction. SQLite runs
+// in-process, so a statement per user costs no round trip, and the single
+// commit saves the per-statement fsync that makes row-by-row inserts slow.
+// Duplicates are skipped and not counted, except with ConflictFail, where
+// the first one fails the whole load.
+func (r *SQLiteRepository) BulkCreate(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	if err := validateAll(us); err != nil {
+		return 0, 0, err
+	}
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	opts := r.opts
+	opts.PrecheckDuplicates = false
+	opts.OnEmailConflict = ConflictFail
+	txRepo := NewSQLiteRepository(tx, opts)
+	for _, u := range us {
+		switch err := txRepo.Create(ctx, &u); {
+		case err == nil:
+			inserted++
+		case errors.Is(err, ErrUpdated):
+			updated++
+		case errors.Is(err, ErrDuplicate) && opts.OnConflict != ConflictFail:
+		default:
+			return 0, 0, err
+		}
+	}
+	return inserted, updated, tx.Commit()
+}
+
+// UpsertMany runs BulkCreate with ConflictUpsert, one statement per user
+// in a single transaction, as SQLite has no array parameters. The IDs of
+// us are not filled in, and an email taken by another user skips that user
+// rather than failing the whole load.
+func (r *SQLiteRepository) UpsertMany(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	opts := r.opts
+	opts.OnConflict = ConflictUpsert
+	return NewSQLiteRepository(r.db, opts).BulkCreate(ctx, us)
+}
+
+// sqliteError translates a UNIQUE constraint failure, by the column it
+// names, a foreign key failure and a write to a read-only database as
+// pgError does PostgreSQL's errors, and returns any other error unchanged.
+func sqliteError(err error) error {
+	var liteErr *sqlite.Error
+	if !errors.As(err, &liteErr) {
+		return err
+	}
+	switch liteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		// "UNIQUE constraint failed: users.email"
+		_, column, _ := strings.Cut(liteErr.Error(), "failed:")
+		return fmt.Errorf("%w: %s", duplicateKind(column), liteErr.Error())
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return fmt.Errorf("%w: %s", ErrForeignKeyViolation, liteErr.Error())
+	case sqlite3.SQLITE_READONLY:
+		return fmt.Errorf("%w: %s", ErrReadOnly, liteErr.Error())
+	}
+	return err
+}
+
+// Update changes the user's email; see Repository.Update for the
+// optimistic-concurrency contract. The timestamps only have millisecond
+// precision, so two updates within the same millisecond share an
+// updated_at; the version column tells them apart.
+func (r *SQLiteRepository) Update(ctx context.Context, u *User) error {
+	if err := ValidateEmail(u.Email); err != nil {
+		return err
+	}
+	version, updatedAt := expectedVersion(u)
+	var expected any
+	if updatedAt != nil {
+		expected = sqliteTime(*updatedAt)
+	}
+	err := r.db.QueryRowContext(ctx, `UPDATE users SET email = ?, updated_at = `+sqliteNow+`, version = version + 1
+		WHERE username = ? AND `+NotDeleted+` AND (? IS NULL OR version = ?) AND (? IS NULL OR updated_at = ?)
+		RETURNING updated_at, version`, u.Email, u.Username, version, version, expected, expected).Scan(&u.UpdatedAt, &u.Version)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return r.missingOrConflict(ctx, u.Username)
+	case err != nil:
+		return sqliteError(err)
+	}
+	return nil
+}
+
+// sqliteTime formats t like sqliteNow, so it compares equal to the stored
+// text of the same instant.
+func sqliteTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05.000")
+}
+
+// Delete marks the user with the given username as deleted.
+func (r *SQLiteRepository) Delete(ctx context.Context, username string) error {
+	return r.delete(ctx, username, sqliteNow)
+}
+
+// Purge deletes the rows Delete marked more than olderThan ago.
+func (r *SQLiteRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	return r.purge(ctx, `strftime('%Y-%m-%d %H:%M:%f', 'now', ?)`, fmt.Sprintf("-%.3f seconds", olderThan.Seconds()))
+}
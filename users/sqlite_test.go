@@ -0,0 +1,239 @@
+package users
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openSQLite opens a SQLite database in a file of its own and creates the
+// users table with schema.
+func openSQLite(t *testing.T, schema string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLiteUpgradeAddsVersion(t *testing.T) {
+	db := openSQLite(t, `CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		username VARCHAR(50) NOT NULL UNIQUE,
+		email VARCHAR(100) NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL DEFAULT (`+sqliteNow+`),
+		updated_at DATETIME NOT NULL DEFAULT (`+sqliteNow+`),
+		source TEXT
+	)`)
+	if _, err := db.Exec("INSERT INTO users (username, email) VALUES ('alice', 'alice@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	for _, upgrade := range SQLiteUpgrades {
+		if _, err := db.Exec(upgrade.SQL); err != nil {
+			t.Fatalf("adding %s: %v", upgrade.Column, err)
+		}
+	}
+	u, err := NewSQLiteRepository(db, Options{}).GetByUsername(context.Background(), "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Version != 1 {
+		t.Errorf("version = %d, want 1", u.Version)
+	}
+}
+
+func TestSQLiteVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := NewSQLiteRepository(openSQLite(t, SQLiteSchema), Options{OnConflict: ConflictUpsert})
+
+	u := User{Username: "alice", Email: "alice@example.com"}
+	if err := repo.Create(ctx, &u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Version != 1 {
+		t.Fatalf("created with version %d, want 1", u.Version)
+	}
+
+	update := User{Username: "alice", Email: "alice@new.example", Version: 1}
+	if err := repo.Update(ctx, &update); err != nil {
+		t.Fatal(err)
+	}
+	if update.Version != 2 {
+		t.Errorf("updated to version %d, want 2", update.Version)
+	}
+
+	stale := User{Username: "alice", Email: "alice@stale.example", Version: 1}
+	if err := repo.Update(ctx, &stale); !errors.Is(err, ErrStaleRecord) {
+		t.Errorf("update with a stale version: error = %v, want ErrStaleRecord", err)
+	}
+
+	upsert := User{Username: "alice", Email: "alice@upsert.example"}
+	if err := repo.Create(ctx, &upsert); !errors.Is(err, ErrUpdated) {
+		t.Fatalf("upsert: error = %v, want ErrUpdated", err)
+	}
+	if upsert.Version != 3 {
+		t.Errorf("upserted to version %d, want 3", upsert.Version)
+	}
+
+	missing := User{Username: "bob", Email: "bob@example.com", Version: 1}
+	if err := repo.Update(ctx, &missing); !errors.Is(err, ErrNotFound) {
+		t.Errorf("update of a missing user: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLiteSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	db := openSQLite(t, `CREATE TABLE users (
+		id INTEGER PRIMARY KEY,
+		username VARCHAR(50) NOT NULL UNIQUE,
+		email VARCHAR(100) NOT NULL UNIQUE,
+		created_at DATETIME NOT NULL DEFAULT (`+sqliteNow+`),
+		updated_at DATETIME NOT NULL DEFAULT (`+sqliteNow+`),
+		version INTEGER NOT NULL DEFAULT 1,
+		source TEXT
+	)`)
+	if _, err := db.Exec("INSERT INTO users (username, email) VALUES ('alice', 'alice@example.com'), ('bob', 'bob@example.com')"); err != nil {
+		t.Fatal(err)
+	}
+	for _, upgrade := range SQLiteUpgrades[1:] {
+		if _, err := db.Exec(upgrade.SQL); err != nil {
+			t.Fatalf("adding %s: %v", upgrade.Column, err)
+		}
+	}
+	repo := NewSQLiteRepository(db, Options{})
+
+	if err := repo.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting a deleted user: error = %v, want ErrNotFound", err)
+	}
+	if _, err := repo.GetByUsername(ctx, "alice"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("get of a deleted user: error = %v, want ErrNotFound", err)
+	}
+	listed, _, err := repo.List(ctx, Page{})
+	if err != nil || len(listed) != 1 || listed[0].Username != "bob" {
+		t.Errorf("List = %v, %v; want bob alone", listed, err)
+	}
+	listed, _, err = repo.List(ctx, Page{Filter: Filter{IncludeDeleted: true}})
+	if err != nil || len(listed) != 2 {
+		t.Fatalf("List with deleted users = %v, %v", listed, err)
+	}
+	if alice := listed[0]; alice.DeletedAt == nil || alice.Version != 2 {
+		t.Errorf("deleted alice = %+v, want deleted_at set and version 2", alice)
+	}
+	if n, err := repo.Count(ctx, Filter{}); err != nil || n != 1 {
+		t.Errorf("Count = %d, %v; want 1", n, err)
+	}
+
+	// The username stays taken until purged
+	if err := repo.Create(ctx, &User{Username: "alice", Email: "alice@new.example"}); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("recreating a deleted user: error = %v, want ErrDuplicate", err)
+	}
+
+	if err := repo.Delete(ctx, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET deleted_at = '2000-01-01 00:00:00.000' WHERE username = 'bob'"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := repo.Purge(ctx, 24*time.Hour); err != nil || n != 1 {
+		t.Errorf("Purge = %d, %v; want bob alone", n, err)
+	}
+	if n, err := repo.Count(ctx, Filter{IncludeDeleted: true}); err != nil || n != 1 {
+		t.Errorf("Count with deleted users after Purge = %d, %v; want 1", n, err)
+	}
+}
+
+func TestSQLiteUpsertRestores(t *testing.T) {
+	ctx := context.Background()
+	repo := NewSQLiteRepository(openSQLite(t, SQLiteSchema), Options{OnConflict: ConflictUpsert})
+	if err := repo.Create(ctx, &User{Username: "alice", Email: "alice@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same email too: the upsert must restore the row, not skip it
+	u := User{Username: "alice", Email: "alice@example.com"}
+	if err := repo.Create(ctx, &u); !errors.Is(err, ErrUpdated) {
+		t.Fatalf("upsert of a deleted user: error = %v, want ErrUpdated", err)
+	}
+	if u.DeletedAt != nil || u.Version != 3 {
+		t.Errorf("restored user = %+v, want deleted_at cleared and version 3", u)
+	}
+	if _, err := repo.GetByUsername(ctx, "alice"); err != nil {
+		t.Errorf("get of the restored user: %v", err)
+	}
+}
+
+func TestSQLiteEmailConflict(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		policy  ConflictStrategy
+		wantErr error
+		owner   string // the username holding alice's email afterwards
+	}{
+		{ConflictFail, ErrDuplicateEmail, "alice"},
+		{ConflictSkip, ErrDuplicateEmail, "alice"},
+		{ConflictUpsert, ErrUpdated, "alicia"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			db := openSQLite(t, SQLiteSchema)
+			repo := NewSQLiteRepository(db, Options{OnEmailConflict: tt.policy})
+			if err := repo.Create(ctx, &User{Username: "alice", Email: "alice@example.com"}); err != nil {
+				t.Fatal(err)
+			}
+
+			u := User{Username: "alicia", Email: "alice@example.com"}
+			err := repo.Create(ctx, &u)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Create with a taken email: error = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrDuplicateUsername) {
+				t.Errorf("a taken email reported as a taken username: %v", err)
+			}
+			var owner string
+			if err := db.QueryRow("SELECT username FROM users WHERE email = 'alice@example.com'").Scan(&owner); err != nil {
+				t.Fatal(err)
+			}
+			if owner != tt.owner {
+				t.Errorf("email held by %q, want %q", owner, tt.owner)
+			}
+			if tt.policy == ConflictUpsert && (u.ID != "1" || u.Version != 2) {
+				t.Errorf("upserted %+v, want alice's row renamed at version 2", u)
+			}
+
+			// One taken email does not stop the rest of a batch
+			results, err := repo.CreateMany(ctx, []User{
+				{Username: "bob", Email: "bob@example.com"},
+				{Username: "bobby", Email: "bob@example.com"},
+				{Username: "carol", Email: "carol@example.com"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []error{nil, tt.wantErr, nil}
+			for i := range want {
+				if !errors.Is(results[i], want[i]) || (want[i] == nil && results[i] != nil) {
+					t.Errorf("CreateMany result %d = %v, want %v", i, results[i], want[i])
+				}
+			}
+			if n, err := repo.Count(ctx, Filter{}); err != nil || n != 3 {
+				t.Errorf("Count = %d, %v; want 3", n, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package users
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DailySignups is a row of the user_stats materialized view of migration
+// 0017: the number of users who signed up on Day, a UTC date.
+type DailySignups struct {
+	Day     time.Time `db:"day" json:"day"`
+	Signups int64     `db:"signups" json:"signups"`
+}
+
+// StatsStore reads and refreshes the user_stats view. Only
+// PostgresRepository implements it: migration 0017 is PostgreSQL-only.
+type StatsStore interface {
+	// RefreshStats recomputes user_stats from users. Readers keep seeing
+	// the previous contents until it is done.
+	RefreshStats(ctx context.Context) error
+	// DailySignups returns the signups of the most recent days that had
+	// any, newest first; at most days of them unless days is zero. They
+	// are as of the last RefreshStats.
+	DailySignups(ctx context.Context, days int) ([]DailySignups, error)
+}
+
+var _ StatsStore = (*PostgresRepository)(nil)
+
+// RefreshStats runs REFRESH MATERIALIZED VIEW CONCURRENTLY, which computes
+// the new contents aside and then applies the difference, so it takes no
+// lock that blocks readers; two refreshes of the view take turns.
+func (r *PostgresRepository) RefreshStats(ctx context.Context) error {
+	return pgError(r.q.RefreshUserStats(ctx))
+}
+
+// DailySignups reads user_stats through its unique index on day.
+func (r *PostgresRepository) DailySignups(ctx context.Context, days int) ([]DailySignups, error) {
+	if days < 0 {
+		return nil, fmt.Errorf("%w: days must not be negative", ErrInvalid)
+	}
+	rows, err := r.q.DailySignups(ctx, limitParam(days))
+	if err != nil {
+		return nil, pgError(err)
+	}
+	stats := make([]DailySignups, len(rows))
+	for i, row := range rows {
+		stats[i] = DailySignups{Day: row.Day.Time, Signups: row.Signups}
+	}
+	return stats, nil
+}
@@ -0,0 +1,61 @@
+package users
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hozana-dusabimana/db"
+)
+
+// ForEachUser calls fn for every user, oldest first, as the rows arrive
+// from a single query. Only the current row is held in memory, so the
+// table can be larger than what List could return at once.
+//
+// The query's deadline and the server's statement_timeout run until the
+// last row has been read, fn's time included; ForEachUserCursor bounds each
+// batch separately instead.
+func (r *PostgresRepository) ForEachUser(ctx context.Context, fn func(User) error) error {
+	return db.Each(ctx, r.db, fn, "SELECT "+columns+" FROM "+r.table+" WHERE "+NotDeleted+" ORDER BY created_at, id")
+}
+
+// ForEachUserCursor is ForEachUser through a server-side cursor: it
+// declares one in a transaction (a savepoint when the repository is
+// already on one) and fetches fetchSize rows at a time until it is
+// exhausted. At most fetchSize users are in memory at once.
+//
+// Each FETCH is a statement of its own, so query deadlines and
+// statement_timeout apply per batch rather than to the whole scan, and the
+// server keeps the rest of the result set instead of pushing it down the
+// connection. The transaction, and with it the snapshot the cursor reads,
+// stays open until the last batch: fn sees the table as it was when the
+// scan started, and a very long scan holds back VACUUM meanwhile.
+func (r *PostgresRepository) ForEachUserCursor(ctx context.Context, fetchSize int, fn func(User) error) error {
+	if fetchSize < 1 {
+		return fmt.Errorf("%w: fetch size must be at least 1", ErrInvalid)
+	}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if _, err := tx.Exec(ctx, "DECLARE users_stream NO SCROLL CURSOR FOR SELECT "+columns+" FROM "+r.table+" WHERE "+NotDeleted+" ORDER BY created_at, id"); err != nil {
+		return err
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM users_stream", fetchSize)
+	for {
+		batch, err := db.Select[User](ctx, tx, fetch)
+		if err != nil {
+			return err
+		}
+		for _, u := range batch {
+			if err := fn(u); err != nil {
+				return err
+			}
+		}
+		if len(batch) < fetchSize {
+			break
+		}
+	}
+	// Only reads happened; committing just closes the cursor
+	return tx.Commit(ctx)
+}
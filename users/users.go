@@ -0,0 +1,793 @@
+// Package users stores user records in PostgreSQL or MySQL. All query text
+// and row scanning for the users table lives here, behind the Repository
+// interface.
+package users
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hozana-dusabimana/db"
+	"github.com/hozana-dusabimana/usersdb"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// User is one row of the users table.
+type User struct {
+	ID        ID        `db:"id" json:"id"`
+	Username  string    `db:"username" json:"username" validate:"required,max=50,username"`
+	Email     string    `db:"email" json:"email" validate:"required,max=100,email"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	// UpdatedAt records the last modification.
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	// Version starts at 1 and grows by one with every change to the row;
+	// Repository.Update checks it for optimistic concurrency. Unlike
+	// UpdatedAt it cannot repeat, however fast the changes come and
+	// whatever the precision of the database's timestamps.
+	Version int64 `db:"version" json:"version"`
+	// Source records where the row was imported from; nil when unknown.
+	Source *string `db:"source" json:"source,omitempty"`
+	// DeletedAt is when Delete marked the user as deleted; nil for the
+	// users the application sees. Only Filter.IncludeDeleted lists the
+	// others.
+	DeletedAt *time.Time `db:"deleted_at" json:"deleted_at,omitempty"`
+}
+
+// Errors returned by Repository methods.
+var (
+	// ErrNotFound means no user matched the given key.
+	ErrNotFound = errors.New("user not found")
+	// ErrStaleRecord means the row changed since the caller read it, so
+	// the update was rejected to avoid overwriting another writer's change.
+	ErrStaleRecord = errors.New("user was modified concurrently")
+	// ErrConflict is the former name of ErrStaleRecord.
+	//
+	// Deprecated: use ErrStaleRecord.
+	ErrConflict = ErrStaleRecord
+	// ErrDuplicate means a user with the same username or email already exists.
+	ErrDuplicate = errors.New("user already exists")
+	// ErrDuplicateUsername and ErrDuplicateEmail refine ErrDuplicate with
+	// the column that clashed, where the database tells; errors.Is matches
+	// ErrDuplicate for both.
+	ErrDuplicateUsername error = &refinedError{ErrDuplicate}
+	ErrDuplicateEmail    error = &refinedError{ErrDuplicate}
+	// ErrForeignKeyViolation means a row of another table still refers to
+	// the user, or the user refers to one that does not exist.
+	ErrForeignKeyViolation = errors.New("user is referenced by another row")
+	// ErrConnectionFailed means the database could not be reached or
+	// dropped the connection; the statement may not have run. The driver's
+	// error is wrapped as well.
+	ErrConnectionFailed = errors.New("database connection failed")
+	// ErrInvalid means a record failed validation and was not sent to the database.
+	ErrInvalid = errors.New("invalid user")
+	// ErrReadOnly means the write was refused because the session, or the
+	// application with READ_ONLY set, only reads.
+	ErrReadOnly = errors.New("the database is read-only")
+	// ErrUpdated is not a failure: with ConflictUpsert it reports that the
+	// username existed and that user's email was overwritten instead of a
+	// new user being inserted. The record is filled in as for an insert.
+	ErrUpdated = errors.New("existing user updated")
+)
+
+// ConflictStrategy selects what happens when a user is created with a
+// username that is already taken (Options.OnConflict) or an email that
+// another user holds (Options.OnEmailConflict).
+type ConflictStrategy string
+
+const (
+	// ConflictSkip leaves the existing user alone and reports ErrDuplicate.
+	ConflictSkip ConflictStrategy = "skip"
+	// ConflictUpsert overwrites the existing user's email and reports
+	// ErrUpdated, or ErrDuplicate when the email is already the same.
+	ConflictUpsert ConflictStrategy = "upsert"
+	// ConflictFail sends the insert without an ON CONFLICT clause, so the
+	// unique violation aborts the statement (and the transaction it runs
+	// in) and is reported as ErrDuplicate.
+	ConflictFail ConflictStrategy = "fail"
+)
+
+// Repository reads and writes users. Create, CreateMany, BulkCreate,
+// UpsertMany and Update check their records with Validate first and report a failure as
+// ErrInvalid without running any SQL.
+type Repository interface {
+	// Create inserts u and fills in its ID and timestamps. A user whose
+	// username or email is taken is reported as ErrDuplicate, or as
+	// ErrUpdated when the conflict strategy overwrote it.
+	Create(ctx context.Context, u *User) error
+	// CreateMany inserts us in one round trip and fills in the ID and
+	// timestamps of each inserted user. The returned slice holds one
+	// result per user: nil when inserted, ErrUpdated when an existing user
+	// was overwritten, an error wrapping ErrDuplicate when skipped, or the
+	// failure. The error is for the batch as a whole.
+	CreateMany(ctx context.Context, us []User) ([]error, error)
+	// BulkCreate loads us with COPY and returns how many were inserted and
+	// how many existing users were overwritten. Other users are skipped
+	// and not reported individually, and the IDs of us are not filled in.
+	BulkCreate(ctx context.Context, us []User) (inserted, updated int64, err error)
+	// UpsertMany inserts the users of us whose username is new and
+	// overwrites the email of the others, whatever the conflict strategy,
+	// and returns how many it inserted and overwrote. Users whose email is
+	// already the same are left alone and counted in neither. Where the
+	// database returns them, the ID and timestamps of the users written
+	// are filled in.
+	UpsertMany(ctx context.Context, us []User) (inserted, updated int64, err error)
+	// GetByID returns the user with the given id or ErrNotFound.
+	GetByID(ctx context.Context, id ID) (*User, error)
+	// GetByIDs returns the users with the given ids in one query, in no
+	// particular order. Ids that name no user are left out rather than
+	// reported, so batching loaders can fetch many keys at once.
+	GetByIDs(ctx context.Context, ids []ID) ([]User, error)
+	// GetByUsername returns the user with the given username or ErrNotFound.
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	// List returns one page of users, oldest first, and a cursor to the
+	// next page: set it as Page.After to continue. The cursor is "" on the
+	// last page and when Page.Limit is zero.
+	List(ctx context.Context, page Page) ([]User, string, error)
+	// ForEachUser calls fn for every user, oldest first, reading the rows
+	// as fn consumes them rather than collecting them first. It stops at
+	// the first error fn returns and returns that error. A connection is
+	// held for the whole scan, so fn must not use a repository that runs
+	// on a single connection or transaction shared with this one.
+	ForEachUser(ctx context.Context, fn func(User) error) error
+	// Count returns the number of users matching f.
+	Count(ctx context.Context, f Filter) (int64, error)
+	// Update writes u.Email for the user named u.Username and sets
+	// u.Version and u.UpdatedAt to their new values. When u.Version is
+	// non-zero the write only applies if the stored version still equals
+	// it, and likewise for a non-zero u.UpdatedAt; otherwise ErrStaleRecord
+	// is returned and nothing changes.
+	Update(ctx context.Context, u *User) error
+	// Delete marks the user with the given username as deleted, or
+	// returns ErrNotFound. The row stays, with its username and email
+	// taken, until Purge removes it; ConflictUpsert restores it instead.
+	Delete(ctx context.Context, username string) error
+	// Purge removes for good the users deleted more than olderThan ago and
+	// returns how many there were.
+	Purge(ctx context.Context, olderThan time.Duration) (int64, error)
+}
+
+// Page selects a window of an ordered listing. A zero Limit means no limit.
+type Page struct {
+	Limit  int
+	Offset int
+	// After is a cursor returned by List. The page then starts after the
+	// last user of the page that returned it, which unlike an Offset costs
+	// the same on any page and is not thrown off by concurrent inserts.
+	// Only List supports it, and it cannot be combined with Offset.
+	After string
+	// Filter limits the listing to matching users.
+	Filter Filter
+}
+
+// Options configures a PostgresRepository.
+type Options struct {
+	// Schema holds the users table; "public" when empty. The static
+	// queries generated by sqlc in package usersdb name their tables
+	// without a schema, so the sessions of the Querier must resolve them in
+	// Schema: the program sets search_path on every session it opens, and
+	// other callers can put search_path in the connection string.
+	Schema string
+	// PrecheckDuplicates makes Create look for an existing user first, so a
+	// clash on either username or email is reported by field name. It only
+	// applies when OnConflict is ConflictSkip and OnEmailConflict is not
+	// ConflictUpsert.
+	PrecheckDuplicates bool
+	// OnConflict handles a taken username; ConflictSkip when empty.
+	OnConflict ConflictStrategy
+	// OnEmailConflict handles, in Create and CreateMany, an email that
+	// another user holds; ConflictFail when empty. ConflictSkip reports it
+	// as ErrDuplicateEmail without aborting the enclosing transaction, and
+	// ConflictUpsert renames the user holding the email to the new
+	// username and reports ErrUpdated. BulkCreate and UpsertMany do not
+	// apply it.
+	OnEmailConflict ConflictStrategy
+	// CockroachDB avoids the PostgreSQL features CockroachDB lacks: the
+	// xmax system column and temporary tables (see insertedExpr and
+	// bulkInput).
+	CockroachDB bool
+	// BcryptCost is the bcrypt work factor of new password hashes;
+	// bcrypt.DefaultCost when zero.
+	BcryptCost int
+}
+
+// Querier is the subset of pgx the repository needs. *pgxpool.Pool,
+// *pgx.Conn and pgx.Tx all satisfy it, so the repository can run inside a
+// transaction, and tests can substitute a mock such as pgxmock.
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+var (
+	_ Querier = (*pgxpool.Pool)(nil)
+	_ Querier = (*pgx.Conn)(nil)
+	_ Querier = pgx.Tx(nil)
+)
+
+// PostgresRepository is the Repository backed by PostgreSQL.
+type PostgresRepository struct {
+	db    Querier
+	q     *usersdb.Queries
+	table string
+	opts  Options
+}
+
+var _ Repository = (*PostgresRepository)(nil)
+
+// NewRepository returns a repository for the users table in opts.Schema
+// that runs its statements on db.
+func NewRepository(db Querier, opts Options) *PostgresRepository {
+	if opts.Schema == "" {
+		opts.Schema = "public"
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+	}
+	if opts.OnEmailConflict == "" {
+		opts.OnEmailConflict = ConflictFail
+	}
+	return &PostgresRepository{
+		db:    db,
+		q:     usersdb.New(db),
+		table: pgx.Identifier{opts.Schema, "users"}.Sanitize(),
+		opts:  opts,
+	}
+}
+
+// Table returns the quoted, schema-qualified name of the users table.
+func (r *PostgresRepository) Table() string {
+	return r.table
+}
+
+// Unwrap returns what the repository runs its statements on: the pool,
+// connection or transaction it was created with. Statements run on it
+// directly bypass the repository's validation, conflict handling and
+// soft-delete filter.
+func (r *PostgresRepository) Unwrap() Querier {
+	return r.db
+}
+
+// Raw returns a connection of the repository for custom queries with the
+// full pgx API, and the function that gives it back. Over a pool it
+// acquires a connection, which stays out of the pool until release is
+// called, so call it exactly once, typically deferred, and do not use the
+// connection or keep it anywhere afterwards: by then the pool may have
+// handed it to someone else. Leave the session as it was found; settings
+// made with SET, temporary tables and an open transaction would be met by
+// the next user of the connection. Over a connection or a transaction,
+// release does nothing, and statements on the connection of a transaction
+// run in that transaction.
+func (r *PostgresRepository) Raw(ctx context.Context) (conn *pgx.Conn, release func(), err error) {
+	switch q := r.db.(type) {
+	case *pgxpool.Pool:
+		c, err := q.Acquire(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.Conn(), c.Release, nil
+	case *pgxpool.Conn:
+		return q.Conn(), func() {}, nil
+	case *pgx.Conn:
+		return q, func() {}, nil
+	case pgx.Tx:
+		return q.Conn(), func() {}, nil
+	}
+	return nil, nil, fmt.Errorf("no pgx connection under a %T; use Unwrap", r.db)
+}
+
+// columns lists the columns scanned into User, in struct order.
+const columns = "id, username, email, created_at, updated_at, version, source, deleted_at"
+
+// Create inserts u, handling a taken username as Options.OnConflict says;
+// see insertSQL. Unless Options.OnEmailConflict is ConflictFail, the insert
+// runs in a savepoint, and a unique violation on the email is handled as
+// it says; see takeEmail.
+//
+// With PrecheckDuplicates it first looks for an existing user with the same
+// username or email and returns an error wrapping ErrDuplicate that names
+// the clashing field. The check costs an extra round trip and is inherently
+// racy (another session can insert between the SELECT and the INSERT), so
+// ON CONFLICT stays in place as the race-safe backstop.
+func (r *PostgresRepository) Create(ctx context.Context, u *User) error {
+	if err := Validate(*u); err != nil {
+		return err
+	}
+	if r.precheck() {
+		var field string
+		err := r.db.QueryRow(ctx, `SELECT CASE WHEN username = $1 THEN 'username' ELSE 'email' END
+			FROM `+r.table+` WHERE username = $1 OR email = $2 LIMIT 1`, u.Username, u.Email).Scan(&field)
+		switch {
+		case err == nil:
+			value := u.Username
+			if field == "email" {
+				value = u.Email
+			}
+			return fmt.Errorf("%w: %s %q is already taken", duplicateKind(field), field, value)
+		case !errors.Is(err, pgx.ErrNoRows):
+			return pgError(err)
+		}
+	}
+
+	sql, args := r.InsertStatement(*u)
+	if r.opts.OnEmailConflict == ConflictFail {
+		return r.insertResult(u, r.db.QueryRow(ctx, sql, args...))
+	}
+	err := r.savepoint(ctx, func(tx Querier) error {
+		return r.insertResult(u, tx.QueryRow(ctx, sql, args...))
+	})
+	switch {
+	case !errors.Is(err, ErrDuplicateEmail):
+		return err
+	case r.opts.OnEmailConflict == ConflictUpsert:
+		return r.savepoint(ctx, func(tx Querier) error { return r.takeEmail(ctx, tx, u) })
+	}
+	return fmt.Errorf("%w: email %q is already taken", ErrDuplicateEmail, u.Email)
+}
+
+// savepoint runs fn in a nested db.WithTx, which is a savepoint when r.db
+// is a transaction already, so a statement of fn that fails does not abort
+// the caller's transaction, and returns the outcome of fn. ErrUpdated is
+// kept like an insert.
+func (r *PostgresRepository) savepoint(ctx context.Context, fn func(tx Querier) error) error {
+	var outcome error
+	err := db.WithTx(ctx, r.db, func(tx pgx.Tx) error {
+		outcome = fn(tx)
+		if errors.Is(outcome, ErrUpdated) {
+			return nil
+		}
+		return outcome
+	})
+	// Anything but fn's own error means the savepoint failed
+	if err != nil && err != outcome {
+		return pgError(err)
+	}
+	return outcome
+}
+
+// takeEmail is ConflictUpsert of Options.OnEmailConflict: when inserting u
+// failed because another user holds u.Email, it gives that user u.Username
+// instead, restoring it if it was soft-deleted. The rename fails as a
+// duplicate username when u.Username is taken as well.
+func (r *PostgresRepository) takeEmail(ctx context.Context, q Querier, u *User) error {
+	err := q.QueryRow(ctx, `UPDATE `+r.table+` SET username = $1, updated_at = clock_timestamp(), version = version + 1, deleted_at = NULL
+		WHERE email = $2 AND (username <> $1 OR deleted_at IS NOT NULL)
+		RETURNING id, created_at, updated_at, version, source`, u.Username, u.Email).
+		Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("%w: email %q already belongs to %q", ErrDuplicateEmail, u.Email, u.Username)
+	case err != nil:
+		return pgError(err)
+	}
+	return ErrUpdated
+}
+
+// InsertStatement returns the statement and arguments Create and CreateMany
+// send to insert u, without running them, for callers that show what
+// would be written. It does not include the duplicate check of
+// PrecheckDuplicates, which only reads, nor the savepoint and rename of
+// OnEmailConflict.
+func (r *PostgresRepository) InsertStatement(u User) (string, []any) {
+	return r.insertSQL(), []any{u.Username, u.Email, u.Source}
+}
+
+// precheck reports whether Create should look for duplicates first.
+func (r *PostgresRepository) precheck() bool {
+	return r.opts.PrecheckDuplicates && r.opts.OnConflict == ConflictSkip && r.opts.OnEmailConflict != ConflictUpsert
+}
+
+// insertSQL inserts one user; $3 is the provenance label (NULL when
+// unknown). The ON CONFLICT clause depends on the strategy:
+//
+//   - skip: DO NOTHING, so no row comes back for a taken username.
+//   - upsert: DO UPDATE the email, unless it is already the same, in which
+//     case no row comes back either. insertedExpr tells an insert from an
+//     update. A soft-deleted user is restored, whatever its email.
+//   - fail: no clause, so the unique violation becomes an error.
+func (r *PostgresRepository) insertSQL() string {
+	var onConflict string
+	switch r.opts.OnConflict {
+	case ConflictUpsert:
+		onConflict = `ON CONFLICT (username) DO UPDATE
+	               SET email = EXCLUDED.email, updated_at = clock_timestamp(), version = ` + r.table + `.version + 1, deleted_at = NULL
+	               WHERE ` + r.table + `.email IS DISTINCT FROM EXCLUDED.email OR ` + r.table + `.deleted_at IS NOT NULL`
+	case ConflictFail:
+	default:
+		onConflict = `ON CONFLICT (username) DO NOTHING`
+	}
+	return `INSERT INTO ` + r.table + ` (username, email, source)
+	               VALUES ($1, $2, $3)
+	               ` + onConflict + `
+	               RETURNING id, created_at, updated_at, version, source, ` + r.insertedExpr()
+}
+
+// insertedExpr is true in a RETURNING list for a row the statement inserted
+// rather than updated. In PostgreSQL xmax is only zero for a freshly
+// inserted row version. CockroachDB has no xmax; there an inserted row has
+// created_at = updated_at, both defaulting to the transaction timestamp,
+// while an update sets updated_at to the later clock_timestamp().
+func (r *PostgresRepository) insertedExpr() string {
+	if r.opts.CockroachDB {
+		return "created_at = updated_at"
+	}
+	return "xmax = 0"
+}
+
+// insertResult scans the row returned by insertSQL into u. An upsert keeps
+// the existing user's source, so that is scanned back as well.
+func (r *PostgresRepository) insertResult(u *User, row pgx.Row) error {
+	var inserted bool
+	err := row.Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source, &inserted)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows) && r.opts.OnConflict == ConflictUpsert:
+		return fmt.Errorf("%w: username %q already has email %q", ErrDuplicateUsername, u.Username, u.Email)
+	case errors.Is(err, pgx.ErrNoRows):
+		return fmt.Errorf("%w: username %q is already taken", ErrDuplicateUsername, u.Username)
+	case err != nil:
+		return pgError(err)
+	case !inserted:
+		return ErrUpdated
+	}
+	return nil
+}
+
+// CreateMany queues one insert per user in a pgx.Batch and sends them
+// together. Outside a transaction the batch runs in an implicit one, so a
+// failure other than a skipped duplicate username (an email clash, or any
+// duplicate with ConflictFail) rolls back the whole batch and every result
+// after it reports the abort.
+//
+// With PrecheckDuplicates, or an OnEmailConflict other than ConflictFail,
+// the users are created one by one instead, because the check and the
+// savepoint each need their own round trips per user.
+func (r *PostgresRepository) CreateMany(ctx context.Context, us []User) ([]error, error) {
+	results := make([]error, len(us))
+	if r.precheck() || r.opts.OnEmailConflict != ConflictFail {
+		for i := range us {
+			results[i] = r.Create(ctx, &us[i])
+		}
+		return results, nil
+	}
+	// Invalid users are reported without being sent
+	valid := make([]int, 0, len(us))
+	for i, u := range us {
+		if results[i] = Validate(u); results[i] == nil {
+			valid = append(valid, i)
+		}
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	b := &pgx.Batch{}
+	for _, i := range valid {
+		sql, args := r.InsertStatement(us[i])
+		b.Queue(sql, args...)
+	}
+	br := r.db.SendBatch(ctx, b)
+	for _, i := range valid {
+		results[i] = r.insertResult(&us[i], br.QueryRow())
+	}
+	return results, pgError(br.Close())
+}
+
+// GetByID returns the user with the given id. An id of the wrong kind for
+// the table, such as a UUID when ids are integers, matches no user.
+func (r *PostgresRepository) GetByID(ctx context.Context, id ID) (*User, error) {
+	u, err := r.getOne(ctx, "id = $1", string(id))
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+		return nil, ErrNotFound
+	}
+	return u, err
+}
+
+// GetByIDs returns the users with the given ids. A table has integer or
+// UUID ids, and comparing the id column with an id of the other kind is an
+// error, so each kind is asked for in a query of its own, and one that
+// does not fit the table matches no user as in GetByID.
+func (r *PostgresRepository) GetByIDs(ctx context.Context, ids []ID) ([]User, error) {
+	var integers, uuids []ID
+	for _, id := range ids {
+		if id.isInteger() {
+			integers = append(integers, id)
+		} else {
+			uuids = append(uuids, id)
+		}
+	}
+	var found []User
+	for _, kind := range [][]ID{integers, uuids} {
+		if len(kind) == 0 {
+			continue
+		}
+		where := newWhereBuilder(pgPlaceholder)
+		where.addIDs(kind)
+		where.add(NotDeleted)
+		records, err := db.Select[User](ctx, r.db, "SELECT "+columns+" FROM "+r.table+where.clause(), where.args...)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "22P02" {
+			continue
+		}
+		if err != nil {
+			return nil, pgError(err)
+		}
+		found = append(found, records...)
+	}
+	return found, nil
+}
+
+// GetByUsername returns the user with the given username.
+func (r *PostgresRepository) GetByUsername(ctx context.Context, username string) (*User, error) {
+	return r.getOne(ctx, "username = $1", username)
+}
+
+// getOne returns the single user matching where, translating pgx.ErrNoRows
+// into ErrNotFound so callers need not know about pgx.
+func (r *PostgresRepository) getOne(ctx context.Context, where string, arg any) (*User, error) {
+	u, err := db.Get[User](ctx, r.db, "SELECT "+columns+" FROM "+r.table+" WHERE "+where+" AND "+NotDeleted, arg)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return u, pgError(err)
+}
+
+// List returns the users in page, ordered by creation time. The id breaks
+// ties so pages stay stable when several users share a created_at. A page
+// with a cursor starts right after the row the cursor names, which the
+// (created_at, id) index finds directly however deep into the table it is.
+func (r *PostgresRepository) List(ctx context.Context, page Page) ([]User, string, error) {
+	if err := checkPage(page); err != nil {
+		return nil, "", err
+	}
+	// LIMIT NULL means no limit; one extra row tells whether a next page exists
+	var limit *int
+	if page.Limit > 0 {
+		n := page.Limit + 1
+		limit = &n
+	}
+	where := newWhereBuilder(pgPlaceholder, limit, page.Offset)
+	if page.After != "" {
+		c, err := parseCursor(page.After)
+		if err != nil {
+			return nil, "", err
+		}
+		where.add("(created_at, id) > (?, ?)", c.CreatedAt, string(c.ID))
+	}
+	where.addFilter(page.Filter, pgTime)
+	records, err := db.Select[User](ctx, r.db, "SELECT "+columns+" FROM "+r.table+where.clause()+" ORDER BY created_at, id LIMIT $1 OFFSET $2", where.args...)
+	if err != nil {
+		return nil, "", pgError(err)
+	}
+	records, next := nextCursor(records, page)
+	return records, next, nil
+}
+
+// Count returns the number of rows in the users table matching f.
+func (r *PostgresRepository) Count(ctx context.Context, f Filter) (int64, error) {
+	if err := checkFilter(f); err != nil {
+		return 0, err
+	}
+	where := newWhereBuilder(pgPlaceholder)
+	where.addFilter(f, pgTime)
+	var n int64
+	err := r.db.QueryRow(ctx, "SELECT count(*) FROM "+r.table+where.clause(), where.args...).Scan(&n)
+	return n, pgError(err)
+}
+
+// pgTime passes times to pgx as they are.
+func pgTime(t time.Time) any {
+	return t
+}
+
+// BulkCreate copies us into a temporary staging table and moves them into
+// the users table with a single INSERT ... SELECT. COPY is far faster than
+// row-by-row inserts but cannot handle conflicts itself, hence the staging
+// step. It all runs in one transaction (a savepoint when the repository is
+// already on one), which also scopes the staging table. On CockroachDB the
+// rows are sent as arrays instead; see bulkInput.
+//
+// With ConflictSkip, ON CONFLICT DO NOTHING skips a clash on any unique
+// column, including duplicates within us. With ConflictUpsert only the last
+// occurrence of each username is kept, because DO UPDATE cannot touch the
+// same row twice in one statement; an email clash fails the whole load. With
+// ConflictFail any duplicate does.
+func (r *PostgresRepository) BulkCreate(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	if err := validateAll(us); err != nil {
+		return 0, 0, err
+	}
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	input, args, err := r.bulkInput(ctx, tx, us)
+	if err != nil {
+		return 0, 0, err
+	}
+	insert := `INSERT INTO ` + r.table + ` (username, email, source)
+		SELECT username, email, source FROM ` + input + ` ORDER BY ord`
+	switch r.opts.OnConflict {
+	case ConflictUpsert:
+		insert = `INSERT INTO ` + r.table + ` (username, email, source)
+		SELECT DISTINCT ON (username) username, email, source FROM ` + input + ` ORDER BY username, ord DESC
+		ON CONFLICT (username) DO UPDATE
+		SET email = EXCLUDED.email, updated_at = clock_timestamp(), version = ` + r.table + `.version + 1, deleted_at = NULL
+		WHERE ` + r.table + `.email IS DISTINCT FROM EXCLUDED.email OR ` + r.table + `.deleted_at IS NOT NULL`
+	case ConflictFail:
+	default:
+		insert += ` ON CONFLICT DO NOTHING`
+	}
+	err = tx.QueryRow(ctx, `WITH affected AS (`+insert+` RETURNING `+r.insertedExpr()+` AS inserted)
+		SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM affected`, args...).Scan(&inserted, &updated)
+	if err != nil {
+		return 0, 0, pgError(err)
+	}
+	return inserted, updated, tx.Commit(ctx)
+}
+
+// UpsertMany sends us as three arrays, one per column, and upserts them
+// all with a single INSERT ... SELECT FROM unnest(...) ON CONFLICT DO
+// UPDATE: one statement and one round trip whatever the number of users,
+// with no staging table, so it suits batches of thousands that need
+// upsert semantics and their ids back.
+//
+// A username given twice keeps its last occurrence, because DO UPDATE
+// cannot touch the same row twice in one statement, and every occurrence
+// is filled in from the row written. An email taken by another user fails
+// the whole statement with ErrDuplicateEmail.
+func (r *PostgresRepository) UpsertMany(ctx context.Context, us []User) (inserted, updated int64, err error) {
+	if err := validateAll(us); err != nil {
+		return 0, 0, err
+	}
+	if len(us) == 0 {
+		return 0, 0, nil
+	}
+	usernames, emails, sources := make([]string, len(us)), make([]string, len(us)), make([]*string, len(us))
+	for i, u := range us {
+		usernames[i], emails[i], sources[i] = u.Username, u.Email, u.Source
+	}
+	rows, err := r.db.Query(ctx, `INSERT INTO `+r.table+` (username, email, source)
+		SELECT DISTINCT ON (username) username, email, source
+		FROM unnest($1::text[], $2::text[], $3::text[]) WITH ORDINALITY AS input (username, email, source, ord)
+		ORDER BY username, ord DESC
+		ON CONFLICT (username) DO UPDATE
+		SET email = EXCLUDED.email, updated_at = clock_timestamp(), version = `+r.table+`.version + 1, deleted_at = NULL
+		WHERE `+r.table+`.email IS DISTINCT FROM EXCLUDED.email OR `+r.table+`.deleted_at IS NOT NULL
+		RETURNING id, username, created_at, updated_at, version, source, `+r.insertedExpr(), usernames, emails, sources)
+	if err != nil {
+		return 0, 0, pgError(err)
+	}
+	written := map[string]User{}
+	var u User
+	var isInsert bool
+	_, err = pgx.ForEachRow(rows, []any{&u.ID, &u.Username, &u.CreatedAt, &u.UpdatedAt, &u.Version, &u.Source, &isInsert}, func() error {
+		written[u.Username] = u
+		if isInsert {
+			inserted++
+		} else {
+			updated++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, pgError(err)
+	}
+	for i := range us {
+		if w, ok := written[us[i].Username]; ok {
+			us[i].ID, us[i].CreatedAt, us[i].UpdatedAt, us[i].Version, us[i].Source = w.ID, w.CreatedAt, w.UpdatedAt, w.Version, w.Source
+		}
+	}
+	return inserted, updated, nil
+}
+
+// bulkInput makes us readable by BulkCreate's INSERT ... SELECT as rows of
+// (ord, username, email, source), returning the FROM item that reads them
+// and the arguments it takes.
+//
+// PostgreSQL gets the rows through COPY into a temporary staging table.
+// CockroachDB's temporary tables are experimental and lack ON COMMIT DELETE
+// ROWS, so there the columns are sent as three array parameters and
+// expanded with unnest; slower for large loads, but a single round trip.
+func (r *PostgresRepository) bulkInput(ctx context.Context, tx pgx.Tx, us []User) (string, []any, error) {
+	if r.opts.CockroachDB {
+		usernames, emails, sources := make([]string, len(us)), make([]string, len(us)), make([]*string, len(us))
+		for i, u := range us {
+			usernames[i], emails[i], sources[i] = u.Username, u.Email, u.Source
+		}
+		return `unnest($1::text[], $2::text[], $3::text[]) WITH ORDINALITY AS input (username, email, source, ord)`,
+			[]any{usernames, emails, sources}, nil
+	}
+
+	if _, err := tx.Exec(ctx, `CREATE TEMPORARY TABLE IF NOT EXISTS users_staging
+		(ord INT, username TEXT, email TEXT, source TEXT) ON COMMIT DELETE ROWS`); err != nil {
+		return "", nil, fmt.Errorf("creating staging table: %w", err)
+	}
+	// The temporary table outlives a savepoint, so clear what an earlier
+	// call in the same transaction may have left
+	if _, err := tx.Exec(ctx, "TRUNCATE users_staging"); err != nil {
+		return "", nil, err
+	}
+	_, err := tx.CopyFrom(ctx, pgx.Identifier{"users_staging"}, []string{"ord", "username", "email", "source"},
+		pgx.CopyFromSlice(len(us), func(i int) ([]any, error) {
+			return []any{i, us[i].Username, us[i].Email, us[i].Source}, nil
+		}))
+	if err != nil {
+		return "", nil, fmt.Errorf("copying into staging table: %w", err)
+	}
+	return "users_staging", nil, nil
+}
+
+// Update changes the user's email; see Repository.Update for the
+// optimistic-concurrency contract.
+func (r *PostgresRepository) Update(ctx context.Context, u *User) error {
+	if err := ValidateEmail(u.Email); err != nil {
+		return err
+	}
+	version, updatedAt := expectedVersion(u)
+	err := r.db.QueryRow(ctx, `UPDATE `+r.table+`
+		SET email = $2, updated_at = clock_timestamp(), version = version + 1
+		WHERE username = $1 AND `+NotDeleted+` AND ($3::bigint IS NULL OR version = $3) AND ($4::timestamptz IS NULL OR updated_at = $4)
+		RETURNING updated_at, version`, u.Username, u.Email, version, updatedAt).Scan(&u.UpdatedAt, &u.Version)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return pgError(err)
+	}
+
+	// No row matched: tell a missing user apart from a stale version
+	exists, err := r.exists(ctx, u.Username)
+	if err != nil {
+		return pgError(err)
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return ErrStaleRecord
+}
+
+// expectedVersion returns the version and modification time Update must
+// find, each nil when u leaves it zero and it is not checked.
+func expectedVersion(u *User) (version *int64, updatedAt *time.Time) {
+	if u.Version != 0 {
+		version = &u.Version
+	}
+	if !u.UpdatedAt.IsZero() {
+		updatedAt = &u.UpdatedAt
+	}
+	return version, updatedAt
+}
+
+// Delete marks the user with the given username as deleted. Like any
+// change, it bumps updated_at and version.
+func (r *PostgresRepository) Delete(ctx context.Context, username string) error {
+	n, err := r.q.SoftDeleteUser(ctx, username)
+	if err != nil {
+		return pgError(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Purge deletes the rows Delete marked more than olderThan ago. A row
+// another table still refers to fails the whole purge with
+// ErrForeignKeyViolation.
+func (r *PostgresRepository) Purge(ctx context.Context, olderThan time.Duration) (int64, error) {
+	n, err := r.q.PurgeDeletedUsers(ctx, olderThan.Microseconds())
+	return n, pgError(err)
+}
+
+func (r *PostgresRepository) exists(ctx context.Context, username string) (bool, error) {
+	return r.q.UserExists(ctx, username)
+}
@@ -0,0 +1,274 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/pashagolub/pgxmock/v4"
+)
+
+// insertColumns are the columns insertSQL returns.
+var insertColumns = []string{"id", "created_at", "updated_at", "version", "source", "inserted"}
+
+func TestCreateConflicts(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	inserted := func(m pgxmock.PgxPoolIface) *pgxmock.Rows {
+		return m.NewRows(insertColumns).AddRow("7", now, now, int64(1), nil, true)
+	}
+	// insert expects the insert of alice, whose source is unknown
+	insert := func(m pgxmock.PgxPoolIface, pattern string) *pgxmock.ExpectedQuery {
+		return m.ExpectQuery(pattern).WithArgs("alice", "alice@example.com", (*string)(nil))
+	}
+	duplicate := func(constraint string) error {
+		return &pgconn.PgError{Code: "23505", ConstraintName: constraint, Detail: "Key already exists."}
+	}
+
+	tests := []struct {
+		name   string
+		opts   Options
+		expect func(m pgxmock.PgxPoolIface)
+		want   error // nil when the user is inserted
+	}{
+		{
+			name: "inserted",
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `INSERT INTO "public"."users" .* ON CONFLICT \(username\) DO NOTHING`).WillReturnRows(inserted(m))
+			},
+		},
+		{
+			name: "skip taken username",
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `ON CONFLICT \(username\) DO NOTHING`).WillReturnRows(m.NewRows(insertColumns))
+			},
+			want: ErrDuplicateUsername,
+		},
+		{
+			name: "upsert taken username",
+			opts: Options{OnConflict: ConflictUpsert},
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `ON CONFLICT \(username\) DO UPDATE`).
+					WillReturnRows(m.NewRows(insertColumns).AddRow("7", now, now, int64(2), nil, false))
+			},
+			want: ErrUpdated,
+		},
+		{
+			name: "upsert same email",
+			opts: Options{OnConflict: ConflictUpsert},
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `ON CONFLICT \(username\) DO UPDATE`).WillReturnRows(m.NewRows(insertColumns))
+			},
+			want: ErrDuplicateUsername,
+		},
+		{
+			name: "fail on taken username",
+			opts: Options{OnConflict: ConflictFail},
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `VALUES \(\$1, \$2, \$3\)\s+RETURNING`).WillReturnError(duplicate("users_username_key"))
+			},
+			want: ErrDuplicateUsername,
+		},
+		{
+			name: "fail on taken email",
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `INSERT INTO`).WillReturnError(duplicate("users_email_key"))
+			},
+			want: ErrDuplicateEmail,
+		},
+		{
+			name: "skip taken email in a savepoint",
+			opts: Options{OnEmailConflict: ConflictSkip},
+			expect: func(m pgxmock.PgxPoolIface) {
+				m.ExpectBegin()
+				insert(m, `INSERT INTO`).WillReturnError(duplicate("users_email_key"))
+				m.ExpectRollback()
+			},
+			want: ErrDuplicateEmail,
+		},
+		{
+			name: "take over a taken email",
+			opts: Options{OnEmailConflict: ConflictUpsert},
+			expect: func(m pgxmock.PgxPoolIface) {
+				m.ExpectBegin()
+				insert(m, `INSERT INTO`).WillReturnError(duplicate("users_email_key"))
+				m.ExpectRollback()
+				m.ExpectBegin()
+				m.ExpectQuery(`UPDATE "public"."users" SET username = \$1`).WithArgs("alice", "alice@example.com").
+					WillReturnRows(m.NewRows(insertColumns[:5]).AddRow("3", now, now, int64(4), nil))
+				m.ExpectCommit()
+			},
+			want: ErrUpdated,
+		},
+		{
+			name: "email taken by the same username",
+			opts: Options{OnEmailConflict: ConflictUpsert},
+			expect: func(m pgxmock.PgxPoolIface) {
+				m.ExpectBegin()
+				insert(m, `INSERT INTO`).WillReturnError(duplicate("users_email_key"))
+				m.ExpectRollback()
+				m.ExpectBegin()
+				m.ExpectQuery(`UPDATE "public"."users" SET username = \$1`).WithArgs("alice", "alice@example.com").WillReturnRows(m.NewRows(insertColumns[:5]))
+				m.ExpectRollback()
+			},
+			want: ErrDuplicateEmail,
+		},
+		{
+			name: "precheck finds the email",
+			opts: Options{PrecheckDuplicates: true},
+			expect: func(m pgxmock.PgxPoolIface) {
+				m.ExpectQuery(`SELECT CASE WHEN username = \$1`).WithArgs("alice", "alice@example.com").
+					WillReturnRows(m.NewRows([]string{"field"}).AddRow("email"))
+			},
+			want: ErrDuplicateEmail,
+		},
+		{
+			name: "connection lost",
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `INSERT INTO`).WillReturnError(&pgconn.PgError{Code: "08006", Message: "connection failure"})
+			},
+			want: ErrConnectionFailed,
+		},
+		{
+			name: "read-only server",
+			expect: func(m pgxmock.PgxPoolIface) {
+				insert(m, `INSERT INTO`).WillReturnError(&pgconn.PgError{Code: "25006", Message: "cannot execute INSERT in a read-only transaction"})
+			},
+			want: ErrReadOnly,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mock.Close()
+			tt.expect(mock)
+
+			u := User{Username: "alice", Email: "alice@example.com"}
+			err = NewRepository(mock, tt.opts).Create(context.Background(), &u)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Create = %v, want %v", err, tt.want)
+			}
+			if (err == nil || errors.Is(err, ErrUpdated)) && (u.ID == "" || u.Version == 0) {
+				t.Errorf("created user = %+v, want its ID and version filled in", u)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCreateInvalidSendsNothing(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	err = NewRepository(mock, Options{}).Create(context.Background(), &User{Username: "alice", Email: "not an email"})
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("Create of an invalid user = %v, want ErrInvalid", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	updateColumns := []string{"updated_at", "version"}
+	// update expects the write of alice's new email
+	update := func(m pgxmock.PgxPoolIface) *pgxmock.ExpectedQuery {
+		return m.ExpectQuery(`UPDATE "public"."users"`).WithArgs("alice", "alice@example.org", pgxmock.AnyArg(), (*time.Time)(nil))
+	}
+	tests := []struct {
+		name   string
+		expect func(m pgxmock.PgxPoolIface)
+		want   error
+	}{
+		{
+			name: "stale version",
+			expect: func(m pgxmock.PgxPoolIface) {
+				update(m).WillReturnRows(m.NewRows(updateColumns))
+				m.ExpectQuery(`SELECT EXISTS`).WithArgs("alice").WillReturnRows(m.NewRows([]string{"exists"}).AddRow(true))
+			},
+			want: ErrStaleRecord,
+		},
+		{
+			name: "missing user",
+			expect: func(m pgxmock.PgxPoolIface) {
+				update(m).WillReturnRows(m.NewRows(updateColumns))
+				m.ExpectQuery(`SELECT EXISTS`).WithArgs("alice").WillReturnRows(m.NewRows([]string{"exists"}).AddRow(false))
+			},
+			want: ErrNotFound,
+		},
+		{
+			name: "taken email",
+			expect: func(m pgxmock.PgxPoolIface) {
+				update(m).WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "users_email_key"})
+			},
+			want: ErrDuplicateEmail,
+		},
+		{
+			name: "connection lost while telling them apart",
+			expect: func(m pgxmock.PgxPoolIface) {
+				update(m).WillReturnRows(m.NewRows(updateColumns))
+				m.ExpectQuery(`SELECT EXISTS`).WithArgs("alice").WillReturnError(&pgconn.PgError{Code: "57P01"})
+			},
+			want: ErrConnectionFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, err := pgxmock.NewPool()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mock.Close()
+			tt.expect(mock)
+
+			u := User{Username: "alice", Email: "alice@example.org", Version: 3}
+			if err := NewRepository(mock, Options{}).Update(context.Background(), &u); !errors.Is(err, tt.want) {
+				t.Errorf("Update = %v, want %v", err, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDeleteAndPurgeErrors(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	ctx := context.Background()
+	repo := NewRepository(mock, Options{Schema: "tenant_a"})
+
+	// Delete and Purge run the usersdb queries, which leave the schema to
+	// the session's search_path
+	mock.ExpectExec(`UPDATE users\s+SET deleted_at`).WithArgs("nobody").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	if err := repo.Delete(ctx, "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Delete of a missing user = %v, want ErrNotFound", err)
+	}
+
+	mock.ExpectExec(`DELETE FROM users\s+WHERE deleted_at`).WithArgs(time.Hour.Microseconds()).
+		WillReturnError(&pgconn.PgError{Code: "23503", Detail: `Key (id)=(7) is still referenced from table "posts".`})
+	if n, err := repo.Purge(ctx, time.Hour); n != 0 || !errors.Is(err, ErrForeignKeyViolation) {
+		t.Errorf("Purge of a referenced user = %d, %v, want ErrForeignKeyViolation", n, err)
+	}
+
+	mock.ExpectQuery(`SELECT .* FROM "tenant_a"."users" WHERE username = \$1`).WithArgs("nobody").
+		WillReturnRows(mock.NewRows([]string{"id", "username", "email", "created_at", "updated_at", "version", "source", "deleted_at"}))
+	if _, err := repo.GetByUsername(ctx, "nobody"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetByUsername of a missing user = %v, want ErrNotFound", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
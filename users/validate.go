@@ -0,0 +1,86 @@
+package users
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// usernamePattern is the charset of a username: ASCII letters, digits, '.',
+// '_' and '-', starting with a letter or digit.
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// validate checks the validate tags of User. Fields are reported by their
+// JSON name, which is also the column name.
+var validate = func() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		return name
+	})
+	v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return usernamePattern.MatchString(fl.Field().String())
+	})
+	return v
+}()
+
+// Validate checks a record against the validate tags of User: both fields
+// are required and within the column limits of the users table, the
+// username keeps to usernamePattern and the email is an RFC 5322 address.
+// The repositories call it before any SQL runs, so bad input is reported
+// clearly as ErrInvalid instead of surfacing as a constraint violation.
+func Validate(u User) error {
+	return validationError(validate.Struct(u))
+}
+
+// ValidateEmail checks an email as Validate does, for updates that change
+// nothing else.
+func ValidateEmail(email string) error {
+	return validationError(validate.Var(email, "required,max=100,email"))
+}
+
+// validateAll validates every record of a batch that is written as a
+// whole, reporting the first invalid one by position.
+func validateAll(us []User) error {
+	for i, u := range us {
+		if err := Validate(u); err != nil {
+			return fmt.Errorf("user %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// validationError turns the first failure reported by the validator into
+// an error wrapping ErrInvalid.
+func validationError(err error) error {
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+	fe := fieldErrs[0]
+	field := fe.Field()
+	if field == "" {
+		field = "email" // validate.Var has no field name
+	}
+	switch fe.Tag() {
+	case "required":
+		return fmt.Errorf("%w: %s is empty", ErrInvalid, field)
+	case "max":
+		return fmt.Errorf("%w: %s %q is longer than %s characters", ErrInvalid, field, fe.Value(), fe.Param())
+	case "email":
+		return fmt.Errorf("%w: email %q is not a valid address", ErrInvalid, fe.Value())
+	case "username":
+		return fmt.Errorf("%w: username %q may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit", ErrInvalid, fe.Value())
+	}
+	return fmt.Errorf("%w: %s fails %q", ErrInvalid, field, fe.Tag())
+}
+
+// ValidateUsername checks a username as Validate does, so it can be
+// checked on its own, as it is typed.
+func ValidateUsername(username string) error {
+	return validationError(validate.StructPartial(User{Username: username}, "Username"))
+}
@@ -0,0 +1,11 @@
+// Package usersdb holds the static PostgreSQL queries of the users
+// repository, generated by sqlc from queries.sql against the migrations.
+// The queries name their tables without a schema, so they run on sessions
+// whose search_path starts with the schema of the tables, as the sessions
+// the program opens do. After editing queries.sql or adding a migration,
+// regenerate the code with:
+//
+//	go generate ./usersdb
+package usersdb
+
+//go:generate go run github.com/sqlc-dev/sqlc/cmd/sqlc@v1.30.0 generate
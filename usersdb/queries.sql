@@ -0,0 +1,87 @@
+-- The static queries of users.PostgresRepository. Tables are named without
+-- a schema: the session's search_path picks DB_SCHEMA or the tenant's
+-- schema. Statements whose text depends on the configuration, such as the
+-- inserts with their ON CONFLICT clause, stay in the users package.
+
+-- name: SignupsPerDay :many
+-- SignupsPerDay returns the users who signed up each UTC day since since,
+-- or ever when since is NULL, soft-deleted users included; newest first.
+SELECT (created_at AT TIME ZONE 'UTC')::date AS day, count(*) AS signups
+FROM users
+WHERE sqlc.narg('since')::timestamptz IS NULL OR created_at >= sqlc.narg('since')
+GROUP BY 1
+ORDER BY 1 DESC;
+
+-- name: EmailDomains :many
+-- EmailDomains groups the users that are not deleted by the part of their
+-- email after the @, with the most users first.
+SELECT lower(split_part(email, '@', 2))::text AS domain, count(*) AS users,
+	round(100.0 * count(*) / sum(count(*)) OVER (), 1)::float8 AS share
+FROM users
+WHERE deleted_at IS NULL
+GROUP BY 1
+ORDER BY users DESC, domain
+LIMIT sqlc.narg('max_rows')::int;
+
+-- name: DuplicateEmails :many
+-- DuplicateEmails returns the emails that more than one user that is not
+-- deleted has when case is ignored, with their usernames, oldest first.
+SELECT lower(email)::text AS email, count(*) AS users,
+	array_agg(username ORDER BY created_at, id)::text[] AS usernames
+FROM users
+WHERE deleted_at IS NULL
+GROUP BY 1
+HAVING count(*) > 1
+ORDER BY users DESC, email
+LIMIT sqlc.narg('max_rows')::int;
+
+-- name: RefreshUserStats :exec
+REFRESH MATERIALIZED VIEW CONCURRENTLY user_stats;
+
+-- name: DailySignups :many
+-- DailySignups reads user_stats through its unique index on day, newest
+-- first.
+SELECT day, signups
+FROM user_stats
+ORDER BY day DESC
+LIMIT sqlc.narg('max_days')::int;
+
+-- name: SoftDeleteUser :execrows
+UPDATE users
+SET deleted_at = clock_timestamp(), updated_at = clock_timestamp(), version = version + 1
+WHERE username = sqlc.arg('username') AND deleted_at IS NULL;
+
+-- name: PurgeDeletedUsers :execrows
+-- PurgeDeletedUsers deletes the users soft-deleted more than older_than
+-- microseconds ago.
+DELETE FROM users
+WHERE deleted_at < now() - sqlc.arg('older_than')::bigint * interval '1 microsecond';
+
+-- name: UserExists :one
+SELECT EXISTS (
+	SELECT 1 FROM users WHERE username = sqlc.arg('username') AND deleted_at IS NULL
+);
+
+-- name: GetProfile :one
+SELECT profile
+FROM users
+WHERE username = sqlc.arg('username') AND deleted_at IS NULL;
+
+-- name: SetProfile :execrows
+UPDATE users
+SET profile = sqlc.arg('profile')::jsonb, updated_at = clock_timestamp(), version = version + 1
+WHERE username = sqlc.arg('username') AND deleted_at IS NULL;
+
+-- name: MergeProfile :one
+-- MergeProfile overwrites the top-level keys set in patch, in a single
+-- statement so concurrent merges of different keys do not overwrite each
+-- other.
+UPDATE users
+SET profile = profile || sqlc.arg('patch')::jsonb, updated_at = clock_timestamp(), version = version + 1
+WHERE username = sqlc.arg('username') AND deleted_at IS NULL
+RETURNING profile;
+
+-- name: SetPasswordHash :execrows
+UPDATE users
+SET password_hash = sqlc.arg('password_hash')::text, updated_at = clock_timestamp(), version = version + 1
+WHERE username = sqlc.arg('username') AND deleted_at IS NULL;
@@ -0,0 +1,9 @@
+// Package userspb is the gRPC API of the users table: the messages and
+// the UserService client and server generated from users.proto. After
+// editing users.proto, regenerate the code with protoc, protoc-gen-go and
+// protoc-gen-go-grpc on the PATH:
+//
+//	go generate ./userspb
+package userspb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative users.proto
@@ -0,0 +1,205 @@
+// Package vector adds the vector type of the pgvector extension to pgx.
+//
+// The extension's type gets an OID of its own in each database, so it is
+// looked up and registered per connection with Register. Values then travel
+// in pgvector's binary format: a 16-bit dimension count, 16 unused bits and
+// the elements as big-endian 32-bit floats. That is smaller than the text
+// form "[0.1,0.2,0.3]" and spares the server parsing decimals. The text
+// form is supported too, for the simple protocol.
+package vector
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// MaxDimensions is the most elements a pgvector vector may have.
+const MaxDimensions = 16000
+
+// Vector is a pgvector vector.
+type Vector []float32
+
+// Parse reads a vector written as comma-separated numbers, with or without
+// the enclosing brackets of pgvector's text form: "0.1,0.2" or "[0.1,0.2]".
+func Parse(s string) (Vector, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if strings.TrimSpace(s) == "" {
+		return nil, errors.New("vector: no elements")
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > MaxDimensions {
+		return nil, fmt.Errorf("vector: %d elements, more than the %d pgvector allows", len(parts), MaxDimensions)
+	}
+	v := make(Vector, len(parts))
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 32)
+		if err != nil {
+			return nil, fmt.Errorf("vector: element %d: %w", i+1, err)
+		}
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("vector: element %d is %v; pgvector stores finite numbers only", i+1, f)
+		}
+		v[i] = float32(f)
+	}
+	return v, nil
+}
+
+// String returns v in pgvector's text form.
+func (v Vector) String() string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, f := range v {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(f), 'g', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// Register looks up the OID of the vector type in the database of conn and
+// registers Codec for it, so Vector values can be sent and scanned. The
+// extension must be installed in the database.
+func Register(ctx context.Context, conn *pgx.Conn) error {
+	var oid uint32
+	err := conn.QueryRow(ctx, "SELECT to_regtype('vector')::oid").Scan(&oid)
+	if err != nil {
+		return err
+	}
+	if oid == 0 {
+		return errors.New("the vector type does not exist; install pgvector and run CREATE EXTENSION vector")
+	}
+	conn.TypeMap().RegisterType(&pgtype.Type{Name: "vector", OID: oid, Codec: Codec{}})
+	return nil
+}
+
+// Codec is the pgtype.Codec of Vector.
+type Codec struct{}
+
+func (Codec) FormatSupported(format int16) bool {
+	return format == pgtype.BinaryFormatCode || format == pgtype.TextFormatCode
+}
+
+func (Codec) PreferredFormat() int16 {
+	return pgtype.BinaryFormatCode
+}
+
+func (Codec) PlanEncode(m *pgtype.Map, oid uint32, format int16, value any) pgtype.EncodePlan {
+	if _, ok := value.(Vector); !ok {
+		return nil
+	}
+	if format == pgtype.BinaryFormatCode {
+		return encodeBinary{}
+	}
+	return encodeText{}
+}
+
+type encodeBinary struct{}
+
+func (encodeBinary) Encode(value any, buf []byte) ([]byte, error) {
+	v := value.(Vector)
+	if v == nil {
+		return nil, nil
+	}
+	if len(v) > MaxDimensions {
+		return nil, fmt.Errorf("vector: %d elements, more than the %d pgvector allows", len(v), MaxDimensions)
+	}
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(v)))
+	buf = binary.BigEndian.AppendUint16(buf, 0)
+	for _, f := range v {
+		buf = binary.BigEndian.AppendUint32(buf, math.Float32bits(f))
+	}
+	return buf, nil
+}
+
+type encodeText struct{}
+
+func (encodeText) Encode(value any, buf []byte) ([]byte, error) {
+	v := value.(Vector)
+	if v == nil {
+		return nil, nil
+	}
+	return append(buf, v.String()...), nil
+}
+
+func (Codec) PlanScan(m *pgtype.Map, oid uint32, format int16, target any) pgtype.ScanPlan {
+	if _, ok := target.(*Vector); !ok {
+		return nil
+	}
+	if format == pgtype.BinaryFormatCode {
+		return scanBinary{}
+	}
+	return scanText{}
+}
+
+type scanBinary struct{}
+
+func (scanBinary) Scan(src []byte, target any) error {
+	dst := target.(*Vector)
+	if src == nil {
+		*dst = nil
+		return nil
+	}
+	if len(src) < 4 {
+		return fmt.Errorf("vector: %d bytes is too short", len(src))
+	}
+	n := int(binary.BigEndian.Uint16(src))
+	if len(src) != 4+4*n {
+		return fmt.Errorf("vector: %d bytes for %d elements", len(src), n)
+	}
+	v := make(Vector, n)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.BigEndian.Uint32(src[4+4*i:]))
+	}
+	*dst = v
+	return nil
+}
+
+type scanText struct{}
+
+func (scanText) Scan(src []byte, target any) error {
+	dst := target.(*Vector)
+	if src == nil {
+		*dst = nil
+		return nil
+	}
+	v, err := Parse(string(src))
+	if err != nil {
+		return err
+	}
+	*dst = v
+	return nil
+}
+
+func (c Codec) DecodeDatabaseSQLValue(m *pgtype.Map, oid uint32, format int16, src []byte) (driver.Value, error) {
+	if src == nil {
+		return nil, nil
+	}
+	v, err := c.DecodeValue(m, oid, format, src)
+	if err != nil {
+		return nil, err
+	}
+	return v.(Vector).String(), nil
+}
+
+func (c Codec) DecodeValue(m *pgtype.Map, oid uint32, format int16, src []byte) (any, error) {
+	if src == nil {
+		return nil, nil
+	}
+	var v Vector
+	if err := c.PlanScan(m, oid, format, &v).Scan(src, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}